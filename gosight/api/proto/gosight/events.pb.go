@@ -117,6 +117,8 @@ type Event struct {
 	Type      EventType              `protobuf:"varint,2,opt,name=type,proto3,enum=gosight.EventType" json:"type,omitempty"`
 	Timestamp int64                  `protobuf:"varint,3,opt,name=timestamp,proto3" json:"timestamp,omitempty"` // Unix milliseconds
 	Page      *Page                  `protobuf:"bytes,4,opt,name=page,proto3" json:"page,omitempty"`
+	// Client key of the event: repeats within the ingestor's idempotency.window are dropped
+	IdempotencyKey string `protobuf:"bytes,5,opt,name=idempotency_key,json=idempotencyKey,proto3" json:"idempotency_key,omitempty"`
 	// Types that are valid to be assigned to Payload:
 	//
 	//	*Event_Click
@@ -190,6 +192,13 @@ func (x *Event) GetPage() *Page {
 	return nil
 }
 
+func (x *Event) GetIdempotencyKey() string {
+	if x != nil {
+		return x.IdempotencyKey
+	}
+	return ""
+}
+
 func (x *Event) GetPayload() isEvent_Payload {
 	if x != nil {
 		return x.Payload
@@ -957,6 +966,8 @@ type ReplayChunk struct {
 	TimestampEnd    int64                  `protobuf:"varint,3,opt,name=timestamp_end,json=timestampEnd,proto3" json:"timestamp_end,omitempty"`
 	Data            []byte                 `protobuf:"bytes,4,opt,name=data,proto3" json:"data,omitempty"` // Compressed rrweb events JSON
 	HasFullSnapshot bool                   `protobuf:"varint,5,opt,name=has_full_snapshot,json=hasFullSnapshot,proto3" json:"has_full_snapshot,omitempty"`
+	SessionId       string                 `protobuf:"bytes,6,opt,name=session_id,json=sessionId,proto3" json:"session_id,omitempty"`    // Required; may be omitted after a stream's first chunk
+	ProjectKey      string                 `protobuf:"bytes,7,opt,name=project_key,json=projectKey,proto3" json:"project_key,omitempty"` // Required on a stream's first chunk only
 	unknownFields   protoimpl.UnknownFields
 	sizeCache       protoimpl.SizeCache
 }
@@ -1026,16 +1037,31 @@ func (x *ReplayChunk) GetHasFullSnapshot() bool {
 	return false
 }
 
+func (x *ReplayChunk) GetSessionId() string {
+	if x != nil {
+		return x.SessionId
+	}
+	return ""
+}
+
+func (x *ReplayChunk) GetProjectKey() string {
+	if x != nil {
+		return x.ProjectKey
+	}
+	return ""
+}
+
 var File_gosight_events_proto protoreflect.FileDescriptor
 
 const file_gosight_events_proto_rawDesc = "" +
 	"\n" +
-	"\x14gosight/events.proto\x12\agosight\x1a\x14gosight/common.proto\"\xaf\x04\n" +
+	"\x14gosight/events.proto\x12\agosight\x1a\x14gosight/common.proto\"\xd8\x04\n" +
 	"\x05Event\x12\x19\n" +
 	"\bevent_id\x18\x01 \x01(\tR\aeventId\x12&\n" +
 	"\x04type\x18\x02 \x01(\x0e2\x12.gosight.EventTypeR\x04type\x12\x1c\n" +
 	"\ttimestamp\x18\x03 \x01(\x03R\ttimestamp\x12!\n" +
-	"\x04page\x18\x04 \x01(\v2\r.gosight.PageR\x04page\x12+\n" +
+	"\x04page\x18\x04 \x01(\v2\r.gosight.PageR\x04page\x12'\n" +
+	"\x0fidempotency_key\x18\x05 \x01(\tR\x0eidempotencyKey\x12+\n" +
 	"\x05click\x18\n" +
 	" \x01(\v2\x13.gosight.ClickEventH\x00R\x05click\x12.\n" +
 	"\x06scroll\x18\v \x01(\v2\x14.gosight.ScrollEventH\x00R\x06scroll\x12+\n" +
@@ -1111,14 +1137,18 @@ const file_gosight_events_proto_rawDesc = "" +
 	"properties\x1a=\n" +
 	"\x0fPropertiesEntry\x12\x10\n" +
 	"\x03key\x18\x01 \x01(\tR\x03key\x12\x14\n" +
-	"\x05value\x18\x02 \x01(\tR\x05value:\x028\x01\"\xbc\x01\n" +
+	"\x05value\x18\x02 \x01(\tR\x05value:\x028\x01\"\xfc\x01\n" +
 	"\vReplayChunk\x12\x1f\n" +
 	"\vchunk_index\x18\x01 \x01(\x05R\n" +
 	"chunkIndex\x12'\n" +
 	"\x0ftimestamp_start\x18\x02 \x01(\x03R\x0etimestampStart\x12#\n" +
 	"\rtimestamp_end\x18\x03 \x01(\x03R\ftimestampEnd\x12\x12\n" +
 	"\x04data\x18\x04 \x01(\fR\x04data\x12*\n" +
-	"\x11has_full_snapshot\x18\x05 \x01(\bR\x0fhasFullSnapshot*\xbc\x03\n" +
+	"\x11has_full_snapshot\x18\x05 \x01(\bR\x0fhasFullSnapshot\x12\x1d\n" +
+	"\n" +
+	"session_id\x18\x06 \x01(\tR\tsessionId\x12\x1f\n" +
+	"\vproject_key\x18\a \x01(\tR\n" +
+	"projectKey*\xbc\x03\n" +
 	"\tEventType\x12\x1a\n" +
 	"\x16EVENT_TYPE_UNSPECIFIED\x10\x00\x12\x18\n" +
 	"\x14EVENT_TYPE_PAGE_VIEW\x10\x01\x12\x14\n" +
@@ -97,6 +97,10 @@ type EventAck struct {
 	AcceptedCount int32                  `protobuf:"varint,2,opt,name=accepted_count,json=acceptedCount,proto3" json:"accepted_count,omitempty"`
 	RejectedCount int32                  `protobuf:"varint,3,opt,name=rejected_count,json=rejectedCount,proto3" json:"rejected_count,omitempty"`
 	Errors        []string               `protobuf:"bytes,4,rep,name=errors,proto3" json:"errors,omitempty"`
+	// Which limit rejected the batch: project_rate, ip_rate or event_count
+	LimitType string `protobuf:"bytes,5,opt,name=limit_type,json=limitType,proto3" json:"limit_type,omitempty"`
+	// How long to wait before sending a rate limited batch again
+	RetryAfterMs  int64 `protobuf:"varint,6,opt,name=retry_after_ms,json=retryAfterMs,proto3" json:"retry_after_ms,omitempty"`
 	unknownFields protoimpl.UnknownFields
 	sizeCache     protoimpl.SizeCache
 }
@@ -159,6 +163,20 @@ func (x *EventAck) GetErrors() []string {
 	return nil
 }
 
+func (x *EventAck) GetLimitType() string {
+	if x != nil {
+		return x.LimitType
+	}
+	return ""
+}
+
+func (x *EventAck) GetRetryAfterMs() int64 {
+	if x != nil {
+		return x.RetryAfterMs
+	}
+	return 0
+}
+
 // Replay acknowledgment
 type ReplayAck struct {
 	state         protoimpl.MessageState `protogen:"open.v1"`
@@ -223,12 +241,15 @@ const file_gosight_ingest_proto_rawDesc = "" +
 	"projectKey\x12.\n" +
 	"\asession\x18\x02 \x01(\v2\x14.gosight.SessionMetaR\asession\x12&\n" +
 	"\x06events\x18\x03 \x03(\v2\x0e.gosight.EventR\x06events\x12\x17\n" +
-	"\asent_at\x18\x04 \x01(\x03R\x06sentAt\"\x8a\x01\n" +
+	"\asent_at\x18\x04 \x01(\x03R\x06sentAt\"\xcf\x01\n" +
 	"\bEventAck\x12\x18\n" +
 	"\asuccess\x18\x01 \x01(\bR\asuccess\x12%\n" +
 	"\x0eaccepted_count\x18\x02 \x01(\x05R\racceptedCount\x12%\n" +
 	"\x0erejected_count\x18\x03 \x01(\x05R\rrejectedCount\x12\x16\n" +
-	"\x06errors\x18\x04 \x03(\tR\x06errors\"?\n" +
+	"\x06errors\x18\x04 \x03(\tR\x06errors\x12\x1d\n" +
+	"\n" +
+	"limit_type\x18\x05 \x01(\tR\tlimitType\x12$\n" +
+	"\x0eretry_after_ms\x18\x06 \x01(\x03R\fretryAfterMs\"?\n" +
 	"\tReplayAck\x12\x18\n" +
 	"\asuccess\x18\x01 \x01(\bR\asuccess\x12\x18\n" +
 	"\amessage\x18\x02 \x01(\tR\amessage2\x83\x01\n" +
@@ -0,0 +1,158 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"os"
+	"time"
+
+	"github.com/ClickHouse/clickhouse-go/v2"
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+
+	"github.com/gosight/gosight/ingestor/internal/config"
+	"github.com/gosight/gosight/ingestor/internal/enricher"
+)
+
+// backfill-enrichment re-enriches events stored without geo or user agent data
+// (e.g. ingested via gRPC, or before the GeoIP database was loaded) from their
+// stored client_ip and user_agent, updating rows with ClickHouse mutations.
+// User agents can only be re-parsed for events stored with the processor's
+// enrichment.store_raw_user_agent on.
+//
+// Rows sharing the same (client_ip, user_agent) enrich identically, so each
+// distinct pair is enriched once and all pairs of a run are applied in a single
+// mutation, looking up each row's values by its pair.
+func main() {
+	zerolog.TimeFieldFormat = zerolog.TimeFormatUnixMs
+	log.Logger = log.Output(zerolog.ConsoleWriter{Out: os.Stderr})
+
+	since := flag.Duration("since", 7*24*time.Hour, "only backfill events newer than this")
+	limit := flag.Int("limit", 1000, "max distinct (client_ip, user_agent) pairs to backfill per run")
+	dryRun := flag.Bool("dry-run", false, "log updates without running mutations")
+	flag.Parse()
+
+	configPath := os.Getenv("CONFIG_PATH")
+	if configPath == "" {
+		configPath = "config/ingestor.yaml"
+	}
+
+	cfg, err := config.Load(configPath)
+	if err != nil {
+		log.Fatal().Err(err).Msg("Failed to load config")
+	}
+
+	geoProvider, err := enricher.NewGeoProvider(cfg.GeoIP)
+	if err != nil {
+		log.Fatal().Err(err).Msg("Failed to create geo provider")
+	}
+	eventEnricher := enricher.NewEnricher(geoProvider)
+	defer eventEnricher.Close()
+
+	conn, err := clickhouse.Open(&clickhouse.Options{
+		Addr: []string{cfg.ClickHouse.Addr},
+		Auth: clickhouse.Auth{
+			Database: cfg.ClickHouse.Database,
+			Username: cfg.ClickHouse.Username,
+			Password: cfg.ClickHouse.Password,
+		},
+	})
+	if err != nil {
+		log.Fatal().Err(err).Msg("Failed to connect to ClickHouse")
+	}
+	defer conn.Close()
+
+	ctx := context.Background()
+	from := time.Now().Add(-*since)
+
+	rows, err := conn.Query(ctx, `
+		SELECT DISTINCT client_ip, user_agent
+		FROM events
+		WHERE timestamp >= ?
+		AND (country = '' OR browser = '')
+		AND (client_ip != '' OR user_agent != '')
+		LIMIT ?
+	`, from, *limit)
+	if err != nil {
+		log.Fatal().Err(err).Msg("Failed to query events missing enrichment")
+	}
+
+	type pair struct{ clientIP, userAgent string }
+	var pairs []pair
+	for rows.Next() {
+		var p pair
+		if err := rows.Scan(&p.clientIP, &p.userAgent); err != nil {
+			log.Fatal().Err(err).Msg("Failed to scan event")
+		}
+		pairs = append(pairs, p)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		log.Fatal().Err(err).Msg("Failed to read events missing enrichment")
+	}
+
+	log.Info().Int("pairs", len(pairs)).Time("since", from).Msg("Backfilling enrichment")
+
+	// Parallel arrays indexed by pair; pairKey matches pairKeyExpr for each row
+	var keys, browsers, browserVersions, oses, deviceTypes, countries, cities []string
+	for _, p := range pairs {
+		enriched := eventEnricher.Enrich(map[string]interface{}{}, p.userAgent, p.clientIP)
+		if enriched.Country == "" && enriched.Browser == "" {
+			continue
+		}
+
+		if *dryRun {
+			log.Info().
+				Str("client_ip", p.clientIP).
+				Str("browser", enriched.Browser).
+				Str("os", enriched.OS).
+				Str("country", enriched.Country).
+				Msg("Would update events")
+		}
+
+		keys = append(keys, pairKey(p.clientIP, p.userAgent))
+		browsers = append(browsers, enriched.Browser)
+		browserVersions = append(browserVersions, enriched.BrowserVersion)
+		oses = append(oses, enriched.OS)
+		deviceTypes = append(deviceTypes, enriched.DeviceType)
+		countries = append(countries, enriched.Country)
+		cities = append(cities, enriched.City)
+	}
+
+	if *dryRun || len(keys) == 0 {
+		log.Info().Int("updated_pairs", len(keys)).Bool("dry_run", *dryRun).Msg("Backfill complete")
+		return
+	}
+
+	// Only fill columns that are still empty
+	err = conn.Exec(ctx, `
+		ALTER TABLE events UPDATE
+			browser = if(browser = '', transform(`+pairKeyExpr+`, ?, ?, browser), browser),
+			browser_version = if(browser_version = '', transform(`+pairKeyExpr+`, ?, ?, browser_version), browser_version),
+			os = if(os = '', transform(`+pairKeyExpr+`, ?, ?, os), os),
+			device_type = if(device_type = '', transform(`+pairKeyExpr+`, ?, ?, device_type), device_type),
+			country = if(country = '', transform(`+pairKeyExpr+`, ?, ?, country), country),
+			city = if(city = '', transform(`+pairKeyExpr+`, ?, ?, city), city)
+		WHERE `+pairKeyExpr+` IN ?
+		AND timestamp >= ?
+		AND (country = '' OR browser = '')
+	`,
+		keys, browsers, keys, browserVersions, keys, oses,
+		keys, deviceTypes, keys, countries, keys, cities,
+		keys, from,
+	)
+	if err != nil {
+		log.Fatal().Err(err).Int("pairs", len(keys)).Msg("Failed to update events")
+	}
+
+	log.Info().Int("updated_pairs", len(keys)).Bool("dry_run", *dryRun).Msg("Backfill complete")
+}
+
+// pairKeyExpr is the ClickHouse expression for a row's (client_ip, user_agent)
+// lookup key. Client IPs never contain a space, so the key is unambiguous.
+const pairKeyExpr = "concat(client_ip, ' ', user_agent)"
+
+// pairKey builds the lookup key pairKeyExpr evaluates to for a row
+func pairKey(clientIP, userAgent string) string {
+	return clientIP + " " + userAgent
+}
@@ -8,6 +8,7 @@ import (
 	"os"
 	"os/signal"
 	"syscall"
+	"time"
 
 	"github.com/go-chi/chi/v5"
 	"github.com/go-chi/chi/v5/middleware"
@@ -15,6 +16,7 @@ import (
 	"github.com/rs/zerolog/log"
 	"google.golang.org/grpc"
 
+	"github.com/gosight/gosight/ingestor/internal/audit"
 	"github.com/gosight/gosight/ingestor/internal/config"
 	"github.com/gosight/gosight/ingestor/internal/enricher"
 	"github.com/gosight/gosight/ingestor/internal/handler"
@@ -24,6 +26,10 @@ import (
 	pb "github.com/gosight/gosight/ingestor/proto/gosight"
 )
 
+// handlerExitTimeout bounds the wait for handlers canceled at the shutdown
+// timeout to return
+const handlerExitTimeout = 5 * time.Second
+
 func main() {
 	// Setup logging
 	zerolog.TimeFieldFormat = zerolog.TimeFormatUnixMs
@@ -47,8 +53,7 @@ func main() {
 	if err != nil {
 		log.Fatal().Err(err).Msg("Failed to create Kafka producer")
 	}
-	defer kafkaProducer.Close()
-	log.Info().Msg("Kafka producer initialized")
+	log.Info().Str("encoding", cfg.Kafka.Encoding).Msg("Kafka producer initialized")
 
 	validator, err := validation.NewValidator(cfg)
 	if err != nil {
@@ -57,13 +62,44 @@ func main() {
 	defer validator.Close()
 	log.Info().Msg("Validator initialized")
 
-	eventEnricher := enricher.NewEnricher(cfg.GeoIP.DatabasePath)
+	geoProvider, err := enricher.NewGeoProvider(cfg.GeoIP)
+	if err != nil {
+		log.Warn().Err(err).Msg("Failed to create geo provider, geo enrichment disabled")
+	}
+
+	eventEnricher := enricher.NewEnricher(geoProvider)
+	if err != nil {
+		// Configured but no database could be opened: count lookups as no_database
+		eventEnricher.ExpectGeo()
+	}
 	defer eventEnricher.Close()
 	log.Info().Msg("Enricher initialized")
 
+	auditor := audit.NewAuditor(cfg.Audit, kafkaProducer)
+	if auditor != nil {
+		log.Info().Float64("sample_rate", cfg.Audit.SampleRate).Msg("Rejected event auditing enabled")
+	}
+
 	// Create gRPC server
-	grpcServer := grpc.NewServer()
-	ingestServer := server.NewIngestServer(kafkaProducer, validator, eventEnricher)
+	grpcOpts, err := server.ServerOptions(cfg.Server.GRPCTLS)
+	if err != nil {
+		log.Fatal().Err(err).Msg("Failed to configure gRPC TLS")
+	}
+	if cfg.Server.GRPCTLS.Enabled() {
+		log.Info().
+			Bool("mtls", cfg.Server.GRPCTLS.ClientCAFile != "").
+			Str("client_auth", cfg.Server.GRPCTLS.ClientAuth).
+			Msg("gRPC TLS enabled")
+	} else {
+		log.Warn().Msg("gRPC TLS not configured, serving plaintext")
+	}
+	grpcOpts = append(grpcOpts,
+		grpc.MaxRecvMsgSize(cfg.Server.GRPCMaxRecvMsgSize),
+		// Stop at the shutdown timeout then waits for the RPCs it cancels
+		grpc.WaitForHandlers(true),
+	)
+	grpcServer := grpc.NewServer(grpcOpts...)
+	ingestServer := server.NewIngestServer(kafkaProducer, validator, eventEnricher, auditor, cfg.Server.GRPCMaxRecvMsgSize)
 	pb.RegisterIngestServiceServer(grpcServer, ingestServer)
 
 	// Start gRPC server
@@ -79,17 +115,33 @@ func main() {
 	}()
 
 	// Create HTTP server (fallback)
-	httpHandler := handler.NewHTTPHandler(kafkaProducer, validator, eventEnricher)
+	httpHandler := handler.NewHTTPHandler(kafkaProducer, validator, eventEnricher, auditor, cfg.Batch)
+	inFlight := &handler.InFlight{}
 	r := chi.NewRouter()
-	r.Use(middleware.Logger)
+	r.Use(inFlight.Middleware)
+	r.Use(handler.RealIP(cfg.Server.ClientIPHeaders, cfg.Server.ForwardedForHops)) // before the logger so it logs the client IP
+	r.Use(handler.SamplingLogger(cfg.RequestLog))
 	r.Use(middleware.Recoverer)
-	r.Use(middleware.RealIP)
-	r.Use(handler.CORSMiddleware)
+	var credentialOrigins []string
+	if cfg.Visitor.Enabled {
+		credentialOrigins = cfg.Visitor.AllowedOrigins
+	}
+	r.Use(handler.CORS(credentialOrigins))
 
+	readiness := &handler.Readiness{}
 	r.Get("/health", handler.HealthCheck)
+	r.Get("/readyz", readiness.ReadyCheck)
+	r.Get("/metrics", handler.MetricsHandler(eventEnricher, validator))
 	r.Post("/v1/events", httpHandler.HandleEvents)
 	r.Post("/v1/replay", httpHandler.HandleReplay)
 
+	var wsHandler *handler.WebSocketHandler
+	if cfg.WebSocket.Enabled {
+		wsHandler = handler.NewWebSocketHandler(kafkaProducer, validator, eventEnricher, auditor, cfg.WebSocket)
+		r.Get("/v1/ws", wsHandler.HandleWebSocket)
+		log.Info().Int("max_message_bytes", cfg.WebSocket.MaxMessageBytes).Msg("WebSocket ingestion enabled")
+	}
+
 	httpServer := &http.Server{
 		Addr:    fmt.Sprintf(":%d", cfg.Server.HTTPPort),
 		Handler: r,
@@ -107,8 +159,63 @@ func main() {
 	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
 	<-quit
 
+	// Fail readiness first so load balancers stop routing new requests
+	readiness.SetDraining()
+	if cfg.Server.ShutdownDrain > 0 {
+		log.Info().Dur("drain", cfg.Server.ShutdownDrain).Msg("Draining before shutdown...")
+		time.Sleep(cfg.Server.ShutdownDrain)
+	}
+
 	log.Info().Msg("Shutting down servers...")
-	grpcServer.GracefulStop()
-	httpServer.Shutdown(context.Background())
+	ctx, cancel := context.WithTimeout(context.Background(), cfg.Server.ShutdownTimeout)
+	defer cancel()
+
+	// Both servers drain in parallel; a stuck client gets cut off at the timeout
+	grpcStopped := make(chan struct{})
+	go func() {
+		grpcServer.GracefulStop()
+		close(grpcStopped)
+	}()
+	// Shutdown neither closes nor waits for hijacked WebSocket connections
+	if wsHandler != nil {
+		if err := wsHandler.Shutdown(ctx); err != nil {
+			log.Warn().Err(err).Msg("WebSocket connections still open at the shutdown timeout")
+		}
+	}
+	if err := httpServer.Shutdown(ctx); err != nil {
+		log.Warn().Err(err).Msg("HTTP shutdown timed out, closing connections")
+		httpServer.Close()
+	}
+	grpcForced := false
+	select {
+	case <-grpcStopped:
+	case <-ctx.Done():
+		log.Warn().Msg("gRPC graceful stop timed out, closing streams")
+		grpcForced = true
+	}
+
+	// Close and Stop cancel the requests still running, and Close does not
+	// wait for them; give them handlerExitTimeout to return before closing
+	// the auditor and producer they write to
+	exitCtx, exitCancel := context.WithTimeout(context.Background(), handlerExitTimeout)
+	defer exitCancel()
+	if grpcForced {
+		go grpcServer.Stop()
+		select {
+		case <-grpcStopped:
+		case <-exitCtx.Done():
+			log.Warn().Msg("gRPC handlers still running after being canceled")
+		}
+	}
+	if err := inFlight.Wait(exitCtx); err != nil {
+		log.Warn().Err(err).Msg("HTTP handlers still running after being canceled")
+	}
 	log.Info().Msg("Servers stopped")
+
+	// Drain producers only once no new requests can arrive
+	auditor.Close()
+	if err := kafkaProducer.Close(); err != nil {
+		log.Error().Err(err).Msg("Failed to flush Kafka producer")
+	}
+	log.Info().Msg("Kafka producer closed")
 }
@@ -0,0 +1,146 @@
+package audit
+
+import (
+	"context"
+	"encoding/json"
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+
+	"github.com/gosight/gosight/ingestor/internal/config"
+	"github.com/gosight/gosight/ingestor/internal/producer"
+)
+
+// RejectedEvent is an audit record of an event (or batch) rejected at ingestion
+type RejectedEvent struct {
+	ProjectID  string `json:"project_id"`
+	Reason     string `json:"reason"`
+	Source     string `json:"source"` // http or grpc
+	EventCount int    `json:"event_count"`
+	Payload    string `json:"payload"`
+	Truncated  bool   `json:"truncated"`
+	Timestamp  int64  `json:"timestamp"`
+}
+
+// Auditor writes sampled, rate-limited rejection records to the rejected events topic.
+// A nil *Auditor is valid and records nothing.
+type Auditor struct {
+	producer        *producer.KafkaProducer
+	sampleRate      float64
+	maxPerSecond    int
+	maxPayloadBytes int
+
+	records chan RejectedEvent
+	done    chan struct{}
+
+	// closed is set by Close; Record holds closeMu while sending to records
+	closeMu sync.RWMutex
+	closed  bool
+
+	mu          sync.Mutex
+	windowStart time.Time
+	windowCount int
+}
+
+// NewAuditor creates an auditor, or returns nil when auditing is disabled
+func NewAuditor(cfg config.AuditConfig, p *producer.KafkaProducer) *Auditor {
+	if !cfg.Enabled {
+		return nil
+	}
+
+	a := &Auditor{
+		producer:        p,
+		sampleRate:      cfg.SampleRate,
+		maxPerSecond:    cfg.MaxPerSecond,
+		maxPayloadBytes: cfg.MaxPayloadBytes,
+		records:         make(chan RejectedEvent, 1000),
+		done:            make(chan struct{}),
+	}
+
+	go a.writeLoop()
+
+	return a
+}
+
+// Record audits a rejection. It never blocks: records beyond the sample rate,
+// the per-second limit or the write buffer are dropped, as are records after
+// Close.
+func (a *Auditor) Record(projectID, reason, source string, eventCount int, payload interface{}) {
+	if a == nil || !a.allow() {
+		return
+	}
+
+	record := RejectedEvent{
+		ProjectID:  projectID,
+		Reason:     reason,
+		Source:     source,
+		EventCount: eventCount,
+		Timestamp:  time.Now().UnixMilli(),
+	}
+
+	data, _ := json.Marshal(payload)
+	if len(data) > a.maxPayloadBytes {
+		data = data[:a.maxPayloadBytes]
+		record.Truncated = true
+	}
+	record.Payload = string(data)
+
+	a.closeMu.RLock()
+	defer a.closeMu.RUnlock()
+	if a.closed {
+		return
+	}
+	select {
+	case a.records <- record:
+	default:
+	}
+}
+
+func (a *Auditor) allow() bool {
+	if a.sampleRate < 1 && rand.Float64() >= a.sampleRate {
+		return false
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	now := time.Now()
+	if now.Sub(a.windowStart) >= time.Second {
+		a.windowStart = now
+		a.windowCount = 0
+	}
+	if a.windowCount >= a.maxPerSecond {
+		return false
+	}
+	a.windowCount++
+	return true
+}
+
+func (a *Auditor) writeLoop() {
+	defer close(a.done)
+	for record := range a.records {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		if err := a.producer.ProduceRejected(ctx, record.ProjectID, record); err != nil {
+			log.Error().Err(err).Str("reason", record.Reason).Msg("Failed to write rejected event audit record")
+		}
+		cancel()
+	}
+}
+
+// Close stops the write loop once buffered records are written. Records
+// made meanwhile or afterwards are dropped.
+func (a *Auditor) Close() {
+	if a == nil {
+		return
+	}
+
+	a.closeMu.Lock()
+	if !a.closed {
+		a.closed = true
+		close(a.records)
+	}
+	a.closeMu.Unlock()
+	<-a.done
+}
@@ -0,0 +1,94 @@
+package audit
+
+import (
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/gosight/gosight/ingestor/internal/config"
+	"github.com/gosight/gosight/ingestor/internal/producer"
+)
+
+func newTestAuditor(t *testing.T) *Auditor {
+	t.Helper()
+	// Without a "rejected" topic, records fail to write without reaching Kafka
+	p, err := producer.NewKafkaProducer(config.KafkaConfig{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return NewAuditor(config.AuditConfig{
+		Enabled:         true,
+		SampleRate:      1,
+		MaxPerSecond:    1 << 30,
+		MaxPayloadBytes: 16,
+	}, p)
+}
+
+func TestRecordDuringAndAfterClose(t *testing.T) {
+	a := newTestAuditor(t)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 1000; j++ {
+				a.Record("proj", "Invalid JSON", "http", 1, "payload")
+			}
+		}()
+	}
+	a.Close()
+	wg.Wait()
+
+	// No-ops once closed, including a second Close
+	a.Record("proj", "Invalid JSON", "http", 1, nil)
+	a.Close()
+}
+
+func TestRecordTruncatesPayload(t *testing.T) {
+	// Without a write loop, so the record stays in the buffer
+	a := &Auditor{
+		sampleRate:      1,
+		maxPerSecond:    10,
+		maxPayloadBytes: 16,
+		records:         make(chan RejectedEvent, 1),
+	}
+
+	a.Record("proj", "Event too large", "http", 1, strings.Repeat("x", 100))
+	record := <-a.records
+
+	if !record.Truncated || len(record.Payload) != 16 {
+		t.Errorf("record = %+v, want a truncated 16 byte payload", record)
+	}
+	if record.ProjectID != "proj" || record.Reason != "Event too large" || record.EventCount != 1 {
+		t.Errorf("record = %+v", record)
+	}
+}
+
+func TestRecordRateLimit(t *testing.T) {
+	a := &Auditor{
+		sampleRate:      1,
+		maxPerSecond:    2,
+		maxPayloadBytes: 16,
+		records:         make(chan RejectedEvent, 10),
+	}
+
+	for i := 0; i < 5; i++ {
+		a.Record("proj", "Rate limit exceeded", "http", 1, nil)
+	}
+	if n := len(a.records); n != 2 {
+		t.Errorf("recorded %d rejections, want 2 (max_per_second)", n)
+	}
+}
+
+func TestNilAuditor(t *testing.T) {
+	var a *Auditor
+	a.Record("proj", "Invalid JSON", "http", 1, nil)
+	a.Close()
+}
+
+func TestDisabledAuditorIsNil(t *testing.T) {
+	if a := NewAuditor(config.AuditConfig{}, nil); a != nil {
+		t.Error("NewAuditor returned an auditor with auditing disabled")
+	}
+}
@@ -1,7 +1,12 @@
 package config
 
 import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net/url"
 	"os"
+	"time"
 
 	"gopkg.in/yaml.v3"
 )
@@ -14,16 +19,180 @@ type Config struct {
 	GeoIP     GeoIPConfig     `yaml:"geoip"`
 	RateLimit RateLimitConfig `yaml:"rate_limit"`
 	Batch     BatchConfig     `yaml:"batch"`
+	Audit     AuditConfig     `yaml:"audit"`
+	WebSocket WebSocketConfig `yaml:"websocket"`
+
+	Validation ValidationConfig `yaml:"validation"`
+
+	Idempotency    IdempotencyConfig    `yaml:"idempotency"`
+	ReplaySampling ReplaySamplingConfig `yaml:"replay_sampling"`
+	RequestLog     RequestLogConfig     `yaml:"request_log"`
+
+	ProjectSettings ProjectSettingsConfig `yaml:"project_settings"`
+
+	Consent ConsentConfig `yaml:"consent"`
+	Visitor VisitorConfig `yaml:"visitor"`
+
+	// Only used by the backfill-enrichment tool
+	ClickHouse ClickHouseConfig `yaml:"clickhouse"`
+}
+
+type ClickHouseConfig struct {
+	Addr     string `yaml:"addr"`
+	Database string `yaml:"database"`
+	Username string `yaml:"username"`
+	Password string `yaml:"password"`
 }
 
 type ServerConfig struct {
 	GRPCPort int `yaml:"grpc_port"`
 	HTTPPort int `yaml:"http_port"`
+	// Max size in bytes of a single gRPC message (defaults to 4MB, capped at 64MB)
+	GRPCMaxRecvMsgSize int `yaml:"grpc_max_recv_msg_size"`
+	// On shutdown /readyz reports 503 for ShutdownDrain before the servers stop,
+	// then in-flight requests get ShutdownTimeout before connections are closed
+	ShutdownDrain   time.Duration `yaml:"shutdown_drain"`
+	ShutdownTimeout time.Duration `yaml:"shutdown_timeout"`
+	// TLS for the gRPC server; plaintext when no certificate is configured
+	GRPCTLS GRPCTLSConfig `yaml:"grpc_tls"`
+	// Headers carrying the HTTP client IP, tried in order before the peer
+	// address; only list headers your proxies or CDN set, the others can be
+	// spoofed. An empty list uses the peer address only.
+	ClientIPHeaders []string `yaml:"client_ip_headers"`
+	// Proxies appending to X-Forwarded-For; the client IP is that many entries
+	// from the right (0 takes the leftmost valid entry)
+	ForwardedForHops int `yaml:"forwarded_for_hops"`
+}
+
+// GRPCTLSConfig enables TLS, and with a client CA mutual TLS, on the gRPC server
+type GRPCTLSConfig struct {
+	CertFile string `yaml:"cert_file"`
+	KeyFile  string `yaml:"key_file"`
+	// CA used to verify client certificates; enables mTLS
+	ClientCAFile string `yaml:"client_ca_file"`
+	// require (default) or optional, to accept clients without a certificate
+	// (e.g. mobile SDKs) alongside mTLS forwarders on the same port
+	ClientAuth string `yaml:"client_auth"`
+	// Client certificate subject common names allowed to connect; any verified
+	// certificate is accepted when empty
+	AllowedClientNames []string `yaml:"allowed_client_names"`
+}
+
+// Enabled reports whether the gRPC server should use TLS
+func (c GRPCTLSConfig) Enabled() bool {
+	return c.CertFile != ""
 }
 
 type KafkaConfig struct {
 	Brokers []string          `yaml:"brokers"`
 	Topics  map[string]string `yaml:"topics"`
+	// Event encoding: json (default) or avro, registered with the schema registry
+	Encoding       string               `yaml:"encoding"`
+	SchemaRegistry SchemaRegistryConfig `yaml:"schema_registry"`
+	// Message compression: none, gzip, snappy (default), lz4 or zstd
+	Compression string `yaml:"compression"`
+
+	// Authentication to the brokers, e.g. managed Kafka
+	SASL KafkaSASLConfig `yaml:"sasl"`
+	TLS  KafkaTLSConfig  `yaml:"tls"`
+}
+
+// SASL mechanisms (kafka.sasl.mechanism)
+const (
+	SASLMechanismPlain       = "PLAIN"
+	SASLMechanismScramSHA256 = "SCRAM-SHA-256"
+	SASLMechanismScramSHA512 = "SCRAM-SHA-512"
+)
+
+// KafkaSASLConfig authenticates to the brokers with SASL; disabled when no
+// mechanism is set. Keep credentials out of the file with ${VAR} references.
+type KafkaSASLConfig struct {
+	Mechanism string `yaml:"mechanism"` // PLAIN, SCRAM-SHA-256 or SCRAM-SHA-512
+	Username  string `yaml:"username"`
+	Password  string `yaml:"password"`
+}
+
+// KafkaTLSConfig connects to the brokers over TLS, and with a client
+// certificate over mutual TLS
+type KafkaTLSConfig struct {
+	Enabled bool `yaml:"enabled"`
+	// CA verifying the broker certificates; the system roots when empty
+	CAFile string `yaml:"ca_file"`
+	// Client certificate, for brokers requiring mTLS
+	CertFile string `yaml:"cert_file"`
+	KeyFile  string `yaml:"key_file"`
+	// Skips broker certificate verification; for testing only
+	InsecureSkipVerify bool `yaml:"insecure_skip_verify"`
+
+	config *tls.Config // loaded by Load
+}
+
+// Config returns the TLS configuration loaded from the files, nil when TLS is disabled
+func (c KafkaTLSConfig) Config() *tls.Config {
+	return c.config
+}
+
+// load loads the certificate files, so a bad file fails at startup rather
+// than on the first produce
+func (c *KafkaTLSConfig) load() error {
+	if !c.Enabled {
+		if c.CAFile != "" || c.CertFile != "" || c.KeyFile != "" {
+			return fmt.Errorf("kafka.tls files are set but kafka.tls.enabled is false")
+		}
+		return nil
+	}
+
+	tlsCfg := &tls.Config{
+		MinVersion:         tls.VersionTLS12,
+		InsecureSkipVerify: c.InsecureSkipVerify,
+	}
+	if c.CAFile != "" {
+		caPEM, err := os.ReadFile(c.CAFile)
+		if err != nil {
+			return fmt.Errorf("read kafka.tls.ca_file: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(caPEM) {
+			return fmt.Errorf("no certificates found in kafka.tls.ca_file %s", c.CAFile)
+		}
+		tlsCfg.RootCAs = pool
+	}
+	if (c.CertFile == "") != (c.KeyFile == "") {
+		return fmt.Errorf("kafka.tls.cert_file and kafka.tls.key_file must be set together")
+	}
+	if c.CertFile != "" {
+		cert, err := tls.LoadX509KeyPair(c.CertFile, c.KeyFile)
+		if err != nil {
+			return fmt.Errorf("load kafka.tls client certificate: %w", err)
+		}
+		tlsCfg.Certificates = []tls.Certificate{cert}
+	}
+	c.config = tlsCfg
+	return nil
+}
+
+// Event encodings (kafka.encoding)
+const (
+	EncodingJSON = "json"
+	EncodingAvro = "avro"
+)
+
+// Message compression codecs (kafka.compression)
+const (
+	CompressionNone   = "none"
+	CompressionGzip   = "gzip"
+	CompressionSnappy = "snappy"
+	CompressionLz4    = "lz4"
+	CompressionZstd   = "zstd"
+)
+
+// SchemaRegistryConfig points at a Confluent-compatible schema registry
+type SchemaRegistryConfig struct {
+	URL string `yaml:"url"`
+	// Subject of the event schema; defaults to "<events topic>-value"
+	Subject  string `yaml:"subject"`
+	Username string `yaml:"username"`
+	Password string `yaml:"password"`
 }
 
 type RedisConfig struct {
@@ -34,22 +203,184 @@ type RedisConfig struct {
 
 type PostgresConfig struct {
 	DSN string `yaml:"dsn"`
+	// Bounds each API key lookup and last_used_at update
+	QueryTimeout time.Duration `yaml:"query_timeout"`
+	// last_used_at updates run on this many workers; updates beyond
+	// last_used_queue pending ones are dropped
+	LastUsedWorkers int `yaml:"last_used_workers"`
+	LastUsedQueue   int `yaml:"last_used_queue"`
 }
 
 type GeoIPConfig struct {
+	Provider     string `yaml:"provider"` // maxmind (default) or none
 	DatabasePath string `yaml:"database_path"`
+	// Further databases tried after database_path, e.g. a country DB as fallback for a city DB
+	Databases []GeoIPDatabaseConfig `yaml:"databases"`
+}
+
+type GeoIPDatabaseConfig struct {
+	Path string `yaml:"path"`
+	Type string `yaml:"type"` // city or country; detected from the database when empty
 }
 
+// RateLimitConfig limits the requests per project with a token bucket: up to
+// burst requests at once, refilled at requests_per_second. Unlimited when
+// requests_per_second is 0. The ip_ limits do the same per client IP, checked
+// before the project's.
 type RateLimitConfig struct {
-	RequestsPerSecond int `yaml:"requests_per_second"`
-	Burst             int `yaml:"burst"`
+	RequestsPerSecond   int `yaml:"requests_per_second"`
+	Burst               int `yaml:"burst"` // defaults to requests_per_second
+	IPRequestsPerSecond int `yaml:"ip_requests_per_second"`
+	IPBurst             int `yaml:"ip_burst"` // defaults to ip_requests_per_second
+	// Where buckets are kept: redis (default), shared by all instances, or
+	// memory, per instance, for single-node deployments without Redis
+	Backend string `yaml:"backend"`
 }
 
+// Rate limiter backends (rate_limit.backend)
+const (
+	RateLimitBackendRedis  = "redis"
+	RateLimitBackendMemory = "memory"
+)
+
 type BatchConfig struct {
-	MaxSize       int    `yaml:"max_size"`
-	FlushInterval string `yaml:"flush_interval"`
+	MaxSize           int    `yaml:"max_size"`
+	FlushInterval     string `yaml:"flush_interval"`
+	MaxEventsPerBatch int    `yaml:"max_events_per_batch"`
+	// Stamp every HTTP event with the request's session_id/user_id, even events
+	// that carry their own (by default those are kept)
+	OverwriteEventSession bool `yaml:"overwrite_event_session"`
+}
+
+// AuditConfig controls audit logging of rejected events to the "rejected" Kafka topic
+type AuditConfig struct {
+	Enabled         bool    `yaml:"enabled"`
+	SampleRate      float64 `yaml:"sample_rate"`       // fraction of rejections recorded (0-1)
+	MaxPerSecond    int     `yaml:"max_per_second"`    // cap on audit writes per second
+	MaxPayloadBytes int     `yaml:"max_payload_bytes"` // payloads are truncated to this size
+}
+
+// ValidationConfig controls per-event validation
+type ValidationConfig struct {
+	// Events whose timestamp is further than this from the server time are rejected
+	MaxTimestampSkew time.Duration `yaml:"max_timestamp_skew"`
+	// Payload stack, message and custom property strings are truncated to this many bytes
+	MaxFieldLength int `yaml:"max_field_length"`
+	// Events still larger than this once serialized are rejected
+	MaxEventBytes int `yaml:"max_event_bytes"`
+	// Events without a session_id are rejected (default), or assigned a
+	// session generated per request
+	MissingSession string `yaml:"missing_session"`
+}
+
+// Handling of events without a session_id (validation.missing_session)
+const (
+	MissingSessionReject = "reject"
+	MissingSessionAssign = "assign"
+)
+
+// IdempotencyConfig controls deduplication of events by their optional
+// client-provided idempotency_key, per project within Window. Duplicates are
+// acknowledged to the client but not produced.
+type IdempotencyConfig struct {
+	Enabled bool          `yaml:"enabled"`
+	Window  time.Duration `yaml:"window"`
+}
+
+// ReplaySamplingConfig controls which sessions' replay chunks are kept. The
+// decision is a hash of the session ID, so a session's chunks are all kept or
+// all dropped. Sessions flagged by the insights processor are always kept from
+// that point on.
+type ReplaySamplingConfig struct {
+	Enabled         bool               `yaml:"enabled"`
+	SampleRate      float64            `yaml:"sample_rate"`       // fraction of sessions kept (0-1)
+	ProjectRates    map[string]float64 `yaml:"project_rates"`     // per project ID, overrides sample_rate
+	KeepWithInsight bool               `yaml:"keep_with_insight"` // keep sessions with a detected insight
+}
+
+// ConsentConfig controls how consent flags the client didn't send are
+// resolved: granted in permissive mode (the default), denied in strict mode
+type ConsentConfig struct {
+	Mode string `yaml:"mode"`
+}
+
+// Consent modes (consent.mode)
+const (
+	ConsentModePermissive = "permissive"
+	ConsentModeStrict     = "strict"
+)
+
+// VisitorConfig controls the anonymous visitor ID (anonymous_id) stamped on
+// events to count unique visitors across sessions. Browsers get it from a
+// first-party cookie set on POST /v1/events; other clients (gRPC, mobile)
+// send their own. It is only kept with analytics consent. Browsers only send
+// and accept the cookie cross-origin from AllowedOrigins.
+type VisitorConfig struct {
+	Enabled      bool          `yaml:"enabled"`
+	CookieName   string        `yaml:"cookie_name"`
+	CookieDomain string        `yaml:"cookie_domain"` // e.g. example.com to share it across subdomains; empty for the ingestor host
+	CookieMaxAge time.Duration `yaml:"cookie_max_age"`
+	// Origins (e.g. https://www.example.com) allowed credentialed CORS requests
+	AllowedOrigins []string `yaml:"allowed_origins"`
 }
 
+// ProjectSettingsConfig controls loading of per-project settings (replay
+// sample rates) from the project_settings table. Changes are picked up as
+// they are notified and the whole table is reloaded every RefreshInterval.
+type ProjectSettingsConfig struct {
+	Enabled         bool          `yaml:"enabled"`
+	RefreshInterval time.Duration `yaml:"refresh_interval"`
+}
+
+// RequestLogConfig controls HTTP request logging. Errors (5xx), slow requests
+// and the always-log statuses are always logged; other requests are sampled.
+type RequestLogConfig struct {
+	SampleRate        float64       `yaml:"sample_rate"` // fraction of other requests logged (0-1)
+	SlowThreshold     time.Duration `yaml:"slow_threshold"`
+	AlwaysLogStatuses []int         `yaml:"always_log_statuses"`
+}
+
+// WebSocketConfig controls event streaming over GET /v1/ws
+type WebSocketConfig struct {
+	Enabled         bool          `yaml:"enabled"`
+	MaxMessageBytes int           `yaml:"max_message_bytes"` // larger event frames are rejected
+	AckInterval     time.Duration `yaml:"ack_interval"`      // how often accepted/rejected counts are sent
+}
+
+const (
+	DefaultGRPCMaxRecvMsgSize = 4 * 1024 * 1024
+	MaxGRPCMaxRecvMsgSize     = 64 * 1024 * 1024
+	DefaultMaxEventsPerBatch  = 500
+	DefaultShutdownDrain      = 5 * time.Second
+	DefaultShutdownTimeout    = 15 * time.Second
+	DefaultWSMaxMessageBytes  = 64 * 1024
+	DefaultWSAckInterval      = time.Second
+	DefaultMaxTimestampSkew   = 24 * time.Hour
+	DefaultMaxFieldLength     = 8 * 1024
+	DefaultMaxEventBytes      = 64 * 1024
+	DefaultIdempotencyWindow  = 10 * time.Minute
+	DefaultSettingsRefresh    = time.Minute
+	DefaultRequestLogSample   = 0.01
+	DefaultSlowRequest        = time.Second
+	DefaultPostgresTimeout    = 2 * time.Second
+	DefaultVisitorCookie      = "visitor_id"
+	DefaultVisitorCookieAge   = 365 * 24 * time.Hour
+	DefaultLastUsedWorkers    = 4
+	DefaultLastUsedQueue      = 1000
+)
+
+// gRPC client certificate modes (server.grpc_tls.client_auth)
+const (
+	ClientAuthRequire  = "require"
+	ClientAuthOptional = "optional"
+)
+
+// DefaultClientIPHeaders are the headers set by common reverse proxies
+var DefaultClientIPHeaders = []string{"X-Real-IP", "X-Forwarded-For"}
+
+// DefaultAlwaysLogStatuses are validation failures and rate limits
+var DefaultAlwaysLogStatuses = []int{400, 401, 413, 429}
+
 func Load(path string) (*Config, error) {
 	data, err := os.ReadFile(path)
 	if err != nil {
@@ -64,5 +395,148 @@ func Load(path string) (*Config, error) {
 		return nil, err
 	}
 
+	cfg.applyDefaults()
+
+	if cfg.Server.ForwardedForHops < 0 {
+		return nil, fmt.Errorf("server.forwarded_for_hops must not be negative, got %d", cfg.Server.ForwardedForHops)
+	}
+	for _, origin := range cfg.Visitor.AllowedOrigins {
+		if u, err := url.Parse(origin); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" || u.Path != "" {
+			return nil, fmt.Errorf("visitor.allowed_origins must hold origins like https://www.example.com, got %q", origin)
+		}
+	}
+	if cfg.Consent.Mode != ConsentModePermissive && cfg.Consent.Mode != ConsentModeStrict {
+		return nil, fmt.Errorf("consent.mode must be %s or %s, got %q", ConsentModePermissive, ConsentModeStrict, cfg.Consent.Mode)
+	}
+	if cfg.Validation.MissingSession != MissingSessionReject && cfg.Validation.MissingSession != MissingSessionAssign {
+		return nil, fmt.Errorf("validation.missing_session must be %s or %s, got %q", MissingSessionReject, MissingSessionAssign, cfg.Validation.MissingSession)
+	}
+	if cfg.RateLimit.Backend != RateLimitBackendRedis && cfg.RateLimit.Backend != RateLimitBackendMemory {
+		return nil, fmt.Errorf("rate_limit.backend must be %s or %s, got %q", RateLimitBackendRedis, RateLimitBackendMemory, cfg.RateLimit.Backend)
+	}
+	switch cfg.Kafka.Compression {
+	case CompressionNone, CompressionGzip, CompressionSnappy, CompressionLz4, CompressionZstd:
+	default:
+		return nil, fmt.Errorf("kafka.compression must be %s, %s, %s, %s or %s, got %q",
+			CompressionNone, CompressionGzip, CompressionSnappy, CompressionLz4, CompressionZstd, cfg.Kafka.Compression)
+	}
+	switch cfg.Kafka.SASL.Mechanism {
+	case "":
+	case SASLMechanismPlain, SASLMechanismScramSHA256, SASLMechanismScramSHA512:
+		if cfg.Kafka.SASL.Username == "" {
+			return nil, fmt.Errorf("kafka.sasl.username is required with kafka.sasl.mechanism %s", cfg.Kafka.SASL.Mechanism)
+		}
+	default:
+		return nil, fmt.Errorf("kafka.sasl.mechanism must be %s, %s or %s, got %q",
+			SASLMechanismPlain, SASLMechanismScramSHA256, SASLMechanismScramSHA512, cfg.Kafka.SASL.Mechanism)
+	}
+	if err := cfg.Kafka.TLS.load(); err != nil {
+		return nil, err
+	}
+
 	return &cfg, nil
 }
+
+func (c *Config) applyDefaults() {
+	if c.Server.GRPCMaxRecvMsgSize <= 0 {
+		c.Server.GRPCMaxRecvMsgSize = DefaultGRPCMaxRecvMsgSize
+	}
+	// Raising the limit indefinitely lets a single client pin large buffers in memory
+	if c.Server.GRPCMaxRecvMsgSize > MaxGRPCMaxRecvMsgSize {
+		c.Server.GRPCMaxRecvMsgSize = MaxGRPCMaxRecvMsgSize
+	}
+	if c.Server.ClientIPHeaders == nil {
+		c.Server.ClientIPHeaders = DefaultClientIPHeaders
+	}
+	// A negative drain skips it
+	if c.Server.ShutdownDrain == 0 {
+		c.Server.ShutdownDrain = DefaultShutdownDrain
+	}
+	if c.Server.ShutdownTimeout <= 0 {
+		c.Server.ShutdownTimeout = DefaultShutdownTimeout
+	}
+	if c.Server.GRPCTLS.ClientCAFile != "" && c.Server.GRPCTLS.ClientAuth == "" {
+		c.Server.GRPCTLS.ClientAuth = ClientAuthRequire
+	}
+	if c.Kafka.Encoding == "" {
+		c.Kafka.Encoding = EncodingJSON
+	}
+	if c.Kafka.Compression == "" {
+		c.Kafka.Compression = CompressionSnappy
+	}
+	if c.RateLimit.Burst <= 0 {
+		c.RateLimit.Burst = c.RateLimit.RequestsPerSecond
+	}
+	if c.RateLimit.IPBurst <= 0 {
+		c.RateLimit.IPBurst = c.RateLimit.IPRequestsPerSecond
+	}
+	if c.RateLimit.Backend == "" {
+		c.RateLimit.Backend = RateLimitBackendRedis
+	}
+	if c.Kafka.SchemaRegistry.Subject == "" {
+		c.Kafka.SchemaRegistry.Subject = c.Kafka.Topics["events"] + "-value"
+	}
+	if c.Batch.MaxEventsPerBatch <= 0 {
+		c.Batch.MaxEventsPerBatch = DefaultMaxEventsPerBatch
+	}
+	if c.Audit.SampleRate <= 0 {
+		c.Audit.SampleRate = 1
+	}
+	if c.Audit.MaxPerSecond <= 0 {
+		c.Audit.MaxPerSecond = 100
+	}
+	if c.Audit.MaxPayloadBytes <= 0 {
+		c.Audit.MaxPayloadBytes = 2048
+	}
+	if c.Postgres.QueryTimeout <= 0 {
+		c.Postgres.QueryTimeout = DefaultPostgresTimeout
+	}
+	if c.Postgres.LastUsedWorkers <= 0 {
+		c.Postgres.LastUsedWorkers = DefaultLastUsedWorkers
+	}
+	if c.Postgres.LastUsedQueue <= 0 {
+		c.Postgres.LastUsedQueue = DefaultLastUsedQueue
+	}
+	if c.Validation.MaxTimestampSkew <= 0 {
+		c.Validation.MaxTimestampSkew = DefaultMaxTimestampSkew
+	}
+	if c.Validation.MaxFieldLength <= 0 {
+		c.Validation.MaxFieldLength = DefaultMaxFieldLength
+	}
+	if c.Validation.MaxEventBytes <= 0 {
+		c.Validation.MaxEventBytes = DefaultMaxEventBytes
+	}
+	if c.Validation.MissingSession == "" {
+		c.Validation.MissingSession = MissingSessionReject
+	}
+	if c.Visitor.CookieName == "" {
+		c.Visitor.CookieName = DefaultVisitorCookie
+	}
+	if c.Visitor.CookieMaxAge <= 0 {
+		c.Visitor.CookieMaxAge = DefaultVisitorCookieAge
+	}
+	if c.Idempotency.Window <= 0 {
+		c.Idempotency.Window = DefaultIdempotencyWindow
+	}
+	if c.ProjectSettings.RefreshInterval <= 0 {
+		c.ProjectSettings.RefreshInterval = DefaultSettingsRefresh
+	}
+	if c.RequestLog.SampleRate <= 0 {
+		c.RequestLog.SampleRate = DefaultRequestLogSample
+	}
+	if c.RequestLog.SlowThreshold <= 0 {
+		c.RequestLog.SlowThreshold = DefaultSlowRequest
+	}
+	if c.RequestLog.AlwaysLogStatuses == nil {
+		c.RequestLog.AlwaysLogStatuses = DefaultAlwaysLogStatuses
+	}
+	if c.WebSocket.MaxMessageBytes <= 0 {
+		c.WebSocket.MaxMessageBytes = DefaultWSMaxMessageBytes
+	}
+	if c.WebSocket.AckInterval <= 0 {
+		c.WebSocket.AckInterval = DefaultWSAckInterval
+	}
+	if c.Consent.Mode == "" {
+		c.Consent.Mode = ConsentModePermissive
+	}
+}
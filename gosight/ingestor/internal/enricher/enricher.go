@@ -1,26 +1,25 @@
 package enricher
 
 import (
+	"io"
 	"net"
 	"time"
 
 	"github.com/mssola/useragent"
-	"github.com/oschwald/geoip2-golang"
 )
 
 type Enricher struct {
-	geoIP *geoip2.Reader
-}
+	geo GeoProvider
 
-func NewEnricher(geoIPPath string) *Enricher {
-	// Try to load GeoIP database
-	var geoIP *geoip2.Reader
-	if geoIPPath != "" {
-		geoIP, _ = geoip2.Open(geoIPPath)
-	}
+	// Count lookups without a database as no_database (see ExpectGeo)
+	geoExpected bool
+	geoMetrics  geoMetrics
+}
 
+// NewEnricher creates an enricher; geo may be nil to skip geo lookups
+func NewEnricher(geo GeoProvider) *Enricher {
 	return &Enricher{
-		geoIP: geoIP,
+		geo: geo,
 	}
 }
 
@@ -35,6 +34,15 @@ type EnrichedEvent struct {
 	Page      map[string]interface{} `json:"page,omitempty"`
 	Payload   map[string]interface{} `json:"payload,omitempty"`
 
+	// Anonymous visitor ID, stable across sessions (see visitor.enabled)
+	AnonymousID string `json:"anonymous_id,omitempty"`
+
+	// QA or synthetic monitoring traffic, excluded from analytics by default
+	Synthetic bool `json:"synthetic,omitempty"`
+
+	// User consent, resolved by the handler (see validation.ResolveConsent)
+	Consent *Consent `json:"consent,omitempty"`
+
 	// Enriched fields
 	ServerTimestamp int64  `json:"server_timestamp"`
 	Browser         string `json:"browser"`
@@ -44,7 +52,23 @@ type EnrichedEvent struct {
 	DeviceType      string `json:"device_type"`
 	Country         string `json:"country"`
 	City            string `json:"city"`
+	GeoAccuracy     string `json:"geo_accuracy,omitempty"` // "city", "country" or empty
 	ClientIP        string `json:"client_ip,omitempty"`
+	UserAgent       string `json:"user_agent,omitempty"`
+}
+
+// Consent is the user's consent to data collection. Without analytics consent
+// the processor stores a reduced event set; without replay consent replay
+// chunks are dropped.
+type Consent struct {
+	Analytics bool `json:"analytics"`
+	Replay    bool `json:"replay"`
+}
+
+// ExpectGeo marks geo enrichment as configured, so events enriched while no
+// GeoIP database is loaded are counted as no_database lookups
+func (e *Enricher) ExpectGeo() {
+	e.geoExpected = true
 }
 
 func (e *Enricher) Enrich(event map[string]interface{}, userAgentString, clientIP string) *EnrichedEvent {
@@ -95,6 +119,9 @@ func (e *Enricher) Enrich(event map[string]interface{}, userAgentString, clientI
 	if v, ok := event["payload"].(map[string]interface{}); ok {
 		enriched.Payload = v
 	}
+	if v, ok := event["synthetic"].(bool); ok {
+		enriched.Synthetic = v
+	}
 
 	// Parse user agent
 	if userAgentString != "" {
@@ -105,24 +132,49 @@ func (e *Enricher) Enrich(event map[string]interface{}, userAgentString, clientI
 	}
 
 	// GeoIP lookup
-	if e.geoIP != nil && clientIP != "" {
-		ip := net.ParseIP(clientIP)
-		if ip != nil {
-			record, err := e.geoIP.City(ip)
-			if err == nil {
-				enriched.Country = record.Country.IsoCode
-				if name, ok := record.City.Names["en"]; ok {
-					enriched.City = name
-				}
-			}
-		}
+	if clientIP != "" {
+		e.lookupGeo(enriched, clientIP)
 	}
 
 	enriched.ClientIP = clientIP
+	enriched.UserAgent = userAgentString
 
 	return enriched
 }
 
+func (e *Enricher) lookupGeo(enriched *EnrichedEvent, clientIP string) {
+	if e.geo == nil {
+		if e.geoExpected {
+			e.geoMetrics.inc(GeoLookupNoDatabase)
+		}
+		return
+	}
+
+	ip := net.ParseIP(clientIP)
+	if ip == nil {
+		return
+	}
+
+	result, err := e.geo.Lookup(ip)
+	if err != nil {
+		e.geoMetrics.inc(GeoLookupError)
+		return
+	}
+
+	enriched.Country = result.Country
+	enriched.City = result.City
+	enriched.GeoAccuracy = result.Accuracy
+
+	switch result.Accuracy {
+	case GeoAccuracyCity:
+		e.geoMetrics.inc(GeoLookupCity)
+	case GeoAccuracyCountry:
+		e.geoMetrics.inc(GeoLookupCountry)
+	default:
+		e.geoMetrics.inc(GeoLookupNotFound)
+	}
+}
+
 func getDeviceType(ua *useragent.UserAgent) string {
 	if ua.Mobile() {
 		return "mobile"
@@ -134,7 +186,7 @@ func getDeviceType(ua *useragent.UserAgent) string {
 }
 
 func (e *Enricher) Close() {
-	if e.geoIP != nil {
-		e.geoIP.Close()
+	if closer, ok := e.geo.(io.Closer); ok {
+		closer.Close()
 	}
 }
@@ -0,0 +1,175 @@
+package enricher
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+
+	"github.com/oschwald/geoip2-golang"
+	"github.com/rs/zerolog/log"
+
+	"github.com/gosight/gosight/ingestor/internal/config"
+)
+
+// Geo accuracy levels reported in GeoResult.Accuracy
+const (
+	GeoAccuracyNone    = ""
+	GeoAccuracyCountry = "country"
+	GeoAccuracyCity    = "city"
+)
+
+// GeoProvider resolves an IP address to a location
+type GeoProvider interface {
+	Lookup(ip net.IP) (GeoResult, error)
+}
+
+// GeoResult is the location of an IP address
+type GeoResult struct {
+	Country  string // ISO country code
+	City     string // English city name
+	Accuracy string // GeoAccuracyCity, GeoAccuracyCountry or GeoAccuracyNone
+}
+
+// NewGeoProvider creates the geo provider selected by config.
+// It returns nil (no geo enrichment) when the provider is disabled or has no database.
+// With several MaxMind databases, they are tried in order (see GeoChain); databases
+// that fail to open are logged and skipped, and only if none opens is an error returned.
+func NewGeoProvider(cfg config.GeoIPConfig) (GeoProvider, error) {
+	switch cfg.Provider {
+	case "", "maxmind":
+		databases := cfg.Databases
+		if cfg.DatabasePath != "" {
+			databases = append([]config.GeoIPDatabaseConfig{{Path: cfg.DatabasePath}}, databases...)
+		}
+		if len(databases) == 0 {
+			return nil, nil
+		}
+
+		var chain GeoChain
+		for _, db := range databases {
+			provider, err := NewMaxMindProvider(db.Path, db.Type)
+			if err != nil {
+				log.Warn().Err(err).Str("path", db.Path).Msg("Failed to open GeoIP database")
+				continue
+			}
+			log.Info().Str("path", db.Path).Str("level", provider.level).Msg("Loaded GeoIP database")
+			chain = append(chain, provider)
+		}
+
+		switch len(chain) {
+		case 0:
+			return nil, errors.New("no GeoIP database could be opened")
+		case 1:
+			return chain[0], nil
+		default:
+			return chain, nil
+		}
+	case "none":
+		return nil, nil
+	default:
+		return nil, fmt.Errorf("unknown geo provider %q", cfg.Provider)
+	}
+}
+
+// GeoChain tries providers in order and returns the first city-level result,
+// falling back to the first country-level one (e.g. a city DB, then a country DB)
+type GeoChain []GeoProvider
+
+func (c GeoChain) Lookup(ip net.IP) (GeoResult, error) {
+	var best GeoResult
+	var lastErr error
+	for _, provider := range c {
+		result, err := provider.Lookup(ip)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		if result.Accuracy == GeoAccuracyCity {
+			return result, nil
+		}
+		if best.Accuracy == GeoAccuracyNone {
+			best = result
+		}
+	}
+
+	if best.Accuracy == GeoAccuracyNone && lastErr != nil {
+		return GeoResult{}, lastErr
+	}
+	return best, nil
+}
+
+func (c GeoChain) Close() error {
+	for _, provider := range c {
+		if p, ok := provider.(*MaxMindProvider); ok {
+			p.Close()
+		}
+	}
+	return nil
+}
+
+// MaxMindProvider looks up locations in a MaxMind GeoIP2/GeoLite2 City or Country database
+type MaxMindProvider struct {
+	reader *geoip2.Reader
+	level  string // GeoAccuracyCity or GeoAccuracyCountry
+}
+
+// NewMaxMindProvider opens the .mmdb database at path. dbType is "city" or
+// "country"; when empty it is detected from the database metadata.
+func NewMaxMindProvider(path, dbType string) (*MaxMindProvider, error) {
+	reader, err := geoip2.Open(path)
+	if err != nil {
+		return nil, err
+	}
+
+	level := strings.ToLower(dbType)
+	if level == "" {
+		level = GeoAccuracyCity
+		if !strings.Contains(reader.Metadata().DatabaseType, "City") {
+			level = GeoAccuracyCountry
+		}
+	}
+	if level != GeoAccuracyCity && level != GeoAccuracyCountry {
+		reader.Close()
+		return nil, fmt.Errorf("unknown GeoIP database type %q", dbType)
+	}
+
+	return &MaxMindProvider{reader: reader, level: level}, nil
+}
+
+func (p *MaxMindProvider) Lookup(ip net.IP) (GeoResult, error) {
+	if p.level == GeoAccuracyCountry {
+		record, err := p.reader.Country(ip)
+		if err != nil {
+			return GeoResult{}, err
+		}
+		return countryResult(record.Country.IsoCode), nil
+	}
+
+	record, err := p.reader.City(ip)
+	if err != nil {
+		return GeoResult{}, err
+	}
+
+	name, ok := record.City.Names["en"]
+	if !ok || name == "" {
+		return countryResult(record.Country.IsoCode), nil
+	}
+	return GeoResult{
+		Country:  record.Country.IsoCode,
+		City:     name,
+		Accuracy: GeoAccuracyCity,
+	}, nil
+}
+
+func (p *MaxMindProvider) Close() error {
+	return p.reader.Close()
+}
+
+// countryResult is a country-level result, or an empty one if the country is unknown
+func countryResult(country string) GeoResult {
+	if country == "" {
+		return GeoResult{}
+	}
+	return GeoResult{Country: country, Accuracy: GeoAccuracyCountry}
+}
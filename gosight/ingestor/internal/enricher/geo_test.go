@@ -0,0 +1,111 @@
+package enricher
+
+import (
+	"errors"
+	"net"
+	"testing"
+
+	"github.com/gosight/gosight/ingestor/internal/config"
+)
+
+// fakeGeo resolves IPs from a fixed table; unknown IPs are not found, and
+// IPs in errs fail
+type fakeGeo struct {
+	results map[string]GeoResult
+	errs    map[string]error
+	lookups []string
+}
+
+func (f *fakeGeo) Lookup(ip net.IP) (GeoResult, error) {
+	f.lookups = append(f.lookups, ip.String())
+	if err := f.errs[ip.String()]; err != nil {
+		return GeoResult{}, err
+	}
+	return f.results[ip.String()], nil
+}
+
+func TestEnrichUsesGeoProvider(t *testing.T) {
+	geo := &fakeGeo{results: map[string]GeoResult{
+		"203.0.113.7": {Country: "VN", City: "Hanoi", Accuracy: GeoAccuracyCity},
+		"203.0.113.8": {Country: "DE", Accuracy: GeoAccuracyCountry},
+	}, errs: map[string]error{
+		"203.0.113.9": errors.New("corrupt database"),
+	}}
+	e := NewEnricher(geo)
+
+	tests := []struct {
+		ip                      string
+		country, city, accuracy string
+	}{
+		{"203.0.113.7", "VN", "Hanoi", GeoAccuracyCity},
+		{"203.0.113.8", "DE", "", GeoAccuracyCountry},
+		{"198.51.100.1", "", "", GeoAccuracyNone},
+		{"203.0.113.9", "", "", GeoAccuracyNone},
+	}
+	for _, tt := range tests {
+		enriched := e.Enrich(map[string]interface{}{"type": "click"}, "", tt.ip)
+		if enriched.Country != tt.country || enriched.City != tt.city || enriched.GeoAccuracy != tt.accuracy {
+			t.Errorf("%s: got %q/%q/%q, want %q/%q/%q", tt.ip,
+				enriched.Country, enriched.City, enriched.GeoAccuracy, tt.country, tt.city, tt.accuracy)
+		}
+	}
+
+	// Unparsable IPs are not looked up
+	e.Enrich(map[string]interface{}{"type": "click"}, "", "not-an-ip")
+	if len(geo.lookups) != len(tests) {
+		t.Errorf("looked up %v, want only the valid IPs", geo.lookups)
+	}
+
+	lookups := e.GeoLookups()
+	want := map[string]uint64{GeoLookupCity: 1, GeoLookupCountry: 1, GeoLookupNotFound: 1, GeoLookupError: 1, GeoLookupNoDatabase: 0}
+	for result, n := range want {
+		if lookups[result] != n {
+			t.Errorf("%s lookups = %d, want %d", result, lookups[result], n)
+		}
+	}
+}
+
+func TestEnrichWithoutGeoProvider(t *testing.T) {
+	e := NewEnricher(nil)
+	e.ExpectGeo()
+
+	enriched := e.Enrich(map[string]interface{}{"type": "click"}, "", "203.0.113.7")
+	if enriched.Country != "" || enriched.GeoAccuracy != GeoAccuracyNone {
+		t.Errorf("got %q/%q without a provider", enriched.Country, enriched.GeoAccuracy)
+	}
+	if n := e.GeoLookups()[GeoLookupNoDatabase]; n != 1 {
+		t.Errorf("no_database lookups = %d, want 1", n)
+	}
+}
+
+func TestGeoChainPrefersCityResults(t *testing.T) {
+	ip := net.ParseIP("203.0.113.7")
+	country := &fakeGeo{results: map[string]GeoResult{"203.0.113.7": {Country: "VN", Accuracy: GeoAccuracyCountry}}}
+	city := &fakeGeo{results: map[string]GeoResult{"203.0.113.7": {Country: "VN", City: "Hanoi", Accuracy: GeoAccuracyCity}}}
+	broken := &fakeGeo{errs: map[string]error{"203.0.113.7": errors.New("corrupt database")}}
+
+	if result, err := (GeoChain{broken, country, city}).Lookup(ip); err != nil || result.City != "Hanoi" {
+		t.Errorf("Lookup = %+v, %v, want the city result", result, err)
+	}
+	if result, err := (GeoChain{country, broken}).Lookup(ip); err != nil || result.Accuracy != GeoAccuracyCountry {
+		t.Errorf("Lookup = %+v, %v, want the country result", result, err)
+	}
+	if _, err := (GeoChain{broken}).Lookup(ip); err == nil {
+		t.Error("Lookup succeeded with every provider failing")
+	}
+}
+
+func TestNewGeoProvider(t *testing.T) {
+	if p, err := NewGeoProvider(config.GeoIPConfig{Provider: "none", DatabasePath: "GeoLite2-City.mmdb"}); p != nil || err != nil {
+		t.Errorf("provider none = %v, %v, want no provider", p, err)
+	}
+	if p, err := NewGeoProvider(config.GeoIPConfig{}); p != nil || err != nil {
+		t.Errorf("no database = %v, %v, want no provider", p, err)
+	}
+	if _, err := NewGeoProvider(config.GeoIPConfig{Provider: "ipinfo"}); err == nil {
+		t.Error("unknown provider accepted")
+	}
+	if _, err := NewGeoProvider(config.GeoIPConfig{DatabasePath: "/nonexistent/GeoLite2-City.mmdb"}); err == nil {
+		t.Error("missing database accepted")
+	}
+}
@@ -0,0 +1,52 @@
+package enricher
+
+import (
+	"fmt"
+	"io"
+	"sync/atomic"
+)
+
+// GeoIP lookup results counted by the enricher
+const (
+	GeoLookupCity       = "city"        // resolved to a city
+	GeoLookupCountry    = "country"     // resolved to a country only
+	GeoLookupNotFound   = "not_found"   // IP not covered by any database
+	GeoLookupError      = "error"       // lookup failed (e.g. corrupt database)
+	GeoLookupNoDatabase = "no_database" // geo enrichment configured but no database loaded
+)
+
+var geoLookupResults = []string{GeoLookupCity, GeoLookupCountry, GeoLookupNotFound, GeoLookupError, GeoLookupNoDatabase}
+
+// geoMetrics counts GeoIP lookups by result
+type geoMetrics struct {
+	counts [5]atomic.Uint64 // indexed like geoLookupResults
+}
+
+func (m *geoMetrics) inc(result string) {
+	for i, r := range geoLookupResults {
+		if r == result {
+			m.counts[i].Add(1)
+			return
+		}
+	}
+}
+
+// GeoLookups returns the number of GeoIP lookups per result since startup
+func (e *Enricher) GeoLookups() map[string]uint64 {
+	lookups := make(map[string]uint64, len(geoLookupResults))
+	for i, r := range geoLookupResults {
+		lookups[r] = e.geoMetrics.counts[i].Load()
+	}
+	return lookups
+}
+
+// WriteMetrics writes the enricher's metrics in the Prometheus text format.
+// A rising error or not_found share (or any no_database) points at a bad or
+// stale GeoIP database.
+func (e *Enricher) WriteMetrics(w io.Writer) {
+	fmt.Fprintln(w, "# HELP gosight_ingestor_geoip_lookups_total GeoIP lookups by result.")
+	fmt.Fprintln(w, "# TYPE gosight_ingestor_geoip_lookups_total counter")
+	for i, r := range geoLookupResults {
+		fmt.Fprintf(w, "gosight_ingestor_geoip_lookups_total{result=%q} %d\n", r, e.geoMetrics.counts[i].Load())
+	}
+}
@@ -0,0 +1,79 @@
+package handler
+
+import (
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+)
+
+// RealIP sets r.RemoteAddr to the client IP: the first valid IP of headers,
+// tried in order, or else the peer address. X-Forwarded-For holds a chain
+// ("client, proxy1, proxy2") with an entry appended by each proxy; the client
+// is taken forwardedForHops entries from the right, so entries a client
+// prepended are ignored, or the leftmost valid entry when forwardedForHops is
+// 0. Only list headers set (and overwritten) by your own proxies or CDN; any
+// other can be spoofed by clients.
+func RealIP(headers []string, forwardedForHops int) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			for _, header := range headers {
+				if ip, ok := headerIP(r, header, forwardedForHops); ok {
+					r.RemoteAddr = ip.String()
+					break
+				}
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// headerIP parses the client IP of a header
+func headerIP(r *http.Request, header string, forwardedForHops int) (netip.Addr, bool) {
+	value := r.Header.Get(header)
+	if value == "" {
+		return netip.Addr{}, false
+	}
+	if !strings.EqualFold(header, "X-Forwarded-For") {
+		return parseIP(value)
+	}
+
+	// Proxies may also send separate X-Forwarded-For headers
+	var chain []string
+	for _, v := range r.Header.Values(header) {
+		chain = append(chain, strings.Split(v, ",")...)
+	}
+	if forwardedForHops > 0 {
+		if forwardedForHops > len(chain) {
+			return netip.Addr{}, false
+		}
+		return parseIP(chain[len(chain)-forwardedForHops])
+	}
+	for _, entry := range chain {
+		if ip, ok := parseIP(entry); ok {
+			return ip, true
+		}
+	}
+	return netip.Addr{}, false
+}
+
+// parseIP parses an IP, with or without a port
+func parseIP(s string) (netip.Addr, bool) {
+	s = strings.TrimSpace(s)
+	if host, _, err := net.SplitHostPort(s); err == nil {
+		s = host
+	}
+	ip, err := netip.ParseAddr(s)
+	if err != nil {
+		return netip.Addr{}, false
+	}
+	return ip.Unmap(), true
+}
+
+// clientIP returns the client IP of a request, as set by RealIP
+func clientIP(r *http.Request) string {
+	if ip, ok := parseIP(r.RemoteAddr); ok {
+		return ip.String()
+	}
+	return r.RemoteAddr
+}
@@ -0,0 +1,92 @@
+package handler
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestRealIP(t *testing.T) {
+	tests := []struct {
+		name    string
+		headers []string
+		hops    int
+		request map[string][]string
+		want    string
+	}{
+		{
+			name:    "cloudflare",
+			headers: []string{"CF-Connecting-IP"},
+			request: map[string][]string{"CF-Connecting-IP": {"203.0.113.7"}, "X-Real-IP": {"198.51.100.66"}},
+			want:    "203.0.113.7",
+		},
+		{
+			name:    "akamai, spoofed headers ignored",
+			headers: []string{"True-Client-IP"},
+			request: map[string][]string{"True-Client-IP": {"2001:db8::7"}, "X-Forwarded-For": {"198.51.100.66"}},
+			want:    "2001:db8::7",
+		},
+		{
+			name:    "first valid header wins",
+			headers: []string{"True-Client-IP", "X-Real-IP"},
+			request: map[string][]string{"True-Client-IP": {"unknown"}, "X-Real-IP": {"203.0.113.7"}},
+			want:    "203.0.113.7",
+		},
+		{
+			name:    "load balancer appending to X-Forwarded-For",
+			headers: []string{"X-Forwarded-For"},
+			hops:    1,
+			request: map[string][]string{"X-Forwarded-For": {"198.51.100.66, 203.0.113.7"}},
+			want:    "203.0.113.7",
+		},
+		{
+			name:    "X-Forwarded-For over several headers",
+			headers: []string{"X-Forwarded-For"},
+			hops:    2,
+			request: map[string][]string{"X-Forwarded-For": {"198.51.100.66, 203.0.113.7", "10.0.0.2"}},
+			want:    "203.0.113.7",
+		},
+		{
+			name:    "leftmost valid X-Forwarded-For entry",
+			headers: []string{"X-Forwarded-For"},
+			request: map[string][]string{"X-Forwarded-For": {"garbage, 203.0.113.7:4711, 10.0.0.2"}},
+			want:    "203.0.113.7",
+		},
+		{
+			name:    "shorter chain than the hops",
+			headers: []string{"X-Forwarded-For"},
+			hops:    3,
+			request: map[string][]string{"X-Forwarded-For": {"203.0.113.7"}},
+			want:    "192.0.2.1",
+		},
+		{
+			name:    "no headers configured",
+			request: map[string][]string{"X-Real-IP": {"203.0.113.7"}},
+			want:    "192.0.2.1",
+		},
+		{
+			name:    "IPv4-mapped address",
+			headers: []string{"X-Real-IP"},
+			request: map[string][]string{"X-Real-IP": {"::ffff:203.0.113.7"}},
+			want:    "203.0.113.7",
+		},
+	}
+
+	for _, tt := range tests {
+		r := httptest.NewRequest(http.MethodPost, "/v1/events", nil) // from 192.0.2.1:1234
+		for header, values := range tt.request {
+			for _, v := range values {
+				r.Header.Add(header, v)
+			}
+		}
+
+		var got string
+		RealIP(tt.headers, tt.hops)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			got = clientIP(r)
+		})).ServeHTTP(httptest.NewRecorder(), r)
+
+		if got != tt.want {
+			t.Errorf("%s: client IP = %s, want %s", tt.name, got, tt.want)
+		}
+	}
+}
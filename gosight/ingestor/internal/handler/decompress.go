@@ -0,0 +1,113 @@
+package handler
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"io"
+)
+
+var errNothingRecovered = errors.New("no complete events recovered")
+
+// isGzip checks for the gzip magic bytes (0x1f 0x8b)
+func isGzip(body []byte) bool {
+	return len(body) >= 2 && body[0] == 0x1f && body[1] == 0x8b
+}
+
+// decompressTolerant decompresses a gzip body, keeping whatever decompressed
+// cleanly when the stream is cut off (e.g. dropped mobile connections).
+// truncated reports whether the stream ended early.
+func decompressTolerant(rawBody []byte) (body []byte, truncated bool, err error) {
+	reader, err := gzip.NewReader(bytes.NewReader(rawBody))
+	if err != nil {
+		return nil, false, err
+	}
+	defer reader.Close()
+
+	var buf bytes.Buffer
+	if _, err := io.Copy(&buf, reader); err != nil {
+		if buf.Len() == 0 {
+			return nil, true, err
+		}
+		return buf.Bytes(), true, nil
+	}
+	return buf.Bytes(), false, nil
+}
+
+// salvageEventBatch recovers the complete events from truncated JSON. It
+// accepts both the batch object ({"project_key":...,"events":[...]}) and a
+// bare top-level array of events, stopping at the first incomplete element.
+func salvageEventBatch(body []byte) (*EventBatchRequest, error) {
+	dec := json.NewDecoder(bytes.NewReader(body))
+
+	tok, err := dec.Token()
+	if err != nil {
+		return nil, err
+	}
+
+	req := &EventBatchRequest{}
+	switch tok {
+	case json.Delim('['):
+		req.Events = salvageEvents(dec)
+	case json.Delim('{'):
+		for dec.More() {
+			keyTok, err := dec.Token()
+			if err != nil {
+				break
+			}
+			key, _ := keyTok.(string)
+
+			if key == "events" {
+				if tok, err := dec.Token(); err != nil || tok != json.Delim('[') {
+					break
+				}
+				req.Events = salvageEvents(dec)
+				// Anything after a cut-off events array is lost
+				break
+			}
+
+			var value interface{}
+			if err := dec.Decode(&value); err != nil {
+				break
+			}
+			if key == "synthetic" {
+				req.Synthetic, _ = value.(bool)
+				continue
+			}
+			if key == "consent" {
+				req.Consent, _ = value.(map[string]interface{})
+				continue
+			}
+			s, _ := value.(string)
+			switch key {
+			case "project_key":
+				req.ProjectKey = s
+			case "session_id":
+				req.SessionID = s
+			case "user_id":
+				req.UserID = s
+			}
+		}
+	default:
+		return nil, errors.New("unexpected JSON value")
+	}
+
+	if len(req.Events) == 0 {
+		return nil, errNothingRecovered
+	}
+	return req, nil
+}
+
+// salvageEvents decodes array elements until the first one that is incomplete
+func salvageEvents(dec *json.Decoder) []map[string]interface{} {
+	var events []map[string]interface{}
+	for dec.More() {
+		var event map[string]interface{}
+		if err := dec.Decode(&event); err != nil {
+			break
+		}
+		events = append(events, event)
+	}
+	return events
+}
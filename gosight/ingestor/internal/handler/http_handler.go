@@ -8,10 +8,15 @@ import (
 	"io"
 	"log"
 	"net/http"
+	"strconv"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/google/uuid"
 
+	"github.com/gosight/gosight/ingestor/internal/audit"
+	"github.com/gosight/gosight/ingestor/internal/config"
 	"github.com/gosight/gosight/ingestor/internal/enricher"
 	"github.com/gosight/gosight/ingestor/internal/producer"
 	"github.com/gosight/gosight/ingestor/internal/validation"
@@ -21,13 +26,17 @@ type HTTPHandler struct {
 	producer  *producer.KafkaProducer
 	validator *validation.Validator
 	enricher  *enricher.Enricher
+	auditor   *audit.Auditor
+	batchCfg  config.BatchConfig
 }
 
-func NewHTTPHandler(p *producer.KafkaProducer, v *validation.Validator, e *enricher.Enricher) *HTTPHandler {
+func NewHTTPHandler(p *producer.KafkaProducer, v *validation.Validator, e *enricher.Enricher, a *audit.Auditor, batchCfg config.BatchConfig) *HTTPHandler {
 	return &HTTPHandler{
 		producer:  p,
 		validator: v,
 		enricher:  e,
+		auditor:   a,
+		batchCfg:  batchCfg,
 	}
 }
 
@@ -36,13 +45,29 @@ type EventBatchRequest struct {
 	SessionID  string                   `json:"session_id"`
 	UserID     string                   `json:"user_id"`
 	Events     []map[string]interface{} `json:"events"`
+	// Marks every event of the batch synthetic (QA, synthetic monitoring)
+	Synthetic bool `json:"synthetic,omitempty"`
+	// Consent flags of the batch's events; an event's own consent overrides them
+	Consent map[string]interface{} `json:"consent,omitempty"`
+	// Anonymous visitor ID of the batch's events, for clients without the
+	// visitor cookie (see visitor.enabled)
+	AnonymousID string `json:"anonymous_id,omitempty"`
 }
 
+// EventResponse reports the outcome of a batch. Success is false only when the
+// client should send the batch again: some events failed for a transient
+// reason (RetryableCount) or the body was truncated, so the events after the
+// first AcceptedCount+RejectedCount never arrived. Invalid events are counted
+// in RejectedCount but don't fail the batch, as sending them again can't help.
 type EventResponse struct {
-	Success       bool     `json:"success"`
-	AcceptedCount int      `json:"accepted_count"`
-	RejectedCount int      `json:"rejected_count"`
-	Errors        []string `json:"errors,omitempty"`
+	Success        bool     `json:"success"`
+	AcceptedCount  int      `json:"accepted_count"`
+	RejectedCount  int      `json:"rejected_count"`
+	RetryableCount int      `json:"retryable_count,omitempty"` // rejected, but may be sent again (e.g. Kafka unavailable)
+	DuplicateCount int      `json:"duplicate_count,omitempty"` // accepted, but dropped as a repeated idempotency_key
+	Truncated      bool     `json:"truncated,omitempty"`
+	Errors         []string `json:"errors,omitempty"`
+	LimitType      string   `json:"limit_type,omitempty"` // which limit rejected the request
 }
 
 func (h *HTTPHandler) HandleEvents(w http.ResponseWriter, r *http.Request) {
@@ -54,16 +79,11 @@ func (h *HTTPHandler) HandleEvents(w http.ResponseWriter, r *http.Request) {
 	}
 	defer r.Body.Close()
 
-	// Auto-detect and decompress gzip by checking magic bytes (0x1f 0x8b)
+	// Auto-detect and decompress gzip, keeping what decompressed if the stream was cut off
 	var body []byte
-	if len(rawBody) >= 2 && rawBody[0] == 0x1f && rawBody[1] == 0x8b {
-		reader, err := gzip.NewReader(bytes.NewReader(rawBody))
-		if err != nil {
-			http.Error(w, "Invalid gzip", http.StatusBadRequest)
-			return
-		}
-		defer reader.Close()
-		body, err = io.ReadAll(reader)
+	truncated := false
+	if isGzip(rawBody) {
+		body, truncated, err = decompressTolerant(rawBody)
 		if err != nil {
 			http.Error(w, "Failed to decompress", http.StatusBadRequest)
 			return
@@ -72,16 +92,47 @@ func (h *HTTPHandler) HandleEvents(w http.ResponseWriter, r *http.Request) {
 		body = rawBody
 	}
 
-	// Parse request
+	// Parse request, salvaging complete events from a truncated body
 	var req EventBatchRequest
 	if err := json.Unmarshal(body, &req); err != nil {
-		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		if !truncated {
+			http.Error(w, "Invalid JSON", http.StatusBadRequest)
+			return
+		}
+		salvaged, err := salvageEventBatch(body)
+		if err != nil {
+			http.Error(w, "Failed to decompress", http.StatusBadRequest)
+			return
+		}
+		req = *salvaged
+		if req.ProjectKey == "" {
+			req.ProjectKey = r.Header.Get("X-Project-Key")
+		}
+		log.Printf("[Events] Truncated gzip body, recovered %d events", len(req.Events))
+	} else {
+		// Body decompressed to complete JSON after all
+		truncated = false
+	}
+
+	// Client IP rate limiting, before the API key lookup
+	clientIP := clientIP(r)
+	if limit := h.validator.CheckIPRateLimit(clientIP); !limit.Allowed {
+		h.auditor.Record("", limit.Message, "http", len(req.Events), req.Events)
+		w.Header().Set("Content-Type", "application/json")
+		setRetryAfter(w, limit.RetryAfter)
+		w.WriteHeader(http.StatusTooManyRequests)
+		json.NewEncoder(w).Encode(EventResponse{
+			Success:   false,
+			Errors:    []string{limit.Message},
+			LimitType: limit.LimitType,
+		})
 		return
 	}
 
 	// Validate API key
-	projectID, err := h.validator.ValidateAPIKey(r.Context(), req.ProjectKey)
+	key, err := h.validator.ValidateAPIKey(r.Context(), req.ProjectKey)
 	if err != nil {
+		h.auditor.Record("", "Invalid API key", "http", len(req.Events), req.Events)
 		w.Header().Set("Content-Type", "application/json")
 		w.WriteHeader(http.StatusUnauthorized)
 		json.NewEncoder(w).Encode(EventResponse{
@@ -90,64 +141,135 @@ func (h *HTTPHandler) HandleEvents(w http.ResponseWriter, r *http.Request) {
 		})
 		return
 	}
+	projectID := key.ProjectID
 
-	// Rate limiting
-	if !h.validator.CheckRateLimit(projectID) {
+	// Batch size limit
+	if limit := h.validator.CheckBatchSize(len(req.Events)); !limit.Allowed {
+		h.auditor.Record(projectID, limit.Message, "http", len(req.Events), req.Events)
 		w.Header().Set("Content-Type", "application/json")
-		w.WriteHeader(http.StatusTooManyRequests)
+		w.WriteHeader(http.StatusRequestEntityTooLarge)
 		json.NewEncoder(w).Encode(EventResponse{
-			Success: false,
-			Errors:  []string{"Rate limit exceeded"},
+			Success:       false,
+			RejectedCount: len(req.Events),
+			Errors:        []string{limit.Message},
+			LimitType:     limit.LimitType,
 		})
 		return
 	}
 
-	// Get client IP for enrichment
-	clientIP := r.Header.Get("X-Real-IP")
-	if clientIP == "" {
-		clientIP = r.Header.Get("X-Forwarded-For")
-	}
-	if clientIP == "" {
-		clientIP = r.RemoteAddr
+	// Rate limiting
+	if limit := h.validator.CheckRateLimit(projectID); !limit.Allowed {
+		h.auditor.Record(projectID, limit.Message, "http", len(req.Events), req.Events)
+		w.Header().Set("Content-Type", "application/json")
+		setRetryAfter(w, limit.RetryAfter)
+		w.WriteHeader(http.StatusTooManyRequests)
+		json.NewEncoder(w).Encode(EventResponse{
+			Success:   false,
+			Errors:    []string{limit.Message},
+			LimitType: limit.LimitType,
+		})
+		return
 	}
 
 	// Get User-Agent
 	userAgent := r.Header.Get("User-Agent")
 
+	// Anonymous visitor ID, set as a cookie with the batch's analytics consent
+	visitorID := h.validator.VisitorCookie(w, r, h.validator.ResolveConsent(req.Consent))
+
 	// Process events
 	accepted := 0
 	rejected := 0
+	retryable := 0
+	duplicates := 0
 	var errors []string
+	sessions := h.validator.NewSessionAssigner()
 
 	for _, event := range req.Events {
-		// Add metadata
+		// Validate event
+		if err := h.validator.ValidateEvent(event); err != nil {
+			h.auditor.Record(projectID, err.Error(), "http", 1, event)
+			rejected++
+			errors = append(errors, err.Error())
+			continue
+		}
+
+		// Add metadata. A batch can span sessions (e.g. a session rolled over
+		// mid-batch), so events carrying their own session keep it.
 		event["project_id"] = projectID
-		event["session_id"] = req.SessionID
-		event["user_id"] = req.UserID
+		if sessionID, _ := event["session_id"].(string); sessionID == "" || h.batchCfg.OverwriteEventSession {
+			event["session_id"] = req.SessionID
+			event["user_id"] = req.UserID
+		} else if userID, _ := event["user_id"].(string); userID == "" {
+			event["user_id"] = req.UserID
+		}
+		if err := sessions.Assign(event); err != nil {
+			h.auditor.Record(projectID, err.Error(), "http", 1, event)
+			rejected++
+			errors = append(errors, err.Error())
+			continue
+		}
 		if event["event_id"] == nil {
 			event["event_id"] = uuid.New().String()
 		}
+		if req.Synthetic || key.Test {
+			event["synthetic"] = true
+		}
+
+		// Truncate oversized fields, reject events still too large
+		if err := h.validator.LimitEventSize(event); err != nil {
+			h.auditor.Record(projectID, err.Error(), "http", 1, event)
+			rejected++
+			errors = append(errors, err.Error())
+			continue
+		}
+
+		// Client double-fire of an event already produced
+		idemKey := validation.IdempotencyKey(event)
+		if !h.validator.ClaimIdempotencyKey(r.Context(), projectID, idemKey) {
+			accepted++
+			duplicates++
+			continue
+		}
 
 		// Enrich event
 		enrichedEvent := h.enricher.Enrich(event, userAgent, clientIP)
+		eventConsent, _ := event["consent"].(map[string]interface{})
+		consent := h.validator.ResolveConsent(eventConsent, req.Consent)
+		enrichedEvent.Consent = &consent
+		eventAnonymousID, _ := event["anonymous_id"].(string)
+		enrichedEvent.AnonymousID = h.validator.AnonymousID(r, consent, eventAnonymousID, req.AnonymousID, visitorID)
 
 		// Produce to Kafka
-		err := h.producer.ProduceEvent(r.Context(), projectID, enrichedEvent)
+		err := h.producer.ProduceEvent(r.Context(), projectID, enrichedEvent.SessionID, enrichedEvent.Type, enrichedEvent)
 		if err != nil {
+			h.validator.ReleaseIdempotencyKey(r.Context(), projectID, idemKey)
+			h.auditor.Record(projectID, err.Error(), "http", 1, event)
 			rejected++
+			retryable++
 			errors = append(errors, err.Error())
 			continue
 		}
 		accepted++
 	}
 
+	if truncated {
+		errors = append(errors, "Request body truncated, only complete events were accepted")
+	}
+	if rejected > 0 || truncated {
+		ForceLog(r)
+	}
+
 	// Response
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(EventResponse{
-		Success:       rejected == 0,
-		AcceptedCount: accepted,
-		RejectedCount: rejected,
-		Errors:        errors,
+		Success:        retryable == 0 && !truncated,
+		AcceptedCount:  accepted,
+		RejectedCount:  rejected,
+		RetryableCount: retryable,
+		DuplicateCount: duplicates,
+		Truncated:      truncated,
+		Errors:         errors,
 	})
 }
 
@@ -159,6 +281,8 @@ type ReplayChunkRequest struct {
 	TimestampEnd    int64         `json:"timestamp_end"`
 	Events          []interface{} `json:"events"` // Raw rrweb events (gzip compressed at transport level)
 	HasFullSnapshot bool          `json:"has_full_snapshot"`
+	// Chunks without replay consent are dropped (see consent.mode)
+	Consent map[string]interface{} `json:"consent,omitempty"`
 }
 
 func (h *HTTPHandler) HandleReplay(w http.ResponseWriter, r *http.Request) {
@@ -200,8 +324,22 @@ func (h *HTTPHandler) HandleReplay(w http.ResponseWriter, r *http.Request) {
 	}
 	log.Printf("[Replay] Parsed: sessionID=%s, chunkIndex=%d, events=%d", req.SessionID, req.ChunkIndex, len(req.Events))
 
+	// Client IP rate limiting, before the API key lookup
+	if limit := h.validator.CheckIPRateLimit(clientIP(r)); !limit.Allowed {
+		log.Println("[Replay] Client IP rate limit exceeded")
+		w.Header().Set("Content-Type", "application/json")
+		setRetryAfter(w, limit.RetryAfter)
+		w.WriteHeader(http.StatusTooManyRequests)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"success":    false,
+			"message":    limit.Message,
+			"limit_type": limit.LimitType,
+		})
+		return
+	}
+
 	// Validate API key
-	projectID, err := h.validator.ValidateAPIKey(r.Context(), req.ProjectKey)
+	key, err := h.validator.ValidateAPIKey(r.Context(), req.ProjectKey)
 	if err != nil {
 		log.Printf("[Replay] Invalid API key: %v", err)
 		w.Header().Set("Content-Type", "application/json")
@@ -212,16 +350,41 @@ func (h *HTTPHandler) HandleReplay(w http.ResponseWriter, r *http.Request) {
 		})
 		return
 	}
+	projectID := key.ProjectID
 	log.Printf("[Replay] Validated projectID=%s", projectID)
 
 	// Rate limiting
-	if !h.validator.CheckRateLimit(projectID) {
+	if limit := h.validator.CheckRateLimit(projectID); !limit.Allowed {
 		log.Println("[Replay] Rate limit exceeded")
 		w.Header().Set("Content-Type", "application/json")
+		setRetryAfter(w, limit.RetryAfter)
 		w.WriteHeader(http.StatusTooManyRequests)
 		json.NewEncoder(w).Encode(map[string]interface{}{
-			"success": false,
-			"message": "Rate limit exceeded",
+			"success":    false,
+			"message":    limit.Message,
+			"limit_type": limit.LimitType,
+		})
+		return
+	}
+
+	// Chunks without replay consent are acknowledged but dropped
+	if !h.validator.ResolveConsent(req.Consent).Replay {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"success": true,
+			"consent": false,
+			"message": "Chunk dropped without replay consent",
+		})
+		return
+	}
+
+	// Replay sampling: chunks of sessions not sampled are acknowledged but dropped
+	if !h.validator.SampleReplay(r.Context(), projectID, req.SessionID) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"success": true,
+			"sampled": false,
+			"message": "Chunk not sampled",
 		})
 		return
 	}
@@ -241,7 +404,7 @@ func (h *HTTPHandler) HandleReplay(w http.ResponseWriter, r *http.Request) {
 	log.Println("[Replay] Sending to Kafka...")
 	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
 	defer cancel()
-	err = h.producer.ProduceReplayChunk(ctx, req.SessionID, chunk)
+	err = h.producer.ProduceReplayChunk(ctx, projectID, req.SessionID, chunk)
 	if err != nil {
 		log.Printf("[Replay] Kafka error: %v", err)
 		w.Header().Set("Content-Type", "application/json")
@@ -261,22 +424,111 @@ func (h *HTTPHandler) HandleReplay(w http.ResponseWriter, r *http.Request) {
 	})
 }
 
+// setRetryAfter sets the Retry-After header in whole seconds, rounded up
+func setRetryAfter(w http.ResponseWriter, d time.Duration) {
+	if d <= 0 {
+		return
+	}
+	w.Header().Set("Retry-After", strconv.Itoa(int((d+time.Second-1)/time.Second)))
+}
+
 func HealthCheck(w http.ResponseWriter, r *http.Request) {
 	w.WriteHeader(http.StatusOK)
 	w.Write([]byte("OK"))
 }
 
-func CORSMiddleware(next http.Handler) http.Handler {
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		w.Header().Set("Access-Control-Allow-Origin", "*")
-		w.Header().Set("Access-Control-Allow-Methods", "GET, POST, OPTIONS")
-		w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization, X-Project-Key")
+// Readiness backs /readyz. It is flipped to draining at shutdown so load
+// balancers stop routing new requests before the servers close.
+type Readiness struct {
+	draining atomic.Bool
+}
 
-		if r.Method == "OPTIONS" {
-			w.WriteHeader(http.StatusOK)
-			return
-		}
+// SetDraining makes /readyz report 503
+func (rd *Readiness) SetDraining() {
+	rd.draining.Store(true)
+}
+
+// ReadyCheck returns 503 once the ingestor is draining
+func (rd *Readiness) ReadyCheck(w http.ResponseWriter, r *http.Request) {
+	if rd.draining.Load() {
+		http.Error(w, "draining", http.StatusServiceUnavailable)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte("OK"))
+}
+
+// InFlight tracks the HTTP handlers running, so shutdown can wait for the ones
+// http.Server.Close cut off (their requests are canceled) before closing what
+// they write to
+type InFlight struct {
+	handlers sync.WaitGroup
+}
 
+// Middleware counts the requests being handled
+func (f *InFlight) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		f.handlers.Add(1)
+		defer f.handlers.Done()
 		next.ServeHTTP(w, r)
 	})
 }
+
+// Wait waits until no handler is running or ctx is done. Call it once the
+// server no longer accepts requests.
+func (f *InFlight) Wait(ctx context.Context) error {
+	done := make(chan struct{})
+	go func() {
+		f.handlers.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// MetricsHandler serves the enricher's and validator's metrics in the
+// Prometheus text format
+func MetricsHandler(e *enricher.Enricher, v *validation.Validator) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+		e.WriteMetrics(w)
+		v.WriteMetrics(w)
+	}
+}
+
+// CORS allows cross-origin requests from any origin. Requests from
+// credentialOrigins (visitor.allowed_origins) get their origin echoed with
+// credentials allowed instead of "*", as browsers require to send and accept
+// the visitor cookie; other origins can't read or set it.
+func CORS(credentialOrigins []string) func(http.Handler) http.Handler {
+	allowed := make(map[string]bool, len(credentialOrigins))
+	for _, origin := range credentialOrigins {
+		allowed[origin] = true
+	}
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Access-Control-Allow-Origin", "*")
+			if len(allowed) > 0 {
+				w.Header().Add("Vary", "Origin")
+			}
+			if origin := r.Header.Get("Origin"); allowed[origin] {
+				w.Header().Set("Access-Control-Allow-Origin", origin)
+				w.Header().Set("Access-Control-Allow-Credentials", "true")
+			}
+			w.Header().Set("Access-Control-Allow-Methods", "GET, POST, OPTIONS")
+			w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization, X-Project-Key")
+
+			if r.Method == "OPTIONS" {
+				w.WriteHeader(http.StatusOK)
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
@@ -0,0 +1,62 @@
+package handler
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestInFlightWaitsForHandlers(t *testing.T) {
+	f := &InFlight{}
+	release := make(chan struct{})
+	started := make(chan struct{})
+	h := f.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		<-release
+	}))
+
+	go h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, "/v1/events", nil))
+	<-started
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if err := f.Wait(ctx); err != context.DeadlineExceeded {
+		t.Fatalf("Wait with a handler running = %v, want context.DeadlineExceeded", err)
+	}
+
+	close(release)
+	if err := f.Wait(context.Background()); err != nil {
+		t.Errorf("Wait = %v", err)
+	}
+}
+
+func TestCORSAllowsCredentialsOnlyForAllowedOrigins(t *testing.T) {
+	h := CORS([]string{"https://www.example.com"})(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	tests := []struct {
+		origin          string
+		wantOrigin      string
+		wantCredentials string
+	}{
+		{"https://www.example.com", "https://www.example.com", "true"},
+		{"https://evil.example.net", "*", ""},
+		{"", "*", ""},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest(http.MethodPost, "/v1/events", nil)
+		if tt.origin != "" {
+			req.Header.Set("Origin", tt.origin)
+		}
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+
+		if got := rec.Header().Get("Access-Control-Allow-Origin"); got != tt.wantOrigin {
+			t.Errorf("origin %q: Access-Control-Allow-Origin = %q, want %q", tt.origin, got, tt.wantOrigin)
+		}
+		if got := rec.Header().Get("Access-Control-Allow-Credentials"); got != tt.wantCredentials {
+			t.Errorf("origin %q: Access-Control-Allow-Credentials = %q, want %q", tt.origin, got, tt.wantCredentials)
+		}
+	}
+}
@@ -0,0 +1,74 @@
+package handler
+
+import (
+	"context"
+	"math/rand"
+	"net/http"
+	"time"
+
+	"github.com/go-chi/chi/v5/middleware"
+	"github.com/rs/zerolog/log"
+
+	"github.com/gosight/gosight/ingestor/internal/config"
+)
+
+type forceLogKey struct{}
+
+// ForceLog makes the request logger log this request regardless of sampling,
+// e.g. for a 200 response that still rejected some events
+func ForceLog(r *http.Request) {
+	if force, ok := r.Context().Value(forceLogKey{}).(*bool); ok {
+		*force = true
+	}
+}
+
+// SamplingLogger logs requests that fail (5xx), are slow, end with one of the
+// always-log statuses or are marked with ForceLog, and only a sample of the rest
+func SamplingLogger(cfg config.RequestLogConfig) func(http.Handler) http.Handler {
+	alwaysLog := make(map[int]bool, len(cfg.AlwaysLogStatuses))
+	for _, status := range cfg.AlwaysLogStatuses {
+		alwaysLog[status] = true
+	}
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			start := time.Now()
+			force := false
+			r = r.WithContext(context.WithValue(r.Context(), forceLogKey{}, &force))
+			ww := middleware.NewWrapResponseWriter(w, r.ProtoMajor)
+
+			next.ServeHTTP(ww, r)
+
+			duration := time.Since(start)
+			status := ww.Status()
+			if status == 0 {
+				status = http.StatusOK
+			}
+
+			sampled := false
+			switch {
+			case status >= 500, force, alwaysLog[status], duration >= cfg.SlowThreshold:
+			case rand.Float64() < cfg.SampleRate:
+				sampled = true
+			default:
+				return
+			}
+
+			entry := log.Info()
+			if status >= 500 {
+				entry = log.Error()
+			} else if status >= 400 || force {
+				entry = log.Warn()
+			}
+			entry.
+				Str("method", r.Method).
+				Str("path", r.URL.Path).
+				Int("status", status).
+				Int("bytes", ww.BytesWritten()).
+				Dur("duration", duration).
+				Str("remote_ip", r.RemoteAddr).
+				Bool("sampled", sampled).
+				Msg("HTTP request")
+		})
+	}
+}
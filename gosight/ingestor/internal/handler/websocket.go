@@ -0,0 +1,313 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"golang.org/x/net/websocket"
+
+	"github.com/gosight/gosight/ingestor/internal/audit"
+	"github.com/gosight/gosight/ingestor/internal/config"
+	"github.com/gosight/gosight/ingestor/internal/enricher"
+	"github.com/gosight/gosight/ingestor/internal/producer"
+	"github.com/gosight/gosight/ingestor/internal/validation"
+)
+
+// wsAuthTimeout bounds how long a client has to send its auth frame
+const wsAuthTimeout = 10 * time.Second
+
+// WebSocketHandler streams events over a persistent connection (GET /v1/ws).
+// The first frame must be a WSAuthFrame; every following text frame is one
+// JSON event, run through the same validation, enrichment and Kafka path as
+// HandleEvents. Accepted/rejected counts are acked every ack_interval.
+type WebSocketHandler struct {
+	producer  *producer.KafkaProducer
+	validator *validation.Validator
+	enricher  *enricher.Enricher
+	auditor   *audit.Auditor
+	cfg       config.WebSocketConfig
+
+	// Open connections, closed and waited for by Shutdown
+	conns   map[*websocket.Conn]struct{}
+	serving sync.WaitGroup
+	closing bool
+	connsMu sync.Mutex
+}
+
+func NewWebSocketHandler(p *producer.KafkaProducer, v *validation.Validator, e *enricher.Enricher, a *audit.Auditor, cfg config.WebSocketConfig) *WebSocketHandler {
+	return &WebSocketHandler{
+		producer:  p,
+		validator: v,
+		enricher:  e,
+		auditor:   a,
+		cfg:       cfg,
+		conns:     make(map[*websocket.Conn]struct{}),
+	}
+}
+
+// Shutdown closes every open connection, refuses new ones, and waits until
+// their handlers have returned or ctx is done. Hijacked WebSocket connections
+// are neither closed nor waited for by http.Server.Shutdown, so call it before
+// closing what the handlers write to (the auditor, the Kafka producer).
+func (h *WebSocketHandler) Shutdown(ctx context.Context) error {
+	h.connsMu.Lock()
+	h.closing = true
+	for ws := range h.conns {
+		ws.Close()
+	}
+	h.connsMu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		h.serving.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// WSAuthFrame is the first frame sent by the client
+type WSAuthFrame struct {
+	ProjectKey string `json:"project_key"`
+	SessionID  string `json:"session_id"`
+	UserID     string `json:"user_id"`
+	// Marks every event of the connection synthetic (QA, synthetic monitoring)
+	Synthetic bool `json:"synthetic,omitempty"`
+	// Consent flags of the connection's events; an event's own consent overrides them
+	Consent map[string]interface{} `json:"consent,omitempty"`
+	// Anonymous visitor ID of the connection's events, for clients without
+	// the visitor cookie (see visitor.enabled)
+	AnonymousID string `json:"anonymous_id,omitempty"`
+}
+
+// WSServerFrame is sent by the server: "auth_ok", "ack" or "error"
+type WSServerFrame struct {
+	Type          string `json:"type"`
+	AcceptedCount int    `json:"accepted_count,omitempty"`
+	RejectedCount int    `json:"rejected_count,omitempty"`
+	// Accepted events dropped as a repeated idempotency_key (included in AcceptedCount)
+	DuplicateCount int    `json:"duplicate_count,omitempty"`
+	Error          string `json:"error,omitempty"`
+}
+
+// wsOutcome is the result of handling one event frame
+type wsOutcome int
+
+const (
+	wsRejected wsOutcome = iota
+	wsAccepted
+	wsDuplicate // accepted but not produced
+)
+
+// wsConn serializes writes to a connection and accumulates counts between acks
+type wsConn struct {
+	ws         *websocket.Conn
+	mu         sync.Mutex
+	accepted   int
+	rejected   int
+	duplicates int
+}
+
+func (c *wsConn) send(frame WSServerFrame) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return websocket.JSON.Send(c.ws, frame)
+}
+
+func (c *wsConn) count(outcome wsOutcome) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	switch outcome {
+	case wsAccepted:
+		c.accepted++
+	case wsDuplicate:
+		c.accepted++
+		c.duplicates++
+	default:
+		c.rejected++
+	}
+}
+
+// ack sends and resets the counts, if there is anything to report
+func (c *wsConn) ack() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.accepted == 0 && c.rejected == 0 {
+		return nil
+	}
+	frame := WSServerFrame{Type: "ack", AcceptedCount: c.accepted, RejectedCount: c.rejected, DuplicateCount: c.duplicates}
+	c.accepted, c.rejected, c.duplicates = 0, 0, 0
+	return websocket.JSON.Send(c.ws, frame)
+}
+
+func (h *WebSocketHandler) HandleWebSocket(w http.ResponseWriter, r *http.Request) {
+	server := websocket.Server{
+		// Any origin is allowed, as for the HTTP endpoints (see CORS); the
+		// visitor cookie is only read for visitor.allowed_origins
+		Handshake: func(*websocket.Config, *http.Request) error { return nil },
+		Handler:   func(ws *websocket.Conn) { h.serve(ws, r) },
+	}
+	server.ServeHTTP(w, r)
+}
+
+func (h *WebSocketHandler) serve(ws *websocket.Conn, r *http.Request) {
+	h.connsMu.Lock()
+	if h.closing {
+		h.connsMu.Unlock()
+		ws.Close()
+		return
+	}
+	h.conns[ws] = struct{}{}
+	h.serving.Add(1)
+	h.connsMu.Unlock()
+	defer func() {
+		h.connsMu.Lock()
+		delete(h.conns, ws)
+		h.connsMu.Unlock()
+		ws.Close()
+		h.serving.Done()
+	}()
+	ws.MaxPayloadBytes = h.cfg.MaxMessageBytes
+	conn := &wsConn{ws: ws}
+
+	// Authenticate
+	ws.SetReadDeadline(time.Now().Add(wsAuthTimeout))
+	var auth WSAuthFrame
+	if err := websocket.JSON.Receive(ws, &auth); err != nil {
+		conn.send(WSServerFrame{Type: "error", Error: "Expected auth frame"})
+		return
+	}
+	ws.SetReadDeadline(time.Time{})
+
+	key, err := h.validator.ValidateAPIKey(r.Context(), auth.ProjectKey)
+	if err != nil {
+		h.auditor.Record("", "Invalid API key", "websocket", 0, nil)
+		conn.send(WSServerFrame{Type: "error", Error: "Invalid API key"})
+		return
+	}
+	// The connection's events all get its session
+	if auth.SessionID, err = h.validator.NewSessionAssigner().Resolve(auth.SessionID); err != nil {
+		h.auditor.Record(key.ProjectID, err.Error(), "websocket", 0, nil)
+		conn.send(WSServerFrame{Type: "error", Error: err.Error()})
+		return
+	}
+	if err := conn.send(WSServerFrame{Type: "auth_ok"}); err != nil {
+		return
+	}
+	projectID := key.ProjectID
+	auth.Synthetic = auth.Synthetic || key.Test
+
+	// Get client IP and User-Agent for enrichment
+	clientIP := clientIP(r)
+	userAgent := r.Header.Get("User-Agent")
+
+	// Periodic acks
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		ticker := time.NewTicker(h.cfg.AckInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				if err := conn.ack(); err != nil {
+					ws.Close()
+					return
+				}
+			}
+		}
+	}()
+
+	for {
+		var data []byte
+		err := websocket.Message.Receive(ws, &data)
+		if errors.Is(err, websocket.ErrFrameTooLarge) {
+			h.auditor.Record(projectID, "Message too large", "websocket", 1, nil)
+			conn.count(wsRejected)
+			continue
+		}
+		if err != nil {
+			if err != io.EOF {
+				log.Printf("[WebSocket] Read error: %v", err)
+			}
+			conn.ack()
+			return
+		}
+
+		conn.count(h.handleEvent(r, projectID, auth, data, clientIP, userAgent))
+	}
+}
+
+// handleEvent validates, enriches and produces a single event
+func (h *WebSocketHandler) handleEvent(r *http.Request, projectID string, auth WSAuthFrame, data []byte, clientIP, userAgent string) wsOutcome {
+	var event map[string]interface{}
+	if err := json.Unmarshal(data, &event); err != nil {
+		h.auditor.Record(projectID, "Invalid JSON", "websocket", 1, string(data))
+		return wsRejected
+	}
+
+	// Rate limiting
+	if limit := h.validator.CheckIPRateLimit(clientIP); !limit.Allowed {
+		h.auditor.Record(projectID, limit.Message, "websocket", 1, event)
+		return wsRejected
+	}
+	if limit := h.validator.CheckRateLimit(projectID); !limit.Allowed {
+		h.auditor.Record(projectID, limit.Message, "websocket", 1, event)
+		return wsRejected
+	}
+
+	// Validate event
+	if err := h.validator.ValidateEvent(event); err != nil {
+		h.auditor.Record(projectID, err.Error(), "websocket", 1, event)
+		return wsRejected
+	}
+
+	// Add metadata
+	event["project_id"] = projectID
+	event["session_id"] = auth.SessionID
+	event["user_id"] = auth.UserID
+	if event["event_id"] == nil {
+		event["event_id"] = uuid.New().String()
+	}
+	if auth.Synthetic {
+		event["synthetic"] = true
+	}
+
+	if err := h.validator.LimitEventSize(event); err != nil {
+		h.auditor.Record(projectID, err.Error(), "websocket", 1, event)
+		return wsRejected
+	}
+
+	idemKey := validation.IdempotencyKey(event)
+	if !h.validator.ClaimIdempotencyKey(r.Context(), projectID, idemKey) {
+		return wsDuplicate
+	}
+
+	enrichedEvent := h.enricher.Enrich(event, userAgent, clientIP)
+	eventConsent, _ := event["consent"].(map[string]interface{})
+	consent := h.validator.ResolveConsent(eventConsent, auth.Consent)
+	enrichedEvent.Consent = &consent
+	eventAnonymousID, _ := event["anonymous_id"].(string)
+	enrichedEvent.AnonymousID = h.validator.AnonymousID(r, consent, eventAnonymousID, auth.AnonymousID, h.validator.ReadVisitorCookie(r))
+
+	if err := h.producer.ProduceEvent(r.Context(), projectID, enrichedEvent.SessionID, enrichedEvent.Type, enrichedEvent); err != nil {
+		h.validator.ReleaseIdempotencyKey(r.Context(), projectID, idemKey)
+		h.auditor.Record(projectID, err.Error(), "websocket", 1, event)
+		return wsRejected
+	}
+	return wsAccepted
+}
@@ -0,0 +1,92 @@
+package handler
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/net/websocket"
+
+	"github.com/gosight/gosight/ingestor/internal/config"
+)
+
+func newTestWebSocketServer(t *testing.T) (*WebSocketHandler, *httptest.Server) {
+	t.Helper()
+	h := NewWebSocketHandler(nil, nil, nil, nil, config.WebSocketConfig{
+		MaxMessageBytes: 1024,
+		AckInterval:     time.Second,
+	})
+	srv := httptest.NewServer(http.HandlerFunc(h.HandleWebSocket))
+	t.Cleanup(srv.Close)
+	return h, srv
+}
+
+func dialWebSocket(t *testing.T, srv *httptest.Server) *websocket.Conn {
+	t.Helper()
+	url := "ws" + strings.TrimPrefix(srv.URL, "http")
+	ws, err := websocket.Dial(url, "", srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ws.Close() })
+	return ws
+}
+
+func openConns(h *WebSocketHandler) int {
+	h.connsMu.Lock()
+	defer h.connsMu.Unlock()
+	return len(h.conns)
+}
+
+func TestWebSocketShutdownWaitsForConnections(t *testing.T) {
+	h, srv := newTestWebSocketServer(t)
+
+	// Connections waiting for their auth frame
+	for i := 0; i < 3; i++ {
+		dialWebSocket(t, srv)
+	}
+	deadline := time.Now().Add(time.Second)
+	for openConns(h) < 3 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	if n := openConns(h); n != 3 {
+		t.Fatalf("open connections = %d, want 3", n)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if err := h.Shutdown(ctx); err != nil {
+		t.Fatalf("Shutdown: %v", err)
+	}
+	if n := openConns(h); n != 0 {
+		t.Errorf("open connections after Shutdown = %d, want 0", n)
+	}
+
+	// New connections are closed right away
+	ws := dialWebSocket(t, srv)
+	ws.SetReadDeadline(time.Now().Add(time.Second))
+	var frame WSServerFrame
+	if err := websocket.JSON.Receive(ws, &frame); err == nil {
+		t.Errorf("received %+v on a connection opened after Shutdown", frame)
+	}
+	if n := openConns(h); n != 0 {
+		t.Errorf("open connections = %d, want 0", n)
+	}
+}
+
+func TestWebSocketShutdownTimesOut(t *testing.T) {
+	h, _ := newTestWebSocketServer(t)
+
+	// A handler that has not returned yet
+	h.serving.Add(1)
+	defer h.serving.Done()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if err := h.Shutdown(ctx); err != context.DeadlineExceeded {
+		t.Errorf("Shutdown = %v, want context.DeadlineExceeded", err)
+	}
+}
@@ -0,0 +1,41 @@
+// Package kafkaauth applies kafka.sasl and kafka.tls to the Kafka producer,
+// so it can connect to managed Kafka (Confluent Cloud, MSK, Aiven, ...)
+package kafkaauth
+
+import (
+	"crypto/sha256"
+	"crypto/sha512"
+
+	"github.com/segmentio/kafka-go"
+	"github.com/segmentio/kafka-go/sasl"
+	"github.com/segmentio/kafka-go/sasl/plain"
+
+	"github.com/gosight/gosight/ingestor/internal/config"
+)
+
+// Transport returns the transport of kafka.Writers, or nil (kafka-go's
+// default) without authentication
+func Transport(cfg config.KafkaConfig) kafka.RoundTripper {
+	mechanism := Mechanism(cfg.SASL)
+	if mechanism == nil && cfg.TLS.Config() == nil {
+		return nil
+	}
+	return &kafka.Transport{
+		TLS:  cfg.TLS.Config(),
+		SASL: mechanism,
+	}
+}
+
+// Mechanism returns the SASL mechanism of kafka.sasl, or nil when disabled.
+// The mechanism is validated by config.Load.
+func Mechanism(cfg config.KafkaSASLConfig) sasl.Mechanism {
+	switch cfg.Mechanism {
+	case config.SASLMechanismPlain:
+		return plain.Mechanism{Username: cfg.Username, Password: cfg.Password}
+	case config.SASLMechanismScramSHA256:
+		return &scram{name: cfg.Mechanism, hash: sha256.New, username: cfg.Username, password: cfg.Password}
+	case config.SASLMechanismScramSHA512:
+		return &scram{name: cfg.Mechanism, hash: sha512.New, username: cfg.Username, password: cfg.Password}
+	}
+	return nil
+}
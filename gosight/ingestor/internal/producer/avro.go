@@ -0,0 +1,210 @@
+package producer
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/gosight/gosight/ingestor/internal/config"
+)
+
+// eventSchema is the Avro schema of enriched events (enricher.EnrichedEvent).
+// page and payload are free-form, so they are carried as JSON strings, marked
+// with gosight.json for the processor to decode. Changes must stay compatible
+// under the subject's compatibility rules (e.g. new fields need a default);
+// the registry rejects the registration at startup otherwise.
+const eventSchema = `{
+  "type": "record",
+  "name": "Event",
+  "namespace": "gosight",
+  "fields": [
+    {"name": "event_id", "type": "string", "default": ""},
+    {"name": "type", "type": "string", "default": ""},
+    {"name": "timestamp", "type": "long", "default": 0},
+    {"name": "project_id", "type": "string", "default": ""},
+    {"name": "session_id", "type": "string", "default": ""},
+    {"name": "user_id", "type": "string", "default": ""},
+    {"name": "page", "type": ["null", "string"], "default": null, "gosight.json": true},
+    {"name": "payload", "type": ["null", "string"], "default": null, "gosight.json": true},
+    {"name": "server_timestamp", "type": "long", "default": 0},
+    {"name": "browser", "type": "string", "default": ""},
+    {"name": "browser_version", "type": "string", "default": ""},
+    {"name": "os", "type": "string", "default": ""},
+    {"name": "os_version", "type": "string", "default": ""},
+    {"name": "device_type", "type": "string", "default": ""},
+    {"name": "country", "type": "string", "default": ""},
+    {"name": "city", "type": "string", "default": ""},
+    {"name": "geo_accuracy", "type": "string", "default": ""},
+    {"name": "client_ip", "type": "string", "default": ""},
+    {"name": "user_agent", "type": "string", "default": ""},
+    {"name": "synthetic", "type": "boolean", "default": false},
+    {"name": "consent", "type": ["null", "string"], "default": null, "gosight.json": true},
+    {"name": "anonymous_id", "type": "string", "default": ""}
+  ]
+}`
+
+// avroField is a field of eventSchema; only string, long, boolean and
+// ["null", "string"] fields are supported
+type avroField struct {
+	Name     string          `json:"name"`
+	Type     json.RawMessage `json:"type"`
+	JSON     bool            `json:"gosight.json"`
+	nullable bool
+	kind     string // string, long or boolean
+}
+
+// AvroEncoder encodes events with eventSchema in the Confluent wire format:
+// a zero magic byte, the 4 byte schema ID, then the Avro binary encoding
+type AvroEncoder struct {
+	schemaID uint32
+	fields   []avroField
+}
+
+// NewAvroEncoder registers eventSchema under the configured subject (a no-op
+// when already registered) and returns an encoder using its schema ID
+func NewAvroEncoder(cfg config.SchemaRegistryConfig) (*AvroEncoder, error) {
+	var schema struct {
+		Fields []avroField `json:"fields"`
+	}
+	if err := json.Unmarshal([]byte(eventSchema), &schema); err != nil {
+		return nil, err
+	}
+	for i := range schema.Fields {
+		f := &schema.Fields[i]
+		var kind string
+		if err := json.Unmarshal(f.Type, &kind); err != nil {
+			f.nullable, kind = true, "string" // ["null", "string"]
+		}
+		f.kind = kind
+	}
+
+	id, err := registerSchema(cfg, eventSchema)
+	if err != nil {
+		return nil, fmt.Errorf("register event schema: %w", err)
+	}
+	return &AvroEncoder{schemaID: id, fields: schema.Fields}, nil
+}
+
+// SchemaID returns the registry ID of the event schema
+func (e *AvroEncoder) SchemaID() uint32 {
+	return e.schemaID
+}
+
+// Encode encodes an event given as a map of its JSON fields
+func (e *AvroEncoder) Encode(event map[string]interface{}) ([]byte, error) {
+	var buf bytes.Buffer
+	buf.WriteByte(0)
+	binary.Write(&buf, binary.BigEndian, e.schemaID)
+
+	for _, f := range e.fields {
+		v := event[f.Name]
+		if f.nullable {
+			if v == nil {
+				writeLong(&buf, 0) // null branch
+				continue
+			}
+			writeLong(&buf, 1)
+		}
+
+		switch f.kind {
+		case "long":
+			n, ok := toInt64(v)
+			if !ok && v != nil {
+				return nil, fmt.Errorf("field %s: expected a number, got %T", f.Name, v)
+			}
+			writeLong(&buf, n)
+		case "boolean":
+			b, ok := v.(bool)
+			if !ok && v != nil {
+				return nil, fmt.Errorf("field %s: expected a boolean, got %T", f.Name, v)
+			}
+			if b {
+				buf.WriteByte(1)
+			} else {
+				buf.WriteByte(0)
+			}
+		case "string":
+			s, ok := v.(string)
+			if !ok && v != nil {
+				if !f.JSON {
+					return nil, fmt.Errorf("field %s: expected a string, got %T", f.Name, v)
+				}
+				data, err := json.Marshal(v)
+				if err != nil {
+					return nil, fmt.Errorf("field %s: %w", f.Name, err)
+				}
+				s = string(data)
+			}
+			writeLong(&buf, int64(len(s)))
+			buf.WriteString(s)
+		default:
+			return nil, fmt.Errorf("field %s: unsupported type %s", f.Name, f.kind)
+		}
+	}
+	return buf.Bytes(), nil
+}
+
+// writeLong writes a zigzag varint, Avro's int and long encoding
+func writeLong(buf *bytes.Buffer, n int64) {
+	var tmp [binary.MaxVarintLen64]byte
+	buf.Write(tmp[:binary.PutVarint(tmp[:], n)])
+}
+
+func toInt64(v interface{}) (int64, bool) {
+	switch n := v.(type) {
+	case float64:
+		return int64(n), true
+	case int64:
+		return n, true
+	case int:
+		return int64(n), true
+	case json.Number:
+		i, err := n.Int64()
+		return i, err == nil
+	}
+	return 0, false
+}
+
+// registerSchema registers a schema version under the subject and returns its ID
+func registerSchema(cfg config.SchemaRegistryConfig, schema string) (uint32, error) {
+	body, err := json.Marshal(map[string]string{"schema": schema})
+	if err != nil {
+		return 0, err
+	}
+
+	req, err := http.NewRequest(http.MethodPost,
+		cfg.URL+"/subjects/"+url.PathEscape(cfg.Subject)+"/versions", bytes.NewReader(body))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/vnd.schemaregistry.v1+json")
+	if cfg.Username != "" {
+		req.SetBasicAuth(cfg.Username, cfg.Password)
+	}
+
+	client := &http.Client{Timeout: 10 * time.Second}
+	resp, err := client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		// e.g. 409 when the schema is incompatible with the subject's latest version
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return 0, fmt.Errorf("schema registry returned %s: %s", resp.Status, msg)
+	}
+
+	var result struct {
+		ID uint32 `json:"id"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return 0, err
+	}
+	return result.ID, nil
+}
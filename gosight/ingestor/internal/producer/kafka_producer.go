@@ -3,78 +3,232 @@ package producer
 import (
 	"context"
 	"encoding/json"
+	"errors"
+	"sync"
 	"time"
 
 	"github.com/segmentio/kafka-go"
 
 	"github.com/gosight/gosight/ingestor/internal/config"
+	"github.com/gosight/gosight/ingestor/internal/kafkaauth"
 )
 
+// closeTimeout bounds how long Close waits for pending writes
+const closeTimeout = 10 * time.Second
+
+// SchemaVersion is the version of the event and replay message format, sent
+// in the schema_version header; bumped on incompatible changes
+const SchemaVersion = "1"
+
+// Headers of event and replay messages, so consumers can route and filter
+// them without decoding the value
+const (
+	HeaderProjectID     = "project_id"
+	HeaderEventType     = "event_type"
+	HeaderSchemaVersion = "schema_version"
+)
+
+// replayEventType is the event_type header of replay chunks
+const replayEventType = "replay_chunk"
+
 type KafkaProducer struct {
 	writers map[string]*kafka.Writer
 	topics  map[string]string
+
+	// Encodes events as Avro when kafka.encoding is avro; nil for JSON
+	avro *AvroEncoder
+
+	// Held for reading by every write in progress, so Flush can wait for them
+	inflight sync.RWMutex
 }
 
 func NewKafkaProducer(cfg config.KafkaConfig) (*KafkaProducer, error) {
 	writers := make(map[string]*kafka.Writer)
+	// Shared by the topics' writers, with the brokers' connections
+	transport := kafkaauth.Transport(cfg)
 
 	for name, topic := range cfg.Topics {
 		writers[name] = &kafka.Writer{
 			Addr:                   kafka.TCP(cfg.Brokers...),
+			Transport:              transport,
 			Topic:                  topic,
 			Balancer:               &kafka.LeastBytes{},
 			BatchSize:              1,                       // Send immediately
 			BatchTimeout:           time.Millisecond * 10,   // Flush quickly
 			Async:                  false,                   // Sync mode for reliability
 			AllowAutoTopicCreation: true,
+			Compression:            compression(cfg.Compression),
 		}
 	}
 
-	return &KafkaProducer{
+	// Events are partitioned by their key (see eventKey), so each session's
+	// events are consumed from one partition, in order
+	if w, ok := writers["events"]; ok {
+		w.Balancer = &kafka.Hash{}
+	}
+
+	p := &KafkaProducer{
 		writers: writers,
 		topics:  cfg.Topics,
-	}, nil
+	}
+
+	if cfg.Encoding == config.EncodingAvro {
+		avro, err := NewAvroEncoder(cfg.SchemaRegistry)
+		if err != nil {
+			return nil, err
+		}
+		p.avro = avro
+	}
+
+	return p, nil
+}
+
+// compression returns the codec of kafka.compression, validated by config.Load;
+// none leaves messages uncompressed
+func compression(name string) kafka.Compression {
+	switch name {
+	case config.CompressionGzip:
+		return kafka.Gzip
+	case config.CompressionSnappy:
+		return kafka.Snappy
+	case config.CompressionLz4:
+		return kafka.Lz4
+	case config.CompressionZstd:
+		return kafka.Zstd
+	}
+	return 0
 }
 
-func (p *KafkaProducer) ProduceEvent(ctx context.Context, projectID string, event interface{}) error {
+// encodeEvent encodes an event as JSON, or as Avro when configured
+func (p *KafkaProducer) encodeEvent(event interface{}) ([]byte, error) {
 	data, err := json.Marshal(event)
+	if err != nil || p.avro == nil {
+		return data, err
+	}
+
+	// The encoder works on the event's JSON fields
+	var fields map[string]interface{}
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return nil, err
+	}
+	return p.avro.Encode(fields)
+}
+
+// eventKey keys an event by session, so that the processor's stateful
+// detectors see each session's events in order; events without a session fall
+// back to the project
+func eventKey(projectID, sessionID string) []byte {
+	if sessionID == "" {
+		return []byte(projectID)
+	}
+	return []byte(projectID + ":" + sessionID)
+}
+
+// messageHeaders returns the headers of an event or replay message
+func messageHeaders(projectID, eventType string) []kafka.Header {
+	return []kafka.Header{
+		{Key: HeaderProjectID, Value: []byte(projectID)},
+		{Key: HeaderEventType, Value: []byte(eventType)},
+		{Key: HeaderSchemaVersion, Value: []byte(SchemaVersion)},
+	}
+}
+
+func (p *KafkaProducer) ProduceEvent(ctx context.Context, projectID, sessionID, eventType string, event interface{}) error {
+	data, err := p.encodeEvent(event)
 	if err != nil {
 		return err
 	}
 
-	return p.writers["events"].WriteMessages(ctx, kafka.Message{
-		Key:   []byte(projectID),
-		Value: data,
+	return p.write(ctx, p.writers["events"], kafka.Message{
+		Key:     eventKey(projectID, sessionID),
+		Value:   data,
+		Headers: messageHeaders(projectID, eventType),
 	})
 }
 
 func (p *KafkaProducer) ProduceEventJSON(ctx context.Context, projectID string, event map[string]interface{}) error {
-	data, err := json.Marshal(event)
+	data, err := p.encodeEvent(event)
 	if err != nil {
 		return err
 	}
 
-	return p.writers["events"].WriteMessages(ctx, kafka.Message{
-		Key:   []byte(projectID),
-		Value: data,
+	sessionID, _ := event["session_id"].(string)
+	eventType, _ := event["type"].(string)
+	return p.write(ctx, p.writers["events"], kafka.Message{
+		Key:     eventKey(projectID, sessionID),
+		Value:   data,
+		Headers: messageHeaders(projectID, eventType),
 	})
 }
 
-func (p *KafkaProducer) ProduceReplayChunk(ctx context.Context, sessionID string, chunk interface{}) error {
+func (p *KafkaProducer) ProduceReplayChunk(ctx context.Context, projectID, sessionID string, chunk interface{}) error {
 	data, err := json.Marshal(chunk)
 	if err != nil {
 		return err
 	}
 
-	return p.writers["replay"].WriteMessages(ctx, kafka.Message{
-		Key:   []byte(sessionID),
+	return p.write(ctx, p.writers["replay"], kafka.Message{
+		Key:     []byte(sessionID),
+		Value:   data,
+		Headers: messageHeaders(projectID, replayEventType),
+	})
+}
+
+// ProduceRejected writes a rejected event audit record to the "rejected" topic
+func (p *KafkaProducer) ProduceRejected(ctx context.Context, projectID string, record interface{}) error {
+	writer, ok := p.writers["rejected"]
+	if !ok {
+		return errors.New("rejected events topic not configured")
+	}
+
+	data, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+
+	return p.write(ctx, writer, kafka.Message{
+		Key:   []byte(projectID),
 		Value: data,
 	})
 }
 
+func (p *KafkaProducer) write(ctx context.Context, w *kafka.Writer, msgs ...kafka.Message) error {
+	p.inflight.RLock()
+	defer p.inflight.RUnlock()
+	return w.WriteMessages(ctx, msgs...)
+}
+
+// Flush waits until the writes in progress when it is called have completed, or ctx is done
+func (p *KafkaProducer) Flush(ctx context.Context) error {
+	done := make(chan struct{})
+	go func() {
+		// Lock is granted once all current writes release their read lock
+		p.inflight.Lock()
+		p.inflight.Unlock()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Close flushes pending writes (waiting up to closeTimeout) and closes the writers,
+// which also delivers anything still batched in them. Call it after the servers
+// have stopped accepting requests.
 func (p *KafkaProducer) Close() error {
+	ctx, cancel := context.WithTimeout(context.Background(), closeTimeout)
+	defer cancel()
+
+	err := p.Flush(ctx)
+
 	for _, w := range p.writers {
-		w.Close()
+		if closeErr := w.Close(); closeErr != nil && err == nil {
+			err = closeErr
+		}
 	}
-	return nil
+	return err
 }
@@ -0,0 +1,198 @@
+package producer
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/segmentio/kafka-go"
+	"github.com/segmentio/kafka-go/compress"
+	"github.com/segmentio/kafka-go/protocol"
+	"github.com/segmentio/kafka-go/protocol/metadata"
+	"github.com/segmentio/kafka-go/protocol/produce"
+
+	"github.com/gosight/gosight/ingestor/internal/config"
+)
+
+// fakeBroker is a single-partition broker the producer's writers talk to in
+// memory. Produced record sets are encoded and decoded as on the wire, so
+// their compression is exercised.
+type fakeBroker struct {
+	mu       sync.Mutex
+	messages []fakeMessage
+}
+
+type fakeMessage struct {
+	topic       string
+	compression compress.Compression
+	key, value  []byte
+	headers     map[string]string
+}
+
+func (b *fakeBroker) RoundTrip(ctx context.Context, addr net.Addr, req protocol.Message) (protocol.Message, error) {
+	switch req := req.(type) {
+	case *metadata.Request:
+		res := &metadata.Response{Brokers: []metadata.ResponseBroker{{NodeID: 0, Host: "fake", Port: 9092}}}
+		for _, topic := range req.TopicNames {
+			res.Topics = append(res.Topics, metadata.ResponseTopic{
+				Name:       topic,
+				Partitions: []metadata.ResponsePartition{{PartitionIndex: 0, LeaderID: 0}},
+			})
+		}
+		return res, nil
+
+	case *produce.Request:
+		res := &produce.Response{}
+		for _, topic := range req.Topics {
+			for _, partition := range topic.Partitions {
+				if err := b.store(topic.Topic, partition.RecordSet); err != nil {
+					return nil, err
+				}
+			}
+			res.Topics = append(res.Topics, produce.ResponseTopic{
+				Topic:      topic.Topic,
+				Partitions: []produce.ResponsePartition{{Partition: 0}},
+			})
+		}
+		return res, nil
+	}
+	return nil, io.ErrUnexpectedEOF
+}
+
+func (b *fakeBroker) store(topic string, set protocol.RecordSet) error {
+	set.Version = 2
+	var wire bytes.Buffer
+	if _, err := set.WriteTo(&wire); err != nil {
+		return err
+	}
+	var decoded protocol.RecordSet
+	if _, err := decoded.ReadFrom(&wire); err != nil {
+		return err
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for {
+		r, err := decoded.Records.ReadRecord()
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		msg := fakeMessage{topic: topic, compression: decoded.Attributes.Compression(), headers: make(map[string]string)}
+		if msg.key, err = protocol.ReadAll(r.Key); err != nil {
+			return err
+		}
+		if msg.value, err = protocol.ReadAll(r.Value); err != nil {
+			return err
+		}
+		for _, h := range r.Headers {
+			msg.headers[h.Key] = string(h.Value)
+		}
+		b.messages = append(b.messages, msg)
+	}
+}
+
+// newTestProducer returns a producer of cfg writing to a fake broker
+func newTestProducer(t *testing.T, cfg config.KafkaConfig) (*KafkaProducer, *fakeBroker) {
+	t.Helper()
+	cfg.Brokers = []string{"fake:9092"}
+	if cfg.Topics == nil {
+		cfg.Topics = map[string]string{"events": "gosight.events", "replay": "gosight.replay"}
+	}
+	p, err := NewKafkaProducer(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	broker := &fakeBroker{}
+	for _, w := range p.writers {
+		w.Transport = broker
+	}
+	t.Cleanup(func() { p.Close() })
+	return p, broker
+}
+
+func TestProducedMessagesCarryRoutingHeaders(t *testing.T) {
+	p, broker := newTestProducer(t, config.KafkaConfig{})
+	ctx := context.Background()
+
+	if err := p.ProduceEvent(ctx, "proj", "sess", "click", map[string]interface{}{"type": "click"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.ProduceEventJSON(ctx, "proj", map[string]interface{}{"type": "page_view", "session_id": "sess"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.ProduceReplayChunk(ctx, "proj", "sess", map[string]interface{}{"events": []int{1}}); err != nil {
+		t.Fatal(err)
+	}
+
+	want := []struct{ topic, key, eventType string }{
+		{"gosight.events", "proj:sess", "click"},
+		{"gosight.events", "proj:sess", "page_view"},
+		{"gosight.replay", "sess", replayEventType},
+	}
+	if len(broker.messages) != len(want) {
+		t.Fatalf("produced %d messages, want %d", len(broker.messages), len(want))
+	}
+	for i, w := range want {
+		msg := broker.messages[i]
+		if msg.topic != w.topic || string(msg.key) != w.key {
+			t.Errorf("message %d to %s keyed %s, want %s keyed %s", i, msg.topic, msg.key, w.topic, w.key)
+		}
+		if msg.headers[HeaderProjectID] != "proj" || msg.headers[HeaderEventType] != w.eventType || msg.headers[HeaderSchemaVersion] != SchemaVersion {
+			t.Errorf("message %d headers = %v", i, msg.headers)
+		}
+	}
+}
+
+func TestProducerCompression(t *testing.T) {
+	codecs := map[string]compress.Compression{
+		config.CompressionNone:   compress.None,
+		config.CompressionGzip:   compress.Gzip,
+		config.CompressionSnappy: compress.Snappy,
+		config.CompressionLz4:    compress.Lz4,
+		config.CompressionZstd:   compress.Zstd,
+	}
+	for name, codec := range codecs {
+		p, broker := newTestProducer(t, config.KafkaConfig{Compression: name})
+
+		event := map[string]interface{}{"type": "click", "payload": map[string]interface{}{"target_selector": "button.buy"}}
+		if err := p.ProduceEvent(context.Background(), "proj", "sess", "click", event); err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		want, _ := p.encodeEvent(event)
+
+		if len(broker.messages) != 1 {
+			t.Fatalf("%s: produced %d messages, want 1", name, len(broker.messages))
+		}
+		msg := broker.messages[0]
+		if msg.compression != codec {
+			t.Errorf("%s: record set compressed with %v, want %v", name, msg.compression, codec)
+		}
+		if !bytes.Equal(msg.value, want) {
+			t.Errorf("%s: round-tripped value %s, want %s", name, msg.value, want)
+		}
+	}
+}
+
+func TestProducerCompressionDefaultsToSnappy(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "ingestor.yaml")
+	if err := os.WriteFile(path, []byte("kafka:\n  brokers: [fake:9092]\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	cfg, err := config.Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if compression(cfg.Kafka.Compression) != kafka.Snappy {
+		t.Errorf("compression = %q, want snappy", cfg.Kafka.Compression)
+	}
+}
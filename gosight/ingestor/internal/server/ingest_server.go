@@ -3,10 +3,16 @@ package server
 import (
 	"context"
 	"io"
+	"net"
 
 	"github.com/google/uuid"
 	"github.com/rs/zerolog/log"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
 
+	"github.com/gosight/gosight/ingestor/internal/audit"
 	"github.com/gosight/gosight/ingestor/internal/enricher"
 	"github.com/gosight/gosight/ingestor/internal/producer"
 	"github.com/gosight/gosight/ingestor/internal/validation"
@@ -18,29 +24,60 @@ type IngestServer struct {
 	producer  *producer.KafkaProducer
 	validator *validation.Validator
 	enricher  *enricher.Enricher
+	auditor   *audit.Auditor
+
+	maxRecvMsgSize int
 }
 
-func NewIngestServer(p *producer.KafkaProducer, v *validation.Validator, e *enricher.Enricher) *IngestServer {
+func NewIngestServer(p *producer.KafkaProducer, v *validation.Validator, e *enricher.Enricher, a *audit.Auditor, maxRecvMsgSize int) *IngestServer {
 	return &IngestServer{
-		producer:  p,
-		validator: v,
-		enricher:  e,
+		producer:       p,
+		validator:      v,
+		enricher:       e,
+		auditor:        a,
+		maxRecvMsgSize: maxRecvMsgSize,
 	}
 }
 
+// anonymousIDMetadata carries the client's anonymous visitor ID, which the
+// protobuf messages have no field for (see visitor.enabled)
+const anonymousIDMetadata = "x-anonymous-id"
+
 func (s *IngestServer) SendEvents(stream pb.IngestService_SendEventsServer) error {
+	clientIP := peerIP(stream.Context())
+	var anonymousID string
+	if md, ok := metadata.FromIncomingContext(stream.Context()); ok {
+		if ids := md.Get(anonymousIDMetadata); len(ids) > 0 {
+			anonymousID = ids[0]
+		}
+	}
+
 	for {
 		batch, err := stream.Recv()
 		if err == io.EOF {
 			return nil
 		}
+		if status.Code(err) == codes.ResourceExhausted {
+			// The transport rejected the message before we could decode it
+			return status.Errorf(codes.ResourceExhausted,
+				"event batch exceeds max message size of %d bytes; split it into smaller batches or raise server.grpc_max_recv_msg_size",
+				s.maxRecvMsgSize)
+		}
 		if err != nil {
 			return err
 		}
 
+		// Client IP rate limiting, before the API key lookup
+		if limit := s.validator.CheckIPRateLimit(clientIP); !limit.Allowed {
+			s.auditor.Record("", limit.Message, "grpc", len(batch.Events), batch.Events)
+			stream.Send(limitExceededAck(limit, limit.Message, len(batch.Events)))
+			continue
+		}
+
 		// Validate API key
-		projectID, err := s.validator.ValidateAPIKey(stream.Context(), batch.ProjectKey)
+		key, err := s.validator.ValidateAPIKey(stream.Context(), batch.ProjectKey)
 		if err != nil {
+			s.auditor.Record("", "Invalid API key", "grpc", len(batch.Events), batch.Events)
 			stream.Send(&pb.EventAck{
 				Success:       false,
 				Errors:        []string{"Invalid API key"},
@@ -48,14 +85,21 @@ func (s *IngestServer) SendEvents(stream pb.IngestService_SendEventsServer) erro
 			})
 			continue
 		}
+		projectID := key.ProjectID
 
-		// Rate limiting
-		if !s.validator.CheckRateLimit(projectID) {
-			stream.Send(&pb.EventAck{
-				Success:       false,
-				Errors:        []string{"Rate limit exceeded"},
-				RejectedCount: int32(len(batch.Events)),
-			})
+		// Batch size limit
+		if limit := s.validator.CheckBatchSize(len(batch.Events)); !limit.Allowed {
+			s.auditor.Record(projectID, limit.Message, "grpc", len(batch.Events), batch.Events)
+			stream.Send(limitExceededAck(limit,
+				limit.Message+"; split it into smaller batches or raise batch.max_events_per_batch", len(batch.Events)))
+			continue
+		}
+
+		// Rate limiting: the stream stays open for the client to send the
+		// batch again after retry_after_ms
+		if limit := s.validator.CheckRateLimit(projectID); !limit.Allowed {
+			s.auditor.Record(projectID, limit.Message, "grpc", len(batch.Events), batch.Events)
+			stream.Send(limitExceededAck(limit, limit.Message, len(batch.Events)))
 			continue
 		}
 
@@ -63,10 +107,12 @@ func (s *IngestServer) SendEvents(stream pb.IngestService_SendEventsServer) erro
 		accepted := 0
 		rejected := 0
 		var errors []string
+		sessions := s.validator.NewSessionAssigner()
 
 		for _, event := range batch.Events {
 			// Validate event
 			if err := s.validator.ValidateEvent(event); err != nil {
+				s.auditor.Record(projectID, err.Error(), "grpc", 1, event)
 				rejected++
 				errors = append(errors, err.Error())
 				continue
@@ -74,13 +120,42 @@ func (s *IngestServer) SendEvents(stream pb.IngestService_SendEventsServer) erro
 
 			// Convert protobuf event to map for enrichment
 			eventMap := s.protoEventToMap(event, projectID, batch.Session)
+			if err := sessions.Assign(eventMap); err != nil {
+				s.auditor.Record(projectID, err.Error(), "grpc", 1, eventMap)
+				rejected++
+				errors = append(errors, err.Error())
+				continue
+			}
+
+			// Truncate oversized fields, reject events still too large
+			if err := s.validator.LimitEventSize(eventMap); err != nil {
+				s.auditor.Record(projectID, err.Error(), "grpc", 1, eventMap)
+				rejected++
+				errors = append(errors, err.Error())
+				continue
+			}
+
+			// Client double-fire of an event already produced
+			idemKey := event.IdempotencyKey
+			if !s.validator.ClaimIdempotencyKey(stream.Context(), projectID, idemKey) {
+				accepted++
+				continue
+			}
 
 			// Enrich event (no user agent or IP in gRPC context by default)
 			enrichedEvent := s.enricher.Enrich(eventMap, "", "")
+			// Protobuf events have no synthetic flag; only test keys mark them
+			enrichedEvent.Synthetic = key.Test
+			// Nor consent flags, which resolve to the consent.mode default
+			consent := s.validator.ResolveConsent()
+			enrichedEvent.Consent = &consent
+			enrichedEvent.AnonymousID = s.validator.AnonymousID(nil, consent, anonymousID)
 
 			// Produce to Kafka
-			err := s.producer.ProduceEvent(stream.Context(), projectID, enrichedEvent)
+			err := s.producer.ProduceEvent(stream.Context(), projectID, enrichedEvent.SessionID, enrichedEvent.Type, enrichedEvent)
 			if err != nil {
+				s.validator.ReleaseIdempotencyKey(stream.Context(), projectID, idemKey)
+				s.auditor.Record(projectID, err.Error(), "grpc", 1, eventMap)
 				rejected++
 				errors = append(errors, err.Error())
 				continue
@@ -109,6 +184,9 @@ func (s *IngestServer) protoEventToMap(event *pb.Event, projectID string, sessio
 	eventMap["type"] = event.Type.String()
 	eventMap["timestamp"] = float64(event.Timestamp)
 	eventMap["project_id"] = projectID
+	if event.IdempotencyKey != "" {
+		eventMap["idempotency_key"] = event.IdempotencyKey
+	}
 
 	if session != nil {
 		eventMap["session_id"] = session.SessionId
@@ -127,18 +205,14 @@ func (s *IngestServer) protoEventToMap(event *pb.Event, projectID string, sessio
 	// Handle payload based on event type
 	switch p := event.Payload.(type) {
 	case *pb.Event_Click:
-		eventMap["payload"] = map[string]interface{}{
+		payload := map[string]interface{}{
 			"x": p.Click.X,
 			"y": p.Click.Y,
 		}
 		if p.Click.Target != nil {
-			eventMap["target"] = map[string]interface{}{
-				"tag":      p.Click.Target.Tag,
-				"selector": p.Click.Target.Selector,
-				"id":       p.Click.Target.Id,
-				"text":     p.Click.Target.Text,
-			}
+			addTargetFields(payload, p.Click.Target)
 		}
+		eventMap["payload"] = payload
 	case *pb.Event_Scroll:
 		eventMap["payload"] = map[string]interface{}{
 			"scroll_top":      p.Scroll.ScrollTop,
@@ -186,7 +260,37 @@ func (s *IngestServer) protoEventToMap(event *pb.Event, projectID string, sessio
 	return eventMap
 }
 
+// addTargetFields adds a click target to the payload under the keys the web SDK
+// uses (target_selector, target_tag, ...), which the processor reads for
+// selectors and dead click detection
+func addTargetFields(payload map[string]interface{}, target *pb.TargetElement) {
+	payload["target_tag"] = target.Tag
+	payload["target_selector"] = target.Selector
+	if target.Id != "" {
+		payload["target_id"] = target.Id
+	}
+	if target.Text != "" {
+		payload["target_text"] = target.Text
+	}
+	if target.Href != "" {
+		payload["target_href"] = target.Href
+	}
+	if role := target.Attributes["role"]; role != "" {
+		payload["target_role"] = role
+	}
+	if len(target.Classes) > 0 {
+		classes := make([]interface{}, len(target.Classes))
+		for i, c := range target.Classes {
+			classes[i] = c
+		}
+		payload["target_classes"] = classes
+	}
+}
+
 func (s *IngestServer) SendReplay(stream pb.IngestService_SendReplayServer) error {
+	// The stream's first chunk carries the project key and session ID; later
+	// chunks may omit the session ID to keep the previous one
+	var projectID, sessionID string
 	for {
 		chunk, err := stream.Recv()
 		if err == io.EOF {
@@ -199,8 +303,34 @@ func (s *IngestServer) SendReplay(stream pb.IngestService_SendReplayServer) erro
 			return err
 		}
 
+		if projectID == "" {
+			key, err := s.validator.ValidateAPIKey(stream.Context(), chunk.ProjectKey)
+			if err != nil {
+				return status.Error(codes.Unauthenticated, "Invalid API key")
+			}
+			projectID = key.ProjectID
+		}
+		if chunk.SessionId != "" {
+			sessionID = chunk.SessionId
+		}
+		if sessionID == "" {
+			return status.Error(codes.InvalidArgument, "replay chunk without session_id")
+		}
+
+		// Protobuf chunks carry no consent flags: strict consent.mode drops them
+		if !s.validator.ResolveConsent().Replay {
+			continue
+		}
+
+		// Replay sampling: chunks of sessions not sampled are dropped
+		if !s.validator.SampleReplay(stream.Context(), projectID, sessionID) {
+			continue
+		}
+
 		// Create chunk map for Kafka
 		chunkMap := map[string]interface{}{
+			"project_id":        projectID,
+			"session_id":        sessionID,
 			"chunk_index":       chunk.ChunkIndex,
 			"timestamp_start":   chunk.TimestampStart,
 			"timestamp_end":     chunk.TimestampEnd,
@@ -208,12 +338,36 @@ func (s *IngestServer) SendReplay(stream pb.IngestService_SendReplayServer) erro
 			"has_full_snapshot": chunk.HasFullSnapshot,
 		}
 
-		// Produce to Kafka replay topic
-		// Note: gRPC ReplayChunk doesn't have session_id, using empty string
-		// TODO: Update proto to include session_id for proper Kafka partitioning
-		err = s.producer.ProduceReplayChunk(context.Background(), "", chunkMap)
+		// Produce to Kafka replay topic, keyed by session so a session's
+		// chunks stay in order on one partition
+		err = s.producer.ProduceReplayChunk(context.Background(), projectID, sessionID, chunkMap)
 		if err != nil {
-			log.Error().Err(err).Msg("Failed to produce replay chunk")
+			log.Error().Err(err).Str("session_id", sessionID).Msg("Failed to produce replay chunk")
 		}
 	}
 }
+
+// limitExceededAck rejects a batch over a limit, telling the client which
+// limit and, when retrying can succeed, how long to wait
+func limitExceededAck(limit validation.LimitResult, msg string, events int) *pb.EventAck {
+	return &pb.EventAck{
+		Success:       false,
+		RejectedCount: int32(events),
+		Errors:        []string{msg},
+		LimitType:     limit.LimitType,
+		RetryAfterMs:  limit.RetryAfter.Milliseconds(),
+	}
+}
+
+// peerIP returns the IP of a stream's client, as seen by the transport
+func peerIP(ctx context.Context) string {
+	p, ok := peer.FromContext(ctx)
+	if !ok || p.Addr == nil {
+		return ""
+	}
+	host, _, err := net.SplitHostPort(p.Addr.String())
+	if err != nil {
+		return p.Addr.String()
+	}
+	return host
+}
@@ -0,0 +1,111 @@
+package server
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"os"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+
+	"github.com/gosight/gosight/ingestor/internal/config"
+)
+
+// ServerOptions returns the gRPC server options for server.grpc_tls: TLS
+// credentials and, when client names are restricted, an interceptor checking
+// the client certificate. No options (plaintext) when TLS isn't configured.
+func ServerOptions(cfg config.GRPCTLSConfig) ([]grpc.ServerOption, error) {
+	if !cfg.Enabled() {
+		return nil, nil
+	}
+
+	tlsCfg, err := newTLSConfig(cfg)
+	if err != nil {
+		return nil, err
+	}
+	opts := []grpc.ServerOption{grpc.Creds(credentials.NewTLS(tlsCfg))}
+
+	if len(cfg.AllowedClientNames) > 0 {
+		opts = append(opts, grpc.StreamInterceptor(clientNameInterceptor(cfg.AllowedClientNames)))
+	}
+	return opts, nil
+}
+
+func newTLSConfig(cfg config.GRPCTLSConfig) (*tls.Config, error) {
+	cert, err := tls.LoadX509KeyPair(cfg.CertFile, cfg.KeyFile)
+	if err != nil {
+		return nil, fmt.Errorf("load gRPC TLS certificate: %w", err)
+	}
+	tlsCfg := &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		MinVersion:   tls.VersionTLS12,
+	}
+
+	if cfg.ClientCAFile == "" {
+		if len(cfg.AllowedClientNames) > 0 {
+			return nil, errors.New("grpc_tls.allowed_client_names requires grpc_tls.client_ca_file")
+		}
+		return tlsCfg, nil
+	}
+
+	caPEM, err := os.ReadFile(cfg.ClientCAFile)
+	if err != nil {
+		return nil, fmt.Errorf("read gRPC client CA: %w", err)
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(caPEM) {
+		return nil, fmt.Errorf("no certificates found in gRPC client CA %s", cfg.ClientCAFile)
+	}
+	tlsCfg.ClientCAs = pool
+
+	switch cfg.ClientAuth {
+	case config.ClientAuthRequire:
+		tlsCfg.ClientAuth = tls.RequireAndVerifyClientCert
+	case config.ClientAuthOptional:
+		tlsCfg.ClientAuth = tls.VerifyClientCertIfGiven
+	default:
+		return nil, fmt.Errorf("unknown grpc_tls.client_auth %q (want %s or %s)",
+			cfg.ClientAuth, config.ClientAuthRequire, config.ClientAuthOptional)
+	}
+	return tlsCfg, nil
+}
+
+// clientNameInterceptor only lets through streams whose verified client
+// certificate has one of the allowed subject common names. It is checked in
+// addition to the API key, so a leaked key alone can't be used from elsewhere.
+func clientNameInterceptor(names []string) grpc.StreamServerInterceptor {
+	allowed := make(map[string]bool, len(names))
+	for _, name := range names {
+		allowed[name] = true
+	}
+
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		name, ok := clientName(ss.Context())
+		if !ok {
+			return status.Error(codes.Unauthenticated, "client certificate required")
+		}
+		if !allowed[name] {
+			return status.Errorf(codes.PermissionDenied, "client certificate %q is not allowed", name)
+		}
+		return handler(srv, ss)
+	}
+}
+
+// clientName returns the subject common name of the stream's verified client certificate
+func clientName(ctx context.Context) (string, bool) {
+	p, ok := peer.FromContext(ctx)
+	if !ok {
+		return "", false
+	}
+	tlsInfo, ok := p.AuthInfo.(credentials.TLSInfo)
+	if !ok || len(tlsInfo.State.VerifiedChains) == 0 || len(tlsInfo.State.VerifiedChains[0]) == 0 {
+		return "", false
+	}
+	return tlsInfo.State.VerifiedChains[0][0].Subject.CommonName, true
+}
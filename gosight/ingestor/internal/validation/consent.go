@@ -0,0 +1,28 @@
+package validation
+
+import (
+	"github.com/gosight/gosight/ingestor/internal/config"
+	"github.com/gosight/gosight/ingestor/internal/enricher"
+)
+
+// ResolveConsent resolves the consent flags ("analytics", "replay") sent by
+// the client, given from the most to the least specific (e.g. the event's
+// consent object, then the batch's). Each flag is taken from the first object
+// carrying it; a flag sent nowhere is granted in permissive mode and denied in
+// strict mode. An explicit false is always honored.
+func (v *Validator) ResolveConsent(sent ...map[string]interface{}) enricher.Consent {
+	granted := v.cfg.Consent.Mode != config.ConsentModeStrict
+	return enricher.Consent{
+		Analytics: consentFlag(sent, "analytics", granted),
+		Replay:    consentFlag(sent, "replay", granted),
+	}
+}
+
+func consentFlag(sent []map[string]interface{}, flag string, fallback bool) bool {
+	for _, consent := range sent {
+		if v, ok := consent[flag].(bool); ok {
+			return v
+		}
+	}
+	return fallback
+}
@@ -0,0 +1,39 @@
+package validation
+
+import (
+	"context"
+)
+
+// idempotencyKeyPrefix marks client idempotency keys seen within the window
+const idempotencyKeyPrefix = "idem:"
+
+// IdempotencyKey returns the event's client-provided idempotency_key, if any
+func IdempotencyKey(event map[string]interface{}) string {
+	key, _ := event["idempotency_key"].(string)
+	return key
+}
+
+// ClaimIdempotencyKey reports whether the key is new for the project within
+// idempotency.window, claiming it if so. A false result means the client fired
+// the same event twice (e.g. on render and again on hydration). Always true
+// when idempotency is disabled or the key is empty.
+func (v *Validator) ClaimIdempotencyKey(ctx context.Context, projectID, key string) bool {
+	if !v.cfg.Idempotency.Enabled || key == "" {
+		return true
+	}
+
+	ok, err := v.redis.SetNX(ctx, idempotencyKeyPrefix+projectID+":"+key, 1, v.cfg.Idempotency.Window).Result()
+	if err != nil {
+		return true // Accept on error
+	}
+	return ok
+}
+
+// ReleaseIdempotencyKey releases a claimed key, e.g. when the event could not
+// be produced, so the client's retry is not taken for a duplicate
+func (v *Validator) ReleaseIdempotencyKey(ctx context.Context, projectID, key string) {
+	if !v.cfg.Idempotency.Enabled || key == "" {
+		return
+	}
+	v.redis.Del(ctx, idempotencyKeyPrefix+projectID+":"+key)
+}
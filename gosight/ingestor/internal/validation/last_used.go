@@ -0,0 +1,80 @@
+package validation
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// lastUsedUpdater records API key use (last_used_at, request_count) on a
+// fixed pool of workers. Updates are dropped rather than queued without bound
+// when Postgres falls behind; they are only statistics.
+type lastUsedUpdater struct {
+	db      *pgxpool.Pool
+	timeout time.Duration
+	queue   chan string // key hashes
+	wg      sync.WaitGroup
+
+	dropped atomic.Uint64
+	failed  atomic.Uint64
+}
+
+func newLastUsedUpdater(db *pgxpool.Pool, workers, queue int, timeout time.Duration) *lastUsedUpdater {
+	u := &lastUsedUpdater{
+		db:      db,
+		timeout: timeout,
+		queue:   make(chan string, queue),
+	}
+	u.wg.Add(workers)
+	for range workers {
+		go u.work()
+	}
+	return u
+}
+
+// record queues an update of the key's use, or drops it when the queue is full
+func (u *lastUsedUpdater) record(keyHash string) {
+	select {
+	case u.queue <- keyHash:
+	default:
+		u.dropped.Add(1)
+	}
+}
+
+func (u *lastUsedUpdater) work() {
+	defer u.wg.Done()
+	for keyHash := range u.queue {
+		ctx, cancel := context.WithTimeout(context.Background(), u.timeout)
+		_, err := u.db.Exec(ctx, `
+			UPDATE api_keys
+			SET last_used_at = NOW(), request_count = request_count + 1
+			WHERE key_hash = $1
+		`, keyHash)
+		cancel()
+		if err != nil {
+			u.failed.Add(1)
+			log.Printf("[Validator] Failed to update API key last_used_at: %v", err)
+		}
+	}
+}
+
+// close stops the workers once the queued updates are done
+func (u *lastUsedUpdater) close() {
+	close(u.queue)
+	u.wg.Wait()
+}
+
+func (u *lastUsedUpdater) writeMetrics(w io.Writer) {
+	fmt.Fprintln(w, "# HELP gosight_ingestor_last_used_updates_dropped_total API key last_used_at updates dropped because the queue was full.")
+	fmt.Fprintln(w, "# TYPE gosight_ingestor_last_used_updates_dropped_total counter")
+	fmt.Fprintf(w, "gosight_ingestor_last_used_updates_dropped_total %d\n", u.dropped.Load())
+	fmt.Fprintln(w, "# HELP gosight_ingestor_last_used_updates_failed_total API key last_used_at updates that failed or timed out.")
+	fmt.Fprintln(w, "# TYPE gosight_ingestor_last_used_updates_failed_total counter")
+	fmt.Fprintf(w, "gosight_ingestor_last_used_updates_failed_total %d\n", u.failed.Load())
+}
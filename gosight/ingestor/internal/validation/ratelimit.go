@@ -0,0 +1,137 @@
+package validation
+
+import (
+	"context"
+	"math"
+	"sync"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+
+	"github.com/gosight/gosight/ingestor/internal/config"
+)
+
+// RateLimiter is a token bucket rate limiter: the bucket of each key holds up
+// to burst tokens, refilled at limit tokens per second, and each request takes
+// one. Allow reports whether the request is allowed and, when it isn't, how
+// long until a token is available.
+type RateLimiter interface {
+	Allow(ctx context.Context, key string, limit, burst int) (bool, time.Duration)
+}
+
+// NewRateLimiter returns the rate limiter of rate_limit.backend
+func NewRateLimiter(cfg config.RateLimitConfig, rdb *redis.Client) RateLimiter {
+	if cfg.Backend == config.RateLimitBackendMemory {
+		return NewMemoryRateLimiter()
+	}
+	return NewRedisRateLimiter(rdb)
+}
+
+// tokenBucketScript takes a token from the bucket of KEYS[1] (ARGV[1] tokens
+// per second, up to ARGV[2]), timed by the Redis clock so all ingestor
+// instances agree. It returns {allowed, ms until a token is available}.
+var tokenBucketScript = redis.NewScript(`
+local key, rate, burst = KEYS[1], tonumber(ARGV[1]), tonumber(ARGV[2])
+local t = redis.call('TIME')
+local now = t[1] * 1000 + math.floor(t[2] / 1000)
+local state = redis.call('HMGET', key, 'tokens', 'ts')
+local tokens, ts = tonumber(state[1]), tonumber(state[2])
+if not tokens then
+	tokens, ts = burst, now
+end
+tokens = math.min(burst, tokens + math.max(0, now - ts) * rate / 1000)
+local allowed, wait = 0, 0
+if tokens >= 1 then
+	tokens, allowed = tokens - 1, 1
+else
+	wait = math.ceil((1 - tokens) * 1000 / rate)
+end
+redis.call('HSET', key, 'tokens', tostring(tokens), 'ts', now)
+redis.call('PEXPIRE', key, math.ceil(burst * 1000 / rate) + 1000)
+return {allowed, wait}
+`)
+
+// redisRateLimiter shares buckets between ingestor instances through Redis
+type redisRateLimiter struct {
+	redis *redis.Client
+}
+
+// NewRedisRateLimiter returns a rate limiter keeping buckets in Redis, shared
+// by all ingestor instances. Requests are allowed when Redis fails.
+func NewRedisRateLimiter(rdb *redis.Client) RateLimiter {
+	return &redisRateLimiter{redis: rdb}
+}
+
+func (l *redisRateLimiter) Allow(ctx context.Context, key string, limit, burst int) (bool, time.Duration) {
+	res, err := tokenBucketScript.Run(ctx, l.redis, []string{"ratelimit:bucket:" + key}, limit, burst).Int64Slice()
+	if err != nil || len(res) != 2 {
+		return true, 0 // Allow on error
+	}
+	return res[0] == 1, time.Duration(res[1]) * time.Millisecond
+}
+
+// memorySweepInterval is how often idle buckets are dropped
+const memorySweepInterval = time.Minute
+
+// memoryRateLimiter keeps buckets in memory, for a single ingestor instance
+// or tests
+type memoryRateLimiter struct {
+	buckets   map[string]*tokenBucket
+	lastSweep time.Time
+	mu        sync.Mutex
+}
+
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+	full   time.Time // when the bucket refills to burst; dropped by sweeps after
+}
+
+// NewMemoryRateLimiter returns a rate limiter keeping buckets in memory. Each
+// ingestor instance limits on its own, so with several instances the limits
+// apply per instance.
+func NewMemoryRateLimiter() RateLimiter {
+	return &memoryRateLimiter{
+		buckets:   make(map[string]*tokenBucket),
+		lastSweep: time.Now(),
+	}
+}
+
+func (l *memoryRateLimiter) Allow(ctx context.Context, key string, limit, burst int) (bool, time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := time.Now()
+	if now.Sub(l.lastSweep) >= memorySweepInterval {
+		l.sweep(now)
+	}
+
+	rate := float64(limit)
+	b, ok := l.buckets[key]
+	if !ok {
+		b = &tokenBucket{tokens: float64(burst), last: now}
+		l.buckets[key] = b
+	}
+	b.tokens = math.Min(float64(burst), b.tokens+now.Sub(b.last).Seconds()*rate)
+	b.last = now
+
+	allowed := b.tokens >= 1
+	var wait time.Duration
+	if allowed {
+		b.tokens--
+	} else {
+		wait = time.Duration(math.Ceil((1 - b.tokens) / rate * float64(time.Second)))
+	}
+	b.full = now.Add(time.Duration((float64(burst) - b.tokens) / rate * float64(time.Second)))
+	return allowed, wait
+}
+
+// sweep drops the buckets full again by now, which a new bucket replaces as is
+func (l *memoryRateLimiter) sweep(now time.Time) {
+	for key, b := range l.buckets {
+		if !now.Before(b.full) {
+			delete(l.buckets, key)
+		}
+	}
+	l.lastSweep = now
+}
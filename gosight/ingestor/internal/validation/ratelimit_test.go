@@ -0,0 +1,84 @@
+package validation
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// testRateLimiter checks the token bucket behavior shared by the backends
+func testRateLimiter(t *testing.T, l RateLimiter) {
+	ctx := context.Background()
+	key := fmt.Sprintf("test:%d", time.Now().UnixNano())
+	const limit, burst = 50, 5
+
+	// A burst is allowed at once, then requests wait for the refill
+	for i := 0; i < burst; i++ {
+		if allowed, _ := l.Allow(ctx, key, limit, burst); !allowed {
+			t.Fatalf("request %d of the burst rejected", i+1)
+		}
+	}
+	allowed, wait := l.Allow(ctx, key, limit, burst)
+	if allowed {
+		t.Fatal("request over the burst allowed")
+	}
+	if wait <= 0 || wait > time.Second/limit+5*time.Millisecond {
+		t.Errorf("retry after %v, want about %v", wait, time.Second/limit)
+	}
+	time.Sleep(wait)
+	if allowed, _ := l.Allow(ctx, key, limit, burst); !allowed {
+		t.Error("request rejected after waiting the retry delay")
+	}
+
+	// Sustained load is held to the rate
+	other := key + ":sustained"
+	start := time.Now()
+	n := 0
+	for time.Since(start) < 400*time.Millisecond {
+		if allowed, _ := l.Allow(ctx, other, limit, burst); allowed {
+			n++
+		}
+		time.Sleep(time.Millisecond)
+	}
+	want := burst + int(time.Since(start).Seconds()*limit)
+	if n < want-3 || n > want+1 {
+		t.Errorf("allowed %d requests in %v, want about %d", n, time.Since(start), want)
+	}
+}
+
+func TestMemoryRateLimiter(t *testing.T) {
+	testRateLimiter(t, NewMemoryRateLimiter())
+}
+
+func TestRedisRateLimiter(t *testing.T) {
+	addr := os.Getenv("REDIS_ADDR")
+	if addr == "" {
+		addr = "localhost:6379"
+	}
+	rdb := redis.NewClient(&redis.Options{Addr: addr, DialTimeout: 200 * time.Millisecond, MaxRetries: -1})
+	defer rdb.Close()
+	if err := rdb.Ping(context.Background()).Err(); err != nil {
+		t.Skipf("Redis unavailable at %s: %v", addr, err)
+	}
+	testRateLimiter(t, NewRedisRateLimiter(rdb))
+}
+
+func TestMemoryRateLimiterSweepsFullBuckets(t *testing.T) {
+	l := NewMemoryRateLimiter().(*memoryRateLimiter)
+	ctx := context.Background()
+	l.Allow(ctx, "idle", 1000, 10)
+	l.Allow(ctx, "busy", 1, 10)
+
+	// Half a second later the idle bucket is full again, the busy one isn't
+	l.sweep(time.Now().Add(500 * time.Millisecond))
+	if _, ok := l.buckets["idle"]; ok {
+		t.Error("full bucket kept")
+	}
+	if _, ok := l.buckets["busy"]; !ok {
+		t.Error("refilling bucket dropped")
+	}
+}
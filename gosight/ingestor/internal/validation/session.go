@@ -0,0 +1,54 @@
+package validation
+
+import (
+	"errors"
+	"strings"
+
+	"github.com/google/uuid"
+
+	"github.com/gosight/gosight/ingestor/internal/config"
+)
+
+// ErrMissingSession rejects events without a session_id
+// (validation.missing_session: reject)
+var ErrMissingSession = errors.New("event has no session_id")
+
+// SessionAssigner resolves the session of events sent without one, per
+// validation.missing_session: they are rejected, or all assigned one session
+// generated for the request (HTTP batch, gRPC batch or WebSocket connection).
+// Session-less events would otherwise share the empty session in session
+// aggregation and insight detection.
+type SessionAssigner struct {
+	assign    bool
+	sessionID string
+}
+
+// NewSessionAssigner creates the session assigner of a request
+func (v *Validator) NewSessionAssigner() *SessionAssigner {
+	return &SessionAssigner{assign: v.cfg.Validation.MissingSession == config.MissingSessionAssign}
+}
+
+// Resolve returns sessionID, or the request's generated session when it is empty
+func (a *SessionAssigner) Resolve(sessionID string) (string, error) {
+	if strings.TrimSpace(sessionID) != "" {
+		return sessionID, nil
+	}
+	if !a.assign {
+		return "", ErrMissingSession
+	}
+	if a.sessionID == "" {
+		a.sessionID = uuid.New().String()
+	}
+	return a.sessionID, nil
+}
+
+// Assign resolves the session_id of an event
+func (a *SessionAssigner) Assign(event map[string]interface{}) error {
+	sessionID, _ := event["session_id"].(string)
+	sessionID, err := a.Resolve(sessionID)
+	if err != nil {
+		return err
+	}
+	event["session_id"] = sessionID
+	return nil
+}
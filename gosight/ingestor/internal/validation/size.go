@@ -0,0 +1,128 @@
+package validation
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sync/atomic"
+	"unicode/utf8"
+)
+
+// TruncationMarker is appended to strings cut to validation.max_field_length
+const TruncationMarker = "...[truncated]"
+
+// Fields counted by the truncation metric. Custom properties are counted
+// once per event, however many of them were cut.
+const (
+	TruncatedStack      = "stack"
+	TruncatedMessage    = "message"
+	TruncatedProperties = "properties"
+)
+
+var truncatedFields = []string{TruncatedStack, TruncatedMessage, TruncatedProperties}
+
+// sizeMetrics counts field truncations and events rejected as oversized
+type sizeMetrics struct {
+	truncations [3]atomic.Uint64 // indexed like truncatedFields
+	oversized   atomic.Uint64
+}
+
+func (m *sizeMetrics) inc(field string) {
+	for i, f := range truncatedFields {
+		if f == field {
+			m.truncations[i].Add(1)
+			return
+		}
+	}
+}
+
+// LimitEventSize truncates the payload's stack, message and custom property
+// strings to validation.max_field_length, then rejects the event if it still
+// serializes to more than validation.max_event_bytes. The event is modified in
+// place.
+func (v *Validator) LimitEventSize(event map[string]interface{}) error {
+	maxLen := v.cfg.Validation.MaxFieldLength
+	if payload, ok := event["payload"].(map[string]interface{}); ok {
+		for _, field := range []string{TruncatedStack, TruncatedMessage} {
+			if s, ok := payload[field].(string); ok && len(s) > maxLen {
+				payload[field] = truncateString(s, maxLen)
+				v.sizeMetrics.inc(field)
+			}
+		}
+		if truncateProperties(payload["properties"], maxLen) {
+			v.sizeMetrics.inc(TruncatedProperties)
+		}
+	}
+
+	data, err := json.Marshal(event)
+	if err != nil {
+		return fmt.Errorf("event can't be serialized: %w", err)
+	}
+	if len(data) > v.cfg.Validation.MaxEventBytes {
+		v.sizeMetrics.oversized.Add(1)
+		return fmt.Errorf("event is %d bytes, max is %d", len(data), v.cfg.Validation.MaxEventBytes)
+	}
+	return nil
+}
+
+// truncateProperties truncates the string values of custom properties,
+// including nested ones, reporting whether any were cut
+func truncateProperties(value interface{}, maxLen int) bool {
+	truncated := false
+	switch p := value.(type) {
+	case map[string]interface{}:
+		for k, v := range p {
+			if s, ok := v.(string); ok {
+				if len(s) > maxLen {
+					p[k] = truncateString(s, maxLen)
+					truncated = true
+				}
+			} else if truncateProperties(v, maxLen) {
+				truncated = true
+			}
+		}
+	case []interface{}:
+		for i, v := range p {
+			if s, ok := v.(string); ok {
+				if len(s) > maxLen {
+					p[i] = truncateString(s, maxLen)
+					truncated = true
+				}
+			} else if truncateProperties(v, maxLen) {
+				truncated = true
+			}
+		}
+	case map[string]string: // gRPC custom events
+		for k, s := range p {
+			if len(s) > maxLen {
+				p[k] = truncateString(s, maxLen)
+				truncated = true
+			}
+		}
+	}
+	return truncated
+}
+
+// truncateString cuts s to at most maxLen bytes, on a rune boundary, and
+// appends the truncation marker
+func truncateString(s string, maxLen int) string {
+	for maxLen > 0 && !utf8.RuneStart(s[maxLen]) {
+		maxLen--
+	}
+	return s[:maxLen] + TruncationMarker
+}
+
+// WriteMetrics writes the validator's metrics in the Prometheus text format.
+// A steady rate of truncations or oversized events means a client is sending
+// pathological data (e.g. whole state dumps as custom properties).
+func (v *Validator) WriteMetrics(w io.Writer) {
+	fmt.Fprintln(w, "# HELP gosight_ingestor_field_truncations_total Event fields truncated to max_field_length.")
+	fmt.Fprintln(w, "# TYPE gosight_ingestor_field_truncations_total counter")
+	for i, f := range truncatedFields {
+		fmt.Fprintf(w, "gosight_ingestor_field_truncations_total{field=%q} %d\n", f, v.sizeMetrics.truncations[i].Load())
+	}
+	fmt.Fprintln(w, "# HELP gosight_ingestor_oversized_events_total Events rejected for exceeding max_event_bytes.")
+	fmt.Fprintln(w, "# TYPE gosight_ingestor_oversized_events_total counter")
+	fmt.Fprintf(w, "gosight_ingestor_oversized_events_total %d\n", v.sizeMetrics.oversized.Load())
+	v.lastUsed.writeMetrics(w)
+}
@@ -5,18 +5,31 @@ import (
 	"crypto/sha256"
 	"encoding/hex"
 	"errors"
+	"fmt"
+	"hash/fnv"
+	"log"
+	"strings"
 	"time"
 
 	"github.com/jackc/pgx/v5/pgxpool"
 	"github.com/redis/go-redis/v9"
 
 	"github.com/gosight/gosight/ingestor/internal/config"
+	"github.com/gosight/gosight/ingestor/internal/settings"
+	pb "github.com/gosight/gosight/ingestor/proto/gosight"
 )
 
 type Validator struct {
 	db    *pgxpool.Pool
 	redis *redis.Client
 	cfg   *config.Config
+
+	// Per-project replay sample rates; nil when project settings are disabled
+	settings *settings.Loader
+
+	sizeMetrics sizeMetrics
+	lastUsed    *lastUsedUpdater
+	rateLimiter RateLimiter
 }
 
 func NewValidator(cfg *config.Config) (*Validator, error) {
@@ -33,81 +46,224 @@ func NewValidator(cfg *config.Config) (*Validator, error) {
 		DB:       cfg.Redis.DB,
 	})
 
-	return &Validator{
+	v := &Validator{
 		db:    db,
 		redis: rdb,
 		cfg:   cfg,
-	}, nil
+
+		lastUsed:    newLastUsedUpdater(db, cfg.Postgres.LastUsedWorkers, cfg.Postgres.LastUsedQueue, cfg.Postgres.QueryTimeout),
+		rateLimiter: NewRateLimiter(cfg.RateLimit, rdb),
+	}
+	if cfg.ProjectSettings.Enabled {
+		v.settings = settings.NewLoader(db, cfg.ProjectSettings)
+	}
+	return v, nil
+}
+
+// APIKey is a validated API key
+type APIKey struct {
+	ProjectID string
+	// Test keys (api_keys.is_test) mark all their events synthetic, e.g. for
+	// QA and synthetic monitoring
+	Test bool
 }
 
-func (v *Validator) ValidateAPIKey(ctx context.Context, apiKey string) (string, error) {
+// testKeySuffix marks cached test keys ("<project ID>:test")
+const testKeySuffix = ":test"
+
+func (v *Validator) ValidateAPIKey(ctx context.Context, apiKey string) (APIKey, error) {
 	if len(apiKey) < 12 {
-		return "", errors.New("invalid API key format")
+		return APIKey{}, errors.New("invalid API key format")
 	}
 
 	// Check cache first
 	cacheKey := "apikey:" + apiKey[:12]
-	projectID, err := v.redis.Get(ctx, cacheKey).Result()
+	cached, err := v.redis.Get(ctx, cacheKey).Result()
 	if err == nil {
-		return projectID, nil
+		projectID, test := strings.CutSuffix(cached, testKeySuffix)
+		return APIKey{ProjectID: projectID, Test: test}, nil
 	}
 
 	// Hash the key
 	hash := sha256.Sum256([]byte(apiKey))
 	keyHash := hex.EncodeToString(hash[:])
 
-	// Query database
-	var id string
-	err = v.db.QueryRow(ctx, `
-		SELECT project_id::text FROM api_keys
+	// Query database, bounded so a stalled Postgres can't hang the request
+	queryCtx, cancel := context.WithTimeout(ctx, v.cfg.Postgres.QueryTimeout)
+	defer cancel()
+	var key APIKey
+	err = v.db.QueryRow(queryCtx, `
+		SELECT project_id::text, is_test FROM api_keys
 		WHERE key_hash = $1 AND is_active = true
 		AND (expires_at IS NULL OR expires_at > NOW())
-	`, keyHash).Scan(&id)
+	`, keyHash).Scan(&key.ProjectID, &key.Test)
 
+	if errors.Is(err, context.DeadlineExceeded) {
+		log.Printf("[Validator] API key lookup timed out after %s", v.cfg.Postgres.QueryTimeout)
+	}
 	if err != nil {
-		return "", errors.New("invalid API key")
+		return APIKey{}, errors.New("invalid API key")
 	}
 
 	// Cache for 5 minutes
-	v.redis.Set(ctx, cacheKey, id, 5*time.Minute)
+	cached = key.ProjectID
+	if key.Test {
+		cached += testKeySuffix
+	}
+	v.redis.Set(ctx, cacheKey, cached, 5*time.Minute)
 
 	// Update last used
-	go v.db.Exec(context.Background(), `
-		UPDATE api_keys
-		SET last_used_at = NOW(), request_count = request_count + 1
-		WHERE key_hash = $1
-	`, keyHash)
+	v.lastUsed.record(keyHash)
 
-	return id, nil
+	return key, nil
 }
 
-func (v *Validator) CheckRateLimit(projectID string) bool {
-	ctx := context.Background()
-	key := "ratelimit:" + projectID
+// Limit types reported to clients as limit_type when a limit is exceeded
+const (
+	LimitProjectRate = "project_rate" // requests per second per project
+	LimitIPRate      = "ip_rate"      // requests per second per client IP
+	LimitEventCount  = "event_count"  // events per batch
+)
 
-	// Increment counter
-	count, err := v.redis.Incr(ctx, key).Result()
-	if err != nil {
-		return true // Allow on error
+// LimitResult is the outcome of a limit check. RetryAfter is zero when
+// retrying the same request can't succeed (e.g. the batch must be split).
+type LimitResult struct {
+	Allowed    bool
+	LimitType  string
+	RetryAfter time.Duration
+	Message    string
+}
+
+var allowed = LimitResult{Allowed: true}
+
+// CheckRateLimit applies the per-project rate limit
+func (v *Validator) CheckRateLimit(projectID string) LimitResult {
+	limit := v.cfg.RateLimit
+	if limit.RequestsPerSecond <= 0 {
+		return allowed
 	}
 
-	// Set expiry on first request
-	if count == 1 {
-		v.redis.Expire(ctx, key, time.Second)
+	ok, retryAfter := v.rateLimiter.Allow(context.Background(), projectID, limit.RequestsPerSecond, limit.Burst)
+	if ok {
+		return allowed
 	}
+	return LimitResult{
+		LimitType:  LimitProjectRate,
+		RetryAfter: retryAfter,
+		Message:    "Rate limit exceeded",
+	}
+}
 
-	return count <= int64(v.cfg.RateLimit.RequestsPerSecond)
+// CheckIPRateLimit applies the per client IP rate limit
+func (v *Validator) CheckIPRateLimit(ip string) LimitResult {
+	limit := v.cfg.RateLimit
+	if limit.IPRequestsPerSecond <= 0 || ip == "" {
+		return allowed
+	}
+
+	ok, retryAfter := v.rateLimiter.Allow(context.Background(), "ip:"+ip, limit.IPRequestsPerSecond, limit.IPBurst)
+	if ok {
+		return allowed
+	}
+	return LimitResult{
+		LimitType:  LimitIPRate,
+		RetryAfter: retryAfter,
+		Message:    "Rate limit exceeded for client IP",
+	}
 }
 
+// replayKeepKeyPrefix marks sessions with a detected insight; set by the
+// insights processor (replay_keep) and shared with it
+const replayKeepKeyPrefix = "replay:keep:"
+
+// SampleReplay reports whether replay chunks of the session should be kept.
+// Chunks received before a session's first insight follow the sample rate.
+func (v *Validator) SampleReplay(ctx context.Context, projectID, sessionID string) bool {
+	sampling := v.cfg.ReplaySampling
+	if !sampling.Enabled {
+		return true
+	}
+
+	rate := sampling.SampleRate
+	if projectRate, ok := sampling.ProjectRates[projectID]; ok {
+		rate = projectRate
+	}
+	if v.settings != nil {
+		if projectRate := v.settings.Get(projectID).ReplaySampleRate; projectRate != nil {
+			rate = *projectRate
+		}
+	}
+	if sessionSample(sessionID) < rate {
+		return true
+	}
+
+	if sampling.KeepWithInsight {
+		n, err := v.redis.Exists(ctx, replayKeepKeyPrefix+projectID+":"+sessionID).Result()
+		if err != nil {
+			return true // Keep on error
+		}
+		return n > 0
+	}
+	return false
+}
+
+// sessionSample maps a session ID to a stable value in [0, 1)
+func sessionSample(sessionID string) float64 {
+	h := fnv.New32a()
+	h.Write([]byte(sessionID))
+	return float64(h.Sum32()) / (1 << 32)
+}
+
+// CheckBatchSize rejects batches with more events than max_events_per_batch
+func (v *Validator) CheckBatchSize(count int) LimitResult {
+	if count > v.cfg.Batch.MaxEventsPerBatch {
+		return LimitResult{
+			LimitType: LimitEventCount,
+			Message:   fmt.Sprintf("batch contains %d events, max is %d", count, v.cfg.Batch.MaxEventsPerBatch),
+		}
+	}
+	return allowed
+}
+
+// ValidateEvent validates a single event, either a decoded JSON event (HTTP,
+// WebSocket) or a *pb.Event (gRPC)
 func (v *Validator) ValidateEvent(event interface{}) error {
-	// Basic validation
-	// - Required fields
-	// - Field types
-	// - Value ranges
+	var timestamp int64
+	switch e := event.(type) {
+	case *pb.Event:
+		timestamp = e.Timestamp
+	case map[string]interface{}:
+		if ts, ok := e["timestamp"].(float64); ok {
+			timestamp = int64(ts)
+		}
+	}
+
+	return v.ValidateTimestamp(timestamp, time.Now())
+}
+
+// ValidateTimestamp rejects event timestamps (unix ms) further than
+// validation.max_timestamp_skew from the server time. Events without a
+// timestamp are not checked.
+func (v *Validator) ValidateTimestamp(timestamp int64, serverTime time.Time) error {
+	if timestamp == 0 {
+		return nil
+	}
+
+	skew := time.UnixMilli(timestamp).Sub(serverTime)
+	if skew > v.cfg.Validation.MaxTimestampSkew || skew < -v.cfg.Validation.MaxTimestampSkew {
+		return fmt.Errorf("event timestamp is %s from server time, max allowed is %s",
+			skew.Round(time.Second), v.cfg.Validation.MaxTimestampSkew)
+	}
 	return nil
 }
 
 func (v *Validator) Close() {
+	if v.settings != nil {
+		v.settings.Close()
+	}
+	if v.lastUsed != nil {
+		v.lastUsed.close()
+	}
 	if v.db != nil {
 		v.db.Close()
 	}
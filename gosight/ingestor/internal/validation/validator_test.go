@@ -0,0 +1,95 @@
+package validation
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gosight/gosight/ingestor/internal/config"
+	pb "github.com/gosight/gosight/ingestor/proto/gosight"
+)
+
+func TestCheckIPRateLimit(t *testing.T) {
+	v := &Validator{
+		cfg: &config.Config{RateLimit: config.RateLimitConfig{
+			RequestsPerSecond:   1000,
+			Burst:               1000,
+			IPRequestsPerSecond: 1,
+			IPBurst:             2,
+		}},
+		rateLimiter: NewMemoryRateLimiter(),
+	}
+
+	for i := 0; i < 2; i++ {
+		if limit := v.CheckIPRateLimit("203.0.113.7"); !limit.Allowed {
+			t.Fatalf("request %d rejected within the burst", i+1)
+		}
+	}
+	limit := v.CheckIPRateLimit("203.0.113.7")
+	if limit.Allowed || limit.LimitType != LimitIPRate || limit.RetryAfter <= 0 {
+		t.Errorf("limit = %+v, want ip_rate with a retry delay", limit)
+	}
+
+	// Other clients and the project limit keep their own buckets
+	if !v.CheckIPRateLimit("203.0.113.8").Allowed {
+		t.Error("another client IP was limited")
+	}
+	if !v.CheckRateLimit("203.0.113.7").Allowed {
+		t.Error("the IP bucket is shared with a project of the same name")
+	}
+}
+
+func TestReadVisitorCookieIgnoresOtherOrigins(t *testing.T) {
+	v := &Validator{cfg: &config.Config{Visitor: config.VisitorConfig{
+		Enabled:        true,
+		CookieName:     "visitor_id",
+		AllowedOrigins: []string{"https://www.example.com"},
+	}}}
+
+	for origin, want := range map[string]string{
+		"":                         "0b6f2c4e-visitor",
+		"https://www.example.com":  "0b6f2c4e-visitor",
+		"https://evil.example.net": "",
+	} {
+		r := httptest.NewRequest(http.MethodGet, "/v1/ws", nil)
+		r.AddCookie(&http.Cookie{Name: "visitor_id", Value: "0b6f2c4e-visitor"})
+		if origin != "" {
+			r.Header.Set("Origin", origin)
+		}
+		if got := v.ReadVisitorCookie(r); got != want {
+			t.Errorf("origin %q: visitor ID = %q, want %q", origin, got, want)
+		}
+	}
+}
+
+func TestValidateTimestamp(t *testing.T) {
+	v := &Validator{cfg: &config.Config{Validation: config.ValidationConfig{MaxTimestampSkew: 24 * time.Hour}}}
+	now := time.Now()
+
+	tests := []struct {
+		name      string
+		timestamp int64
+		wantErr   bool
+	}{
+		{"now", now.UnixMilli(), false},
+		{"an hour ago", now.Add(-time.Hour).UnixMilli(), false},
+		{"an hour ahead", now.Add(time.Hour).UnixMilli(), false},
+		{"no timestamp", 0, false},
+		{"two days ago", now.Add(-48 * time.Hour).UnixMilli(), true},
+		{"two days ahead", now.Add(48 * time.Hour).UnixMilli(), true},
+		{"years ago", time.Date(2001, 1, 1, 0, 0, 0, 0, time.UTC).UnixMilli(), true},
+	}
+	for _, tt := range tests {
+		if err := v.ValidateTimestamp(tt.timestamp, now); (err != nil) != tt.wantErr {
+			t.Errorf("%s: err = %v, want error %v", tt.name, err, tt.wantErr)
+		}
+		// HTTP and gRPC events are checked alike
+		if err := v.ValidateEvent(map[string]interface{}{"timestamp": float64(tt.timestamp)}); (err != nil) != tt.wantErr {
+			t.Errorf("%s: JSON event err = %v, want error %v", tt.name, err, tt.wantErr)
+		}
+		if err := v.ValidateEvent(&pb.Event{Timestamp: tt.timestamp}); (err != nil) != tt.wantErr {
+			t.Errorf("%s: proto event err = %v, want error %v", tt.name, err, tt.wantErr)
+		}
+	}
+}
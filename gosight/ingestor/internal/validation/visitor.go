@@ -0,0 +1,89 @@
+package validation
+
+import (
+	"net/http"
+	"regexp"
+	"slices"
+
+	"github.com/google/uuid"
+
+	"github.com/gosight/gosight/ingestor/internal/enricher"
+)
+
+// anonymousIDPattern restricts anonymous IDs, from cookies or clients, to
+// opaque IDs such as UUIDs
+var anonymousIDPattern = regexp.MustCompile(`^[A-Za-z0-9_-]{8,64}$`)
+
+// DoNotTrack reports whether the request carries a Do Not Track or Global
+// Privacy Control signal; such requests get no visitor cookie or anonymous ID
+func DoNotTrack(r *http.Request) bool {
+	return r.Header.Get("DNT") == "1" || r.Header.Get("Sec-GPC") == "1"
+}
+
+// VisitorCookie returns the visitor ID of the request's visitor cookie,
+// setting a new cookie when it has none. Nothing is read or set when visitor
+// IDs are disabled, without analytics consent, with Do Not Track or for
+// origins not in visitor.allowed_origins.
+func (v *Validator) VisitorCookie(w http.ResponseWriter, r *http.Request, consent enricher.Consent) string {
+	cfg := v.cfg.Visitor
+	if !cfg.Enabled || !consent.Analytics || DoNotTrack(r) || !v.credentialOrigin(r) {
+		return ""
+	}
+
+	if visitorID := v.ReadVisitorCookie(r); visitorID != "" {
+		return visitorID
+	}
+
+	visitorID := uuid.New().String()
+	http.SetCookie(w, &http.Cookie{
+		Name:     cfg.CookieName,
+		Value:    visitorID,
+		Path:     "/",
+		Domain:   cfg.CookieDomain,
+		MaxAge:   int(cfg.CookieMaxAge.Seconds()),
+		HttpOnly: true,
+		Secure:   r.TLS != nil || r.Header.Get("X-Forwarded-Proto") == "https",
+		SameSite: http.SameSiteLaxMode,
+	})
+	return visitorID
+}
+
+// ReadVisitorCookie returns the visitor ID of the request's visitor cookie
+// without setting one, e.g. for WebSocket connections; empty if it has none or
+// the request comes from a browser origin not in visitor.allowed_origins
+func (v *Validator) ReadVisitorCookie(r *http.Request) string {
+	if !v.credentialOrigin(r) {
+		return ""
+	}
+	cookie, err := r.Cookie(v.cfg.Visitor.CookieName)
+	if err != nil || !anonymousIDPattern.MatchString(cookie.Value) {
+		return ""
+	}
+	return cookie.Value
+}
+
+// AnonymousID returns the anonymous visitor ID of an event: the first valid
+// one of ids, given from the most to the least specific (e.g. the event's own
+// anonymous_id, the batch's, then the visitor cookie). It is empty when
+// visitor IDs are disabled, the event has no analytics consent or the HTTP
+// request r (nil for gRPC) carries Do Not Track.
+func (v *Validator) AnonymousID(r *http.Request, consent enricher.Consent, ids ...string) string {
+	if !v.cfg.Visitor.Enabled || !consent.Analytics || (r != nil && DoNotTrack(r)) {
+		return ""
+	}
+	for _, id := range ids {
+		if anonymousIDPattern.MatchString(id) {
+			return id
+		}
+	}
+	return ""
+}
+
+// credentialOrigin reports whether the request may carry the visitor cookie:
+// it has no Origin (not a cross-origin browser request) or one of
+// visitor.allowed_origins. WebSocket handshakes send cookies from any origin,
+// so the cookie of other origins is ignored rather than trusted.
+func (v *Validator) credentialOrigin(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	return origin == "" || slices.Contains(v.cfg.Visitor.AllowedOrigins, origin)
+}
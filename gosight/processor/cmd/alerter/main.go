@@ -0,0 +1,105 @@
+package main
+
+import (
+	"context"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/redis/go-redis/v9"
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+
+	"github.com/gosight/gosight/processor/internal/alert"
+	"github.com/gosight/gosight/processor/internal/config"
+	"github.com/gosight/gosight/processor/internal/consumer"
+)
+
+func main() {
+	// Setup logging
+	zerolog.TimeFieldFormat = zerolog.TimeFormatUnixMs
+	log.Logger = log.Output(zerolog.ConsoleWriter{Out: os.Stderr})
+
+	// Load config
+	configPath := os.Getenv("CONFIG_PATH")
+	if configPath == "" {
+		configPath = "config/processor.yaml"
+	}
+
+	cfg, err := config.Load(configPath)
+	if err != nil {
+		log.Fatal().Err(err).Str("path", configPath).Msg("Failed to load config")
+	}
+
+	if !cfg.Alerter.Enabled {
+		log.Info().Msg("Alerter disabled, exiting")
+		return
+	}
+
+	alertsTopic := cfg.Kafka.Topics["alerts"]
+	if alertsTopic == "" {
+		log.Fatal().Msg("No alerts topic configured (kafka.topics.alerts)")
+	}
+
+	log.Info().
+		Strs("kafka_brokers", cfg.Kafka.Brokers).
+		Str("topic", alertsTopic).
+		Int("sinks", len(cfg.Alerter.Sinks)).
+		Int("routes", len(cfg.Alerter.Routes)).
+		Dur("cooldown", cfg.Alerter.Cooldown).
+		Msg("Starting alerter")
+
+	// Initialize Redis for cooldowns shared across alerter instances
+	var rdb *redis.Client
+	if cfg.Redis.Addr != "" {
+		rdb = redis.NewClient(&redis.Options{
+			Addr:     cfg.Redis.Addr,
+			Password: cfg.Redis.Password,
+			DB:       cfg.Redis.DB,
+		})
+
+		// Test connection
+		if err := rdb.Ping(context.Background()).Err(); err != nil {
+			log.Warn().Err(err).Msg("Failed to connect to Redis, cooldowns will be per instance")
+			rdb = nil
+		} else {
+			defer rdb.Close()
+			log.Info().Msg("Connected to Redis")
+		}
+	}
+
+	alerter, err := alert.NewAlerter(cfg.Alerter, cfg.Kafka, rdb)
+	if err != nil {
+		log.Fatal().Err(err).Msg("Failed to create alerter")
+	}
+
+	// Consume the alerts topic in a separate consumer group; alerts that can't
+	// be decoded go to the same dead-letter topic as undeliverable ones
+	kafkaCfg := cfg.Kafka
+	kafkaCfg.ConsumerGroup = cfg.Alerter.ConsumerGroup
+	kafkaCfg.Topics = map[string]string{"events": alertsTopic}
+	kafkaCfg.DeadLetterTopic = cfg.Alerter.DLQTopic
+
+	kafkaConsumer, err := consumer.NewKafkaConsumer(kafkaCfg, alerter)
+	if err != nil {
+		log.Fatal().Err(err).Msg("Failed to create Kafka consumer")
+	}
+
+	// Start consuming
+	ctx, cancel := context.WithCancel(context.Background())
+	go kafkaConsumer.Start(ctx)
+
+	log.Info().Msg("Alerter started")
+
+	// Graceful shutdown
+	quit := make(chan os.Signal, 1)
+	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
+	<-quit
+
+	log.Info().Msg("Shutting down...")
+	cancel()
+	kafkaConsumer.Close()
+	alerter.Close()
+
+	log.Info().Msg("Shutdown complete")
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"context"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+
+	"github.com/gosight/gosight/processor/internal/archive"
+	"github.com/gosight/gosight/processor/internal/config"
+	"github.com/gosight/gosight/processor/internal/consumer"
+)
+
+func main() {
+	// Setup logging
+	zerolog.TimeFieldFormat = zerolog.TimeFormatUnixMs
+	log.Logger = log.Output(zerolog.ConsoleWriter{Out: os.Stderr})
+
+	// Load config
+	configPath := os.Getenv("CONFIG_PATH")
+	if configPath == "" {
+		configPath = "config/processor.yaml"
+	}
+
+	cfg, err := config.Load(configPath)
+	if err != nil {
+		log.Fatal().Err(err).Str("path", configPath).Msg("Failed to load config")
+	}
+
+	if !cfg.Archive.Enabled {
+		log.Info().Msg("Archive disabled, exiting")
+		return
+	}
+
+	log.Info().
+		Strs("kafka_brokers", cfg.Kafka.Brokers).
+		Str("store", cfg.Archive.Store).
+		Str("format", cfg.Archive.Format).
+		Str("prefix", cfg.Archive.Prefix).
+		Int("batch_size", cfg.Archive.BatchSize).
+		Dur("rotate_interval", cfg.Archive.RotateInterval).
+		Msg("Starting archiver")
+
+	store, err := archive.NewObjectStore(cfg.Archive)
+	if err != nil {
+		log.Fatal().Err(err).Msg("Failed to create archive store")
+	}
+
+	archiver, err := archive.NewArchiver(store, cfg.Archive)
+	if err != nil {
+		log.Fatal().Err(err).Msg("Failed to create archiver")
+	}
+
+	// Use a separate consumer group so the main pipeline's offsets are unaffected
+	cfg.Kafka.ConsumerGroup = cfg.Archive.ConsumerGroup
+
+	kafkaConsumer, err := consumer.NewKafkaConsumer(cfg.Kafka, archiver)
+	if err != nil {
+		log.Fatal().Err(err).Msg("Failed to create Kafka consumer")
+	}
+
+	// Start consuming
+	ctx, cancel := context.WithCancel(context.Background())
+	go kafkaConsumer.Start(ctx)
+
+	log.Info().Msg("Archiver started")
+
+	// Graceful shutdown
+	quit := make(chan os.Signal, 1)
+	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
+	<-quit
+
+	log.Info().Msg("Shutting down...")
+	cancel()
+	// Upload the last batch while its offsets can still be committed
+	archiver.Stop()
+	kafkaConsumer.Close()
+
+	log.Info().Msg("Shutdown complete")
+}
@@ -2,17 +2,23 @@ package main
 
 import (
 	"context"
+	"errors"
+	"net/http"
 	"os"
 	"os/signal"
 	"syscall"
 
+	"github.com/jackc/pgx/v5/pgxpool"
 	"github.com/rs/zerolog"
 	"github.com/rs/zerolog/log"
 
 	"github.com/gosight/gosight/processor/internal/config"
 	"github.com/gosight/gosight/processor/internal/consumer"
+	"github.com/gosight/gosight/processor/internal/live"
 	"github.com/gosight/gosight/processor/internal/processor"
+	"github.com/gosight/gosight/processor/internal/selector"
 	"github.com/gosight/gosight/processor/internal/session"
+	"github.com/gosight/gosight/processor/internal/settings"
 	"github.com/gosight/gosight/processor/internal/storage"
 )
 
@@ -46,18 +52,104 @@ func main() {
 		log.Fatal().Err(err).Msg("Failed to connect to ClickHouse")
 	}
 	defer ch.Close()
+	ch.SetCompressPayload(cfg.Storage.CompressPayload)
+	ch.SetTimestampSource(cfg.Storage.TimestampSource)
 	log.Info().Msg("Connected to ClickHouse")
 
+	// Route event categories to their own tables
+	if len(cfg.Storage.EventCategories) > 0 {
+		ch.SetEventCategories(cfg.Storage.EventCategories)
+		// Shadow mode creates its own copies
+		if !cfg.Shadow.Enabled {
+			if err := ch.CreateEventTables(context.Background()); err != nil {
+				log.Fatal().Err(err).Msg("Failed to create event category tables")
+			}
+		}
+		log.Info().Interface("event_categories", cfg.Storage.EventCategories).Msg("Storing event categories in their own tables")
+	}
+
+	// Keep a shadow deployment's writes out of the production tables
+	if cfg.Shadow.Enabled {
+		ch.SetShadow(cfg.Shadow.TablePrefix, cfg.Shadow.Discard)
+		if err := ch.CreateShadowTables(context.Background()); err != nil {
+			log.Fatal().Err(err).Msg("Failed to create shadow tables")
+		}
+		log.Warn().
+			Str("table_prefix", cfg.Shadow.TablePrefix).
+			Bool("discard", cfg.Shadow.Discard).
+			Str("consumer_group", cfg.Kafka.ConsumerGroup).
+			Msg("Running in shadow mode")
+	}
+
 	// Initialize session aggregator
 	var sessionAgg *session.Aggregator
+	var sessionUpdates *session.UpdatePool
 	if cfg.Redis.Addr != "" {
-		sessionAgg = session.NewAggregator(ch, cfg.Redis)
+		if cfg.SessionCheckpoint.Enabled {
+			sessionAgg = session.NewAggregatorWithCheckpoint(ch, cfg.Redis, cfg.Kafka, cfg.SessionCheckpoint)
+
+			// Rebuild in-flight sessions before consuming new events
+			if err := sessionAgg.RestoreFromCheckpoint(context.Background()); err != nil {
+				log.Error().Err(err).Msg("Failed to restore sessions from checkpoint")
+			}
+		} else {
+			sessionAgg = session.NewAggregator(ch, cfg.Redis)
+		}
+		if cfg.SessionCDC.Enabled {
+			sessionAgg.EnableCDC(cfg.Kafka)
+		}
+		if cfg.SessionScroll.Enabled {
+			sessionAgg.EnableScrollDepth()
+		}
 		defer sessionAgg.Close()
-		log.Info().Msg("Session aggregator initialized")
+		sessionUpdates = session.NewUpdatePool(sessionAgg, cfg.SessionUpdates)
+		log.Info().
+			Bool("checkpoint", cfg.SessionCheckpoint.Enabled).
+			Bool("cdc", cfg.SessionCDC.Enabled).
+			Bool("scroll_depth", cfg.SessionScroll.Enabled).
+			Int("update_workers", cfg.SessionUpdates.Workers).
+			Msg("Session aggregator initialized")
+	}
+
+	// Create selector normalizer
+	normalizer, err := selector.NewNormalizer(cfg.Selector)
+	if err != nil {
+		log.Fatal().Err(err).Msg("Invalid selector normalization pattern")
 	}
 
 	// Create event processor
-	eventProcessor := processor.NewEventProcessor(ch, sessionAgg, cfg.Batch)
+	eventProcessor := processor.NewEventProcessor(ch, sessionUpdates, cfg.Batch, cfg.Storage, normalizer, cfg.ErrorSampling, cfg.AggregateMetrics, cfg.Consent, cfg.Enrichment)
+
+	// Load per-project path exclusions
+	var projectSettings *settings.Loader
+	if cfg.ProjectSettings.Enabled {
+		db, err := pgxpool.New(context.Background(), cfg.Postgres.DSN)
+		if err != nil {
+			log.Fatal().Err(err).Msg("Invalid Postgres DSN")
+		}
+		defer db.Close()
+		projectSettings = settings.NewLoader(db, cfg.ProjectSettings)
+		eventProcessor.SetProjectSettings(projectSettings)
+		log.Info().Dur("refresh_interval", cfg.ProjectSettings.RefreshInterval).Msg("Project settings enabled")
+	}
+
+	// Live top pages and errors for dashboards
+	if cfg.LiveTop.Enabled {
+		liveTop := live.NewTopCounters(cfg.Redis, cfg.LiveTop)
+		defer liveTop.Close()
+		eventProcessor.SetLiveTop(liveTop)
+		log.Info().
+			Strs("dimensions", cfg.LiveTop.Dimensions).
+			Dur("bucket", cfg.LiveTop.Bucket).
+			Dur("retention", cfg.LiveTop.Retention).
+			Msg("Live top counts enabled")
+	}
+
+	// Per-project retention
+	var retention *processor.Retention
+	if cfg.Retention.Enabled {
+		retention = processor.NewRetention(ch, projectSettings, cfg.Retention)
+	}
 
 	// Create Kafka consumer
 	kafkaConsumer, err := consumer.NewKafkaConsumer(cfg.Kafka, eventProcessor)
@@ -69,6 +161,43 @@ func main() {
 	ctx, cancel := context.WithCancel(context.Background())
 	go kafkaConsumer.Start(ctx)
 
+	// Serve flush metrics
+	var metricsServer *http.Server
+	if cfg.Metrics.Addr != "" {
+		mux := http.NewServeMux()
+		mux.HandleFunc("/metrics", func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+			eventProcessor.WriteMetrics(w)
+			if retention != nil {
+				retention.WriteMetrics(w)
+			}
+		})
+		metricsServer = &http.Server{Addr: cfg.Metrics.Addr, Handler: mux}
+		go func() {
+			if err := metricsServer.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+				log.Error().Err(err).Str("addr", cfg.Metrics.Addr).Msg("Metrics server failed")
+			}
+		}()
+		log.Info().Str("addr", cfg.Metrics.Addr).Msg("Serving metrics")
+	}
+
+	// Start web vitals rollup
+	if cfg.Rollup.Enabled {
+		rollup := processor.NewWebVitalsRollup(ch, cfg.Rollup)
+		go rollup.Start(ctx)
+		log.Info().Dur("interval", cfg.Rollup.Interval).Msg("Web vitals rollup started")
+	}
+
+	// Start retention enforcement
+	if retention != nil {
+		go retention.Start(ctx)
+		log.Info().
+			Dur("interval", cfg.Retention.Interval).
+			Dur("mutation_interval", cfg.Retention.MutationInterval).
+			Bool("dry_run", cfg.Retention.DryRun).
+			Msg("Retention enforcement started")
+	}
+
 	log.Info().Msg("Event processor started")
 
 	// Graceful shutdown
@@ -80,10 +209,17 @@ func main() {
 	cancel()
 	kafkaConsumer.Close()
 	eventProcessor.Stop()
+	if metricsServer != nil {
+		metricsServer.Close()
+	}
+	if projectSettings != nil {
+		projectSettings.Close()
+	}
 
-	// Flush remaining sessions
+	// Flush remaining sessions, with their queued updates applied
 	if sessionAgg != nil {
-		if err := sessionAgg.FlushAllSessions(context.Background()); err != nil {
+		sessionUpdates.Close()
+		if err := sessionAgg.FlushAllSessions(context.Background(), cfg.SessionFlush.Workers); err != nil {
 			log.Error().Err(err).Msg("Failed to flush sessions")
 		}
 	}
@@ -0,0 +1,162 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+
+	"github.com/gosight/gosight/processor/internal/config"
+	"github.com/gosight/gosight/processor/internal/insights"
+	"github.com/gosight/gosight/processor/internal/selector"
+	"github.com/gosight/gosight/processor/internal/storage"
+)
+
+// insight-backfill replays a project's stored events through the insight
+// detectors and stores the resulting insights, e.g. to run a new detector over
+// past traffic. Events are read per session in timestamp order, as stateful
+// detectors require. Insights are timestamped at the event that triggered them.
+//
+// Detectors whose windows are measured in processing time rather than event
+// time (dead_click, slow_page, missing_vitals, funnel_abandonment, funnel_drop)
+// can't be replayed and are always disabled.
+func main() {
+	zerolog.TimeFieldFormat = zerolog.TimeFormatUnixMs
+	log.Logger = log.Output(zerolog.ConsoleWriter{Out: os.Stderr})
+
+	projectID := flag.String("project", "", "project ID to backfill (required)")
+	since := flag.Duration("since", 7*24*time.Hour, "backfill events newer than this")
+	until := flag.Duration("until", 0, "backfill events older than this")
+	detectors := flag.String("detectors", "", "comma-separated detectors to run (default: those enabled in config)")
+	alerts := flag.Bool("alerts", false, "publish alerts for backfilled insights")
+	flag.Parse()
+
+	if *projectID == "" {
+		log.Fatal().Msg("-project is required")
+	}
+
+	configPath := os.Getenv("CONFIG_PATH")
+	if configPath == "" {
+		configPath = "config/processor.yaml"
+	}
+
+	cfg, err := config.Load(configPath)
+	if err != nil {
+		log.Fatal().Err(err).Str("path", configPath).Msg("Failed to load config")
+	}
+
+	enabled := map[string]*bool{
+		"rage_click":      &cfg.Insights.RageClick.Enabled,
+		"error_click":     &cfg.Insights.ErrorClick.Enabled,
+		"thrashed_cursor": &cfg.Insights.ThrashedCursor.Enabled,
+		"rage_scroll":     &cfg.Insights.RageScroll.Enabled,
+		"u_turn":          &cfg.Insights.UTurn.Enabled,
+		"form_retry":      &cfg.Insights.FormRetry.Enabled,
+		"reload_loop":     &cfg.Insights.ReloadLoop.Enabled,
+		"error_page":      &cfg.Insights.ErrorPage.Enabled,
+		"long_task":       &cfg.Insights.LongTask.Enabled,
+		"pogostick":       &cfg.Insights.Pogostick.Enabled,
+	}
+	if *detectors != "" {
+		for _, on := range enabled {
+			*on = false
+		}
+		for _, name := range strings.Split(*detectors, ",") {
+			on, ok := enabled[strings.TrimSpace(name)]
+			if !ok {
+				log.Fatal().Str("detector", name).Msg("Unknown or non-replayable detector")
+			}
+			*on = true
+		}
+	}
+	cfg.Insights.DeadClick.Enabled = false
+	cfg.Insights.SlowPage.Enabled = false
+	cfg.Insights.MissingVitals.Enabled = false
+	cfg.Insights.Funnel.Enabled = false
+	cfg.Insights.FunnelDrop.Enabled = false
+	// Historical sessions are already past replay sampling
+	cfg.Insights.ReplayKeep.Enabled = false
+	// Nor may it restore or overwrite the live processor's detector state
+	cfg.Insights.Checkpoint.Enabled = false
+
+	var names []string
+	for name, on := range enabled {
+		if *on {
+			names = append(names, name)
+		}
+	}
+	if len(names) == 0 {
+		log.Fatal().Msg("No detectors enabled")
+	}
+
+	ch, err := storage.NewClickHouse(cfg.ClickHouse)
+	if err != nil {
+		log.Fatal().Err(err).Msg("Failed to connect to ClickHouse")
+	}
+	defer ch.Close()
+	ch.SetEventCategories(cfg.Storage.EventCategories)
+
+	// Rage click detection keeps its click windows in Redis
+	var rdb *redis.Client
+	if cfg.Redis.Addr != "" {
+		rdb = redis.NewClient(&redis.Options{
+			Addr:     cfg.Redis.Addr,
+			Password: cfg.Redis.Password,
+			DB:       cfg.Redis.DB,
+		})
+		if err := rdb.Ping(context.Background()).Err(); err != nil {
+			log.Warn().Err(err).Msg("Failed to connect to Redis, rage click detection disabled")
+			rdb = nil
+			cfg.Insights.RageClick.Enabled = false
+		} else {
+			defer rdb.Close()
+		}
+	}
+
+	normalizer, err := selector.NewNormalizer(cfg.Selector)
+	if err != nil {
+		log.Fatal().Err(err).Msg("Invalid selector normalization pattern")
+	}
+
+	// Without Kafka config the processor publishes no alerts
+	kafkaCfg := config.KafkaConfig{}
+	if *alerts {
+		kafkaCfg = cfg.Kafka
+	}
+	processor := insights.NewProcessorWithKafka(ch, rdb, cfg.Insights, kafkaCfg, normalizer)
+	processor.SetBackfill(true)
+
+	from := time.Now().Add(-*since)
+	to := time.Now().Add(-*until)
+
+	log.Info().
+		Str("project_id", *projectID).
+		Time("from", from).
+		Time("to", to).
+		Strs("detectors", names).
+		Bool("alerts", *alerts).
+		Msg("Starting insight backfill")
+
+	ctx := context.Background()
+	count := 0
+	// Detectors skip synthetic events anyway
+	err = ch.StreamEvents(ctx, *projectID, from, to, false, func(row storage.EventRow) error {
+		count++
+		if count%100000 == 0 {
+			log.Info().Int("events", count).Msg("Backfill progress")
+		}
+		return processor.ProcessRow(ctx, row)
+	})
+	if err != nil {
+		log.Fatal().Err(err).Int("events", count).Msg("Failed to read events")
+	}
+
+	processor.Stop()
+
+	log.Info().Int("events", count).Msg("Insight backfill complete")
+}
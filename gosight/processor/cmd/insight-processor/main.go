@@ -6,6 +6,7 @@ import (
 	"os/signal"
 	"syscall"
 
+	"github.com/jackc/pgx/v5/pgxpool"
 	"github.com/redis/go-redis/v9"
 	"github.com/rs/zerolog"
 	"github.com/rs/zerolog/log"
@@ -13,6 +14,8 @@ import (
 	"github.com/gosight/gosight/processor/internal/config"
 	"github.com/gosight/gosight/processor/internal/consumer"
 	"github.com/gosight/gosight/processor/internal/insights"
+	"github.com/gosight/gosight/processor/internal/selector"
+	"github.com/gosight/gosight/processor/internal/settings"
 	"github.com/gosight/gosight/processor/internal/storage"
 )
 
@@ -44,8 +47,21 @@ func main() {
 		log.Fatal().Err(err).Msg("Failed to connect to ClickHouse")
 	}
 	defer ch.Close()
+	ch.SetFrustrationWeights(cfg.FrustrationScore.Weights)
 	log.Info().Msg("Connected to ClickHouse")
 
+	// Keep a shadow deployment's writes out of the production tables
+	if cfg.Shadow.Enabled {
+		ch.SetShadow(cfg.Shadow.TablePrefix, cfg.Shadow.Discard)
+		if err := ch.CreateShadowTables(context.Background()); err != nil {
+			log.Fatal().Err(err).Msg("Failed to create shadow tables")
+		}
+		log.Warn().
+			Str("table_prefix", cfg.Shadow.TablePrefix).
+			Bool("discard", cfg.Shadow.Discard).
+			Msg("Running in shadow mode")
+	}
+
 	// Initialize Redis
 	var rdb *redis.Client
 	if cfg.Redis.Addr != "" {
@@ -68,21 +84,58 @@ func main() {
 	// Enable all detectors by default if not configured
 	if !cfg.Insights.RageClick.Enabled && !cfg.Insights.DeadClick.Enabled &&
 		!cfg.Insights.ErrorClick.Enabled && !cfg.Insights.ThrashedCursor.Enabled &&
-		!cfg.Insights.UTurn.Enabled && !cfg.Insights.SlowPage.Enabled {
+		!cfg.Insights.RageScroll.Enabled &&
+		!cfg.Insights.UTurn.Enabled && !cfg.Insights.SlowPage.Enabled &&
+		!cfg.Insights.SlowAbandon.Enabled &&
+		!cfg.Insights.FormRetry.Enabled && !cfg.Insights.ReloadLoop.Enabled &&
+		!cfg.Insights.ErrorPage.Enabled && !cfg.Insights.LongTask.Enabled &&
+		!cfg.Insights.MediaAbandon.Enabled &&
+		!cfg.Insights.MissingVitals.Enabled && !cfg.Insights.Pogostick.Enabled {
 		log.Info().Msg("No insight detectors enabled in config, enabling all by default")
 		cfg.Insights.RageClick.Enabled = true
 		cfg.Insights.DeadClick.Enabled = true
 		cfg.Insights.ErrorClick.Enabled = true
 		cfg.Insights.ThrashedCursor.Enabled = true
+		cfg.Insights.RageScroll.Enabled = true
 		cfg.Insights.UTurn.Enabled = true
 		cfg.Insights.SlowPage.Enabled = true
+		cfg.Insights.SlowAbandon.Enabled = true
+		cfg.Insights.FormRetry.Enabled = true
+		cfg.Insights.ReloadLoop.Enabled = true
+		cfg.Insights.ErrorPage.Enabled = true
+		cfg.Insights.LongTask.Enabled = true
+		cfg.Insights.MediaAbandon.Enabled = true
+		cfg.Insights.MissingVitals.Enabled = true
+		cfg.Insights.Pogostick.Enabled = true
+	}
+
+	// Create selector normalizer
+	normalizer, err := selector.NewNormalizer(cfg.Selector)
+	if err != nil {
+		log.Fatal().Err(err).Msg("Invalid selector normalization pattern")
 	}
 
 	// Create insight processor with Kafka alert publishing
-	insightProcessor := insights.NewProcessorWithKafka(ch, rdb, cfg.Insights, cfg.Kafka)
+	insightProcessor := insights.NewProcessorWithKafka(ch, rdb, cfg.Insights, cfg.Kafka, normalizer)
+
+	// Load per-project insight toggles
+	var projectSettings *settings.Loader
+	if cfg.ProjectSettings.Enabled {
+		db, err := pgxpool.New(context.Background(), cfg.Postgres.DSN)
+		if err != nil {
+			log.Fatal().Err(err).Msg("Invalid Postgres DSN")
+		}
+		defer db.Close()
+		projectSettings = settings.NewLoader(db, cfg.ProjectSettings)
+		insightProcessor.SetProjectSettings(projectSettings)
+		log.Info().Dur("refresh_interval", cfg.ProjectSettings.RefreshInterval).Msg("Project settings enabled")
+	}
 
 	// Override consumer group for insight processor
 	cfg.Kafka.ConsumerGroup = "gosight-insight-processor"
+	if cfg.Shadow.Enabled {
+		cfg.Kafka.ConsumerGroup = cfg.Shadow.ConsumerGroup + "-insights"
+	}
 
 	// Create Kafka consumer
 	kafkaConsumer, err := consumer.NewKafkaConsumer(cfg.Kafka, insightProcessor)
@@ -99,10 +152,36 @@ func main() {
 		Bool("dead_click", cfg.Insights.DeadClick.Enabled).
 		Bool("error_click", cfg.Insights.ErrorClick.Enabled).
 		Bool("thrashed_cursor", cfg.Insights.ThrashedCursor.Enabled).
+		Bool("rage_scroll", cfg.Insights.RageScroll.Enabled).
 		Bool("u_turn", cfg.Insights.UTurn.Enabled).
 		Bool("slow_page", cfg.Insights.SlowPage.Enabled).
+		Bool("slow_page_abandonment", cfg.Insights.SlowAbandon.Enabled).
+		Bool("form_retry", cfg.Insights.FormRetry.Enabled).
+		Bool("reload_loop", cfg.Insights.ReloadLoop.Enabled).
+		Bool("error_page", cfg.Insights.ErrorPage.Enabled).
+		Bool("long_task", cfg.Insights.LongTask.Enabled).
+		Bool("media_abandonment", cfg.Insights.MediaAbandon.Enabled).
+		Bool("missing_vitals", cfg.Insights.MissingVitals.Enabled).
+		Bool("pogostick", cfg.Insights.Pogostick.Enabled).
+		Bool("funnel_abandonment", cfg.Insights.Funnel.Enabled).
+		Bool("funnel_drop", cfg.Insights.FunnelDrop.Enabled).
 		Msg("Insight processor started")
 
+	// Reload detector thresholds from the config file on SIGHUP
+	reload := make(chan os.Signal, 1)
+	signal.Notify(reload, syscall.SIGHUP)
+	go func() {
+		for range reload {
+			newCfg, err := config.Load(configPath)
+			if err != nil {
+				log.Error().Err(err).Str("path", configPath).Msg("Failed to reload config, keeping current thresholds")
+				continue
+			}
+			insightProcessor.SetThresholds(newCfg.Insights)
+			log.Info().Str("path", configPath).Msg("Reloaded insight detector thresholds")
+		}
+	}()
+
 	// Graceful shutdown
 	quit := make(chan os.Signal, 1)
 	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
@@ -112,6 +191,9 @@ func main() {
 	cancel()
 	kafkaConsumer.Close()
 	insightProcessor.Stop()
+	if projectSettings != nil {
+		projectSettings.Close()
+	}
 
 	log.Info().Msg("Shutdown complete")
 }
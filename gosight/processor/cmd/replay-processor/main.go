@@ -0,0 +1,90 @@
+package main
+
+import (
+	"context"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+
+	"github.com/gosight/gosight/processor/internal/config"
+	"github.com/gosight/gosight/processor/internal/consumer"
+	"github.com/gosight/gosight/processor/internal/replay"
+	"github.com/gosight/gosight/processor/internal/storage"
+)
+
+func main() {
+	// Setup logging
+	zerolog.TimeFieldFormat = zerolog.TimeFormatUnixMs
+	log.Logger = log.Output(zerolog.ConsoleWriter{Out: os.Stderr})
+
+	// Load config
+	configPath := os.Getenv("CONFIG_PATH")
+	if configPath == "" {
+		configPath = "config/processor.yaml"
+	}
+
+	cfg, err := config.Load(configPath)
+	if err != nil {
+		log.Fatal().Err(err).Str("path", configPath).Msg("Failed to load config")
+	}
+
+	if !cfg.Replay.Enabled {
+		log.Info().Msg("Replay processor disabled, exiting")
+		return
+	}
+
+	replayTopic := cfg.Kafka.Topics["replay"]
+	if replayTopic == "" {
+		log.Fatal().Msg("No replay topic configured (kafka.topics.replay)")
+	}
+
+	log.Info().
+		Strs("kafka_brokers", cfg.Kafka.Brokers).
+		Str("topic", replayTopic).
+		Str("clickhouse_addr", cfg.ClickHouse.Addr).
+		Int("batch_size", cfg.Replay.BatchSize).
+		Dur("session_timeout", cfg.Replay.SessionTimeout).
+		Msg("Starting replay processor")
+
+	// Initialize ClickHouse
+	ch, err := storage.NewClickHouse(cfg.ClickHouse)
+	if err != nil {
+		log.Fatal().Err(err).Msg("Failed to connect to ClickHouse")
+	}
+	defer ch.Close()
+	log.Info().Msg("Connected to ClickHouse")
+
+	replayProcessor := replay.NewProcessor(ch, cfg.Replay)
+
+	// Consume the replay topic in a separate consumer group; chunks are keyed
+	// by session, so a session's chunks keep their order across workers
+	kafkaCfg := cfg.Kafka
+	kafkaCfg.ConsumerGroup = cfg.Replay.ConsumerGroup
+	kafkaCfg.Topics = map[string]string{"events": replayTopic}
+
+	kafkaConsumer, err := consumer.NewKafkaConsumer(kafkaCfg, replayProcessor)
+	if err != nil {
+		log.Fatal().Err(err).Msg("Failed to create Kafka consumer")
+	}
+
+	// Start consuming
+	ctx, cancel := context.WithCancel(context.Background())
+	go kafkaConsumer.Start(ctx)
+
+	log.Info().Msg("Replay processor started")
+
+	// Graceful shutdown
+	quit := make(chan os.Signal, 1)
+	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
+	<-quit
+
+	log.Info().Msg("Shutting down...")
+	cancel()
+	kafkaConsumer.Close()
+	replayProcessor.Stop()
+
+	log.Info().Msg("Shutdown complete")
+}
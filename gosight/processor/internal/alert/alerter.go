@@ -0,0 +1,294 @@
+package alert
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	"github.com/rs/zerolog/log"
+	"github.com/segmentio/kafka-go"
+
+	"github.com/gosight/gosight/processor/internal/config"
+	"github.com/gosight/gosight/processor/internal/kafkaauth"
+)
+
+// Alert is an insight alert as published by the insights processor
+type Alert struct {
+	InsightID          string                 `json:"insight_id"`
+	Type               string                 `json:"type"`
+	ProjectID          string                 `json:"project_id"`
+	SessionID          string                 `json:"session_id"`
+	Timestamp          time.Time              `json:"timestamp"`
+	URL                string                 `json:"url"`
+	Path               string                 `json:"path"`
+	Details            map[string]interface{} `json:"details"`
+	Confidence         *float64               `json:"confidence,omitempty"` // nil for alerts published before confidence
+	PublishedAt        int64                  `json:"published_at"`
+	X                  *int                   `json:"x,omitempty"`
+	Y                  *int                   `json:"y,omitempty"`
+	TargetSelector     string                 `json:"target_selector,omitempty"`
+	NormalizedSelector string                 `json:"normalized_selector,omitempty"`
+}
+
+// Alerter routes alerts to sinks. It implements consumer.MessageProcessor.
+// Delivery to each sink is retried with backoff; alerts that still fail are
+// written to the dead-letter topic with the failing sink in the headers.
+type Alerter struct {
+	sinks        map[string]Sink
+	routes       []config.AlertRouteConfig
+	cooldown     time.Duration
+	maxAttempts  int
+	retryBackoff time.Duration
+
+	redis *redis.Client // cooldown state; nil keeps it in memory
+	local *localCooldown
+	dlq   *kafka.Writer // nil when no dead-letter topic is configured
+}
+
+// NewAlerter creates an alerter. rdb may be nil, in which case cooldowns are
+// only tracked per process.
+func NewAlerter(cfg config.AlerterConfig, kafkaCfg config.KafkaConfig, rdb *redis.Client) (*Alerter, error) {
+	sinks := make(map[string]Sink, len(cfg.Sinks))
+	for name, sinkCfg := range cfg.Sinks {
+		sink, err := NewSink(sinkCfg)
+		if err != nil {
+			return nil, fmt.Errorf("sink %q: %w", name, err)
+		}
+		sinks[name] = sink
+	}
+
+	a := &Alerter{
+		sinks:        sinks,
+		routes:       cfg.Routes,
+		cooldown:     cfg.Cooldown,
+		maxAttempts:  cfg.MaxAttempts,
+		retryBackoff: cfg.RetryBackoff,
+		redis:        rdb,
+		local:        newLocalCooldown(),
+	}
+
+	if cfg.DLQTopic != "" && len(kafkaCfg.Brokers) > 0 {
+		a.dlq = &kafka.Writer{
+			Addr:                   kafka.TCP(kafkaCfg.Brokers...),
+			Transport:              kafkaauth.Transport(kafkaCfg),
+			Topic:                  cfg.DLQTopic,
+			Balancer:               &kafka.LeastBytes{},
+			BatchTimeout:           time.Millisecond * 10,
+			AllowAutoTopicCreation: true,
+		}
+	}
+
+	return a, nil
+}
+
+// Process delivers one alert. Only undecodable alerts return an error; failed
+// deliveries go to the dead-letter topic so one bad sink doesn't resend the
+// alert to the others.
+func (a *Alerter) Process(ctx context.Context, event map[string]interface{}) error {
+	data, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	var alert Alert
+	if err := json.Unmarshal(data, &alert); err != nil {
+		return fmt.Errorf("decode alert: %w", err)
+	}
+
+	for name, cooldown := range a.route(&alert) {
+		sink, ok := a.sinks[name]
+		if !ok {
+			continue
+		}
+
+		key := fmt.Sprintf("alert:cooldown:%s:%s:%s:%s", name, alert.ProjectID, alert.Type, alert.Path)
+		if !a.acquire(ctx, key, cooldown) {
+			log.Debug().
+				Str("sink", name).
+				Str("project_id", alert.ProjectID).
+				Str("type", alert.Type).
+				Msg("Alert suppressed by cooldown")
+			continue
+		}
+
+		if err := a.deliver(ctx, sink, &alert); err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			log.Error().
+				Err(err).
+				Str("sink", name).
+				Str("insight_id", alert.InsightID).
+				Msg("Failed to deliver alert, giving up")
+			// Don't hold the cooldown for an alert nobody received
+			a.release(ctx, key)
+			a.deadLetter(ctx, name, data, err)
+			continue
+		}
+
+		log.Info().
+			Str("sink", name).
+			Str("project_id", alert.ProjectID).
+			Str("type", alert.Type).
+			Msg("Alert delivered")
+	}
+
+	return nil
+}
+
+// Flush is a no-op: alerts are delivered as they are processed
+func (a *Alerter) Flush() {}
+
+// Close closes the dead-letter writer
+func (a *Alerter) Close() error {
+	if a.dlq != nil {
+		return a.dlq.Close()
+	}
+	return nil
+}
+
+// route returns the sinks the alert goes to with the cooldown for each. A sink
+// in several matching routes uses the cooldown of the first one.
+func (a *Alerter) route(alert *Alert) map[string]time.Duration {
+	sinks := make(map[string]time.Duration)
+	for _, route := range a.routes {
+		if route.ProjectID != "" && route.ProjectID != "*" && route.ProjectID != alert.ProjectID {
+			continue
+		}
+		if len(route.InsightTypes) > 0 && !contains(route.InsightTypes, alert.Type) {
+			continue
+		}
+		if alert.Confidence != nil && *alert.Confidence < route.MinConfidence {
+			continue
+		}
+
+		cooldown := route.Cooldown
+		if cooldown == 0 {
+			cooldown = a.cooldown
+		}
+		for _, name := range route.Sinks {
+			if _, ok := sinks[name]; !ok {
+				sinks[name] = cooldown
+			}
+		}
+	}
+	return sinks
+}
+
+// deliver sends the alert, retrying with exponential backoff
+func (a *Alerter) deliver(ctx context.Context, sink Sink, alert *Alert) error {
+	backoff := a.retryBackoff
+	var err error
+	for attempt := 1; attempt <= a.maxAttempts; attempt++ {
+		if err = sink.Send(ctx, alert); err == nil {
+			return nil
+		}
+		if attempt == a.maxAttempts {
+			break
+		}
+
+		log.Warn().
+			Err(err).
+			Int("attempt", attempt).
+			Str("insight_id", alert.InsightID).
+			Msg("Failed to deliver alert, retrying")
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+	}
+	return err
+}
+
+// acquire starts the cooldown for key, returning false if it is already running.
+// Redis errors fail open so alerts aren't lost when Redis is unavailable.
+func (a *Alerter) acquire(ctx context.Context, key string, cooldown time.Duration) bool {
+	if a.redis == nil {
+		return a.local.acquire(key, cooldown)
+	}
+
+	ok, err := a.redis.SetNX(ctx, key, 1, cooldown).Result()
+	if err != nil {
+		log.Warn().Err(err).Str("key", key).Msg("Failed to check alert cooldown")
+		return true
+	}
+	return ok
+}
+
+func (a *Alerter) release(ctx context.Context, key string) {
+	if a.redis == nil {
+		a.local.release(key)
+		return
+	}
+	if err := a.redis.Del(ctx, key).Err(); err != nil {
+		log.Warn().Err(err).Str("key", key).Msg("Failed to clear alert cooldown")
+	}
+}
+
+func (a *Alerter) deadLetter(ctx context.Context, sink string, data []byte, cause error) {
+	if a.dlq == nil {
+		return
+	}
+
+	err := a.dlq.WriteMessages(ctx, kafka.Message{
+		Value: data,
+		Headers: []kafka.Header{
+			{Key: "x-sink", Value: []byte(sink)},
+			{Key: "x-error", Value: []byte(cause.Error())},
+		},
+	})
+	if err != nil {
+		log.Error().Err(err).Str("sink", sink).Msg("Failed to write alert to dead-letter topic")
+	}
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// localCooldown tracks cooldowns in memory when Redis isn't available
+type localCooldown struct {
+	mu      sync.Mutex
+	expires map[string]time.Time
+}
+
+func newLocalCooldown() *localCooldown {
+	return &localCooldown{expires: make(map[string]time.Time)}
+}
+
+func (c *localCooldown) acquire(key string, cooldown time.Duration) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := time.Now()
+	if exp, ok := c.expires[key]; ok && now.Before(exp) {
+		return false
+	}
+	c.expires[key] = now.Add(cooldown)
+
+	// Drop expired keys so the map doesn't grow with every page ever alerted on
+	if len(c.expires) > 10000 {
+		for k, exp := range c.expires {
+			if now.After(exp) {
+				delete(c.expires, k)
+			}
+		}
+	}
+	return true
+}
+
+func (c *localCooldown) release(key string) {
+	c.mu.Lock()
+	delete(c.expires, key)
+	c.mu.Unlock()
+}
@@ -0,0 +1,172 @@
+package alert
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/smtp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gosight/gosight/processor/internal/config"
+)
+
+// Sink types
+const (
+	SinkSlack   = "slack"
+	SinkEmail   = "email"
+	SinkWebhook = "webhook"
+)
+
+// Sink delivers an alert to one notification channel
+type Sink interface {
+	Send(ctx context.Context, alert *Alert) error
+}
+
+var httpClient = &http.Client{Timeout: 10 * time.Second}
+
+// NewSink creates a sink from its config
+func NewSink(cfg config.AlertSinkConfig) (Sink, error) {
+	switch cfg.Type {
+	case SinkSlack:
+		if cfg.URL == "" {
+			return nil, fmt.Errorf("slack sink requires url")
+		}
+		return &SlackSink{url: cfg.URL}, nil
+	case SinkWebhook:
+		if cfg.URL == "" {
+			return nil, fmt.Errorf("webhook sink requires url")
+		}
+		return &WebhookSink{url: cfg.URL, headers: cfg.Headers}, nil
+	case SinkEmail:
+		if cfg.SMTP.Host == "" || cfg.SMTP.From == "" || len(cfg.To) == 0 {
+			return nil, fmt.Errorf("email sink requires smtp.host, smtp.from and to")
+		}
+		return &EmailSink{smtp: cfg.SMTP, to: cfg.To}, nil
+	default:
+		return nil, fmt.Errorf("unknown sink type %q (want %s, %s or %s)", cfg.Type, SinkSlack, SinkEmail, SinkWebhook)
+	}
+}
+
+// SlackSink posts a text message to a Slack incoming webhook
+type SlackSink struct {
+	url string
+}
+
+func (s *SlackSink) Send(ctx context.Context, alert *Alert) error {
+	body, err := json.Marshal(map[string]string{"text": summary(alert) + "\n" + detailLines(alert)})
+	if err != nil {
+		return err
+	}
+	return postJSON(ctx, s.url, body, nil)
+}
+
+// WebhookSink posts the alert JSON as-is
+type WebhookSink struct {
+	url     string
+	headers map[string]string
+}
+
+func (s *WebhookSink) Send(ctx context.Context, alert *Alert) error {
+	body, err := json.Marshal(alert)
+	if err != nil {
+		return err
+	}
+	return postJSON(ctx, s.url, body, s.headers)
+}
+
+// EmailSink sends a plain text email over SMTP, with PLAIN auth when a
+// username is set
+type EmailSink struct {
+	smtp config.SMTPConfig
+	to   []string
+}
+
+func (s *EmailSink) Send(ctx context.Context, alert *Alert) error {
+	port := s.smtp.Port
+	if port == 0 {
+		port = 587
+	}
+	addr := net.JoinHostPort(s.smtp.Host, strconv.Itoa(port))
+
+	var auth smtp.Auth
+	if s.smtp.Username != "" {
+		auth = smtp.PlainAuth("", s.smtp.Username, s.smtp.Password, s.smtp.Host)
+	}
+
+	var msg strings.Builder
+	fmt.Fprintf(&msg, "From: %s\r\n", s.smtp.From)
+	fmt.Fprintf(&msg, "To: %s\r\n", strings.Join(s.to, ", "))
+	fmt.Fprintf(&msg, "Subject: %s\r\n", summary(alert))
+	msg.WriteString("Content-Type: text/plain; charset=utf-8\r\n\r\n")
+	msg.WriteString(strings.ReplaceAll(detailLines(alert), "\n", "\r\n"))
+
+	// net/smtp has no context support; bound the send by the context instead
+	errc := make(chan error, 1)
+	go func() {
+		errc <- smtp.SendMail(addr, auth, s.smtp.From, s.to, []byte(msg.String()))
+	}()
+	select {
+	case err := <-errc:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func postJSON(ctx context.Context, url string, body []byte, headers map[string]string) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for k, v := range headers {
+		req.Header.Set(k, v)
+	}
+
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("%s returned %d: %s", url, resp.StatusCode, strings.TrimSpace(string(msg)))
+	}
+	return nil
+}
+
+// summary is a one-line description of the alert
+func summary(alert *Alert) string {
+	return fmt.Sprintf("[GoSight] %s on %s (project %s)", alert.Type, alert.Path, alert.ProjectID)
+}
+
+// detailLines lists the alert's context and details, one per line
+func detailLines(alert *Alert) string {
+	lines := []string{
+		"URL: " + alert.URL,
+		"Session: " + alert.SessionID,
+		"Time: " + alert.Timestamp.UTC().Format(time.RFC3339),
+	}
+	if alert.TargetSelector != "" {
+		lines = append(lines, "Target: "+alert.TargetSelector)
+	}
+
+	keys := make([]string, 0, len(alert.Details))
+	for k := range alert.Details {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		lines = append(lines, fmt.Sprintf("%s: %v", k, alert.Details[k]))
+	}
+
+	return strings.Join(lines, "\n")
+}
@@ -0,0 +1,230 @@
+package archive
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"fmt"
+	"path"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/rs/zerolog/log"
+
+	"github.com/gosight/gosight/processor/internal/config"
+	"github.com/gosight/gosight/processor/internal/consumer"
+)
+
+// FormatNDJSONGzip writes one enriched event JSON per line, gzipped
+const FormatNDJSONGzip = "ndjson_gzip"
+
+// Archiver batches enriched events from Kafka and writes them to object storage.
+// A batch is written when it reaches batch_size or when rotate_interval has
+// passed since it was started. It implements consumer.DeferredProcessor: an
+// event's offset is committed only once its batch is uploaded, and a failed
+// upload is retried with backoff (holding up new events meanwhile) rather than
+// dropped, so an outage or a crash re-delivers events instead of losing them.
+// Events may then be archived twice, never lost.
+type Archiver struct {
+	store          ObjectStore
+	prefix         string
+	batchSize      int
+	rotateInterval time.Duration
+	uploadBackoff  time.Duration // after a failed upload, doubled up to maxUploadBackoff
+
+	buf        bytes.Buffer
+	gz         *gzip.Writer
+	count      int
+	batchStart time.Time
+	acks       []func() // of the events in the current batch
+	unsent     []*sealedBatch
+	mu         sync.Mutex
+
+	// Canceled by Stop, to give up retrying a rotated batch
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	ticker *time.Ticker
+	done   chan struct{}
+}
+
+// sealedBatch is a complete batch waiting to be uploaded
+type sealedBatch struct {
+	key   string
+	body  []byte
+	count int
+	acks  []func()
+}
+
+const (
+	// Pause after a failed upload, doubled after each one up to maxUploadBackoff
+	defaultUploadBackoff = time.Second
+	maxUploadBackoff     = time.Minute
+
+	// How long Flush keeps retrying the last batches at shutdown
+	flushTimeout = 30 * time.Second
+)
+
+// NewArchiver creates an archiver writing to store
+func NewArchiver(store ObjectStore, cfg config.ArchiveConfig) (*Archiver, error) {
+	if cfg.Format != FormatNDJSONGzip {
+		return nil, fmt.Errorf("unsupported archive format %q (supported: %s)", cfg.Format, FormatNDJSONGzip)
+	}
+
+	a := &Archiver{
+		store:          store,
+		prefix:         cfg.Prefix,
+		batchSize:      cfg.BatchSize,
+		rotateInterval: cfg.RotateInterval,
+		uploadBackoff:  defaultUploadBackoff,
+		done:           make(chan struct{}),
+	}
+	a.gz = gzip.NewWriter(&a.buf)
+	a.ctx, a.cancel = context.WithCancel(context.Background())
+
+	// Check for batches due to rotation even when no events arrive
+	a.ticker = time.NewTicker(time.Second)
+	go a.rotateLoop()
+
+	return a, nil
+}
+
+// DefersCommits marks the archiver as a consumer.DeferredProcessor
+func (a *Archiver) DefersCommits() {}
+
+// Process appends an enriched event to the current batch. Its message is
+// acked once the batch is uploaded.
+func (a *Archiver) Process(ctx context.Context, event map[string]interface{}) error {
+	line, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if a.count == 0 {
+		a.batchStart = time.Now()
+	}
+	a.gz.Write(line)
+	a.gz.Write([]byte("\n"))
+	a.count++
+	a.acks = append(a.acks, consumer.Ack(ctx))
+
+	if a.count >= a.batchSize {
+		// Only fails once ctx is done; the batch is then kept for Flush
+		return a.writeBatch(ctx)
+	}
+	return nil
+}
+
+// Flush writes the current batch and any batch left unsent, retrying for up
+// to flushTimeout
+func (a *Archiver) Flush() {
+	ctx, cancel := context.WithTimeout(context.Background(), flushTimeout)
+	defer cancel()
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if err := a.writeBatch(ctx); err != nil {
+		log.Error().Err(err).Int("batches", len(a.unsent)).Msg("Failed to write archive batches, leaving them uncommitted")
+	}
+}
+
+// Stop stops the rotation loop and writes the current batch. Call it before
+// closing the consumer, so the uploaded events' offsets can still be committed.
+func (a *Archiver) Stop() {
+	a.cancel()
+	a.ticker.Stop()
+	close(a.done)
+	a.Flush()
+}
+
+func (a *Archiver) rotateLoop() {
+	for {
+		select {
+		case <-a.done:
+			return
+		case <-a.ticker.C:
+			a.mu.Lock()
+			if a.count > 0 && time.Since(a.batchStart) >= a.rotateInterval {
+				if err := a.writeBatch(a.ctx); err != nil {
+					log.Error().Err(err).Msg("Failed to write archive batch")
+				}
+			}
+			a.mu.Unlock()
+		}
+	}
+}
+
+// writeBatch seals the current batch, starting a new one, and uploads every
+// sealed batch in order, acking their events. Must hold a.mu. A failed upload
+// is retried until ctx is done; the batches not yet uploaded are then kept
+// for the next call and the error returned.
+func (a *Archiver) writeBatch(ctx context.Context) error {
+	if a.count > 0 {
+		a.gz.Close()
+		a.unsent = append(a.unsent, &sealedBatch{
+			key:   a.objectKey(a.batchStart),
+			body:  bytes.Clone(a.buf.Bytes()),
+			count: a.count,
+			acks:  a.acks,
+		})
+
+		a.buf.Reset()
+		a.gz.Reset(&a.buf)
+		a.count = 0
+		a.acks = nil
+	}
+
+	for len(a.unsent) > 0 {
+		batch := a.unsent[0]
+		if err := a.upload(ctx, batch); err != nil {
+			return err
+		}
+		a.unsent = a.unsent[1:]
+		for _, ack := range batch.acks {
+			ack()
+		}
+	}
+	return nil
+}
+
+// upload puts a batch, retrying with backoff until it succeeds or ctx is done
+func (a *Archiver) upload(ctx context.Context, batch *sealedBatch) error {
+	backoff := a.uploadBackoff
+	for {
+		err := a.store.Put(ctx, batch.key, batch.body, "application/gzip")
+		if err == nil {
+			log.Info().Str("key", batch.key).Int("events", batch.count).Msg("Archived events")
+			return nil
+		}
+		if ctx.Err() != nil {
+			return fmt.Errorf("archive %d events to %s: %w", batch.count, batch.key, err)
+		}
+
+		log.Warn().
+			Err(err).
+			Str("key", batch.key).
+			Int("events", batch.count).
+			Dur("backoff", backoff).
+			Msg("Failed to write archive batch, retrying")
+
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("archive %d events to %s: %w", batch.count, batch.key, err)
+		case <-time.After(backoff):
+		}
+		backoff = min(backoff*2, maxUploadBackoff)
+	}
+}
+
+// objectKey partitions batches by the hour they were started in
+func (a *Archiver) objectKey(start time.Time) string {
+	start = start.UTC()
+	name := fmt.Sprintf("%s-%s.ndjson.gz", start.Format("20060102T150405Z"), uuid.New().String())
+	return path.Join(a.prefix, "dt="+start.Format("2006-01-02"), "hour="+start.Format("15"), name)
+}
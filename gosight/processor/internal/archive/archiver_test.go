@@ -0,0 +1,186 @@
+package archive
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gosight/gosight/processor/internal/config"
+	"github.com/gosight/gosight/processor/internal/consumer"
+)
+
+// fakeStore records uploaded objects, failing the first `failures` puts
+type fakeStore struct {
+	mu       sync.Mutex
+	objects  map[string][]byte
+	failures int
+	puts     int
+}
+
+func (s *fakeStore) Put(ctx context.Context, key string, body []byte, contentType string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.puts++
+	if s.failures > 0 {
+		s.failures--
+		return errors.New("s3 unavailable")
+	}
+	if s.objects == nil {
+		s.objects = make(map[string][]byte)
+	}
+	s.objects[key] = body
+	return nil
+}
+
+func (s *fakeStore) events(t *testing.T) []map[string]interface{} {
+	t.Helper()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var events []map[string]interface{}
+	for _, body := range s.objects {
+		gz, err := gzip.NewReader(bytes.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		scanner := bufio.NewScanner(gz)
+		for scanner.Scan() {
+			var event map[string]interface{}
+			if err := json.Unmarshal(scanner.Bytes(), &event); err != nil {
+				t.Fatal(err)
+			}
+			events = append(events, event)
+		}
+	}
+	return events
+}
+
+func newTestArchiver(t *testing.T, store ObjectStore, batchSize int) *Archiver {
+	t.Helper()
+	a, err := NewArchiver(store, config.ArchiveConfig{
+		Format:         FormatNDJSONGzip,
+		Prefix:         "events",
+		BatchSize:      batchSize,
+		RotateInterval: time.Hour,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	a.uploadBackoff = time.Millisecond
+	t.Cleanup(func() {
+		select {
+		case <-a.done:
+		default:
+			a.Stop()
+		}
+	})
+	return a
+}
+
+func process(t *testing.T, a *Archiver, ctx context.Context, id string, acked *atomic.Int32) error {
+	t.Helper()
+	return a.Process(consumer.WithAck(ctx, func() { acked.Add(1) }), map[string]interface{}{"event_id": id})
+}
+
+func TestArchiverAcksAfterUpload(t *testing.T) {
+	store := &fakeStore{}
+	a := newTestArchiver(t, store, 3)
+	var acked atomic.Int32
+
+	for _, id := range []string{"e1", "e2"} {
+		if err := process(t, a, context.Background(), id, &acked); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if n := acked.Load(); n != 0 {
+		t.Fatalf("acked %d events before the batch was uploaded", n)
+	}
+
+	if err := process(t, a, context.Background(), "e3", &acked); err != nil {
+		t.Fatal(err)
+	}
+	if n := acked.Load(); n != 3 {
+		t.Errorf("acked %d events after the upload, want 3", n)
+	}
+	if events := store.events(t); len(events) != 3 {
+		t.Errorf("archived %d events, want 3", len(events))
+	}
+	for key := range store.objects {
+		if !strings.HasPrefix(key, "events/dt=") || !strings.HasSuffix(key, ".ndjson.gz") {
+			t.Errorf("unexpected object key %s", key)
+		}
+	}
+}
+
+func TestArchiverRetriesFailedUploads(t *testing.T) {
+	store := &fakeStore{failures: 3}
+	a := newTestArchiver(t, store, 2)
+	var acked atomic.Int32
+
+	for _, id := range []string{"e1", "e2"} {
+		if err := process(t, a, context.Background(), id, &acked); err != nil {
+			t.Fatalf("Process returned %v; failed uploads must be retried, not surfaced", err)
+		}
+	}
+
+	if store.puts != 4 {
+		t.Errorf("puts = %d, want 4", store.puts)
+	}
+	if n := acked.Load(); n != 2 {
+		t.Errorf("acked %d events, want 2", n)
+	}
+	if events := store.events(t); len(events) != 2 {
+		t.Errorf("archived %d events, want 2 (no duplicates)", len(events))
+	}
+}
+
+func TestArchiverKeepsUnsentBatchUnacked(t *testing.T) {
+	store := &fakeStore{failures: 1 << 30}
+	a := newTestArchiver(t, store, 1)
+	var acked atomic.Int32
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if err := process(t, a, ctx, "e1", &acked); err == nil {
+		t.Fatal("expected an error once the context is done")
+	}
+	if n := acked.Load(); n != 0 {
+		t.Fatalf("acked %d events of a batch that was never uploaded", n)
+	}
+
+	// The store recovers; the next flush uploads the kept batch
+	store.mu.Lock()
+	store.failures = 0
+	store.mu.Unlock()
+	a.Flush()
+
+	if n := acked.Load(); n != 1 {
+		t.Errorf("acked %d events after the flush, want 1", n)
+	}
+	if events := store.events(t); len(events) != 1 || events[0]["event_id"] != "e1" {
+		t.Errorf("archived %v, want e1", events)
+	}
+}
+
+func TestArchiverStopFlushesCurrentBatch(t *testing.T) {
+	store := &fakeStore{}
+	a := newTestArchiver(t, store, 100)
+	var acked atomic.Int32
+
+	if err := process(t, a, context.Background(), "e1", &acked); err != nil {
+		t.Fatal(err)
+	}
+	a.Stop()
+
+	if n := acked.Load(); n != 1 {
+		t.Errorf("acked %d events, want 1", n)
+	}
+}
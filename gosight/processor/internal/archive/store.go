@@ -0,0 +1,201 @@
+package archive
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/gosight/gosight/processor/internal/config"
+)
+
+// ObjectStore writes archive objects
+type ObjectStore interface {
+	Put(ctx context.Context, key string, body []byte, contentType string) error
+}
+
+// NewObjectStore creates the object store selected by config
+func NewObjectStore(cfg config.ArchiveConfig) (ObjectStore, error) {
+	switch cfg.Store {
+	case "s3":
+		if cfg.S3.Bucket == "" {
+			return nil, fmt.Errorf("archive.s3.bucket is required")
+		}
+		return NewS3Store(cfg.S3), nil
+	case "file":
+		if cfg.Dir == "" {
+			return nil, fmt.Errorf("archive.dir is required")
+		}
+		return &FileStore{dir: cfg.Dir}, nil
+	default:
+		return nil, fmt.Errorf("unknown archive store %q", cfg.Store)
+	}
+}
+
+// FileStore writes objects under a local directory, for development
+type FileStore struct {
+	dir string
+}
+
+func (s *FileStore) Put(ctx context.Context, key string, body []byte, contentType string) error {
+	path := filepath.Join(s.dir, filepath.FromSlash(key))
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	return os.WriteFile(path, body, 0o644)
+}
+
+// S3Store writes objects with S3 PutObject requests signed with AWS Signature V4.
+// Endpoint can point at an S3-compatible service such as MinIO.
+type S3Store struct {
+	cfg    config.S3Config
+	client *http.Client
+}
+
+// NewS3Store creates an S3 store
+func NewS3Store(cfg config.S3Config) *S3Store {
+	return &S3Store{
+		cfg:    cfg,
+		client: &http.Client{Timeout: time.Minute},
+	}
+}
+
+func (s *S3Store) Put(ctx context.Context, key string, body []byte, contentType string) error {
+	u := s.objectURL(key)
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPut, u.String(), bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", contentType)
+	s.sign(req, body, time.Now().UTC())
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode/100 != 2 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("s3 put %s: %s: %s", key, resp.Status, strings.TrimSpace(string(msg)))
+	}
+	return nil
+}
+
+// objectURL uses path-style URLs with a custom endpoint and virtual-hosted AWS URLs otherwise.
+// The path is escaped the way SigV4 canonicalizes it, so the signed and sent paths match.
+func (s *S3Store) objectURL(key string) *url.URL {
+	u := &url.URL{
+		Scheme: "https",
+		Host:   fmt.Sprintf("%s.s3.%s.amazonaws.com", s.cfg.Bucket, s.cfg.Region),
+		Path:   "/" + key,
+	}
+	if s.cfg.Endpoint != "" {
+		u, _ = url.Parse(strings.TrimSuffix(s.cfg.Endpoint, "/"))
+		u.Path = "/" + s.cfg.Bucket + "/" + key
+	}
+	u.RawPath = awsEscapePath(u.Path)
+	return u
+}
+
+// awsEscapePath percent-encodes every byte of each path segment except unreserved characters
+func awsEscapePath(p string) string {
+	segments := strings.Split(p, "/")
+	for i, segment := range segments {
+		var b strings.Builder
+		for j := 0; j < len(segment); j++ {
+			c := segment[j]
+			if 'A' <= c && c <= 'Z' || 'a' <= c && c <= 'z' || '0' <= c && c <= '9' ||
+				c == '-' || c == '_' || c == '.' || c == '~' {
+				b.WriteByte(c)
+			} else {
+				fmt.Fprintf(&b, "%%%02X", c)
+			}
+		}
+		segments[i] = b.String()
+	}
+	return strings.Join(segments, "/")
+}
+
+// sign adds AWS Signature V4 headers for the s3 service
+func (s *S3Store) sign(req *http.Request, body []byte, now time.Time) {
+	amzDate := now.Format("20060102T150405Z")
+	date := now.Format("20060102")
+	payloadHash := sha256Hex(body)
+
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+	if s.cfg.SessionToken != "" {
+		req.Header.Set("X-Amz-Security-Token", s.cfg.SessionToken)
+	}
+
+	// Canonical headers: host plus every x-amz-* and content-type header, sorted
+	headers := map[string]string{
+		"host":                 req.URL.Host,
+		"content-type":         req.Header.Get("Content-Type"),
+		"x-amz-content-sha256": payloadHash,
+		"x-amz-date":           amzDate,
+	}
+	if s.cfg.SessionToken != "" {
+		headers["x-amz-security-token"] = s.cfg.SessionToken
+	}
+	names := []string{"content-type", "host", "x-amz-content-sha256", "x-amz-date"}
+	if s.cfg.SessionToken != "" {
+		names = append(names, "x-amz-security-token")
+	}
+
+	var canonicalHeaders strings.Builder
+	for _, name := range names {
+		canonicalHeaders.WriteString(name + ":" + strings.TrimSpace(headers[name]) + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		req.URL.EscapedPath(),
+		"", // no query string
+		canonicalHeaders.String(),
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+
+	scope := date + "/" + s.cfg.Region + "/s3/aws4_request"
+	stringToSign := strings.Join([]string{
+		"AWS4-HMAC-SHA256",
+		amzDate,
+		scope,
+		sha256Hex([]byte(canonicalRequest)),
+	}, "\n")
+
+	signingKey := hmacSHA256([]byte("AWS4"+s.cfg.SecretAccessKey), date)
+	signingKey = hmacSHA256(signingKey, s.cfg.Region)
+	signingKey = hmacSHA256(signingKey, "s3")
+	signingKey = hmacSHA256(signingKey, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(signingKey, stringToSign))
+
+	req.Header.Set("Authorization", fmt.Sprintf(
+		"AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		s.cfg.AccessKeyID, scope, signedHeaders, signature,
+	))
+}
+
+func sha256Hex(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
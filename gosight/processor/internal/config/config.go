@@ -1,27 +1,386 @@
 package config
 
 import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
 	"os"
+	"regexp"
 	"time"
 
 	"gopkg.in/yaml.v3"
+
+	"github.com/gosight/gosight/processor/internal/eventtype"
 )
 
 type Config struct {
 	Kafka      KafkaConfig      `yaml:"kafka"`
 	ClickHouse ClickHouseConfig `yaml:"clickhouse"`
 	Redis      RedisConfig      `yaml:"redis"`
+	Postgres   PostgresConfig   `yaml:"postgres"`
 	Batch      BatchConfig      `yaml:"batch"`
+	Metrics    MetricsConfig    `yaml:"metrics"`
+	Storage    StorageConfig    `yaml:"storage"`
 	Insights   InsightsConfig   `yaml:"insights"`
+	Rollup     RollupConfig     `yaml:"rollup"`
+	Retention  RetentionConfig  `yaml:"retention"`
+	LiveTop    LiveTopConfig    `yaml:"live_top"`
+	Selector   SelectorConfig   `yaml:"selector"`
+
+	FrustrationScore FrustrationScoreConfig `yaml:"frustration_score"`
+	Archive          ArchiveConfig          `yaml:"archive"`
+	Alerter          AlerterConfig          `yaml:"alerter"`
+	Replay           ReplayConfig           `yaml:"replay"`
+
+	SessionCheckpoint SessionCheckpointConfig `yaml:"session_checkpoint"`
+	SessionCDC        SessionCDCConfig        `yaml:"session_cdc"`
+	SessionFlush      SessionFlushConfig      `yaml:"session_flush"`
+	SessionUpdates    SessionUpdatesConfig    `yaml:"session_updates"`
+	SessionScroll     SessionScrollConfig     `yaml:"session_scroll_depth"`
+
+	Consent ConsentConfig `yaml:"consent"`
+
+	Enrichment EnrichmentConfig `yaml:"enrichment"`
+
+	ErrorSampling ErrorSamplingConfig `yaml:"error_sampling"`
+
+	AggregateMetrics AggregateMetricsConfig `yaml:"aggregate_metrics"`
+
+	ProjectSettings ProjectSettingsConfig `yaml:"project_settings"`
+
+	Shadow ShadowConfig `yaml:"shadow"`
+}
+
+// ShadowConfig runs the event and insight processors as a shadow deployment,
+// to validate a change against live traffic: they consume the events topic in
+// their own consumer groups (the insight processor appends "-insights") and
+// write to tables prefixed with TablePrefix, or nowhere with Discard. Every
+// other Kafka output (alerts, session checkpoints and CDC, dead letters) is
+// off, the web vitals rollup doesn't run and Redis state lives in RedisDB.
+type ShadowConfig struct {
+	Enabled       bool   `yaml:"enabled"`
+	ConsumerGroup string `yaml:"consumer_group"`
+	TablePrefix   string `yaml:"table_prefix"`
+	Discard       bool   `yaml:"discard"` // process and log counts only
+	RedisDB       int    `yaml:"redis_db"`
+}
+
+// PostgresConfig points at the API database, read for project settings
+type PostgresConfig struct {
+	DSN string `yaml:"dsn"`
+}
+
+// ProjectSettingsConfig controls loading of per-project settings (insight
+// toggles, excluded paths, retention, insight rules) from the project_settings table. Changes are picked up as they are
+// notified and the whole table is reloaded every RefreshInterval.
+type ProjectSettingsConfig struct {
+	Enabled         bool          `yaml:"enabled"`
+	RefreshInterval time.Duration `yaml:"refresh_interval"`
+}
+
+// Timestamp sources for the primary events timestamp column
+const (
+	TimestampSourceClient = "client"
+	TimestampSourceServer = "server"
+)
+
+// eventCategoryPattern restricts event categories to names usable in table names
+var eventCategoryPattern = regexp.MustCompile(`^[a-z0-9_]+$`)
+
+// StorageConfig controls how events are stored. TimestampSource picks which
+// timestamp fills the primary timestamp column (and so all time bucketing):
+// the client event time or the ingestor's server_timestamp. The other one is
+// kept in secondary_timestamp.
+type StorageConfig struct {
+	TimestampSource string `yaml:"timestamp_source"`
+	CompressPayload bool   `yaml:"compress_payload"` // gzip event payloads into payload_compressed
+
+	// EventCategories stores the events of each category's types in their own
+	// table, events_<category> (e.g. "interaction": [click, scroll, mouse_move]),
+	// for a shorter TTL or separate storage of high-volume types. Other types
+	// stay in events; reads union all event tables.
+	EventCategories map[string][]string `yaml:"event_categories"`
+}
+
+// SessionCheckpointConfig controls checkpointing of in-flight sessions to the
+// compacted "sessions" Kafka topic. An interval of 0 checkpoints on every update.
+type SessionCheckpointConfig struct {
+	Enabled  bool          `yaml:"enabled"`
+	Interval time.Duration `yaml:"interval"`
+}
+
+// SessionCDCConfig controls publishing of flushed sessions to the "sessions_cdc"
+// Kafka topic for warehouse sink connectors
+type SessionCDCConfig struct {
+	Enabled bool `yaml:"enabled"`
+}
+
+// SessionFlushConfig controls the flush of all in-flight sessions at shutdown.
+// Workers flush sessions concurrently, each holding a ClickHouse connection.
+type SessionFlushConfig struct {
+	Workers int `yaml:"workers"`
+}
+
+// SessionUpdatesConfig bounds the concurrent session updates in Redis. Workers
+// each run one Redis pipeline at a time (keep at or below the Redis pool size,
+// 10 per CPU); up to QueueSize updates wait for them before event processing
+// blocks.
+type SessionUpdatesConfig struct {
+	Workers   int `yaml:"workers"`
+	QueueSize int `yaml:"queue_size"`
+}
+
+// SessionScrollConfig controls tracking of the maximum scroll depth of page
+// views (page_views.max_scroll_depth) from scroll events in the session
+// aggregator. Each visit of a page keeps its own maximum.
+type SessionScrollConfig struct {
+	Enabled bool `yaml:"enabled"`
+}
+
+// ConsentConfig lists the event types still stored for users who didn't
+// consent to analytics, without user identifiers (user ID, IP, user agent,
+// city). Their other events are dropped. Consent itself is resolved by the
+// ingestor (consent.mode there).
+type ConsentConfig struct {
+	EssentialTypes []string `yaml:"essential_types"`
+}
+
+// EnrichmentConfig controls the raw enrichment inputs stored with events
+type EnrichmentConfig struct {
+	// Stores the raw User-Agent string in events.user_agent and
+	// sessions.user_agent, to re-parse it when the parser improves (ingestor
+	// backfill-enrichment) or debug misclassified browsers. Off by default:
+	// the full string is mildly identifying.
+	StoreRawUserAgent bool `yaml:"store_raw_user_agent"`
+}
+
+// Error sampling strategies
+const (
+	ErrorSamplingNone   = "none"
+	ErrorSamplingGroup  = "group"
+	ErrorSamplingSample = "sample"
+)
+
+// ErrorSamplingConfig controls how identical errors (same fingerprint) are
+// reduced before insertion, per Window: group stores one row per fingerprint
+// with its occurrence_count; sample stores the first KeepFirst, then one in
+// 1/SampleRate. none stores every error.
+type ErrorSamplingConfig struct {
+	Strategy   string        `yaml:"strategy"`
+	Window     time.Duration `yaml:"window"`
+	KeepFirst  int           `yaml:"keep_first"`
+	SampleRate float64       `yaml:"sample_rate"`
+}
+
+// AggregateMetricsConfig bounds the pre-aggregated metrics pushed as aggregate
+// events. A bucket must be one of BucketSizes and start on a multiple of it; a
+// value has at most MaxDimensions labels, and at most MaxSeriesPerMetric
+// distinct label sets are stored per project, metric and bucket (the rest are
+// dropped).
+type AggregateMetricsConfig struct {
+	BucketSizes        []time.Duration `yaml:"bucket_sizes"`
+	MaxDimensions      int             `yaml:"max_dimensions"`
+	MaxSeriesPerMetric int             `yaml:"max_series_per_metric"`
+}
+
+// ArchiveConfig controls the archiver, which copies enriched events to object
+// storage in its own consumer group
+type ArchiveConfig struct {
+	Enabled        bool          `yaml:"enabled"`
+	ConsumerGroup  string        `yaml:"consumer_group"`
+	Store          string        `yaml:"store"`  // s3 or file
+	Format         string        `yaml:"format"` // ndjson_gzip
+	Prefix         string        `yaml:"prefix"` // object key prefix
+	BatchSize      int           `yaml:"batch_size"`
+	RotateInterval time.Duration `yaml:"rotate_interval"` // max age of a batch before it is written
+	Dir            string        `yaml:"dir"`             // file store only
+	S3             S3Config      `yaml:"s3"`
+}
+
+type S3Config struct {
+	Bucket          string `yaml:"bucket"`
+	Region          string `yaml:"region"`
+	Endpoint        string `yaml:"endpoint"` // S3-compatible endpoint (e.g. MinIO), path-style
+	AccessKeyID     string `yaml:"access_key_id"`
+	SecretAccessKey string `yaml:"secret_access_key"`
+	SessionToken    string `yaml:"session_token"`
+}
+
+// AlerterConfig controls the alerter, which turns alerts from the "alerts" topic
+// into notifications. Each alert goes to the sinks of every matching route, at
+// most once per cooldown per sink, project, insight type and page.
+type AlerterConfig struct {
+	Enabled       bool                       `yaml:"enabled"`
+	ConsumerGroup string                     `yaml:"consumer_group"`
+	DLQTopic      string                     `yaml:"dlq_topic"` // undeliverable alerts
+	Cooldown      time.Duration              `yaml:"cooldown"`
+	MaxAttempts   int                        `yaml:"max_attempts"`  // delivery attempts per sink
+	RetryBackoff  time.Duration              `yaml:"retry_backoff"` // doubled after each failed attempt
+	Sinks         map[string]AlertSinkConfig `yaml:"sinks"`
+	Routes        []AlertRouteConfig         `yaml:"routes"`
+}
+
+// ReplayConfig controls the replay processor, which stores replay chunks from
+// the "replay" topic and keeps a manifest per session. A session's manifest is
+// finalized once no chunk arrived for SessionTimeout.
+type ReplayConfig struct {
+	Enabled        bool          `yaml:"enabled"`
+	ConsumerGroup  string        `yaml:"consumer_group"`
+	BatchSize      int           `yaml:"batch_size"`
+	FlushInterval  time.Duration `yaml:"flush_interval"`
+	SessionTimeout time.Duration `yaml:"session_timeout"`
+}
+
+// AlertSinkConfig configures one notification channel
+type AlertSinkConfig struct {
+	Type    string            `yaml:"type"`    // slack, email or webhook
+	URL     string            `yaml:"url"`     // slack and webhook
+	Headers map[string]string `yaml:"headers"` // webhook only
+	SMTP    SMTPConfig        `yaml:"smtp"`    // email only
+	To      []string          `yaml:"to"`      // email only
+}
+
+type SMTPConfig struct {
+	Host     string `yaml:"host"`
+	Port     int    `yaml:"port"`
+	Username string `yaml:"username"`
+	Password string `yaml:"password"`
+	From     string `yaml:"from"`
+}
+
+// AlertRouteConfig sends alerts of a project (empty or "*" for all) and insight
+// types (empty for all), with at least MinConfidence, to the named sinks
+type AlertRouteConfig struct {
+	ProjectID     string        `yaml:"project_id"`
+	InsightTypes  []string      `yaml:"insight_types"`
+	MinConfidence float64       `yaml:"min_confidence"`
+	Sinks         []string      `yaml:"sinks"`
+	Cooldown      time.Duration `yaml:"cooldown"` // overrides alerter.cooldown
+}
+
+// FrustrationScoreConfig sets the points each insight type adds to a session's
+// frustration score at full confidence; empty uses the storage defaults
+type FrustrationScoreConfig struct {
+	Weights map[string]float64 `yaml:"weights"`
+}
+
+// SelectorConfig controls target selector normalization. Each pattern matches a
+// generated class name; a capture group keeps that part, otherwise the class is dropped.
+// MaxTargetClasses and MaxClassLength bound the target_classes kept from a payload.
+type SelectorConfig struct {
+	HashedClassPatterns []string `yaml:"hashed_class_patterns"`
+	MaxTargetClasses    int      `yaml:"max_target_classes"` // further classes are dropped
+	MaxClassLength      int      `yaml:"max_class_length"`   // longer classes are truncated
 }
 
+// RollupConfig controls the web vitals rollup: every Interval, the last
+// completed interval is rolled up, and the intervals of the Lateness before it
+// are rolled up again when web vitals of theirs arrived since
+type RollupConfig struct {
+	Enabled  bool          `yaml:"enabled"`
+	Interval time.Duration `yaml:"interval"`
+	Lateness time.Duration `yaml:"lateness"`
+}
+
+// RetentionConfig controls per-project retention: every Interval, rows older
+// than a project's project_settings.retention_days are deleted from the
+// analytics tables. Deletes are ClickHouse mutations, issued one at a time at
+// least MutationInterval apart and only while fewer than MaxPendingMutations
+// are unfinished. DryRun only counts and logs the rows that would be deleted.
+type RetentionConfig struct {
+	Enabled             bool          `yaml:"enabled"`
+	Interval            time.Duration `yaml:"interval"`
+	MutationInterval    time.Duration `yaml:"mutation_interval"`
+	MaxPendingMutations int           `yaml:"max_pending_mutations"`
+	DryRun              bool          `yaml:"dry_run"`
+}
+
+// LiveTopConfig controls the live top counts in Redis: per project and Bucket,
+// a sorted set of each tracked dimension's counts, kept for Retention and
+// trimmed to its MaxMembers top members. N is the default number of members
+// read.
+type LiveTopConfig struct {
+	Enabled    bool          `yaml:"enabled"`
+	Dimensions []string      `yaml:"dimensions"`
+	N          int           `yaml:"n"`
+	Bucket     time.Duration `yaml:"bucket"`
+	Retention  time.Duration `yaml:"retention"`
+	MaxMembers int           `yaml:"max_members"`
+}
+
+// Live top dimensions (live_top.dimensions)
+const (
+	LiveDimensionPages  = "pages"  // page views by page path
+	LiveDimensionErrors = "errors" // error occurrences by fingerprint
+)
+
 type InsightsConfig struct {
-	RageClick      RageClickConfig      `yaml:"rage_click"`
-	DeadClick      DeadClickConfig      `yaml:"dead_click"`
-	ErrorClick     ErrorClickConfig     `yaml:"error_click"`
-	ThrashedCursor ThrashedCursorConfig `yaml:"thrashed_cursor"`
-	UTurn          UTurnConfig          `yaml:"u_turn"`
-	SlowPage       SlowPageConfig       `yaml:"slow_page"`
+	RageClick      RageClickConfig           `yaml:"rage_click"`
+	DeadClick      DeadClickConfig           `yaml:"dead_click"`
+	ErrorClick     ErrorClickConfig          `yaml:"error_click"`
+	ThrashedCursor ThrashedCursorConfig      `yaml:"thrashed_cursor"`
+	RageScroll     RageScrollConfig          `yaml:"rage_scroll"`
+	UTurn          UTurnConfig               `yaml:"u_turn"`
+	SlowPage       SlowPageConfig            `yaml:"slow_page"`
+	SlowAbandon    SlowPageAbandonmentConfig `yaml:"slow_page_abandonment"`
+	FormRetry      FormRetryConfig           `yaml:"form_retry"`
+	ReloadLoop     ReloadLoopConfig          `yaml:"reload_loop"`
+	ErrorPage      ErrorPageConfig           `yaml:"error_page"`
+	LongTask       LongTaskConfig            `yaml:"long_task"`
+	MediaAbandon   MediaAbandonmentConfig    `yaml:"media_abandonment"`
+	MissingVitals  MissingVitalsConfig       `yaml:"missing_vitals"`
+	Pogostick      PogostickConfig           `yaml:"pogostick"`
+	Funnel         FunnelAbandonmentConfig   `yaml:"funnel_abandonment"`
+	FunnelDrop     FunnelDropConfig          `yaml:"funnel_drop"`
+	RateCap        InsightRateCapConfig      `yaml:"rate_cap"`
+	ReplayKeep     ReplayKeepConfig          `yaml:"replay_keep"`
+	Priority       InsightPriorityConfig     `yaml:"priority"`
+	Alerts         InsightAlertsConfig       `yaml:"alerts"`
+	Checkpoint     InsightCheckpointConfig   `yaml:"state_checkpoint"`
+}
+
+// InsightCheckpointConfig checkpoints the in-memory state of the detectors
+// (all but rage click, whose state lives in Redis) and the page history of the
+// navigation detectors (u-turn, ...) to Redis, every Interval and on graceful
+// shutdown, and restores it on startup, so a deploy doesn't reset detection
+// for active sessions. State older than MaxAge is neither saved nor restored.
+// Instance names the checkpoint: each insight processor running at the same
+// time needs its own, kept across its restarts (e.g. a StatefulSet pod name).
+type InsightCheckpointConfig struct {
+	Enabled  bool          `yaml:"enabled"`
+	Interval time.Duration `yaml:"interval"`
+	MaxAge   time.Duration `yaml:"max_age"`
+	Instance string        `yaml:"instance"`
+}
+
+// InsightAlertsConfig controls publishing of insights as alerts to the "alerts"
+// topic. Enabled without an alerts topic or brokers is logged at startup, or
+// fails config loading when Strict. The asynchronous writes are batched: a
+// batch is sent at BatchSize alerts or after BatchTimeout. The fast path's
+// synchronous alerts are not batched.
+type InsightAlertsConfig struct {
+	Enabled      bool          `yaml:"enabled"`
+	Strict       bool          `yaml:"strict"`
+	BatchSize    int           `yaml:"batch_size"`
+	BatchTimeout time.Duration `yaml:"batch_timeout"`
+}
+
+// InsightPriorityConfig lists insight types written and alerted on a fast path
+// (synchronous alert, ClickHouse flush every FlushInterval or BatchSize rows)
+// instead of the 5s batch. One insight per project, type and path per Cooldown
+// takes the fast path; the rest use the batched path.
+type InsightPriorityConfig struct {
+	Types         []string      `yaml:"types"`
+	FlushInterval time.Duration `yaml:"flush_interval"`
+	BatchSize     int           `yaml:"batch_size"`
+	Cooldown      time.Duration `yaml:"cooldown"`
+}
+
+// ReplayKeepConfig flags sessions with an insight in Redis so the ingestor keeps
+// their replay chunks regardless of replay sampling
+type ReplayKeepConfig struct {
+	Enabled bool          `yaml:"enabled"`
+	TTL     time.Duration `yaml:"ttl"` // how long after the insight chunks are kept
 }
 
 type RageClickConfig struct {
@@ -29,6 +388,10 @@ type RageClickConfig struct {
 	MinClicks    int   `yaml:"min_clicks"`
 	TimeWindowMs int64 `yaml:"time_window_ms"`
 	RadiusPx     int   `yaml:"radius_px"`
+	// Store a session's cells in one Redis hash instead of one key per cell
+	HashPerSession bool `yaml:"hash_per_session"`
+	// Max cells tracked per session in hash mode (0 = unlimited)
+	MaxCellsPerSession int `yaml:"max_cells_per_session"`
 }
 
 type DeadClickConfig struct {
@@ -41,28 +404,237 @@ type ErrorClickConfig struct {
 	ErrorWindowMs int64 `yaml:"error_window_ms"`
 }
 
+// RageScrollConfig fires when a page is scrolled back and forth at least
+// MinDirectionChanges times within WindowMs at MinVelocity px/sec or faster
+type RageScrollConfig struct {
+	Enabled             bool  `yaml:"enabled"`
+	MinDirectionChanges int   `yaml:"min_direction_changes"`
+	MinVelocity         int   `yaml:"min_velocity"`
+	WindowMs            int64 `yaml:"window_ms"`
+}
+
 type ThrashedCursorConfig struct {
 	Enabled             bool  `yaml:"enabled"`
 	MinDurationMs       int64 `yaml:"min_duration_ms"`
 	MinDirectionChanges int   `yaml:"min_direction_changes"`
 	MinVelocity         int   `yaml:"min_velocity"`
+	// Fire when the weighted thrash score reaches this (1.0 = at baseline)
+	ScoreThreshold  float64 `yaml:"score_threshold"`
+	DirectionWeight float64 `yaml:"direction_weight"`
+	VelocityWeight  float64 `yaml:"velocity_weight"`
+	// Movement with fewer direction changes never fires, whatever its score
+	GateDirectionChanges int `yaml:"gate_direction_changes"`
+	// Direction changes are counted on a moving average of the last
+	// SmoothingWindow points, once it moved at least MinSegmentPx; 0 disables
+	// either
+	SmoothingWindow int `yaml:"smoothing_window"`
+	MinSegmentPx    int `yaml:"min_segment_px"`
 }
 
 type UTurnConfig struct {
 	Enabled       bool  `yaml:"enabled"`
 	MaxTimeAwayMs int64 `yaml:"max_time_away_ms"`
+	// Pages visited before returning that still make a U-turn: 1 only
+	// detects direct A -> B -> A returns, 2 also A -> B -> C -> A, ...
+	LookbackDepth int `yaml:"lookback_depth"`
 }
 
 type SlowPageConfig struct {
 	Enabled         bool  `yaml:"enabled"`
 	LCPThresholdMs  int64 `yaml:"lcp_threshold_ms"`
 	TTFBThresholdMs int64 `yaml:"ttfb_threshold_ms"`
+	DebounceMs      int64 `yaml:"debounce_ms"` // one insight per page per interval, with the slow load count
+}
+
+// SlowPageAbandonmentConfig reports sessions that leave a slow page (by the
+// slow_page thresholds) or don't interact within AbandonmentWindowMs of the load
+type SlowPageAbandonmentConfig struct {
+	Enabled             bool  `yaml:"enabled"`
+	AbandonmentWindowMs int64 `yaml:"abandonment_window_ms"`
+}
+
+type FormRetryConfig struct {
+	Enabled       bool  `yaml:"enabled"`
+	ErrorWindowMs int64 `yaml:"error_window_ms"`
+	MinAttempts   int   `yaml:"min_attempts"`
+}
+
+type ReloadLoopConfig struct {
+	Enabled    bool  `yaml:"enabled"`
+	MinReloads int   `yaml:"min_reloads"`
+	WindowMs   int64 `yaml:"window_ms"` // max time between consecutive reloads
+}
+
+// ErrorPageConfig reports navigation to a page with an HTTP status of
+// MinStatus or above (0 disables the check), a page the SDK flags as an error
+// page, or a page whose path matches one of PathPatterns (regular expressions)
+type ErrorPageConfig struct {
+	Enabled      bool     `yaml:"enabled"`
+	PathPatterns []string `yaml:"path_patterns"` // e.g. ^/404$, ^/errors?/
+	MinStatus    int      `yaml:"min_status"`
+}
+
+// LongTaskConfig fires when a page's long tasks add up to at least
+// TotalBlockingTimeThresholdMs within WindowMs
+type LongTaskConfig struct {
+	Enabled                      bool  `yaml:"enabled"`
+	TotalBlockingTimeThresholdMs int64 `yaml:"total_blocking_time_threshold_ms"`
+	WindowMs                     int64 `yaml:"window_ms"`
+}
+
+// MediaAbandonmentConfig reports media playback that stops before
+// MinWatchPercent of the media was watched: the session leaves the page or
+// sends no media event for InactivityTimeoutMs without the media ending
+type MediaAbandonmentConfig struct {
+	Enabled             bool    `yaml:"enabled"`
+	MinWatchPercent     float64 `yaml:"min_watch_percent"`
+	InactivityTimeoutMs int64   `yaml:"inactivity_timeout_ms"`
+}
+
+type PogostickConfig struct {
+	Enabled    bool  `yaml:"enabled"`
+	MinReturns int   `yaml:"min_returns"` // returns to the same hub page
+	WindowMs   int64 `yaml:"window_ms"`
+}
+
+// FunnelAbandonmentConfig defines a funnel as page paths visited in order. A
+// session that reaches min_step but not conversion_path within timeout_ms is
+// reported.
+type FunnelAbandonmentConfig struct {
+	Enabled        bool     `yaml:"enabled"`
+	Steps          []string `yaml:"steps"`
+	ConversionPath string   `yaml:"conversion_path"`
+	MinStep        int      `yaml:"min_step"` // 1-based
+	TimeoutMs      int64    `yaml:"timeout_ms"`
+}
+
+// FunnelDropConfig defines a funnel as page paths visited in order, the last
+// being the goal. A session on a step for step_timeout_ms without reaching the
+// next one is reported.
+type FunnelDropConfig struct {
+	Enabled       bool     `yaml:"enabled"`
+	Steps         []string `yaml:"steps"`
+	StepTimeoutMs int64    `yaml:"step_timeout_ms"`
+}
+
+type MissingVitalsConfig struct {
+	Enabled             bool     `yaml:"enabled"`
+	ExpectedMetrics     []string `yaml:"expected_metrics"`      // e.g. LCP, FCP, TTFB, CLS, INP, FID
+	ObservationWindowMs int64    `yaml:"observation_window_ms"` // from the page's first web vitals event
+}
+
+type InsightRateCapConfig struct {
+	Enabled      bool `yaml:"enabled"`
+	MaxPerMinute int  `yaml:"max_per_minute"` // per project, across all insight types
+}
+
+// RetryConfig is the exponential backoff between processing attempts: the
+// pause after the first failure is InitialBackoff, doubled after each one up to
+// MaxBackoff
+type RetryConfig struct {
+	MaxRetries     int           `yaml:"max_retries"` // after the first attempt; negative for none
+	InitialBackoff time.Duration `yaml:"initial_backoff"`
+	MaxBackoff     time.Duration `yaml:"max_backoff"`
 }
 
 type KafkaConfig struct {
 	Brokers       []string          `yaml:"brokers"`
 	Topics        map[string]string `yaml:"topics"`
 	ConsumerGroup string            `yaml:"consumer_group"`
+	// Retries of a message failing to process before it goes to DeadLetterTopic
+	Retry RetryConfig `yaml:"retry"`
+	// Messages failing every attempt or unparsable; dropped when empty
+	DeadLetterTopic string `yaml:"dead_letter_topic"`
+	// Concurrent processing; each session's events stay on one worker, in order
+	Workers int `yaml:"workers"`
+	// Registry for decoding Avro events (ingestor kafka.encoding: avro)
+	SchemaRegistry SchemaRegistryConfig `yaml:"schema_registry"`
+
+	// Authentication to the brokers, e.g. managed Kafka; used by every
+	// consumer and writer of the processor
+	SASL KafkaSASLConfig `yaml:"sasl"`
+	TLS  KafkaTLSConfig  `yaml:"tls"`
+}
+
+// SASL mechanisms (kafka.sasl.mechanism)
+const (
+	SASLMechanismPlain       = "PLAIN"
+	SASLMechanismScramSHA256 = "SCRAM-SHA-256"
+	SASLMechanismScramSHA512 = "SCRAM-SHA-512"
+)
+
+// KafkaSASLConfig authenticates to the brokers with SASL; disabled when no
+// mechanism is set. Keep credentials out of the file with ${VAR} references.
+type KafkaSASLConfig struct {
+	Mechanism string `yaml:"mechanism"` // PLAIN, SCRAM-SHA-256 or SCRAM-SHA-512
+	Username  string `yaml:"username"`
+	Password  string `yaml:"password"`
+}
+
+// KafkaTLSConfig connects to the brokers over TLS, and with a client
+// certificate over mutual TLS
+type KafkaTLSConfig struct {
+	Enabled bool `yaml:"enabled"`
+	// CA verifying the broker certificates; the system roots when empty
+	CAFile string `yaml:"ca_file"`
+	// Client certificate, for brokers requiring mTLS
+	CertFile string `yaml:"cert_file"`
+	KeyFile  string `yaml:"key_file"`
+	// Skips broker certificate verification; for testing only
+	InsecureSkipVerify bool `yaml:"insecure_skip_verify"`
+
+	config *tls.Config // loaded by Load
+}
+
+// Config returns the TLS configuration loaded from the files, nil when TLS is disabled
+func (c KafkaTLSConfig) Config() *tls.Config {
+	return c.config
+}
+
+// load loads the certificate files, so a bad file fails at startup rather
+// than on the first connection
+func (c *KafkaTLSConfig) load() error {
+	if !c.Enabled {
+		if c.CAFile != "" || c.CertFile != "" || c.KeyFile != "" {
+			return fmt.Errorf("kafka.tls files are set but kafka.tls.enabled is false")
+		}
+		return nil
+	}
+
+	tlsCfg := &tls.Config{
+		MinVersion:         tls.VersionTLS12,
+		InsecureSkipVerify: c.InsecureSkipVerify,
+	}
+	if c.CAFile != "" {
+		caPEM, err := os.ReadFile(c.CAFile)
+		if err != nil {
+			return fmt.Errorf("read kafka.tls.ca_file: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(caPEM) {
+			return fmt.Errorf("no certificates found in kafka.tls.ca_file %s", c.CAFile)
+		}
+		tlsCfg.RootCAs = pool
+	}
+	if (c.CertFile == "") != (c.KeyFile == "") {
+		return fmt.Errorf("kafka.tls.cert_file and kafka.tls.key_file must be set together")
+	}
+	if c.CertFile != "" {
+		cert, err := tls.LoadX509KeyPair(c.CertFile, c.KeyFile)
+		if err != nil {
+			return fmt.Errorf("load kafka.tls client certificate: %w", err)
+		}
+		tlsCfg.Certificates = []tls.Certificate{cert}
+	}
+	c.config = tlsCfg
+	return nil
+}
+
+// SchemaRegistryConfig points at a Confluent-compatible schema registry
+type SchemaRegistryConfig struct {
+	URL      string `yaml:"url"`
+	Username string `yaml:"username"`
+	Password string `yaml:"password"`
 }
 
 type ClickHouseConfig struct {
@@ -83,6 +655,15 @@ type RedisConfig struct {
 type BatchConfig struct {
 	Size          int           `yaml:"size"`
 	FlushInterval time.Duration `yaml:"flush_interval"`
+
+	// Inserts taking longer are logged as warnings and counted
+	SlowFlushThreshold time.Duration `yaml:"slow_flush_threshold"`
+}
+
+// MetricsConfig serves the event processor's metrics in the Prometheus text
+// format on Addr (e.g. ":9102"); empty disables the endpoint
+type MetricsConfig struct {
+	Addr string `yaml:"addr"`
 }
 
 func Load(path string) (*Config, error) {
@@ -100,12 +681,220 @@ func Load(path string) (*Config, error) {
 	}
 
 	// Set defaults
+	switch {
+	case cfg.Kafka.Retry.MaxRetries == 0:
+		cfg.Kafka.Retry.MaxRetries = 2
+	case cfg.Kafka.Retry.MaxRetries < 0:
+		cfg.Kafka.Retry.MaxRetries = 0
+	}
+	if cfg.Kafka.Retry.InitialBackoff < 0 || cfg.Kafka.Retry.MaxBackoff < 0 {
+		return nil, fmt.Errorf("kafka.retry.initial_backoff and max_backoff must not be negative")
+	}
+	if cfg.Kafka.Retry.InitialBackoff == 0 {
+		cfg.Kafka.Retry.InitialBackoff = 100 * time.Millisecond
+	}
+	if cfg.Kafka.Retry.MaxBackoff == 0 {
+		cfg.Kafka.Retry.MaxBackoff = 5 * time.Second
+	}
+	if cfg.Kafka.Workers == 0 {
+		cfg.Kafka.Workers = 1
+	}
+	switch cfg.Kafka.SASL.Mechanism {
+	case "":
+	case SASLMechanismPlain, SASLMechanismScramSHA256, SASLMechanismScramSHA512:
+		if cfg.Kafka.SASL.Username == "" {
+			return nil, fmt.Errorf("kafka.sasl.username is required with kafka.sasl.mechanism %s", cfg.Kafka.SASL.Mechanism)
+		}
+	default:
+		return nil, fmt.Errorf("invalid kafka.sasl.mechanism %q (want %s, %s or %s)", cfg.Kafka.SASL.Mechanism,
+			SASLMechanismPlain, SASLMechanismScramSHA256, SASLMechanismScramSHA512)
+	}
+	if err := cfg.Kafka.TLS.load(); err != nil {
+		return nil, err
+	}
+	if cfg.Storage.TimestampSource == "" {
+		cfg.Storage.TimestampSource = TimestampSourceClient
+	}
+	if cfg.Storage.TimestampSource != TimestampSourceClient && cfg.Storage.TimestampSource != TimestampSourceServer {
+		return nil, fmt.Errorf("invalid storage.timestamp_source %q (want %q or %q)",
+			cfg.Storage.TimestampSource, TimestampSourceClient, TimestampSourceServer)
+	}
+	categorized := make(map[eventtype.Type]string)
+	for category, types := range cfg.Storage.EventCategories {
+		if !eventCategoryPattern.MatchString(category) {
+			return nil, fmt.Errorf("invalid storage.event_categories category %q (want lowercase letters, digits and underscores)", category)
+		}
+		for _, t := range types {
+			et := eventtype.Normalize(t)
+			if et == eventtype.Unknown {
+				return nil, fmt.Errorf("unknown event type %q in storage.event_categories.%s", t, category)
+			}
+			if other, ok := categorized[et]; ok && other != category {
+				return nil, fmt.Errorf("event type %q is in both storage.event_categories.%s and %s", t, other, category)
+			}
+			categorized[et] = category
+		}
+	}
+	if cfg.Archive.ConsumerGroup == "" {
+		cfg.Archive.ConsumerGroup = "gosight-archiver"
+	}
+	if cfg.Archive.Store == "" {
+		cfg.Archive.Store = "s3"
+	}
+	if cfg.Archive.Format == "" {
+		cfg.Archive.Format = "ndjson_gzip"
+	}
+	if cfg.Archive.BatchSize == 0 {
+		cfg.Archive.BatchSize = 10000
+	}
+	if cfg.Archive.RotateInterval == 0 {
+		cfg.Archive.RotateInterval = 5 * time.Minute
+	}
+	if cfg.Archive.S3.Region == "" {
+		cfg.Archive.S3.Region = "us-east-1"
+	}
+	if cfg.Replay.ConsumerGroup == "" {
+		cfg.Replay.ConsumerGroup = "gosight-replay-processor"
+	}
+	if cfg.Replay.BatchSize == 0 {
+		cfg.Replay.BatchSize = 500
+	}
+	if cfg.Replay.FlushInterval == 0 {
+		cfg.Replay.FlushInterval = 5 * time.Second
+	}
+	if cfg.Replay.SessionTimeout == 0 {
+		cfg.Replay.SessionTimeout = 30 * time.Minute
+	}
+	if cfg.Alerter.ConsumerGroup == "" {
+		cfg.Alerter.ConsumerGroup = "gosight-alerter"
+	}
+	if cfg.Alerter.Cooldown == 0 {
+		cfg.Alerter.Cooldown = 10 * time.Minute
+	}
+	if cfg.Alerter.MaxAttempts == 0 {
+		cfg.Alerter.MaxAttempts = 3
+	}
+	if cfg.Alerter.RetryBackoff == 0 {
+		cfg.Alerter.RetryBackoff = time.Second
+	}
+	for i, route := range cfg.Alerter.Routes {
+		if route.MinConfidence < 0 || route.MinConfidence > 1 {
+			return nil, fmt.Errorf("alerter.routes[%d]: min_confidence must be between 0 and 1", i)
+		}
+		for _, name := range route.Sinks {
+			if _, ok := cfg.Alerter.Sinks[name]; !ok {
+				return nil, fmt.Errorf("alerter.routes[%d]: unknown sink %q", i, name)
+			}
+		}
+	}
+	if cfg.SessionFlush.Workers == 0 {
+		cfg.SessionFlush.Workers = 8
+	}
+	if cfg.SessionUpdates.Workers == 0 {
+		cfg.SessionUpdates.Workers = 16
+	}
+	if cfg.SessionUpdates.QueueSize == 0 {
+		cfg.SessionUpdates.QueueSize = 10000
+	}
+	if cfg.Consent.EssentialTypes == nil {
+		cfg.Consent.EssentialTypes = []string{string(eventtype.JSError)}
+	}
+	for _, t := range cfg.Consent.EssentialTypes {
+		if eventtype.Normalize(t) == eventtype.Unknown {
+			return nil, fmt.Errorf("consent.essential_types: unknown event type %q", t)
+		}
+	}
+	if cfg.ErrorSampling.Strategy == "" {
+		cfg.ErrorSampling.Strategy = ErrorSamplingNone
+	}
+	switch cfg.ErrorSampling.Strategy {
+	case ErrorSamplingNone, ErrorSamplingGroup, ErrorSamplingSample:
+	default:
+		return nil, fmt.Errorf("invalid error_sampling.strategy %q (want %q, %q or %q)",
+			cfg.ErrorSampling.Strategy, ErrorSamplingNone, ErrorSamplingGroup, ErrorSamplingSample)
+	}
+	if cfg.ErrorSampling.Window == 0 {
+		cfg.ErrorSampling.Window = time.Minute
+	}
+	if cfg.ErrorSampling.KeepFirst == 0 {
+		cfg.ErrorSampling.KeepFirst = 10
+	}
+	if cfg.ErrorSampling.SampleRate == 0 {
+		cfg.ErrorSampling.SampleRate = 0.01
+	}
+	if cfg.ErrorSampling.SampleRate < 0 || cfg.ErrorSampling.SampleRate > 1 {
+		return nil, fmt.Errorf("invalid error_sampling.sample_rate %v (want a rate in (0, 1])", cfg.ErrorSampling.SampleRate)
+	}
+	if len(cfg.AggregateMetrics.BucketSizes) == 0 {
+		cfg.AggregateMetrics.BucketSizes = []time.Duration{time.Minute, 5 * time.Minute, time.Hour}
+	}
+	for _, size := range cfg.AggregateMetrics.BucketSizes {
+		if size < time.Second || size%time.Second != 0 {
+			return nil, fmt.Errorf("invalid aggregate_metrics.bucket_sizes %s (want whole seconds)", size)
+		}
+	}
+	if cfg.AggregateMetrics.MaxDimensions == 0 {
+		cfg.AggregateMetrics.MaxDimensions = 10
+	}
+	if cfg.AggregateMetrics.MaxSeriesPerMetric == 0 {
+		cfg.AggregateMetrics.MaxSeriesPerMetric = 1000
+	}
+	if cfg.ProjectSettings.RefreshInterval == 0 {
+		cfg.ProjectSettings.RefreshInterval = time.Minute
+	}
+	if cfg.ProjectSettings.Enabled && cfg.Postgres.DSN == "" {
+		return nil, fmt.Errorf("project_settings requires postgres.dsn")
+	}
 	if cfg.Batch.Size == 0 {
 		cfg.Batch.Size = 1000
 	}
 	if cfg.Batch.FlushInterval == 0 {
 		cfg.Batch.FlushInterval = 5 * time.Second
 	}
+	if cfg.Batch.SlowFlushThreshold == 0 {
+		cfg.Batch.SlowFlushThreshold = 2 * time.Second
+	}
+	if cfg.Rollup.Interval == 0 {
+		cfg.Rollup.Interval = time.Hour
+	}
+	if cfg.Rollup.Lateness == 0 {
+		cfg.Rollup.Lateness = 6 * time.Hour
+	}
+	if cfg.Retention.Interval == 0 {
+		cfg.Retention.Interval = 24 * time.Hour
+	}
+	if cfg.Retention.MutationInterval == 0 {
+		cfg.Retention.MutationInterval = time.Minute
+	}
+	if cfg.Retention.MaxPendingMutations == 0 {
+		cfg.Retention.MaxPendingMutations = 5
+	}
+	if cfg.Retention.Enabled && !cfg.ProjectSettings.Enabled {
+		return nil, fmt.Errorf("retention requires project_settings.enabled")
+	}
+	if len(cfg.LiveTop.Dimensions) == 0 {
+		cfg.LiveTop.Dimensions = []string{LiveDimensionPages, LiveDimensionErrors}
+	}
+	for _, d := range cfg.LiveTop.Dimensions {
+		if d != LiveDimensionPages && d != LiveDimensionErrors {
+			return nil, fmt.Errorf("invalid live_top.dimensions %q (want %q or %q)", d, LiveDimensionPages, LiveDimensionErrors)
+		}
+	}
+	if cfg.LiveTop.N == 0 {
+		cfg.LiveTop.N = 10
+	}
+	if cfg.LiveTop.Bucket == 0 {
+		cfg.LiveTop.Bucket = time.Minute
+	}
+	if cfg.LiveTop.Retention == 0 {
+		cfg.LiveTop.Retention = time.Hour
+	}
+	if cfg.LiveTop.MaxMembers == 0 {
+		cfg.LiveTop.MaxMembers = 1000
+	}
+	if cfg.LiveTop.Enabled && cfg.Redis.Addr == "" {
+		return nil, fmt.Errorf("live_top requires redis.addr")
+	}
 	if cfg.ClickHouse.MaxOpenConns == 0 {
 		cfg.ClickHouse.MaxOpenConns = 10
 	}
@@ -129,6 +918,15 @@ func Load(path string) (*Config, error) {
 	if cfg.Insights.ErrorClick.ErrorWindowMs == 0 {
 		cfg.Insights.ErrorClick.ErrorWindowMs = 1000
 	}
+	if cfg.Insights.RageScroll.MinDirectionChanges == 0 {
+		cfg.Insights.RageScroll.MinDirectionChanges = 4
+	}
+	if cfg.Insights.RageScroll.MinVelocity == 0 {
+		cfg.Insights.RageScroll.MinVelocity = 1500
+	}
+	if cfg.Insights.RageScroll.WindowMs == 0 {
+		cfg.Insights.RageScroll.WindowMs = 3000
+	}
 	if cfg.Insights.ThrashedCursor.MinDurationMs == 0 {
 		cfg.Insights.ThrashedCursor.MinDurationMs = 2000
 	}
@@ -138,15 +936,173 @@ func Load(path string) (*Config, error) {
 	if cfg.Insights.ThrashedCursor.MinVelocity == 0 {
 		cfg.Insights.ThrashedCursor.MinVelocity = 500
 	}
+	if cfg.Insights.ThrashedCursor.ScoreThreshold == 0 {
+		cfg.Insights.ThrashedCursor.ScoreThreshold = 1.0
+	}
+	if cfg.Insights.ThrashedCursor.DirectionWeight == 0 && cfg.Insights.ThrashedCursor.VelocityWeight == 0 {
+		cfg.Insights.ThrashedCursor.DirectionWeight = 0.5
+		cfg.Insights.ThrashedCursor.VelocityWeight = 0.5
+	}
+	if cfg.Insights.ThrashedCursor.GateDirectionChanges == 0 {
+		cfg.Insights.ThrashedCursor.GateDirectionChanges = max(cfg.Insights.ThrashedCursor.MinDirectionChanges/2, 1)
+	}
 	if cfg.Insights.UTurn.MaxTimeAwayMs == 0 {
 		cfg.Insights.UTurn.MaxTimeAwayMs = 10000
 	}
+	if cfg.Insights.UTurn.LookbackDepth == 0 {
+		cfg.Insights.UTurn.LookbackDepth = 1
+	}
+	if cfg.Insights.UTurn.LookbackDepth < 0 {
+		return nil, fmt.Errorf("insights.u_turn.lookback_depth must be positive, got %d", cfg.Insights.UTurn.LookbackDepth)
+	}
 	if cfg.Insights.SlowPage.LCPThresholdMs == 0 {
 		cfg.Insights.SlowPage.LCPThresholdMs = 3000
 	}
 	if cfg.Insights.SlowPage.TTFBThresholdMs == 0 {
 		cfg.Insights.SlowPage.TTFBThresholdMs = 800
 	}
+	if cfg.Insights.SlowPage.DebounceMs == 0 {
+		cfg.Insights.SlowPage.DebounceMs = 300000
+	}
+	if cfg.Insights.SlowAbandon.AbandonmentWindowMs == 0 {
+		cfg.Insights.SlowAbandon.AbandonmentWindowMs = 30000
+	}
+	if cfg.Insights.FormRetry.ErrorWindowMs == 0 {
+		cfg.Insights.FormRetry.ErrorWindowMs = 5000
+	}
+	if cfg.Insights.FormRetry.MinAttempts == 0 {
+		cfg.Insights.FormRetry.MinAttempts = 3
+	}
+	if cfg.Insights.ReloadLoop.MinReloads == 0 {
+		cfg.Insights.ReloadLoop.MinReloads = 3
+	}
+	if cfg.Insights.ReloadLoop.WindowMs == 0 {
+		cfg.Insights.ReloadLoop.WindowMs = 30000
+	}
+	if cfg.Insights.ErrorPage.MinStatus == 0 {
+		cfg.Insights.ErrorPage.MinStatus = 400
+	}
+	for _, pattern := range cfg.Insights.ErrorPage.PathPatterns {
+		if _, err := regexp.Compile(pattern); err != nil {
+			return nil, fmt.Errorf("insights.error_page.path_patterns: %w", err)
+		}
+	}
+	if cfg.Insights.LongTask.TotalBlockingTimeThresholdMs == 0 {
+		cfg.Insights.LongTask.TotalBlockingTimeThresholdMs = 500
+	}
+	if cfg.Insights.LongTask.WindowMs == 0 {
+		cfg.Insights.LongTask.WindowMs = 10000
+	}
+	if cfg.Insights.MediaAbandon.MinWatchPercent == 0 {
+		cfg.Insights.MediaAbandon.MinWatchPercent = 25
+	}
+	if cfg.Insights.MediaAbandon.MinWatchPercent < 0 || cfg.Insights.MediaAbandon.MinWatchPercent > 100 {
+		return nil, fmt.Errorf("insights.media_abandonment.min_watch_percent must be between 0 and 100")
+	}
+	if cfg.Insights.MediaAbandon.InactivityTimeoutMs == 0 {
+		cfg.Insights.MediaAbandon.InactivityTimeoutMs = 1800000
+	}
+	if cfg.Insights.Pogostick.MinReturns == 0 {
+		cfg.Insights.Pogostick.MinReturns = 3
+	}
+	if cfg.Insights.Pogostick.WindowMs == 0 {
+		cfg.Insights.Pogostick.WindowMs = 300000
+	}
+	if cfg.Insights.Funnel.MinStep == 0 {
+		cfg.Insights.Funnel.MinStep = len(cfg.Insights.Funnel.Steps)
+	}
+	if cfg.Insights.Funnel.TimeoutMs == 0 {
+		cfg.Insights.Funnel.TimeoutMs = 1800000
+	}
+	if cfg.Insights.Funnel.Enabled {
+		if len(cfg.Insights.Funnel.Steps) == 0 || cfg.Insights.Funnel.ConversionPath == "" {
+			return nil, fmt.Errorf("insights.funnel_abandonment requires steps and conversion_path")
+		}
+		if cfg.Insights.Funnel.MinStep < 1 || cfg.Insights.Funnel.MinStep > len(cfg.Insights.Funnel.Steps) {
+			return nil, fmt.Errorf("insights.funnel_abandonment.min_step must be between 1 and %d", len(cfg.Insights.Funnel.Steps))
+		}
+	}
+	if cfg.Insights.FunnelDrop.StepTimeoutMs == 0 {
+		cfg.Insights.FunnelDrop.StepTimeoutMs = 600000
+	}
+	if cfg.Insights.FunnelDrop.Enabled && len(cfg.Insights.FunnelDrop.Steps) < 2 {
+		return nil, fmt.Errorf("insights.funnel_drop requires at least 2 steps")
+	}
+	if len(cfg.Insights.MissingVitals.ExpectedMetrics) == 0 {
+		cfg.Insights.MissingVitals.ExpectedMetrics = []string{"LCP"}
+	}
+	if cfg.Insights.MissingVitals.ObservationWindowMs == 0 {
+		cfg.Insights.MissingVitals.ObservationWindowMs = 60000
+	}
+	if cfg.Insights.ReplayKeep.TTL == 0 {
+		cfg.Insights.ReplayKeep.TTL = 24 * time.Hour
+	}
+	if cfg.Insights.Priority.FlushInterval == 0 {
+		cfg.Insights.Priority.FlushInterval = 200 * time.Millisecond
+	}
+	if cfg.Insights.Priority.BatchSize == 0 {
+		cfg.Insights.Priority.BatchSize = 10
+	}
+	if cfg.Insights.Priority.Cooldown == 0 {
+		cfg.Insights.Priority.Cooldown = time.Minute
+	}
+	if cfg.Insights.Checkpoint.Interval < 0 || cfg.Insights.Checkpoint.MaxAge < 0 {
+		return nil, fmt.Errorf("insights.state_checkpoint.interval and max_age must not be negative")
+	}
+	if cfg.Insights.Checkpoint.Interval == 0 {
+		cfg.Insights.Checkpoint.Interval = 30 * time.Second
+	}
+	if cfg.Insights.Checkpoint.MaxAge == 0 {
+		cfg.Insights.Checkpoint.MaxAge = 30 * time.Minute
+	}
+	if cfg.Insights.Checkpoint.Instance == "" {
+		cfg.Insights.Checkpoint.Instance = "default"
+	}
+	if cfg.Shadow.Enabled {
+		if err := cfg.applyShadow(); err != nil {
+			return nil, err
+		}
+	}
+	if cfg.Insights.Alerts.Enabled && cfg.Insights.Alerts.Strict &&
+		(cfg.Kafka.Topics["alerts"] == "" || len(cfg.Kafka.Brokers) == 0) {
+		return nil, fmt.Errorf("insights.alerts.enabled but kafka.topics.alerts or kafka.brokers is not set")
+	}
+	if cfg.Insights.Alerts.BatchSize == 0 {
+		cfg.Insights.Alerts.BatchSize = 50
+	}
+	if cfg.Insights.Alerts.BatchTimeout == 0 {
+		cfg.Insights.Alerts.BatchTimeout = 200 * time.Millisecond
+	}
+	if cfg.Insights.RateCap.MaxPerMinute == 0 {
+		cfg.Insights.RateCap.MaxPerMinute = 1000
+	}
 
 	return &cfg, nil
 }
+
+// applyShadow isolates a shadow deployment from production state
+func (cfg *Config) applyShadow() error {
+	if cfg.Shadow.ConsumerGroup == "" {
+		cfg.Shadow.ConsumerGroup = "gosight-shadow"
+	}
+	if cfg.Shadow.ConsumerGroup == cfg.Kafka.ConsumerGroup {
+		return fmt.Errorf("shadow.consumer_group must differ from kafka.consumer_group")
+	}
+	if cfg.Shadow.TablePrefix == "" && !cfg.Shadow.Discard {
+		return fmt.Errorf("shadow.table_prefix is required unless shadow.discard is set")
+	}
+	if cfg.Redis.Addr != "" && cfg.Shadow.RedisDB == cfg.Redis.DB {
+		return fmt.Errorf("shadow.redis_db must differ from redis.db")
+	}
+
+	cfg.Kafka.ConsumerGroup = cfg.Shadow.ConsumerGroup
+	cfg.Kafka.Topics = map[string]string{"events": cfg.Kafka.Topics["events"]}
+	cfg.Redis.DB = cfg.Shadow.RedisDB
+	cfg.SessionCheckpoint.Enabled = false
+	cfg.SessionCDC.Enabled = false
+	cfg.Rollup.Enabled = false
+	cfg.Retention.Enabled = false
+	cfg.Insights.Alerts.Enabled = false
+	cfg.Insights.ReplayKeep.Enabled = false
+	return nil
+}
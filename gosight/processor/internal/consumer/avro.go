@@ -0,0 +1,399 @@
+package consumer
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gosight/gosight/processor/internal/config"
+)
+
+// AvroDecoder decodes messages in the Confluent wire format (a zero magic byte,
+// the 4 byte schema ID, then the Avro binary encoding) into the same maps JSON
+// messages decode to. Each message is decoded with the schema it was written
+// with, fetched from the registry by ID and cached, so events written with an
+// older or newer compatible schema decode alike; fields the processor doesn't
+// know are passed along and missing ones are simply absent.
+type AvroDecoder struct {
+	cfg    config.SchemaRegistryConfig
+	client *http.Client
+
+	schemas map[uint32]*avroSchema
+	mu      sync.Mutex
+}
+
+// NewAvroDecoder creates a decoder using the configured schema registry
+func NewAvroDecoder(cfg config.SchemaRegistryConfig) *AvroDecoder {
+	return &AvroDecoder{
+		cfg:     cfg,
+		client:  &http.Client{Timeout: 10 * time.Second},
+		schemas: make(map[uint32]*avroSchema),
+	}
+}
+
+// IsAvro reports whether a message value is in the Confluent wire format.
+// JSON messages start with '{', so both can share a topic during a migration.
+func IsAvro(value []byte) bool {
+	return len(value) >= 5 && value[0] == 0
+}
+
+// Decode decodes a Confluent wire format message into a map. Numbers decode
+// to float64, as with encoding/json; string fields marked gosight.json in
+// the schema are decoded from JSON.
+func (d *AvroDecoder) Decode(value []byte) (map[string]interface{}, error) {
+	if !IsAvro(value) {
+		return nil, errors.New("not an Avro message")
+	}
+	schema, err := d.schema(binary.BigEndian.Uint32(value[1:5]))
+	if err != nil {
+		return nil, err
+	}
+
+	r := bytes.NewReader(value[5:])
+	v, err := schema.decode(r)
+	if err != nil {
+		return nil, err
+	}
+	event, ok := v.(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("schema is not a record")
+	}
+	return event, nil
+}
+
+// RegistryError is returned by Decode when the schema registry could not be
+// reached or failed (a transport error, 429 or 5xx). Unlike a message that
+// does not decode, decoding it again later may succeed.
+type RegistryError struct {
+	SchemaID uint32
+	Err      error
+}
+
+func (e *RegistryError) Error() string {
+	return fmt.Sprintf("fetch schema %d: %v", e.SchemaID, e.Err)
+}
+
+func (e *RegistryError) Unwrap() error {
+	return e.Err
+}
+
+// IsRetryable reports whether decoding failed because of the schema registry
+// rather than the message
+func IsRetryable(err error) bool {
+	var registryErr *RegistryError
+	return errors.As(err, &registryErr)
+}
+
+// schema returns the writer schema with the ID, fetching it on first use. The
+// fetch runs without holding the lock, so a slow registry only holds up the
+// messages needing an uncached schema.
+func (d *AvroDecoder) schema(id uint32) (*avroSchema, error) {
+	d.mu.Lock()
+	s, ok := d.schemas[id]
+	d.mu.Unlock()
+	if ok {
+		return s, nil
+	}
+
+	s, err := d.fetchSchema(id)
+	if err != nil {
+		return nil, err
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if cached, ok := d.schemas[id]; ok {
+		// Fetched concurrently by another message
+		return cached, nil
+	}
+	d.schemas[id] = s
+	return s, nil
+}
+
+// fetchSchema fetches and parses the schema with the ID from the registry
+func (d *AvroDecoder) fetchSchema(id uint32) (*avroSchema, error) {
+	req, err := http.NewRequest(http.MethodGet, d.cfg.URL+"/schemas/ids/"+strconv.FormatUint(uint64(id), 10), nil)
+	if err != nil {
+		return nil, err
+	}
+	if d.cfg.Username != "" {
+		req.SetBasicAuth(d.cfg.Username, d.cfg.Password)
+	}
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return nil, &RegistryError{SchemaID: id, Err: err}
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		err := fmt.Errorf("schema registry returned %s: %s", resp.Status, msg)
+		if resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500 {
+			return nil, &RegistryError{SchemaID: id, Err: err}
+		}
+		return nil, fmt.Errorf("fetch schema %d: %w", id, err)
+	}
+
+	var result struct {
+		Schema string `json:"schema"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		// A response cut short by the connection is worth fetching again
+		return nil, &RegistryError{SchemaID: id, Err: err}
+	}
+	s, err := parseAvroSchema(json.RawMessage(result.Schema), map[string]*avroSchema{})
+	if err != nil {
+		return nil, fmt.Errorf("parse schema %d: %w", id, err)
+	}
+	return s, nil
+}
+
+// avroSchema is a parsed Avro schema
+type avroSchema struct {
+	typ      string
+	fields   []avroRecordField // record
+	items    *avroSchema       // array, map values
+	branches []*avroSchema     // union
+	symbols  []string          // enum
+	size     int               // fixed
+}
+
+type avroRecordField struct {
+	name   string
+	schema *avroSchema
+	json   bool // gosight.json: a string holding JSON
+}
+
+// parseAvroSchema parses a schema; named holds the named types seen so far
+func parseAvroSchema(raw json.RawMessage, named map[string]*avroSchema) (*avroSchema, error) {
+	var name string
+	if err := json.Unmarshal(raw, &name); err == nil {
+		switch name {
+		case "null", "boolean", "int", "long", "float", "double", "bytes", "string":
+			return &avroSchema{typ: name}, nil
+		}
+		if s, ok := named[name]; ok {
+			return s, nil
+		}
+		return nil, fmt.Errorf("unknown type %q", name)
+	}
+
+	var union []json.RawMessage
+	if err := json.Unmarshal(raw, &union); err == nil {
+		s := &avroSchema{typ: "union"}
+		for _, b := range union {
+			branch, err := parseAvroSchema(b, named)
+			if err != nil {
+				return nil, err
+			}
+			s.branches = append(s.branches, branch)
+		}
+		return s, nil
+	}
+
+	var def struct {
+		Type      json.RawMessage `json:"type"`
+		Name      string          `json:"name"`
+		Namespace string          `json:"namespace"`
+		Fields    []struct {
+			Name string          `json:"name"`
+			Type json.RawMessage `json:"type"`
+			JSON bool            `json:"gosight.json"`
+		} `json:"fields"`
+		Items   json.RawMessage `json:"items"`
+		Values  json.RawMessage `json:"values"`
+		Symbols []string        `json:"symbols"`
+		Size    int             `json:"size"`
+	}
+	if err := json.Unmarshal(raw, &def); err != nil {
+		return nil, err
+	}
+	var typ string
+	if err := json.Unmarshal(def.Type, &typ); err != nil {
+		// e.g. {"type": {"type": "array", ...}}
+		return parseAvroSchema(def.Type, named)
+	}
+
+	s := &avroSchema{typ: typ}
+	register := func() {
+		named[def.Name] = s
+		if def.Namespace != "" {
+			named[def.Namespace+"."+def.Name] = s
+		}
+	}
+	switch typ {
+	case "record", "error":
+		s.typ = "record"
+		register() // records may refer to themselves
+		for _, f := range def.Fields {
+			fs, err := parseAvroSchema(f.Type, named)
+			if err != nil {
+				return nil, fmt.Errorf("field %s: %w", f.Name, err)
+			}
+			s.fields = append(s.fields, avroRecordField{name: f.Name, schema: fs, json: f.JSON})
+		}
+	case "enum":
+		s.symbols = def.Symbols
+		register()
+	case "fixed":
+		s.size = def.Size
+		register()
+	case "array":
+		items, err := parseAvroSchema(def.Items, named)
+		if err != nil {
+			return nil, err
+		}
+		s.items = items
+	case "map":
+		values, err := parseAvroSchema(def.Values, named)
+		if err != nil {
+			return nil, err
+		}
+		s.items = values
+	default:
+		// Primitive with attributes, e.g. a logical type
+		return parseAvroSchema(def.Type, named)
+	}
+	return s, nil
+}
+
+func (s *avroSchema) decode(r *bytes.Reader) (interface{}, error) {
+	switch s.typ {
+	case "null":
+		return nil, nil
+	case "boolean":
+		b, err := r.ReadByte()
+		return b == 1, err
+	case "int", "long":
+		n, err := binary.ReadVarint(r)
+		return float64(n), err
+	case "float":
+		var b [4]byte
+		if _, err := io.ReadFull(r, b[:]); err != nil {
+			return nil, err
+		}
+		return float64(math.Float32frombits(binary.LittleEndian.Uint32(b[:]))), nil
+	case "double":
+		var b [8]byte
+		if _, err := io.ReadFull(r, b[:]); err != nil {
+			return nil, err
+		}
+		return math.Float64frombits(binary.LittleEndian.Uint64(b[:])), nil
+	case "bytes", "string":
+		b, err := readBytes(r)
+		if err != nil {
+			return nil, err
+		}
+		if s.typ == "bytes" {
+			return b, nil
+		}
+		return string(b), nil
+	case "fixed":
+		b := make([]byte, s.size)
+		_, err := io.ReadFull(r, b)
+		return b, err
+	case "enum":
+		i, err := binary.ReadVarint(r)
+		if err != nil {
+			return nil, err
+		}
+		if i < 0 || int(i) >= len(s.symbols) {
+			return nil, fmt.Errorf("enum index %d out of range", i)
+		}
+		return s.symbols[i], nil
+	case "union":
+		i, err := binary.ReadVarint(r)
+		if err != nil {
+			return nil, err
+		}
+		if i < 0 || int(i) >= len(s.branches) {
+			return nil, fmt.Errorf("union index %d out of range", i)
+		}
+		return s.branches[i].decode(r)
+	case "record":
+		record := make(map[string]interface{}, len(s.fields))
+		for _, f := range s.fields {
+			v, err := f.schema.decode(r)
+			if err != nil {
+				return nil, fmt.Errorf("field %s: %w", f.name, err)
+			}
+			if str, ok := v.(string); ok && f.json {
+				var decoded interface{}
+				if err := json.Unmarshal([]byte(str), &decoded); err != nil {
+					return nil, fmt.Errorf("field %s: %w", f.name, err)
+				}
+				v = decoded
+			}
+			if v != nil {
+				record[f.name] = v
+			}
+		}
+		return record, nil
+	case "array":
+		var items []interface{}
+		err := readBlocks(r, func() error {
+			v, err := s.items.decode(r)
+			items = append(items, v)
+			return err
+		})
+		return items, err
+	case "map":
+		values := make(map[string]interface{})
+		err := readBlocks(r, func() error {
+			key, err := readBytes(r)
+			if err != nil {
+				return err
+			}
+			v, err := s.items.decode(r)
+			values[string(key)] = v
+			return err
+		})
+		return values, err
+	}
+	return nil, fmt.Errorf("unsupported type %s", s.typ)
+}
+
+// readBlocks reads the blocks of an array or map, calling item for each item
+func readBlocks(r *bytes.Reader, item func() error) error {
+	for {
+		count, err := binary.ReadVarint(r)
+		if err != nil {
+			return err
+		}
+		if count == 0 {
+			return nil
+		}
+		if count < 0 {
+			// A negative count is followed by the block size in bytes
+			count = -count
+			if _, err := binary.ReadVarint(r); err != nil {
+				return err
+			}
+		}
+		for i := int64(0); i < count; i++ {
+			if err := item(); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+func readBytes(r *bytes.Reader) ([]byte, error) {
+	n, err := binary.ReadVarint(r)
+	if err != nil {
+		return nil, err
+	}
+	if n < 0 || n > int64(r.Len()) {
+		return nil, fmt.Errorf("invalid length %d", n)
+	}
+	b := make([]byte, n)
+	_, err = io.ReadFull(r, b)
+	return b, err
+}
@@ -0,0 +1,326 @@
+package consumer
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/segmentio/kafka-go"
+
+	"github.com/gosight/gosight/processor/internal/config"
+)
+
+// eventSchema mirrors the schema the ingestor registers for events
+const eventSchema = `{
+  "type": "record",
+  "name": "Event",
+  "namespace": "gosight",
+  "fields": [
+    {"name": "event_id", "type": "string", "default": ""},
+    {"name": "timestamp", "type": "long", "default": 0},
+    {"name": "session_id", "type": "string", "default": ""},
+    {"name": "page", "type": ["null", "string"], "default": null, "gosight.json": true},
+    {"name": "payload", "type": ["null", "string"], "default": null, "gosight.json": true},
+    {"name": "synthetic", "type": "boolean", "default": false}
+  ]
+}`
+
+// avroWriter builds Avro binary encodings for the tests
+type avroWriter struct {
+	bytes.Buffer
+}
+
+func (w *avroWriter) long(n int64) *avroWriter {
+	w.Write(binary.AppendVarint(nil, n))
+	return w
+}
+
+func (w *avroWriter) str(s string) *avroWriter {
+	w.long(int64(len(s)))
+	w.WriteString(s)
+	return w
+}
+
+func (w *avroWriter) boolean(b bool) *avroWriter {
+	if b {
+		w.WriteByte(1)
+	} else {
+		w.WriteByte(0)
+	}
+	return w
+}
+
+func (w *avroWriter) float(f float32) *avroWriter {
+	w.Write(binary.LittleEndian.AppendUint32(nil, math.Float32bits(f)))
+	return w
+}
+
+func (w *avroWriter) double(f float64) *avroWriter {
+	w.Write(binary.LittleEndian.AppendUint64(nil, math.Float64bits(f)))
+	return w
+}
+
+// wire prefixes an encoding with the Confluent wire format header
+func wire(schemaID uint32, body []byte) []byte {
+	out := []byte{0}
+	out = binary.BigEndian.AppendUint32(out, schemaID)
+	return append(out, body...)
+}
+
+func decodeWith(t *testing.T, schema string, body []byte) (interface{}, error) {
+	t.Helper()
+	s, err := parseAvroSchema(json.RawMessage(schema), map[string]*avroSchema{})
+	if err != nil {
+		t.Fatalf("parse schema: %v", err)
+	}
+	return s.decode(bytes.NewReader(body))
+}
+
+func TestAvroDecodePrimitives(t *testing.T) {
+	tests := []struct {
+		schema string
+		body   []byte
+		want   interface{}
+	}{
+		{`"null"`, nil, nil},
+		{`"boolean"`, new(avroWriter).boolean(true).Bytes(), true},
+		{`"int"`, new(avroWriter).long(-42).Bytes(), float64(-42)},
+		{`"long"`, new(avroWriter).long(1700000000000).Bytes(), float64(1700000000000)},
+		{`"float"`, new(avroWriter).float(1.5).Bytes(), float64(1.5)},
+		{`"double"`, new(avroWriter).double(-2.25).Bytes(), float64(-2.25)},
+		{`"string"`, new(avroWriter).str("héllo").Bytes(), "héllo"},
+		{`"bytes"`, new(avroWriter).str("\x00\x01").Bytes(), []byte{0, 1}},
+		{`{"type": "long", "logicalType": "timestamp-millis"}`, new(avroWriter).long(5).Bytes(), float64(5)},
+		{`{"type": "fixed", "name": "Id", "size": 3}`, []byte("abc"), []byte("abc")},
+		{`{"type": "enum", "name": "Kind", "symbols": ["click", "scroll"]}`, new(avroWriter).long(1).Bytes(), "scroll"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.schema, func(t *testing.T) {
+			got, err := decodeWith(t, tt.schema, tt.body)
+			if err != nil {
+				t.Fatalf("decode: %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %#v, want %#v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestAvroDecodeComplexTypes(t *testing.T) {
+	schema := `{
+	  "type": "record",
+	  "name": "Node",
+	  "fields": [
+	    {"name": "tags", "type": {"type": "array", "items": "string"}},
+	    {"name": "counts", "type": {"type": "map", "values": "long"}},
+	    {"name": "next", "type": ["null", "Node"]}
+	  ]
+	}`
+
+	w := new(avroWriter)
+	// tags: one block of two items, then a negative-count block with its size
+	w.long(2).str("a").str("b")
+	w.long(-1).long(2).str("c")
+	w.long(0)
+	// counts: {"x": 1}
+	w.long(1).str("x").long(1).long(0)
+	// next: a nested Node with empty collections and no next
+	w.long(1)
+	w.long(0)
+	w.long(0)
+	w.long(0)
+
+	got, err := decodeWith(t, schema, w.Bytes())
+	if err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	want := map[string]interface{}{
+		"tags":   []interface{}{"a", "b", "c"},
+		"counts": map[string]interface{}{"x": float64(1)},
+		"next": map[string]interface{}{
+			"tags":   []interface{}(nil),
+			"counts": map[string]interface{}{},
+		},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %#v, want %#v", got, want)
+	}
+}
+
+func TestAvroDecodeErrors(t *testing.T) {
+	tests := []struct {
+		name   string
+		schema string
+		body   []byte
+	}{
+		{"truncated string", `"string"`, new(avroWriter).long(10).Bytes()[:1]},
+		{"negative length", `"string"`, new(avroWriter).long(-3).Bytes()},
+		{"truncated double", `"double"`, []byte{1, 2}},
+		{"enum out of range", `{"type": "enum", "name": "E", "symbols": ["a"]}`, new(avroWriter).long(3).Bytes()},
+		{"union out of range", `["null", "string"]`, new(avroWriter).long(2).Bytes()},
+		{"invalid json field", `{"type": "record", "name": "R", "fields": [{"name": "p", "type": "string", "gosight.json": true}]}`, new(avroWriter).str("{").Bytes()},
+		{"empty", `"long"`, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := decodeWith(t, tt.schema, tt.body); err == nil {
+				t.Error("expected an error")
+			}
+		})
+	}
+}
+
+func TestParseAvroSchemaErrors(t *testing.T) {
+	for _, schema := range []string{`"Unknown"`, `{"type": "record", "name": "R", "fields": [{"name": "f", "type": "Missing"}]}`, `{`} {
+		if _, err := parseAvroSchema(json.RawMessage(schema), map[string]*avroSchema{}); err == nil {
+			t.Errorf("parseAvroSchema(%s): expected an error", schema)
+		}
+	}
+}
+
+// fakeRegistry serves schemas by ID like a Confluent schema registry,
+// answering failures of the registry with the given status
+type fakeRegistry struct {
+	schemas  map[string]string
+	requests atomic.Int32
+	failures atomic.Int32 // requests to answer with failStatus before succeeding
+	status   int
+}
+
+func (f *fakeRegistry) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.requests.Add(1)
+	if f.failures.Add(-1) >= 0 {
+		http.Error(w, "unavailable", f.status)
+		return
+	}
+	schema, ok := f.schemas[strings.TrimPrefix(r.URL.Path, "/schemas/ids/")]
+	if !ok {
+		http.Error(w, `{"error_code": 40403, "message": "Schema not found"}`, http.StatusNotFound)
+		return
+	}
+	json.NewEncoder(w).Encode(map[string]string{"schema": schema})
+}
+
+func newFakeRegistry(t *testing.T) (*fakeRegistry, *httptest.Server) {
+	f := &fakeRegistry{schemas: map[string]string{"7": eventSchema}, status: http.StatusServiceUnavailable}
+	srv := httptest.NewServer(f)
+	t.Cleanup(srv.Close)
+	return f, srv
+}
+
+func eventMessage() []byte {
+	w := new(avroWriter)
+	w.str("evt-1").long(1700000000000).str("sess-1")
+	w.long(1).str(`{"url": "https://example.com/"}`)
+	w.long(0) // payload: null
+	w.boolean(false)
+	return wire(7, w.Bytes())
+}
+
+func TestAvroDecoderWithRegistry(t *testing.T) {
+	registry, srv := newFakeRegistry(t)
+	d := NewAvroDecoder(config.SchemaRegistryConfig{URL: srv.URL})
+
+	for i := 0; i < 3; i++ {
+		event, err := d.Decode(eventMessage())
+		if err != nil {
+			t.Fatalf("Decode: %v", err)
+		}
+		want := map[string]interface{}{
+			"event_id":   "evt-1",
+			"timestamp":  float64(1700000000000),
+			"session_id": "sess-1",
+			"page":       map[string]interface{}{"url": "https://example.com/"},
+			"synthetic":  false,
+		}
+		if !reflect.DeepEqual(event, want) {
+			t.Fatalf("got %#v, want %#v", event, want)
+		}
+	}
+
+	if n := registry.requests.Load(); n != 1 {
+		t.Errorf("registry requests = %d, want 1 (schema cached)", n)
+	}
+}
+
+func TestAvroDecoderRegistryErrors(t *testing.T) {
+	registry, srv := newFakeRegistry(t)
+	d := NewAvroDecoder(config.SchemaRegistryConfig{URL: srv.URL})
+
+	registry.failures.Store(1)
+	if _, err := d.Decode(eventMessage()); !IsRetryable(err) {
+		t.Errorf("5xx: got %v, want a retryable error", err)
+	}
+
+	if _, err := d.Decode(wire(99, nil)); err == nil || IsRetryable(err) {
+		t.Errorf("unknown schema: got %v, want a non-retryable error", err)
+	}
+
+	srv.Close()
+	if _, err := d.Decode(wire(8, nil)); !IsRetryable(err) {
+		t.Errorf("unreachable registry: got %v, want a retryable error", err)
+	}
+}
+
+func TestDecodeWithRetryWaitsForRegistry(t *testing.T) {
+	registry, srv := newFakeRegistry(t)
+	registry.failures.Store(3)
+	c := &KafkaConsumer{
+		avro:         NewAvroDecoder(config.SchemaRegistryConfig{URL: srv.URL}),
+		retryBackoff: time.Millisecond,
+		maxBackoff:   5 * time.Millisecond,
+	}
+
+	event, err := c.decodeWithRetry(context.Background(), kafka.Message{Value: eventMessage()})
+	if err != nil {
+		t.Fatalf("decodeWithRetry: %v", err)
+	}
+	if event["event_id"] != "evt-1" {
+		t.Errorf("event_id = %v, want evt-1", event["event_id"])
+	}
+	if n := registry.requests.Load(); n != 4 {
+		t.Errorf("registry requests = %d, want 4", n)
+	}
+}
+
+func TestDecodeWithRetryStopsOnContext(t *testing.T) {
+	registry, srv := newFakeRegistry(t)
+	registry.failures.Store(math.MaxInt32)
+	c := &KafkaConsumer{
+		avro:         NewAvroDecoder(config.SchemaRegistryConfig{URL: srv.URL}),
+		retryBackoff: time.Millisecond,
+		maxBackoff:   time.Millisecond,
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if _, err := c.decodeWithRetry(ctx, kafka.Message{Value: eventMessage()}); err != context.DeadlineExceeded {
+		t.Errorf("got %v, want context.DeadlineExceeded", err)
+	}
+}
+
+func TestDecodeWithRetryGivesUpOnBadMessages(t *testing.T) {
+	_, srv := newFakeRegistry(t)
+	c := &KafkaConsumer{
+		avro:         NewAvroDecoder(config.SchemaRegistryConfig{URL: srv.URL}),
+		retryBackoff: time.Hour,
+	}
+
+	for _, value := range [][]byte{[]byte("not json"), wire(7, []byte{0x7f})} {
+		if _, err := c.decodeWithRetry(context.Background(), kafka.Message{Value: value}); err == nil {
+			t.Errorf("decodeWithRetry(%q): expected an error", value)
+		}
+	}
+}
@@ -0,0 +1,137 @@
+package consumer
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/segmentio/kafka-go"
+	"github.com/segmentio/kafka-go/protocol"
+	"github.com/segmentio/kafka-go/protocol/metadata"
+	"github.com/segmentio/kafka-go/protocol/produce"
+)
+
+// dlqBroker is a single-partition broker recording the records produced to it
+type dlqBroker struct {
+	values  [][]byte
+	headers []map[string]string
+}
+
+func (b *dlqBroker) RoundTrip(ctx context.Context, addr net.Addr, req protocol.Message) (protocol.Message, error) {
+	switch req := req.(type) {
+	case *metadata.Request:
+		res := &metadata.Response{Brokers: []metadata.ResponseBroker{{NodeID: 0, Host: "fake", Port: 9092}}}
+		for _, topic := range req.TopicNames {
+			res.Topics = append(res.Topics, metadata.ResponseTopic{
+				Name:       topic,
+				Partitions: []metadata.ResponsePartition{{PartitionIndex: 0, LeaderID: 0}},
+			})
+		}
+		return res, nil
+
+	case *produce.Request:
+		res := &produce.Response{}
+		for _, topic := range req.Topics {
+			for _, partition := range topic.Partitions {
+				for {
+					r, err := partition.RecordSet.Records.ReadRecord()
+					if errors.Is(err, io.EOF) {
+						break
+					}
+					if err != nil {
+						return nil, err
+					}
+					value, err := io.ReadAll(r.Value)
+					if err != nil {
+						return nil, err
+					}
+					headers := make(map[string]string)
+					for _, h := range r.Headers {
+						headers[h.Key] = string(h.Value)
+					}
+					b.values = append(b.values, value)
+					b.headers = append(b.headers, headers)
+				}
+			}
+			res.Topics = append(res.Topics, produce.ResponseTopic{
+				Topic:      topic.Topic,
+				Partitions: []produce.ResponsePartition{{Partition: 0}},
+			})
+		}
+		return res, nil
+	}
+	return nil, io.ErrUnexpectedEOF
+}
+
+// failingProcessor fails every event, counting the attempts
+type failingProcessor struct {
+	attempts int
+}
+
+func (p *failingProcessor) Process(ctx context.Context, event map[string]interface{}) error {
+	p.attempts++
+	return errors.New("clickhouse: connection refused")
+}
+
+func (p *failingProcessor) Flush() {}
+
+func TestDeadLetterAfterRetries(t *testing.T) {
+	broker := &dlqBroker{}
+	dlq := &kafka.Writer{Addr: kafka.TCP("fake:9092"), Topic: "gosight.events.dlq", Transport: broker, BatchTimeout: time.Millisecond}
+	defer dlq.Close()
+
+	processor := &failingProcessor{}
+	msg := kafka.Message{
+		Topic:     "gosight.events.raw",
+		Partition: 0,
+		Offset:    42,
+		Value:     []byte(`{"project_id":"proj","session_id":"sess"}`),
+	}
+	reader := newFakeReader(nil)
+	c := &KafkaConsumer{
+		reader:       reader,
+		processor:    processor,
+		dlq:          dlq,
+		maxRetries:   2,
+		retryBackoff: time.Millisecond,
+		maxBackoff:   time.Millisecond,
+	}
+
+	tracker := newOffsetTracker()
+	tracker.fetched(msg)
+	if !c.handle(context.Background(), tracker, msg, map[string]interface{}{"session_id": "sess"}) {
+		t.Fatal("handle stopped")
+	}
+
+	if processor.attempts != 3 {
+		t.Errorf("processed %d times, want 3 (2 retries)", processor.attempts)
+	}
+	if reader.Committed() != 42 {
+		t.Errorf("committed offset %d, want 42", reader.Committed())
+	}
+	if len(broker.values) != 1 {
+		t.Fatalf("dead-lettered %d messages, want 1", len(broker.values))
+	}
+
+	var letter DeadLetter
+	if err := json.Unmarshal(broker.values[0], &letter); err != nil {
+		t.Fatalf("DLQ payload: %v", err)
+	}
+	if string(letter.Value) != string(msg.Value) {
+		t.Errorf("value = %s, want the original %s", letter.Value, msg.Value)
+	}
+	if letter.RetryCount != 2 || !strings.Contains(letter.Error, "connection refused") {
+		t.Errorf("retry count %d, error %q", letter.RetryCount, letter.Error)
+	}
+	if letter.Topic != msg.Topic || letter.Offset != msg.Offset {
+		t.Errorf("source = %s/%d, want %s/%d", letter.Topic, letter.Offset, msg.Topic, msg.Offset)
+	}
+	if headers := broker.headers[0]; headers["x-retries"] != "2" || headers["x-source-offset"] != "42" {
+		t.Errorf("DLQ headers = %v", headers)
+	}
+}
@@ -3,23 +3,82 @@ package consumer
 import (
 	"context"
 	"encoding/json"
+	"errors"
+	"fmt"
+	"runtime/debug"
+	"strconv"
+	"sync"
+	"time"
 
 	"github.com/rs/zerolog/log"
 	"github.com/segmentio/kafka-go"
 
 	"github.com/gosight/gosight/processor/internal/config"
+	"github.com/gosight/gosight/processor/internal/kafkaauth"
 )
 
-// MessageProcessor interface for processing messages
+// MessageProcessor interface for processing messages.
+//
+// Ordering contract: the events of a session are passed to Process one at a
+// time, in the order they were produced (the ingestor keys events by session,
+// so they share a partition, and with kafka.workers each session is handled by
+// a single worker). Stateful detectors (dead click, u-turn, thrashed cursor, ...)
+// rely on this. Events of different sessions may be processed concurrently, so
+// processors must be safe for concurrent use and must not assume any order
+// across sessions.
 type MessageProcessor interface {
 	Process(ctx context.Context, event map[string]interface{}) error
 	Flush()
 }
 
+// DeferredProcessor is a MessageProcessor that buffers events and writes them
+// later, like the archiver. The consumer does not commit a message once Process
+// returns nil; instead Process takes the message's ack from its context (see
+// Ack) and calls it once the event is written. Offsets only advance past acked
+// messages, so a failed write or a crash re-delivers the buffered events.
+type DeferredProcessor interface {
+	MessageProcessor
+	DefersCommits()
+}
+
+type ackKey struct{}
+
+// WithAck returns a context carrying the ack of the message being processed
+func WithAck(ctx context.Context, ack func()) context.Context {
+	return context.WithValue(ctx, ackKey{}, ack)
+}
+
+// Ack returns the function acknowledging the message being processed, for a
+// DeferredProcessor to call once its event is written. It is a no-op outside a
+// DeferredProcessor's Process.
+func Ack(ctx context.Context) func() {
+	if ack, ok := ctx.Value(ackKey{}).(func()); ok {
+		return ack
+	}
+	return func() {}
+}
+
+// messageReader is the part of *kafka.Reader the consumer uses
+type messageReader interface {
+	FetchMessage(ctx context.Context) (kafka.Message, error)
+	CommitMessages(ctx context.Context, msgs ...kafka.Message) error
+	Config() kafka.ReaderConfig
+	Close() error
+}
+
 // KafkaConsumer consumes messages from Kafka
 type KafkaConsumer struct {
-	reader    *kafka.Reader
-	processor MessageProcessor
+	reader     messageReader
+	processor  MessageProcessor
+	dlq        *kafka.Writer // nil when no dead-letter topic is configured
+	avro       *AvroDecoder  // nil when no schema registry is configured
+	maxRetries int           // after the first processing attempt
+	workers    int           // 1 processes messages in the fetch loop
+	deferred   bool          // processor is a DeferredProcessor
+
+	// Pause after a failed processing attempt, doubled after each one up to maxBackoff
+	retryBackoff time.Duration
+	maxBackoff   time.Duration
 }
 
 // NewKafkaConsumer creates a new Kafka consumer
@@ -37,21 +96,97 @@ func NewKafkaConsumer(cfg config.KafkaConfig, processor MessageProcessor) (*Kafk
 		MaxBytes:       10e6, // 10MB
 		CommitInterval: 1000,
 		StartOffset:    kafka.LastOffset,
+		Dialer:         kafkaauth.Dialer(cfg),
 	})
 
+	var dlq *kafka.Writer
+	if cfg.DeadLetterTopic != "" {
+		dlq = &kafka.Writer{
+			Addr:                   kafka.TCP(cfg.Brokers...),
+			Transport:              kafkaauth.Transport(cfg),
+			Topic:                  cfg.DeadLetterTopic,
+			Balancer:               &kafka.LeastBytes{},
+			BatchTimeout:           time.Millisecond * 10,
+			AllowAutoTopicCreation: true,
+		}
+	}
+
+	var avro *AvroDecoder
+	if cfg.SchemaRegistry.URL != "" {
+		avro = NewAvroDecoder(cfg.SchemaRegistry)
+	}
+
+	_, deferred := processor.(DeferredProcessor)
+
 	return &KafkaConsumer{
-		reader:    reader,
-		processor: processor,
+		reader:       reader,
+		processor:    processor,
+		dlq:          dlq,
+		avro:         avro,
+		maxRetries:   cfg.Retry.MaxRetries,
+		workers:      cfg.Workers,
+		deferred:     deferred,
+		retryBackoff: cfg.Retry.InitialBackoff,
+		maxBackoff:   cfg.Retry.MaxBackoff,
 	}, nil
 }
 
+// decode decodes a JSON or, with a schema registry configured, Avro message
+func (c *KafkaConsumer) decode(value []byte) (map[string]interface{}, error) {
+	if IsAvro(value) {
+		if c.avro == nil {
+			return nil, errors.New("Avro message but no kafka.schema_registry configured")
+		}
+		return c.avro.Decode(value)
+	}
+
+	var event map[string]interface{}
+	err := json.Unmarshal(value, &event)
+	return event, err
+}
+
+// decodeWithRetry decodes a message, retrying with backoff for as long as the
+// schema registry is unavailable: the message is fine and skipping it would
+// drop the event. It returns ctx.Err() when ctx is done meanwhile, and any
+// other error when the message itself can't be decoded.
+func (c *KafkaConsumer) decodeWithRetry(ctx context.Context, msg kafka.Message) (map[string]interface{}, error) {
+	backoff := c.retryBackoff
+	for {
+		event, err := c.decode(msg.Value)
+		if err == nil || !IsRetryable(err) {
+			return event, err
+		}
+
+		log.Warn().
+			Err(err).
+			Int("partition", msg.Partition).
+			Int64("offset", msg.Offset).
+			Dur("backoff", backoff).
+			Msg("Schema registry unavailable, retrying")
+
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(backoff):
+		}
+		backoff = min(backoff*2, c.maxBackoff)
+	}
+}
+
 // Start begins consuming messages
 func (c *KafkaConsumer) Start(ctx context.Context) {
 	log.Info().
 		Str("topic", c.reader.Config().Topic).
 		Str("group", c.reader.Config().GroupID).
+		Int("workers", c.workers).
 		Msg("Starting Kafka consumer")
 
+	if c.workers > 1 {
+		c.startWorkers(ctx)
+		return
+	}
+
+	tracker := newOffsetTracker()
 	for {
 		select {
 		case <-ctx.Done():
@@ -66,34 +201,181 @@ func (c *KafkaConsumer) Start(ctx context.Context) {
 				log.Error().Err(err).Msg("Failed to fetch message")
 				continue
 			}
+			tracker.fetched(msg)
 
 			// Parse message
-			var event map[string]interface{}
-			if err := json.Unmarshal(msg.Value, &event); err != nil {
-				log.Error().
-					Err(err).
-					Str("value", string(msg.Value)).
-					Msg("Failed to parse message")
-				// Still commit to avoid getting stuck
-				if err := c.reader.CommitMessages(ctx, msg); err != nil {
-					log.Error().Err(err).Msg("Failed to commit message")
+			event, err := c.decodeWithRetry(ctx, msg)
+			if err != nil {
+				if ctx.Err() != nil {
+					return
 				}
+				c.logUnparsable(msg, err)
+				c.deadLetter(ctx, msg, err, 0)
+				// Still commit to avoid getting stuck
+				c.ack(tracker, msg)
 				continue
 			}
 
-			// Process event
-			if err := c.processor.Process(ctx, event); err != nil {
-				log.Error().
-					Err(err).
-					Interface("event", event).
-					Msg("Failed to process event")
+			if !c.handle(ctx, tracker, msg, event) {
+				return
 			}
+		}
+	}
+}
 
-			// Commit
-			if err := c.reader.CommitMessages(ctx, msg); err != nil {
-				log.Error().Err(err).Msg("Failed to commit message")
-			}
+// handle processes an event, retrying before giving up on it and sending it to
+// the DLQ, and acks the message once done (a DeferredProcessor that took the
+// event acks it itself once written). It returns false, leaving the message
+// unacked, when ctx is done.
+func (c *KafkaConsumer) handle(ctx context.Context, tracker *offsetTracker, msg kafka.Message, event map[string]interface{}) bool {
+	ack := sync.OnceFunc(func() { c.ack(tracker, msg) })
+	if c.deferred {
+		ctx = WithAck(ctx, ack)
+	}
+
+	if retries, err := c.processWithRetry(ctx, msg, event); err != nil {
+		if ctx.Err() != nil {
+			return false
 		}
+		log.Error().
+			Err(err).
+			Int("partition", msg.Partition).
+			Int64("offset", msg.Offset).
+			Interface("event", event).
+			Msg("Failed to process event, giving up")
+		c.deadLetter(ctx, msg, err, retries)
+		ack()
+		return true
+	}
+
+	if !c.deferred {
+		ack()
+	}
+	return true
+}
+
+// ack marks a message done and commits its partition's offset if it advanced.
+// A DeferredProcessor may ack after the consumer's context is done, so the
+// commit does not use it.
+func (c *KafkaConsumer) ack(tracker *offsetTracker, msg kafka.Message) {
+	if commit, ok := tracker.done(msg); ok {
+		c.commit(context.Background(), commit)
+	}
+}
+
+func (c *KafkaConsumer) logUnparsable(msg kafka.Message, err error) {
+	log.Error().
+		Err(err).
+		Str("value", string(msg.Value)).
+		Msg("Failed to parse message")
+}
+
+func (c *KafkaConsumer) commit(ctx context.Context, msg kafka.Message) {
+	if err := c.reader.CommitMessages(ctx, msg); err != nil {
+		log.Error().Err(err).Msg("Failed to commit message")
+	}
+}
+
+// processWithRetry processes a message, retrying up to maxRetries times with
+// exponential backoff and treating a panic in the processor as a failed
+// attempt. It returns the retries made. The session's later events wait
+// meanwhile, keeping their order.
+func (c *KafkaConsumer) processWithRetry(ctx context.Context, msg kafka.Message, event map[string]interface{}) (int, error) {
+	backoff := c.retryBackoff
+	for retries := 0; ; retries++ {
+		err := c.safeProcess(ctx, event)
+		if err == nil {
+			return retries, nil
+		}
+		if retries == c.maxRetries {
+			return retries, fmt.Errorf("after %d retries: %w", retries, err)
+		}
+
+		log.Warn().
+			Err(err).
+			Int("partition", msg.Partition).
+			Int64("offset", msg.Offset).
+			Int("attempt", retries+1).
+			Dur("backoff", backoff).
+			Msg("Failed to process event, retrying")
+
+		select {
+		case <-ctx.Done():
+			return retries, ctx.Err()
+		case <-time.After(backoff):
+		}
+		backoff = min(backoff*2, c.maxBackoff)
+	}
+}
+
+// safeProcess calls the processor, recovering a panic into an error
+func (c *KafkaConsumer) safeProcess(ctx context.Context, event map[string]interface{}) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			log.Error().
+				Interface("panic", r).
+				Str("stack", string(debug.Stack())).
+				Msg("Panic while processing event")
+			err = fmt.Errorf("panic: %v", r)
+		}
+	}()
+
+	return c.processor.Process(ctx, event)
+}
+
+// DeadLetter is the payload of a message written to the dead-letter topic
+type DeadLetter struct {
+	Value      []byte    `json:"value"` // the original message value, base64 encoded
+	Error      string    `json:"error"`
+	RetryCount int       `json:"retry_count"` // 0 for messages that could not be parsed
+	Topic      string    `json:"topic"`
+	Partition  int       `json:"partition"`
+	Offset     int64     `json:"offset"`
+	FailedAt   time.Time `json:"failed_at"`
+}
+
+// deadLetter writes a message that could not be processed to the dead-letter
+// topic, keyed and with headers like the original, plus headers describing
+// where it came from and why it failed
+func (c *KafkaConsumer) deadLetter(ctx context.Context, msg kafka.Message, cause error, retries int) {
+	if c.dlq == nil {
+		return
+	}
+
+	value, err := json.Marshal(DeadLetter{
+		Value:      msg.Value,
+		Error:      cause.Error(),
+		RetryCount: retries,
+		Topic:      msg.Topic,
+		Partition:  msg.Partition,
+		Offset:     msg.Offset,
+		FailedAt:   time.Now(),
+	})
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to encode DLQ message")
+		return
+	}
+
+	headers := append([]kafka.Header{}, msg.Headers...)
+	headers = append(headers,
+		kafka.Header{Key: "x-source-topic", Value: []byte(msg.Topic)},
+		kafka.Header{Key: "x-source-partition", Value: []byte(strconv.Itoa(msg.Partition))},
+		kafka.Header{Key: "x-source-offset", Value: []byte(strconv.FormatInt(msg.Offset, 10))},
+		kafka.Header{Key: "x-retries", Value: []byte(strconv.Itoa(retries))},
+		kafka.Header{Key: "x-error", Value: []byte(cause.Error())},
+	)
+
+	err = c.dlq.WriteMessages(ctx, kafka.Message{
+		Key:     msg.Key,
+		Value:   value,
+		Headers: headers,
+	})
+	if err != nil {
+		log.Error().
+			Err(err).
+			Int("partition", msg.Partition).
+			Int64("offset", msg.Offset).
+			Msg("Failed to write message to DLQ")
 	}
 }
 
@@ -102,5 +384,8 @@ func (c *KafkaConsumer) Close() error {
 	log.Info().Msg("Closing Kafka consumer")
 	// Flush remaining events before closing
 	c.processor.Flush()
+	if c.dlq != nil {
+		c.dlq.Close()
+	}
 	return c.reader.Close()
 }
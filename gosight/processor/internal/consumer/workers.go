@@ -0,0 +1,142 @@
+package consumer
+
+import (
+	"context"
+	"hash/fnv"
+	"sync"
+
+	"github.com/rs/zerolog/log"
+	"github.com/segmentio/kafka-go"
+)
+
+// workerQueueSize bounds the messages waiting for each worker; a full queue
+// blocks fetching
+const workerQueueSize = 100
+
+type job struct {
+	msg   kafka.Message
+	event map[string]interface{}
+}
+
+// startWorkers processes messages on c.workers goroutines until ctx is done.
+// Each session's events go to the same worker (see workerFor), which keeps them
+// in order. Offsets are committed only once every earlier message of the
+// partition is acked, so a restart never skips an unprocessed message.
+func (c *KafkaConsumer) startWorkers(ctx context.Context) {
+	tracker := newOffsetTracker()
+	queues := make([]chan job, c.workers)
+
+	var wg sync.WaitGroup
+	for i := range queues {
+		queues[i] = make(chan job, workerQueueSize)
+		wg.Add(1)
+		go func(queue <-chan job) {
+			defer wg.Done()
+			for j := range queue {
+				c.handle(ctx, tracker, j.msg, j.event)
+			}
+		}(queues[i])
+	}
+
+	defer func() {
+		for _, queue := range queues {
+			close(queue)
+		}
+		wg.Wait()
+	}()
+
+	for {
+		msg, err := c.reader.FetchMessage(ctx)
+		if err != nil {
+			if ctx.Err() != nil {
+				return
+			}
+			log.Error().Err(err).Msg("Failed to fetch message")
+			continue
+		}
+		tracker.fetched(msg)
+
+		event, err := c.decodeWithRetry(ctx, msg)
+		if err != nil {
+			if ctx.Err() != nil {
+				return
+			}
+			c.logUnparsable(msg, err)
+			c.deadLetter(ctx, msg, err, 0)
+			c.ack(tracker, msg)
+			continue
+		}
+
+		select {
+		case queues[workerFor(msg, event, c.workers)] <- job{msg: msg, event: event}:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// workerFor picks the worker of the event's session. Events without a session
+// (e.g. aggregate metrics) are spread by message key.
+func workerFor(msg kafka.Message, event map[string]interface{}, workers int) int {
+	h := fnv.New32a()
+	if sessionID, _ := event["session_id"].(string); sessionID != "" {
+		projectID, _ := event["project_id"].(string)
+		h.Write([]byte(projectID + ":" + sessionID))
+	} else {
+		h.Write(msg.Key)
+	}
+	return int(h.Sum32() % uint32(workers))
+}
+
+// offsetTracker tracks the messages of each partition being processed, to
+// commit the highest offset below which every message is done
+type offsetTracker struct {
+	partitions map[int]*partitionOffsets
+	mu         sync.Mutex
+}
+
+type partitionOffsets struct {
+	pending []kafka.Message // fetched and not yet committable, in offset order
+	done    map[int64]bool
+}
+
+func newOffsetTracker() *offsetTracker {
+	return &offsetTracker{partitions: make(map[int]*partitionOffsets)}
+}
+
+// fetched records a message handed to a worker; messages of a partition are
+// fetched in offset order
+func (t *offsetTracker) fetched(msg kafka.Message) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	p, ok := t.partitions[msg.Partition]
+	if !ok {
+		p = &partitionOffsets{done: make(map[int64]bool)}
+		t.partitions[msg.Partition] = p
+	}
+	p.pending = append(p.pending, msg)
+}
+
+// done marks a message processed and returns the message to commit, if the
+// partition's committable offset advanced
+func (t *offsetTracker) done(msg kafka.Message) (kafka.Message, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	p, ok := t.partitions[msg.Partition]
+	if !ok {
+		return kafka.Message{}, false
+	}
+	p.done[msg.Offset] = true
+
+	var commit kafka.Message
+	advanced := false
+	for len(p.pending) > 0 && p.done[p.pending[0].Offset] {
+		commit = p.pending[0]
+		delete(p.done, commit.Offset)
+		p.pending = p.pending[1:]
+		advanced = true
+	}
+	return commit, advanced
+}
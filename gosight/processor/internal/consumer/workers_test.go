@@ -0,0 +1,246 @@
+package consumer
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/segmentio/kafka-go"
+)
+
+// fakeReader serves messages of a single partition in offset order, then
+// blocks until ctx is done. check runs on every commit.
+type fakeReader struct {
+	mu        sync.Mutex
+	messages  []kafka.Message
+	next      int
+	committed int64 // highest committed offset, -1 for none
+	check     func(commit kafka.Message)
+}
+
+func newFakeReader(messages []kafka.Message) *fakeReader {
+	return &fakeReader{messages: messages, committed: -1}
+}
+
+func (r *fakeReader) FetchMessage(ctx context.Context) (kafka.Message, error) {
+	r.mu.Lock()
+	if r.next < len(r.messages) {
+		msg := r.messages[r.next]
+		r.next++
+		r.mu.Unlock()
+		return msg, nil
+	}
+	r.mu.Unlock()
+	<-ctx.Done()
+	return kafka.Message{}, ctx.Err()
+}
+
+func (r *fakeReader) CommitMessages(ctx context.Context, msgs ...kafka.Message) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, msg := range msgs {
+		if r.check != nil {
+			r.check(msg)
+		}
+		r.committed = max(r.committed, msg.Offset)
+	}
+	return nil
+}
+
+func (r *fakeReader) Committed() int64 {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.committed
+}
+
+func (r *fakeReader) Config() kafka.ReaderConfig { return kafka.ReaderConfig{} }
+func (r *fakeReader) Close() error               { return nil }
+
+// recordingProcessor records the order of each session's events and the
+// processed offsets, after an optional per-event hook
+type recordingProcessor struct {
+	mu        sync.Mutex
+	sessions  map[string][]int
+	processed map[int64]bool
+	before    func(event map[string]interface{})
+}
+
+func newRecordingProcessor() *recordingProcessor {
+	return &recordingProcessor{sessions: make(map[string][]int), processed: make(map[int64]bool)}
+}
+
+func (p *recordingProcessor) Process(ctx context.Context, event map[string]interface{}) error {
+	if p.before != nil {
+		p.before(event)
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	sessionID := event["session_id"].(string)
+	p.sessions[sessionID] = append(p.sessions[sessionID], int(event["seq"].(float64)))
+	p.processed[int64(event["offset"].(float64))] = true
+	return nil
+}
+
+func (p *recordingProcessor) Flush() {}
+
+// sessionMessages returns n messages of one partition, spread round-robin over
+// sessions; each event carries its offset and its sequence number in its session
+func sessionMessages(n, sessions int) []kafka.Message {
+	messages := make([]kafka.Message, n)
+	seq := make([]int, sessions)
+	for i := range messages {
+		s := i % sessions
+		messages[i] = kafka.Message{
+			Partition: 0,
+			Offset:    int64(i),
+			Value:     []byte(fmt.Sprintf(`{"project_id":"proj","session_id":"sess-%d","seq":%d,"offset":%d}`, s, seq[s], i)),
+		}
+		seq[s]++
+	}
+	return messages
+}
+
+// runWorkers runs startWorkers until the reader committed offset last, failing
+// the test after timeout
+func runWorkers(t *testing.T, c *KafkaConsumer, reader *fakeReader, last int64) {
+	t.Helper()
+	ctx, cancel := context.WithCancel(context.Background())
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		c.startWorkers(ctx)
+	}()
+	defer func() {
+		cancel()
+		<-stopped
+	}()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for reader.Committed() < last {
+		if time.Now().After(deadline) {
+			t.Fatalf("committed offset %d, want %d", reader.Committed(), last)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func newTestConsumer(reader messageReader, processor MessageProcessor, workers int) *KafkaConsumer {
+	return &KafkaConsumer{reader: reader, processor: processor, workers: workers}
+}
+
+func TestWorkersKeepSessionOrder(t *testing.T) {
+	const n, sessions = 500, 17
+	reader := newFakeReader(sessionMessages(n, sessions))
+	processor := newRecordingProcessor()
+	processor.before = func(map[string]interface{}) {
+		// Let workers overtake each other
+		time.Sleep(time.Duration(rand.Intn(200)) * time.Microsecond)
+	}
+
+	runWorkers(t, newTestConsumer(reader, processor, 4), reader, n-1)
+
+	processor.mu.Lock()
+	defer processor.mu.Unlock()
+	if len(processor.sessions) != sessions {
+		t.Fatalf("processed %d sessions, want %d", len(processor.sessions), sessions)
+	}
+	for sessionID, seqs := range processor.sessions {
+		for i, seq := range seqs {
+			if seq != i {
+				t.Fatalf("%s processed in order %v", sessionID, seqs)
+			}
+		}
+	}
+}
+
+func TestWorkersNeverCommitPastUnprocessedOffset(t *testing.T) {
+	const n, sessions, slow = 300, 7, 40
+	reader := newFakeReader(sessionMessages(n, sessions))
+	processor := newRecordingProcessor()
+	release := make(chan struct{})
+	processor.before = func(event map[string]interface{}) {
+		if int64(event["offset"].(float64)) == slow {
+			<-release
+		}
+	}
+
+	// Every commit must come after all the offsets it covers were processed;
+	// violations is guarded by reader.mu
+	var violations []string
+	reader.check = func(commit kafka.Message) {
+		processor.mu.Lock()
+		defer processor.mu.Unlock()
+		for offset := int64(0); offset <= commit.Offset; offset++ {
+			if !processor.processed[offset] {
+				violations = append(violations, fmt.Sprintf("commit %d before offset %d was processed", commit.Offset, offset))
+				return
+			}
+		}
+	}
+
+	go func() {
+		// Hold the slow message while the other sessions get ahead
+		deadline := time.Now().Add(5 * time.Second)
+		for time.Now().Before(deadline) {
+			processor.mu.Lock()
+			ahead := len(processor.processed)
+			processor.mu.Unlock()
+			if ahead >= n/2 {
+				break
+			}
+			time.Sleep(time.Millisecond)
+		}
+		reader.mu.Lock()
+		if reader.committed >= slow {
+			violations = append(violations, fmt.Sprintf("committed %d while offset %d was held", reader.committed, slow))
+		}
+		reader.mu.Unlock()
+		close(release)
+	}()
+
+	runWorkers(t, newTestConsumer(reader, processor, 4), reader, n-1)
+
+	reader.mu.Lock()
+	defer reader.mu.Unlock()
+	for _, v := range violations {
+		t.Error(v)
+	}
+}
+
+func TestOffsetTrackerCommitsContiguousDoneOffsets(t *testing.T) {
+	tracker := newOffsetTracker()
+	msg := func(partition int, offset int64) kafka.Message {
+		return kafka.Message{Partition: partition, Offset: offset}
+	}
+	for offset := int64(0); offset < 4; offset++ {
+		tracker.fetched(msg(0, offset))
+		tracker.fetched(msg(1, offset))
+	}
+
+	steps := []struct {
+		done       kafka.Message
+		wantCommit int64 // -1 for no commit
+	}{
+		{msg(0, 2), -1},
+		{msg(0, 0), 0},
+		{msg(1, 0), 0}, // partitions advance on their own
+		{msg(0, 1), 2},
+		{msg(0, 3), 3},
+		{msg(1, 3), -1},
+	}
+	for _, step := range steps {
+		commit, ok := tracker.done(step.done)
+		if step.wantCommit < 0 {
+			if ok {
+				t.Errorf("done(%d/%d) committed %d, want no commit", step.done.Partition, step.done.Offset, commit.Offset)
+			}
+			continue
+		}
+		if !ok || commit.Offset != step.wantCommit || commit.Partition != step.done.Partition {
+			t.Errorf("done(%d/%d) = %d/%d, %v, want commit %d", step.done.Partition, step.done.Offset, commit.Partition, commit.Offset, ok, step.wantCommit)
+		}
+	}
+}
@@ -0,0 +1,79 @@
+// Package eventtype maps the event type strings sent by clients to one
+// canonical type. The JS SDK sends simple names ("click") while gRPC clients
+// send proto enum names ("EVENT_TYPE_CLICK"); both normalize to the same Type.
+package eventtype
+
+import "strings"
+
+// Type is a canonical event type (the simple, lowercase name)
+type Type string
+
+// Event types. The first group mirrors the proto EventType enum; the rest are
+// only sent as simple names.
+const (
+	Unknown Type = ""
+
+	PageView         Type = "page_view"
+	Click            Type = "click"
+	Scroll           Type = "scroll"
+	InputChange      Type = "input_change"
+	InputFocus       Type = "input_focus"
+	InputBlur        Type = "input_blur"
+	MouseMove        Type = "mouse_move"
+	VisibilityChange Type = "visibility_change"
+	JSError          Type = "js_error"
+	NetworkError     Type = "network_error"
+	ConsoleLog       Type = "console_log"
+	WebVitals        Type = "web_vitals"
+	PageLoad         Type = "page_load"
+	ResourceLoad     Type = "resource_load"
+	Custom           Type = "custom"
+
+	FormSubmit  Type = "form_submit"
+	FormError   Type = "form_error"
+	DOMMutation Type = "dom_mutation"
+	PageHidden  Type = "page_hidden"
+	PageVisible Type = "page_visible"
+	PageExit    Type = "page_exit"
+	LongTask    Type = "long_task" // main thread blocked over 50ms (PerformanceLongTaskTiming)
+	MediaPlay   Type = "media_play"
+	MediaPause  Type = "media_pause"
+	MediaEnded  Type = "media_ended"
+	Aggregate   Type = "aggregate" // pre-aggregated metric pushed by a server-side integration
+)
+
+// protoPrefix prefixes proto enum names of event types
+const protoPrefix = "EVENT_TYPE_"
+
+var known = map[Type]bool{
+	PageView: true, Click: true, Scroll: true, InputChange: true, InputFocus: true,
+	InputBlur: true, MouseMove: true, VisibilityChange: true, JSError: true,
+	NetworkError: true, ConsoleLog: true, WebVitals: true, PageLoad: true,
+	ResourceLoad: true, Custom: true,
+	FormSubmit: true, FormError: true, DOMMutation: true, PageHidden: true,
+	PageVisible: true, PageExit: true, LongTask: true, Aggregate: true,
+	MediaPlay: true, MediaPause: true, MediaEnded: true,
+}
+
+// Normalize returns the canonical type of a simple or proto enum event type
+// name, or Unknown if it is not a known type
+func Normalize(s string) Type {
+	t := Type(strings.ToLower(strings.TrimPrefix(s, protoPrefix)))
+	if !known[t] {
+		return Unknown
+	}
+	return t
+}
+
+// Resolve is Normalize, except that custom events named after a known type
+// (e.g. a custom "web_vitals" event) resolve to that type
+func Resolve(s, customName string) Type {
+	t := Normalize(s)
+	if t != Custom {
+		return t
+	}
+	if named := Normalize(customName); named != Unknown {
+		return named
+	}
+	return Custom
+}
@@ -0,0 +1,80 @@
+package insights
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/segmentio/kafka-go"
+	"github.com/segmentio/kafka-go/protocol"
+	"github.com/segmentio/kafka-go/protocol/metadata"
+	"github.com/segmentio/kafka-go/protocol/produce"
+)
+
+// alertBroker is a single-partition broker recording the headers of the
+// records produced to it
+type alertBroker struct {
+	headers []map[string]string
+}
+
+func (b *alertBroker) RoundTrip(ctx context.Context, addr net.Addr, req protocol.Message) (protocol.Message, error) {
+	switch req := req.(type) {
+	case *metadata.Request:
+		res := &metadata.Response{Brokers: []metadata.ResponseBroker{{NodeID: 0, Host: "fake", Port: 9092}}}
+		for _, topic := range req.TopicNames {
+			res.Topics = append(res.Topics, metadata.ResponseTopic{
+				Name:       topic,
+				Partitions: []metadata.ResponsePartition{{PartitionIndex: 0, LeaderID: 0}},
+			})
+		}
+		return res, nil
+
+	case *produce.Request:
+		res := &produce.Response{}
+		for _, topic := range req.Topics {
+			for _, partition := range topic.Partitions {
+				for {
+					r, err := partition.RecordSet.Records.ReadRecord()
+					if errors.Is(err, io.EOF) {
+						break
+					}
+					if err != nil {
+						return nil, err
+					}
+					headers := make(map[string]string)
+					for _, h := range r.Headers {
+						headers[h.Key] = string(h.Value)
+					}
+					b.headers = append(b.headers, headers)
+				}
+			}
+			res.Topics = append(res.Topics, produce.ResponseTopic{
+				Topic:      topic.Topic,
+				Partitions: []produce.ResponsePartition{{Partition: 0}},
+			})
+		}
+		return res, nil
+	}
+	return nil, io.ErrUnexpectedEOF
+}
+
+func TestPublishAlertSetsRoutingHeaders(t *testing.T) {
+	broker := &alertBroker{}
+	w := &kafka.Writer{Addr: kafka.TCP("fake:9092"), Topic: "gosight.alerts", Transport: broker, BatchTimeout: time.Millisecond}
+	defer w.Close()
+
+	p := &Processor{}
+	p.publishAlert(context.Background(), w, &Insight{Type: "rage_click", ProjectID: "proj", SessionID: "sess"}, uuid.New())
+
+	if len(broker.headers) != 1 {
+		t.Fatalf("published %d alerts, want 1", len(broker.headers))
+	}
+	headers := broker.headers[0]
+	if headers["project_id"] != "proj" || headers["event_type"] != "rage_click" || headers["schema_version"] != alertSchemaVersion {
+		t.Errorf("alert headers = %v", headers)
+	}
+}
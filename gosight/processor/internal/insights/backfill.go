@@ -0,0 +1,38 @@
+package insights
+
+import (
+	"context"
+
+	"github.com/gosight/gosight/processor/internal/storage"
+)
+
+// SetBackfill marks the processor as replaying stored events: insights detected
+// on an event are timestamped at that event instead of at processing time
+func (p *Processor) SetBackfill(enabled bool) {
+	p.backfill = enabled
+}
+
+// ProcessRow feeds a stored event through the detectors like an event from
+// Kafka. Stateful detectors need each session's events in timestamp order.
+func (p *Processor) ProcessRow(ctx context.Context, row storage.EventRow) error {
+	raw := map[string]interface{}{
+		"event_id":   row.EventID,
+		"type":       row.EventType,
+		"project_id": row.ProjectID,
+		"session_id": row.SessionID,
+		"user_id":    row.UserID,
+		"timestamp":  float64(row.Timestamp.UnixMilli()),
+		"page": map[string]interface{}{
+			"url":      row.PageURL,
+			"path":     row.PagePath,
+			"title":    row.PageTitle,
+			"referrer": row.Referrer,
+		},
+		"synthetic": row.IsSynthetic == 1,
+	}
+	if row.PayloadData != nil {
+		raw["payload"] = row.PayloadData
+	}
+
+	return p.Process(ctx, raw)
+}
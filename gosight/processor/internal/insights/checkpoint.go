@@ -0,0 +1,158 @@
+package insights
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	"github.com/rs/zerolog/log"
+)
+
+// stateKeyPrefix is followed by the instance and detector name
+const stateKeyPrefix = "insights:state:"
+
+// statefulDetector is a detector whose in-memory state is checkpointed to
+// Redis (see config.InsightCheckpointConfig); each has its own format
+type statefulDetector interface {
+	SaveState(cutoff time.Time) ([]byte, error)
+	RestoreState(data []byte) error
+}
+
+// statefulDetectors returns the enabled detectors with checkpointed state by name
+func (p *Processor) statefulDetectors() map[string]statefulDetector {
+	detectors := make(map[string]statefulDetector)
+	if p.uTurn != nil || p.reloadLoop != nil || p.errorPage != nil || p.pogostick != nil || p.funnel != nil {
+		detectors["page_history"] = p.pageTracker
+	}
+	if p.thrashedCursor != nil {
+		detectors["thrashed_cursor"] = p.thrashedCursor
+	}
+	if p.errorClick != nil {
+		detectors["error_click"] = p.errorClick
+	}
+	if p.deadClick != nil {
+		detectors["dead_click"] = p.deadClick
+	}
+	if p.rageScroll != nil {
+		detectors["rage_scroll"] = p.rageScroll
+	}
+	if p.slowPage != nil {
+		detectors["slow_page"] = p.slowPage
+	}
+	if p.slowAbandon != nil {
+		detectors["slow_page_abandonment"] = p.slowAbandon
+	}
+	if p.pogostick != nil {
+		detectors["pogostick"] = p.pogostick
+	}
+	if p.formRetry != nil {
+		detectors["form_retry"] = p.formRetry
+	}
+	if p.longTask != nil {
+		detectors["long_task"] = p.longTask
+	}
+	if p.mediaAbandon != nil {
+		detectors["media_abandonment"] = p.mediaAbandon
+	}
+	if p.missingVitals != nil {
+		detectors["missing_vitals"] = p.missingVitals
+	}
+	if p.funnel != nil {
+		detectors["funnel_abandonment"] = p.funnel
+	}
+	if p.funnelDrop != nil {
+		detectors["funnel_drop"] = p.funnelDrop
+	}
+	return detectors
+}
+
+// processingTimeState is the checkpoint of a detector whose windows are
+// measured in processing time
+type processingTimeState[T any] struct {
+	SavedAt time.Time `json:"saved_at"`
+	State   T         `json:"state"`
+}
+
+// saveProcessingTimeState returns the state as JSON, stamped with the time it
+// was saved
+func saveProcessingTimeState[T any](state T) ([]byte, error) {
+	return json.Marshal(processingTimeState[T]{SavedAt: time.Now(), State: state})
+}
+
+// restoreProcessingTimeState decodes a checkpoint of saveProcessingTimeState
+// into state and returns the time since it was saved. Restored windows resume
+// where they were saved by adding that downtime to their start: the events
+// that would have closed them weren't processed meanwhile.
+func restoreProcessingTimeState[T any](data []byte, state *T) (time.Duration, error) {
+	var saved processingTimeState[T]
+	if err := json.Unmarshal(data, &saved); err != nil {
+		return 0, err
+	}
+	*state = saved.State
+	return max(time.Since(saved.SavedAt), 0), nil
+}
+
+func (p *Processor) stateKey(detector string) string {
+	return stateKeyPrefix + p.checkpoint.Instance + ":" + detector
+}
+
+// SaveState checkpoints the state of stateful detectors to Redis; a no-op
+// unless insights.state_checkpoint is enabled
+func (p *Processor) SaveState(ctx context.Context) error {
+	if p.checkpoint == nil {
+		return nil
+	}
+
+	cutoff := time.Now().Add(-p.checkpoint.MaxAge)
+	pipe := p.redis.Pipeline()
+	for name, detector := range p.statefulDetectors() {
+		data, err := detector.SaveState(cutoff)
+		if err != nil {
+			return fmt.Errorf("%s state: %w", name, err)
+		}
+		pipe.Set(ctx, p.stateKey(name), data, p.checkpoint.MaxAge)
+	}
+	_, err := pipe.Exec(ctx)
+	return err
+}
+
+// restoreState restores the detector state checkpointed by this instance's
+// previous run. An invalid checkpoint is skipped, leaving its detector empty.
+func (p *Processor) restoreState(ctx context.Context) error {
+	for name, detector := range p.statefulDetectors() {
+		data, err := p.redis.Get(ctx, p.stateKey(name)).Bytes()
+		if errors.Is(err, redis.Nil) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		if err := detector.RestoreState(data); err != nil {
+			log.Warn().Err(err).Str("detector", name).Msg("Skipping invalid detector checkpoint")
+			continue
+		}
+		log.Info().Str("detector", name).Int("bytes", len(data)).Msg("Restored detector state")
+	}
+	return nil
+}
+
+// checkpointLoop checkpoints detector state every interval until Stop
+func (p *Processor) checkpointLoop() {
+	defer close(p.checkpointDone)
+	ticker := time.NewTicker(p.checkpoint.Interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-p.checkpointStop:
+			return
+		case <-ticker.C:
+			if err := p.SaveState(context.Background()); err != nil {
+				log.Error().Err(err).Msg("Failed to checkpoint detector state")
+			}
+		}
+	}
+}
@@ -0,0 +1,365 @@
+package insights
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+
+	"github.com/gosight/gosight/processor/internal/config"
+	"github.com/gosight/gosight/processor/internal/eventtype"
+)
+
+// checkpoint saves the detector's state and restores it into restored, as a
+// restart of the processor would
+func checkpoint(t *testing.T, saved, restored statefulDetector) {
+	t.Helper()
+	data, err := saved.SaveState(time.Now().Add(-time.Hour))
+	if err != nil {
+		t.Fatalf("SaveState: %v", err)
+	}
+	if err := restored.RestoreState(data); err != nil {
+		t.Fatalf("RestoreState: %v", err)
+	}
+}
+
+// sessionEvent is an event of session "sess" on /page, at offsetMs from now
+func sessionEvent(eventID string, offsetMs int64) *Event {
+	return &Event{
+		EventID:   eventID,
+		ProjectID: "proj",
+		SessionID: "sess",
+		URL:       "https://example.com/page",
+		Path:      "/page",
+		Timestamp: time.Now().UnixMilli() + offsetMs,
+	}
+}
+
+func TestCheckpointRageScroll(t *testing.T) {
+	cfg := config.RageScrollConfig{Enabled: true, MinDirectionChanges: 4, MinVelocity: 100, WindowMs: 10_000}
+	scroll := func(d *RageScrollDetector, i int) *Insight {
+		event := sessionEvent("e", int64(i)*100)
+		event.ScrollTop = (i % 2) * 1000
+		return d.ProcessScroll(event)
+	}
+
+	before := NewRageScrollDetector(cfg)
+	for i := 0; i < 3; i++ {
+		scroll(before, i)
+	}
+	after := NewRageScrollDetector(cfg)
+	checkpoint(t, before, after)
+
+	var insight *Insight
+	for i := 3; i < 6 && insight == nil; i++ {
+		insight = scroll(after, i)
+	}
+	if insight == nil {
+		t.Fatal("expected a rage_scroll insight from the restored reversals")
+	}
+	if n := insight.Details["direction_changes"]; n != 4 {
+		t.Errorf("direction_changes = %v, want 4", n)
+	}
+}
+
+func TestCheckpointLongTask(t *testing.T) {
+	cfg := config.LongTaskConfig{Enabled: true, TotalBlockingTimeThresholdMs: 300, WindowMs: 10_000}
+	task := func(d *LongTaskDetector, i int) *Insight {
+		event := sessionEvent("e", int64(i)*100)
+		event.LongTaskDurationMs = 200
+		return d.ProcessLongTask(event)
+	}
+
+	before := NewLongTaskDetector(cfg)
+	if task(before, 0) != nil {
+		t.Fatal("unexpected insight from a single long task")
+	}
+	after := NewLongTaskDetector(cfg)
+	checkpoint(t, before, after)
+
+	insight := task(after, 1)
+	if insight == nil {
+		t.Fatal("expected a long_task insight from the restored task")
+	}
+	if n := insight.Details["task_count"]; n != 2 {
+		t.Errorf("task_count = %v, want 2", n)
+	}
+}
+
+func TestCheckpointSlowPage(t *testing.T) {
+	before := newTestSlowPageDetector()
+	before.ProcessPerformance(slowLoad("e1", "/checkout", 3000))
+	after := newTestSlowPageDetector()
+	checkpoint(t, before, after)
+
+	if insights := after.Expire(time.Now()); len(insights) != 0 {
+		t.Fatalf("got %d insights before the restored window closed", len(insights))
+	}
+	after.ProcessPerformance(slowLoad("e2", "/checkout", 4000))
+	insights := after.Expire(time.Now().Add(2 * time.Minute))
+	if len(insights) != 1 {
+		t.Fatalf("got %d insights, want 1", len(insights))
+	}
+	if n := insights[0].Details["slow_count"]; n != 2 {
+		t.Errorf("slow_count = %v, want 2", n)
+	}
+}
+
+func TestCheckpointResumesProcessingTimeWindows(t *testing.T) {
+	// A window opened 30s before a checkpoint saved 10 minutes ago has 30s
+	// of its minute left
+	savedAt := time.Now().Add(-10 * time.Minute)
+	data, err := json.Marshal(processingTimeState[map[string]*SlowPageWindow]{
+		SavedAt: savedAt,
+		State: map[string]*SlowPageWindow{
+			"proj:/checkout": {
+				Slowest:   &Insight{Type: "slow_page", Details: map[string]interface{}{"load_time_ms": 3000.0}},
+				SlowCount: 1,
+				Start:     savedAt.Add(-30 * time.Second),
+			},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	d := newTestSlowPageDetector()
+	if err := d.RestoreState(data); err != nil {
+		t.Fatal(err)
+	}
+	if insights := d.Expire(time.Now()); len(insights) != 0 {
+		t.Errorf("got %d insights, want the window to resume", len(insights))
+	}
+	if insights := d.Expire(time.Now().Add(31 * time.Second)); len(insights) != 1 {
+		t.Errorf("got %d insights, want the resumed window to close", len(insights))
+	}
+}
+
+func TestCheckpointSlowPageAbandonment(t *testing.T) {
+	cfg := config.SlowPageAbandonmentConfig{Enabled: true, AbandonmentWindowMs: 60_000}
+	slowCfg := config.SlowPageConfig{Enabled: true, LCPThresholdMs: 2500, TTFBThresholdMs: 800}
+
+	before := NewSlowPageAbandonmentDetector(cfg, slowCfg)
+	before.ProcessPerformance(slowLoad("e1", "/checkout", 3000))
+	after := NewSlowPageAbandonmentDetector(cfg, slowCfg)
+	checkpoint(t, before, after)
+
+	exit := slowLoad("e2", "/checkout", 0)
+	exit.SessionID = "sess-e1"
+	insight := after.ProcessEvent(exit, eventtype.PageExit)
+	if insight == nil {
+		t.Fatal("expected an insight for the restored slow load")
+	}
+	if reason := insight.Details["abandonment_reason"]; reason != "page_exit" {
+		t.Errorf("abandonment_reason = %v, want page_exit", reason)
+	}
+}
+
+func TestCheckpointFormRetry(t *testing.T) {
+	cfg := config.FormRetryConfig{Enabled: true, ErrorWindowMs: 5000, MinAttempts: 2}
+	submit := func(id string, offsetMs int64) *Event {
+		event := sessionEvent(id, offsetMs)
+		event.FormSelector = "#signup"
+		return event
+	}
+
+	before := NewFormRetryDetector(cfg)
+	before.ProcessSubmit(submit("submit-1", 0))
+	before.ProcessError(sessionEvent("error-1", 100))
+	before.ProcessSubmit(submit("submit-2", 1000))
+	after := NewFormRetryDetector(cfg)
+	checkpoint(t, before, after)
+
+	// Needs both the restored submit and the restored failed attempt
+	insight := after.ProcessError(sessionEvent("error-2", 1100))
+	if insight == nil {
+		t.Fatal("expected a form_retry insight from the restored attempts")
+	}
+	if n := insight.Details["attempts"]; n != 2 {
+		t.Errorf("attempts = %v, want 2", n)
+	}
+}
+
+func TestCheckpointMediaAbandonment(t *testing.T) {
+	cfg := config.MediaAbandonmentConfig{Enabled: true, MinWatchPercent: 50, InactivityTimeoutMs: 60_000}
+
+	before := NewMediaAbandonmentDetector(cfg)
+	play := sessionEvent("play", 0)
+	play.MediaID = "intro"
+	play.MediaPosition = 10
+	play.MediaDuration = 100
+	before.ProcessMedia(play, eventtype.MediaPlay)
+	after := NewMediaAbandonmentDetector(cfg)
+	checkpoint(t, before, after)
+
+	insights := after.ProcessPageLeave(sessionEvent("exit", 1000), eventtype.PageExit)
+	if len(insights) != 1 {
+		t.Fatalf("got %d insights, want 1 for the restored playback", len(insights))
+	}
+	if pct := insights[0].Details["watched_percent"]; pct != 10.0 {
+		t.Errorf("watched_percent = %v, want 10", pct)
+	}
+}
+
+func TestCheckpointMissingVitals(t *testing.T) {
+	cfg := config.MissingVitalsConfig{Enabled: true, ExpectedMetrics: []string{"lcp", "cls"}, ObservationWindowMs: 60_000}
+	lcp, cls := 1200.0, 0.1
+
+	before := NewMissingVitalsDetector(cfg)
+	event := sessionEvent("vitals-1", 0)
+	event.LCP = &lcp
+	before.ProcessVitals(event)
+	after := NewMissingVitalsDetector(cfg)
+	checkpoint(t, before, after)
+
+	event = sessionEvent("vitals-2", 1000)
+	event.CLS = &cls
+	after.ProcessVitals(event)
+	if insights := after.Expire(time.Now().Add(2 * time.Minute)); len(insights) != 0 {
+		t.Errorf("got %d insights, want none with LCP restored and CLS reported", len(insights))
+	}
+}
+
+func TestCheckpointFunnelAbandonment(t *testing.T) {
+	before := newTestFunnelDetector()
+	visitPages(before, NewPageTracker(), "s1", "/cart", "/shipping")
+	after := newTestFunnelDetector()
+	checkpoint(t, before, after)
+
+	insights := after.Expire(time.Now().Add(2 * time.Minute))
+	if len(insights) != 1 {
+		t.Fatalf("got %d insights, want 1 for the restored progress", len(insights))
+	}
+	if step := insights[0].Details["abandoned_step"]; step != 2 {
+		t.Errorf("abandoned_step = %v, want 2", step)
+	}
+
+	// Reported abandonments stay reported across restarts
+	restarted := newTestFunnelDetector()
+	checkpoint(t, after, restarted)
+	if insights := restarted.Expire(time.Now().Add(4 * time.Minute)); len(insights) != 0 {
+		t.Errorf("got %d insights for an abandonment already reported", len(insights))
+	}
+}
+
+func TestCheckpointFunnelDrop(t *testing.T) {
+	cfg := config.FunnelDropConfig{Enabled: true, Steps: []string{"/cart", "/shipping", "/payment"}, StepTimeoutMs: 60_000}
+	cart := sessionEvent("cart", 0)
+	cart.Path = "/cart"
+
+	before := NewFunnelDropDetector(cfg)
+	before.ProcessPageView(cart)
+	after := NewFunnelDropDetector(cfg)
+	checkpoint(t, before, after)
+
+	insights := after.Expire(time.Now().Add(2 * time.Minute))
+	if len(insights) != 1 {
+		t.Fatalf("got %d insights, want 1 for the restored step", len(insights))
+	}
+	if path := insights[0].Details["next_step_path"]; path != "/shipping" {
+		t.Errorf("next_step_path = %v, want /shipping", path)
+	}
+
+	// Steps the configured funnel no longer has are skipped
+	shorter := NewFunnelDropDetector(config.FunnelDropConfig{Enabled: true, Steps: []string{"/cart"}, StepTimeoutMs: 60_000})
+	checkpoint(t, before, shorter)
+	if insights := shorter.Expire(time.Now().Add(2 * time.Minute)); len(insights) != 0 {
+		t.Errorf("got %d insights for a step past the configured funnel", len(insights))
+	}
+}
+
+func TestCheckpointErrorClick(t *testing.T) {
+	cfg := config.ErrorClickConfig{Enabled: true, ErrorWindowMs: 5000}
+
+	before := NewErrorClickDetector(cfg)
+	before.ProcessClick(sessionEvent("click", 0))
+	after := NewErrorClickDetector(cfg)
+	checkpoint(t, before, after)
+
+	if after.ProcessError(sessionEvent("error", 100)) == nil {
+		t.Error("expected an error_click insight for the restored click")
+	}
+}
+
+func TestCheckpointDeadClick(t *testing.T) {
+	cfg := config.DeadClickConfig{Enabled: true, ObservationWindowMs: 1000}
+
+	before := NewDeadClickDetector(cfg)
+	click := sessionEvent("click", 0)
+	click.TargetTag = "button"
+	before.ProcessClick(click)
+	after := NewDeadClickDetector(cfg)
+	checkpoint(t, before, after)
+
+	if insights := after.Expire(time.Now().Add(time.Minute)); len(insights) != 1 {
+		t.Errorf("got %d insights, want 1 for the restored click", len(insights))
+	}
+}
+
+func TestCheckpointPageHistory(t *testing.T) {
+	before := NewPageTracker()
+	before.Visit(sessionEvent("view-1", 0))
+	after := NewPageTracker()
+	checkpoint(t, before, after)
+
+	if pages := after.Visit(sessionEvent("view-2", 1000)); len(pages) != 2 {
+		t.Errorf("got %d pages, want the restored one and the new one", len(pages))
+	}
+}
+
+func TestCheckpointLeavesOutStateBeforeCutoff(t *testing.T) {
+	d := NewLongTaskDetector(config.LongTaskConfig{Enabled: true, TotalBlockingTimeThresholdMs: 300, WindowMs: 10_000})
+	event := sessionEvent("e", -2*time.Hour.Milliseconds())
+	event.LongTaskDurationMs = 200
+	d.ProcessLongTask(event)
+
+	data, err := d.SaveState(time.Now().Add(-time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != "{}" {
+		t.Errorf("state = %s, want no sessions", data)
+	}
+}
+
+func TestStopEndsCheckpointLoop(t *testing.T) {
+	rdb := redis.NewClient(&redis.Options{Addr: "127.0.0.1:1", MaxRetries: -1, DialTimeout: 100 * time.Millisecond})
+	defer rdb.Close()
+
+	p := &Processor{
+		redis:          rdb,
+		checkpoint:     &config.InsightCheckpointConfig{Enabled: true, Interval: time.Hour, MaxAge: time.Hour, Instance: "test"},
+		checkpointStop: make(chan struct{}),
+		checkpointDone: make(chan struct{}),
+	}
+	go p.checkpointLoop()
+	p.Stop()
+
+	select {
+	case <-p.checkpointDone:
+	default:
+		t.Error("checkpointLoop still running after Stop")
+	}
+}
+
+func TestCheckpointPogostick(t *testing.T) {
+	cfg := config.PogostickConfig{Enabled: true, MinReturns: 2, WindowMs: 10_000}
+	tracker := NewPageTracker()
+	var pages []PageVisit
+	for i, path := range []string{"/search", "/item/a", "/search", "/item/b", "/search"} {
+		pages = tracker.Visit(&Event{EventID: path, SessionID: "sess", Path: path, Timestamp: int64(i) * 1000})
+	}
+	hub := &Event{ProjectID: "proj", SessionID: "sess", Path: "/search"}
+
+	before := NewPogostickDetector(cfg)
+	if before.ProcessPageView(hub, pages) == nil {
+		t.Fatal("pogosticking not detected")
+	}
+	after := NewPogostickDetector(cfg)
+	checkpoint(t, before, after)
+
+	if after.ProcessPageView(hub, pages) != nil {
+		t.Error("hub reported again after a restart")
+	}
+}
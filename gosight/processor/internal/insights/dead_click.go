@@ -1,19 +1,37 @@
 package insights
 
 import (
+	"encoding/json"
 	"fmt"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/gosight/gosight/processor/internal/config"
+	"github.com/gosight/gosight/processor/internal/eventtype"
 )
 
-// DeadClickDetector detects clicks on interactive elements that produce no response
+// maxRecentResponses bounds the recent responses kept per session
+const maxRecentResponses = 32
+
+// DeadClickDetector detects clicks on interactive elements that produce no
+// response within the observation window. Dead clicks are reported by Expire.
 type DeadClickDetector struct {
-	observationWindowMs int64
+	observationWindowMs atomic.Int64
 	pendingClicks       sync.Map // key -> ClickContext
-	emitCallback        func(*Insight)
+
+	// Page views and DOM mutations of each session processed within the
+	// observation window. Batching or the network can deliver a click after
+	// the navigation it caused; the click is resolved against these then.
+	recent   map[string][]recentResponse // sessionID -> responses
+	recentMu sync.Mutex
+}
+
+// recentResponse is an event that may resolve a click processed after it
+type recentResponse struct {
+	event *Event
+	seen  time.Time
 }
 
 // ClickContext stores context about a pending click
@@ -21,6 +39,7 @@ type ClickContext struct {
 	Event      *Event
 	ExpectedTo string // "navigate", "mutate", "handle"
 	Timestamp  int64
+	Deadline   time.Time // end of the observation window
 }
 
 var expectedInteractiveTags = []string{
@@ -32,11 +51,18 @@ var expectedInteractiveClasses = []string{
 }
 
 // NewDeadClickDetector creates a new dead click detector
-func NewDeadClickDetector(cfg config.DeadClickConfig, emitCallback func(*Insight)) *DeadClickDetector {
-	return &DeadClickDetector{
-		observationWindowMs: cfg.ObservationWindowMs,
-		emitCallback:        emitCallback,
+func NewDeadClickDetector(cfg config.DeadClickConfig) *DeadClickDetector {
+	d := &DeadClickDetector{
+		recent: make(map[string][]recentResponse),
 	}
+	d.SetThresholds(cfg)
+	return d
+}
+
+// SetThresholds applies a new observation window to the running detector;
+// clicks already pending keep the check they were scheduled with
+func (d *DeadClickDetector) SetThresholds(cfg config.DeadClickConfig) {
+	d.observationWindowMs.Store(cfg.ObservationWindowMs)
 }
 
 // ProcessClick processes a click event
@@ -49,19 +75,55 @@ func (d *DeadClickDetector) ProcessClick(event *Event) {
 	// Determine expected behavior
 	expected := d.determineExpectedBehavior(event)
 
-	// Store pending click
-	key := fmt.Sprintf("%s:%s", event.SessionID, event.EventID)
-	d.pendingClicks.Store(key, ClickContext{
+	window := time.Duration(d.observationWindowMs.Load()) * time.Millisecond
+	ctx := ClickContext{
 		Event:      event,
 		ExpectedTo: expected,
 		Timestamp:  event.Timestamp,
-	})
+		Deadline:   time.Now().Add(window),
+	}
 
-	// Schedule check
-	go func(checkKey string, clickEvent *Event) {
-		time.Sleep(time.Duration(d.observationWindowMs) * time.Millisecond)
-		d.checkForResponse(checkKey, clickEvent)
-	}(key, event)
+	// The response may have been processed before the click
+	if d.respondedBefore(ctx) {
+		return
+	}
+
+	// Store pending click until its observation window ends
+	key := fmt.Sprintf("%s:%s", event.SessionID, event.EventID)
+	d.pendingClicks.Store(key, ctx)
+}
+
+// respondedBefore reports whether an already processed event of the click's
+// session responds to it
+func (d *DeadClickDetector) respondedBefore(ctx ClickContext) bool {
+	d.recentMu.Lock()
+	defer d.recentMu.Unlock()
+
+	for _, response := range d.recent[ctx.Event.SessionID] {
+		if d.isResponseTo(ctx, response.event) {
+			return true
+		}
+	}
+	return false
+}
+
+// Expire reports the pending clicks whose observation window ended by now
+// without a response, and forgets responses older than the window
+func (d *DeadClickDetector) Expire(now time.Time) []*Insight {
+	d.expireRecent(now)
+
+	var insights []*Insight
+	d.pendingClicks.Range(func(key, value interface{}) bool {
+		if now.Before(value.(ClickContext).Deadline) {
+			return true
+		}
+		// Resolved concurrently otherwise
+		if value, loaded := d.pendingClicks.LoadAndDelete(key); loaded {
+			insights = append(insights, d.deadClick(value.(ClickContext)))
+		}
+		return true
+	})
+	return insights
 }
 
 // ProcessEvent processes events that might resolve pending dead clicks
@@ -81,6 +143,113 @@ func (d *DeadClickDetector) ProcessEvent(event *Event) {
 
 		return true
 	})
+
+	// Kept for clicks of the session processed after it
+	d.recentMu.Lock()
+	recent := append(d.recent[event.SessionID], recentResponse{event: event, seen: time.Now()})
+	if len(recent) > maxRecentResponses {
+		recent = recent[len(recent)-maxRecentResponses:]
+	}
+	d.recent[event.SessionID] = recent
+	d.recentMu.Unlock()
+}
+
+// expireRecent forgets the responses processed more than the observation
+// window before now
+func (d *DeadClickDetector) expireRecent(now time.Time) {
+	cutoff := now.Add(-time.Duration(d.observationWindowMs.Load()) * time.Millisecond)
+
+	d.recentMu.Lock()
+	defer d.recentMu.Unlock()
+
+	for sessionID, recent := range d.recent {
+		i := 0
+		for i < len(recent) && recent[i].seen.Before(cutoff) {
+			i++
+		}
+		if i == len(recent) {
+			delete(d.recent, sessionID)
+		} else if i > 0 {
+			d.recent[sessionID] = recent[i:]
+		}
+	}
+}
+
+// deadClickState is the checkpoint of a DeadClickDetector
+type deadClickState struct {
+	SavedAt time.Time                      `json:"saved_at"`
+	Pending map[string]ClickContext        `json:"pending"`
+	Recent  map[string][]deadClickResponse `json:"recent"`
+}
+
+type deadClickResponse struct {
+	Event *Event    `json:"event"`
+	Seen  time.Time `json:"seen"`
+}
+
+// SaveState returns the pending clicks and recent responses as JSON. They all
+// lie within the observation window, so none are older than cutoff.
+func (d *DeadClickDetector) SaveState(_ time.Time) ([]byte, error) {
+	state := deadClickState{
+		SavedAt: time.Now(),
+		Pending: make(map[string]ClickContext),
+		Recent:  make(map[string][]deadClickResponse),
+	}
+	d.pendingClicks.Range(func(key, value interface{}) bool {
+		state.Pending[key.(string)] = value.(ClickContext)
+		return true
+	})
+
+	d.recentMu.Lock()
+	for sessionID, recent := range d.recent {
+		responses := make([]deadClickResponse, len(recent))
+		for i, response := range recent {
+			responses[i] = deadClickResponse{Event: response.event, Seen: response.seen}
+		}
+		state.Recent[sessionID] = responses
+	}
+	d.recentMu.Unlock()
+
+	return json.Marshal(state)
+}
+
+// RestoreState restores the state returned by SaveState. Observation windows
+// resume where they were saved: the time the processor was down, when the
+// events that would resolve the clicks weren't processed, does not count.
+func (d *DeadClickDetector) RestoreState(data []byte) error {
+	var state deadClickState
+	if err := json.Unmarshal(data, &state); err != nil {
+		return err
+	}
+	downtime := max(time.Since(state.SavedAt), 0)
+
+	for key, ctx := range state.Pending {
+		if ctx.Event == nil {
+			continue
+		}
+		ctx.Deadline = ctx.Deadline.Add(downtime)
+		d.pendingClicks.LoadOrStore(key, ctx)
+	}
+
+	d.recentMu.Lock()
+	defer d.recentMu.Unlock()
+	for sessionID, responses := range state.Recent {
+		restored := make([]recentResponse, 0, len(responses))
+		for _, response := range responses {
+			if response.Event != nil {
+				restored = append(restored, recentResponse{event: response.Event, seen: response.Seen.Add(downtime)})
+			}
+		}
+		// Responses processed since startup are more recent
+		recent := append(restored, d.recent[sessionID]...)
+		if len(recent) > maxRecentResponses {
+			recent = recent[len(recent)-maxRecentResponses:]
+		}
+		if len(recent) > 0 {
+			d.recent[sessionID] = recent
+		}
+	}
+	return nil
 }
 
 func (d *DeadClickDetector) isResponseTo(ctx ClickContext, event *Event) bool {
@@ -90,36 +259,29 @@ func (d *DeadClickDetector) isResponseTo(ctx ClickContext, event *Event) bool {
 	}
 
 	// Within observation window
-	if event.Timestamp > ctx.Event.Timestamp+d.observationWindowMs {
+	if event.Timestamp > ctx.Event.Timestamp+d.observationWindowMs.Load() {
 		return false
 	}
 
+	eventType := eventtype.Normalize(event.Type)
 	switch ctx.ExpectedTo {
 	case "navigate":
-		return event.Type == "page_view" || event.Type == "EVENT_TYPE_PAGE_VIEW"
+		return eventType == eventtype.PageView
 	case "mutate":
-		return event.Type == "dom_mutation" || event.Type == "EVENT_TYPE_DOM_MUTATION"
+		return eventType == eventtype.DOMMutation
 	case "handle":
-		return event.Type != "mouse_move" && event.Type != "scroll" &&
-			event.Type != "EVENT_TYPE_MOUSE_MOVE" && event.Type != "EVENT_TYPE_SCROLL"
+		return eventType != eventtype.MouseMove && eventType != eventtype.Scroll
 	}
 
 	return false
 }
 
-func (d *DeadClickDetector) checkForResponse(key string, event *Event) {
-	value, exists := d.pendingClicks.LoadAndDelete(key)
-	if !exists {
-		return // Already resolved
-	}
-
-	ctx := value.(ClickContext)
-
-	// No response - this is a dead click
+// deadClick is the insight of a click that got no response
+func (d *DeadClickDetector) deadClick(ctx ClickContext) *Insight {
 	x := ctx.Event.ClickX
 	y := ctx.Event.ClickY
 
-	insight := &Insight{
+	return &Insight{
 		Type:           "dead_click",
 		ProjectID:      ctx.Event.ProjectID,
 		SessionID:      ctx.Event.SessionID,
@@ -131,40 +293,55 @@ func (d *DeadClickDetector) checkForResponse(key string, event *Event) {
 		TargetSelector: ctx.Event.TargetSelector,
 		Details: map[string]interface{}{
 			"expected_behavior":      ctx.ExpectedTo,
-			"observation_window_ms":  d.observationWindowMs,
+			"observation_window_ms":  d.observationWindowMs.Load(),
 			"target_tag":             ctx.Event.TargetTag,
 		},
 		RelatedEventIDs: []string{ctx.Event.EventID},
-	}
-
-	if d.emitCallback != nil {
-		d.emitCallback(insight)
+		Confidence:      d.interactiveConfidence(ctx.Event),
 	}
 }
 
 func (d *DeadClickDetector) looksInteractive(event *Event) bool {
+	return d.interactiveConfidence(event) > 0
+}
+
+// interactiveConfidence grades how surely the target is meant to be clicked,
+// the confidence of its dead clicks: 0.9 for a semantic tag or role, 0.8 for
+// a JS click handler, 0.6 for only a class name or pointer cursor, 0 when
+// nothing suggests it is interactive
+func (d *DeadClickDetector) interactiveConfidence(event *Event) float64 {
 	// Check tag
 	for _, tag := range expectedInteractiveTags {
 		if event.TargetTag == tag {
-			return true
+			return 0.9
 		}
 	}
 
+	// Check role attribute
+	if event.TargetRole == "button" || event.TargetRole == "link" {
+		return 0.9
+	}
+
+	// Custom clickable elements (e.g. a <div> with a JS handler) carry no
+	// semantic markers, only the SDK's click handler or cursor hints
+	if event.HadClickHandler {
+		return 0.8
+	}
+
 	// Check classes
 	for _, class := range event.TargetClasses {
 		for _, expected := range expectedInteractiveClasses {
 			if strings.Contains(strings.ToLower(class), expected) {
-				return true
+				return 0.6
 			}
 		}
 	}
 
-	// Check role attribute
-	if event.TargetRole == "button" || event.TargetRole == "link" {
-		return true
+	if event.CursorPointer {
+		return 0.6
 	}
 
-	return false
+	return 0
 }
 
 func (d *DeadClickDetector) determineExpectedBehavior(event *Event) string {
@@ -0,0 +1,50 @@
+package insights
+
+import (
+	"testing"
+	"time"
+
+	"github.com/gosight/gosight/processor/internal/config"
+)
+
+func linkClick(sessionID string, timestamp int64) *Event {
+	return &Event{
+		EventID:    "click-" + sessionID,
+		Type:       "click",
+		ProjectID:  "proj",
+		SessionID:  sessionID,
+		Timestamp:  timestamp,
+		Path:       "/pricing",
+		TargetTag:  "a",
+		TargetHref: "/checkout",
+	}
+}
+
+func pageView(sessionID string, timestamp int64) *Event {
+	return &Event{EventID: "pv-" + sessionID, Type: "page_view", ProjectID: "proj", SessionID: sessionID, Timestamp: timestamp, Path: "/checkout"}
+}
+
+func TestDeadClickResolvedByNavigationProcessedFirst(t *testing.T) {
+	d := NewDeadClickDetector(config.DeadClickConfig{Enabled: true, ObservationWindowMs: 1000})
+
+	// The navigation reaches the processor before the click that caused it
+	d.ProcessEvent(pageView("reordered", 1_700_000_000_400))
+	d.ProcessClick(linkClick("reordered", 1_700_000_000_000))
+
+	// A page view before the click doesn't answer it
+	d.ProcessEvent(pageView("earlier", 1_699_999_999_000))
+	d.ProcessClick(linkClick("earlier", 1_700_000_000_000))
+
+	insights := d.Expire(time.Now().Add(2 * time.Second))
+	if len(insights) != 1 || insights[0].SessionID != "earlier" {
+		t.Fatalf("dead clicks = %v, want only the one of session earlier", insights)
+	}
+	if insights[0].Details["expected_behavior"] != "navigate" {
+		t.Errorf("expected_behavior = %v", insights[0].Details["expected_behavior"])
+	}
+
+	// Responses are forgotten after the observation window
+	if len(d.recent) != 0 {
+		t.Errorf("%d sessions of responses kept after the window", len(d.recent))
+	}
+}
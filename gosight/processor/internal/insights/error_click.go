@@ -2,6 +2,7 @@ package insights
 
 import (
 	"container/ring"
+	"encoding/json"
 	"sync"
 	"time"
 
@@ -29,6 +30,14 @@ func NewErrorClickDetector(cfg config.ErrorClickConfig) *ErrorClickDetector {
 	}
 }
 
+// SetThresholds applies a new error window to the running detector; recent
+// clicks are kept
+func (d *ErrorClickDetector) SetThresholds(cfg config.ErrorClickConfig) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.errorWindowMs = cfg.ErrorWindowMs
+}
+
 // ProcessClick records a click for potential error correlation
 func (d *ErrorClickDetector) ProcessClick(event *Event) {
 	d.mu.Lock()
@@ -41,6 +50,50 @@ func (d *ErrorClickDetector) ProcessClick(event *Event) {
 	d.recentClicks = d.recentClicks.Next()
 }
 
+// SaveState returns the recent clicks, oldest first, as JSON, leaving out
+// clicks before cutoff
+func (d *ErrorClickDetector) SaveState(cutoff time.Time) ([]byte, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	var clicks []ClickWithSession
+	d.recentClicks.Do(func(v interface{}) {
+		if click, ok := v.(ClickWithSession); ok && click.Event.Timestamp >= cutoff.UnixMilli() {
+			clicks = append(clicks, click)
+		}
+	})
+	return json.Marshal(clicks)
+}
+
+// RestoreState restores the clicks returned by SaveState as the most recent
+// ones, before clicks recorded since startup
+func (d *ErrorClickDetector) RestoreState(data []byte) error {
+	var clicks []ClickWithSession
+	if err := json.Unmarshal(data, &clicks); err != nil {
+		return err
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	// Clicks recorded since startup go after the restored ones
+	var recorded []ClickWithSession
+	d.recentClicks.Do(func(v interface{}) {
+		if click, ok := v.(ClickWithSession); ok {
+			recorded = append(recorded, click)
+		}
+	})
+	d.recentClicks = ring.New(d.recentClicks.Len())
+	for _, click := range append(clicks, recorded...) {
+		if click.Event == nil {
+			continue
+		}
+		d.recentClicks.Value = click
+		d.recentClicks = d.recentClicks.Next()
+	}
+	return nil
+}
+
 // ProcessError checks if an error was preceded by a click
 func (d *ErrorClickDetector) ProcessError(errorEvent *Event) *Insight {
 	d.mu.Lock()
@@ -96,5 +149,7 @@ func (d *ErrorClickDetector) ProcessError(errorEvent *Event) *Insight {
 			matchingClick.Event.EventID,
 			errorEvent.EventID,
 		},
+		// The sooner the error followed the click, the likelier the click caused it
+		Confidence: underThresholdConfidence(float64(errorEvent.Timestamp-matchingClick.Event.Timestamp), float64(d.errorWindowMs)),
 	}
 }
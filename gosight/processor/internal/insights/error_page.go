@@ -0,0 +1,98 @@
+package insights
+
+import (
+	"regexp"
+	"sync/atomic"
+	"time"
+
+	"github.com/gosight/gosight/processor/internal/config"
+)
+
+// ErrorPageDetector detects navigation to error pages: a page view with an
+// HTTP status of minStatus or above, flagged as an error page by the SDK, or
+// whose path matches one of the configured patterns
+type ErrorPageDetector struct {
+	patterns  []*regexp.Regexp
+	minStatus atomic.Int64
+}
+
+// NewErrorPageDetector creates a new error page detector. The patterns are
+// validated by config.Load.
+func NewErrorPageDetector(cfg config.ErrorPageConfig) *ErrorPageDetector {
+	d := &ErrorPageDetector{}
+	for _, pattern := range cfg.PathPatterns {
+		d.patterns = append(d.patterns, regexp.MustCompile(pattern))
+	}
+	d.SetThresholds(cfg)
+	return d
+}
+
+// SetThresholds applies a new status threshold to the running detector
+func (d *ErrorPageDetector) SetThresholds(cfg config.ErrorPageConfig) {
+	d.minStatus.Store(int64(cfg.MinStatus))
+}
+
+// ProcessPageView detects an error page given the session's page history,
+// which ends with the current page view. The page before it, if any, is
+// reported as the referrer. Reloads of an error page are not reported again.
+func (d *ErrorPageDetector) ProcessPageView(event *Event, pages []PageVisit) *Insight {
+	reason := d.match(event)
+	if reason == "" {
+		return nil
+	}
+
+	details := map[string]interface{}{
+		"path":   event.Path,
+		"reason": reason,
+	}
+	if event.HTTPStatus > 0 {
+		details["http_status"] = event.HTTPStatus
+	}
+	eventIDs := []string{event.EventID}
+
+	if len(pages) >= 2 {
+		referrer := pages[len(pages)-2]
+		if referrer.Path == event.Path {
+			return nil
+		}
+		details["referrer_url"] = referrer.URL
+		details["referrer_path"] = referrer.Path
+		eventIDs = []string{referrer.EventID, event.EventID}
+	}
+
+	return &Insight{
+		Type:            "error_page",
+		ProjectID:       event.ProjectID,
+		SessionID:       event.SessionID,
+		Timestamp:       time.Now(),
+		URL:             event.URL,
+		Path:            event.Path,
+		Details:         details,
+		RelatedEventIDs: eventIDs,
+		Confidence:      errorPageConfidence[reason],
+	}
+}
+
+// errorPageConfidence is the confidence of error pages by match reason: a
+// status or the SDK's flag is certain, a path pattern may match a real page
+var errorPageConfidence = map[string]float64{
+	"http_status":  1,
+	"flagged":      1,
+	"path_pattern": 0.7,
+}
+
+// match returns why the page view is an error page, or "" if it isn't
+func (d *ErrorPageDetector) match(event *Event) string {
+	if minStatus := d.minStatus.Load(); minStatus > 0 && int64(event.HTTPStatus) >= minStatus {
+		return "http_status"
+	}
+	if event.ErrorPage {
+		return "flagged"
+	}
+	for _, re := range d.patterns {
+		if re.MatchString(event.Path) {
+			return "path_pattern"
+		}
+	}
+	return ""
+}
@@ -0,0 +1,235 @@
+package insights
+
+import (
+	"container/ring"
+	"encoding/json"
+	"sync"
+	"time"
+
+	"github.com/gosight/gosight/processor/internal/config"
+)
+
+// formAttemptsTTL is how long the failed attempts of a form are kept after its
+// last failed submission
+const formAttemptsTTL = 30 * time.Minute
+
+// formAttemptsSweepInterval is how often failed attempts past formAttemptsTTL
+// are evicted
+const formAttemptsSweepInterval = time.Minute
+
+// FormRetryDetector detects repeated form submissions that keep failing
+type FormRetryDetector struct {
+	errorWindowMs  int64
+	minAttempts    int
+	recentSubmits  *ring.Ring
+	failedAttempts map[string]*FormAttempts // sessionID:formSelector -> attempts
+	lastSweep      time.Time
+	mu             sync.Mutex
+}
+
+// FormAttempts tracks failed submissions of a single form within a session
+type FormAttempts struct {
+	SubmitEventIDs []string
+	ErrorEventIDs  []string
+	FirstAttempt   int64
+	LastAttempt    int64
+	Reported       bool
+	UpdatedAt      time.Time // when the last failed attempt was processed
+}
+
+// formRetryState is the checkpoint of a FormRetryDetector
+type formRetryState struct {
+	Submits  []ClickWithSession       `json:"submits"`
+	Attempts map[string]*FormAttempts `json:"attempts"`
+}
+
+// NewFormRetryDetector creates a new form retry detector
+func NewFormRetryDetector(cfg config.FormRetryConfig) *FormRetryDetector {
+	return &FormRetryDetector{
+		errorWindowMs:  cfg.ErrorWindowMs,
+		minAttempts:    cfg.MinAttempts,
+		recentSubmits:  ring.New(100), // Keep last 100 submits
+		failedAttempts: make(map[string]*FormAttempts),
+	}
+}
+
+// SetThresholds applies new thresholds to the running detector; failed
+// attempts already counted are kept
+func (d *FormRetryDetector) SetThresholds(cfg config.FormRetryConfig) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.errorWindowMs = cfg.ErrorWindowMs
+	d.minAttempts = cfg.MinAttempts
+}
+
+// ProcessSubmit records a form submission for potential error correlation
+func (d *FormRetryDetector) ProcessSubmit(event *Event) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.recentSubmits.Value = ClickWithSession{
+		SessionID: event.SessionID,
+		Event:     event,
+	}
+	d.recentSubmits = d.recentSubmits.Next()
+}
+
+// SaveState returns the recent submits, oldest first, and the failed attempts
+// as JSON, leaving out submits and forms last submitted before cutoff
+func (d *FormRetryDetector) SaveState(cutoff time.Time) ([]byte, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	state := formRetryState{Attempts: make(map[string]*FormAttempts)}
+	d.recentSubmits.Do(func(v interface{}) {
+		if submit, ok := v.(ClickWithSession); ok && submit.Event.Timestamp >= cutoff.UnixMilli() {
+			state.Submits = append(state.Submits, submit)
+		}
+	})
+	for key, attempts := range d.failedAttempts {
+		if attempts.LastAttempt >= cutoff.UnixMilli() {
+			state.Attempts[key] = attempts
+		}
+	}
+	return json.Marshal(state)
+}
+
+// RestoreState restores the state returned by SaveState: the submits as the
+// most recent ones, before submits recorded since startup, and the failed
+// attempts of forms not failed since startup
+func (d *FormRetryDetector) RestoreState(data []byte) error {
+	var state formRetryState
+	if err := json.Unmarshal(data, &state); err != nil {
+		return err
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	// Submits recorded since startup go after the restored ones
+	var recorded []ClickWithSession
+	d.recentSubmits.Do(func(v interface{}) {
+		if submit, ok := v.(ClickWithSession); ok {
+			recorded = append(recorded, submit)
+		}
+	})
+	d.recentSubmits = ring.New(d.recentSubmits.Len())
+	for _, submit := range append(state.Submits, recorded...) {
+		if submit.Event == nil {
+			continue
+		}
+		d.recentSubmits.Value = submit
+		d.recentSubmits = d.recentSubmits.Next()
+	}
+
+	for key, attempts := range state.Attempts {
+		if _, ok := d.failedAttempts[key]; ok || attempts == nil {
+			continue
+		}
+		d.failedAttempts[key] = attempts
+	}
+	return nil
+}
+
+// ProcessError checks if an error followed a form submission and returns an
+// insight once the same form has failed minAttempts times in the session
+func (d *FormRetryDetector) ProcessError(errorEvent *Event) *Insight {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.evictAttempts(time.Now())
+
+	var matchingSubmit *ClickWithSession
+
+	// Find most recent submit in same session within error window
+	d.recentSubmits.Do(func(v interface{}) {
+		if v == nil {
+			return
+		}
+
+		submit := v.(ClickWithSession)
+
+		// Same session
+		if submit.SessionID != errorEvent.SessionID {
+			return
+		}
+
+		// Within error window (submit before error)
+		timeDiff := errorEvent.Timestamp - submit.Event.Timestamp
+		if timeDiff >= 0 && timeDiff <= d.errorWindowMs {
+			if matchingSubmit == nil || submit.Event.Timestamp > matchingSubmit.Event.Timestamp {
+				matchingSubmit = &submit
+			}
+		}
+	})
+
+	if matchingSubmit == nil {
+		return nil
+	}
+
+	submit := matchingSubmit.Event
+	key := errorEvent.SessionID + ":" + submit.FormSelector
+
+	attempts, ok := d.failedAttempts[key]
+	if !ok {
+		attempts = &FormAttempts{FirstAttempt: submit.Timestamp}
+		d.failedAttempts[key] = attempts
+	}
+
+	// Several field errors for one submit count as a single failed attempt
+	for _, id := range attempts.SubmitEventIDs {
+		if id == submit.EventID {
+			return nil
+		}
+	}
+
+	attempts.SubmitEventIDs = append(attempts.SubmitEventIDs, submit.EventID)
+	attempts.ErrorEventIDs = append(attempts.ErrorEventIDs, errorEvent.EventID)
+	attempts.LastAttempt = submit.Timestamp
+	attempts.UpdatedAt = time.Now()
+
+	if attempts.Reported || len(attempts.SubmitEventIDs) < d.minAttempts {
+		return nil
+	}
+	attempts.Reported = true
+
+	relatedIDs := make([]string, 0, len(attempts.SubmitEventIDs)+len(attempts.ErrorEventIDs))
+	relatedIDs = append(relatedIDs, attempts.SubmitEventIDs...)
+	relatedIDs = append(relatedIDs, attempts.ErrorEventIDs...)
+
+	return &Insight{
+		Type:           "form_retry",
+		ProjectID:      errorEvent.ProjectID,
+		SessionID:      errorEvent.SessionID,
+		Timestamp:      time.Now(),
+		URL:            submit.URL,
+		Path:           submit.Path,
+		TargetSelector: submit.FormSelector,
+		Details: map[string]interface{}{
+			"form_selector":   submit.FormSelector,
+			"attempts":        len(attempts.SubmitEventIDs),
+			"duration_ms":     attempts.LastAttempt - attempts.FirstAttempt,
+			"last_error":      errorEvent.ErrorMessage,
+			"last_error_type": errorEvent.ErrorType,
+		},
+		RelatedEventIDs: relatedIDs,
+		// Every attempt is matched to an observed validation error
+		Confidence: 1,
+	}
+}
+
+// evictAttempts forgets the failed attempts of forms that haven't failed for
+// formAttemptsTTL, sweeping at most every formAttemptsSweepInterval. Attempts
+// are only added by ProcessError, so sweeping there bounds them.
+func (d *FormRetryDetector) evictAttempts(now time.Time) {
+	if now.Sub(d.lastSweep) < formAttemptsSweepInterval {
+		return
+	}
+	d.lastSweep = now
+
+	for key, attempts := range d.failedAttempts {
+		if now.Sub(attempts.UpdatedAt) >= formAttemptsTTL {
+			delete(d.failedAttempts, key)
+		}
+	}
+}
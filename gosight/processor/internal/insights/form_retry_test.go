@@ -0,0 +1,66 @@
+package insights
+
+import (
+	"testing"
+	"time"
+
+	"github.com/gosight/gosight/processor/internal/config"
+)
+
+func newTestFormRetryDetector() *FormRetryDetector {
+	return NewFormRetryDetector(config.FormRetryConfig{Enabled: true, ErrorWindowMs: 5000, MinAttempts: 2})
+}
+
+// failSubmit submits the session's form and reports a validation error after it
+func failSubmit(d *FormRetryDetector, sessionID, submitID string, offsetMs int64) *Insight {
+	submit := sessionEvent(submitID, offsetMs)
+	submit.SessionID = sessionID
+	submit.FormSelector = "#signup"
+	d.ProcessSubmit(submit)
+
+	formError := sessionEvent(submitID+"-error", offsetMs+100)
+	formError.SessionID = sessionID
+	formError.ErrorMessage = "invalid email"
+	return d.ProcessError(formError)
+}
+
+func TestFormRetryReportsRepeatedFailures(t *testing.T) {
+	d := newTestFormRetryDetector()
+
+	if failSubmit(d, "s1", "submit-1", 0) != nil {
+		t.Fatal("unexpected insight after one failed attempt")
+	}
+	insight := failSubmit(d, "s1", "submit-2", 1000)
+	if insight == nil {
+		t.Fatal("expected a form_retry insight after two failed attempts")
+	}
+	if n := insight.Details["attempts"]; n != 2 {
+		t.Errorf("attempts = %v, want 2", n)
+	}
+	if failSubmit(d, "s1", "submit-3", 2000) != nil {
+		t.Error("form reported twice")
+	}
+}
+
+func TestFormRetryEvictsStaleAttempts(t *testing.T) {
+	d := newTestFormRetryDetector()
+	failSubmit(d, "s1", "submit-1", 0)
+	failSubmit(d, "s2", "submit-2", 0)
+
+	// s1's form last failed past the TTL, s2's just now
+	d.failedAttempts["s1:#signup"].UpdatedAt = time.Now().Add(-formAttemptsTTL - time.Minute)
+	d.lastSweep = time.Now().Add(-formAttemptsSweepInterval)
+
+	failSubmit(d, "s3", "submit-3", 0)
+	if _, ok := d.failedAttempts["s1:#signup"]; ok {
+		t.Error("stale attempts of s1 still kept")
+	}
+	if len(d.failedAttempts) != 2 {
+		t.Errorf("kept %d forms, want s2 and s3", len(d.failedAttempts))
+	}
+
+	// A session failing again after eviction starts over
+	if failSubmit(d, "s1", "submit-4", 1000) != nil {
+		t.Error("unexpected insight from evicted attempts")
+	}
+}
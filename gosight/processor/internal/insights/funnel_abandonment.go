@@ -0,0 +1,188 @@
+package insights
+
+import (
+	"sync"
+	"time"
+
+	"github.com/gosight/gosight/processor/internal/config"
+)
+
+// funnelSessionIdle is how long a session goes without a page view before it
+// is considered ended and its reported abandonment is forgotten
+const funnelSessionIdle = 30 * time.Minute
+
+// FunnelAbandonmentDetector detects sessions that get far enough into a funnel
+// (e.g. cart -> shipping -> payment) but don't reach the conversion page within
+// the abandonment timeout
+type FunnelAbandonmentDetector struct {
+	steps          []string
+	conversionPath string
+	minStep        int // 1-based step a session must reach to be tracked
+	timeout        time.Duration
+	sessions       map[string]*FunnelProgress // sessionID -> progress
+	mu             sync.Mutex
+}
+
+// FunnelProgress tracks the furthest funnel step a session reached
+type FunnelProgress struct {
+	Event     *Event // page view that reached the step
+	Step      int    // 0-based index into the steps
+	ReachedAt time.Time
+	EventIDs  []string
+	// Reported is set once the abandonment was reported; the progress is then
+	// kept, without reporting again, until the session converts or ends
+	Reported bool
+	LastSeen time.Time
+}
+
+// NewFunnelAbandonmentDetector creates a new funnel abandonment detector
+func NewFunnelAbandonmentDetector(cfg config.FunnelAbandonmentConfig) *FunnelAbandonmentDetector {
+	return &FunnelAbandonmentDetector{
+		steps:          cfg.Steps,
+		conversionPath: cfg.ConversionPath,
+		minStep:        cfg.MinStep,
+		timeout:        time.Duration(cfg.TimeoutMs) * time.Millisecond,
+		sessions:       make(map[string]*FunnelProgress),
+	}
+}
+
+// SetThresholds applies a new minimum step and timeout to the running detector;
+// the progress of sessions already tracked is kept
+func (d *FunnelAbandonmentDetector) SetThresholds(cfg config.FunnelAbandonmentConfig) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.minStep = cfg.MinStep
+	d.timeout = time.Duration(cfg.TimeoutMs) * time.Millisecond
+}
+
+// ProcessPageView updates the session's funnel progress given its page history,
+// which ends with the current page view. Steps count only when visited in order;
+// reaching the conversion page ends tracking of the session, and a session whose
+// abandonment was reported is not tracked again until then.
+func (d *FunnelAbandonmentDetector) ProcessPageView(event *Event, pages []PageVisit) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if event.Path == d.conversionPath {
+		delete(d.sessions, event.SessionID)
+		return
+	}
+
+	progress, ok := d.sessions[event.SessionID]
+	if ok {
+		progress.LastSeen = time.Now()
+		if progress.Reported {
+			return
+		}
+	}
+
+	step := -1
+	var eventIDs []string
+	for _, visit := range pages {
+		if step+1 < len(d.steps) && visit.Path == d.steps[step+1] {
+			step++
+			eventIDs = append(eventIDs, visit.EventID)
+		}
+	}
+	if step+1 < d.minStep {
+		return
+	}
+
+	// The history is bounded, so keep the furthest step seen so far
+	if ok && step <= progress.Step {
+		return
+	}
+	now := time.Now()
+	d.sessions[event.SessionID] = &FunnelProgress{
+		Event:     event,
+		Step:      step,
+		ReachedAt: now,
+		EventIDs:  eventIDs,
+		LastSeen:  now,
+	}
+}
+
+// Expire returns an insight for each session that reached its furthest step
+// longer than the abandonment timeout ago without converting, and forgets the
+// reported sessions that have since ended
+func (d *FunnelAbandonmentDetector) Expire(now time.Time) []*Insight {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	var insights []*Insight
+	for sessionID, progress := range d.sessions {
+		if progress.Reported {
+			if now.Sub(progress.LastSeen) >= funnelSessionIdle {
+				delete(d.sessions, sessionID)
+			}
+			continue
+		}
+		if now.Sub(progress.ReachedAt) < d.timeout {
+			continue
+		}
+		progress.Reported = true
+
+		insights = append(insights, &Insight{
+			Type:      "funnel_abandonment",
+			ProjectID: progress.Event.ProjectID,
+			SessionID: sessionID,
+			Timestamp: now,
+			URL:       progress.Event.URL,
+			Path:      progress.Event.Path,
+			Details: map[string]interface{}{
+				"abandoned_step":      progress.Step + 1,
+				"abandoned_step_path": d.steps[progress.Step],
+				"total_steps":         len(d.steps),
+				"conversion_path":     d.conversionPath,
+				"time_since_step_ms":  now.Sub(progress.ReachedAt).Milliseconds(),
+			},
+			RelatedEventIDs: progress.EventIDs,
+			// The further into the funnel, the surer the session meant to
+			// convert: 0.5 before the first step, 1 at the last
+			Confidence: 0.5 + 0.5*float64(progress.Step+1)/float64(len(d.steps)),
+		})
+	}
+
+	return insights
+}
+
+// SaveState returns the progress of sessions with a page view since cutoff,
+// as JSON
+func (d *FunnelAbandonmentDetector) SaveState(cutoff time.Time) ([]byte, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	sessions := make(map[string]*FunnelProgress, len(d.sessions))
+	for sessionID, progress := range d.sessions {
+		if !progress.LastSeen.Before(cutoff) {
+			sessions[sessionID] = progress
+		}
+	}
+	return saveProcessingTimeState(sessions)
+}
+
+// RestoreState restores the progress returned by SaveState, the abandonment
+// timeouts resuming where they were saved; sessions tracked since startup keep
+// their progress. Progress past the configured steps is skipped.
+func (d *FunnelAbandonmentDetector) RestoreState(data []byte) error {
+	var sessions map[string]*FunnelProgress
+	downtime, err := restoreProcessingTimeState(data, &sessions)
+	if err != nil {
+		return err
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for sessionID, progress := range sessions {
+		if _, ok := d.sessions[sessionID]; ok || progress == nil || progress.Event == nil {
+			continue
+		}
+		if progress.Step < 0 || progress.Step >= len(d.steps) {
+			continue
+		}
+		progress.ReachedAt = progress.ReachedAt.Add(downtime)
+		progress.LastSeen = progress.LastSeen.Add(downtime)
+		d.sessions[sessionID] = progress
+	}
+	return nil
+}
@@ -0,0 +1,105 @@
+package insights
+
+import (
+	"testing"
+	"time"
+
+	"github.com/gosight/gosight/processor/internal/config"
+)
+
+func newTestFunnelDetector() *FunnelAbandonmentDetector {
+	return NewFunnelAbandonmentDetector(config.FunnelAbandonmentConfig{
+		Enabled:        true,
+		Steps:          []string{"/cart", "/shipping", "/payment"},
+		ConversionPath: "/thanks",
+		MinStep:        2,
+		TimeoutMs:      60_000,
+	})
+}
+
+// visitPages sends a page view per path, each with the history so far
+func visitPages(d *FunnelAbandonmentDetector, tracker *PageTracker, sessionID string, paths ...string) {
+	for _, path := range paths {
+		event := &Event{
+			EventID:   sessionID + path,
+			ProjectID: "proj",
+			SessionID: sessionID,
+			URL:       "https://shop.example.com" + path,
+			Path:      path,
+			Timestamp: time.Now().UnixMilli(),
+		}
+		d.ProcessPageView(event, tracker.Visit(event))
+	}
+}
+
+func TestFunnelAbandonmentReportedOnce(t *testing.T) {
+	d := newTestFunnelDetector()
+	tracker := NewPageTracker()
+
+	visitPages(d, tracker, "s1", "/cart", "/shipping")
+	later := time.Now().Add(2 * time.Minute)
+	insights := d.Expire(later)
+	if len(insights) != 1 {
+		t.Fatalf("got %d insights, want 1", len(insights))
+	}
+	if step := insights[0].Details["abandoned_step"]; step != 2 {
+		t.Errorf("abandoned_step = %v, want 2", step)
+	}
+
+	// The history still holds the funnel steps, so further page views must
+	// not track the session again
+	visitPages(d, tracker, "s1", "/products", "/cart")
+	if insights := d.Expire(later.Add(2 * time.Minute)); len(insights) != 0 {
+		t.Errorf("got %d insights for an abandonment already reported", len(insights))
+	}
+}
+
+func TestFunnelAbandonmentConversionClearsReported(t *testing.T) {
+	d := newTestFunnelDetector()
+	tracker := NewPageTracker()
+
+	visitPages(d, tracker, "s1", "/cart", "/shipping")
+	if insights := d.Expire(time.Now().Add(2 * time.Minute)); len(insights) != 1 {
+		t.Fatalf("got %d insights, want 1", len(insights))
+	}
+
+	visitPages(d, tracker, "s1", "/thanks")
+	if _, ok := d.sessions["s1"]; ok {
+		t.Fatal("session still tracked after converting")
+	}
+
+	// A new trip through the funnel is tracked again
+	visitPages(d, tracker, "s1", "/cart", "/shipping", "/payment")
+	insights := d.Expire(time.Now().Add(2 * time.Minute))
+	if len(insights) != 1 {
+		t.Fatalf("got %d insights, want 1", len(insights))
+	}
+	if step := insights[0].Details["abandoned_step"]; step != 3 {
+		t.Errorf("abandoned_step = %v, want 3", step)
+	}
+}
+
+func TestFunnelAbandonmentForgetsEndedSessions(t *testing.T) {
+	d := newTestFunnelDetector()
+	tracker := NewPageTracker()
+
+	visitPages(d, tracker, "s1", "/cart", "/shipping")
+	d.Expire(time.Now().Add(2 * time.Minute))
+
+	if insights := d.Expire(time.Now().Add(funnelSessionIdle + time.Minute)); len(insights) != 0 {
+		t.Errorf("got %d insights when forgetting an ended session", len(insights))
+	}
+	if _, ok := d.sessions["s1"]; ok {
+		t.Error("ended session still tracked")
+	}
+}
+
+func TestFunnelAbandonmentBelowMinStep(t *testing.T) {
+	d := newTestFunnelDetector()
+	tracker := NewPageTracker()
+
+	visitPages(d, tracker, "s1", "/cart", "/payment")
+	if insights := d.Expire(time.Now().Add(2 * time.Minute)); len(insights) != 0 {
+		t.Errorf("got %d insights for a session that skipped a step", len(insights))
+	}
+}
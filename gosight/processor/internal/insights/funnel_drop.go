@@ -0,0 +1,185 @@
+package insights
+
+import (
+	"slices"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gosight/gosight/processor/internal/config"
+)
+
+// FunnelDropDetector detects sessions that stall on a step of a funnel: a
+// session entering the funnel at its first step must reach each next step
+// within the step timeout, until the last step (the goal). Unlike
+// FunnelAbandonmentDetector, every step is timed on its own and sessions are
+// tracked from the first step.
+type FunnelDropDetector struct {
+	steps         []string
+	stepTimeoutMs atomic.Int64
+	sessions      sync.Map // sessionID -> *FunnelStep
+}
+
+// FunnelStep is the funnel step a session is on
+type FunnelStep struct {
+	Event     *Event // page view that reached the step
+	Step      int    // 0-based index into the steps
+	ReachedAt time.Time
+	EventIDs  []string // page views of the steps reached
+	mu        sync.Mutex
+	// removed is set, under mu, once the step is deleted from the sessions;
+	// a page view that loaded it before then must look the session up again
+	removed bool
+}
+
+// NewFunnelDropDetector creates a new funnel drop detector
+func NewFunnelDropDetector(cfg config.FunnelDropConfig) *FunnelDropDetector {
+	d := &FunnelDropDetector{steps: cfg.Steps}
+	d.SetThresholds(cfg)
+	return d
+}
+
+// SetThresholds applies a new step timeout to the running detector; sessions
+// keep the step they are on
+func (d *FunnelDropDetector) SetThresholds(cfg config.FunnelDropConfig) {
+	d.stepTimeoutMs.Store(cfg.StepTimeoutMs)
+}
+
+// ProcessPageView advances the session to the next funnel step when the page
+// view is that step. Steps count only when visited in order: other pages,
+// including earlier or later steps, leave the session on its step. Reaching
+// the last step ends tracking of the session.
+func (d *FunnelDropDetector) ProcessPageView(event *Event) {
+	for {
+		value, ok := d.sessions.Load(event.SessionID)
+		if !ok {
+			if len(d.steps) < 2 || event.Path != d.steps[0] {
+				return
+			}
+			if _, loaded := d.sessions.LoadOrStore(event.SessionID, &FunnelStep{
+				Event:     event,
+				ReachedAt: time.Now(),
+				EventIDs:  []string{event.EventID},
+			}); !loaded {
+				return
+			}
+			// Another page view of the session was first; advance its step
+			continue
+		}
+
+		progress := value.(*FunnelStep)
+		if d.advance(progress, event) {
+			return
+		}
+	}
+}
+
+// advance moves the session on to the page view's step, if it is the next
+// one. It returns false when the step was removed since it was loaded.
+func (d *FunnelDropDetector) advance(progress *FunnelStep, event *Event) bool {
+	progress.mu.Lock()
+	defer progress.mu.Unlock()
+
+	if progress.removed {
+		return false
+	}
+	next := progress.Step + 1
+	if next >= len(d.steps) || event.Path != d.steps[next] {
+		return true
+	}
+	if next == len(d.steps)-1 {
+		progress.removed = true
+		d.sessions.Delete(event.SessionID)
+		return true
+	}
+	progress.Event = event
+	progress.Step = next
+	progress.ReachedAt = time.Now()
+	progress.EventIDs = append(progress.EventIDs, event.EventID)
+	return true
+}
+
+// Expire returns an insight for each session on its step for longer than the
+// step timeout, which stops tracking it
+func (d *FunnelDropDetector) Expire(now time.Time) []*Insight {
+	timeout := time.Duration(d.stepTimeoutMs.Load()) * time.Millisecond
+
+	var insights []*Insight
+	d.sessions.Range(func(key, value interface{}) bool {
+		progress := value.(*FunnelStep)
+		progress.mu.Lock()
+		defer progress.mu.Unlock()
+
+		if progress.removed || now.Sub(progress.ReachedAt) < timeout {
+			return true
+		}
+		progress.removed = true
+		d.sessions.Delete(key)
+
+		insights = append(insights, &Insight{
+			Type:      "funnel_drop",
+			ProjectID: progress.Event.ProjectID,
+			SessionID: progress.Event.SessionID,
+			Timestamp: now,
+			URL:       progress.Event.URL,
+			Path:      progress.Event.Path,
+			Details: map[string]interface{}{
+				"drop_step":       progress.Step + 1,
+				"drop_step_path":  d.steps[progress.Step],
+				"next_step_path":  d.steps[progress.Step+1],
+				"total_steps":     len(d.steps),
+				"time_on_step_ms": now.Sub(progress.ReachedAt).Milliseconds(),
+				"step_timeout_ms": timeout.Milliseconds(),
+			},
+			RelatedEventIDs: progress.EventIDs,
+			// The further into the funnel, the surer the session meant to
+			// reach the goal
+			Confidence: 0.5 + 0.5*float64(progress.Step+1)/float64(len(d.steps)),
+		})
+		return true
+	})
+
+	return insights
+}
+
+// SaveState returns the step of each session that reached it since cutoff,
+// as JSON
+func (d *FunnelDropDetector) SaveState(cutoff time.Time) ([]byte, error) {
+	sessions := make(map[string]*FunnelStep)
+	d.sessions.Range(func(key, value interface{}) bool {
+		progress := value.(*FunnelStep)
+		progress.mu.Lock()
+		defer progress.mu.Unlock()
+
+		if !progress.removed && !progress.ReachedAt.Before(cutoff) {
+			sessions[key.(string)] = &FunnelStep{
+				Event:     progress.Event,
+				Step:      progress.Step,
+				ReachedAt: progress.ReachedAt,
+				EventIDs:  slices.Clone(progress.EventIDs),
+			}
+		}
+		return true
+	})
+	return saveProcessingTimeState(sessions)
+}
+
+// RestoreState restores the steps returned by SaveState, the step timeouts
+// resuming where they were saved; sessions tracked since startup keep their
+// step. Steps without a next step in the configured funnel are skipped.
+func (d *FunnelDropDetector) RestoreState(data []byte) error {
+	var sessions map[string]*FunnelStep
+	downtime, err := restoreProcessingTimeState(data, &sessions)
+	if err != nil {
+		return err
+	}
+
+	for sessionID, progress := range sessions {
+		if progress == nil || progress.Event == nil || progress.Step < 0 || progress.Step+1 >= len(d.steps) {
+			continue
+		}
+		progress.ReachedAt = progress.ReachedAt.Add(downtime)
+		d.sessions.LoadOrStore(sessionID, progress)
+	}
+	return nil
+}
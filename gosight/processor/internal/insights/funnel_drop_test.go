@@ -0,0 +1,105 @@
+package insights
+
+import (
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/gosight/gosight/processor/internal/config"
+)
+
+func newTestFunnelDropDetector() *FunnelDropDetector {
+	return NewFunnelDropDetector(config.FunnelDropConfig{
+		Enabled:       true,
+		Steps:         []string{"/cart", "/shipping", "/payment", "/thanks"},
+		StepTimeoutMs: 60_000,
+	})
+}
+
+func funnelPageView(sessionID, path string) *Event {
+	return &Event{
+		EventID:   sessionID + path,
+		ProjectID: "proj",
+		SessionID: sessionID,
+		URL:       "https://shop.example.com" + path,
+		Path:      path,
+		Timestamp: time.Now().UnixMilli(),
+	}
+}
+
+func TestFunnelDropInterleavedSessions(t *testing.T) {
+	d := newTestFunnelDropDetector()
+
+	// Page views of four sessions, interleaved as they arrive
+	for _, view := range []struct{ session, path string }{
+		{"s1", "/cart"},
+		{"s2", "/cart"},
+		{"s3", "/shipping"}, // not in the funnel before its first step
+		{"s1", "/shipping"},
+		{"s2", "/payment"}, // skips a step
+		{"s3", "/cart"},
+		{"s4", "/cart"},
+		{"s1", "/payment"},
+		{"s2", "/shipping"},
+		{"s4", "/shipping"},
+		{"s4", "/payment"},
+		{"s4", "/thanks"}, // reaches the goal
+	} {
+		d.ProcessPageView(funnelPageView(view.session, view.path))
+	}
+
+	want := map[string]struct {
+		step     int
+		next     string
+		eventIDs []string
+	}{
+		"s1": {3, "/thanks", []string{"s1/cart", "s1/shipping", "s1/payment"}},
+		"s2": {2, "/payment", []string{"s2/cart", "s2/shipping"}},
+		"s3": {1, "/shipping", []string{"s3/cart"}},
+	}
+
+	insights := d.Expire(time.Now().Add(2 * time.Minute))
+	if len(insights) != len(want) {
+		t.Fatalf("got %d insights, want %d", len(insights), len(want))
+	}
+	for _, insight := range insights {
+		w, ok := want[insight.SessionID]
+		if !ok {
+			t.Errorf("unexpected insight for %s", insight.SessionID)
+			continue
+		}
+		if step := insight.Details["drop_step"]; step != w.step {
+			t.Errorf("%s: drop_step = %v, want %d", insight.SessionID, step, w.step)
+		}
+		if next := insight.Details["next_step_path"]; next != w.next {
+			t.Errorf("%s: next_step_path = %v, want %s", insight.SessionID, next, w.next)
+		}
+		if !slices.Equal(insight.RelatedEventIDs, w.eventIDs) {
+			t.Errorf("%s: related events = %v, want %v", insight.SessionID, insight.RelatedEventIDs, w.eventIDs)
+		}
+	}
+}
+
+func TestFunnelDropPageViewAfterExpire(t *testing.T) {
+	d := newTestFunnelDropDetector()
+	d.ProcessPageView(funnelPageView("s1", "/cart"))
+
+	// A page view that loaded the step just before it expired must not
+	// advance the reported step
+	value, _ := d.sessions.Load("s1")
+	if insights := d.Expire(time.Now().Add(2 * time.Minute)); len(insights) != 1 {
+		t.Fatalf("got %d insights, want 1", len(insights))
+	}
+	if d.advance(value.(*FunnelStep), funnelPageView("s1", "/shipping")) {
+		t.Error("advanced a step removed by Expire")
+	}
+	if _, ok := d.sessions.Load("s1"); ok {
+		t.Error("session tracked again after its drop was reported")
+	}
+
+	// Entering the funnel again starts over
+	d.ProcessPageView(funnelPageView("s1", "/cart"))
+	if value, ok := d.sessions.Load("s1"); !ok || value.(*FunnelStep).Step != 0 {
+		t.Error("session not tracked from the first step again")
+	}
+}
@@ -0,0 +1,161 @@
+package insights
+
+import (
+	"encoding/json"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gosight/gosight/processor/internal/config"
+)
+
+// LongTaskDetector detects pages blocking the main thread: long tasks (over
+// 50ms, as reported by PerformanceLongTaskTiming) adding up to a total
+// blocking time above the threshold within the window. Pages like these feel
+// janky and usually have a poor INP.
+type LongTaskDetector struct {
+	thresholds  atomic.Pointer[longTaskThresholds]
+	sessionData sync.Map // sessionID -> *LongTaskTrackingData
+}
+
+// longTaskThresholds are the settings of LongTaskDetector reloadable at runtime
+type longTaskThresholds struct {
+	totalBlockingTimeMs int64
+	windowMs            int64
+}
+
+// LongTaskTrackingData tracks long tasks on the session's current page
+type LongTaskTrackingData struct {
+	Path     string
+	Tasks    []LongTask
+	EventIDs []string
+	mu       sync.Mutex
+}
+
+// LongTask is a long task that ended at the given time
+type LongTask struct {
+	DurationMs float64
+	Timestamp  int64
+}
+
+// NewLongTaskDetector creates a new long task detector
+func NewLongTaskDetector(cfg config.LongTaskConfig) *LongTaskDetector {
+	d := &LongTaskDetector{}
+	d.SetThresholds(cfg)
+	return d
+}
+
+// SetThresholds applies new thresholds to the running detector; long tasks
+// already tracked are kept
+func (d *LongTaskDetector) SetThresholds(cfg config.LongTaskConfig) {
+	d.thresholds.Store(&longTaskThresholds{
+		totalBlockingTimeMs: cfg.TotalBlockingTimeThresholdMs,
+		windowMs:            cfg.WindowMs,
+	})
+}
+
+// ProcessLongTask processes a long task event. Tasks are aggregated per
+// session and page; once reported, the page's tasks start over.
+func (d *LongTaskDetector) ProcessLongTask(event *Event) *Insight {
+	if event.LongTaskDurationMs <= 0 {
+		return nil
+	}
+
+	dataI, _ := d.sessionData.LoadOrStore(event.SessionID, &LongTaskTrackingData{})
+	data := dataI.(*LongTaskTrackingData)
+
+	data.mu.Lock()
+	defer data.mu.Unlock()
+
+	// Long tasks are tracked per page
+	if data.Path != event.Path {
+		data.Path = event.Path
+		data.reset()
+	}
+
+	data.Tasks = append(data.Tasks, LongTask{DurationMs: event.LongTaskDurationMs, Timestamp: event.Timestamp})
+	data.EventIDs = append(data.EventIDs, event.EventID)
+
+	// Keep the window
+	t := d.thresholds.Load()
+	cutoff := event.Timestamp - t.windowMs
+	for len(data.Tasks) > 0 && data.Tasks[0].Timestamp < cutoff {
+		data.Tasks = data.Tasks[1:]
+		data.EventIDs = data.EventIDs[1:]
+	}
+
+	total, longest := 0.0, 0.0
+	for _, task := range data.Tasks {
+		total += task.DurationMs
+		longest = max(longest, task.DurationMs)
+	}
+	if total < float64(t.totalBlockingTimeMs) {
+		return nil
+	}
+
+	taskCount := len(data.Tasks)
+	eventIDs := data.EventIDs
+	data.reset()
+
+	return &Insight{
+		Type:      "long_task",
+		ProjectID: event.ProjectID,
+		SessionID: event.SessionID,
+		Timestamp: time.Now(),
+		URL:       event.URL,
+		Path:      event.Path,
+		Details: map[string]interface{}{
+			"total_blocking_time_ms": total,
+			"task_count":             taskCount,
+			"longest_ms":             longest,
+			"window_ms":              t.windowMs,
+			"page":                   event.Path,
+		},
+		RelatedEventIDs: eventIDs,
+		// How far the blocking time went over total_blocking_time_ms
+		Confidence: overThresholdConfidence(total, float64(t.totalBlockingTimeMs)),
+	}
+}
+
+// SaveState returns the long tasks tracked per session, as JSON, leaving out
+// sessions without long tasks since cutoff
+func (d *LongTaskDetector) SaveState(cutoff time.Time) ([]byte, error) {
+	state := make(map[string]*LongTaskTrackingData)
+	d.sessionData.Range(func(key, value interface{}) bool {
+		data := value.(*LongTaskTrackingData)
+		data.mu.Lock()
+		defer data.mu.Unlock()
+
+		if n := len(data.Tasks); n > 0 && data.Tasks[n-1].Timestamp >= cutoff.UnixMilli() {
+			state[key.(string)] = &LongTaskTrackingData{
+				Path:     data.Path,
+				Tasks:    slices.Clone(data.Tasks),
+				EventIDs: slices.Clone(data.EventIDs),
+			}
+		}
+		return true
+	})
+	return json.Marshal(state)
+}
+
+// RestoreState restores the state returned by SaveState; sessions already
+// tracked keep their state
+func (d *LongTaskDetector) RestoreState(data []byte) error {
+	var state map[string]*LongTaskTrackingData
+	if err := json.Unmarshal(data, &state); err != nil {
+		return err
+	}
+	for sessionID, tracking := range state {
+		if tracking == nil || len(tracking.Tasks) != len(tracking.EventIDs) {
+			continue
+		}
+		d.sessionData.LoadOrStore(sessionID, tracking)
+	}
+	return nil
+}
+
+func (t *LongTaskTrackingData) reset() {
+	t.Tasks = nil
+	t.EventIDs = nil
+}
@@ -0,0 +1,248 @@
+package insights
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gosight/gosight/processor/internal/config"
+	"github.com/gosight/gosight/processor/internal/eventtype"
+)
+
+// MediaAbandonmentDetector detects video or audio playback abandoned early: a
+// session starts playing a media element, then leaves the page or stops
+// sending media events for the inactivity timeout without it ending and with
+// less than the minimum percentage watched. Early abandonment points at poor
+// content or at a playback problem (buffering, errors).
+type MediaAbandonmentDetector struct {
+	thresholds atomic.Pointer[mediaAbandonmentThresholds]
+	sessions   map[string]map[string]*MediaPlayback // sessionID -> media ID -> playback
+	mu         sync.Mutex
+}
+
+// mediaAbandonmentThresholds are the settings of MediaAbandonmentDetector
+// reloadable at runtime
+type mediaAbandonmentThresholds struct {
+	minWatchPercent float64
+	inactivity      time.Duration
+}
+
+// MediaPlayback tracks the playback of a media element in a session
+type MediaPlayback struct {
+	MediaID     string
+	ProjectID   string
+	URL         string
+	Path        string
+	MaxPosition float64 // furthest position reached, in seconds
+	Duration    float64 // in seconds; 0 until reported
+	EventIDs    []string
+	LastSeen    time.Time
+}
+
+// NewMediaAbandonmentDetector creates a new media abandonment detector
+func NewMediaAbandonmentDetector(cfg config.MediaAbandonmentConfig) *MediaAbandonmentDetector {
+	d := &MediaAbandonmentDetector{
+		sessions: make(map[string]map[string]*MediaPlayback),
+	}
+	d.SetThresholds(cfg)
+	return d
+}
+
+// SetThresholds applies new thresholds to the running detector; playbacks
+// already tracked are kept
+func (d *MediaAbandonmentDetector) SetThresholds(cfg config.MediaAbandonmentConfig) {
+	d.thresholds.Store(&mediaAbandonmentThresholds{
+		minWatchPercent: cfg.MinWatchPercent,
+		inactivity:      time.Duration(cfg.InactivityTimeoutMs) * time.Millisecond,
+	})
+}
+
+// ProcessMedia tracks a media_play, media_pause or media_ended event. Playback
+// is tracked from its first media_play; media_ended completes it.
+func (d *MediaAbandonmentDetector) ProcessMedia(event *Event, eventType eventtype.Type) {
+	if event.MediaID == "" {
+		return
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	media := d.sessions[event.SessionID]
+	if eventType == eventtype.MediaEnded {
+		delete(media, event.MediaID)
+		if len(media) == 0 {
+			delete(d.sessions, event.SessionID)
+		}
+		return
+	}
+
+	playback, ok := media[event.MediaID]
+	if !ok {
+		if eventType != eventtype.MediaPlay {
+			return
+		}
+		if media == nil {
+			media = make(map[string]*MediaPlayback)
+			d.sessions[event.SessionID] = media
+		}
+		playback = &MediaPlayback{
+			MediaID:   event.MediaID,
+			ProjectID: event.ProjectID,
+			URL:       event.URL,
+			Path:      event.Path,
+		}
+		media[event.MediaID] = playback
+	}
+
+	playback.MaxPosition = max(playback.MaxPosition, event.MediaPosition)
+	if event.MediaDuration > 0 {
+		playback.Duration = event.MediaDuration
+	}
+	playback.EventIDs = append(playback.EventIDs, event.EventID)
+	playback.LastSeen = time.Now()
+}
+
+// ProcessPageLeave reports the session's playbacks on the page it leaves,
+// on a page view of another page or a page exit
+func (d *MediaAbandonmentDetector) ProcessPageLeave(event *Event, eventType eventtype.Type) []*Insight {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	media, ok := d.sessions[event.SessionID]
+	if !ok {
+		return nil
+	}
+
+	var insights []*Insight
+	for mediaID, playback := range media {
+		if eventType == eventtype.PageView && playback.Path == event.Path {
+			continue
+		}
+		delete(media, mediaID)
+		if insight := d.abandonment(event.SessionID, playback, string(eventType)); insight != nil {
+			insight.RelatedEventIDs = append(insight.RelatedEventIDs, event.EventID)
+			insights = append(insights, insight)
+		}
+	}
+	if len(media) == 0 {
+		delete(d.sessions, event.SessionID)
+	}
+	return insights
+}
+
+// Expire reports the playbacks without media events for the inactivity timeout
+func (d *MediaAbandonmentDetector) Expire(now time.Time) []*Insight {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	inactivity := d.thresholds.Load().inactivity
+	var insights []*Insight
+	for sessionID, media := range d.sessions {
+		for mediaID, playback := range media {
+			if now.Sub(playback.LastSeen) < inactivity {
+				continue
+			}
+			delete(media, mediaID)
+			if insight := d.abandonment(sessionID, playback, "inactive"); insight != nil {
+				insights = append(insights, insight)
+			}
+		}
+		if len(media) == 0 {
+			delete(d.sessions, sessionID)
+		}
+	}
+	return insights
+}
+
+// SaveState returns the playbacks with media events since cutoff, as JSON
+func (d *MediaAbandonmentDetector) SaveState(cutoff time.Time) ([]byte, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	sessions := make(map[string]map[string]*MediaPlayback, len(d.sessions))
+	for sessionID, media := range d.sessions {
+		for mediaID, playback := range media {
+			if playback.LastSeen.Before(cutoff) {
+				continue
+			}
+			if sessions[sessionID] == nil {
+				sessions[sessionID] = make(map[string]*MediaPlayback)
+			}
+			sessions[sessionID][mediaID] = playback
+		}
+	}
+	return saveProcessingTimeState(sessions)
+}
+
+// RestoreState restores the playbacks returned by SaveState, their inactivity
+// timeouts resuming where they were saved; playbacks tracked since startup
+// are kept
+func (d *MediaAbandonmentDetector) RestoreState(data []byte) error {
+	var sessions map[string]map[string]*MediaPlayback
+	downtime, err := restoreProcessingTimeState(data, &sessions)
+	if err != nil {
+		return err
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for sessionID, restored := range sessions {
+		for mediaID, playback := range restored {
+			if playback == nil {
+				continue
+			}
+			media := d.sessions[sessionID]
+			if _, ok := media[mediaID]; ok {
+				continue
+			}
+			if media == nil {
+				media = make(map[string]*MediaPlayback)
+				d.sessions[sessionID] = media
+			}
+			playback.LastSeen = playback.LastSeen.Add(downtime)
+			media[mediaID] = playback
+		}
+	}
+	return nil
+}
+
+// abandonment returns the insight of a playback that stopped, or nil when
+// enough of it was watched or its duration is unknown. Its confidence falls
+// from 1 for a playback left at the start to 0.5 at min_watch_percent, and is
+// lower when the playback stopped with no media event rather than on leaving
+// the page.
+func (d *MediaAbandonmentDetector) abandonment(sessionID string, playback *MediaPlayback, reason string) *Insight {
+	if playback.Duration <= 0 {
+		return nil
+	}
+	minWatchPercent := d.thresholds.Load().minWatchPercent
+	watchedPercent := min(playback.MaxPosition/playback.Duration*100, 100)
+	if watchedPercent >= minWatchPercent {
+		return nil
+	}
+
+	confidence := underThresholdConfidence(watchedPercent, minWatchPercent)
+	if reason == "inactive" {
+		confidence *= inactiveConfidenceFactor
+	}
+
+	return &Insight{
+		Type:      "media_abandonment",
+		ProjectID: playback.ProjectID,
+		SessionID: sessionID,
+		Timestamp: time.Now(),
+		URL:       playback.URL,
+		Path:      playback.Path,
+		Details: map[string]interface{}{
+			"media_id":           playback.MediaID,
+			"watched_percent":    watchedPercent,
+			"position_s":         playback.MaxPosition,
+			"duration_s":         playback.Duration,
+			"min_watch_percent":  minWatchPercent,
+			"abandonment_reason": reason,
+			"page":               playback.Path,
+		},
+		RelatedEventIDs: playback.EventIDs,
+		Confidence:      confidence,
+	}
+}
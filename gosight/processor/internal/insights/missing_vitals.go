@@ -0,0 +1,186 @@
+package insights
+
+import (
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gosight/gosight/processor/internal/config"
+)
+
+// MissingVitalsDetector detects pages whose web vitals never include some expected
+// metrics (e.g. no LCP because the page never paints). Only pages that report at
+// least one web vitals event are observed, so pages without vitals collection
+// are not flagged.
+type MissingVitalsDetector struct {
+	expected    []string
+	observation time.Duration
+	pages       map[string]*PageVitals // sessionID:path -> reported vitals
+	mu          sync.Mutex
+}
+
+// PageVitals tracks the web vitals reported for a page within a session
+type PageVitals struct {
+	Event     *Event // first web vitals event of the page
+	Reported  map[string]bool
+	EventIDs  []string
+	FirstSeen time.Time
+}
+
+// NewMissingVitalsDetector creates a new missing vitals detector
+func NewMissingVitalsDetector(cfg config.MissingVitalsConfig) *MissingVitalsDetector {
+	expected := make([]string, len(cfg.ExpectedMetrics))
+	for i, metric := range cfg.ExpectedMetrics {
+		expected[i] = strings.ToUpper(metric)
+	}
+
+	return &MissingVitalsDetector{
+		expected:    expected,
+		observation: time.Duration(cfg.ObservationWindowMs) * time.Millisecond,
+		pages:       make(map[string]*PageVitals),
+	}
+}
+
+// SetThresholds applies a new observation window to the running detector;
+// pages already observed are kept
+func (d *MissingVitalsDetector) SetThresholds(cfg config.MissingVitalsConfig) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.observation = time.Duration(cfg.ObservationWindowMs) * time.Millisecond
+}
+
+// ProcessVitals records the metrics reported by a web vitals event
+func (d *MissingVitalsDetector) ProcessVitals(event *Event) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	key := event.SessionID + ":" + event.Path
+	page, ok := d.pages[key]
+	if !ok {
+		page = &PageVitals{
+			Event:     event,
+			Reported:  make(map[string]bool),
+			FirstSeen: time.Now(),
+		}
+		d.pages[key] = page
+	}
+
+	for _, metric := range reportedMetrics(event) {
+		page.Reported[metric] = true
+	}
+	page.EventIDs = append(page.EventIDs, event.EventID)
+}
+
+// Expire closes pages observed for longer than the observation window and
+// returns an insight for each one still missing expected metrics
+func (d *MissingVitalsDetector) Expire(now time.Time) []*Insight {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	var insights []*Insight
+	for key, page := range d.pages {
+		if now.Sub(page.FirstSeen) < d.observation {
+			continue
+		}
+		delete(d.pages, key)
+
+		var missing []string
+		for _, metric := range d.expected {
+			if !page.Reported[metric] {
+				missing = append(missing, metric)
+			}
+		}
+		if len(missing) == 0 {
+			continue
+		}
+
+		reported := make([]string, 0, len(page.Reported))
+		for metric := range page.Reported {
+			reported = append(reported, metric)
+		}
+
+		insights = append(insights, &Insight{
+			Type:      "missing_vitals",
+			ProjectID: page.Event.ProjectID,
+			SessionID: page.Event.SessionID,
+			Timestamp: now,
+			URL:       page.Event.URL,
+			Path:      page.Event.Path,
+			Details: map[string]interface{}{
+				"missing_metrics":  missing,
+				"reported_metrics": reported,
+				"vitals_events":    len(page.EventIDs),
+				"observed_ms":      now.Sub(page.FirstSeen).Milliseconds(),
+			},
+			RelatedEventIDs: page.EventIDs,
+			// A single missing metric may just be unsupported by the browser;
+			// the more are missing, the likelier collection is broken
+			Confidence: 0.5 + 0.5*float64(len(missing))/float64(len(d.expected)),
+		})
+	}
+
+	return insights
+}
+
+// SaveState returns the pages observed since cutoff, as JSON
+func (d *MissingVitalsDetector) SaveState(cutoff time.Time) ([]byte, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	pages := make(map[string]*PageVitals, len(d.pages))
+	for key, page := range d.pages {
+		if !page.FirstSeen.Before(cutoff) {
+			pages[key] = page
+		}
+	}
+	return saveProcessingTimeState(pages)
+}
+
+// RestoreState restores the pages returned by SaveState, their observation
+// windows resuming where they were saved; pages observed since startup keep
+// their window
+func (d *MissingVitalsDetector) RestoreState(data []byte) error {
+	var pages map[string]*PageVitals
+	downtime, err := restoreProcessingTimeState(data, &pages)
+	if err != nil {
+		return err
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for key, page := range pages {
+		if _, ok := d.pages[key]; ok || page == nil || page.Event == nil {
+			continue
+		}
+		if page.Reported == nil {
+			page.Reported = make(map[string]bool)
+		}
+		page.FirstSeen = page.FirstSeen.Add(downtime)
+		d.pages[key] = page
+	}
+	return nil
+}
+
+// reportedMetrics lists the metrics present on a web vitals event
+func reportedMetrics(event *Event) []string {
+	var metrics []string
+	if event.LCP != nil {
+		metrics = append(metrics, "LCP")
+	}
+	if event.FID != nil {
+		metrics = append(metrics, "FID")
+	}
+	if event.CLS != nil {
+		metrics = append(metrics, "CLS")
+	}
+	if event.TTFB != nil {
+		metrics = append(metrics, "TTFB")
+	}
+	if event.FCP != nil {
+		metrics = append(metrics, "FCP")
+	}
+	if event.INP != nil {
+		metrics = append(metrics, "INP")
+	}
+	return metrics
+}
@@ -0,0 +1,132 @@
+package insights
+
+import (
+	"encoding/json"
+	"sync"
+	"time"
+)
+
+// maxPageHistory bounds the page history kept per session
+const maxPageHistory = 20
+
+// pageSessionIdle is how long a session goes without a page view before its
+// history is forgotten
+const pageSessionIdle = 30 * time.Minute
+
+// PageTracker records page navigation history per session, shared by the
+// detectors that look at navigation patterns
+type PageTracker struct {
+	sessionPages sync.Map // sessionID -> *PageHistory
+}
+
+// PageHistory tracks page navigation history per session
+type PageHistory struct {
+	Pages    []PageVisit
+	lastSeen time.Time // processing time of the last page view
+	// removed is set, under mu, once the history is deleted from the sessions;
+	// a page view that loaded it before then must look the session up again
+	removed bool
+	mu      sync.Mutex
+}
+
+// PageVisit represents a page visit
+type PageVisit struct {
+	URL       string
+	Path      string
+	Timestamp int64
+	EventID   string
+}
+
+// NewPageTracker creates a new page tracker
+func NewPageTracker() *PageTracker {
+	return &PageTracker{}
+}
+
+// Visit records a page view and returns the session's history, ending with this visit
+func (t *PageTracker) Visit(event *Event) []PageVisit {
+	for {
+		// Get or create session history
+		historyI, _ := t.sessionPages.LoadOrStore(event.SessionID, &PageHistory{
+			Pages: make([]PageVisit, 0, maxPageHistory),
+		})
+		if pages, ok := historyI.(*PageHistory).visit(event); ok {
+			return pages
+		}
+	}
+}
+
+// visit appends the page view to the history and returns a copy of it; it
+// returns false when the history was removed since it was loaded
+func (history *PageHistory) visit(event *Event) ([]PageVisit, bool) {
+	history.mu.Lock()
+	defer history.mu.Unlock()
+
+	if history.removed {
+		return nil, false
+	}
+	history.lastSeen = time.Now()
+	history.Pages = append(history.Pages, PageVisit{
+		URL:       event.URL,
+		Path:      event.Path,
+		Timestamp: event.Timestamp,
+		EventID:   event.EventID,
+	})
+
+	// Keep history bounded
+	if len(history.Pages) > maxPageHistory {
+		history.Pages = history.Pages[len(history.Pages)-maxPageHistory:]
+	}
+
+	pages := make([]PageVisit, len(history.Pages))
+	copy(pages, history.Pages)
+	return pages, true
+}
+
+// Expire forgets the history of sessions without a page view for
+// pageSessionIdle
+func (t *PageTracker) Expire(now time.Time) {
+	t.sessionPages.Range(func(key, value interface{}) bool {
+		history := value.(*PageHistory)
+		history.mu.Lock()
+		defer history.mu.Unlock()
+
+		if now.Sub(history.lastSeen) >= pageSessionIdle {
+			history.removed = true
+			t.sessionPages.Delete(key)
+		}
+		return true
+	})
+}
+
+// SaveState returns the page history of each session, as JSON, leaving out
+// sessions without a page view since cutoff
+func (t *PageTracker) SaveState(cutoff time.Time) ([]byte, error) {
+	state := make(map[string][]PageVisit)
+	t.sessionPages.Range(func(key, value interface{}) bool {
+		history := value.(*PageHistory)
+		history.mu.Lock()
+		defer history.mu.Unlock()
+
+		if n := len(history.Pages); !history.removed && n > 0 && history.Pages[n-1].Timestamp >= cutoff.UnixMilli() {
+			pages := make([]PageVisit, n)
+			copy(pages, history.Pages)
+			state[key.(string)] = pages
+		}
+		return true
+	})
+	return json.Marshal(state)
+}
+
+// RestoreState restores the state returned by SaveState; sessions already
+// tracked keep their history. Restored sessions go idle counting from now.
+func (t *PageTracker) RestoreState(data []byte) error {
+	var state map[string][]PageVisit
+	if err := json.Unmarshal(data, &state); err != nil {
+		return err
+	}
+	now := time.Now()
+	for sessionID, pages := range state {
+		t.sessionPages.LoadOrStore(sessionID, &PageHistory{Pages: pages, lastSeen: now})
+	}
+	return nil
+}
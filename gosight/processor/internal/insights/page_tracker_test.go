@@ -0,0 +1,52 @@
+package insights
+
+import (
+	"testing"
+	"time"
+)
+
+func TestPageTrackerExpiresIdleSessions(t *testing.T) {
+	tracker := NewPageTracker()
+	view := func(sessionID, path string) []PageVisit {
+		return tracker.Visit(&Event{EventID: sessionID + path, SessionID: sessionID, Path: path, Timestamp: time.Now().UnixMilli()})
+	}
+
+	view("idle", "/a")
+	view("idle", "/b")
+	view("active", "/a")
+
+	// Only the active session has a page view after the idle one went quiet
+	later := time.Now().Add(pageSessionIdle)
+	history, _ := tracker.sessionPages.Load("active")
+	history.(*PageHistory).lastSeen = later.Add(-time.Minute)
+
+	tracker.Expire(later)
+	if _, ok := tracker.sessionPages.Load("idle"); ok {
+		t.Error("idle session still tracked")
+	}
+	if pages := view("active", "/b"); len(pages) != 2 {
+		t.Errorf("active session history = %v, want both page views", pages)
+	}
+
+	// A session back after expiry starts a new history
+	if pages := view("idle", "/c"); len(pages) != 1 || pages[0].Path != "/c" {
+		t.Errorf("history after expiry = %v, want only /c", pages)
+	}
+}
+
+func TestPageTrackerVisitAfterExpire(t *testing.T) {
+	tracker := NewPageTracker()
+	event := &Event{EventID: "e1", SessionID: "sess", Path: "/a"}
+	tracker.Visit(event)
+
+	// A page view that loaded the history just before it expired records
+	// into a new history rather than the removed one
+	history, _ := tracker.sessionPages.Load("sess")
+	tracker.Expire(time.Now().Add(pageSessionIdle))
+	if _, ok := history.(*PageHistory).visit(event); ok {
+		t.Fatal("visited a removed history")
+	}
+	if pages := tracker.Visit(event); len(pages) != 1 {
+		t.Errorf("history = %v, want the page view in a new history", pages)
+	}
+}
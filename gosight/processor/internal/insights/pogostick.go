@@ -0,0 +1,195 @@
+package insights
+
+import (
+	"maps"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gosight/gosight/processor/internal/config"
+)
+
+// PogostickDetector detects users bouncing between a hub page (search results,
+// a listing) and different items: A -> B -> A -> C -> A -> D. Each hub is
+// reported once per session.
+type PogostickDetector struct {
+	thresholds atomic.Pointer[pogostickThresholds]
+	sessions   map[string]*PogostickSession // sessionID -> reported hubs
+	mu         sync.Mutex
+}
+
+// PogostickSession records the hubs reported for a session
+type PogostickSession struct {
+	Hubs     map[string]bool
+	LastSeen time.Time // processing time of the last page view
+}
+
+// pogostickThresholds are the settings of PogostickDetector reloadable at runtime
+type pogostickThresholds struct {
+	minReturns int
+	windowMs   int64
+}
+
+// NewPogostickDetector creates a new pogostick detector
+func NewPogostickDetector(cfg config.PogostickConfig) *PogostickDetector {
+	d := &PogostickDetector{sessions: make(map[string]*PogostickSession)}
+	d.SetThresholds(cfg)
+	return d
+}
+
+// SetThresholds applies new thresholds to the running detector
+func (d *PogostickDetector) SetThresholds(cfg config.PogostickConfig) {
+	d.thresholds.Store(&pogostickThresholds{
+		minReturns: cfg.MinReturns,
+		windowMs:   cfg.WindowMs,
+	})
+}
+
+// ProcessPageView detects pogosticking given the session's page history, which
+// ends with the current page view. The current page is the candidate hub; each
+// earlier hub visit within windowMs followed by other pages counts as a return.
+// An insight is emitted when the returns reach minReturns, unless the hub was
+// already reported for the session.
+func (d *PogostickDetector) ProcessPageView(event *Event, pages []PageVisit) *Insight {
+	if len(pages) == 0 {
+		return nil
+	}
+	d.seen(event.SessionID)
+
+	current := pages[len(pages)-1]
+	hub := current.Path
+	t := d.thresholds.Load()
+
+	// Visits within the window
+	start := len(pages) - 1
+	for start > 0 && current.Timestamp-pages[start-1].Timestamp <= t.windowMs {
+		start--
+	}
+	visits := pages[start:]
+
+	// Skip to the first hub visit in the window
+	first := -1
+	for i, visit := range visits {
+		if visit.Path == hub {
+			first = i
+			break
+		}
+	}
+
+	returns := 0
+	var spokes []string
+	eventIDs := []string{visits[first].EventID}
+	for i := first + 1; i < len(visits); i++ {
+		visit := visits[i]
+		eventIDs = append(eventIDs, visit.EventID)
+		if visit.Path != hub {
+			spokes = append(spokes, visit.Path)
+			continue
+		}
+		// Back on the hub after visiting something else (reloads do not count)
+		if visits[i-1].Path != hub {
+			returns++
+		}
+	}
+
+	if returns < t.minReturns || !d.report(event.SessionID, hub) {
+		return nil
+	}
+
+	duration := current.Timestamp - visits[first].Timestamp
+	return &Insight{
+		Type:      "pogostick",
+		ProjectID: event.ProjectID,
+		SessionID: event.SessionID,
+		Timestamp: time.Now(),
+		URL:       event.URL,
+		Path:      event.Path,
+		Details: map[string]interface{}{
+			"hub_page":     hub,
+			"return_count": returns,
+			"spokes":       spokes,
+			"duration_ms":  duration,
+		},
+		RelatedEventIDs: eventIDs,
+		// The faster the returns came, the likelier the spokes disappointed:
+		// 0.5 when they took the whole window
+		Confidence: underThresholdConfidence(float64(duration), float64(t.windowMs)),
+	}
+}
+
+// seen records a page view of a session with reported hubs, which keeps them
+func (d *PogostickDetector) seen(sessionID string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if session, ok := d.sessions[sessionID]; ok {
+		session.LastSeen = time.Now()
+	}
+}
+
+// report marks the hub reported for the session, returning false if it
+// already was
+func (d *PogostickDetector) report(sessionID, hub string) bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	session, ok := d.sessions[sessionID]
+	if !ok {
+		session = &PogostickSession{Hubs: make(map[string]bool), LastSeen: time.Now()}
+		d.sessions[sessionID] = session
+	}
+	if session.Hubs[hub] {
+		return false
+	}
+	session.Hubs[hub] = true
+	return true
+}
+
+// Expire forgets the reported hubs of sessions without a page view for
+// pageSessionIdle
+func (d *PogostickDetector) Expire(now time.Time) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	for sessionID, session := range d.sessions {
+		if now.Sub(session.LastSeen) >= pageSessionIdle {
+			delete(d.sessions, sessionID)
+		}
+	}
+}
+
+// SaveState returns the reported hubs of sessions with a page view since
+// cutoff, as JSON
+func (d *PogostickDetector) SaveState(cutoff time.Time) ([]byte, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	sessions := make(map[string]*PogostickSession, len(d.sessions))
+	for sessionID, session := range d.sessions {
+		if !session.LastSeen.Before(cutoff) {
+			sessions[sessionID] = &PogostickSession{Hubs: maps.Clone(session.Hubs), LastSeen: session.LastSeen}
+		}
+	}
+	return saveProcessingTimeState(sessions)
+}
+
+// RestoreState restores the reported hubs returned by SaveState; sessions
+// tracked since startup keep theirs
+func (d *PogostickDetector) RestoreState(data []byte) error {
+	var sessions map[string]*PogostickSession
+	downtime, err := restoreProcessingTimeState(data, &sessions)
+	if err != nil {
+		return err
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for sessionID, session := range sessions {
+		if _, ok := d.sessions[sessionID]; ok || session == nil || session.Hubs == nil {
+			continue
+		}
+		session.LastSeen = session.LastSeen.Add(downtime)
+		d.sessions[sessionID] = session
+	}
+	return nil
+}
@@ -0,0 +1,64 @@
+package insights
+
+import (
+	"testing"
+	"time"
+
+	"github.com/gosight/gosight/processor/internal/config"
+)
+
+func TestPogostickReportsEachHubOncePerSession(t *testing.T) {
+	d := NewPogostickDetector(config.PogostickConfig{Enabled: true, MinReturns: 2, WindowMs: 10_000})
+	tracker := NewPageTracker()
+
+	reports := make(map[string]int) // sessionID:hub -> insights
+	visit := func(sessionID, path string, at int64) {
+		event := &Event{EventID: sessionID + path, ProjectID: "proj", SessionID: sessionID, Path: path, Timestamp: at}
+		if insight := d.ProcessPageView(event, tracker.Visit(event)); insight != nil {
+			reports[sessionID+":"+insight.Details["hub_page"].(string)]++
+		}
+	}
+
+	// Two returns to the results page
+	visit("s1", "/search", 0)
+	visit("s1", "/item/a", 1000)
+	visit("s1", "/search", 2000)
+	visit("s1", "/item/b", 3000)
+	visit("s1", "/search", 4000)
+	// After a pause the first returns leave the window, and the returns in
+	// it reach the threshold again
+	visit("s1", "/item/c", 20_000)
+	visit("s1", "/search", 21_000)
+	visit("s1", "/item/d", 22_000)
+	visit("s1", "/search", 23_000)
+	visit("s1", "/item/e", 24_000)
+	visit("s1", "/search", 25_000)
+	// Another hub of the session is reported on its own
+	visit("s1", "/category", 26_000)
+	visit("s1", "/item/f", 27_000)
+	visit("s1", "/category", 28_000)
+	visit("s1", "/item/g", 29_000)
+	visit("s1", "/category", 30_000)
+	// As is the same hub in another session
+	visit("s2", "/search", 0)
+	visit("s2", "/item/a", 1000)
+	visit("s2", "/search", 2000)
+	visit("s2", "/item/b", 3000)
+	visit("s2", "/search", 4000)
+
+	want := map[string]int{"s1:/search": 1, "s1:/category": 1, "s2:/search": 1}
+	if len(reports) != len(want) {
+		t.Errorf("reports = %v, want %v", reports, want)
+	}
+	for key, n := range want {
+		if reports[key] != n {
+			t.Errorf("%s reported %d times, want %d", key, reports[key], n)
+		}
+	}
+
+	// Reported hubs are forgotten once the session goes idle
+	d.Expire(time.Now().Add(pageSessionIdle))
+	if len(d.sessions) != 0 {
+		t.Errorf("%d sessions kept past the idle timeout", len(d.sessions))
+	}
+}
@@ -0,0 +1,136 @@
+package insights
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"github.com/segmentio/kafka-go"
+
+	"github.com/gosight/gosight/processor/internal/config"
+	"github.com/gosight/gosight/processor/internal/kafkaauth"
+	"github.com/gosight/gosight/processor/internal/storage"
+)
+
+// priorityPath is the fast path for insights of priority types: their alerts
+// are published synchronously and their rows are written from a small buffer
+// flushed every flush_interval instead of the 5s batch. Insights reach it only
+// after the rate cap, and at most one per project, type and path per cooldown
+// takes it; the rest fall back to the batched path, so a storm of a priority
+// type can't flood ClickHouse or the alert topic.
+type priorityPath struct {
+	types     map[string]bool
+	batchSize int
+	interval  time.Duration
+	cooldown  time.Duration
+
+	alertWriter *kafka.Writer // synchronous; nil when alerts are disabled
+
+	buffer   []storage.InsightRow
+	lastSent map[string]time.Time // project|type|path -> last fast path insight
+	mu       sync.Mutex
+}
+
+func newPriorityPath(cfg config.InsightPriorityConfig, kafkaCfg config.KafkaConfig, alertsTopic string) *priorityPath {
+	pp := &priorityPath{
+		types:     make(map[string]bool, len(cfg.Types)),
+		batchSize: cfg.BatchSize,
+		interval:  cfg.FlushInterval,
+		cooldown:  cfg.Cooldown,
+		buffer:    make([]storage.InsightRow, 0, cfg.BatchSize),
+		lastSent:  make(map[string]time.Time),
+	}
+	for _, t := range cfg.Types {
+		pp.types[t] = true
+	}
+
+	if alertsTopic != "" {
+		pp.alertWriter = &kafka.Writer{
+			Addr:                   kafka.TCP(kafkaCfg.Brokers...),
+			Transport:              kafkaauth.Transport(kafkaCfg),
+			Topic:                  alertsTopic,
+			Balancer:               &kafka.LeastBytes{},
+			BatchSize:              1,
+			BatchTimeout:           time.Millisecond,
+			AllowAutoTopicCreation: true,
+		}
+	}
+	return pp
+}
+
+// take reports whether the insight goes through the fast path, starting its cooldown
+func (pp *priorityPath) take(insight *Insight, now time.Time) bool {
+	if !pp.types[insight.Type] {
+		return false
+	}
+
+	key := insight.ProjectID + "|" + insight.Type + "|" + insight.Path
+	pp.mu.Lock()
+	defer pp.mu.Unlock()
+	if last, ok := pp.lastSent[key]; ok && now.Sub(last) < pp.cooldown {
+		return false
+	}
+	pp.lastSent[key] = now
+	return true
+}
+
+// add buffers a row, reporting whether the buffer is full
+func (pp *priorityPath) add(row storage.InsightRow) bool {
+	pp.mu.Lock()
+	defer pp.mu.Unlock()
+	pp.buffer = append(pp.buffer, row)
+	return len(pp.buffer) >= pp.batchSize
+}
+
+// takeBuffer returns the buffered rows and drops expired cooldowns
+func (pp *priorityPath) takeBuffer(now time.Time) []storage.InsightRow {
+	pp.mu.Lock()
+	defer pp.mu.Unlock()
+	for key, last := range pp.lastSent {
+		if now.Sub(last) >= pp.cooldown {
+			delete(pp.lastSent, key)
+		}
+	}
+	if len(pp.buffer) == 0 {
+		return nil
+	}
+	rows := pp.buffer
+	pp.buffer = make([]storage.InsightRow, 0, pp.batchSize)
+	return rows
+}
+
+// writePriorityInsight publishes the alert synchronously and buffers the row
+// for the next fast path flush
+func (p *Processor) writePriorityInsight(ctx context.Context, insight *Insight, row storage.InsightRow) {
+	if p.priority.alertWriter != nil {
+		p.publishAlert(ctx, p.priority.alertWriter, insight, row.InsightID)
+	}
+	if p.priority.add(row) {
+		p.flushPriority()
+	}
+}
+
+func (p *Processor) priorityLoop() {
+	ticker := time.NewTicker(p.priority.interval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		p.flushPriority()
+	}
+}
+
+// flushPriority writes the fast path buffer to ClickHouse
+func (p *Processor) flushPriority() {
+	rows := p.priority.takeBuffer(time.Now())
+	if len(rows) == 0 {
+		return
+	}
+
+	if err := p.ch.InsertInsights(context.Background(), rows); err != nil {
+		log.Error().Err(err).Int("count", len(rows)).Msg("Failed to insert priority insights")
+		p.deadLetter(context.Background(), rows, err)
+	} else {
+		log.Debug().Int("count", len(rows)).Msg("Flushed priority insights to ClickHouse")
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"context"
 	"encoding/json"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/google/uuid"
@@ -12,6 +13,10 @@ import (
 	"github.com/segmentio/kafka-go"
 
 	"github.com/gosight/gosight/processor/internal/config"
+	"github.com/gosight/gosight/processor/internal/eventtype"
+	"github.com/gosight/gosight/processor/internal/kafkaauth"
+	"github.com/gosight/gosight/processor/internal/selector"
+	"github.com/gosight/gosight/processor/internal/settings"
 	"github.com/gosight/gosight/processor/internal/storage"
 )
 
@@ -21,8 +26,52 @@ type Processor struct {
 	deadClick      *DeadClickDetector
 	errorClick     *ErrorClickDetector
 	thrashedCursor *ThrashedCursorDetector
+	rageScroll     *RageScrollDetector
 	uTurn          *UTurnDetector
 	slowPage       *SlowPageDetector
+	slowAbandon    *SlowPageAbandonmentDetector
+	formRetry      *FormRetryDetector
+	reloadLoop     *ReloadLoopDetector
+	errorPage      *ErrorPageDetector
+	longTask       *LongTaskDetector
+	mediaAbandon   *MediaAbandonmentDetector
+	missingVitals  *MissingVitalsDetector
+	pogostick      *PogostickDetector
+	funnel         *FunnelAbandonmentDetector
+	funnelDrop     *FunnelDropDetector
+
+	// Page history shared by navigation detectors
+	pageTracker *PageTracker
+
+	// Custom insights of project settings' insight rules
+	rules *RuleEngine
+
+	// Receives every detected insight; storeInsight unless replaced by SetSink
+	sink Sink
+
+	// Per-project cap on stored insights
+	rateCap *InsightRateCap
+
+	// Fast path for priority insight types; nil when none are configured
+	priority *priorityPath
+
+	// TTL of replay keep flags; 0 when disabled
+	replayKeepTTL time.Duration
+
+	// Detector state checkpoints; nil when disabled or without Redis
+	checkpoint     *config.InsightCheckpointConfig
+	checkpointStop chan struct{} // closed by Stop to end checkpointLoop
+	checkpointDone chan struct{} // closed when checkpointLoop returns
+
+	// Replaying stored events (see SetBackfill)
+	backfill bool
+
+	// Per-project insight toggles, path exclusions and insight rules; nil
+	// when project settings are disabled
+	settings *settings.Loader
+	excluded atomic.Uint64 // events of excluded paths dropped since the last flush
+
+	normalizer *selector.Normalizer
 
 	ch    *storage.ClickHouse
 	redis *redis.Client
@@ -30,6 +79,9 @@ type Processor struct {
 	// Kafka writer for alerts
 	alertWriter *kafka.Writer
 
+	// Kafka writer for insights that fail to insert; nil when not configured
+	deadLetterWriter *kafka.Writer
+
 	// Buffer for batch inserts
 	insightBuffer []storage.InsightRow
 	mu            sync.Mutex
@@ -38,38 +90,45 @@ type Processor struct {
 
 // NewProcessor creates a new insight processor
 func NewProcessor(ch *storage.ClickHouse, rdb *redis.Client, cfg config.InsightsConfig) *Processor {
-	return NewProcessorWithKafka(ch, rdb, cfg, config.KafkaConfig{})
+	return NewProcessorWithKafka(ch, rdb, cfg, config.KafkaConfig{}, nil)
 }
 
 // NewProcessorWithKafka creates a new insight processor with Kafka alert publishing
-func NewProcessorWithKafka(ch *storage.ClickHouse, rdb *redis.Client, cfg config.InsightsConfig, kafkaCfg config.KafkaConfig) *Processor {
+func NewProcessorWithKafka(ch *storage.ClickHouse, rdb *redis.Client, cfg config.InsightsConfig, kafkaCfg config.KafkaConfig, normalizer *selector.Normalizer) *Processor {
 	p := &Processor{
 		ch:            ch,
 		redis:         rdb,
+		normalizer:    normalizer,
+		pageTracker:   NewPageTracker(),
+		rules:         NewRuleEngine(),
 		insightBuffer: make([]storage.InsightRow, 0, 100),
 		lastFlush:     time.Now(),
 	}
+	p.sink = SinkFunc(p.storeInsight)
 
-	// Initialize Kafka writer for alerts if configured
-	if alertsTopic, ok := kafkaCfg.Topics["alerts"]; ok && len(kafkaCfg.Brokers) > 0 {
+	// Initialize Kafka writer for alerts if enabled
+	alertsTopic := resolveAlertsTopic(cfg.Alerts, kafkaCfg)
+	if alertsTopic != "" {
 		p.alertWriter = &kafka.Writer{
 			Addr:                   kafka.TCP(kafkaCfg.Brokers...),
+			Transport:              kafkaauth.Transport(kafkaCfg),
 			Topic:                  alertsTopic,
 			Balancer:               &kafka.LeastBytes{},
-			BatchSize:              1,
-			BatchTimeout:           time.Millisecond * 10,
+			BatchSize:              cfg.Alerts.BatchSize,
+			BatchTimeout:           cfg.Alerts.BatchTimeout,
 			Async:                  true, // Async for alerts to not block processing
 			AllowAutoTopicCreation: true,
 		}
 		log.Info().Str("topic", alertsTopic).Msg("Kafka alert writer initialized")
 	}
+	p.deadLetterWriter = newDeadLetterWriter(kafkaCfg)
 
 	// Initialize detectors based on config
 	if cfg.RageClick.Enabled {
 		p.rageClick = NewRageClickDetector(rdb, cfg.RageClick)
 	}
 	if cfg.DeadClick.Enabled {
-		p.deadClick = NewDeadClickDetector(cfg.DeadClick, p.emitInsight)
+		p.deadClick = NewDeadClickDetector(cfg.DeadClick)
 	}
 	if cfg.ErrorClick.Enabled {
 		p.errorClick = NewErrorClickDetector(cfg.ErrorClick)
@@ -77,12 +136,68 @@ func NewProcessorWithKafka(ch *storage.ClickHouse, rdb *redis.Client, cfg config
 	if cfg.ThrashedCursor.Enabled {
 		p.thrashedCursor = NewThrashedCursorDetector(cfg.ThrashedCursor)
 	}
+	if cfg.RageScroll.Enabled {
+		p.rageScroll = NewRageScrollDetector(cfg.RageScroll)
+	}
 	if cfg.UTurn.Enabled {
 		p.uTurn = NewUTurnDetector(cfg.UTurn)
 	}
 	if cfg.SlowPage.Enabled {
 		p.slowPage = NewSlowPageDetector(cfg.SlowPage)
 	}
+	if cfg.SlowAbandon.Enabled {
+		p.slowAbandon = NewSlowPageAbandonmentDetector(cfg.SlowAbandon, cfg.SlowPage)
+	}
+	if cfg.FormRetry.Enabled {
+		p.formRetry = NewFormRetryDetector(cfg.FormRetry)
+	}
+	if cfg.ReloadLoop.Enabled {
+		p.reloadLoop = NewReloadLoopDetector(cfg.ReloadLoop)
+	}
+	if cfg.ErrorPage.Enabled {
+		p.errorPage = NewErrorPageDetector(cfg.ErrorPage)
+	}
+	if cfg.LongTask.Enabled {
+		p.longTask = NewLongTaskDetector(cfg.LongTask)
+	}
+	if cfg.MediaAbandon.Enabled {
+		p.mediaAbandon = NewMediaAbandonmentDetector(cfg.MediaAbandon)
+	}
+	if cfg.Pogostick.Enabled {
+		p.pogostick = NewPogostickDetector(cfg.Pogostick)
+	}
+	if cfg.Funnel.Enabled {
+		p.funnel = NewFunnelAbandonmentDetector(cfg.Funnel)
+	}
+	if cfg.FunnelDrop.Enabled {
+		p.funnelDrop = NewFunnelDropDetector(cfg.FunnelDrop)
+	}
+	if cfg.MissingVitals.Enabled {
+		p.missingVitals = NewMissingVitalsDetector(cfg.MissingVitals)
+	}
+	if cfg.RateCap.Enabled {
+		p.rateCap = NewInsightRateCap(cfg.RateCap)
+	}
+	if cfg.ReplayKeep.Enabled {
+		p.replayKeepTTL = cfg.ReplayKeep.TTL
+	}
+	if len(cfg.Priority.Types) > 0 {
+		p.priority = newPriorityPath(cfg.Priority, kafkaCfg, alertsTopic)
+		go p.priorityLoop()
+	}
+	if cfg.Checkpoint.Enabled {
+		if rdb == nil {
+			log.Warn().Msg("insights.state_checkpoint.enabled but Redis is unavailable, detector state will not be checkpointed")
+		} else {
+			p.checkpoint = &cfg.Checkpoint
+			if err := p.restoreState(context.Background()); err != nil {
+				log.Error().Err(err).Msg("Failed to restore detector state")
+			}
+			p.checkpointStop = make(chan struct{})
+			p.checkpointDone = make(chan struct{})
+			go p.checkpointLoop()
+		}
+	}
 
 	// Start flush ticker
 	go p.flushLoop()
@@ -90,15 +205,119 @@ func NewProcessorWithKafka(ch *storage.ClickHouse, rdb *redis.Client, cfg config
 	return p
 }
 
+// resolveAlertsTopic returns the topic alerts are published to, or "" when
+// alerts are disabled or can't be published, which is logged so that a
+// misconfigured topic doesn't go unnoticed
+func resolveAlertsTopic(cfg config.InsightAlertsConfig, kafkaCfg config.KafkaConfig) string {
+	topic := kafkaCfg.Topics["alerts"]
+	switch {
+	case !cfg.Enabled:
+		if topic != "" {
+			log.Info().Str("topic", topic).Msg("Alerts disabled (insights.alerts.enabled is false)")
+		}
+		return ""
+	case topic == "" || len(kafkaCfg.Brokers) == 0:
+		log.Warn().Msg("insights.alerts.enabled but kafka.topics.alerts or kafka.brokers is not set: NO ALERTS WILL BE PUBLISHED")
+		return ""
+	}
+	return topic
+}
+
+// SetProjectSettings drops insights of the types a project has disabled and
+// events of the page paths it excludes, and emits the insights of its rules
+func (p *Processor) SetProjectSettings(s *settings.Loader) {
+	p.settings = s
+}
+
+// SetThresholds applies the numeric thresholds of cfg to the running
+// detectors, keeping their per-session state. Enabling or disabling detectors
+// and their non-numeric settings (funnel steps, expected vitals, ...) still
+// take a restart.
+func (p *Processor) SetThresholds(cfg config.InsightsConfig) {
+	if p.rageClick != nil {
+		p.rageClick.SetThresholds(cfg.RageClick)
+	}
+	if p.deadClick != nil {
+		p.deadClick.SetThresholds(cfg.DeadClick)
+	}
+	if p.errorClick != nil {
+		p.errorClick.SetThresholds(cfg.ErrorClick)
+	}
+	if p.thrashedCursor != nil {
+		p.thrashedCursor.SetThresholds(cfg.ThrashedCursor)
+	}
+	if p.rageScroll != nil {
+		p.rageScroll.SetThresholds(cfg.RageScroll)
+	}
+	if p.uTurn != nil {
+		p.uTurn.SetThresholds(cfg.UTurn)
+	}
+	if p.slowPage != nil {
+		p.slowPage.SetThresholds(cfg.SlowPage)
+	}
+	if p.slowAbandon != nil {
+		p.slowAbandon.SetThresholds(cfg.SlowAbandon, cfg.SlowPage)
+	}
+	if p.formRetry != nil {
+		p.formRetry.SetThresholds(cfg.FormRetry)
+	}
+	if p.reloadLoop != nil {
+		p.reloadLoop.SetThresholds(cfg.ReloadLoop)
+	}
+	if p.errorPage != nil {
+		p.errorPage.SetThresholds(cfg.ErrorPage)
+	}
+	if p.longTask != nil {
+		p.longTask.SetThresholds(cfg.LongTask)
+	}
+	if p.mediaAbandon != nil {
+		p.mediaAbandon.SetThresholds(cfg.MediaAbandon)
+	}
+	if p.missingVitals != nil {
+		p.missingVitals.SetThresholds(cfg.MissingVitals)
+	}
+	if p.pogostick != nil {
+		p.pogostick.SetThresholds(cfg.Pogostick)
+	}
+	if p.funnel != nil {
+		p.funnel.SetThresholds(cfg.Funnel)
+	}
+	if p.funnelDrop != nil {
+		p.funnelDrop.SetThresholds(cfg.FunnelDrop)
+	}
+}
+
 // Process processes a single event from Kafka
 func (p *Processor) Process(ctx context.Context, raw map[string]interface{}) error {
+	// QA and synthetic monitoring traffic must not raise insights or alerts
+	if synthetic, _ := raw["synthetic"].(bool); synthetic {
+		return nil
+	}
+	// Nor can users who didn't consent to analytics be profiled
+	if consent, ok := raw["consent"].(map[string]interface{}); ok {
+		if analytics, ok := consent["analytics"].(bool); ok && !analytics {
+			return nil
+		}
+	}
+
 	event := p.parseEvent(raw)
 
+	// Detectors track state per session; events without one would share it
+	if event.SessionID == "" {
+		return nil
+	}
+
+	if p.settings != nil && event.Path != "" && p.settings.Get(event.ProjectID).PathExcluded(event.Path) {
+		p.excluded.Add(1)
+		return nil
+	}
+
 	var insights []*Insight
 
-	// Handle based on event type (support both proto enum names and simple names)
-	switch event.Type {
-	case "click", "EVENT_TYPE_CLICK":
+	// Handle based on event type; custom events named after a type count as that type
+	eventType := eventtype.Resolve(event.Type, event.EventName)
+	switch eventType {
+	case eventtype.Click:
 		// Rage click detection
 		if p.rageClick != nil {
 			if insight := p.rageClick.ProcessClick(event); insight != nil {
@@ -116,9 +335,9 @@ func (p *Processor) Process(ctx context.Context, raw map[string]interface{}) err
 			p.errorClick.ProcessClick(event)
 		}
 
-	case "js_error", "EVENT_TYPE_JS_ERROR", "EVENT_TYPE_CUSTOM":
+	case eventtype.JSError, eventtype.Custom:
 		// Check if custom event is actually an error
-		if event.Type == "EVENT_TYPE_CUSTOM" && event.ErrorType == "" {
+		if eventType == eventtype.Custom && event.ErrorType == "" {
 			break
 		}
 
@@ -129,7 +348,50 @@ func (p *Processor) Process(ctx context.Context, raw map[string]interface{}) err
 			}
 		}
 
-	case "mouse_move", "EVENT_TYPE_MOUSE_MOVE":
+		// Form retry detection
+		if p.formRetry != nil {
+			if insight := p.formRetry.ProcessError(event); insight != nil {
+				insights = append(insights, insight)
+			}
+		}
+
+	case eventtype.FormSubmit:
+		// Form retry tracking
+		if p.formRetry != nil {
+			p.formRetry.ProcessSubmit(event)
+		}
+
+	case eventtype.FormError:
+		// Form retry detection
+		if p.formRetry != nil {
+			if insight := p.formRetry.ProcessError(event); insight != nil {
+				insights = append(insights, insight)
+			}
+		}
+
+	case eventtype.Scroll:
+		// Rage scroll detection
+		if p.rageScroll != nil {
+			if insight := p.rageScroll.ProcessScroll(event); insight != nil {
+				insights = append(insights, insight)
+			}
+		}
+
+	case eventtype.LongTask:
+		// Main thread blocking detection
+		if p.longTask != nil {
+			if insight := p.longTask.ProcessLongTask(event); insight != nil {
+				insights = append(insights, insight)
+			}
+		}
+
+	case eventtype.MediaPlay, eventtype.MediaPause, eventtype.MediaEnded:
+		// Media playback tracking (abandonment is reported on page leave or expiry)
+		if p.mediaAbandon != nil {
+			p.mediaAbandon.ProcessMedia(event, eventType)
+		}
+
+	case eventtype.MouseMove:
 		// Thrashed cursor detection
 		if p.thrashedCursor != nil {
 			if insight := p.thrashedCursor.ProcessMouseMove(event); insight != nil {
@@ -137,74 +399,228 @@ func (p *Processor) Process(ctx context.Context, raw map[string]interface{}) err
 			}
 		}
 
-	case "page_view", "EVENT_TYPE_PAGE_VIEW":
-		// U-turn detection
-		if p.uTurn != nil {
-			if insight := p.uTurn.ProcessPageView(event); insight != nil {
-				insights = append(insights, insight)
+	case eventtype.PageView:
+		if p.uTurn != nil || p.reloadLoop != nil || p.errorPage != nil || p.pogostick != nil || p.funnel != nil {
+			pages := p.pageTracker.Visit(event)
+
+			// U-turn detection
+			if p.uTurn != nil {
+				if insight := p.uTurn.ProcessPageView(event, pages); insight != nil {
+					insights = append(insights, insight)
+				}
+			}
+
+			// Reload loop detection
+			if p.reloadLoop != nil {
+				if insight := p.reloadLoop.ProcessPageView(event, pages); insight != nil {
+					insights = append(insights, insight)
+				}
+			}
+
+			// Error page detection
+			if p.errorPage != nil {
+				if insight := p.errorPage.ProcessPageView(event, pages); insight != nil {
+					insights = append(insights, insight)
+				}
+			}
+
+			// Pogostick detection
+			if p.pogostick != nil {
+				if insight := p.pogostick.ProcessPageView(event, pages); insight != nil {
+					insights = append(insights, insight)
+				}
+			}
+
+			// Funnel progress (abandonment is reported on expiry)
+			if p.funnel != nil {
+				p.funnel.ProcessPageView(event, pages)
 			}
 		}
 
+		// Funnel steps (drops are reported on expiry)
+		if p.funnelDrop != nil {
+			p.funnelDrop.ProcessPageView(event)
+		}
+
 		// Resolve pending dead clicks
 		if p.deadClick != nil {
 			p.deadClick.ProcessEvent(event)
 		}
 
-	case "dom_mutation", "EVENT_TYPE_DOM_MUTATION":
+	case eventtype.DOMMutation:
 		// Resolve pending dead clicks
 		if p.deadClick != nil {
 			p.deadClick.ProcessEvent(event)
 		}
 
-	case "web_vitals", "EVENT_TYPE_WEB_VITALS":
+	case eventtype.WebVitals:
 		// Slow page detection
 		if p.slowPage != nil {
-			if insight := p.slowPage.ProcessPerformance(event); insight != nil {
-				insights = append(insights, insight)
-			}
+			p.slowPage.ProcessPerformance(event)
+		}
+
+		// Slow loads awaiting the session's next interaction
+		if p.slowAbandon != nil {
+			p.slowAbandon.ProcessPerformance(event)
+		}
+
+		// Missing vitals tracking
+		if p.missingVitals != nil {
+			p.missingVitals.ProcessVitals(event)
+		}
+	}
+
+	// Slow page abandonment: interactions resolve a pending slow load, a page exit reports it
+	if p.slowAbandon != nil && eventType != eventtype.WebVitals {
+		if insight := p.slowAbandon.ProcessEvent(event, eventType); insight != nil {
+			insights = append(insights, insight)
+		}
+	}
+
+	// Media abandonment: leaving the page stops its playbacks
+	if p.mediaAbandon != nil && (eventType == eventtype.PageView || eventType == eventtype.PageExit) {
+		insights = append(insights, p.mediaAbandon.ProcessPageLeave(event, eventType)...)
+	}
+
+	// Custom insights of the project's rules
+	if p.settings != nil {
+		if rules := p.settings.Get(event.ProjectID).InsightRules; len(rules) > 0 {
+			insights = append(insights, p.rules.ProcessEvent(event, eventType, rules)...)
 		}
 	}
 
 	// Store insights
 	for _, insight := range insights {
-		p.storeInsight(ctx, insight)
+		if p.backfill {
+			insight.Timestamp = time.UnixMilli(event.Timestamp)
+		}
+		p.sink.Emit(ctx, insight)
 	}
 
 	return nil
 }
 
-func (p *Processor) emitInsight(insight *Insight) {
-	ctx := context.Background()
-	p.storeInsight(ctx, insight)
+// SetSink routes the detected insights to sink instead of storage
+func (p *Processor) SetSink(sink Sink) {
+	p.sink = sink
+}
+
+// expire emits the insights of detectors reporting at the end of a window
+func (p *Processor) expire(ctx context.Context, now time.Time) {
+	var insights []*Insight
+
+	// Clicks that got no response within the observation window
+	if p.deadClick != nil {
+		insights = append(insights, p.deadClick.Expire(now)...)
+	}
+
+	// Slow pages whose debounce window closed
+	if p.slowPage != nil {
+		insights = append(insights, p.slowPage.Expire(now)...)
+	}
+
+	// Sessions that didn't interact after a slow load
+	if p.slowAbandon != nil {
+		insights = append(insights, p.slowAbandon.Expire(now)...)
+	}
+
+	// Pages whose observation window closed with expected vitals missing
+	if p.missingVitals != nil {
+		insights = append(insights, p.missingVitals.Expire(now)...)
+	}
+
+	// Sessions that stalled in the funnel past the abandonment timeout
+	if p.funnel != nil {
+		insights = append(insights, p.funnel.Expire(now)...)
+	}
+
+	// Sessions stalled on a funnel step past the step timeout
+	if p.funnelDrop != nil {
+		insights = append(insights, p.funnelDrop.Expire(now)...)
+	}
+
+	// Playbacks without media events past the inactivity timeout
+	if p.mediaAbandon != nil {
+		insights = append(insights, p.mediaAbandon.Expire(now)...)
+	}
+
+	for _, insight := range insights {
+		p.sink.Emit(ctx, insight)
+	}
+
+	// Forget the page history of sessions gone idle
+	p.pageTracker.Expire(now)
+	if p.pogostick != nil {
+		p.pogostick.Expire(now)
+	}
 }
 
 func (p *Processor) storeInsight(ctx context.Context, insight *Insight) {
-	row := storage.InsightRow{
-		InsightID:       uuid.New(),
-		ProjectID:       insight.ProjectID,
-		SessionID:       insight.SessionID,
-		InsightType:     insight.Type,
-		Timestamp:       insight.Timestamp,
-		URL:             insight.URL,
-		Path:            insight.Path,
-		X:               insight.X,
-		Y:               insight.Y,
-		TargetSelector:  insight.TargetSelector,
-		Details:         insight.Details,
-		RelatedEventIDs: insight.RelatedEventIDs,
+	if p.settings != nil && !p.settings.Get(insight.ProjectID).InsightEnabled(insight.Type) {
+		return
 	}
+	if p.rateCap != nil && !p.rateCap.Allow(insight, time.Now()) {
+		return
+	}
+	p.markReplayKeep(ctx, insight)
+	p.writeInsight(ctx, insight)
+}
 
-	p.mu.Lock()
-	p.insightBuffer = append(p.insightBuffer, row)
-	shouldFlush := len(p.insightBuffer) >= 100
-	p.mu.Unlock()
+// replayKeepKeyPrefix is read by the ingestor's replay sampling (keep_with_insight)
+const replayKeepKeyPrefix = "replay:keep:"
 
-	if shouldFlush {
-		p.Flush()
+// markReplayKeep flags the insight's session so its replay chunks are kept
+func (p *Processor) markReplayKeep(ctx context.Context, insight *Insight) {
+	if p.replayKeepTTL == 0 || p.redis == nil || insight.SessionID == "" {
+		return
+	}
+
+	key := replayKeepKeyPrefix + insight.ProjectID + ":" + insight.SessionID
+	if err := p.redis.Set(ctx, key, 1, p.replayKeepTTL).Err(); err != nil {
+		log.Warn().Err(err).Str("session_id", insight.SessionID).Msg("Failed to flag session replay")
 	}
+}
 
-	// Publish alert to Kafka for downstream alert processing (Phase 9)
-	p.publishAlert(ctx, insight, row.InsightID)
+// writeInsight buffers an insight for ClickHouse and publishes its alert, bypassing the rate cap
+func (p *Processor) writeInsight(ctx context.Context, insight *Insight) {
+	// Unencodable values would fail both the insert and the alert
+	if details, replaced := SanitizeDetails(insight.Details); replaced > 0 {
+		log.Warn().Str("type", insight.Type).Int("replaced", replaced).Msg("Sanitized insight details")
+		insight.Details = details
+	}
+
+	row := storage.InsightRow{
+		InsightID:          uuid.New(),
+		ProjectID:          insight.ProjectID,
+		SessionID:          insight.SessionID,
+		InsightType:        insight.Type,
+		Timestamp:          insight.Timestamp,
+		URL:                insight.URL,
+		Path:               insight.Path,
+		X:                  insight.X,
+		Y:                  insight.Y,
+		TargetSelector:     insight.TargetSelector,
+		NormalizedSelector: p.normalizer.Normalize(insight.TargetSelector),
+		Details:            insight.Details,
+		RelatedEventIDs:    insight.RelatedEventIDs,
+		Confidence:         float32(insight.Confidence),
+	}
+
+	if p.priority != nil && p.priority.take(insight, time.Now()) {
+		p.writePriorityInsight(ctx, insight, row)
+	} else {
+		p.mu.Lock()
+		p.insightBuffer = append(p.insightBuffer, row)
+		shouldFlush := len(p.insightBuffer) >= 100
+		p.mu.Unlock()
+
+		if shouldFlush {
+			p.Flush()
+		}
+
+		// Publish alert to Kafka for downstream alert processing (Phase 9)
+		p.publishAlert(ctx, p.alertWriter, insight, row.InsightID)
+	}
 
 	log.Info().
 		Str("type", insight.Type).
@@ -213,9 +629,14 @@ func (p *Processor) storeInsight(ctx context.Context, insight *Insight) {
 		Msg("Insight detected")
 }
 
+// alertSchemaVersion is the version of the alert message format. Like the
+// ingestor's event messages, alerts carry project_id, event_type (the insight
+// type) and schema_version headers for routing without decoding.
+const alertSchemaVersion = "1"
+
 // publishAlert publishes an insight alert to Kafka for downstream alert processing
-func (p *Processor) publishAlert(ctx context.Context, insight *Insight, insightID uuid.UUID) {
-	if p.alertWriter == nil {
+func (p *Processor) publishAlert(ctx context.Context, w *kafka.Writer, insight *Insight, insightID uuid.UUID) {
+	if w == nil {
 		return
 	}
 
@@ -228,6 +649,7 @@ func (p *Processor) publishAlert(ctx context.Context, insight *Insight, insightI
 		"url":          insight.URL,
 		"path":         insight.Path,
 		"details":      insight.Details,
+		"confidence":   insight.Confidence,
 		"published_at": time.Now().UnixMilli(),
 	}
 
@@ -239,6 +661,7 @@ func (p *Processor) publishAlert(ctx context.Context, insight *Insight, insightI
 	}
 	if insight.TargetSelector != "" {
 		alert["target_selector"] = insight.TargetSelector
+		alert["normalized_selector"] = p.normalizer.Normalize(insight.TargetSelector)
 	}
 
 	data, err := json.Marshal(alert)
@@ -247,9 +670,14 @@ func (p *Processor) publishAlert(ctx context.Context, insight *Insight, insightI
 		return
 	}
 
-	err = p.alertWriter.WriteMessages(ctx, kafka.Message{
+	err = w.WriteMessages(ctx, kafka.Message{
 		Key:   []byte(insight.ProjectID),
 		Value: data,
+		Headers: []kafka.Header{
+			{Key: "project_id", Value: []byte(insight.ProjectID)},
+			{Key: "event_type", Value: []byte(insight.Type)},
+			{Key: "schema_version", Value: []byte(alertSchemaVersion)},
+		},
 	})
 	if err != nil {
 		log.Error().Err(err).Str("type", insight.Type).Msg("Failed to publish alert to Kafka")
@@ -263,6 +691,21 @@ func (p *Processor) flushLoop() {
 	defer ticker.Stop()
 
 	for range ticker.C {
+		p.expire(context.Background(), time.Now())
+
+		// Summarize projects whose insights were capped in the last interval
+		if p.rateCap != nil {
+			for _, capped := range p.rateCap.Expire(time.Now()) {
+				log.Warn().
+					Str("project_id", capped.ProjectID).
+					Interface("suppressed", capped.Details["suppressed_count"]).
+					Msg("Insight rate capped")
+				p.writeInsight(context.Background(), capped)
+			}
+		}
+		if n := p.excluded.Swap(0); n > 0 {
+			log.Info().Uint64("count", n).Msg("Dropped events of excluded paths")
+		}
 		p.Flush()
 	}
 }
@@ -283,6 +726,7 @@ func (p *Processor) Flush() {
 	ctx := context.Background()
 	if err := p.ch.InsertInsights(ctx, insights); err != nil {
 		log.Error().Err(err).Int("count", len(insights)).Msg("Failed to insert insights")
+		p.deadLetter(ctx, insights, err)
 	} else {
 		log.Info().Int("count", len(insights)).Msg("Flushed insights to ClickHouse")
 	}
@@ -323,12 +767,11 @@ func (p *Processor) parseEvent(raw map[string]interface{}) *Event {
 	// Parse payload
 	if payload, ok := raw["payload"].(map[string]interface{}); ok {
 		// Click coordinates
-		if v, ok := payload["x"].(float64); ok {
-			event.ClickX = int(v)
-		}
-		if v, ok := payload["y"].(float64); ok {
-			event.ClickY = int(v)
-		}
+		x, hasX := payload["x"].(float64)
+		y, hasY := payload["y"].(float64)
+		event.ClickX = int(x)
+		event.ClickY = int(y)
+		event.HasClickCoords = hasX && hasY
 
 		// Target info
 		if v, ok := payload["target_selector"].(string); ok {
@@ -344,11 +787,15 @@ func (p *Processor) parseEvent(raw map[string]interface{}) *Event {
 			event.TargetHref = v
 		}
 		if classes, ok := payload["target_classes"].([]interface{}); ok {
-			for _, c := range classes {
-				if s, ok := c.(string); ok {
-					event.TargetClasses = append(event.TargetClasses, s)
-				}
-			}
+			event.TargetClasses = p.normalizer.LimitClasses(classes)
+		}
+		if v, ok := payload["had_click_handler"].(bool); ok {
+			event.HadClickHandler = v
+		}
+		if v, ok := payload["cursor_pointer"].(bool); ok {
+			event.CursorPointer = v
+		} else if v, ok := payload["cursor"].(string); ok {
+			event.CursorPointer = v == "pointer"
 		}
 
 		// Error info
@@ -361,6 +808,19 @@ func (p *Processor) parseEvent(raw map[string]interface{}) *Event {
 		if v, ok := payload["errorType"].(string); ok {
 			event.ErrorType = v
 		}
+		if v, ok := payload["error_message"].(string); ok && event.ErrorMessage == "" {
+			event.ErrorMessage = v
+		}
+
+		// Custom event name
+		if v, ok := payload["name"].(string); ok {
+			event.EventName = v
+		}
+
+		// Form info
+		if v, ok := payload["form_selector"].(string); ok {
+			event.FormSelector = v
+		}
 
 		// Web vitals (individual metric format)
 		if metric, ok := payload["metric"].(string); ok {
@@ -381,23 +841,27 @@ func (p *Processor) parseEvent(raw map[string]interface{}) *Event {
 				}
 			}
 		} else {
-			// Combined format
-			if v, ok := payload["lcp"].(float64); ok {
+			// Combined format; custom web_vitals events nest it under properties
+			vitals := payload
+			if properties, ok := payload["properties"].(map[string]interface{}); ok && eventtype.Normalize(event.EventName) == eventtype.WebVitals {
+				vitals = properties
+			}
+			if v, ok := vitals["lcp"].(float64); ok {
 				event.LCP = &v
 			}
-			if v, ok := payload["ttfb"].(float64); ok {
+			if v, ok := vitals["ttfb"].(float64); ok {
 				event.TTFB = &v
 			}
-			if v, ok := payload["fcp"].(float64); ok {
+			if v, ok := vitals["fcp"].(float64); ok {
 				event.FCP = &v
 			}
-			if v, ok := payload["fid"].(float64); ok {
+			if v, ok := vitals["fid"].(float64); ok {
 				event.FID = &v
 			}
-			if v, ok := payload["cls"].(float64); ok {
+			if v, ok := vitals["cls"].(float64); ok {
 				event.CLS = &v
 			}
-			if v, ok := payload["inp"].(float64); ok {
+			if v, ok := vitals["inp"].(float64); ok {
 				event.INP = &v
 			}
 		}
@@ -409,17 +873,78 @@ func (p *Processor) parseEvent(raw map[string]interface{}) *Event {
 		if v, ok := payload["mouse_y"].(float64); ok {
 			event.MouseY = int(v)
 		}
+
+		// Scroll position
+		if v, ok := payload["scroll_top"].(float64); ok {
+			event.ScrollTop = int(v)
+		}
+		if v, ok := payload["direction"].(string); ok {
+			event.ScrollDirection = v
+		}
+
+		// Error pages
+		if v, ok := payload["http_status"].(float64); ok {
+			event.HTTPStatus = int(v)
+		} else if v, ok := payload["status_code"].(float64); ok {
+			event.HTTPStatus = int(v)
+		}
+		if v, ok := payload["error_page"].(bool); ok {
+			event.ErrorPage = v
+		}
+
+		// duration is a long task's in ms, but the media's in seconds
+		switch eventtype.Resolve(event.Type, event.EventName) {
+		case eventtype.LongTask:
+			event.LongTaskDurationMs, _ = payload["duration"].(float64)
+
+		case eventtype.MediaPlay, eventtype.MediaPause, eventtype.MediaEnded:
+			if v, ok := payload["media_id"].(string); ok {
+				event.MediaID = v
+			} else if v, ok := payload["src"].(string); ok {
+				event.MediaID = v
+			}
+			if event.MediaID != "" {
+				event.MediaPosition, _ = payload["position"].(float64)
+				event.MediaDuration, _ = payload["duration"].(float64)
+			}
+		}
 	}
 
 	return event
 }
 
-// Stop stops the processor
+// Stop stops the processor, checkpointing detector state when enabled.
+// Without checkpoints, open slow page windows are closed and flushed early.
 func (p *Processor) Stop() {
+	if p.checkpoint == nil && p.slowPage != nil {
+		for _, insight := range p.slowPage.CloseAll(time.Now()) {
+			p.sink.Emit(context.Background(), insight)
+		}
+	}
 	p.Flush()
+	if p.checkpointStop != nil {
+		close(p.checkpointStop)
+		<-p.checkpointDone
+	}
+	if err := p.SaveState(context.Background()); err != nil {
+		log.Error().Err(err).Msg("Failed to checkpoint detector state")
+	}
+	if p.priority != nil {
+		p.flushPriority()
+		if p.priority.alertWriter != nil {
+			if err := p.priority.alertWriter.Close(); err != nil {
+				log.Error().Err(err).Msg("Failed to close priority alert writer")
+			}
+		}
+	}
 	if p.alertWriter != nil {
 		if err := p.alertWriter.Close(); err != nil {
 			log.Error().Err(err).Msg("Failed to close alert writer")
 		}
 	}
+	if p.deadLetterWriter != nil {
+		if err := p.deadLetterWriter.Close(); err != nil {
+			log.Error().Err(err).Msg("Failed to close insight DLQ writer")
+		}
+	}
 }
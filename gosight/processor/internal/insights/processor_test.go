@@ -0,0 +1,49 @@
+package insights
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/gosight/gosight/processor/internal/selector"
+)
+
+func TestParseEventBoundsTargetClasses(t *testing.T) {
+	classes := make([]interface{}, 10000)
+	for i := range classes {
+		classes[i] = strings.Repeat("btn", 100)
+	}
+
+	p := &Processor{}
+	event := p.parseEvent(map[string]interface{}{
+		"type":    "click",
+		"payload": map[string]interface{}{"target_classes": classes},
+	})
+	if len(event.TargetClasses) != selector.DefaultMaxTargetClasses {
+		t.Fatalf("parsed %d classes, want %d", len(event.TargetClasses), selector.DefaultMaxTargetClasses)
+	}
+	for _, c := range event.TargetClasses {
+		if len(c) > selector.DefaultMaxClassLength {
+			t.Fatalf("parsed a class of length %d", len(c))
+		}
+	}
+}
+
+func TestParseEventDurationByEventType(t *testing.T) {
+	p := &Processor{}
+
+	longTask := p.parseEvent(map[string]interface{}{
+		"type":    "long_task",
+		"payload": map[string]interface{}{"duration": 180.0, "src": "https://cdn.example.com/app.js"},
+	})
+	if longTask.LongTaskDurationMs != 180 || longTask.MediaID != "" || longTask.MediaDuration != 0 {
+		t.Errorf("long task: duration %vms, media %q of %vs", longTask.LongTaskDurationMs, longTask.MediaID, longTask.MediaDuration)
+	}
+
+	media := p.parseEvent(map[string]interface{}{
+		"type":    "media_pause",
+		"payload": map[string]interface{}{"media_id": "intro", "position": 12.0, "duration": 240.0},
+	})
+	if media.LongTaskDurationMs != 0 || media.MediaID != "intro" || media.MediaPosition != 12 || media.MediaDuration != 240 {
+		t.Errorf("media: long task duration %vms, media %q at %vs of %vs", media.LongTaskDurationMs, media.MediaID, media.MediaPosition, media.MediaDuration)
+	}
+}
@@ -4,6 +4,9 @@ import (
 	"context"
 	"fmt"
 	"math"
+	"strconv"
+	"strings"
+	"sync/atomic"
 	"time"
 
 	"github.com/redis/go-redis/v9"
@@ -13,10 +16,19 @@ import (
 
 // RageClickDetector detects rapid clicks in a small area indicating user frustration
 type RageClickDetector struct {
-	redis        *redis.Client
-	minClicks    int
-	timeWindowMs int64
-	radiusPx     int
+	redis      *redis.Client
+	thresholds atomic.Pointer[rageClickThresholds]
+
+	// Track all cells of a session in a single hash instead of a key per cell
+	hashPerSession bool
+}
+
+// rageClickThresholds are the settings of RageClickDetector reloadable at runtime
+type rageClickThresholds struct {
+	minClicks          int
+	timeWindowMs       int64
+	radiusPx           int
+	maxCellsPerSession int
 }
 
 // ClickRecord stores info about a single click
@@ -28,12 +40,23 @@ type ClickRecord struct {
 
 // NewRageClickDetector creates a new rage click detector
 func NewRageClickDetector(rdb *redis.Client, cfg config.RageClickConfig) *RageClickDetector {
-	return &RageClickDetector{
-		redis:        rdb,
-		minClicks:    cfg.MinClicks,
-		timeWindowMs: cfg.TimeWindowMs,
-		radiusPx:     cfg.RadiusPx,
+	d := &RageClickDetector{
+		redis:          rdb,
+		hashPerSession: cfg.HashPerSession,
 	}
+	d.SetThresholds(cfg)
+	return d
+}
+
+// SetThresholds applies new thresholds to the running detector; clicks already
+// tracked are kept
+func (d *RageClickDetector) SetThresholds(cfg config.RageClickConfig) {
+	d.thresholds.Store(&rageClickThresholds{
+		minClicks:          cfg.MinClicks,
+		timeWindowMs:       cfg.TimeWindowMs,
+		radiusPx:           cfg.RadiusPx,
+		maxCellsPerSession: cfg.MaxCellsPerSession,
+	})
 }
 
 // ProcessClick processes a click event and detects rage clicks
@@ -43,36 +66,88 @@ func (d *RageClickDetector) ProcessClick(event *Event) *Insight {
 	}
 
 	ctx := context.Background()
+	t := d.thresholds.Load()
 
-	// Get click coordinates
+	// Clicks without coordinates can't be grouped spatially
+	if !event.HasClickCoords {
+		return nil
+	}
 	x := event.ClickX
 	y := event.ClickY
-	if x == 0 && y == 0 {
+
+	// Grid cell for spatial grouping
+	gridX := x / t.radiusPx
+	gridY := y / t.radiusPx
+
+	var records []ClickRecord
+	if d.hashPerSession {
+		records = d.trackClickInHash(ctx, t, event, gridX, gridY)
+	} else {
+		records = d.trackClickInSortedSet(ctx, t, event, gridX, gridY)
+	}
+	if len(records) < t.minClicks {
 		return nil
 	}
 
-	// Grid cell for spatial grouping
-	gridX := x / d.radiusPx
-	gridY := y / d.radiusPx
+	// Calculate center
+	centerX, centerY := d.calculateCenter(records)
+
+	// Verify all within radius
+	if !d.allWithinRadius(records, centerX, centerY, t.radiusPx) {
+		return nil
+	}
+
+	// Clear processed clicks
+	if d.hashPerSession {
+		d.redis.HDel(ctx, d.sessionKey(event.SessionID), d.cellField(gridX, gridY))
+	} else {
+		d.redis.Del(ctx, d.cellKey(event.SessionID, gridX, gridY))
+	}
+
+	// Create insight
+	return &Insight{
+		Type:           "rage_click",
+		ProjectID:      event.ProjectID,
+		SessionID:      event.SessionID,
+		Timestamp:      time.Now(),
+		URL:            event.URL,
+		Path:           event.Path,
+		X:              &centerX,
+		Y:              &centerY,
+		TargetSelector: event.TargetSelector,
+		Details: map[string]interface{}{
+			"click_count":    len(records),
+			"time_window_ms": t.timeWindowMs,
+			"radius_px":      t.radiusPx,
+		},
+		RelatedEventIDs: d.extractEventIDs(records),
+		// Averages how far over min_clicks the burst went with how tightly the
+		// clicks cluster: 1 for clicks on the same pixel, 0.5 at radius_px apart
+		Confidence: (overThresholdConfidence(float64(len(records)), float64(t.minClicks)) +
+			underThresholdConfidence(d.meanDistance(records, centerX, centerY), float64(t.radiusPx))) / 2,
+	}
+}
 
-	key := fmt.Sprintf("clicks:%s:%d:%d", event.SessionID, gridX, gridY)
+// trackClickInSortedSet keeps one sorted set per (session, cell) and returns the clicks within the window
+func (d *RageClickDetector) trackClickInSortedSet(ctx context.Context, t *rageClickThresholds, event *Event, gridX, gridY int) []ClickRecord {
+	key := d.cellKey(event.SessionID, gridX, gridY)
 
 	// Add click to Redis sorted set (score = timestamp)
 	d.redis.ZAdd(ctx, key, redis.Z{
 		Score:  float64(event.Timestamp),
-		Member: fmt.Sprintf("%d:%d:%s", x, y, event.EventID),
+		Member: fmt.Sprintf("%d:%d:%s", event.ClickX, event.ClickY, event.EventID),
 	})
 
 	// Set expiry
-	d.redis.Expire(ctx, key, time.Duration(d.timeWindowMs*2)*time.Millisecond)
+	d.redis.Expire(ctx, key, time.Duration(t.timeWindowMs*2)*time.Millisecond)
 
 	// Remove old clicks outside time window
-	cutoff := event.Timestamp - d.timeWindowMs
+	cutoff := event.Timestamp - t.timeWindowMs
 	d.redis.ZRemRangeByScore(ctx, key, "-inf", fmt.Sprintf("%d", cutoff))
 
 	// Get remaining clicks
 	clicks, err := d.redis.ZRange(ctx, key, 0, -1).Result()
-	if err != nil || len(clicks) < d.minClicks {
+	if err != nil {
 		return nil
 	}
 
@@ -84,36 +159,121 @@ func (d *RageClickDetector) ProcessClick(event *Event) *Insight {
 		fmt.Sscanf(c, "%d:%d:%s", &cx, &cy, &eid)
 		records = append(records, ClickRecord{X: cx, Y: cy, EventID: eid})
 	}
+	return records
+}
 
-	// Calculate center
-	centerX, centerY := d.calculateCenter(records)
+// rageClickCellScript records a click in its cell of the session hash
+// atomically, so concurrent clicks of a session can't overwrite each other's
+// cells. Each field holds the cell's clicks as "ts:x:y:eventID" entries joined
+// by ",". Before adding a cell to a session at the cell limit, it evicts the
+// cells without clicks in the window, then the least recently clicked one. The
+// cell's clicks at or before the cutoff are dropped, then the new one appended.
+// Returns the cell's clicks.
+//
+// KEYS[1] session hash; ARGV[1] cell field, ARGV[2] window cutoff (ms),
+// ARGV[3] new click entry, ARGV[4] max cells per session (0 = unlimited),
+// ARGV[5] key expiry (ms)
+var rageClickCellScript = redis.NewScript(`
+local key, field, cutoff, entry = KEYS[1], ARGV[1], tonumber(ARGV[2]), ARGV[3]
+local maxCells = tonumber(ARGV[4])
+local function trim(value)
+	local kept, latest = {}, nil
+	for e in string.gmatch(value, '[^,]+') do
+		local ts = tonumber(string.match(e, '^(%-?%d+):'))
+		if ts and ts > cutoff then
+			kept[#kept + 1] = e
+			if not latest or ts > latest then
+				latest = ts
+			end
+		end
+	end
+	return kept, latest
+end
+local current = redis.call('HGET', key, field)
+if not current and maxCells > 0 and redis.call('HLEN', key) >= maxCells then
+	local cells = redis.call('HGETALL', key)
+	local evict, live, oldestField, oldestTs = {}, 0, nil, nil
+	for i = 1, #cells, 2 do
+		local _, latest = trim(cells[i + 1])
+		if not latest then
+			evict[#evict + 1] = cells[i]
+		else
+			live = live + 1
+			if not oldestTs or latest < oldestTs then
+				oldestField, oldestTs = cells[i], latest
+			end
+		end
+	end
+	if live >= maxCells and oldestField then
+		evict[#evict + 1] = oldestField
+	end
+	if #evict > 0 then
+		redis.call('HDEL', key, unpack(evict))
+	end
+end
+local kept = {}
+if current then
+	kept = trim(current)
+end
+kept[#kept + 1] = entry
+local value = table.concat(kept, ',')
+redis.call('HSET', key, field, value)
+redis.call('PEXPIRE', key, ARGV[5])
+return value
+`)
 
-	// Verify all within radius
-	if !d.allWithinRadius(records, centerX, centerY) {
+// trackClickInHash keeps every cell of a session as a field of one hash, so a
+// session costs a single key with a single expiry and cleanup is one DEL.
+// See rageClickCellScript for the format.
+func (d *RageClickDetector) trackClickInHash(ctx context.Context, t *rageClickThresholds, event *Event, gridX, gridY int) []ClickRecord {
+	cutoff := event.Timestamp - t.timeWindowMs
+	entry := fmt.Sprintf("%d:%d:%d:%s", event.Timestamp, event.ClickX, event.ClickY, event.EventID)
+
+	value, err := rageClickCellScript.Run(ctx, d.redis, []string{d.sessionKey(event.SessionID)},
+		d.cellField(gridX, gridY), cutoff, entry, t.maxCellsPerSession, t.timeWindowMs*2).Text()
+	if err != nil {
 		return nil
 	}
 
-	// Clear processed clicks
-	d.redis.Del(ctx, key)
+	_, records := d.parseCell(value, cutoff)
+	return records
+}
 
-	// Create insight
-	return &Insight{
-		Type:           "rage_click",
-		ProjectID:      event.ProjectID,
-		SessionID:      event.SessionID,
-		Timestamp:      time.Now(),
-		URL:            event.URL,
-		Path:           event.Path,
-		X:              &centerX,
-		Y:              &centerY,
-		TargetSelector: event.TargetSelector,
-		Details: map[string]interface{}{
-			"click_count":    len(records),
-			"time_window_ms": d.timeWindowMs,
-			"radius_px":      d.radiusPx,
-		},
-		RelatedEventIDs: d.extractEventIDs(records),
+// parseCell decodes a hash field value, dropping clicks at or before cutoff
+func (d *RageClickDetector) parseCell(value string, cutoff int64) ([]int64, []ClickRecord) {
+	if value == "" {
+		return nil, nil
+	}
+
+	var timestamps []int64
+	var records []ClickRecord
+	for _, entry := range strings.Split(value, ",") {
+		parts := strings.SplitN(entry, ":", 4)
+		if len(parts) != 4 {
+			continue
+		}
+		ts, err := strconv.ParseInt(parts[0], 10, 64)
+		if err != nil || ts <= cutoff {
+			continue
+		}
+		cx, _ := strconv.Atoi(parts[1])
+		cy, _ := strconv.Atoi(parts[2])
+		timestamps = append(timestamps, ts)
+		records = append(records, ClickRecord{X: cx, Y: cy, EventID: parts[3]})
 	}
+	return timestamps, records
+}
+
+func (d *RageClickDetector) cellKey(sessionID string, gridX, gridY int) string {
+	return fmt.Sprintf("clicks:%s:%d:%d", sessionID, gridX, gridY)
+}
+
+func (d *RageClickDetector) sessionKey(sessionID string) string {
+	return "clicks:" + sessionID
+}
+
+func (d *RageClickDetector) cellField(gridX, gridY int) string {
+	return fmt.Sprintf("%d:%d", gridX, gridY)
 }
 
 func (d *RageClickDetector) calculateCenter(clicks []ClickRecord) (int, int) {
@@ -125,18 +285,29 @@ func (d *RageClickDetector) calculateCenter(clicks []ClickRecord) (int, int) {
 	return sumX / len(clicks), sumY / len(clicks)
 }
 
-func (d *RageClickDetector) allWithinRadius(clicks []ClickRecord, centerX, centerY int) bool {
+func (d *RageClickDetector) allWithinRadius(clicks []ClickRecord, centerX, centerY, radiusPx int) bool {
 	for _, c := range clicks {
 		dx := c.X - centerX
 		dy := c.Y - centerY
 		distance := math.Sqrt(float64(dx*dx + dy*dy))
-		if distance > float64(d.radiusPx) {
+		if distance > float64(radiusPx) {
 			return false
 		}
 	}
 	return true
 }
 
+// meanDistance returns the mean distance of clicks from the center
+func (d *RageClickDetector) meanDistance(clicks []ClickRecord, centerX, centerY int) float64 {
+	var total float64
+	for _, c := range clicks {
+		dx := c.X - centerX
+		dy := c.Y - centerY
+		total += math.Sqrt(float64(dx*dx + dy*dy))
+	}
+	return total / float64(len(clicks))
+}
+
 func (d *RageClickDetector) extractEventIDs(clicks []ClickRecord) []string {
 	var ids []string
 	for _, c := range clicks {
@@ -0,0 +1,180 @@
+package insights
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+
+	"github.com/gosight/gosight/processor/internal/config"
+)
+
+// testRedis connects to the Redis at REDIS_ADDR (default localhost:6379),
+// skipping the test when none is reachable
+func testRedis(tb testing.TB) *redis.Client {
+	tb.Helper()
+	addr := os.Getenv("REDIS_ADDR")
+	if addr == "" {
+		addr = "localhost:6379"
+	}
+	rdb := redis.NewClient(&redis.Options{Addr: addr, DialTimeout: 200 * time.Millisecond, MaxRetries: -1})
+	if err := rdb.Ping(context.Background()).Err(); err != nil {
+		rdb.Close()
+		tb.Skipf("Redis unavailable at %s: %v", addr, err)
+	}
+	tb.Cleanup(func() { rdb.Close() })
+	return rdb
+}
+
+func testRageClickConfig(hash bool) config.RageClickConfig {
+	return config.RageClickConfig{
+		Enabled:        true,
+		MinClicks:      3,
+		TimeWindowMs:   1000,
+		RadiusPx:       50,
+		HashPerSession: hash,
+	}
+}
+
+// click is a click of the session at (x, y), offsetMs after start
+func click(sessionID string, start int64, offsetMs int64, x, y int) *Event {
+	return &Event{
+		EventID:        fmt.Sprintf("%s-%d-%d-%d", sessionID, offsetMs, x, y),
+		ProjectID:      "proj",
+		SessionID:      sessionID,
+		Timestamp:      start + offsetMs,
+		ClickX:         x,
+		ClickY:         y,
+		HasClickCoords: true,
+	}
+}
+
+// uniqueSession returns a session ID not used by earlier runs against the same Redis
+func uniqueSession(name string) string {
+	return fmt.Sprintf("test-%s-%d", name, time.Now().UnixNano())
+}
+
+func TestParseCell(t *testing.T) {
+	d := &RageClickDetector{}
+	timestamps, records := d.parseCell("100:1:2:e1,200:3:4:e2,bad,300:5:6:e3", 150)
+	if len(timestamps) != 2 || timestamps[0] != 200 || timestamps[1] != 300 {
+		t.Errorf("timestamps = %v, want [200 300]", timestamps)
+	}
+	want := []ClickRecord{{X: 3, Y: 4, EventID: "e2"}, {X: 5, Y: 6, EventID: "e3"}}
+	if len(records) != 2 || records[0] != want[0] || records[1] != want[1] {
+		t.Errorf("records = %+v, want %+v", records, want)
+	}
+}
+
+func TestRageClickModes(t *testing.T) {
+	rdb := testRedis(t)
+
+	for _, hash := range []bool{false, true} {
+		t.Run(fmt.Sprintf("hash=%v", hash), func(t *testing.T) {
+			d := NewRageClickDetector(rdb, testRageClickConfig(hash))
+			session := uniqueSession("modes")
+			start := time.Now().UnixMilli()
+
+			if d.ProcessClick(click(session, start, 0, 100, 100)) != nil || d.ProcessClick(click(session, start, 100, 105, 102)) != nil {
+				t.Fatal("unexpected insight before min_clicks")
+			}
+			insight := d.ProcessClick(click(session, start, 200, 102, 104))
+			if insight == nil {
+				t.Fatal("expected a rage_click insight")
+			}
+			if n := insight.Details["click_count"]; n != 3 {
+				t.Errorf("click_count = %v, want 3", n)
+			}
+
+			// Clicks outside the window don't count
+			if d.ProcessClick(click(session, start, 5000, 100, 100)) != nil {
+				t.Error("unexpected insight after the processed clicks were cleared")
+			}
+		})
+	}
+}
+
+func TestRageClickHashKeepsConcurrentClicks(t *testing.T) {
+	rdb := testRedis(t)
+	cfg := testRageClickConfig(true)
+	cfg.MinClicks = 1000 // never reported, so no cell is cleared
+	d := NewRageClickDetector(rdb, cfg)
+	session := uniqueSession("concurrent")
+	start := time.Now().UnixMilli()
+
+	// Each goroutine clicks its own cell of the session
+	const cells, clicksPerCell = 8, 20
+	var wg sync.WaitGroup
+	for c := 0; c < cells; c++ {
+		wg.Add(1)
+		go func(c int) {
+			defer wg.Done()
+			for i := 0; i < clicksPerCell; i++ {
+				d.ProcessClick(click(session, start, int64(i), c*cfg.RadiusPx, 0))
+			}
+		}(c)
+	}
+	wg.Wait()
+
+	stored, err := rdb.HGetAll(context.Background(), d.sessionKey(session)).Result()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(stored) != cells {
+		t.Fatalf("stored %d cells, want %d", len(stored), cells)
+	}
+	for field, value := range stored {
+		if n := len(strings.Split(value, ",")); n != clicksPerCell {
+			t.Errorf("cell %s holds %d clicks, want %d", field, n, clicksPerCell)
+		}
+	}
+}
+
+func TestRageClickHashEvictsLeastRecentCell(t *testing.T) {
+	rdb := testRedis(t)
+	cfg := testRageClickConfig(true)
+	cfg.MaxCellsPerSession = 2
+	d := NewRageClickDetector(rdb, cfg)
+	session := uniqueSession("evict")
+	start := time.Now().UnixMilli()
+
+	d.ProcessClick(click(session, start, 0, 0, 0))     // cell 0:0
+	d.ProcessClick(click(session, start, 100, 100, 0)) // cell 2:0
+	d.ProcessClick(click(session, start, 200, 0, 0))   // cell 0:0 again
+	d.ProcessClick(click(session, start, 300, 200, 0)) // cell 4:0 evicts 2:0
+
+	fields, err := rdb.HKeys(context.Background(), d.sessionKey(session)).Result()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(fields) != 2 || !(slices.Contains(fields, "0:0") && slices.Contains(fields, "4:0")) {
+		t.Errorf("cells = %v, want [0:0 4:0]", fields)
+	}
+}
+
+func BenchmarkRageClick(b *testing.B) {
+	rdb := testRedis(b)
+
+	for _, hash := range []bool{false, true} {
+		b.Run(fmt.Sprintf("hash=%v", hash), func(b *testing.B) {
+			cfg := testRageClickConfig(hash)
+			cfg.MinClicks = 1 << 30
+			cfg.MaxCellsPerSession = 16
+			d := NewRageClickDetector(rdb, cfg)
+			session := uniqueSession("bench")
+			start := time.Now().UnixMilli()
+
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				// Spread clicks over more cells than the limit
+				d.ProcessClick(click(session, start, int64(i), (i%32)*cfg.RadiusPx, 0))
+			}
+		})
+	}
+}
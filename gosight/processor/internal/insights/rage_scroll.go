@@ -0,0 +1,194 @@
+package insights
+
+import (
+	"encoding/json"
+	"math"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gosight/gosight/processor/internal/config"
+)
+
+// RageScrollDetector detects frantic up and down scrolling on a page, often a
+// user hunting for something they can't find
+type RageScrollDetector struct {
+	thresholds  atomic.Pointer[rageScrollThresholds]
+	sessionData sync.Map // sessionID -> *ScrollTrackingData
+}
+
+// rageScrollThresholds are the settings of RageScrollDetector reloadable at runtime
+type rageScrollThresholds struct {
+	minDirectionChanges int
+	minVelocity         int // px/sec
+	windowMs            int64
+}
+
+// ScrollTrackingData tracks scrolling on the session's current page
+type ScrollTrackingData struct {
+	Path          string
+	Points        []ScrollPoint
+	Reversals     []int64 // timestamps of direction changes
+	LastDirection float64 // as in ThrashedCursorDetector; 0 before the first move
+	EventIDs      []string
+	mu            sync.Mutex
+}
+
+// ScrollPoint is a scroll position at a given time
+type ScrollPoint struct {
+	ScrollTop int
+	Timestamp int64
+}
+
+// NewRageScrollDetector creates a new rage scroll detector
+func NewRageScrollDetector(cfg config.RageScrollConfig) *RageScrollDetector {
+	d := &RageScrollDetector{}
+	d.SetThresholds(cfg)
+	return d
+}
+
+// SetThresholds applies new thresholds to the running detector; scrolling
+// already tracked is kept
+func (d *RageScrollDetector) SetThresholds(cfg config.RageScrollConfig) {
+	d.thresholds.Store(&rageScrollThresholds{
+		minDirectionChanges: cfg.MinDirectionChanges,
+		minVelocity:         cfg.MinVelocity,
+		windowMs:            cfg.WindowMs,
+	})
+}
+
+// ProcessScroll processes a scroll event
+func (d *RageScrollDetector) ProcessScroll(event *Event) *Insight {
+	dataI, _ := d.sessionData.LoadOrStore(event.SessionID, &ScrollTrackingData{})
+	data := dataI.(*ScrollTrackingData)
+
+	data.mu.Lock()
+	defer data.mu.Unlock()
+
+	// Scrolling is tracked per page
+	if data.Path != event.Path {
+		data.Path = event.Path
+		data.reset()
+	}
+
+	point := ScrollPoint{ScrollTop: event.ScrollTop, Timestamp: event.Timestamp}
+	if len(data.Points) > 0 {
+		dy := float64(point.ScrollTop - data.Points[len(data.Points)-1].ScrollTop)
+		switch event.ScrollDirection {
+		case "down":
+			dy = 1
+		case "up":
+			dy = -1
+		}
+		if dy != 0 {
+			// Vertical scrolling only ever points straight up or down
+			direction := math.Atan2(dy, 0)
+			if data.LastDirection != 0 && isDirectionChange(data.LastDirection, direction) {
+				data.Reversals = append(data.Reversals, event.Timestamp)
+			}
+			data.LastDirection = direction
+		}
+	}
+	data.Points = append(data.Points, point)
+	data.EventIDs = append(data.EventIDs, event.EventID)
+
+	// Keep the window
+	t := d.thresholds.Load()
+	cutoff := event.Timestamp - t.windowMs
+	for len(data.Points) > 0 && data.Points[0].Timestamp < cutoff {
+		data.Points = data.Points[1:]
+		data.EventIDs = data.EventIDs[1:]
+	}
+	for len(data.Reversals) > 0 && data.Reversals[0] < cutoff {
+		data.Reversals = data.Reversals[1:]
+	}
+
+	if len(data.Reversals) < t.minDirectionChanges || len(data.Points) < 2 {
+		return nil
+	}
+
+	// Average scroll velocity over the window
+	distance := 0.0
+	for i := 1; i < len(data.Points); i++ {
+		distance += math.Abs(float64(data.Points[i].ScrollTop - data.Points[i-1].ScrollTop))
+	}
+	durationMs := data.Points[len(data.Points)-1].Timestamp - data.Points[0].Timestamp
+	if durationMs <= 0 {
+		return nil
+	}
+	velocity := distance / (float64(durationMs) / 1000.0)
+	if velocity < float64(t.minVelocity) {
+		return nil
+	}
+
+	reversals := len(data.Reversals)
+	eventIDs := data.EventIDs
+	data.reset()
+
+	return &Insight{
+		Type:      "rage_scroll",
+		ProjectID: event.ProjectID,
+		SessionID: event.SessionID,
+		Timestamp: time.Now(),
+		URL:       event.URL,
+		Path:      event.Path,
+		Details: map[string]interface{}{
+			"direction_changes": reversals,
+			"velocity_px_sec":   velocity,
+			"duration_ms":       durationMs,
+			"page":              event.Path,
+			"window_ms":         t.windowMs,
+		},
+		RelatedEventIDs: eventIDs,
+		// Averages how far the reversals and the velocity went over their minimums
+		Confidence: (overThresholdConfidence(float64(reversals), float64(t.minDirectionChanges)) +
+			overThresholdConfidence(velocity, float64(t.minVelocity))) / 2,
+	}
+}
+
+// SaveState returns the scrolling tracked per session, as JSON, leaving out
+// sessions without scrolling since cutoff
+func (d *RageScrollDetector) SaveState(cutoff time.Time) ([]byte, error) {
+	state := make(map[string]*ScrollTrackingData)
+	d.sessionData.Range(func(key, value interface{}) bool {
+		data := value.(*ScrollTrackingData)
+		data.mu.Lock()
+		defer data.mu.Unlock()
+
+		if n := len(data.Points); n > 0 && data.Points[n-1].Timestamp >= cutoff.UnixMilli() {
+			state[key.(string)] = &ScrollTrackingData{
+				Path:          data.Path,
+				Points:        slices.Clone(data.Points),
+				Reversals:     slices.Clone(data.Reversals),
+				LastDirection: data.LastDirection,
+				EventIDs:      slices.Clone(data.EventIDs),
+			}
+		}
+		return true
+	})
+	return json.Marshal(state)
+}
+
+// RestoreState restores the state returned by SaveState; sessions already
+// tracked keep their state
+func (d *RageScrollDetector) RestoreState(data []byte) error {
+	var state map[string]*ScrollTrackingData
+	if err := json.Unmarshal(data, &state); err != nil {
+		return err
+	}
+	for sessionID, tracking := range state {
+		if tracking == nil || len(tracking.Points) != len(tracking.EventIDs) {
+			continue
+		}
+		d.sessionData.LoadOrStore(sessionID, tracking)
+	}
+	return nil
+}
+
+func (t *ScrollTrackingData) reset() {
+	t.Points = nil
+	t.Reversals = nil
+	t.LastDirection = 0
+	t.EventIDs = nil
+}
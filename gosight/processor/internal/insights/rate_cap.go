@@ -0,0 +1,98 @@
+package insights
+
+import (
+	"sync"
+	"time"
+
+	"github.com/gosight/gosight/processor/internal/config"
+)
+
+// InsightRateCap bounds the number of insights stored per project per interval.
+// Insights beyond the cap are counted and summarized in a single
+// "insight_rate_capped" meta-insight once the interval ends.
+type InsightRateCap struct {
+	maxPerInterval int
+	interval       time.Duration
+	projects       map[string]*projectInsightRate
+	mu             sync.Mutex
+}
+
+type projectInsightRate struct {
+	windowStart time.Time
+	count       int
+	suppressed  map[string]int // insight type -> suppressed count
+}
+
+// NewInsightRateCap creates a new per-project insight rate cap
+func NewInsightRateCap(cfg config.InsightRateCapConfig) *InsightRateCap {
+	return &InsightRateCap{
+		maxPerInterval: cfg.MaxPerMinute,
+		interval:       time.Minute,
+		projects:       make(map[string]*projectInsightRate),
+	}
+}
+
+// Allow reports whether an insight may be stored, counting it as suppressed otherwise
+func (c *InsightRateCap) Allow(insight *Insight, now time.Time) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	// A capped window stays capped until Expire has summarized it
+	rate, ok := c.projects[insight.ProjectID]
+	if !ok || (now.Sub(rate.windowStart) >= c.interval && len(rate.suppressed) == 0) {
+		rate = &projectInsightRate{windowStart: now}
+		c.projects[insight.ProjectID] = rate
+	}
+
+	if rate.count < c.maxPerInterval {
+		rate.count++
+		return true
+	}
+
+	if rate.suppressed == nil {
+		rate.suppressed = make(map[string]int)
+	}
+	rate.suppressed[insight.Type]++
+	return false
+}
+
+// Expire ends intervals older than the cap interval, returning one meta-insight
+// per project that had insights suppressed
+func (c *InsightRateCap) Expire(now time.Time) []*Insight {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	var capped []*Insight
+	for projectID, rate := range c.projects {
+		if now.Sub(rate.windowStart) < c.interval {
+			continue
+		}
+		delete(c.projects, projectID)
+
+		if len(rate.suppressed) == 0 {
+			continue
+		}
+
+		total := 0
+		byType := make(map[string]interface{}, len(rate.suppressed))
+		for insightType, n := range rate.suppressed {
+			total += n
+			byType[insightType] = n
+		}
+
+		capped = append(capped, &Insight{
+			Type:      "insight_rate_capped",
+			ProjectID: projectID,
+			Timestamp: now,
+			Details: map[string]interface{}{
+				"suppressed_count":   total,
+				"suppressed_by_type": byType,
+				"max_per_minute":     c.maxPerInterval,
+				"window_start":       rate.windowStart.UnixMilli(),
+				"window_end":         now.UnixMilli(),
+			},
+			Confidence: 1, // a count, not a heuristic
+		})
+	}
+	return capped
+}
@@ -0,0 +1,83 @@
+package insights
+
+import (
+	"sync/atomic"
+	"time"
+
+	"github.com/gosight/gosight/processor/internal/config"
+)
+
+// ReloadLoopDetector detects the same page being reloaded repeatedly (A -> A -> A)
+type ReloadLoopDetector struct {
+	thresholds atomic.Pointer[reloadLoopThresholds]
+}
+
+// reloadLoopThresholds are the settings of ReloadLoopDetector reloadable at runtime
+type reloadLoopThresholds struct {
+	minReloads int
+	windowMs   int64
+}
+
+// NewReloadLoopDetector creates a new reload loop detector
+func NewReloadLoopDetector(cfg config.ReloadLoopConfig) *ReloadLoopDetector {
+	d := &ReloadLoopDetector{}
+	d.SetThresholds(cfg)
+	return d
+}
+
+// SetThresholds applies new thresholds to the running detector
+func (d *ReloadLoopDetector) SetThresholds(cfg config.ReloadLoopConfig) {
+	d.thresholds.Store(&reloadLoopThresholds{
+		minReloads: cfg.MinReloads,
+		windowMs:   cfg.WindowMs,
+	})
+}
+
+// ProcessPageView detects reload loops given the session's page history, which
+// ends with the current page view. A reload is a page view of the same path as
+// the one before it within windowMs; an insight is emitted once per run of
+// consecutive reloads, when it reaches minReloads.
+func (d *ReloadLoopDetector) ProcessPageView(event *Event, pages []PageVisit) *Insight {
+	t := d.thresholds.Load()
+	reloads := 0
+	for i := len(pages) - 1; i > 0; i-- {
+		current, previous := pages[i], pages[i-1]
+		if current.Path != previous.Path {
+			break
+		}
+		gap := current.Timestamp - previous.Timestamp
+		if gap < 0 || gap > t.windowMs {
+			break
+		}
+		reloads++
+	}
+
+	if reloads != t.minReloads {
+		return nil
+	}
+
+	run := pages[len(pages)-reloads-1:]
+	duration := run[len(run)-1].Timestamp - run[0].Timestamp
+	eventIDs := make([]string, len(run))
+	for i, visit := range run {
+		eventIDs[i] = visit.EventID
+	}
+
+	return &Insight{
+		Type:      "reload_loop",
+		ProjectID: event.ProjectID,
+		SessionID: event.SessionID,
+		Timestamp: time.Now(),
+		URL:       event.URL,
+		Path:      event.Path,
+		Details: map[string]interface{}{
+			"path":         event.Path,
+			"reload_count": reloads,
+			"duration_ms":  duration,
+		},
+		RelatedEventIDs: eventIDs,
+		// The faster the reloads came, the likelier they were in frustration:
+		// 0.5 when each took the whole window
+		Confidence: underThresholdConfidence(float64(duration), float64(int64(reloads)*t.windowMs)),
+	}
+}
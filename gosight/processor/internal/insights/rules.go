@@ -0,0 +1,99 @@
+package insights
+
+import (
+	"sync"
+	"time"
+
+	"github.com/gosight/gosight/processor/internal/eventtype"
+	"github.com/gosight/gosight/processor/internal/settings"
+)
+
+// RuleEngine emits the custom insights defined by projects' insight rules
+// (see settings.InsightRule), counting matching events per session in a
+// sliding window
+type RuleEngine struct {
+	sessionData sync.Map // sessionID -> *RuleTrackingData
+}
+
+// RuleTrackingData tracks the session's events matching each rule
+type RuleTrackingData struct {
+	Hits map[string][]RuleHit // rule type -> matching events in the window
+	mu   sync.Mutex
+}
+
+// RuleHit is an event matching a rule
+type RuleHit struct {
+	EventID   string
+	Timestamp int64
+}
+
+// NewRuleEngine creates a new rule engine
+func NewRuleEngine() *RuleEngine {
+	return &RuleEngine{}
+}
+
+// ProcessEvent matches an event of the resolved type against the project's
+// rules. A rule firing starts counting over.
+func (e *RuleEngine) ProcessEvent(event *Event, eventType eventtype.Type, rules []settings.InsightRule) []*Insight {
+	var data *RuleTrackingData
+	var insights []*Insight
+
+	for _, rule := range rules {
+		if !rule.Matches(eventType, event.EventName, event.Path) {
+			continue
+		}
+
+		if data == nil {
+			dataI, _ := e.sessionData.LoadOrStore(event.SessionID, &RuleTrackingData{Hits: make(map[string][]RuleHit)})
+			data = dataI.(*RuleTrackingData)
+			data.mu.Lock()
+			defer data.mu.Unlock()
+		}
+
+		// Keep the window
+		hits := append(data.Hits[rule.Type], RuleHit{EventID: event.EventID, Timestamp: event.Timestamp})
+		cutoff := event.Timestamp - rule.WindowMs
+		for len(hits) > 0 && hits[0].Timestamp < cutoff {
+			hits = hits[1:]
+		}
+
+		if len(hits) < rule.Count {
+			data.Hits[rule.Type] = hits
+			continue
+		}
+		delete(data.Hits, rule.Type)
+
+		eventIDs := make([]string, len(hits))
+		for i, hit := range hits {
+			eventIDs[i] = hit.EventID
+		}
+
+		details := map[string]interface{}{
+			"rule":       rule.Type,
+			"event_type": rule.EventType,
+			"count":      len(hits),
+			"window_ms":  rule.WindowMs,
+			"page":       event.Path,
+		}
+		if rule.EventName != "" {
+			details["event_name"] = rule.EventName
+		}
+		if rule.Path != "" {
+			details["path_pattern"] = rule.Path
+		}
+
+		insights = append(insights, &Insight{
+			Type:            rule.Type,
+			ProjectID:       event.ProjectID,
+			SessionID:       event.SessionID,
+			Timestamp:       time.Now(),
+			URL:             event.URL,
+			Path:            event.Path,
+			Details:         details,
+			RelatedEventIDs: eventIDs,
+			Confidence:      1, // the rule matched exactly as configured
+		})
+	}
+
+	return insights
+}
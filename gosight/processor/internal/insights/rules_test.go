@@ -0,0 +1,73 @@
+package insights
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/gosight/gosight/processor/internal/eventtype"
+	"github.com/gosight/gosight/processor/internal/settings"
+)
+
+func ruleEvent(id string, timestamp int64, name string) *Event {
+	return &Event{
+		EventID:   id,
+		ProjectID: "proj",
+		SessionID: "sess",
+		Timestamp: timestamp,
+		EventName: name,
+		Path:      "/search",
+	}
+}
+
+func TestRuleEngineCountsWithinWindow(t *testing.T) {
+	e := NewRuleEngine()
+	rules := []settings.InsightRule{
+		// Three searches without results within two minutes
+		{Type: "empty_searches", EventType: "custom", EventName: "search_no_results", Count: 3, WindowMs: 120000},
+	}
+
+	// Each event is out of the previous one's window until the last two
+	timestamps := []int64{0, 130_000, 260_000, 270_000}
+	for i, ts := range timestamps {
+		if insights := e.ProcessEvent(ruleEvent(fmt.Sprintf("e%d", i), ts, "search_no_results"), eventtype.Custom, rules); len(insights) != 0 {
+			t.Fatalf("rule fired at event %d", i)
+		}
+	}
+	// Other custom events don't count
+	if insights := e.ProcessEvent(ruleEvent("other", 275_000, "search"), eventtype.Custom, rules); len(insights) != 0 {
+		t.Fatal("rule fired on another custom event")
+	}
+
+	insights := e.ProcessEvent(ruleEvent("e4", 280_000, "search_no_results"), eventtype.Custom, rules)
+	if len(insights) != 1 {
+		t.Fatalf("got %d insights, want 1", len(insights))
+	}
+	insight := insights[0]
+	if insight.Type != "empty_searches" || insight.Details["count"] != 3 || insight.Details["event_name"] != "search_no_results" {
+		t.Errorf("insight = %+v", insight)
+	}
+	if len(insight.RelatedEventIDs) != 3 || insight.RelatedEventIDs[0] != "e2" {
+		t.Errorf("related events = %v, want the three in the window", insight.RelatedEventIDs)
+	}
+
+	// Firing starts counting over
+	if insights := e.ProcessEvent(ruleEvent("e5", 281_000, "search_no_results"), eventtype.Custom, rules); len(insights) != 0 {
+		t.Error("rule fired again right after firing")
+	}
+}
+
+func TestRuleEngineSingleEventRules(t *testing.T) {
+	e := NewRuleEngine()
+	rules := []settings.InsightRule{
+		{Type: "search_visit", EventType: "page_view", Count: 1},
+		{Type: "search_error", EventType: "js_error", Count: 1},
+	}
+
+	insights := e.ProcessEvent(ruleEvent("e1", 0, ""), eventtype.PageView, rules)
+	if len(insights) != 1 || insights[0].Type != "search_visit" {
+		t.Fatalf("insights = %v, want search_visit", insights)
+	}
+	if insights := e.ProcessEvent(ruleEvent("e2", 0, ""), eventtype.Click, rules); len(insights) != 0 {
+		t.Errorf("click matched %v", insights)
+	}
+}
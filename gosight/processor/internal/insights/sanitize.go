@@ -0,0 +1,176 @@
+package insights
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"strconv"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"github.com/segmentio/kafka-go"
+
+	"github.com/gosight/gosight/processor/internal/config"
+	"github.com/gosight/gosight/processor/internal/kafkaauth"
+	"github.com/gosight/gosight/processor/internal/storage"
+)
+
+// SanitizeDetails returns details with the values JSON can't encode replaced:
+// NaN and infinite floats (e.g. from a web vitals calculation over no samples)
+// become "NaN", "+Inf" or "-Inf", and other unencodable values their fmt
+// representation. It also returns the number of values replaced; details is
+// returned as is when there are none.
+func SanitizeDetails(details map[string]interface{}) (map[string]interface{}, int) {
+	v, replaced := sanitizeValue(details)
+	if replaced == 0 {
+		return details, 0
+	}
+	return v.(map[string]interface{}), replaced
+}
+
+func sanitizeValue(v interface{}) (interface{}, int) {
+	switch x := v.(type) {
+	case nil, string, bool, int, int32, int64, uint32, uint64:
+		return v, 0
+	case float64:
+		return sanitizeFloat(x)
+	case float32:
+		if f, n := sanitizeFloat(float64(x)); n > 0 {
+			return f, n
+		}
+		return v, 0
+	case map[string]interface{}:
+		var out map[string]interface{}
+		replaced := 0
+		for k, item := range x {
+			clean, n := sanitizeValue(item)
+			if n > 0 && out == nil {
+				// Copy on first replacement; detectors may still hold the map
+				out = make(map[string]interface{}, len(x))
+				for k2, item2 := range x {
+					out[k2] = item2
+				}
+			}
+			if out != nil {
+				out[k] = clean
+			}
+			replaced += n
+		}
+		if out == nil {
+			return v, 0
+		}
+		return out, replaced
+	case []interface{}:
+		var out []interface{}
+		replaced := 0
+		for i, item := range x {
+			clean, n := sanitizeValue(item)
+			if n > 0 && out == nil {
+				out = append([]interface{}{}, x...)
+			}
+			if out != nil {
+				out[i] = clean
+			}
+			replaced += n
+		}
+		if out == nil {
+			return v, 0
+		}
+		return out, replaced
+	case []float64:
+		items := make([]interface{}, len(x))
+		for i, f := range x {
+			items[i] = f
+		}
+		if clean, n := sanitizeValue(items); n > 0 {
+			return clean, n
+		}
+		return v, 0
+	}
+
+	// Anything else (structs, typed slices and maps, ...) is kept if it encodes
+	if _, err := json.Marshal(v); err != nil {
+		return fmt.Sprintf("%v", v), 1
+	}
+	return v, 0
+}
+
+func sanitizeFloat(f float64) (interface{}, int) {
+	switch {
+	case math.IsNaN(f):
+		return "NaN", 1
+	case math.IsInf(f, 1):
+		return "+Inf", 1
+	case math.IsInf(f, -1):
+		return "-Inf", 1
+	}
+	return f, 0
+}
+
+// newDeadLetterWriter creates the writer for insights that can't be stored, or
+// nil when no "insights_dlq" topic is configured
+func newDeadLetterWriter(kafkaCfg config.KafkaConfig) *kafka.Writer {
+	topic := kafkaCfg.Topics["insights_dlq"]
+	if topic == "" || len(kafkaCfg.Brokers) == 0 {
+		return nil
+	}
+	return &kafka.Writer{
+		Addr:                   kafka.TCP(kafkaCfg.Brokers...),
+		Transport:              kafkaauth.Transport(kafkaCfg),
+		Topic:                  topic,
+		Balancer:               &kafka.LeastBytes{},
+		BatchTimeout:           time.Millisecond * 10,
+		AllowAutoTopicCreation: true,
+	}
+}
+
+// deadLetter writes insight rows that could not be stored to the insights_dlq
+// topic, with the cause in a header, so they can be inspected and re-inserted
+func (p *Processor) deadLetter(ctx context.Context, rows []storage.InsightRow, cause error) {
+	if p.deadLetterWriter == nil {
+		return
+	}
+
+	msgs := make([]kafka.Message, 0, len(rows))
+	for _, row := range rows {
+		record := map[string]interface{}{
+			"insight_id":          row.InsightID.String(),
+			"project_id":          row.ProjectID,
+			"session_id":          row.SessionID,
+			"insight_type":        row.InsightType,
+			"timestamp":           row.Timestamp,
+			"url":                 row.URL,
+			"path":                row.Path,
+			"x":                   row.X,
+			"y":                   row.Y,
+			"target_selector":     row.TargetSelector,
+			"normalized_selector": row.NormalizedSelector,
+			"details":             row.Details,
+			"related_event_ids":   row.RelatedEventIDs,
+		}
+		data, err := json.Marshal(record)
+		if err != nil {
+			// Details still can't be encoded; keep them readable
+			record["details"] = fmt.Sprintf("%v", row.Details)
+			if data, err = json.Marshal(record); err != nil {
+				log.Error().Err(err).Str("insight_id", row.InsightID.String()).Msg("Failed to encode dead-lettered insight")
+				continue
+			}
+		}
+		msgs = append(msgs, kafka.Message{
+			Key:   []byte(row.ProjectID),
+			Value: data,
+			Headers: []kafka.Header{
+				{Key: "x-error", Value: []byte(cause.Error())},
+				{Key: "x-failed-at", Value: []byte(strconv.FormatInt(time.Now().UnixMilli(), 10))},
+			},
+		})
+	}
+
+	if err := p.deadLetterWriter.WriteMessages(ctx, msgs...); err != nil {
+		log.Error().Err(err).Int("count", len(msgs)).Msg("Failed to write insights to DLQ")
+	} else {
+		log.Warn().Err(cause).Int("count", len(msgs)).Msg("Insights dead-lettered")
+	}
+}
@@ -0,0 +1,65 @@
+package insights
+
+import (
+	"context"
+	"encoding/json"
+	"math"
+	"testing"
+)
+
+func TestSanitizeDetails(t *testing.T) {
+	details := map[string]interface{}{
+		"load_time_ms": 3000.0,
+		"lcp":          math.NaN(),
+		"samples":      []interface{}{1.0, math.Inf(1)},
+		"vitals":       map[string]interface{}{"cls": math.Inf(-1), "inp": float32(120)},
+		"series":       []float64{1, math.NaN()},
+		"callback":     func() {},
+	}
+
+	clean, replaced := SanitizeDetails(details)
+	if replaced != 5 {
+		t.Errorf("replaced %d values, want 5", replaced)
+	}
+	if _, err := json.Marshal(clean); err != nil {
+		t.Fatalf("sanitized details don't encode: %v", err)
+	}
+	if clean["lcp"] != "NaN" || clean["vitals"].(map[string]interface{})["cls"] != "-Inf" || clean["load_time_ms"] != 3000.0 {
+		t.Errorf("sanitized details = %v", clean)
+	}
+	if !math.IsNaN(details["lcp"].(float64)) {
+		t.Error("the detector's details were modified")
+	}
+
+	ok := map[string]interface{}{"click_count": 5, "selector": "button"}
+	if clean, replaced := SanitizeDetails(ok); replaced != 0 || len(clean) != 2 {
+		t.Errorf("encodable details changed: %v, %d replaced", clean, replaced)
+	}
+}
+
+func TestWriteInsightSanitizesDetails(t *testing.T) {
+	p := &Processor{}
+	insight := &Insight{
+		Type:      "slow_page",
+		ProjectID: "proj",
+		SessionID: "sess",
+		Details:   map[string]interface{}{"lcp": math.NaN(), "load_time_ms": 3000.0},
+	}
+	p.writeInsight(context.Background(), insight)
+
+	if len(p.insightBuffer) != 1 {
+		t.Fatalf("buffered %d insights, want the sanitized one", len(p.insightBuffer))
+	}
+	// Stored and alerted with the placeholder rather than dropped
+	for name, details := range map[string]map[string]interface{}{
+		"stored":  p.insightBuffer[0].Details,
+		"alerted": insight.Details,
+	} {
+		if details["lcp"] != "NaN" || details["load_time_ms"] != 3000.0 {
+			t.Errorf("%s details = %v", name, details)
+		}
+		if _, err := json.Marshal(details); err != nil {
+			t.Errorf("%s details don't encode: %v", name, err)
+		}
+	}
+}
@@ -0,0 +1,19 @@
+package insights
+
+import "context"
+
+// Sink receives every insight the Processor's detectors emit. By default
+// insights go to storage (project toggles, rate cap, replay keep flags,
+// ClickHouse and alerts); SetSink routes them elsewhere, e.g. to capture
+// them in tests.
+type Sink interface {
+	Emit(ctx context.Context, insight *Insight)
+}
+
+// SinkFunc adapts a function to a Sink
+type SinkFunc func(ctx context.Context, insight *Insight)
+
+// Emit calls f
+func (f SinkFunc) Emit(ctx context.Context, insight *Insight) {
+	f(ctx, insight)
+}
@@ -0,0 +1,57 @@
+package insights
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/gosight/gosight/processor/internal/config"
+)
+
+// rawEvent returns an event as consumed from Kafka
+func rawEvent(eventType string, i int, payload map[string]interface{}) map[string]interface{} {
+	return map[string]interface{}{
+		"event_id":   fmt.Sprintf("evt-%d", i),
+		"type":       eventType,
+		"project_id": "proj",
+		"session_id": "sess",
+		"timestamp":  float64(1_700_000_000_000 + int64(i)*50),
+		"page":       map[string]interface{}{"url": "https://example.com/checkout", "path": "/checkout"},
+		"payload":    payload,
+	}
+}
+
+func TestDetectorsEmitThroughSink(t *testing.T) {
+	p := &Processor{
+		pageTracker:    NewPageTracker(),
+		rules:          NewRuleEngine(),
+		thrashedCursor: NewThrashedCursorDetector(testThrashedCursorConfig()),
+		deadClick:      NewDeadClickDetector(config.DeadClickConfig{Enabled: true, ObservationWindowMs: 1000}),
+	}
+	emitted := make(map[string]int)
+	p.SetSink(SinkFunc(func(ctx context.Context, insight *Insight) {
+		emitted[insight.Type]++
+	}))
+	ctx := context.Background()
+
+	// Returned by the detector while processing the event
+	for i := 0; i < 60; i++ {
+		x := 400.0
+		if (i/3)%2 == 1 {
+			x = 700
+		}
+		p.Process(ctx, rawEvent("mouse_move", i, map[string]interface{}{"mouse_x": x, "mouse_y": 300.0}))
+	}
+
+	// Reported when the detector's window expires
+	p.Process(ctx, rawEvent("click", 100, map[string]interface{}{"target_tag": "button", "x": 10.0, "y": 10.0}))
+	p.expire(ctx, time.Now().Add(2*time.Second))
+
+	if emitted["thrashed_cursor"] != 1 || emitted["dead_click"] != 1 {
+		t.Errorf("emitted %v, want one thrashed_cursor and one dead_click", emitted)
+	}
+	if len(p.insightBuffer) != 0 {
+		t.Errorf("%d insights stored around the sink", len(p.insightBuffer))
+	}
+}
@@ -1,52 +1,212 @@
 package insights
 
 import (
+	"math"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/gosight/gosight/processor/internal/config"
 )
 
-// SlowPageDetector detects pages with poor performance metrics
+// maxSlowPageEventIDs bounds the related event IDs kept per debounce window
+const maxSlowPageEventIDs = 20
+
+// SlowPageDetector detects pages with poor performance metrics. Slow loads of
+// the same page are debounced: one insight per (project, page) and interval,
+// carrying how many loads were slow.
 type SlowPageDetector struct {
+	thresholds atomic.Pointer[slowPageThresholds]
+	windows    map[string]*SlowPageWindow // projectID:path -> open window
+	mu         sync.Mutex
+}
+
+// slowPageThresholds are the settings of SlowPageDetector reloadable at runtime
+type slowPageThresholds struct {
 	lcpThresholdMs  int64
 	ttfbThresholdMs int64
+	debounce        time.Duration
+}
+
+// SlowPageWindow aggregates the slow loads of a page within a debounce interval
+type SlowPageWindow struct {
+	Slowest   *Insight // insight of the slowest load so far
+	SlowCount int
+	EventIDs  []string
+	Start     time.Time
 }
 
 // NewSlowPageDetector creates a new slow page detector
 func NewSlowPageDetector(cfg config.SlowPageConfig) *SlowPageDetector {
-	return &SlowPageDetector{
+	d := &SlowPageDetector{
+		windows: make(map[string]*SlowPageWindow),
+	}
+	d.SetThresholds(cfg)
+	return d
+}
+
+// SetThresholds applies new thresholds to the running detector; open debounce
+// windows are kept and close by the new debounce interval
+func (d *SlowPageDetector) SetThresholds(cfg config.SlowPageConfig) {
+	d.thresholds.Store(&slowPageThresholds{
 		lcpThresholdMs:  cfg.LCPThresholdMs,
 		ttfbThresholdMs: cfg.TTFBThresholdMs,
+		debounce:        time.Duration(cfg.DebounceMs) * time.Millisecond,
+	})
+}
+
+// ProcessPerformance processes web vitals events and records slow loads in the
+// page's debounce window; insights are emitted by Expire when the window closes
+func (d *SlowPageDetector) ProcessPerformance(event *Event) {
+	insight := d.slowPageInsight(event)
+	if insight == nil {
+		return
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	key := event.ProjectID + ":" + normalizePagePath(event.Path)
+	window, ok := d.windows[key]
+	if !ok {
+		window = &SlowPageWindow{Slowest: insight, Start: time.Now()}
+		d.windows[key] = window
+	}
+
+	window.SlowCount++
+	if len(window.EventIDs) < maxSlowPageEventIDs {
+		window.EventIDs = append(window.EventIDs, event.EventID)
+	}
+	loadTime, _ := insight.Details["load_time_ms"].(float64)
+	slowest, _ := window.Slowest.Details["load_time_ms"].(float64)
+	if loadTime > slowest {
+		window.Slowest = insight
+	}
+}
+
+// Expire closes debounce windows older than the debounce interval and returns
+// one insight per page, based on its slowest load
+func (d *SlowPageDetector) Expire(now time.Time) []*Insight {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	debounce := d.thresholds.Load().debounce
+	var insights []*Insight
+	for key, window := range d.windows {
+		if now.Sub(window.Start) < debounce {
+			continue
+		}
+		delete(d.windows, key)
+		insights = append(insights, window.close(now))
+	}
+
+	return insights
+}
+
+// CloseAll closes every open debounce window early, e.g. on shutdown, and
+// returns one insight per page
+func (d *SlowPageDetector) CloseAll(now time.Time) []*Insight {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	insights := make([]*Insight, 0, len(d.windows))
+	for key, window := range d.windows {
+		delete(d.windows, key)
+		insights = append(insights, window.close(now))
+	}
+	return insights
+}
+
+// close returns the insight of the window's slowest load, summarizing the window
+func (w *SlowPageWindow) close(now time.Time) *Insight {
+	insight := w.Slowest
+	insight.Timestamp = now
+	insight.Details["slow_count"] = w.SlowCount
+	insight.Details["window_start"] = w.Start.UnixMilli()
+	insight.Details["window_ms"] = now.Sub(w.Start).Milliseconds()
+	insight.RelatedEventIDs = w.EventIDs
+	return insight
+}
+
+// SaveState returns the debounce windows opened since cutoff, as JSON
+func (d *SlowPageDetector) SaveState(cutoff time.Time) ([]byte, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	windows := make(map[string]*SlowPageWindow, len(d.windows))
+	for key, window := range d.windows {
+		if !window.Start.Before(cutoff) {
+			windows[key] = window
+		}
+	}
+	return saveProcessingTimeState(windows)
+}
+
+// RestoreState restores the windows returned by SaveState, resuming where they
+// were saved; pages with a window opened since startup keep it
+func (d *SlowPageDetector) RestoreState(data []byte) error {
+	var windows map[string]*SlowPageWindow
+	downtime, err := restoreProcessingTimeState(data, &windows)
+	if err != nil {
+		return err
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for key, window := range windows {
+		if _, ok := d.windows[key]; ok || window == nil || window.Slowest == nil || window.Slowest.Details == nil {
+			continue
+		}
+		window.Start = window.Start.Add(downtime)
+		d.windows[key] = window
+	}
+	return nil
+}
+
+// normalizePagePath drops the query, fragment and trailing slash so variants of a page share a window
+func normalizePagePath(path string) string {
+	if i := strings.IndexAny(path, "?#"); i >= 0 {
+		path = path[:i]
+	}
+	if len(path) > 1 {
+		path = strings.TrimSuffix(path, "/")
 	}
+	return path
 }
 
-// ProcessPerformance processes web vitals events and detects slow pages
-func (d *SlowPageDetector) ProcessPerformance(event *Event) *Insight {
+// slowPageInsight returns an insight if any metric of the event exceeds its
+// threshold. Its confidence grades the metric furthest over its threshold.
+func (d *SlowPageDetector) slowPageInsight(event *Event) *Insight {
+	t := d.thresholds.Load()
 	var reasons []string
-	var slowestMetric float64
+	var slowestMetric, confidence float64
 
 	// Check LCP (Largest Contentful Paint)
-	if event.LCP != nil && *event.LCP > float64(d.lcpThresholdMs) {
+	if event.LCP != nil && *event.LCP > float64(t.lcpThresholdMs) {
 		reasons = append(reasons, "lcp")
 		if *event.LCP > slowestMetric {
 			slowestMetric = *event.LCP
 		}
+		confidence = math.Max(confidence, overThresholdConfidence(*event.LCP, float64(t.lcpThresholdMs)))
 	}
 
 	// Check TTFB (Time to First Byte)
-	if event.TTFB != nil && *event.TTFB > float64(d.ttfbThresholdMs) {
+	if event.TTFB != nil && *event.TTFB > float64(t.ttfbThresholdMs) {
 		reasons = append(reasons, "ttfb")
 		if *event.TTFB > slowestMetric {
 			slowestMetric = *event.TTFB
 		}
+		confidence = math.Max(confidence, overThresholdConfidence(*event.TTFB, float64(t.ttfbThresholdMs)))
 	}
 
 	// Check FCP (First Contentful Paint) - use LCP threshold as approximation
-	if event.FCP != nil && *event.FCP > float64(d.lcpThresholdMs)*0.8 {
+	if event.FCP != nil && *event.FCP > float64(t.lcpThresholdMs)*0.8 {
 		reasons = append(reasons, "fcp")
 		if *event.FCP > slowestMetric {
 			slowestMetric = *event.FCP
 		}
+		confidence = math.Max(confidence, overThresholdConfidence(*event.FCP, float64(t.lcpThresholdMs)*0.8))
 	}
 
 	if len(reasons) == 0 {
@@ -79,13 +239,14 @@ func (d *SlowPageDetector) ProcessPerformance(event *Event) *Insight {
 	}
 
 	return &Insight{
-		Type:      "slow_page",
-		ProjectID: event.ProjectID,
-		SessionID: event.SessionID,
-		Timestamp: time.Now(),
-		URL:       event.URL,
-		Path:      event.Path,
-		Details:   details,
+		Type:            "slow_page",
+		ProjectID:       event.ProjectID,
+		SessionID:       event.SessionID,
+		Timestamp:       time.Now(),
+		URL:             event.URL,
+		Path:            event.Path,
+		Details:         details,
 		RelatedEventIDs: []string{event.EventID},
+		Confidence:      confidence,
 	}
 }
@@ -0,0 +1,168 @@
+package insights
+
+import (
+	"sync"
+	"time"
+
+	"github.com/gosight/gosight/processor/internal/config"
+	"github.com/gosight/gosight/processor/internal/eventtype"
+)
+
+// SlowPageAbandonmentDetector detects sessions that leave a slow page without
+// interacting: a slow load (by the slow_page thresholds) followed by a page exit,
+// or by no interaction within the abandonment window. Unlike slow_page, which
+// reports that a page is slow, it reports sessions the slowness cost.
+type SlowPageAbandonmentDetector struct {
+	slowPage *SlowPageDetector // classifies loads; its windows are not used
+	window   time.Duration
+	sessions map[string]*PendingSlowLoad // sessionID -> last slow load
+	mu       sync.Mutex
+}
+
+// PendingSlowLoad is a slow load waiting for the session's next interaction
+type PendingSlowLoad struct {
+	Insight *Insight // slow_page insight of the load
+	SlowAt  time.Time
+}
+
+// NewSlowPageAbandonmentDetector creates a new slow page abandonment detector
+func NewSlowPageAbandonmentDetector(cfg config.SlowPageAbandonmentConfig, slowCfg config.SlowPageConfig) *SlowPageAbandonmentDetector {
+	return &SlowPageAbandonmentDetector{
+		slowPage: NewSlowPageDetector(slowCfg),
+		window:   time.Duration(cfg.AbandonmentWindowMs) * time.Millisecond,
+		sessions: make(map[string]*PendingSlowLoad),
+	}
+}
+
+// SetThresholds applies a new abandonment window and slow_page thresholds to
+// the running detector; pending slow loads are kept
+func (d *SlowPageAbandonmentDetector) SetThresholds(cfg config.SlowPageAbandonmentConfig, slowCfg config.SlowPageConfig) {
+	d.slowPage.SetThresholds(slowCfg)
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.window = time.Duration(cfg.AbandonmentWindowMs) * time.Millisecond
+}
+
+// ProcessPerformance records a slow load of the session's current page
+func (d *SlowPageAbandonmentDetector) ProcessPerformance(event *Event) {
+	insight := d.slowPage.slowPageInsight(event)
+	if insight == nil {
+		return
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.sessions[event.SessionID] = &PendingSlowLoad{Insight: insight, SlowAt: time.Now()}
+}
+
+// ProcessEvent resolves the session's pending slow load: an interaction means
+// the user stayed, a page exit that they left. Returns an insight on a page exit.
+func (d *SlowPageAbandonmentDetector) ProcessEvent(event *Event, eventType eventtype.Type) *Insight {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	pending, ok := d.sessions[event.SessionID]
+	if !ok {
+		return nil
+	}
+
+	switch eventType {
+	case eventtype.Click, eventtype.Scroll, eventtype.InputChange, eventtype.InputFocus,
+		eventtype.FormSubmit, eventtype.PageView:
+		delete(d.sessions, event.SessionID)
+		return nil
+	case eventtype.PageExit:
+		delete(d.sessions, event.SessionID)
+		insight := d.abandonmentInsight(pending, time.Now(), "page_exit")
+		insight.RelatedEventIDs = append(insight.RelatedEventIDs, event.EventID)
+		return insight
+	}
+	return nil
+}
+
+// Expire returns an insight for each session with no interaction within the
+// abandonment window of its slow load
+func (d *SlowPageAbandonmentDetector) Expire(now time.Time) []*Insight {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	var insights []*Insight
+	for sessionID, pending := range d.sessions {
+		if now.Sub(pending.SlowAt) < d.window {
+			continue
+		}
+		delete(d.sessions, sessionID)
+		insights = append(insights, d.abandonmentInsight(pending, now, "inactive"))
+	}
+
+	return insights
+}
+
+// SaveState returns the slow loads pending since cutoff, as JSON
+func (d *SlowPageAbandonmentDetector) SaveState(cutoff time.Time) ([]byte, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	sessions := make(map[string]*PendingSlowLoad, len(d.sessions))
+	for sessionID, pending := range d.sessions {
+		if !pending.SlowAt.Before(cutoff) {
+			sessions[sessionID] = pending
+		}
+	}
+	return saveProcessingTimeState(sessions)
+}
+
+// RestoreState restores the slow loads returned by SaveState, their
+// abandonment windows resuming where they were saved; sessions with a slow
+// load since startup keep it
+func (d *SlowPageAbandonmentDetector) RestoreState(data []byte) error {
+	var sessions map[string]*PendingSlowLoad
+	downtime, err := restoreProcessingTimeState(data, &sessions)
+	if err != nil {
+		return err
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for sessionID, pending := range sessions {
+		if _, ok := d.sessions[sessionID]; ok || pending == nil || pending.Insight == nil {
+			continue
+		}
+		pending.SlowAt = pending.SlowAt.Add(downtime)
+		d.sessions[sessionID] = pending
+	}
+	return nil
+}
+
+func (d *SlowPageAbandonmentDetector) abandonmentInsight(pending *PendingSlowLoad, now time.Time, reason string) *Insight {
+	slow := pending.Insight
+
+	details := make(map[string]interface{}, len(slow.Details)+3)
+	for k, v := range slow.Details {
+		details[k] = v
+	}
+	details["abandonment_reason"] = reason
+	details["time_since_slow_ms"] = now.Sub(pending.SlowAt).Milliseconds()
+	details["abandonment_window_ms"] = d.window.Milliseconds()
+
+	// The slow load's confidence; leaving the page is clear, while inactivity
+	// may be the user reading a page that did load
+	confidence := slow.Confidence
+	if reason == "inactive" {
+		confidence *= inactiveConfidenceFactor
+	}
+
+	return &Insight{
+		Type:            "slow_page_caused_abandonment",
+		ProjectID:       slow.ProjectID,
+		SessionID:       slow.SessionID,
+		Timestamp:       now,
+		URL:             slow.URL,
+		Path:            slow.Path,
+		Details:         details,
+		RelatedEventIDs: slow.RelatedEventIDs,
+		Confidence:      confidence,
+	}
+}
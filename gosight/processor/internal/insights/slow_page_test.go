@@ -0,0 +1,101 @@
+package insights
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/gosight/gosight/processor/internal/config"
+)
+
+func newTestSlowPageDetector() *SlowPageDetector {
+	return NewSlowPageDetector(config.SlowPageConfig{
+		Enabled:         true,
+		LCPThresholdMs:  2500,
+		TTFBThresholdMs: 800,
+		DebounceMs:      60_000,
+	})
+}
+
+func slowLoad(eventID, path string, lcp float64) *Event {
+	return &Event{
+		EventID:   eventID,
+		ProjectID: "proj",
+		SessionID: "sess-" + eventID,
+		URL:       "https://example.com" + path,
+		Path:      path,
+		LCP:       &lcp,
+	}
+}
+
+func TestSlowPageDebouncesLoadsOfAPage(t *testing.T) {
+	d := newTestSlowPageDetector()
+	d.ProcessPerformance(slowLoad("e1", "/checkout", 3000))
+	d.ProcessPerformance(slowLoad("e2", "/checkout/?step=2", 5000))
+	d.ProcessPerformance(slowLoad("e3", "/checkout", 1000)) // not slow
+	d.ProcessPerformance(slowLoad("e4", "/home", 4000))
+
+	if insights := d.Expire(time.Now()); len(insights) != 0 {
+		t.Fatalf("got %d insights before the debounce interval", len(insights))
+	}
+
+	insights := d.Expire(time.Now().Add(time.Minute))
+	if len(insights) != 2 {
+		t.Fatalf("got %d insights, want one per page", len(insights))
+	}
+	for _, insight := range insights {
+		if insight.Path != "/checkout" && insight.Path != "/checkout/?step=2" {
+			continue
+		}
+		if insight.Details["slow_count"] != 2 {
+			t.Errorf("slow_count = %v, want 2", insight.Details["slow_count"])
+		}
+		if insight.Details["load_time_ms"] != 5000.0 {
+			t.Errorf("load_time_ms = %v, want the slowest load", insight.Details["load_time_ms"])
+		}
+		if len(insight.RelatedEventIDs) != 2 {
+			t.Errorf("RelatedEventIDs = %v, want e1 and e2", insight.RelatedEventIDs)
+		}
+	}
+}
+
+func TestSlowPageCloseAll(t *testing.T) {
+	d := newTestSlowPageDetector()
+	d.ProcessPerformance(slowLoad("e1", "/checkout", 3000))
+
+	insights := d.CloseAll(time.Now())
+	if len(insights) != 1 {
+		t.Fatalf("got %d insights, want 1", len(insights))
+	}
+	if insights := d.Expire(time.Now().Add(time.Hour)); len(insights) != 0 {
+		t.Errorf("got %d insights from a closed window", len(insights))
+	}
+}
+
+func TestSlowPageToleratesMissingLoadTime(t *testing.T) {
+	d := newTestSlowPageDetector()
+	d.windows["proj:/checkout"] = &SlowPageWindow{
+		Slowest: &Insight{Type: "slow_page", Details: map[string]interface{}{}},
+		Start:   time.Now(),
+	}
+
+	d.ProcessPerformance(slowLoad("e1", "/checkout", 3000))
+	if got := d.windows["proj:/checkout"].Slowest.Details["load_time_ms"]; got != 3000.0 {
+		t.Errorf("load_time_ms = %v, want 3000", got)
+	}
+}
+
+func TestStopEmitsOpenSlowPageWindows(t *testing.T) {
+	var emitted []*Insight
+	p := &Processor{slowPage: newTestSlowPageDetector()}
+	p.SetSink(SinkFunc(func(ctx context.Context, insight *Insight) {
+		emitted = append(emitted, insight)
+	}))
+
+	p.slowPage.ProcessPerformance(slowLoad("e1", "/checkout", 3000))
+	p.Stop()
+
+	if len(emitted) != 1 || emitted[0].Type != "slow_page" {
+		t.Fatalf("emitted %v, want the open slow_page window", emitted)
+	}
+}
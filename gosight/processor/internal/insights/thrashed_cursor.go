@@ -1,8 +1,11 @@
 package insights
 
 import (
+	"encoding/json"
 	"math"
+	"slices"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/gosight/gosight/processor/internal/config"
@@ -10,10 +13,22 @@ import (
 
 // ThrashedCursorDetector detects erratic mouse movements indicating confusion
 type ThrashedCursorDetector struct {
-	minDurationMs       int64
-	minDirectionChanges int
-	minVelocity         int
-	sessionData         sync.Map // sessionID -> *CursorTrackingData
+	thresholds  atomic.Pointer[thrashedCursorThresholds]
+	sessionData sync.Map // sessionID -> *CursorTrackingData
+}
+
+// thrashedCursorThresholds are the settings of ThrashedCursorDetector
+// reloadable at runtime
+type thrashedCursorThresholds struct {
+	minDurationMs        int64
+	minDirectionChanges  int
+	minVelocity          int
+	scoreThreshold       float64
+	directionWeight      float64
+	velocityWeight       float64
+	gateDirectionChanges int
+	smoothingWindow      int
+	minSegmentPx         float64
 }
 
 // CursorTrackingData tracks mouse movement data per session
@@ -22,6 +37,9 @@ type CursorTrackingData struct {
 	DirectionChanges int
 	StartTime        int64
 	LastDirection    float64
+	// Smoothed position the last direction was measured to
+	AnchorX, AnchorY float64
+	HasAnchor        bool
 	mu               sync.Mutex
 }
 
@@ -34,11 +52,25 @@ type MousePoint struct {
 
 // NewThrashedCursorDetector creates a new thrashed cursor detector
 func NewThrashedCursorDetector(cfg config.ThrashedCursorConfig) *ThrashedCursorDetector {
-	return &ThrashedCursorDetector{
-		minDurationMs:       cfg.MinDurationMs,
-		minDirectionChanges: cfg.MinDirectionChanges,
-		minVelocity:         cfg.MinVelocity,
-	}
+	d := &ThrashedCursorDetector{}
+	d.SetThresholds(cfg)
+	return d
+}
+
+// SetThresholds applies new thresholds to the running detector; cursor
+// movement already tracked is kept
+func (d *ThrashedCursorDetector) SetThresholds(cfg config.ThrashedCursorConfig) {
+	d.thresholds.Store(&thrashedCursorThresholds{
+		minDurationMs:        cfg.MinDurationMs,
+		minDirectionChanges:  cfg.MinDirectionChanges,
+		minVelocity:          cfg.MinVelocity,
+		scoreThreshold:       cfg.ScoreThreshold,
+		directionWeight:      cfg.DirectionWeight,
+		velocityWeight:       cfg.VelocityWeight,
+		gateDirectionChanges: cfg.GateDirectionChanges,
+		smoothingWindow:      cfg.SmoothingWindow,
+		minSegmentPx:         float64(cfg.MinSegmentPx),
+	})
 }
 
 // ProcessMouseMove processes a mouse move event
@@ -53,6 +85,8 @@ func (d *ThrashedCursorDetector) ProcessMouseMove(event *Event) *Insight {
 	data.mu.Lock()
 	defer data.mu.Unlock()
 
+	t := d.thresholds.Load()
+
 	// Add new point
 	point := MousePoint{
 		X:         event.MouseX,
@@ -60,33 +94,30 @@ func (d *ThrashedCursorDetector) ProcessMouseMove(event *Event) *Insight {
 		Timestamp: event.Timestamp,
 	}
 
-	// Calculate direction change
-	if len(data.Points) > 0 {
-		lastPoint := data.Points[len(data.Points)-1]
-		dx := float64(point.X - lastPoint.X)
-		dy := float64(point.Y - lastPoint.Y)
+	data.Points = append(data.Points, point)
 
-		if dx != 0 || dy != 0 {
+	// Calculate direction change, between smoothed positions at least
+	// minSegmentPx apart so jitter and sampling noise along a curve don't
+	// count as reversals
+	x, y := smoothedPosition(data.Points, t.smoothingWindow)
+	if data.HasAnchor {
+		dx := x - data.AnchorX
+		dy := y - data.AnchorY
+		if (dx != 0 || dy != 0) && math.Hypot(dx, dy) >= t.minSegmentPx {
 			direction := math.Atan2(dy, dx)
 
-			// Check for direction change (more than 90 degrees)
-			if data.LastDirection != 0 {
-				angleDiff := math.Abs(direction - data.LastDirection)
-				if angleDiff > math.Pi {
-					angleDiff = 2*math.Pi - angleDiff
-				}
-				if angleDiff > math.Pi/2 { // 90 degrees
-					data.DirectionChanges++
-				}
+			if data.LastDirection != 0 && isDirectionChange(data.LastDirection, direction) {
+				data.DirectionChanges++
 			}
 			data.LastDirection = direction
+			data.AnchorX, data.AnchorY = x, y
 		}
+	} else {
+		data.AnchorX, data.AnchorY, data.HasAnchor = x, y, true
 	}
 
-	data.Points = append(data.Points, point)
-
 	// Clean old points (keep last 2 seconds)
-	cutoff := event.Timestamp - d.minDurationMs
+	cutoff := event.Timestamp - t.minDurationMs
 	newPoints := make([]MousePoint, 0, len(data.Points))
 	for _, p := range data.Points {
 		if p.Timestamp >= cutoff {
@@ -101,12 +132,13 @@ func (d *ThrashedCursorDetector) ProcessMouseMove(event *Event) *Insight {
 	}
 
 	duration := event.Timestamp - data.StartTime
-	if duration < d.minDurationMs {
+	if duration < t.minDurationMs {
 		return nil
 	}
 
-	// Check direction changes threshold
-	if data.DirectionChanges < d.minDirectionChanges {
+	// The score can reach the threshold on velocity alone; without enough
+	// direction changes the movement is fast, not erratic
+	if data.DirectionChanges < t.gateDirectionChanges {
 		return nil
 	}
 
@@ -124,9 +156,13 @@ func (d *ThrashedCursorDetector) ProcessMouseMove(event *Event) *Insight {
 	}
 
 	velocity := totalDistance / timeDiff
-	if velocity < float64(d.minVelocity) {
+
+	// Score direction-change rate and velocity relative to the configured baselines
+	directionScore, velocityScore, score := t.thrashScore(data.DirectionChanges, duration, velocity)
+	if score < t.scoreThreshold {
 		return nil
 	}
+	directionChanges := data.DirectionChanges
 
 	// Calculate center point
 	var sumX, sumY int
@@ -141,6 +177,7 @@ func (d *ThrashedCursorDetector) ProcessMouseMove(event *Event) *Insight {
 	data.Points = data.Points[:0]
 	data.DirectionChanges = 0
 	data.StartTime = event.Timestamp
+	data.HasAnchor = false
 
 	return &Insight{
 		Type:      "thrashed_cursor",
@@ -152,10 +189,96 @@ func (d *ThrashedCursorDetector) ProcessMouseMove(event *Event) *Insight {
 		X:         &centerX,
 		Y:         &centerY,
 		Details: map[string]interface{}{
-			"direction_changes": data.DirectionChanges,
+			"direction_changes": directionChanges,
 			"velocity_px_sec":   velocity,
 			"duration_ms":       duration,
+			"thrash_score":      score,
+			"direction_score":   directionScore,
+			"velocity_score":    velocityScore,
+			"score_threshold":   t.scoreThreshold,
 		},
 		RelatedEventIDs: []string{event.EventID},
+		// How far the thrash score went over score_threshold
+		Confidence: overThresholdConfidence(score, t.scoreThreshold),
+	}
+}
+
+// SaveState returns the cursor movement tracked per session, as JSON, leaving
+// out sessions without movement since cutoff
+func (d *ThrashedCursorDetector) SaveState(cutoff time.Time) ([]byte, error) {
+	state := make(map[string]*CursorTrackingData)
+	d.sessionData.Range(func(key, value interface{}) bool {
+		data := value.(*CursorTrackingData)
+		data.mu.Lock()
+		defer data.mu.Unlock()
+
+		if n := len(data.Points); n > 0 && data.Points[n-1].Timestamp >= cutoff.UnixMilli() {
+			state[key.(string)] = &CursorTrackingData{
+				Points:           slices.Clone(data.Points),
+				DirectionChanges: data.DirectionChanges,
+				StartTime:        data.StartTime,
+				LastDirection:    data.LastDirection,
+				AnchorX:          data.AnchorX,
+				AnchorY:          data.AnchorY,
+				HasAnchor:        data.HasAnchor,
+			}
+		}
+		return true
+	})
+	return json.Marshal(state)
+}
+
+// RestoreState restores the state returned by SaveState; sessions already
+// tracked keep their state
+func (d *ThrashedCursorDetector) RestoreState(data []byte) error {
+	var state map[string]*CursorTrackingData
+	if err := json.Unmarshal(data, &state); err != nil {
+		return err
+	}
+	for sessionID, tracking := range state {
+		d.sessionData.LoadOrStore(sessionID, tracking)
+	}
+	return nil
+}
+
+// smoothedPosition averages the last window points (the last point alone for
+// a window of 1 or less)
+func smoothedPosition(points []MousePoint, window int) (x, y float64) {
+	n := min(max(window, 1), len(points))
+	for _, p := range points[len(points)-n:] {
+		x += float64(p.X)
+		y += float64(p.Y)
+	}
+	return x / float64(n), y / float64(n)
+}
+
+// isDirectionChange reports whether a movement direction (radians, as from
+// math.Atan2) turned more than 90 degrees from the previous one
+func isDirectionChange(last, direction float64) bool {
+	angleDiff := math.Abs(direction - last)
+	if angleDiff > math.Pi {
+		angleDiff = 2*math.Pi - angleDiff
+	}
+	return angleDiff > math.Pi/2 // 90 degrees
+}
+
+// thrashScore combines direction-change rate and velocity into a single score.
+// Each component is 1.0 at its baseline: min_direction_changes per min_duration_ms
+// for direction changes and min_velocity for velocity.
+func (t *thrashedCursorThresholds) thrashScore(directionChanges int, durationMs int64, velocity float64) (directionScore, velocityScore, score float64) {
+	if durationMs > 0 && t.minDirectionChanges > 0 {
+		rate := float64(directionChanges) / float64(durationMs)
+		baselineRate := float64(t.minDirectionChanges) / float64(t.minDurationMs)
+		directionScore = rate / baselineRate
+	}
+	if t.minVelocity > 0 {
+		velocityScore = velocity / float64(t.minVelocity)
+	}
+
+	totalWeight := t.directionWeight + t.velocityWeight
+	if totalWeight == 0 {
+		return directionScore, velocityScore, 0
 	}
+	score = (t.directionWeight*directionScore + t.velocityWeight*velocityScore) / totalWeight
+	return directionScore, velocityScore, score
 }
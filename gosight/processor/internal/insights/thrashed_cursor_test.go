@@ -0,0 +1,131 @@
+package insights
+
+import (
+	"math"
+	"testing"
+
+	"github.com/gosight/gosight/processor/internal/config"
+)
+
+func testThrashedCursorConfig() config.ThrashedCursorConfig {
+	return config.ThrashedCursorConfig{
+		Enabled:              true,
+		MinDurationMs:        2000,
+		MinDirectionChanges:  10,
+		MinVelocity:          500,
+		ScoreThreshold:       1.0,
+		DirectionWeight:      0.5,
+		VelocityWeight:       0.5,
+		GateDirectionChanges: 5,
+		SmoothingWindow:      3,
+		MinSegmentPx:         10,
+	}
+}
+
+// moveCursor feeds the points to the detector every intervalMs, returning the
+// first insight emitted
+func moveCursor(d *ThrashedCursorDetector, points [][2]int, intervalMs int64) *Insight {
+	for i, p := range points {
+		insight := d.ProcessMouseMove(&Event{
+			EventID:   "evt",
+			ProjectID: "proj",
+			SessionID: "sess",
+			Timestamp: 1_700_000_000_000 + int64(i)*intervalMs,
+			MouseX:    p[0],
+			MouseY:    p[1],
+		})
+		if insight != nil {
+			return insight
+		}
+	}
+	return nil
+}
+
+func TestThrashedCursorIgnoresFastStraightMovement(t *testing.T) {
+	d := NewThrashedCursorDetector(testThrashedCursorConfig())
+
+	// 1200 px/s to the right for 3s: over twice min_velocity, no reversals
+	var points [][2]int
+	for i := 0; i < 60; i++ {
+		points = append(points, [2]int{i * 60, 300})
+	}
+
+	if insight := moveCursor(d, points, 50); insight != nil {
+		t.Errorf("fast straight movement detected as thrashing: %v", insight.Details)
+	}
+}
+
+func TestThrashedCursorDetectsZigZag(t *testing.T) {
+	d := NewThrashedCursorDetector(testThrashedCursorConfig())
+
+	var points [][2]int
+	for i := 0; i < 60; i++ {
+		x := 400
+		if (i/3)%2 == 1 {
+			x = 700
+		}
+		points = append(points, [2]int{x, 300 + (i%2)*5})
+	}
+
+	insight := moveCursor(d, points, 50)
+	if insight == nil {
+		t.Fatal("zig-zag movement not detected")
+	}
+	if insight.Type != "thrashed_cursor" {
+		t.Errorf("Type = %s", insight.Type)
+	}
+	if changes := insight.Details["direction_changes"].(int); changes < 5 {
+		t.Errorf("direction_changes = %d, want at least the gate", changes)
+	}
+	if score := insight.Details["thrash_score"].(float64); score < 1.0 {
+		t.Errorf("thrash_score = %v, want at least score_threshold", score)
+	}
+}
+
+// noisyArc returns a half circle of radius 400 sampled 300 times, with the
+// 2-3 px back-and-forth jitter of a high-frequency mouse
+func noisyArc() [][2]int {
+	var points [][2]int
+	for i := 0; i < 300; i++ {
+		angle := math.Pi * float64(i) / 300
+		jitter := 3.0
+		if i%2 == 1 {
+			jitter = -2
+		}
+		r := 400 + jitter
+		points = append(points, [2]int{600 + int(r*math.Cos(angle)), 600 - int(r*math.Sin(angle))})
+	}
+	return points
+}
+
+func TestThrashedCursorSmoothingIgnoresNoisyArc(t *testing.T) {
+	// Without smoothing the jitter counts as constant reversals
+	unsmoothed := testThrashedCursorConfig()
+	unsmoothed.SmoothingWindow = 0
+	unsmoothed.MinSegmentPx = 0
+	if insight := moveCursor(NewThrashedCursorDetector(unsmoothed), noisyArc(), 10); insight == nil {
+		t.Fatal("noisy arc not detected without smoothing; the test no longer exercises smoothing")
+	}
+
+	if insight := moveCursor(NewThrashedCursorDetector(testThrashedCursorConfig()), noisyArc(), 10); insight != nil {
+		t.Errorf("smooth arc detected as thrashing: %v", insight.Details)
+	}
+}
+
+func TestThrashScore(t *testing.T) {
+	cfg := testThrashedCursorConfig()
+	d := NewThrashedCursorDetector(cfg)
+	th := d.thresholds.Load()
+
+	// At both baselines the score is 1
+	direction, velocity, score := th.thrashScore(10, 2000, 500)
+	if direction != 1 || velocity != 1 || score != 1 {
+		t.Errorf("thrashScore at baseline = %v, %v, %v, want 1, 1, 1", direction, velocity, score)
+	}
+
+	// Twice the direction-change rate, half the velocity
+	_, _, score = th.thrashScore(20, 2000, 250)
+	if score != 1.25 {
+		t.Errorf("score = %v, want 1.25", score)
+	}
+}
@@ -1,6 +1,7 @@
 package insights
 
 import (
+	"math"
 	"time"
 )
 
@@ -16,6 +17,7 @@ type Event struct {
 	Path           string
 	ClickX         int
 	ClickY         int
+	HasClickCoords bool // x and y were sent; (0,0) is a real click at the origin
 	TargetSelector string
 	TargetTag      string
 	TargetClasses  []string
@@ -23,6 +25,8 @@ type Event struct {
 	TargetHref     string
 	ErrorMessage   string
 	ErrorType      string
+	EventName      string
+	FormSelector   string
 	LCP            *float64
 	FID            *float64
 	CLS            *float64
@@ -31,6 +35,25 @@ type Event struct {
 	INP            *float64
 	MouseX         int
 	MouseY         int
+
+	// SDK hints that the target is clickable without semantic markup
+	HadClickHandler bool // a JS click handler was attached
+	CursorPointer   bool // computed cursor style was pointer
+
+	ScrollTop       int
+	ScrollDirection string // "up" or "down" when sent by the SDK
+
+	// Page views of error pages, when reported by the SDK
+	HTTPStatus int
+	ErrorPage  bool
+
+	// Long tasks
+	LongTaskDurationMs float64
+
+	// Media playback of media_play, media_pause and media_ended events
+	MediaID       string
+	MediaPosition float64 // seconds
+	MediaDuration float64 // seconds
 }
 
 // Insight represents a detected UX insight
@@ -46,4 +69,39 @@ type Insight struct {
 	TargetSelector  string
 	Details         map[string]interface{}
 	RelatedEventIDs []string
+
+	// Confidence is how strongly the signal matched, from 0 to 1; see
+	// overThresholdConfidence. Each detector documents how it computes it.
+	Confidence float64
+}
+
+// overThresholdConfidence grades a signal detected for exceeding a threshold:
+// 0.5 at the threshold, rising towards 1 the further over it the value is
+// (0.75 at twice the threshold). Values under the threshold grade below 0.5.
+func overThresholdConfidence(value, threshold float64) float64 {
+	if threshold <= 0 {
+		return 1
+	}
+	if value <= 0 {
+		return 0
+	}
+	return clampConfidence(1 - 0.5*threshold/value)
+}
+
+// underThresholdConfidence grades a signal detected for staying under a
+// threshold (e.g. a quick return): 1 at zero, falling linearly to 0.5 at the
+// threshold.
+func underThresholdConfidence(value, threshold float64) float64 {
+	if threshold <= 0 {
+		return 1
+	}
+	return clampConfidence(1 - 0.5*value/threshold)
+}
+
+// inactiveConfidenceFactor discounts abandonments inferred from inactivity
+// alone, which may be a user idling on the page rather than leaving it
+const inactiveConfidenceFactor = 0.8
+
+func clampConfidence(c float64) float64 {
+	return math.Max(0, math.Min(1, c))
 }
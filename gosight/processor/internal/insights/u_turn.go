@@ -1,107 +1,103 @@
 package insights
 
 import (
-	"sync"
+	"math"
+	"sync/atomic"
 	"time"
 
 	"github.com/gosight/gosight/processor/internal/config"
 )
 
-// UTurnDetector detects when users navigate away and quickly return to a page
+// UTurnDetector detects when users navigate away and quickly return to a page,
+// directly (A -> B -> A) or after visiting up to lookback depth pages
+// (A -> B -> C -> A)
 type UTurnDetector struct {
-	maxTimeAwayMs int64
-	sessionPages  sync.Map // sessionID -> *PageHistory
-}
-
-// PageHistory tracks page navigation history per session
-type PageHistory struct {
-	Pages []PageVisit
-	mu    sync.Mutex
-}
-
-// PageVisit represents a page visit
-type PageVisit struct {
-	URL       string
-	Path      string
-	Timestamp int64
-	EventID   string
+	maxTimeAwayMs atomic.Int64
+	lookbackDepth atomic.Int64
 }
 
 // NewUTurnDetector creates a new U-turn detector
 func NewUTurnDetector(cfg config.UTurnConfig) *UTurnDetector {
-	return &UTurnDetector{
-		maxTimeAwayMs: cfg.MaxTimeAwayMs,
-	}
+	d := &UTurnDetector{}
+	d.SetThresholds(cfg)
+	return d
 }
 
-// ProcessPageView processes a page view event and detects U-turns
-func (d *UTurnDetector) ProcessPageView(event *Event) *Insight {
-	// Get or create session history
-	historyI, _ := d.sessionPages.LoadOrStore(event.SessionID, &PageHistory{
-		Pages: make([]PageVisit, 0, 20),
-	})
-	history := historyI.(*PageHistory)
-
-	history.mu.Lock()
-	defer history.mu.Unlock()
-
-	currentVisit := PageVisit{
-		URL:       event.URL,
-		Path:      event.Path,
-		Timestamp: event.Timestamp,
-		EventID:   event.EventID,
-	}
+// SetThresholds applies a new maximum time away and lookback depth to the
+// running detector
+func (d *UTurnDetector) SetThresholds(cfg config.UTurnConfig) {
+	d.maxTimeAwayMs.Store(cfg.MaxTimeAwayMs)
+	d.lookbackDepth.Store(int64(max(cfg.LookbackDepth, 1)))
+}
 
+// ProcessPageView detects U-turns given the session's page history, which ends
+// with the current page view. The most recent earlier visit of the current
+// page within the lookback depth is the one returned to.
+func (d *UTurnDetector) ProcessPageView(event *Event, pages []PageVisit) *Insight {
 	// Need at least 2 previous pages to detect a U-turn
-	// Pattern: A -> B -> A (within time window)
-	if len(history.Pages) >= 2 {
-		lastPage := history.Pages[len(history.Pages)-1]
-		secondLastPage := history.Pages[len(history.Pages)-2]
-
-		// Check if current page matches the second-to-last page (U-turn)
-		if currentVisit.Path == secondLastPage.Path {
-			// Check time away
-			timeAway := currentVisit.Timestamp - lastPage.Timestamp
-
-			if timeAway > 0 && timeAway <= d.maxTimeAwayMs {
-				// This is a U-turn!
-				history.Pages = append(history.Pages, currentVisit)
+	// Pattern: A -> B -> A, or A -> B -> ... -> A (within time window)
+	if len(pages) < 3 {
+		return nil
+	}
 
-				// Keep history bounded
-				if len(history.Pages) > 20 {
-					history.Pages = history.Pages[len(history.Pages)-20:]
-				}
+	current := len(pages) - 1
+	currentVisit := pages[current]
+	maxTimeAway := d.maxTimeAwayMs.Load()
+	oldest := max(current-1-int(d.lookbackDepth.Load()), 0)
 
-				return &Insight{
-					Type:      "u_turn",
-					ProjectID: event.ProjectID,
-					SessionID: event.SessionID,
-					Timestamp: time.Now(),
-					URL:       event.URL,
-					Path:      event.Path,
-					Details: map[string]interface{}{
-						"original_page":   secondLastPage.Path,
-						"navigated_to":    lastPage.Path,
-						"time_away_ms":    timeAway,
-						"returned_to":     currentVisit.Path,
-					},
-					RelatedEventIDs: []string{
-						secondLastPage.EventID,
-						lastPage.EventID,
-						currentVisit.EventID,
-					},
-				}
-			}
+	for original := current - 2; original >= oldest; original-- {
+		// Time away runs from leaving the original page
+		timeAway := currentVisit.Timestamp - pages[original+1].Timestamp
+		if timeAway > maxTimeAway {
+			return nil
+		}
+		if pages[original].Path != currentVisit.Path {
+			continue
 		}
+		if timeAway <= 0 {
+			return nil
+		}
+		return uTurnInsight(event, pages[original:], timeAway, maxTimeAway)
 	}
+	return nil
+}
 
-	// Add to history
-	history.Pages = append(history.Pages, currentVisit)
+// uTurnInsight reports the return along path, from the original page to the
+// current page view. Its confidence falls from 1 for an instant return to 0.5
+// at the maximum time away, and by a tenth for each further hop: the longer
+// the detour, the likelier the return was intended.
+func uTurnInsight(event *Event, path []PageVisit, timeAway, maxTimeAway int64) *Insight {
+	hops := len(path) - 2
+	returnType := "direct"
+	if hops > 1 {
+		returnType = "multi_hop"
+	}
 
-	// Keep history bounded
-	if len(history.Pages) > 20 {
-		history.Pages = history.Pages[len(history.Pages)-20:]
+	navigationPath := make([]string, len(path))
+	eventIDs := make([]string, len(path))
+	for i, visit := range path {
+		navigationPath[i] = visit.Path
+		eventIDs[i] = visit.EventID
 	}
 
-	return nil
+	// This is a U-turn!
+	return &Insight{
+		Type:      "u_turn",
+		ProjectID: event.ProjectID,
+		SessionID: event.SessionID,
+		Timestamp: time.Now(),
+		URL:       event.URL,
+		Path:      event.Path,
+		Details: map[string]interface{}{
+			"original_page":   path[0].Path,
+			"navigated_to":    path[1].Path,
+			"time_away_ms":    timeAway,
+			"returned_to":     path[len(path)-1].Path,
+			"hops":            hops,
+			"return_type":     returnType,
+			"navigation_path": navigationPath,
+		},
+		RelatedEventIDs: eventIDs,
+		Confidence:      underThresholdConfidence(float64(timeAway), float64(maxTimeAway)) * math.Pow(0.9, float64(hops-1)),
+	}
 }
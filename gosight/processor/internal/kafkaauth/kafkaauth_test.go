@@ -0,0 +1,97 @@
+package kafkaauth
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/segmentio/kafka-go"
+
+	"github.com/gosight/gosight/processor/internal/config"
+)
+
+// saslConfig is the kafka block of a processor config authenticating with
+// credentials from the environment, as deployments are advised to
+const saslConfig = `
+kafka:
+  brokers: [${KAFKA_SASL_BROKERS}]
+  sasl:
+    mechanism: ${KAFKA_SASL_MECHANISM}
+    username: ${KAFKA_SASL_USERNAME}
+    password: ${KAFKA_SASL_PASSWORD}
+  tls:
+    enabled: ${KAFKA_SASL_TLS}
+`
+
+// TestSASLBroker produces and consumes a message through a SASL-enabled
+// broker. It runs against the brokers in KAFKA_SASL_BROKERS (comma-separated)
+// with KAFKA_SASL_MECHANISM (default SCRAM-SHA-512), KAFKA_SASL_USERNAME and
+// KAFKA_SASL_PASSWORD, over TLS when KAFKA_SASL_TLS is true, and is skipped
+// otherwise.
+func TestSASLBroker(t *testing.T) {
+	if os.Getenv("KAFKA_SASL_BROKERS") == "" {
+		t.Skip("KAFKA_SASL_BROKERS not set")
+	}
+	if os.Getenv("KAFKA_SASL_MECHANISM") == "" {
+		t.Setenv("KAFKA_SASL_MECHANISM", config.SASLMechanismScramSHA512)
+	}
+	if os.Getenv("KAFKA_SASL_TLS") == "" {
+		t.Setenv("KAFKA_SASL_TLS", "false")
+	}
+
+	path := filepath.Join(t.TempDir(), "processor.yaml")
+	if err := os.WriteFile(path, []byte(saslConfig), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	full, err := config.Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg := full.Kafka
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	topic := fmt.Sprintf("gosight-sasl-test-%d", time.Now().UnixNano())
+	value := []byte(`{"type":"click"}`)
+
+	w := &kafka.Writer{
+		Addr:                   kafka.TCP(cfg.Brokers...),
+		Transport:              Transport(cfg),
+		Topic:                  topic,
+		AllowAutoTopicCreation: true,
+	}
+	defer w.Close()
+	// The topic is created by the first write
+	for attempt := 0; attempt < 10; attempt++ {
+		if err = w.WriteMessages(ctx, kafka.Message{Value: value}); err == nil {
+			break
+		}
+		time.Sleep(500 * time.Millisecond)
+	}
+	if err != nil {
+		t.Fatalf("write through SASL: %v", err)
+	}
+
+	r := kafka.NewReader(kafka.ReaderConfig{
+		Brokers: cfg.Brokers,
+		Topic:   topic,
+		Dialer:  Dialer(cfg),
+	})
+	defer r.Close()
+	msg, err := r.ReadMessage(ctx)
+	if err != nil {
+		t.Fatalf("read through SASL: %v", err)
+	}
+	if string(msg.Value) != string(value) {
+		t.Errorf("read %s, want %s", msg.Value, value)
+	}
+
+	// Wrong credentials are refused
+	cfg.SASL.Password += "-wrong"
+	if _, err := Dialer(cfg).DialContext(ctx, "tcp", cfg.Brokers[0]); err == nil {
+		t.Error("connected with a wrong password")
+	}
+}
@@ -0,0 +1,133 @@
+package kafkaauth
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/pbkdf2"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"hash"
+	"strconv"
+	"strings"
+
+	"github.com/segmentio/kafka-go/sasl"
+)
+
+// scram is the SCRAM-SHA-256/512 SASL mechanism (RFC 5802, RFC 7677), without
+// channel binding. Credentials are used as is, without SASLprep normalization,
+// which only matters for non-ASCII usernames or passwords.
+type scram struct {
+	name     string
+	hash     func() hash.Hash
+	username string
+	password string
+}
+
+func (m *scram) Name() string {
+	return m.name
+}
+
+// Start sends the client-first message
+func (m *scram) Start(ctx context.Context) (sasl.StateMachine, []byte, error) {
+	nonce := make([]byte, 24)
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, nil, err
+	}
+
+	s := &scramSession{mechanism: m, clientNonce: base64.RawStdEncoding.EncodeToString(nonce)}
+	s.clientFirstBare = "n=" + scramName(m.username) + ",r=" + s.clientNonce
+	return s, []byte("n,," + s.clientFirstBare), nil
+}
+
+// scramSession is a SCRAM exchange: the client-first message is sent by
+// Start, then Next answers the server-first message with the client proof
+// and verifies the server signature of the server-final message
+type scramSession struct {
+	mechanism       *scram
+	clientNonce     string
+	clientFirstBare string
+	serverSignature []byte // set once the proof is sent
+}
+
+func (s *scramSession) Next(ctx context.Context, challenge []byte) (bool, []byte, error) {
+	if s.serverSignature == nil {
+		response, err := s.clientFinal(string(challenge))
+		return false, response, err
+	}
+	return true, nil, s.verifyServerFinal(string(challenge))
+}
+
+// clientFinal answers the server-first message with the client proof
+func (s *scramSession) clientFinal(serverFirst string) ([]byte, error) {
+	attrs := scramAttributes(serverFirst)
+	nonce, salt64, iterations := attrs["r"], attrs["s"], attrs["i"]
+	if !strings.HasPrefix(nonce, s.clientNonce) || len(nonce) == len(s.clientNonce) {
+		return nil, errors.New("SCRAM: invalid server nonce")
+	}
+	salt, err := base64.StdEncoding.DecodeString(salt64)
+	if err != nil {
+		return nil, fmt.Errorf("SCRAM: invalid salt: %w", err)
+	}
+	iter, err := strconv.Atoi(iterations)
+	if err != nil || iter < 1 {
+		return nil, fmt.Errorf("SCRAM: invalid iteration count %q", iterations)
+	}
+
+	h := s.mechanism.hash
+	saltedPassword, err := pbkdf2.Key(h, s.mechanism.password, salt, iter, h().Size())
+	if err != nil {
+		return nil, fmt.Errorf("SCRAM: %w", err)
+	}
+	clientKey := scramHMAC(h, saltedPassword, "Client Key")
+	storedKey := h()
+	storedKey.Write(clientKey)
+
+	// "biws" is the base64 GS2 header "n,,": no channel binding
+	withoutProof := "c=biws,r=" + nonce
+	authMessage := s.clientFirstBare + "," + serverFirst + "," + withoutProof
+
+	proof := scramHMAC(h, storedKey.Sum(nil), authMessage)
+	for i := range proof {
+		proof[i] ^= clientKey[i]
+	}
+	s.serverSignature = scramHMAC(h, scramHMAC(h, saltedPassword, "Server Key"), authMessage)
+
+	return []byte(withoutProof + ",p=" + base64.StdEncoding.EncodeToString(proof)), nil
+}
+
+// verifyServerFinal checks the server signature, authenticating the broker
+func (s *scramSession) verifyServerFinal(serverFinal string) error {
+	attrs := scramAttributes(serverFinal)
+	if e, ok := attrs["e"]; ok {
+		return fmt.Errorf("SCRAM: server error: %s", e)
+	}
+	signature, err := base64.StdEncoding.DecodeString(attrs["v"])
+	if err != nil || !hmac.Equal(signature, s.serverSignature) {
+		return errors.New("SCRAM: invalid server signature")
+	}
+	return nil
+}
+
+func scramHMAC(h func() hash.Hash, key []byte, message string) []byte {
+	mac := hmac.New(h, key)
+	mac.Write([]byte(message))
+	return mac.Sum(nil)
+}
+
+// scramAttributes parses the comma-separated "k=value" attributes of a SCRAM message
+func scramAttributes(message string) map[string]string {
+	attrs := make(map[string]string)
+	for _, attr := range strings.Split(message, ",") {
+		if key, value, ok := strings.Cut(attr, "="); ok {
+			attrs[key] = value
+		}
+	}
+	return attrs
+}
+
+// scramName escapes a username for the n= attribute
+func scramName(username string) string {
+	return strings.NewReplacer("=", "=3D", ",", "=2C").Replace(username)
+}
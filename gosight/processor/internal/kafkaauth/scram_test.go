@@ -0,0 +1,71 @@
+package kafkaauth
+
+import (
+	"context"
+	"crypto/sha256"
+	"strings"
+	"testing"
+)
+
+// RFC 7677 section 3 test vector
+const (
+	rfcClientNonce = "rOprNGfwEbeRWgbNEkqO"
+	rfcServerFirst = "r=rOprNGfwEbeRWgbNEkqO%hvYDpWUa2RaTCAfuxFIlj)hNlF$k0,s=W22ZaJ0SNY7soEsUEjb6gQ==,i=4096"
+	rfcClientFinal = "c=biws,r=rOprNGfwEbeRWgbNEkqO%hvYDpWUa2RaTCAfuxFIlj)hNlF$k0,p=dHzbZapWIk4jUhN+Ute9ytag9zjfMHgsqmmiz7AndVQ="
+	rfcServerFinal = "v=6rriTRBi23WpRR/wtup+mMhUZUn/dB5nLTJRsjl95G4="
+)
+
+func rfcSession() *scramSession {
+	m := &scram{name: "SCRAM-SHA-256", hash: sha256.New, username: "user", password: "pencil"}
+	return &scramSession{mechanism: m, clientNonce: rfcClientNonce, clientFirstBare: "n=user,r=" + rfcClientNonce}
+}
+
+func TestScramSHA256(t *testing.T) {
+	ctx := context.Background()
+	s := rfcSession()
+
+	done, response, err := s.Next(ctx, []byte(rfcServerFirst))
+	if err != nil || done {
+		t.Fatalf("Next(server-first) = %v, %v", done, err)
+	}
+	if string(response) != rfcClientFinal {
+		t.Errorf("client-final = %s, want %s", response, rfcClientFinal)
+	}
+
+	if done, _, err := s.Next(ctx, []byte(rfcServerFinal)); err != nil || !done {
+		t.Errorf("Next(server-final) = %v, %v, want done", done, err)
+	}
+}
+
+func TestScramRejectsBadServers(t *testing.T) {
+	ctx := context.Background()
+
+	// A server nonce not extending the client's
+	if _, _, err := rfcSession().Next(ctx, []byte("r=otherNonce,s=W22ZaJ0SNY7soEsUEjb6gQ==,i=4096")); err == nil {
+		t.Error("foreign server nonce accepted")
+	}
+
+	for _, serverFinal := range []string{
+		"v=AAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAA=", // a server not knowing the password
+		"e=invalid-proof",
+	} {
+		s := rfcSession()
+		if _, _, err := s.Next(ctx, []byte(rfcServerFirst)); err != nil {
+			t.Fatal(err)
+		}
+		if _, _, err := s.Next(ctx, []byte(serverFinal)); err == nil {
+			t.Errorf("server-final %s accepted", serverFinal)
+		}
+	}
+}
+
+func TestStartSendsClientFirst(t *testing.T) {
+	m := &scram{name: "SCRAM-SHA-256", hash: sha256.New, username: "a=b,c"}
+	_, first, err := m.Start(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(string(first), "n,,n=a=3Db=2Cc,r=") {
+		t.Errorf("client-first = %s", first)
+	}
+}
@@ -0,0 +1,189 @@
+package live
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+
+	"github.com/gosight/gosight/processor/internal/config"
+)
+
+// keyPrefix prefixes the sorted sets of live counts
+const keyPrefix = "live:top:"
+
+// Key is the sorted set of a project's counts of a dimension in the bucket
+// starting at bucketStart: members are page paths or error fingerprints,
+// scores their counts. Dashboards read it with ZREVRANGE key 0 N-1 WITHSCORES.
+func Key(dimension, projectID string, bucketStart time.Time) string {
+	return keyPrefix + dimension + ":" + projectID + ":" + strconv.FormatInt(bucketStart.Unix(), 10)
+}
+
+// Count is a member of a dimension and its count
+type Count struct {
+	Member string
+	Count  int64
+}
+
+type counterKey struct {
+	dimension string
+	projectID string
+	bucket    int64 // unix seconds
+}
+
+// TopCounters keeps rolling per-bucket counts of page paths
+// (config.LiveDimensionPages) and error fingerprints
+// (config.LiveDimensionErrors) in Redis sorted sets for live dashboards.
+// Counts are buffered in memory and written on Flush; buckets expire after the
+// configured retention and keep only the max_members top members.
+type TopCounters struct {
+	redis      *redis.Client
+	dimensions map[string]bool
+	bucket     time.Duration
+	retention  time.Duration
+	maxMembers int64
+	n          int
+
+	pending map[counterKey]map[string]int64
+	mu      sync.Mutex
+}
+
+// NewTopCounters creates live top counters writing to Redis
+func NewTopCounters(redisCfg config.RedisConfig, cfg config.LiveTopConfig) *TopCounters {
+	t := &TopCounters{
+		redis: redis.NewClient(&redis.Options{
+			Addr:     redisCfg.Addr,
+			Password: redisCfg.Password,
+			DB:       redisCfg.DB,
+		}),
+		dimensions: make(map[string]bool, len(cfg.Dimensions)),
+		bucket:     cfg.Bucket,
+		retention:  cfg.Retention,
+		maxMembers: int64(cfg.MaxMembers),
+		n:          cfg.N,
+		pending:    make(map[counterKey]map[string]int64),
+	}
+	for _, d := range cfg.Dimensions {
+		t.dimensions[d] = true
+	}
+	return t
+}
+
+// Add counts n occurrences of member in the bucket of ts
+func (t *TopCounters) Add(dimension, projectID, member string, ts time.Time, n int64) {
+	if !t.dimensions[dimension] || member == "" || n <= 0 {
+		return
+	}
+	key := counterKey{dimension, projectID, ts.Truncate(t.bucket).Unix()}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	counts := t.pending[key]
+	if counts == nil {
+		counts = make(map[string]int64)
+		t.pending[key] = counts
+	}
+	counts[member] += n
+}
+
+// Flush writes the buffered counts in one Redis pipeline. Buckets already
+// expired are dropped rather than recreated.
+func (t *TopCounters) Flush(ctx context.Context) error {
+	t.mu.Lock()
+	pending := t.pending
+	t.pending = make(map[counterKey]map[string]int64)
+	t.mu.Unlock()
+
+	if len(pending) == 0 {
+		return nil
+	}
+
+	now := time.Now()
+	pipe := t.redis.Pipeline()
+	for k, counts := range pending {
+		bucketStart := time.Unix(k.bucket, 0)
+		expireAt := bucketStart.Add(t.bucket + t.retention)
+		if !expireAt.After(now) {
+			continue
+		}
+
+		key := Key(k.dimension, k.projectID, bucketStart)
+		for member, n := range counts {
+			pipe.ZIncrBy(ctx, key, float64(n), member)
+		}
+		// Keep the top max_members; the long tail can't make the top N
+		pipe.ZRemRangeByRank(ctx, key, 0, -t.maxMembers-1)
+		pipe.ExpireAt(ctx, key, expireAt)
+	}
+
+	if _, err := pipe.Exec(ctx); err != nil {
+		return fmt.Errorf("write live top counts: %w", err)
+	}
+	return nil
+}
+
+// TopInBucket returns a project's top n members of a dimension in the bucket
+// containing ts; n <= 0 uses live_top.n
+func (t *TopCounters) TopInBucket(ctx context.Context, dimension, projectID string, ts time.Time, n int) ([]Count, error) {
+	if n <= 0 {
+		n = t.n
+	}
+	zs, err := t.redis.ZRevRangeWithScores(ctx, Key(dimension, projectID, ts.Truncate(t.bucket)), 0, int64(n-1)).Result()
+	if err != nil {
+		return nil, err
+	}
+	return toCounts(zs), nil
+}
+
+// Top returns a project's top n members of a dimension over the buckets of the
+// last window (at most the retention), merging their counts; n <= 0 uses
+// live_top.n
+func (t *TopCounters) Top(ctx context.Context, dimension, projectID string, window time.Duration, n int) ([]Count, error) {
+	if n <= 0 {
+		n = t.n
+	}
+	window = min(window, t.retention)
+
+	now := time.Now()
+	var keys []string
+	for b := now.Add(-window).Truncate(t.bucket); !b.After(now); b = b.Add(t.bucket) {
+		keys = append(keys, Key(dimension, projectID, b))
+	}
+
+	zs, err := t.redis.ZUnionWithScores(ctx, redis.ZStore{Keys: keys}).Result()
+	if err != nil {
+		return nil, err
+	}
+	slices.SortFunc(zs, func(a, b redis.Z) int {
+		switch {
+		case a.Score > b.Score:
+			return -1
+		case a.Score < b.Score:
+			return 1
+		}
+		return 0
+	})
+	if len(zs) > n {
+		zs = zs[:n]
+	}
+	return toCounts(zs), nil
+}
+
+// Close closes the Redis client
+func (t *TopCounters) Close() error {
+	return t.redis.Close()
+}
+
+func toCounts(zs []redis.Z) []Count {
+	counts := make([]Count, len(zs))
+	for i, z := range zs {
+		member, _ := z.Member.(string)
+		counts[i] = Count{Member: member, Count: int64(z.Score)}
+	}
+	return counts
+}
@@ -0,0 +1,120 @@
+package processor
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gosight/gosight/processor/internal/config"
+	"github.com/gosight/gosight/processor/internal/storage"
+)
+
+// seriesRetention is how long after a bucket ends its label sets are tracked;
+// later pushes to the bucket count against a fresh limit
+const seriesRetention = time.Hour
+
+// AggregateLimiter keeps pre-aggregated metrics bounded: Validate rejects
+// misaligned buckets and too many dimensions, Allow drops label sets beyond
+// max_series_per_metric per project, metric and bucket
+type AggregateLimiter struct {
+	bucketSizes   []uint32 // seconds
+	maxDimensions int
+	maxSeries     int
+
+	series map[string]*bucketSeries // project:metric:bucket -> label sets seen
+	mu     sync.Mutex
+}
+
+type bucketSeries struct {
+	end    time.Time
+	labels map[string]struct{}
+}
+
+// NewAggregateLimiter creates an aggregate limiter
+func NewAggregateLimiter(cfg config.AggregateMetricsConfig) *AggregateLimiter {
+	sizes := make([]uint32, len(cfg.BucketSizes))
+	for i, size := range cfg.BucketSizes {
+		sizes[i] = uint32(size / time.Second)
+	}
+	return &AggregateLimiter{
+		bucketSizes:   sizes,
+		maxDimensions: cfg.MaxDimensions,
+		maxSeries:     cfg.MaxSeriesPerMetric,
+		series:        make(map[string]*bucketSeries),
+	}
+}
+
+// Validate checks the bucket is an allowed size and aligned to it, and the
+// number of dimensions
+func (l *AggregateLimiter) Validate(row storage.AggregateMetricRow) error {
+	if !slices.Contains(l.bucketSizes, row.BucketSeconds) {
+		return fmt.Errorf("aggregate metric %q: bucket_seconds %d is not one of %v", row.MetricName, row.BucketSeconds, l.bucketSizes)
+	}
+	if row.BucketStart.UnixMilli()%(int64(row.BucketSeconds)*1000) != 0 {
+		return fmt.Errorf("aggregate metric %q: bucket_start %s is not aligned to %ds", row.MetricName, row.BucketStart.Format(time.RFC3339Nano), row.BucketSeconds)
+	}
+	if len(row.Dimensions) > l.maxDimensions {
+		return fmt.Errorf("aggregate metric %q: %d dimensions, max is %d", row.MetricName, len(row.Dimensions), l.maxDimensions)
+	}
+	return nil
+}
+
+// Allow reports whether the row's label set fits in its bucket's series limit.
+// Label sets already seen in the bucket are always allowed.
+func (l *AggregateLimiter) Allow(row storage.AggregateMetricRow) bool {
+	key := row.ProjectID + ":" + row.MetricName + ":" + row.BucketStart.Format(time.RFC3339)
+	labels := labelSetKey(row.Dimensions)
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	s, ok := l.series[key]
+	if !ok {
+		s = &bucketSeries{
+			end:    row.BucketStart.Add(time.Duration(row.BucketSeconds) * time.Second),
+			labels: make(map[string]struct{}),
+		}
+		l.series[key] = s
+	}
+	if _, seen := s.labels[labels]; seen {
+		return true
+	}
+	if len(s.labels) >= l.maxSeries {
+		return false
+	}
+	s.labels[labels] = struct{}{}
+	return true
+}
+
+// Expire stops tracking the label sets of buckets that ended over
+// seriesRetention ago
+func (l *AggregateLimiter) Expire(now time.Time) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	for key, s := range l.series {
+		if now.Sub(s.end) >= seriesRetention {
+			delete(l.series, key)
+		}
+	}
+}
+
+// labelSetKey identifies a set of dimensions regardless of map order
+func labelSetKey(dimensions map[string]string) string {
+	keys := make([]string, 0, len(dimensions))
+	for k := range dimensions {
+		keys = append(keys, k)
+	}
+	slices.Sort(keys)
+
+	var b strings.Builder
+	for _, k := range keys {
+		b.WriteString(k)
+		b.WriteByte(0)
+		b.WriteString(dimensions[k])
+		b.WriteByte(0)
+	}
+	return b.String()
+}
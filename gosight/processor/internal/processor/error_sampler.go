@@ -0,0 +1,124 @@
+package processor
+
+import (
+	"math"
+	"sync"
+	"time"
+
+	"github.com/gosight/gosight/processor/internal/config"
+	"github.com/gosight/gosight/processor/internal/storage"
+)
+
+// ErrorSampler reduces identical error rows (same fingerprint) before they are
+// stored, per error_sampling.strategy:
+//   - group: one representative row per fingerprint and window, carrying the
+//     number of occurrences in OccurrenceCount, written when the window closes
+//   - sample: the first keep_first occurrences per fingerprint and window are
+//     stored as they are, then one in 1/sample_rate, counting for the skipped ones
+//
+// Occurrences are sum(occurrence_count) either way.
+type ErrorSampler struct {
+	strategy  string
+	window    time.Duration
+	keepFirst int
+	every     int // sample: keep one in every occurrences past keep_first
+
+	windows map[string]*errorWindow // fingerprint -> current window
+	mu      sync.Mutex
+}
+
+type errorWindow struct {
+	start time.Time
+	count int
+	row   storage.ErrorRow // group: representative row
+}
+
+// NewErrorSampler creates an error sampler; nil when the strategy is none
+func NewErrorSampler(cfg config.ErrorSamplingConfig) *ErrorSampler {
+	if cfg.Strategy == config.ErrorSamplingNone {
+		return nil
+	}
+	return &ErrorSampler{
+		strategy:  cfg.Strategy,
+		window:    cfg.Window,
+		keepFirst: cfg.KeepFirst,
+		every:     int(math.Round(1 / cfg.SampleRate)),
+		windows:   make(map[string]*errorWindow),
+	}
+}
+
+// Add records an error and returns the rows to store now, if any
+func (s *ErrorSampler) Add(row storage.ErrorRow, now time.Time) []storage.ErrorRow {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	w, ok := s.windows[row.Fingerprint]
+	if ok && now.Sub(w.start) >= s.window {
+		// The previous window is reported by Expire; start a new one
+		ok = false
+	}
+	var expired []storage.ErrorRow
+	if !ok {
+		if w != nil {
+			expired = s.close(w)
+		}
+		w = &errorWindow{start: now, row: row}
+		s.windows[row.Fingerprint] = w
+	}
+	w.count++
+
+	if s.strategy == config.ErrorSamplingGroup {
+		return expired
+	}
+
+	// sample
+	if w.count <= s.keepFirst {
+		return append(expired, row)
+	}
+	if (w.count-s.keepFirst)%s.every == 0 {
+		row.OccurrenceCount = uint32(s.every)
+		return append(expired, row)
+	}
+	return expired
+}
+
+// Expire closes windows older than the window duration and returns the rows
+// they leave to store
+func (s *ErrorSampler) Expire(now time.Time) []storage.ErrorRow {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var rows []storage.ErrorRow
+	for fingerprint, w := range s.windows {
+		if now.Sub(w.start) < s.window {
+			continue
+		}
+		delete(s.windows, fingerprint)
+		rows = append(rows, s.close(w)...)
+	}
+	return rows
+}
+
+// FlushAll closes all windows (on shutdown)
+func (s *ErrorSampler) FlushAll() []storage.ErrorRow {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var rows []storage.ErrorRow
+	for fingerprint, w := range s.windows {
+		delete(s.windows, fingerprint)
+		rows = append(rows, s.close(w)...)
+	}
+	return rows
+}
+
+// close returns the rows a finished window leaves to store: the group's
+// representative row. Sampled rows were already returned by Add.
+func (s *ErrorSampler) close(w *errorWindow) []storage.ErrorRow {
+	if s.strategy != config.ErrorSamplingGroup {
+		return nil
+	}
+	row := w.row
+	row.OccurrenceCount = uint32(w.count)
+	return []storage.ErrorRow{row}
+}
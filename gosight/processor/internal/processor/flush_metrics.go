@@ -0,0 +1,92 @@
+package processor
+
+import (
+	"fmt"
+	"io"
+	"sync/atomic"
+	"time"
+
+	"github.com/gosight/gosight/processor/internal/transformer"
+)
+
+// Tables flushed by the event processor
+var flushTables = [...]string{"events", "page_views", "web_vitals", "errors", "long_tasks", "aggregate_metrics"}
+
+// flushBuckets are the upper bounds, in seconds, of the flush duration histogram
+var flushBuckets = [...]float64{0.01, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+// flushMetrics records insert durations and slow inserts per table
+type flushMetrics struct {
+	tables [len(flushTables)]tableFlushMetrics // indexed like flushTables
+}
+
+type tableFlushMetrics struct {
+	buckets [len(flushBuckets)]atomic.Uint64 // non-cumulative counts
+	count   atomic.Uint64
+	sumNs   atomic.Uint64
+	slow    atomic.Uint64
+}
+
+func (m *flushMetrics) table(name string) *tableFlushMetrics {
+	for i, t := range flushTables {
+		if t == name {
+			return &m.tables[i]
+		}
+	}
+	return nil
+}
+
+// observe records an insert into table and reports whether it was slow
+func (m *flushMetrics) observe(table string, took, slowThreshold time.Duration) bool {
+	t := m.table(table)
+	if t == nil {
+		return false
+	}
+
+	t.count.Add(1)
+	t.sumNs.Add(uint64(took))
+	for i, le := range flushBuckets {
+		if took.Seconds() <= le {
+			t.buckets[i].Add(1)
+			break
+		}
+	}
+
+	if slowThreshold > 0 && took >= slowThreshold {
+		t.slow.Add(1)
+		return true
+	}
+	return false
+}
+
+// WriteMetrics writes the processor's metrics in the Prometheus text format.
+// Rising flush durations or slow flushes are an early sign of ClickHouse
+// degradation, before it shows as consumer lag.
+func (p *EventProcessor) WriteMetrics(w io.Writer) {
+	m := &p.flushMetrics
+
+	fmt.Fprintln(w, "# HELP gosight_processor_flush_duration_seconds Duration of ClickHouse inserts by table.")
+	fmt.Fprintln(w, "# TYPE gosight_processor_flush_duration_seconds histogram")
+	for i, table := range flushTables {
+		t := &m.tables[i]
+		var cumulative uint64
+		for j, le := range flushBuckets {
+			cumulative += t.buckets[j].Load()
+			fmt.Fprintf(w, "gosight_processor_flush_duration_seconds_bucket{table=%q,le=\"%g\"} %d\n", table, le, cumulative)
+		}
+		count := max(t.count.Load(), cumulative)
+		fmt.Fprintf(w, "gosight_processor_flush_duration_seconds_bucket{table=%q,le=\"+Inf\"} %d\n", table, count)
+		fmt.Fprintf(w, "gosight_processor_flush_duration_seconds_sum{table=%q} %g\n", table, time.Duration(t.sumNs.Load()).Seconds())
+		fmt.Fprintf(w, "gosight_processor_flush_duration_seconds_count{table=%q} %d\n", table, count)
+	}
+
+	fmt.Fprintln(w, "# HELP gosight_processor_slow_flushes_total ClickHouse inserts slower than batch.slow_flush_threshold.")
+	fmt.Fprintln(w, "# TYPE gosight_processor_slow_flushes_total counter")
+	for i, table := range flushTables {
+		fmt.Fprintf(w, "gosight_processor_slow_flushes_total{table=%q} %d\n", table, m.tables[i].slow.Load())
+	}
+
+	fmt.Fprintln(w, "# HELP gosight_processor_out_of_range_values_total Payload numbers (dimensions, line numbers, ...) stored as 0 for being negative or too large.")
+	fmt.Fprintln(w, "# TYPE gosight_processor_out_of_range_values_total counter")
+	fmt.Fprintf(w, "gosight_processor_out_of_range_values_total %d\n", transformer.OutOfRangeValues())
+}
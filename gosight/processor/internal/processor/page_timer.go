@@ -0,0 +1,209 @@
+package processor
+
+import (
+	"sync"
+	"time"
+
+	"github.com/gosight/gosight/processor/internal/storage"
+)
+
+// pageIdleTimeout finalizes a page view when its session has gone quiet
+const pageIdleTimeout = 30 * time.Minute
+
+// PageTimer holds the current page view of each session until the next page
+// view (or exit) so time on page can be computed, minus time the tab was hidden.
+// The last page view of a session without an exit ends at the session's last
+// event, which is the session's ended_at.
+type PageTimer struct {
+	pages map[string]*pageTiming // sessionID -> current page
+	exits map[string]*pageExit   // sessionID -> exit, for page views arriving after it
+	mu    sync.Mutex
+}
+
+// pageExit is the page exit ending a session's last page view
+type pageExit struct {
+	start       int64 // event timestamp of the last page view
+	at          int64 // event timestamp of the exit
+	lastTouched time.Time
+}
+
+type pageTiming struct {
+	view        storage.PageViewRow
+	hiddenSince int64 // event timestamp the tab was hidden at, 0 while visible
+	hiddenMs    int64
+	lastSeen    int64 // last event timestamp in the session
+	lastTouched time.Time
+}
+
+// NewPageTimer creates a new page timer
+func NewPageTimer() *PageTimer {
+	return &PageTimer{
+		pages: make(map[string]*pageTiming),
+		exits: make(map[string]*pageExit),
+	}
+}
+
+// StartPage tracks a new page view and returns the previous page view of the
+// session, completed with its timing, if there was one. A page view older than
+// the tracked one arrived out of order: it ended when the tracked one started,
+// so it is returned completed and the tracked one is kept. Likewise a page view
+// older than the session's page exit ended at the exit, or when the page view
+// the exit ended started.
+func (t *PageTimer) StartPage(view storage.PageViewRow) *storage.PageViewRow {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	ts := view.Timestamp.UnixMilli()
+
+	var prev *storage.PageViewRow
+	if pt, ok := t.pages[view.SessionID]; ok {
+		if start := pt.view.Timestamp.UnixMilli(); ts < start {
+			late := &pageTiming{view: view}
+			return late.finish(start)
+		}
+		prev = pt.finish(ts)
+	} else if exit, ok := t.exits[view.SessionID]; ok {
+		if ts < exit.at {
+			late := &pageTiming{view: view}
+			if ts < exit.start {
+				return late.finish(exit.start)
+			}
+			return late.finish(exit.at)
+		}
+		// The session came back after leaving
+		delete(t.exits, view.SessionID)
+	}
+
+	t.pages[view.SessionID] = &pageTiming{
+		view:        view,
+		lastSeen:    ts,
+		lastTouched: time.Now(),
+	}
+
+	return prev
+}
+
+// EndPage completes the current page view of a session (page exit)
+func (t *PageTimer) EndPage(sessionID string, ts int64) *storage.PageViewRow {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	pt, ok := t.pages[sessionID]
+	if !ok {
+		return nil
+	}
+	delete(t.pages, sessionID)
+	t.exits[sessionID] = &pageExit{start: pt.view.Timestamp.UnixMilli(), at: ts, lastTouched: time.Now()}
+
+	return pt.finish(ts)
+}
+
+// Hidden records the tab being backgrounded
+func (t *PageTimer) Hidden(sessionID string, ts int64) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	pt, ok := t.pages[sessionID]
+	if !ok || pt.hiddenSince != 0 {
+		return
+	}
+	pt.hiddenSince = ts
+	pt.touch(ts)
+}
+
+// Visible records the tab coming back to the foreground
+func (t *PageTimer) Visible(sessionID string, ts int64) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	pt, ok := t.pages[sessionID]
+	if !ok || pt.hiddenSince == 0 {
+		return
+	}
+	if ts > pt.hiddenSince {
+		pt.hiddenMs += ts - pt.hiddenSince
+	}
+	pt.hiddenSince = 0
+	pt.touch(ts)
+}
+
+// Touch records activity in a session
+func (t *PageTimer) Touch(sessionID string, ts int64) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if pt, ok := t.pages[sessionID]; ok {
+		pt.touch(ts)
+	}
+}
+
+// FlushIdle completes page views of sessions with no activity for pageIdleTimeout,
+// ending them at the session's last event, and forgets exits as old
+func (t *PageTimer) FlushIdle(now time.Time) []storage.PageViewRow {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	for sessionID, exit := range t.exits {
+		if now.Sub(exit.lastTouched) >= pageIdleTimeout {
+			delete(t.exits, sessionID)
+		}
+	}
+
+	var views []storage.PageViewRow
+	for sessionID, pt := range t.pages {
+		if now.Sub(pt.lastTouched) < pageIdleTimeout {
+			continue
+		}
+		views = append(views, *pt.finish(pt.lastSeen))
+		delete(t.pages, sessionID)
+	}
+	return views
+}
+
+// FlushAll completes every tracked page view
+func (t *PageTimer) FlushAll() []storage.PageViewRow {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	views := make([]storage.PageViewRow, 0, len(t.pages))
+	for _, pt := range t.pages {
+		views = append(views, *pt.finish(pt.lastSeen))
+	}
+	t.pages = make(map[string]*pageTiming)
+	t.exits = make(map[string]*pageExit)
+	return views
+}
+
+func (pt *pageTiming) touch(ts int64) {
+	if ts > pt.lastSeen {
+		pt.lastSeen = ts
+	}
+	pt.lastTouched = time.Now()
+}
+
+// finish fills in raw and active time on page for a page ending at end.
+// A page closed while hidden has no final "visible", so the hidden interval
+// runs until end.
+func (pt *pageTiming) finish(end int64) *storage.PageViewRow {
+	view := pt.view
+
+	raw := end - view.Timestamp.UnixMilli()
+	if raw < 0 {
+		raw = 0
+	}
+
+	hidden := pt.hiddenMs
+	if pt.hiddenSince != 0 && end > pt.hiddenSince {
+		hidden += end - pt.hiddenSince
+	}
+
+	active := raw - hidden
+	if active < 0 {
+		active = 0
+	}
+
+	view.RawTimeOnPageMs = uint64(raw)
+	view.TimeOnPageMs = uint64(active)
+
+	return &view
+}
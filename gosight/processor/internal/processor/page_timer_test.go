@@ -0,0 +1,121 @@
+package processor
+
+import (
+	"testing"
+	"time"
+
+	"github.com/gosight/gosight/processor/internal/storage"
+)
+
+// pageEvent is an event of one session sent to the page timer: a page view of
+// path, or a page exit, hidden, visible or other event
+type pageEvent struct {
+	kind string
+	path string
+	at   int64
+}
+
+func TestPageTimerReorderedPageViews(t *testing.T) {
+	type timing struct{ raw, active uint64 }
+	tests := []struct {
+		name   string
+		events []pageEvent
+		want   map[string]timing // path -> time on page
+	}{
+		{
+			name:   "in order",
+			events: []pageEvent{{"view", "/a", 0}, {"view", "/b", 10_000}, {"exit", "", 25_000}},
+			want:   map[string]timing{"/a": {10_000, 10_000}, "/b": {15_000, 15_000}},
+		},
+		{
+			name:   "late view before the current one",
+			events: []pageEvent{{"view", "/b", 10_000}, {"view", "/a", 0}, {"exit", "", 25_000}},
+			want:   map[string]timing{"/a": {10_000, 10_000}, "/b": {15_000, 15_000}},
+		},
+		{
+			name:   "equal timestamps",
+			events: []pageEvent{{"view", "/a", 1000}, {"view", "/b", 1000}, {"touch", "", 5000}},
+			want:   map[string]timing{"/a": {0, 0}, "/b": {4000, 4000}},
+		},
+		{
+			name:   "late view after the exit",
+			events: []pageEvent{{"view", "/b", 10_000}, {"exit", "", 25_000}, {"view", "/a", 0}},
+			want:   map[string]timing{"/a": {10_000, 10_000}, "/b": {15_000, 15_000}},
+		},
+		{
+			name:   "late view between the last view and the exit",
+			events: []pageEvent{{"view", "/a", 0}, {"exit", "", 25_000}, {"view", "/b", 10_000}},
+			want:   map[string]timing{"/a": {25_000, 25_000}, "/b": {15_000, 15_000}},
+		},
+		{
+			name:   "session back after the exit",
+			events: []pageEvent{{"view", "/a", 0}, {"exit", "", 5000}, {"view", "/b", 60_000}, {"touch", "", 70_000}},
+			want:   map[string]timing{"/a": {5000, 5000}, "/b": {10_000, 10_000}},
+		},
+		{
+			// The last page ends at the session's last event, its ended_at,
+			// however late the events arrive
+			name: "final page",
+			events: []pageEvent{
+				{"view", "/a", 0}, {"hidden", "", 2000}, {"visible", "", 5000},
+				{"touch", "", 9000}, {"touch", "", 7000},
+			},
+			want: map[string]timing{"/a": {9000, 6000}},
+		},
+	}
+
+	for _, tt := range tests {
+		pt := NewPageTimer()
+		got := make(map[string]timing)
+		finished := func(views ...storage.PageViewRow) {
+			for _, view := range views {
+				if _, ok := got[view.PagePath]; ok {
+					t.Errorf("%s: %s finished twice", tt.name, view.PagePath)
+				}
+				got[view.PagePath] = timing{view.RawTimeOnPageMs, view.TimeOnPageMs}
+			}
+		}
+
+		for _, e := range tt.events {
+			var view *storage.PageViewRow
+			switch e.kind {
+			case "view":
+				view = pt.StartPage(storage.PageViewRow{SessionID: "sess", PagePath: e.path, Timestamp: time.UnixMilli(e.at)})
+			case "exit":
+				view = pt.EndPage("sess", e.at)
+			case "hidden":
+				pt.Hidden("sess", e.at)
+			case "visible":
+				pt.Visible("sess", e.at)
+			default:
+				pt.Touch("sess", e.at)
+			}
+			if view != nil {
+				finished(*view)
+			}
+		}
+		// The session ends
+		finished(pt.FlushIdle(time.Now().Add(pageIdleTimeout))...)
+
+		if len(got) != len(tt.want) {
+			t.Errorf("%s: finished %v, want %v", tt.name, got, tt.want)
+			continue
+		}
+		for path, want := range tt.want {
+			if got[path] != want {
+				t.Errorf("%s: %s time on page = %+v, want %+v", tt.name, path, got[path], want)
+			}
+		}
+	}
+}
+
+func TestPageTimerForgetsExits(t *testing.T) {
+	pt := NewPageTimer()
+	pt.StartPage(storage.PageViewRow{SessionID: "sess", PagePath: "/a", Timestamp: time.UnixMilli(0)})
+	pt.EndPage("sess", 5000)
+
+	pt.FlushIdle(time.Now().Add(pageIdleTimeout))
+	if len(pt.exits) != 0 {
+		t.Errorf("%d exits kept past the idle timeout", len(pt.exits))
+	}
+}
@@ -3,12 +3,17 @@ package processor
 import (
 	"context"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/rs/zerolog/log"
 
 	"github.com/gosight/gosight/processor/internal/config"
+	"github.com/gosight/gosight/processor/internal/eventtype"
+	"github.com/gosight/gosight/processor/internal/live"
+	"github.com/gosight/gosight/processor/internal/selector"
 	"github.com/gosight/gosight/processor/internal/session"
+	"github.com/gosight/gosight/processor/internal/settings"
 	"github.com/gosight/gosight/processor/internal/storage"
 	"github.com/gosight/gosight/processor/internal/transformer"
 )
@@ -16,14 +21,36 @@ import (
 // EventProcessor processes events from Kafka and writes them to ClickHouse
 type EventProcessor struct {
 	ch         *storage.ClickHouse
-	sessionAgg *session.Aggregator
+	sessions   *session.UpdatePool // nil without a session aggregator
 	batchCfg   config.BatchConfig
+	pageTimer  *PageTimer
+	normalizer *selector.Normalizer
+	storageCfg config.StorageConfig
+	errors     *ErrorSampler // nil when error sampling is off
+	aggregates *AggregateLimiter
+
+	// Event types kept without analytics consent
+	essentialTypes map[eventtype.Type]bool
+	consentDropped atomic.Uint64 // events dropped since the last flush
+
+	storeRawUserAgent bool
+
+	flushMetrics flushMetrics
+
+	// Live top pages and errors; nil when disabled
+	live *live.TopCounters
+
+	// Per-project path exclusions; nil when project settings are disabled
+	settings *settings.Loader
+	excluded atomic.Uint64 // events dropped since the last flush
 
 	// Event buffers
 	eventBuffer     []storage.EventRow
 	pageViewBuffer  []storage.PageViewRow
 	webVitalsBuffer []storage.WebVitalsRow
 	errorBuffer     []storage.ErrorRow
+	longTaskBuffer  []storage.LongTaskRow
+	aggregateBuffer []storage.AggregateMetricRow
 
 	mu        sync.Mutex
 	lastFlush time.Time
@@ -32,17 +59,31 @@ type EventProcessor struct {
 }
 
 // NewEventProcessor creates a new event processor
-func NewEventProcessor(ch *storage.ClickHouse, sessionAgg *session.Aggregator, batchCfg config.BatchConfig) *EventProcessor {
+func NewEventProcessor(ch *storage.ClickHouse, sessions *session.UpdatePool, batchCfg config.BatchConfig, storageCfg config.StorageConfig, normalizer *selector.Normalizer, errorCfg config.ErrorSamplingConfig, aggregateCfg config.AggregateMetricsConfig, consentCfg config.ConsentConfig, enrichmentCfg config.EnrichmentConfig) *EventProcessor {
 	p := &EventProcessor{
 		ch:              ch,
-		sessionAgg:      sessionAgg,
+		sessions:        sessions,
 		batchCfg:        batchCfg,
+		pageTimer:       NewPageTimer(),
+		normalizer:      normalizer,
+		storageCfg:      storageCfg,
+		errors:          NewErrorSampler(errorCfg),
+		aggregates:      NewAggregateLimiter(aggregateCfg),
+		essentialTypes:  make(map[eventtype.Type]bool, len(consentCfg.EssentialTypes)),
 		eventBuffer:     make([]storage.EventRow, 0, batchCfg.Size),
 		pageViewBuffer:  make([]storage.PageViewRow, 0, 100),
 		webVitalsBuffer: make([]storage.WebVitalsRow, 0, 100),
 		errorBuffer:     make([]storage.ErrorRow, 0, 100),
+		longTaskBuffer:  make([]storage.LongTaskRow, 0, 100),
+		aggregateBuffer: make([]storage.AggregateMetricRow, 0, 100),
 		lastFlush:       time.Now(),
 		done:            make(chan struct{}),
+
+		storeRawUserAgent: enrichmentCfg.StoreRawUserAgent,
+	}
+
+	for _, t := range consentCfg.EssentialTypes {
+		p.essentialTypes[eventtype.Normalize(t)] = true
 	}
 
 	// Start flush ticker
@@ -52,34 +93,115 @@ func NewEventProcessor(ch *storage.ClickHouse, sessionAgg *session.Aggregator, b
 	return p
 }
 
+// SetProjectSettings drops events of the page paths a project excludes
+func (p *EventProcessor) SetProjectSettings(s *settings.Loader) {
+	p.settings = s
+}
+
+// SetLiveTop counts page views and errors in live top counters, written on
+// every flush
+func (p *EventProcessor) SetLiveTop(t *live.TopCounters) {
+	p.live = t
+}
+
 // Process processes a single event
 func (p *EventProcessor) Process(ctx context.Context, event map[string]interface{}) error {
+	if p.pathExcluded(event) {
+		p.excluded.Add(1)
+		return nil
+	}
+
 	// Transform to ClickHouse rows
-	result, err := transformer.TransformEvent(event)
+	result, err := transformer.TransformEvent(event, p.normalizer, p.storageCfg.TimestampSource)
 	if err != nil {
 		return err
 	}
 
+	// Pre-aggregated metrics go to their own table only
+	if result.Aggregate != nil {
+		return p.bufferAggregate(*result.Aggregate)
+	}
+
+	// Synthetic events are stored flagged in events and sessions only; the
+	// page view, web vitals, errors and long tasks tables have no flag to
+	// filter them by
+	if result.Event != nil && result.Event.IsSynthetic == 1 {
+		result.PageView, result.WebVitals, result.Error, result.LongTask = nil, nil, nil, nil
+		result.PageExit = false
+	}
+
+	// Without analytics consent only essential events are kept, anonymized
+	if result.Event != nil && result.Event.AnalyticsDenied == 1 {
+		if !p.essentialTypes[eventtype.Normalize(result.Event.EventType)] {
+			p.consentDropped.Add(1)
+			return nil
+		}
+		anonymize(result)
+	}
+	// The raw User-Agent is only kept with enrichment.store_raw_user_agent;
+	// the parsed browser, OS and device type always are
+	if result.Event != nil && !p.storeRawUserAgent {
+		result.Event.UserAgent = ""
+	}
+
+	// Page views are held until the next page view or exit to compute time on page
+	var finishedPageView *storage.PageViewRow
+	if result.Event != nil {
+		sessionID := result.Event.SessionID
+		ts := result.Event.Timestamp.UnixMilli()
+
+		switch {
+		case result.PageView != nil:
+			finishedPageView = p.pageTimer.StartPage(*result.PageView)
+		case result.PageExit:
+			finishedPageView = p.pageTimer.EndPage(sessionID, ts)
+		case result.Visibility == "hidden":
+			p.pageTimer.Hidden(sessionID, ts)
+		case result.Visibility == "visible":
+			p.pageTimer.Visible(sessionID, ts)
+		default:
+			p.pageTimer.Touch(sessionID, ts)
+		}
+	}
+
+	// Live counts, of all error occurrences whether sampled or not
+	if p.live != nil && result.Event != nil {
+		if result.PageView != nil {
+			p.live.Add(config.LiveDimensionPages, result.PageView.ProjectID, result.PageView.PagePath, result.PageView.Timestamp, 1)
+		}
+		if result.Error != nil {
+			p.live.Add(config.LiveDimensionErrors, result.Error.ProjectID, result.Error.Fingerprint, result.Error.Timestamp, 1)
+		}
+	}
+
 	// Add to buffers
 	p.mu.Lock()
 	if result.Event != nil {
 		p.eventBuffer = append(p.eventBuffer, *result.Event)
 	}
-	if result.PageView != nil {
-		p.pageViewBuffer = append(p.pageViewBuffer, *result.PageView)
+	if finishedPageView != nil {
+		p.pageViewBuffer = append(p.pageViewBuffer, *finishedPageView)
 	}
 	if result.WebVitals != nil {
 		p.webVitalsBuffer = append(p.webVitalsBuffer, *result.WebVitals)
 	}
 	if result.Error != nil {
-		p.errorBuffer = append(p.errorBuffer, *result.Error)
+		if p.errors != nil {
+			p.errorBuffer = append(p.errorBuffer, p.errors.Add(*result.Error, time.Now())...)
+		} else {
+			p.errorBuffer = append(p.errorBuffer, *result.Error)
+		}
+	}
+	if result.LongTask != nil {
+		p.longTaskBuffer = append(p.longTaskBuffer, *result.LongTask)
 	}
 	shouldFlush := len(p.eventBuffer) >= p.batchCfg.Size
 	p.mu.Unlock()
 
-	// Update session aggregation
-	if result.Event != nil && p.sessionAgg != nil {
-		go p.sessionAgg.UpdateSession(ctx, *result.Event)
+	// Update session aggregation; events without a session (produced before
+	// the ingestor rejected them) would all aggregate into one
+	if result.Event != nil && result.Event.SessionID != "" && p.sessions != nil {
+		p.sessions.Enqueue(*result.Event)
 	}
 
 	// Flush if buffer full
@@ -96,6 +218,17 @@ func (p *EventProcessor) flushLoop() {
 		case <-p.done:
 			return
 		case <-p.ticker.C:
+			p.bufferPageViews(p.pageTimer.FlushIdle(time.Now()))
+			if p.errors != nil {
+				p.bufferErrors(p.errors.Expire(time.Now()))
+			}
+			p.aggregates.Expire(time.Now())
+			if n := p.excluded.Swap(0); n > 0 {
+				log.Info().Uint64("count", n).Msg("Dropped events of excluded paths")
+			}
+			if n := p.consentDropped.Swap(0); n > 0 {
+				log.Info().Uint64("count", n).Msg("Dropped events without analytics consent")
+			}
 			p.Flush()
 		}
 	}
@@ -103,10 +236,17 @@ func (p *EventProcessor) flushLoop() {
 
 // Flush writes all buffered data to ClickHouse
 func (p *EventProcessor) Flush() {
+	if p.live != nil {
+		if err := p.live.Flush(context.Background()); err != nil {
+			log.Warn().Err(err).Msg("Failed to flush live top counts")
+		}
+	}
+
 	p.mu.Lock()
 
 	// Check if there's anything to flush
-	if len(p.eventBuffer) == 0 && len(p.pageViewBuffer) == 0 && len(p.webVitalsBuffer) == 0 && len(p.errorBuffer) == 0 {
+	if len(p.eventBuffer) == 0 && len(p.pageViewBuffer) == 0 && len(p.webVitalsBuffer) == 0 &&
+		len(p.errorBuffer) == 0 && len(p.longTaskBuffer) == 0 && len(p.aggregateBuffer) == 0 {
 		p.mu.Unlock()
 		return
 	}
@@ -116,32 +256,51 @@ func (p *EventProcessor) Flush() {
 	pageViews := p.pageViewBuffer
 	webVitals := p.webVitalsBuffer
 	errors := p.errorBuffer
+	longTasks := p.longTaskBuffer
+	aggregates := p.aggregateBuffer
 
 	p.eventBuffer = make([]storage.EventRow, 0, p.batchCfg.Size)
 	p.pageViewBuffer = make([]storage.PageViewRow, 0, 100)
 	p.webVitalsBuffer = make([]storage.WebVitalsRow, 0, 100)
 	p.errorBuffer = make([]storage.ErrorRow, 0, 100)
+	p.longTaskBuffer = make([]storage.LongTaskRow, 0, 100)
+	p.aggregateBuffer = make([]storage.AggregateMetricRow, 0, 100)
 	p.lastFlush = time.Now()
 	p.mu.Unlock()
 
 	ctx := context.Background()
-	start := time.Now()
 
 	// Insert events
 	if len(events) > 0 {
-		if err := p.ch.InsertEvents(ctx, events); err != nil {
-			log.Error().Err(err).Int("count", len(events)).Msg("Failed to insert events")
+		start := time.Now()
+		err := p.ch.InsertEvents(ctx, events)
+		took := p.observeFlush("events", len(events), start)
+		if err != nil {
+			// With event categories, the events of other tables may be stored
+			failed := len(events)
+			if insertErr, ok := err.(*storage.EventsInsertError); ok {
+				failed = len(insertErr.Unsaved)
+			}
+			log.Error().Err(err).Int("count", failed).Msg("Failed to insert events")
 		} else {
 			log.Info().
 				Int("count", len(events)).
-				Dur("duration", time.Since(start)).
+				Dur("duration", took).
 				Msg("Flushed events to ClickHouse")
 		}
 	}
 
-	// Insert page views
+	// Insert page views, with their scroll depth from the session aggregator
 	if len(pageViews) > 0 {
-		if err := p.ch.InsertPageViews(ctx, pageViews); err != nil {
+		if p.sessions != nil {
+			if err := p.sessions.ScrollDepths(ctx, pageViews); err != nil {
+				log.Warn().Err(err).Int("count", len(pageViews)).Msg("Failed to read page view scroll depths")
+			}
+		}
+		start := time.Now()
+		err := p.ch.InsertPageViews(ctx, pageViews)
+		p.observeFlush("page_views", len(pageViews), start)
+		if err != nil {
 			log.Error().Err(err).Int("count", len(pageViews)).Msg("Failed to insert page views")
 		} else {
 			log.Debug().Int("count", len(pageViews)).Msg("Flushed page views to ClickHouse")
@@ -150,7 +309,10 @@ func (p *EventProcessor) Flush() {
 
 	// Insert web vitals
 	if len(webVitals) > 0 {
-		if err := p.ch.InsertWebVitals(ctx, webVitals); err != nil {
+		start := time.Now()
+		err := p.ch.InsertWebVitals(ctx, webVitals)
+		p.observeFlush("web_vitals", len(webVitals), start)
+		if err != nil {
 			log.Error().Err(err).Int("count", len(webVitals)).Msg("Failed to insert web vitals")
 		} else {
 			log.Debug().Int("count", len(webVitals)).Msg("Flushed web vitals to ClickHouse")
@@ -159,17 +321,130 @@ func (p *EventProcessor) Flush() {
 
 	// Insert errors
 	if len(errors) > 0 {
-		if err := p.ch.InsertErrors(ctx, errors); err != nil {
+		start := time.Now()
+		err := p.ch.InsertErrors(ctx, errors)
+		p.observeFlush("errors", len(errors), start)
+		if err != nil {
 			log.Error().Err(err).Int("count", len(errors)).Msg("Failed to insert errors")
 		} else {
 			log.Debug().Int("count", len(errors)).Msg("Flushed errors to ClickHouse")
 		}
 	}
+
+	// Insert long tasks
+	if len(longTasks) > 0 {
+		start := time.Now()
+		err := p.ch.InsertLongTasks(ctx, longTasks)
+		p.observeFlush("long_tasks", len(longTasks), start)
+		if err != nil {
+			log.Error().Err(err).Int("count", len(longTasks)).Msg("Failed to insert long tasks")
+		} else {
+			log.Debug().Int("count", len(longTasks)).Msg("Flushed long tasks to ClickHouse")
+		}
+	}
+
+	// Insert aggregate metrics
+	if len(aggregates) > 0 {
+		start := time.Now()
+		err := p.ch.InsertAggregateMetrics(ctx, aggregates)
+		p.observeFlush("aggregate_metrics", len(aggregates), start)
+		if err != nil {
+			log.Error().Err(err).Int("count", len(aggregates)).Msg("Failed to insert aggregate metrics")
+		} else {
+			log.Debug().Int("count", len(aggregates)).Msg("Flushed aggregate metrics to ClickHouse")
+		}
+	}
+}
+
+// observeFlush records the duration of an insert started at start and warns
+// when it exceeds batch.slow_flush_threshold
+func (p *EventProcessor) observeFlush(table string, rows int, start time.Time) time.Duration {
+	took := time.Since(start)
+	if p.flushMetrics.observe(table, took, p.batchCfg.SlowFlushThreshold) {
+		log.Warn().
+			Str("table", table).
+			Int("count", rows).
+			Dur("duration", took).
+			Dur("threshold", p.batchCfg.SlowFlushThreshold).
+			Msg("Slow ClickHouse flush")
+	}
+	return took
+}
+
+// anonymize removes the user identifiers of an event kept without analytics
+// consent
+func anonymize(result *transformer.TransformResult) {
+	result.Event.UserID = ""
+	result.Event.AnonymousID = ""
+	result.Event.ClientIP = ""
+	result.Event.UserAgent = ""
+	result.Event.City = ""
+	if result.PageView != nil {
+		result.PageView.UserID = ""
+	}
+}
+
+// pathExcluded reports whether the event's page path is excluded by its
+// project's settings
+func (p *EventProcessor) pathExcluded(event map[string]interface{}) bool {
+	if p.settings == nil {
+		return false
+	}
+	page, _ := event["page"].(map[string]interface{})
+	path, _ := page["path"].(string)
+	if path == "" {
+		return false
+	}
+	projectID, _ := event["project_id"].(string)
+	return p.settings.Get(projectID).PathExcluded(path)
+}
+
+// bufferAggregate buffers a valid aggregate metric; label sets over the series
+// limit are dropped, invalid metrics returned as errors
+func (p *EventProcessor) bufferAggregate(row storage.AggregateMetricRow) error {
+	if err := p.aggregates.Validate(row); err != nil {
+		return err
+	}
+	if !p.aggregates.Allow(row) {
+		log.Warn().
+			Str("project_id", row.ProjectID).
+			Str("metric", row.MetricName).
+			Time("bucket_start", row.BucketStart).
+			Msg("Aggregate metric series limit reached, dropping value")
+		return nil
+	}
+
+	p.mu.Lock()
+	p.aggregateBuffer = append(p.aggregateBuffer, row)
+	p.mu.Unlock()
+	return nil
+}
+
+func (p *EventProcessor) bufferPageViews(views []storage.PageViewRow) {
+	if len(views) == 0 {
+		return
+	}
+	p.mu.Lock()
+	p.pageViewBuffer = append(p.pageViewBuffer, views...)
+	p.mu.Unlock()
+}
+
+func (p *EventProcessor) bufferErrors(errors []storage.ErrorRow) {
+	if len(errors) == 0 {
+		return
+	}
+	p.mu.Lock()
+	p.errorBuffer = append(p.errorBuffer, errors...)
+	p.mu.Unlock()
 }
 
 // Stop stops the processor
 func (p *EventProcessor) Stop() {
 	p.ticker.Stop()
 	close(p.done)
+	p.bufferPageViews(p.pageTimer.FlushAll())
+	if p.errors != nil {
+		p.bufferErrors(p.errors.FlushAll())
+	}
 	p.Flush() // Final flush
 }
@@ -0,0 +1,151 @@
+package processor
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sort"
+	"sync/atomic"
+	"time"
+
+	"github.com/rs/zerolog/log"
+
+	"github.com/gosight/gosight/processor/internal/config"
+	"github.com/gosight/gosight/processor/internal/settings"
+	"github.com/gosight/gosight/processor/internal/storage"
+)
+
+// Retention periodically deletes the rows of each project older than its
+// retention_days project setting. Projects without it keep the table TTLs.
+type Retention struct {
+	ch       *storage.ClickHouse
+	settings *settings.Loader
+	cfg      config.RetentionConfig
+
+	lastMutation time.Time
+
+	// Indexed like storage.RetentionTables
+	expiredRows [len(storage.RetentionTables)]atomic.Uint64
+	mutations   [len(storage.RetentionTables)]atomic.Uint64
+}
+
+// NewRetention creates a new retention job
+func NewRetention(ch *storage.ClickHouse, projectSettings *settings.Loader, cfg config.RetentionConfig) *Retention {
+	return &Retention{
+		ch:       ch,
+		settings: projectSettings,
+		cfg:      cfg,
+	}
+}
+
+// Start enforces retention right away and then every interval until ctx is cancelled
+func (r *Retention) Start(ctx context.Context) {
+	r.Enforce(ctx, time.Now())
+
+	ticker := time.NewTicker(r.cfg.Interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			r.Enforce(ctx, now)
+		}
+	}
+}
+
+// Enforce deletes expired rows of every project with a retention setting. It
+// stops early when ClickHouse has too many unfinished mutations; the rest is
+// picked up by the next run.
+func (r *Retention) Enforce(ctx context.Context, now time.Time) {
+	all := r.settings.All()
+	projectIDs := make([]string, 0, len(all))
+	for projectID, s := range all {
+		if s.RetentionDays != nil {
+			projectIDs = append(projectIDs, projectID)
+		}
+	}
+	sort.Strings(projectIDs)
+
+	for _, projectID := range projectIDs {
+		cutoff := now.UTC().AddDate(0, 0, -*all[projectID].RetentionDays)
+		for i, table := range storage.RetentionTables {
+			if ctx.Err() != nil {
+				return
+			}
+			if !r.expire(ctx, i, table, projectID, cutoff) {
+				return
+			}
+		}
+	}
+}
+
+// expire deletes a project's rows of table older than cutoff, and reports
+// whether the run should go on
+func (r *Retention) expire(ctx context.Context, i int, table storage.RetentionTable, projectID string, cutoff time.Time) bool {
+	logger := log.With().
+		Str("project_id", projectID).
+		Str("table", table.Name).
+		Time("cutoff", cutoff).
+		Bool("dry_run", r.cfg.DryRun).
+		Logger()
+
+	count, err := r.ch.CountExpired(ctx, table, projectID, cutoff)
+	if err != nil {
+		logger.Error().Err(err).Msg("Failed to count expired rows")
+		return true
+	}
+	if count == 0 {
+		return true
+	}
+	r.expiredRows[i].Add(count)
+
+	if r.cfg.DryRun {
+		logger.Info().Uint64("rows", count).Msg("Would delete expired rows")
+		return true
+	}
+
+	// Rate limit mutations, which rewrite whole parts
+	if wait := r.cfg.MutationInterval - time.Since(r.lastMutation); wait > 0 {
+		select {
+		case <-ctx.Done():
+			return false
+		case <-time.After(wait):
+		}
+	}
+	pending, err := r.ch.PendingMutations(ctx)
+	if err != nil {
+		logger.Error().Err(err).Msg("Failed to check pending mutations")
+		return false
+	}
+	if pending >= uint64(r.cfg.MaxPendingMutations) {
+		logger.Warn().Uint64("pending", pending).Msg("Too many pending mutations, postponing retention to the next run")
+		return false
+	}
+
+	r.lastMutation = time.Now()
+	if err := r.ch.DeleteExpired(ctx, table, projectID, cutoff); err != nil {
+		logger.Error().Err(err).Msg("Failed to delete expired rows")
+		return true
+	}
+	r.mutations[i].Add(1)
+
+	logger.Info().Uint64("rows", count).Msg("Deleting expired rows")
+	return true
+}
+
+// WriteMetrics writes the retention metrics in the Prometheus text format
+func (r *Retention) WriteMetrics(w io.Writer) {
+	fmt.Fprintln(w, "# HELP gosight_retention_expired_rows_total Rows found past their project's retention, by table (deleted unless in dry run).")
+	fmt.Fprintln(w, "# TYPE gosight_retention_expired_rows_total counter")
+	for i, table := range storage.RetentionTables {
+		fmt.Fprintf(w, "gosight_retention_expired_rows_total{table=%q} %d\n", table.Name, r.expiredRows[i].Load())
+	}
+
+	fmt.Fprintln(w, "# HELP gosight_retention_mutations_total Delete mutations issued for retention, by table.")
+	fmt.Fprintln(w, "# TYPE gosight_retention_mutations_total counter")
+	for i, table := range storage.RetentionTables {
+		fmt.Fprintf(w, "gosight_retention_mutations_total{table=%q} %d\n", table.Name, r.mutations[i].Load())
+	}
+}
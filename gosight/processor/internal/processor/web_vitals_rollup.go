@@ -0,0 +1,95 @@
+package processor
+
+import (
+	"context"
+	"time"
+
+	"github.com/rs/zerolog/log"
+
+	"github.com/gosight/gosight/processor/internal/config"
+	"github.com/gosight/gosight/processor/internal/storage"
+)
+
+// WebVitalsRollup periodically aggregates raw web vitals into per-page quantile
+// states. Web vitals arriving late, after their interval was rolled up, are
+// picked up by rolling up the intervals of the lateness window again.
+type WebVitalsRollup struct {
+	ch       *storage.ClickHouse
+	interval time.Duration
+	lateness time.Duration
+}
+
+// NewWebVitalsRollup creates a new web vitals rollup job
+func NewWebVitalsRollup(ch *storage.ClickHouse, cfg config.RollupConfig) *WebVitalsRollup {
+	return &WebVitalsRollup{
+		ch:       ch,
+		interval: cfg.Interval,
+		lateness: cfg.Lateness,
+	}
+}
+
+// Start rolls up the previous intervals on every tick until ctx is cancelled
+func (r *WebVitalsRollup) Start(ctx context.Context) {
+	// Catch up on the last completed intervals right away
+	r.RollupPrevious(ctx, time.Now())
+
+	ticker := time.NewTicker(r.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			r.RollupPrevious(ctx, now)
+		}
+	}
+}
+
+// RollupPrevious rolls up the completed intervals of the lateness window before
+// now that are stale: not rolled up yet, or with web vitals inserted since
+func (r *WebVitalsRollup) RollupPrevious(ctx context.Context, now time.Time) {
+	for _, from := range r.periods(now) {
+		if ctx.Err() != nil {
+			return
+		}
+		r.rollup(ctx, from, from.Add(r.interval))
+	}
+}
+
+// periods returns the starts of the completed intervals of the lateness window
+// before now, oldest first; the last completed interval is always included
+func (r *WebVitalsRollup) periods(now time.Time) []time.Time {
+	end := now.UTC().Truncate(r.interval)
+	n := int(r.lateness/r.interval) + 1
+
+	periods := make([]time.Time, n)
+	for i := range periods {
+		periods[i] = end.Add(-time.Duration(n-i) * r.interval)
+	}
+	return periods
+}
+
+// rollup rolls up [from, to) unless its rollup is up to date
+func (r *WebVitalsRollup) rollup(ctx context.Context, from, to time.Time) {
+	stale, err := r.ch.WebVitalsRollupStale(ctx, from, to)
+	if err != nil {
+		log.Error().Err(err).Time("from", from).Msg("Failed to check web vitals rollup")
+		return
+	}
+	if !stale {
+		return
+	}
+
+	start := time.Now()
+	if err := r.ch.RollupWebVitals(ctx, from, to); err != nil {
+		log.Error().Err(err).Time("from", from).Time("to", to).Msg("Failed to roll up web vitals")
+		return
+	}
+
+	log.Info().
+		Time("from", from).
+		Time("to", to).
+		Dur("duration", time.Since(start)).
+		Msg("Rolled up web vitals")
+}
@@ -0,0 +1,39 @@
+package processor
+
+import (
+	"testing"
+	"time"
+
+	"github.com/gosight/gosight/processor/internal/config"
+)
+
+func TestWebVitalsRollupPeriods(t *testing.T) {
+	r := NewWebVitalsRollup(nil, config.RollupConfig{Interval: time.Hour, Lateness: 3 * time.Hour})
+	now := time.Date(2024, 5, 1, 13, 20, 0, 0, time.UTC)
+
+	periods := r.periods(now)
+	want := []time.Time{
+		time.Date(2024, 5, 1, 9, 0, 0, 0, time.UTC),
+		time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC),
+		time.Date(2024, 5, 1, 11, 0, 0, 0, time.UTC),
+		time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC),
+	}
+	if len(periods) != len(want) {
+		t.Fatalf("periods = %v, want %v", periods, want)
+	}
+	for i := range want {
+		if !periods[i].Equal(want[i]) {
+			t.Errorf("periods[%d] = %v, want %v", i, periods[i], want[i])
+		}
+	}
+}
+
+func TestWebVitalsRollupPeriodsWithoutLateness(t *testing.T) {
+	r := NewWebVitalsRollup(nil, config.RollupConfig{Interval: 15 * time.Minute})
+	now := time.Date(2024, 5, 1, 13, 20, 0, 0, time.UTC)
+
+	periods := r.periods(now)
+	if len(periods) != 1 || !periods[0].Equal(time.Date(2024, 5, 1, 13, 0, 0, 0, time.UTC)) {
+		t.Errorf("periods = %v, want only the last completed interval", periods)
+	}
+}
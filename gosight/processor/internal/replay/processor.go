@@ -0,0 +1,316 @@
+package replay
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+
+	"github.com/gosight/gosight/processor/internal/config"
+	"github.com/gosight/gosight/processor/internal/consumer"
+	"github.com/gosight/gosight/processor/internal/storage"
+)
+
+// Store is the storage the replay processor writes to, implemented by
+// storage.ClickHouse
+type Store interface {
+	GetReplayManifest(ctx context.Context, projectID, sessionID string) (*storage.ReplayManifest, error)
+	InsertReplayChunks(ctx context.Context, chunks []storage.ReplayChunkRow) error
+	InsertReplayManifests(ctx context.Context, manifests []storage.ReplayManifest) error
+}
+
+// maxBufferedBatches bounds the chunks kept while ClickHouse is failing, in
+// batches; Process rejects chunks beyond it so the consumer backs off
+const maxBufferedBatches = 10
+
+// Processor stores replay chunks from Kafka in replay_chunks and keeps a
+// manifest per session in replay_manifests. It implements
+// consumer.DeferredProcessor.
+//
+// Chunks are buffered up to batch_size or flush_interval, and the manifests of
+// their sessions are written after them, so a stored manifest never lists a
+// chunk that was not stored. Chunks that fail to insert stay buffered for the
+// next flush, and a chunk's offset is committed only once it and its
+// session's manifest are written. A session without chunks for
+// session_timeout has its manifest finalized and is forgotten; a session seen
+// for the first time (or again, e.g. after a restart) resumes its stored
+// manifest.
+type Processor struct {
+	ch             Store
+	batchSize      int
+	sessionTimeout time.Duration
+
+	chunks     []storage.ReplayChunkRow
+	acks       []func()            // of the buffered chunks
+	storedAcks []func()            // of chunks stored whose manifests are not yet written
+	sessions   map[string]*session // project:session
+	mu         sync.Mutex
+	flushMu    sync.Mutex // keeps manifest writes of a session in order
+
+	ticker *time.Ticker
+	done   chan struct{}
+}
+
+type session struct {
+	manifest storage.ReplayManifest
+	lastSeen time.Time
+	dirty    bool // changed since last written
+}
+
+// NewProcessor creates a replay processor
+func NewProcessor(ch Store, cfg config.ReplayConfig) *Processor {
+	p := &Processor{
+		ch:             ch,
+		batchSize:      cfg.BatchSize,
+		sessionTimeout: cfg.SessionTimeout,
+		chunks:         make([]storage.ReplayChunkRow, 0, cfg.BatchSize),
+		sessions:       make(map[string]*session),
+		done:           make(chan struct{}),
+	}
+
+	p.ticker = time.NewTicker(cfg.FlushInterval)
+	go p.flushLoop()
+
+	return p
+}
+
+// DefersCommits marks the processor as a consumer.DeferredProcessor
+func (p *Processor) DefersCommits() {}
+
+// Process buffers a replay chunk and adds it to its session's manifest. Its
+// message is acked once the chunk and the manifest are written.
+func (p *Processor) Process(ctx context.Context, msg map[string]interface{}) error {
+	chunk, err := parseChunk(msg)
+	if err != nil {
+		return err
+	}
+	key := chunk.ProjectID + ":" + chunk.SessionID
+
+	p.mu.Lock()
+	_, known := p.sessions[key]
+	full := len(p.chunks) >= maxBufferedBatches*p.batchSize
+	p.mu.Unlock()
+
+	if full {
+		return fmt.Errorf("replay chunk buffer full (%d chunks), ClickHouse writes are failing", maxBufferedBatches*p.batchSize)
+	}
+
+	// A session's chunks are processed by one worker at a time, so nothing else
+	// adds it while its stored manifest is loaded
+	var stored *storage.ReplayManifest
+	if !known {
+		if stored, err = p.ch.GetReplayManifest(ctx, chunk.ProjectID, chunk.SessionID); err != nil {
+			return fmt.Errorf("load replay manifest: %w", err)
+		}
+	}
+
+	p.mu.Lock()
+	s, ok := p.sessions[key]
+	if !ok {
+		s = &session{manifest: storage.ReplayManifest{ProjectID: chunk.ProjectID, SessionID: chunk.SessionID}}
+		if stored != nil {
+			s.manifest = *stored
+		}
+		p.sessions[key] = s
+	}
+	s.manifest.Finalized = false // reopened if finalized but not yet written
+	s.manifest.AddChunk(chunk)
+	s.lastSeen = time.Now()
+	s.dirty = true
+	p.chunks = append(p.chunks, chunk)
+	p.acks = append(p.acks, consumer.Ack(ctx))
+	shouldFlush := len(p.chunks) >= p.batchSize
+	p.mu.Unlock()
+
+	if shouldFlush {
+		p.Flush()
+	}
+	return nil
+}
+
+// Flush writes the buffered chunks, then the manifests changed since the last
+// flush, and acks the chunks. Chunks that fail to insert are buffered again and
+// their manifests kept for the next flush; if the manifests fail, the stored
+// chunks are acked once a later flush writes them.
+func (p *Processor) Flush() {
+	p.flushMu.Lock()
+	defer p.flushMu.Unlock()
+
+	p.mu.Lock()
+	chunks, acks := p.chunks, p.acks
+	p.chunks = make([]storage.ReplayChunkRow, 0, p.batchSize)
+	p.acks = nil
+
+	now := time.Now()
+	var manifests []storage.ReplayManifest
+	var keys []string
+	for key, s := range p.sessions {
+		if !s.dirty {
+			continue
+		}
+		s.dirty = false
+		manifests = append(manifests, cloneManifest(s.manifest, now))
+		keys = append(keys, key)
+	}
+	p.mu.Unlock()
+
+	if len(chunks) == 0 && len(manifests) == 0 {
+		return
+	}
+	ctx := context.Background()
+
+	if err := p.ch.InsertReplayChunks(ctx, chunks); err != nil {
+		log.Error().Err(err).Int("count", len(chunks)).Msg("Failed to insert replay chunks")
+		p.retryChunks(chunks, acks, keys)
+		return
+	}
+
+	p.mu.Lock()
+	acks = append(p.storedAcks, acks...)
+	p.storedAcks = nil
+	p.mu.Unlock()
+
+	if err := p.ch.InsertReplayManifests(ctx, manifests); err != nil {
+		log.Error().Err(err).Int("count", len(manifests)).Msg("Failed to insert replay manifests")
+		p.mu.Lock()
+		p.storedAcks = acks
+		p.mu.Unlock()
+		p.retryManifests(keys)
+		return
+	}
+	p.forgetFinalized(keys)
+	for _, ack := range acks {
+		ack()
+	}
+
+	log.Debug().
+		Int("chunks", len(chunks)).
+		Int("manifests", len(manifests)).
+		Msg("Flushed replay chunks to ClickHouse")
+}
+
+// retryChunks buffers chunks that failed to insert again, ahead of the chunks
+// buffered since, and keeps their manifests for the next flush
+func (p *Processor) retryChunks(chunks []storage.ReplayChunkRow, acks []func(), keys []string) {
+	p.mu.Lock()
+	p.chunks = append(chunks, p.chunks...)
+	p.acks = append(acks, p.acks...)
+	p.mu.Unlock()
+
+	p.retryManifests(keys)
+}
+
+// retryManifests marks the sessions of manifests that failed to be written for
+// the next flush
+func (p *Processor) retryManifests(keys []string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	for _, key := range keys {
+		p.sessions[key].dirty = true
+	}
+}
+
+// forgetFinalized stops tracking the sessions whose finalized manifest was
+// written, unless a chunk reopened them since
+func (p *Processor) forgetFinalized(keys []string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	for _, key := range keys {
+		if s := p.sessions[key]; s.manifest.Finalized && !s.dirty {
+			delete(p.sessions, key)
+		}
+	}
+}
+
+// finalizeIdle finalizes the manifests of sessions without chunks for
+// sessionTimeout; they are forgotten once written
+func (p *Processor) finalizeIdle(now time.Time) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	for _, s := range p.sessions {
+		if s.manifest.Finalized || now.Sub(s.lastSeen) < p.sessionTimeout {
+			continue
+		}
+		s.manifest.Finalized = true
+		s.dirty = true
+	}
+}
+
+func (p *Processor) flushLoop() {
+	for {
+		select {
+		case <-p.done:
+			return
+		case <-p.ticker.C:
+			p.finalizeIdle(time.Now())
+			p.Flush()
+		}
+	}
+}
+
+// Stop stops the processor and writes what is buffered. Open sessions are not
+// finalized: their chunks may still arrive after a restart.
+func (p *Processor) Stop() {
+	p.ticker.Stop()
+	close(p.done)
+	p.Flush()
+}
+
+// cloneManifest copies a manifest for writing, as AddChunk reuses its slices
+func cloneManifest(m storage.ReplayManifest, updatedAt time.Time) storage.ReplayManifest {
+	m.ChunkIndices = slices.Clone(m.ChunkIndices)
+	m.MissingChunks = slices.Clone(m.MissingChunks)
+	m.SnapshotChunks = slices.Clone(m.SnapshotChunks)
+	m.UpdatedAt = updatedAt
+	return m
+}
+
+// parseChunk reads a chunk produced by the ingestor. HTTP chunks carry the
+// rrweb events as JSON in "events", gRPC chunks the client's bytes base64
+// encoded in "data".
+func parseChunk(msg map[string]interface{}) (storage.ReplayChunkRow, error) {
+	chunk := storage.ReplayChunkRow{}
+	chunk.ProjectID, _ = msg["project_id"].(string)
+	chunk.SessionID, _ = msg["session_id"].(string)
+	if chunk.ProjectID == "" || chunk.SessionID == "" {
+		return chunk, errors.New("replay chunk without project_id or session_id")
+	}
+
+	index, ok := msg["chunk_index"].(float64)
+	if !ok || index < 0 || index > math.MaxUint32 || index != math.Trunc(index) {
+		return chunk, fmt.Errorf("replay chunk: invalid chunk_index %v", msg["chunk_index"])
+	}
+	chunk.ChunkIndex = uint32(index)
+
+	start, _ := msg["timestamp_start"].(float64)
+	end, _ := msg["timestamp_end"].(float64)
+	chunk.TimestampStart = time.UnixMilli(int64(start))
+	chunk.TimestampEnd = time.UnixMilli(int64(max(end, start)))
+
+	if snapshot, _ := msg["has_full_snapshot"].(bool); snapshot {
+		chunk.HasFullSnapshot = 1
+	}
+
+	var err error
+	if events, ok := msg["events"]; ok {
+		chunk.Data, err = json.Marshal(events)
+	} else if data, ok := msg["data"].(string); ok {
+		chunk.Data, err = base64.StdEncoding.DecodeString(data)
+	} else {
+		err = errors.New("no events or data")
+	}
+	if err != nil {
+		return chunk, fmt.Errorf("replay chunk %d: %w", chunk.ChunkIndex, err)
+	}
+	return chunk, nil
+}
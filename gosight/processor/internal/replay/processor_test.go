@@ -0,0 +1,220 @@
+package replay
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gosight/gosight/processor/internal/config"
+	"github.com/gosight/gosight/processor/internal/consumer"
+	"github.com/gosight/gosight/processor/internal/storage"
+)
+
+// fakeStore keeps what was written and fails the writes it is told to
+type fakeStore struct {
+	mu              sync.Mutex
+	chunks          []storage.ReplayChunkRow
+	manifests       []storage.ReplayManifest
+	failChunks      bool
+	failManifests   bool
+	storedManifests map[string]*storage.ReplayManifest
+}
+
+func (s *fakeStore) GetReplayManifest(ctx context.Context, projectID, sessionID string) (*storage.ReplayManifest, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.storedManifests[projectID+":"+sessionID], nil
+}
+
+func (s *fakeStore) InsertReplayChunks(ctx context.Context, chunks []storage.ReplayChunkRow) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.failChunks {
+		return errors.New("clickhouse unavailable")
+	}
+	s.chunks = append(s.chunks, chunks...)
+	return nil
+}
+
+func (s *fakeStore) InsertReplayManifests(ctx context.Context, manifests []storage.ReplayManifest) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.failManifests {
+		return errors.New("clickhouse unavailable")
+	}
+	s.manifests = append(s.manifests, manifests...)
+	return nil
+}
+
+func (s *fakeStore) setFailures(chunks, manifests bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.failChunks, s.failManifests = chunks, manifests
+}
+
+// assertManifestsStored checks that every manifest written lists only stored chunks
+func (s *fakeStore) assertManifestsStored(t *testing.T) {
+	t.Helper()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, m := range s.manifests {
+		for _, index := range m.ChunkIndices {
+			stored := slices.ContainsFunc(s.chunks, func(c storage.ReplayChunkRow) bool {
+				return c.SessionID == m.SessionID && c.ChunkIndex == index
+			})
+			if !stored {
+				t.Errorf("manifest of %s lists chunk %d, which was never stored", m.SessionID, index)
+			}
+		}
+	}
+}
+
+func newTestProcessor(t *testing.T, store Store, batchSize int) *Processor {
+	t.Helper()
+	p := NewProcessor(store, config.ReplayConfig{
+		BatchSize:      batchSize,
+		FlushInterval:  time.Hour,
+		SessionTimeout: time.Hour,
+	})
+	t.Cleanup(func() {
+		p.ticker.Stop()
+		close(p.done)
+	})
+	return p
+}
+
+func chunkMsg(sessionID string, index int) map[string]interface{} {
+	return map[string]interface{}{
+		"project_id":      "proj",
+		"session_id":      sessionID,
+		"chunk_index":     float64(index),
+		"timestamp_start": float64(1700000000000 + index*1000),
+		"timestamp_end":   float64(1700000000500 + index*1000),
+		"events":          []interface{}{map[string]interface{}{"type": 2}},
+	}
+}
+
+func process(t *testing.T, p *Processor, acked *atomic.Int32, sessionID string, index int) error {
+	t.Helper()
+	ctx := consumer.WithAck(context.Background(), func() { acked.Add(1) })
+	return p.Process(ctx, chunkMsg(sessionID, index))
+}
+
+func TestFlushKeepsChunksThatFailToInsert(t *testing.T) {
+	store := &fakeStore{}
+	p := newTestProcessor(t, store, 100)
+	var acked atomic.Int32
+
+	for i := 0; i < 3; i++ {
+		if err := process(t, p, &acked, "s1", i); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	store.setFailures(true, false)
+	p.Flush()
+	if n := acked.Load(); n != 0 {
+		t.Fatalf("acked %d chunks that failed to insert", n)
+	}
+
+	// A chunk arriving meanwhile goes out with the retried ones
+	if err := process(t, p, &acked, "s1", 3); err != nil {
+		t.Fatal(err)
+	}
+	store.setFailures(false, false)
+	p.Flush()
+
+	if n := acked.Load(); n != 4 {
+		t.Errorf("acked %d chunks, want 4", n)
+	}
+	if len(store.chunks) != 4 {
+		t.Errorf("stored %d chunks, want 4", len(store.chunks))
+	}
+	for i, c := range store.chunks {
+		if c.ChunkIndex != uint32(i) {
+			t.Errorf("chunk %d stored as index %d, want in order", i, c.ChunkIndex)
+		}
+	}
+	if len(store.manifests) != 1 || len(store.manifests[0].ChunkIndices) != 4 {
+		t.Errorf("manifests = %+v, want one listing 4 chunks", store.manifests)
+	}
+	store.assertManifestsStored(t)
+}
+
+func TestFlushAcksOnlyOnceManifestsAreWritten(t *testing.T) {
+	store := &fakeStore{}
+	p := newTestProcessor(t, store, 100)
+	var acked atomic.Int32
+
+	if err := process(t, p, &acked, "s1", 0); err != nil {
+		t.Fatal(err)
+	}
+
+	store.setFailures(false, true)
+	p.Flush()
+	if n := acked.Load(); n != 0 {
+		t.Fatalf("acked %d chunks whose manifest was not written", n)
+	}
+
+	store.setFailures(false, false)
+	p.Flush()
+	if n := acked.Load(); n != 1 {
+		t.Errorf("acked %d chunks, want 1", n)
+	}
+	if len(store.chunks) != 1 {
+		t.Errorf("stored %d chunks, want 1 (not inserted again)", len(store.chunks))
+	}
+	if len(store.manifests) != 1 {
+		t.Errorf("stored %d manifests, want 1", len(store.manifests))
+	}
+	store.assertManifestsStored(t)
+}
+
+func TestProcessRejectsChunksWhenBufferIsFull(t *testing.T) {
+	store := &fakeStore{failChunks: true}
+	p := newTestProcessor(t, store, 2)
+	var acked atomic.Int32
+
+	var err error
+	for i := 0; i < maxBufferedBatches*2+1 && err == nil; i++ {
+		err = process(t, p, &acked, "s1", i)
+	}
+	if err == nil {
+		t.Fatal("expected Process to reject chunks once the buffer is full")
+	}
+	if len(p.chunks) != maxBufferedBatches*2 {
+		t.Errorf("buffered %d chunks, want %d", len(p.chunks), maxBufferedBatches*2)
+	}
+	store.assertManifestsStored(t)
+}
+
+func TestParseChunk(t *testing.T) {
+	chunk, err := parseChunk(map[string]interface{}{
+		"project_id":  "proj",
+		"session_id":  "s1",
+		"chunk_index": float64(2),
+		"data":        "aGVsbG8=",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(chunk.Data) != "hello" || chunk.ChunkIndex != 2 {
+		t.Errorf("chunk = %+v", chunk)
+	}
+
+	for _, msg := range []map[string]interface{}{
+		{"session_id": "s1", "chunk_index": float64(0), "data": ""},
+		{"project_id": "proj", "session_id": "s1", "chunk_index": float64(-1), "data": ""},
+		{"project_id": "proj", "session_id": "s1", "chunk_index": float64(1.5), "data": ""},
+		{"project_id": "proj", "session_id": "s1", "chunk_index": float64(0)},
+	} {
+		if _, err := parseChunk(msg); err == nil {
+			t.Errorf("parseChunk(%v): expected an error", msg)
+		}
+	}
+}
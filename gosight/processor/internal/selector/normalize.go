@@ -0,0 +1,141 @@
+package selector
+
+import (
+	"regexp"
+	"strings"
+
+	"github.com/gosight/gosight/processor/internal/config"
+)
+
+// DefaultHashedClassPatterns match framework-generated class names. If a pattern
+// has a capture group the class is replaced by the group, otherwise it is dropped.
+var DefaultHashedClassPatterns = []string{
+	`^(.+)__[A-Za-z0-9_-]{5,}$`,                              // CSS modules: Button_primary__a7f3d
+	`^sc-[A-Za-z0-9]+$`,                                      // styled-components component id: sc-bdVaJa
+	`^(?:css|emotion)-[a-z0-9]{5,}(?:-.*)?$`,                 // emotion: css-1x2y3z4-Button
+	`(?i)^(.+?)[-_](?:[a-z]+[0-9]|[0-9]+[a-z])[a-z0-9]{2,}$`, // hashed suffix: btn-a7f3
+}
+
+// Default bounds on target_classes; payloads can carry thousands of classes
+const (
+	DefaultMaxTargetClasses = 32
+	DefaultMaxClassLength   = 128
+)
+
+var (
+	classPattern    = regexp.MustCompile(`\.(-?[_a-zA-Z][_a-zA-Z0-9-]*)`)
+	positionPattern = regexp.MustCompile(`:nth-(?:child|of-type|last-child|last-of-type)\([^)]*\)`)
+)
+
+// Normalizer turns per-render selectors into stable ones for grouping by
+// stripping hashed class names and positional pseudo-classes
+type Normalizer struct {
+	hashedClasses []*regexp.Regexp
+	maxClasses    int
+	maxClassLen   int
+}
+
+// NewNormalizer compiles the configured hashed class patterns (or the defaults)
+func NewNormalizer(cfg config.SelectorConfig) (*Normalizer, error) {
+	patterns := cfg.HashedClassPatterns
+	if len(patterns) == 0 {
+		patterns = DefaultHashedClassPatterns
+	}
+
+	n := &Normalizer{
+		maxClasses:  cfg.MaxTargetClasses,
+		maxClassLen: cfg.MaxClassLength,
+	}
+	if n.maxClasses <= 0 {
+		n.maxClasses = DefaultMaxTargetClasses
+	}
+	if n.maxClassLen <= 0 {
+		n.maxClassLen = DefaultMaxClassLength
+	}
+	for _, p := range patterns {
+		re, err := regexp.Compile(p)
+		if err != nil {
+			return nil, err
+		}
+		n.hashedClasses = append(n.hashedClasses, re)
+	}
+	return n, nil
+}
+
+// Normalize returns the stable form of a selector. A nil Normalizer returns it unchanged.
+func (n *Normalizer) Normalize(sel string) string {
+	if n == nil || sel == "" {
+		return sel
+	}
+
+	sel = positionPattern.ReplaceAllString(sel, "")
+
+	// Compounds left empty by dropped classes become "*", so descendant
+	// combinators between them are kept
+	fields := strings.Fields(sel)
+	out := make([]string, 0, len(fields))
+	for _, f := range fields {
+		if !isCombinator(f) {
+			f = classPattern.ReplaceAllStringFunc(f, func(match string) string {
+				class := n.normalizeClass(match[1:])
+				if class == "" {
+					return ""
+				}
+				return "." + class
+			})
+			if f == "" {
+				f = "*"
+			}
+		}
+		out = append(out, f)
+	}
+	if len(out) == 0 {
+		return "*"
+	}
+
+	return strings.Join(out, " ")
+}
+
+// LimitClasses returns the string classes of a payload's target_classes, keeping
+// at most the first max_target_classes and truncating each to max_class_length.
+// A nil Normalizer uses the default limits.
+func (n *Normalizer) LimitClasses(classes []interface{}) []string {
+	maxClasses, maxLen := DefaultMaxTargetClasses, DefaultMaxClassLength
+	if n != nil {
+		maxClasses, maxLen = n.maxClasses, n.maxClassLen
+	}
+
+	var out []string
+	for _, c := range classes {
+		if len(out) == maxClasses {
+			break
+		}
+		s, ok := c.(string)
+		if !ok {
+			continue
+		}
+		if len(s) > maxLen {
+			s = strings.ToValidUTF8(s[:maxLen], "")
+		}
+		out = append(out, s)
+	}
+	return out
+}
+
+func isCombinator(s string) bool {
+	return s == ">" || s == "+" || s == "~"
+}
+
+func (n *Normalizer) normalizeClass(class string) string {
+	for _, re := range n.hashedClasses {
+		m := re.FindStringSubmatch(class)
+		if m == nil {
+			continue
+		}
+		if len(m) > 1 {
+			return m[1]
+		}
+		return ""
+	}
+	return class
+}
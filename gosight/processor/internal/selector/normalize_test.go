@@ -0,0 +1,89 @@
+package selector
+
+import (
+	"strings"
+	"testing"
+	"unicode/utf8"
+
+	"github.com/gosight/gosight/processor/internal/config"
+)
+
+func TestNormalize(t *testing.T) {
+	n, err := NewNormalizer(config.SelectorConfig{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		sel, want string
+	}{
+		{"", ""},
+		{"button#submit", "button#submit"},
+		{"div:nth-child(3) > button.btn-a7f3", "div > button.btn"},
+		{"div:nth-child(4) > button.btn-9c2e", "div > button.btn"},
+		{"ul > li:nth-of-type(2n+1) > a.nav-link", "ul > li > a.nav-link"},
+		{"button.Button_primary__a7f3d", "button.Button_primary"},
+		{"div.sc-bdVaJa > span.css-1x2y3z4-Label", "div > span"},
+		{".sc-bdVaJa > .label", "* > .label"},
+		{".sc-bdVaJa", "*"},
+		{"form .css-abc12 + .sc-xyz", "form * + *"},
+	}
+	for _, tt := range tests {
+		if got := n.Normalize(tt.sel); got != tt.want {
+			t.Errorf("Normalize(%q) = %q, want %q", tt.sel, got, tt.want)
+		}
+	}
+}
+
+func TestNormalizeConfiguredPatterns(t *testing.T) {
+	n, err := NewNormalizer(config.SelectorConfig{HashedClassPatterns: []string{`^(.+)--v[0-9]+$`, `^tw-`}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := n.Normalize("a.link--v42.tw-p-4.btn-a7f3"), "a.link.btn-a7f3"; got != want {
+		t.Errorf("Normalize = %q, want %q", got, want)
+	}
+
+	if _, err := NewNormalizer(config.SelectorConfig{HashedClassPatterns: []string{"("}}); err == nil {
+		t.Error("invalid pattern accepted")
+	}
+
+	var none *Normalizer
+	if got := none.Normalize("div:nth-child(3)"); got != "div:nth-child(3)" {
+		t.Errorf("nil Normalizer changed the selector to %q", got)
+	}
+}
+
+func TestLimitClasses(t *testing.T) {
+	classes := make([]interface{}, 10000)
+	for i := range classes {
+		classes[i] = strings.Repeat("c", 1000)
+	}
+	classes[0] = 42 // not a string
+
+	n, err := NewNormalizer(config.SelectorConfig{MaxTargetClasses: 8, MaxClassLength: 16})
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := n.LimitClasses(classes)
+	if len(got) != 8 {
+		t.Fatalf("kept %d classes, want 8", len(got))
+	}
+	for _, c := range got {
+		if len(c) != 16 {
+			t.Fatalf("class of length %d, want 16", len(c))
+		}
+	}
+
+	var defaults *Normalizer
+	got = defaults.LimitClasses(classes)
+	if len(got) != DefaultMaxTargetClasses || len(got[0]) != DefaultMaxClassLength {
+		t.Errorf("default limits kept %d classes of length %d", len(got), len(got[0]))
+	}
+
+	// Truncation does not split a multi-byte rune
+	got = n.LimitClasses([]interface{}{strings.Repeat("é", 20)})
+	if len(got) != 1 || !utf8.ValidString(got[0]) || len(got[0]) > 16 {
+		t.Errorf("LimitClasses = %q, want valid UTF-8 of at most 16 bytes", got)
+	}
+}
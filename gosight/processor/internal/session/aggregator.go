@@ -2,20 +2,94 @@ package session
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
 	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/redis/go-redis/v9"
 	"github.com/rs/zerolog/log"
 
 	"github.com/gosight/gosight/processor/internal/config"
+	"github.com/gosight/gosight/processor/internal/eventtype"
 	"github.com/gosight/gosight/processor/internal/storage"
 )
 
+// sessionTimesScript orders the time-dependent session fields by event
+// timestamp rather than processing order, as batching and the network reorder
+// events: started_at and ended_at are the earliest and latest event, the entry
+// and exit pages those of the earliest and latest page view (entry_page_at and
+// exit_page_at). An entry page stored without entry_page_at, before these were
+// tracked, is kept.
+//
+// KEYS[1] session hash; ARGV[1] event timestamp (ms), ARGV[2] page view path,
+// empty for other events
+var sessionTimesScript = redis.NewScript(`
+local key, ts, page = KEYS[1], tonumber(ARGV[1]), ARGV[2]
+local started = tonumber(redis.call('HGET', key, 'started_at'))
+if not started or ts < started then
+	redis.call('HSET', key, 'started_at', ts)
+end
+local ended = tonumber(redis.call('HGET', key, 'ended_at'))
+if not ended or ts >= ended then
+	redis.call('HSET', key, 'ended_at', ts)
+end
+if page == '' then
+	return 0
+end
+local entryAt = tonumber(redis.call('HGET', key, 'entry_page_at'))
+if (entryAt and ts < entryAt) or (not entryAt and redis.call('HEXISTS', key, 'entry_page') == 0) then
+	redis.call('HSET', key, 'entry_page', page, 'entry_page_at', ts)
+end
+local exitAt = tonumber(redis.call('HGET', key, 'exit_page_at'))
+if not exitAt or ts >= exitAt then
+	redis.call('HSET', key, 'exit_page', page, 'exit_page_at', ts)
+end
+return 0
+`)
+
+// scrollDepthScript tracks the maximum scroll depth of each page visit in the
+// session hash. A page view (ARGV[3] -1) records the latest visit of its path
+// in 'scroll_visit:<path>'; a scroll raises 'max_scroll:<path>@<visit>' of
+// the path's latest visit, so repeated visits to a path keep their own
+// maximum. A scroll processed before its page view counts towards visit 0.
+//
+// KEYS[1] session hash; ARGV[1] event timestamp (ms), ARGV[2] page path,
+// ARGV[3] depth percent, -1 for a page view
+var scrollDepthScript = redis.NewScript(`
+local key, ts, path, depth = KEYS[1], tonumber(ARGV[1]), ARGV[2], tonumber(ARGV[3])
+local visitField = 'scroll_visit:' .. path
+local visit = redis.call('HGET', key, visitField)
+if depth < 0 then
+	if not visit or ts > tonumber(visit) then
+		redis.call('HSET', key, visitField, ARGV[1])
+	end
+	return 0
+end
+local field = 'max_scroll:' .. path .. '@' .. (visit or '0')
+local current = tonumber(redis.call('HGET', key, field))
+if not current or depth > current then
+	redis.call('HSET', key, field, depth)
+end
+return 0
+`)
+
 // Aggregator aggregates session data in Redis
 type Aggregator struct {
 	ch    *storage.ClickHouse
 	redis *redis.Client
+
+	// Track the maximum scroll depth of page views
+	scrollDepth bool
+
+	// Optional checkpointing of in-flight sessions to Kafka
+	checkpoint *Checkpointer
+
+	// Optional change data capture of flushed sessions
+	cdc *CDCPublisher
 }
 
 // NewAggregator creates a new session aggregator
@@ -32,6 +106,34 @@ func NewAggregator(ch *storage.ClickHouse, redisCfg config.RedisConfig) *Aggrega
 	}
 }
 
+// NewAggregatorWithCheckpoint creates a session aggregator that checkpoints
+// session state to a compacted Kafka topic
+func NewAggregatorWithCheckpoint(ch *storage.ClickHouse, redisCfg config.RedisConfig, kafkaCfg config.KafkaConfig, cfg config.SessionCheckpointConfig) *Aggregator {
+	a := NewAggregator(ch, redisCfg)
+	a.checkpoint = NewCheckpointer(kafkaCfg, cfg)
+
+	if err := a.checkpoint.EnsureTopic(); err != nil {
+		log.Warn().Err(err).Str("topic", a.checkpoint.topic).Msg("Failed to ensure session checkpoint topic")
+	}
+
+	if cfg.Interval > 0 {
+		go a.checkpointLoop(cfg.Interval)
+	}
+
+	return a
+}
+
+// EnableCDC publishes every flushed session to the "sessions_cdc" Kafka topic
+func (a *Aggregator) EnableCDC(kafkaCfg config.KafkaConfig) {
+	a.cdc = NewCDCPublisher(kafkaCfg)
+}
+
+// EnableScrollDepth tracks the maximum scroll depth of each page view from
+// scroll events, filled in by ScrollDepths
+func (a *Aggregator) EnableScrollDepth() {
+	a.scrollDepth = true
+}
+
 // UpdateSession updates session aggregation in Redis
 func (a *Aggregator) UpdateSession(ctx context.Context, event storage.EventRow) error {
 	if a.redis == nil {
@@ -43,47 +145,193 @@ func (a *Aggregator) UpdateSession(ctx context.Context, event storage.EventRow)
 	// Use Redis pipeline for efficiency
 	pipe := a.redis.Pipeline()
 
-	// Update session end time
-	pipe.HSet(ctx, key, "ended_at", event.Timestamp.UnixMilli())
-
 	// Increment event count
 	pipe.HIncrBy(ctx, key, "events_count", 1)
 
-	// Track based on event type (support both simple and proto enum names)
-	switch event.EventType {
-	case "page_view", "EVENT_TYPE_PAGE_VIEW":
+	// Track based on event type
+	var pageViewPath string
+	switch eventtype.Normalize(event.EventType) {
+	case eventtype.PageView:
 		pipe.HIncrBy(ctx, key, "page_views", 1)
-		pipe.HSetNX(ctx, key, "entry_page", event.PagePath)
-		pipe.HSet(ctx, key, "exit_page", event.PagePath)
+		pageViewPath = event.PagePath
+		if a.scrollDepth {
+			scrollDepthScript.Eval(ctx, pipe, []string{key}, event.Timestamp.UnixMilli(), event.PagePath, -1)
+		}
+
+	case eventtype.Scroll:
+		if depth, ok := scrollDepthPercent(event.Payload); ok && a.scrollDepth {
+			scrollDepthScript.Eval(ctx, pipe, []string{key}, event.Timestamp.UnixMilli(), event.PagePath, depth)
+		}
 
-	case "click", "EVENT_TYPE_CLICK":
+	case eventtype.Click:
 		pipe.HIncrBy(ctx, key, "click_count", 1)
 
-	case "js_error", "EVENT_TYPE_JS_ERROR":
+	case eventtype.JSError:
 		pipe.HIncrBy(ctx, key, "errors_count", 1)
 	}
 
+	// A single synthetic event marks the whole session
+	if event.IsSynthetic == 1 {
+		pipe.HSet(ctx, key, "is_synthetic", 1)
+	}
+	// Likewise a single event without analytics consent
+	if event.AnalyticsDenied == 1 {
+		pipe.HSet(ctx, key, "analytics_denied", 1)
+	}
+
 	// Set session metadata (only if not exists)
 	pipe.HSetNX(ctx, key, "project_id", event.ProjectID)
 	pipe.HSetNX(ctx, key, "user_id", event.UserID)
-	pipe.HSetNX(ctx, key, "started_at", event.Timestamp.UnixMilli())
 	pipe.HSetNX(ctx, key, "browser", event.Browser)
 	pipe.HSetNX(ctx, key, "os", event.OS)
 	pipe.HSetNX(ctx, key, "device_type", event.DeviceType)
+	pipe.HSetNX(ctx, key, "user_agent", event.UserAgent)
 	pipe.HSetNX(ctx, key, "country", event.Country)
 	pipe.HSetNX(ctx, key, "city", event.City)
 
+	// Start and end time, entry and exit page; Eval rather than EvalSha as
+	// a missing script can't be retried within a pipeline
+	sessionTimesScript.Eval(ctx, pipe, []string{key}, event.Timestamp.UnixMilli(), pageViewPath)
+
 	// Set TTL (1 hour)
 	pipe.Expire(ctx, key, time.Hour)
 
 	_, err := pipe.Exec(ctx)
 	if err != nil {
 		log.Error().Err(err).Str("session_id", event.SessionID).Msg("Failed to update session in Redis")
+		return err
 	}
-	return err
+
+	if a.checkpoint != nil {
+		if a.checkpoint.interval > 0 {
+			a.checkpoint.MarkDirty(event.SessionID)
+		} else {
+			a.checkpointSessions(ctx, []string{event.SessionID})
+		}
+	}
+	return nil
+}
+
+// scrollDepthPercent reads depth_percent of a scroll event payload, clamped to 0-100
+func scrollDepthPercent(payload string) (int, bool) {
+	var scroll struct {
+		DepthPercent *float64 `json:"depth_percent"`
+	}
+	if payload == "" || json.Unmarshal([]byte(payload), &scroll) != nil || scroll.DepthPercent == nil {
+		return 0, false
+	}
+	return int(min(max(*scroll.DepthPercent, 0), 100)), true
+}
+
+// ScrollDepths sets MaxScrollDepth of completed page views from the scroll
+// events of their visit; a no-op unless scroll depth tracking is enabled.
+// Page views of sessions already flushed from Redis keep 0.
+func (a *Aggregator) ScrollDepths(ctx context.Context, views []storage.PageViewRow) error {
+	if a.redis == nil || !a.scrollDepth || len(views) == 0 {
+		return nil
+	}
+
+	pipe := a.redis.Pipeline()
+	cmds := make([]*redis.StringCmd, len(views))
+	for i, view := range views {
+		field := "max_scroll:" + view.PagePath + "@" + strconv.FormatInt(view.Timestamp.UnixMilli(), 10)
+		cmds[i] = pipe.HGet(ctx, "session:"+view.SessionID, field)
+	}
+	if _, err := pipe.Exec(ctx); err != nil && !errors.Is(err, redis.Nil) {
+		return err
+	}
+
+	for i, cmd := range cmds {
+		if depth, err := cmd.Uint64(); err == nil {
+			views[i].MaxScrollDepth = uint8(min(depth, 100))
+		}
+	}
+	return nil
 }
 
-// FlushSession writes session data to ClickHouse
+func (a *Aggregator) checkpointLoop(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-a.checkpoint.done:
+			return
+		case <-ticker.C:
+			a.checkpointSessions(context.Background(), a.checkpoint.TakeDirty())
+		}
+	}
+}
+
+// checkpointSessions writes the current Redis state of the given sessions to Kafka
+func (a *Aggregator) checkpointSessions(ctx context.Context, sessionIDs []string) {
+	if len(sessionIDs) == 0 {
+		return
+	}
+
+	states := make(map[string]map[string]string, len(sessionIDs))
+	for _, sessionID := range sessionIDs {
+		data, err := a.redis.HGetAll(ctx, "session:"+sessionID).Result()
+		if err != nil || len(data) == 0 {
+			// Flushed sessions were already tombstoned
+			continue
+		}
+		states[sessionID] = data
+	}
+
+	if err := a.checkpoint.Write(ctx, states); err != nil {
+		log.Error().Err(err).Int("count", len(states)).Msg("Failed to checkpoint sessions")
+	}
+}
+
+// RestoreFromCheckpoint rebuilds in-flight session hashes missing from Redis
+// from the checkpoint topic. Call before consuming new events.
+func (a *Aggregator) RestoreFromCheckpoint(ctx context.Context) error {
+	if a.checkpoint == nil || a.redis == nil {
+		return nil
+	}
+
+	states, err := a.checkpoint.ReadAll(ctx)
+	if err != nil {
+		return err
+	}
+
+	restored := 0
+	for sessionID, state := range states {
+		key := "session:" + sessionID
+
+		// Sessions still in Redis are at least as fresh as their checkpoint
+		exists, err := a.redis.Exists(ctx, key).Result()
+		if err != nil {
+			return err
+		}
+		if exists > 0 {
+			continue
+		}
+
+		fields := make(map[string]interface{}, len(state))
+		for k, v := range state {
+			fields[k] = v
+		}
+
+		pipe := a.redis.Pipeline()
+		pipe.HSet(ctx, key, fields)
+		pipe.Expire(ctx, key, time.Hour)
+		if _, err := pipe.Exec(ctx); err != nil {
+			return err
+		}
+		restored++
+	}
+
+	log.Info().
+		Int("checkpointed", len(states)).
+		Int("restored", restored).
+		Msg("Restored sessions from checkpoint")
+	return nil
+}
+
+// FlushSession writes session data to ClickHouse, and with CDC enabled to the
+// sessions_cdc topic
 func (a *Aggregator) FlushSession(ctx context.Context, sessionID string) error {
 	if a.redis == nil || a.ch == nil {
 		return nil
@@ -109,9 +357,24 @@ func (a *Aggregator) FlushSession(ctx context.Context, sessionID string) error {
 		return err
 	}
 
+	// Publish before dropping the session from Redis so a failed publish is
+	// retried on the next flush; the ClickHouse upsert is idempotent
+	if a.cdc != nil {
+		if err := a.cdc.Publish(ctx, session); err != nil {
+			return err
+		}
+	}
+
 	// Delete from Redis after successful insert
 	a.redis.Del(ctx, key)
 
+	// Drop the session from the checkpoint topic
+	if a.checkpoint != nil {
+		if err := a.checkpoint.Write(ctx, map[string]map[string]string{sessionID: nil}); err != nil {
+			log.Error().Err(err).Str("session_id", sessionID).Msg("Failed to tombstone session checkpoint")
+		}
+	}
+
 	return nil
 }
 
@@ -148,6 +411,9 @@ func (a *Aggregator) parseSessionData(sessionID string, data map[string]string)
 	if v, ok := data["device_type"]; ok {
 		session.DeviceType = v
 	}
+	if v, ok := data["user_agent"]; ok {
+		session.UserAgent = v
+	}
 	if v, ok := data["country"]; ok {
 		session.Country = v
 	}
@@ -176,6 +442,13 @@ func (a *Aggregator) parseSessionData(sessionID string, data map[string]string)
 		session.ExitPage = v
 	}
 
+	if data["is_synthetic"] == "1" {
+		session.IsSynthetic = 1
+	}
+	if data["analytics_denied"] == "1" {
+		session.AnalyticsDenied = 1
+	}
+
 	// Determine if bounced (only 1 page view)
 	if session.PageViews <= 1 {
 		session.IsBounced = 1
@@ -184,30 +457,71 @@ func (a *Aggregator) parseSessionData(sessionID string, data map[string]string)
 	return session
 }
 
-// FlushAllSessions flushes all pending sessions to ClickHouse
-func (a *Aggregator) FlushAllSessions(ctx context.Context) error {
+// flushScanCount is the COUNT hint of the SCAN finding the sessions to flush
+const flushScanCount = 1000
+
+// FlushAllSessions flushes all pending sessions to ClickHouse using up to
+// workers concurrent flushes. Sessions are found with SCAN, so Redis keeps
+// serving meanwhile, and flushed as they are found. A session that fails to
+// flush is logged and left in Redis; the others are still flushed.
+func (a *Aggregator) FlushAllSessions(ctx context.Context, workers int) error {
 	if a.redis == nil {
 		return nil
 	}
+	if workers < 1 {
+		workers = 1
+	}
 
-	// Find all session keys
-	keys, err := a.redis.Keys(ctx, "session:*").Result()
-	if err != nil {
-		return err
+	start := time.Now()
+	sessionIDs := make(chan string, workers)
+	var failed atomic.Int64
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for sessionID := range sessionIDs {
+				if err := a.FlushSession(ctx, sessionID); err != nil {
+					failed.Add(1)
+					log.Error().Err(err).Str("session_id", sessionID).Msg("Failed to flush session")
+				}
+			}
+		}()
 	}
 
-	for _, key := range keys {
-		sessionID := key[8:] // Remove "session:" prefix
-		if err := a.FlushSession(ctx, sessionID); err != nil {
-			log.Error().Err(err).Str("session_id", sessionID).Msg("Failed to flush session")
+	// SCAN may return a key more than once
+	found := make(map[string]bool)
+	iter := a.redis.Scan(ctx, 0, "session:*", flushScanCount).Iterator()
+	for iter.Next(ctx) {
+		sessionID := strings.TrimPrefix(iter.Val(), "session:")
+		if found[sessionID] {
+			continue
 		}
+		found[sessionID] = true
+		sessionIDs <- sessionID
 	}
-
-	return nil
+	close(sessionIDs)
+	wg.Wait()
+
+	log.Info().
+		Int("sessions", len(found)).
+		Int64("failed", failed.Load()).
+		Int("workers", workers).
+		Dur("duration", time.Since(start)).
+		Msg("Flushed sessions")
+	return iter.Err()
 }
 
 // Close closes the aggregator
 func (a *Aggregator) Close() error {
+	if a.checkpoint != nil {
+		// Persist sessions updated since the last periodic checkpoint
+		a.checkpointSessions(context.Background(), a.checkpoint.TakeDirty())
+		a.checkpoint.Close()
+	}
+	if a.cdc != nil {
+		a.cdc.Close()
+	}
 	if a.redis != nil {
 		return a.redis.Close()
 	}
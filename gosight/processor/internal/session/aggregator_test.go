@@ -0,0 +1,148 @@
+package session
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+
+	"github.com/gosight/gosight/processor/internal/storage"
+)
+
+// testAggregator returns an aggregator on database 15 of the Redis at
+// REDIS_ADDR (default localhost:6379) that discards ClickHouse writes,
+// skipping the test when Redis is unreachable or already holds sessions there
+func testAggregator(tb testing.TB) *Aggregator {
+	tb.Helper()
+	addr := os.Getenv("REDIS_ADDR")
+	if addr == "" {
+		addr = "localhost:6379"
+	}
+	rdb := redis.NewClient(&redis.Options{Addr: addr, DB: 15, DialTimeout: 200 * time.Millisecond, MaxRetries: -1})
+	ctx := context.Background()
+	if err := rdb.Ping(ctx).Err(); err != nil {
+		rdb.Close()
+		tb.Skipf("Redis unavailable at %s: %v", addr, err)
+	}
+	if keys, _ := rdb.Keys(ctx, "session:*").Result(); len(keys) > 0 {
+		rdb.Close()
+		tb.Skipf("Redis database 15 at %s already holds %d sessions", addr, len(keys))
+	}
+	tb.Cleanup(func() { rdb.Close() })
+
+	ch := &storage.ClickHouse{}
+	ch.SetShadow("", true)
+	return &Aggregator{ch: ch, redis: rdb}
+}
+
+// seedSessions stores n sessions in Redis
+func seedSessions(tb testing.TB, a *Aggregator, n int) {
+	tb.Helper()
+	ctx := context.Background()
+	started := time.Now().Add(-time.Hour).UnixMilli()
+	pipe := a.redis.Pipeline()
+	for i := 0; i < n; i++ {
+		pipe.HSet(ctx, fmt.Sprintf("session:sess-%d", i),
+			"project_id", "proj",
+			"started_at", strconv.FormatInt(started, 10),
+			"ended_at", strconv.FormatInt(started+int64(i), 10),
+			"page_views", "1",
+		)
+	}
+	if _, err := pipe.Exec(ctx); err != nil {
+		tb.Fatal(err)
+	}
+}
+
+func TestFlushAllSessionsKeepsGoingPastFailures(t *testing.T) {
+	a := testAggregator(t)
+	ctx := context.Background()
+	seedSessions(t, a, 2000)
+
+	// Sessions stored with the wrong type fail to flush
+	broken := []string{"session:broken-1", "session:broken-2", "session:broken-3"}
+	for _, key := range broken {
+		if err := a.redis.Set(ctx, key, "not a hash", 0).Err(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	t.Cleanup(func() { a.redis.Del(ctx, broken...) })
+
+	if err := a.FlushAllSessions(ctx, 8); err != nil {
+		t.Fatal(err)
+	}
+
+	keys, err := a.redis.Keys(ctx, "session:*").Result()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(keys) != len(broken) {
+		t.Errorf("%d sessions left after the flush, want only the %d broken ones", len(keys), len(broken))
+	}
+}
+
+func BenchmarkFlushAllSessions(b *testing.B) {
+	for _, workers := range []int{1, 16} {
+		b.Run(fmt.Sprintf("workers=%d", workers), func(b *testing.B) {
+			a := testAggregator(b)
+			for i := 0; i < b.N; i++ {
+				b.StopTimer()
+				seedSessions(b, a, 1000)
+				b.StartTimer()
+				if err := a.FlushAllSessions(context.Background(), workers); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func TestUpdateSessionOrdersReorderedStart(t *testing.T) {
+	a := testAggregator(t)
+	ctx := context.Background()
+	sessionID := fmt.Sprintf("reordered-%d", time.Now().UnixNano())
+	t.Cleanup(func() { a.redis.Del(ctx, "session:"+sessionID) })
+
+	base := time.UnixMilli(1_700_000_000_000)
+	event := func(eventType, path string, offsetMs int64) storage.EventRow {
+		return storage.EventRow{
+			ProjectID: "proj",
+			SessionID: sessionID,
+			EventType: eventType,
+			PagePath:  path,
+			Timestamp: base.Add(time.Duration(offsetMs) * time.Millisecond),
+		}
+	}
+
+	// In processing order: the landing page view arrives after a click and
+	// the next page view, and the session's first event last
+	for _, e := range []storage.EventRow{
+		event("click", "/pricing", 2000),
+		event("page_view", "/pricing", 3000),
+		event("page_view", "/home", 1000),
+		event("click", "/home", 500),
+	} {
+		if err := a.UpdateSession(ctx, e); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	data, err := a.redis.HGetAll(ctx, "session:"+sessionID).Result()
+	if err != nil {
+		t.Fatal(err)
+	}
+	session := a.parseSessionData(sessionID, data)
+	if session.EntryPage != "/home" || session.ExitPage != "/pricing" {
+		t.Errorf("entry and exit page = %s, %s, want /home, /pricing", session.EntryPage, session.ExitPage)
+	}
+	if !session.StartedAt.Equal(base.Add(500*time.Millisecond)) || session.DurationMs != 2500 {
+		t.Errorf("started at %v for %dms, want the earliest event and 2500ms", session.StartedAt, session.DurationMs)
+	}
+	if session.PageViews != 2 || session.EventsCount != 4 {
+		t.Errorf("%d page views, %d events, want 2 and 4", session.PageViews, session.EventsCount)
+	}
+}
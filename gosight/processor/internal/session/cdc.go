@@ -0,0 +1,110 @@
+package session
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"github.com/segmentio/kafka-go"
+
+	"github.com/gosight/gosight/processor/internal/config"
+	"github.com/gosight/gosight/processor/internal/kafkaauth"
+	"github.com/gosight/gosight/processor/internal/storage"
+)
+
+// CDCPublisher publishes every flushed session row to the "sessions_cdc"
+// Kafka topic, keyed by session_id, for sink connectors loading sessions into
+// a warehouse. A session can be flushed more than once (e.g. it resumes after
+// the flush), so the downstream must upsert by session_id, keeping the record
+// with the latest flushed_at.
+type CDCPublisher struct {
+	topic  string
+	writer *kafka.Writer
+}
+
+// SessionRecord is the CDC message value: the full session row
+type SessionRecord struct {
+	SessionID   string    `json:"session_id"`
+	ProjectID   string    `json:"project_id"`
+	UserID      string    `json:"user_id"`
+	StartedAt   time.Time `json:"started_at"`
+	EndedAt     time.Time `json:"ended_at"`
+	DurationMs  uint64    `json:"duration_ms"`
+	Browser     string    `json:"browser"`
+	OS          string    `json:"os"`
+	DeviceType  string    `json:"device_type"`
+	Country     string    `json:"country"`
+	City        string    `json:"city"`
+	PageViews   uint32    `json:"page_views"`
+	EventsCount uint32    `json:"events_count"`
+	ErrorsCount uint32    `json:"errors_count"`
+	EntryPage   string    `json:"entry_page"`
+	ExitPage    string    `json:"exit_page"`
+	HasReplay   bool      `json:"has_replay"`
+	IsBounced   bool      `json:"is_bounced"`
+	IsSynthetic bool      `json:"is_synthetic"`
+	// Only essential events without user identifiers (no analytics consent)
+	AnalyticsDenied bool      `json:"analytics_denied"`
+	FlushedAt       time.Time `json:"flushed_at"` // orders updates of the same session
+}
+
+// NewCDCPublisher creates a publisher writing to kafka.topics.sessions_cdc
+func NewCDCPublisher(kafkaCfg config.KafkaConfig) *CDCPublisher {
+	topic := kafkaCfg.Topics["sessions_cdc"]
+	if topic == "" {
+		topic = "gosight.sessions.cdc"
+	}
+
+	return &CDCPublisher{
+		topic: topic,
+		writer: &kafka.Writer{
+			Addr:         kafka.TCP(kafkaCfg.Brokers...),
+			Transport:    kafkaauth.Transport(kafkaCfg),
+			Topic:        topic,
+			Balancer:     &kafka.Hash{}, // updates of a session stay in order on one partition
+			BatchTimeout: time.Millisecond * 10,
+			RequiredAcks: kafka.RequireAll,
+		},
+	}
+}
+
+// Publish writes the session row
+func (p *CDCPublisher) Publish(ctx context.Context, row storage.SessionRow) error {
+	data, err := json.Marshal(SessionRecord{
+		SessionID:   row.SessionID,
+		ProjectID:   row.ProjectID,
+		UserID:      row.UserID,
+		StartedAt:   row.StartedAt,
+		EndedAt:     row.EndedAt,
+		DurationMs:  row.DurationMs,
+		Browser:     row.Browser,
+		OS:          row.OS,
+		DeviceType:  row.DeviceType,
+		Country:     row.Country,
+		City:        row.City,
+		PageViews:   row.PageViews,
+		EventsCount: row.EventsCount,
+		ErrorsCount: row.ErrorsCount,
+		EntryPage:   row.EntryPage,
+		ExitPage:    row.ExitPage,
+		HasReplay:   row.HasReplay == 1,
+		IsBounced:   row.IsBounced == 1,
+		IsSynthetic: row.IsSynthetic == 1,
+
+		AnalyticsDenied: row.AnalyticsDenied == 1,
+		FlushedAt:       time.Now(),
+	})
+	if err != nil {
+		return err
+	}
+
+	return p.writer.WriteMessages(ctx, kafka.Message{
+		Key:   []byte(row.SessionID),
+		Value: data,
+	})
+}
+
+// Close flushes pending messages
+func (p *CDCPublisher) Close() error {
+	return p.writer.Close()
+}
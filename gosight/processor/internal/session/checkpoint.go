@@ -0,0 +1,207 @@
+package session
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"github.com/segmentio/kafka-go"
+
+	"github.com/gosight/gosight/processor/internal/config"
+	"github.com/gosight/gosight/processor/internal/kafkaauth"
+)
+
+// Checkpointer snapshots in-flight session hashes to a compacted Kafka topic
+// keyed by session_id, so they can be rebuilt in Redis after Redis loses them.
+// Completed sessions are written as tombstones so compaction drops them.
+type Checkpointer struct {
+	brokers  []string
+	dialer   *kafka.Dialer
+	topic    string
+	interval time.Duration
+	writer   *kafka.Writer
+
+	dirty map[string]struct{}
+	mu    sync.Mutex
+	done  chan struct{}
+}
+
+// NewCheckpointer creates a checkpointer; with a zero interval every session update is checkpointed
+func NewCheckpointer(kafkaCfg config.KafkaConfig, cfg config.SessionCheckpointConfig) *Checkpointer {
+	topic := kafkaCfg.Topics["sessions"]
+	if topic == "" {
+		topic = "gosight.sessions.checkpoint"
+	}
+
+	return &Checkpointer{
+		brokers:  kafkaCfg.Brokers,
+		dialer:   kafkaauth.Dialer(kafkaCfg),
+		topic:    topic,
+		interval: cfg.Interval,
+		writer: &kafka.Writer{
+			Addr:         kafka.TCP(kafkaCfg.Brokers...),
+			Transport:    kafkaauth.Transport(kafkaCfg),
+			Topic:        topic,
+			Balancer:     &kafka.Hash{}, // same session always lands on the same partition
+			BatchTimeout: time.Millisecond * 10,
+		},
+		dirty: make(map[string]struct{}),
+		done:  make(chan struct{}),
+	}
+}
+
+// EnsureTopic creates the checkpoint topic with log compaction if it does not exist
+func (c *Checkpointer) EnsureTopic() error {
+	conn, err := c.dialer.Dial("tcp", c.brokers[0])
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	controller, err := conn.Controller()
+	if err != nil {
+		return err
+	}
+	controllerConn, err := c.dialer.Dial("tcp", net.JoinHostPort(controller.Host, strconv.Itoa(controller.Port)))
+	if err != nil {
+		return err
+	}
+	defer controllerConn.Close()
+
+	err = controllerConn.CreateTopics(kafka.TopicConfig{
+		Topic:             c.topic,
+		NumPartitions:     3,
+		ReplicationFactor: 1,
+		ConfigEntries: []kafka.ConfigEntry{
+			{ConfigName: "cleanup.policy", ConfigValue: "compact"},
+		},
+	})
+	if errors.Is(err, kafka.TopicAlreadyExists) {
+		return nil
+	}
+	return err
+}
+
+// MarkDirty schedules a session for the next periodic checkpoint
+func (c *Checkpointer) MarkDirty(sessionID string) {
+	c.mu.Lock()
+	c.dirty[sessionID] = struct{}{}
+	c.mu.Unlock()
+}
+
+// TakeDirty returns and clears the sessions updated since the last checkpoint
+func (c *Checkpointer) TakeDirty() []string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	ids := make([]string, 0, len(c.dirty))
+	for id := range c.dirty {
+		ids = append(ids, id)
+	}
+	c.dirty = make(map[string]struct{})
+	return ids
+}
+
+// Write checkpoints session state; a nil state writes a tombstone
+func (c *Checkpointer) Write(ctx context.Context, states map[string]map[string]string) error {
+	msgs := make([]kafka.Message, 0, len(states))
+	for sessionID, state := range states {
+		msg := kafka.Message{Key: []byte(sessionID)}
+		if state != nil {
+			data, err := json.Marshal(state)
+			if err != nil {
+				return err
+			}
+			msg.Value = data
+		}
+		msgs = append(msgs, msg)
+	}
+	if len(msgs) == 0 {
+		return nil
+	}
+	return c.writer.WriteMessages(ctx, msgs...)
+}
+
+// ReadAll replays the checkpoint topic from the beginning and returns the
+// latest state of every session that has not been tombstoned
+func (c *Checkpointer) ReadAll(ctx context.Context) (map[string]map[string]string, error) {
+	conn, err := c.dialer.DialContext(ctx, "tcp", c.brokers[0])
+	if err != nil {
+		return nil, err
+	}
+	partitions, err := conn.ReadPartitions(c.topic)
+	conn.Close()
+	if err != nil {
+		return nil, err
+	}
+
+	states := make(map[string]map[string]string)
+	for _, p := range partitions {
+		if err := c.readPartition(ctx, p.ID, states); err != nil {
+			return nil, err
+		}
+	}
+	return states, nil
+}
+
+func (c *Checkpointer) readPartition(ctx context.Context, partition int, states map[string]map[string]string) error {
+	leader, err := c.dialer.DialLeader(ctx, "tcp", c.brokers[0], c.topic, partition)
+	if err != nil {
+		return err
+	}
+	first, last, err := leader.ReadOffsets()
+	leader.Close()
+	if err != nil {
+		return err
+	}
+	if first >= last {
+		return nil
+	}
+
+	reader := kafka.NewReader(kafka.ReaderConfig{
+		Brokers:   c.brokers,
+		Topic:     c.topic,
+		Partition: partition,
+		MaxBytes:  10e6, // 10MB
+		Dialer:    c.dialer,
+	})
+	defer reader.Close()
+
+	if err := reader.SetOffset(first); err != nil {
+		return err
+	}
+
+	for {
+		msg, err := reader.ReadMessage(ctx)
+		if err != nil {
+			return err
+		}
+
+		sessionID := string(msg.Key)
+		if msg.Value == nil {
+			delete(states, sessionID)
+		} else {
+			var state map[string]string
+			if err := json.Unmarshal(msg.Value, &state); err != nil {
+				log.Warn().Err(err).Str("session_id", sessionID).Msg("Skipping invalid session checkpoint")
+			} else {
+				states[sessionID] = state
+			}
+		}
+
+		if msg.Offset >= last-1 {
+			return nil
+		}
+	}
+}
+
+// Close stops the checkpoint writer
+func (c *Checkpointer) Close() error {
+	close(c.done)
+	return c.writer.Close()
+}
@@ -0,0 +1,91 @@
+package session
+
+import (
+	"context"
+	"hash/fnv"
+	"sync"
+
+	"github.com/gosight/gosight/processor/internal/config"
+	"github.com/gosight/gosight/processor/internal/storage"
+)
+
+// UpdatePool applies session updates on a fixed number of workers, bounding
+// the concurrent Redis pipelines. Each session's updates go to the same
+// worker, which keeps them in order (entry and exit page depend on it). A full
+// queue blocks Enqueue, slowing down consumption rather than piling up
+// goroutines.
+type UpdatePool struct {
+	agg    *Aggregator
+	update func(ctx context.Context, event storage.EventRow) error // agg.UpdateSession
+	queues []chan storage.EventRow
+	wg     sync.WaitGroup
+
+	mu     sync.RWMutex
+	closed bool
+}
+
+// NewUpdatePool starts cfg.Workers workers updating sessions of agg
+func NewUpdatePool(agg *Aggregator, cfg config.SessionUpdatesConfig) *UpdatePool {
+	p := &UpdatePool{
+		agg:    agg,
+		update: agg.UpdateSession,
+		queues: make([]chan storage.EventRow, cfg.Workers),
+	}
+
+	queueSize := max(cfg.QueueSize/cfg.Workers, 1)
+	for i := range p.queues {
+		p.queues[i] = make(chan storage.EventRow, queueSize)
+		p.wg.Add(1)
+		go p.work(p.queues[i])
+	}
+
+	return p
+}
+
+func (p *UpdatePool) work(queue <-chan storage.EventRow) {
+	defer p.wg.Done()
+	for event := range queue {
+		// Errors are logged by UpdateSession
+		p.update(context.Background(), event)
+	}
+}
+
+// Enqueue queues an update of the event's session. Updates enqueued after
+// Close are dropped.
+func (p *UpdatePool) Enqueue(event storage.EventRow) {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	if p.closed {
+		return
+	}
+	p.queues[p.workerFor(event)] <- event
+}
+
+func (p *UpdatePool) workerFor(event storage.EventRow) int {
+	h := fnv.New32a()
+	h.Write([]byte(event.SessionID))
+	return int(h.Sum32() % uint32(len(p.queues)))
+}
+
+// ScrollDepths sets MaxScrollDepth of completed page views (see
+// Aggregator.ScrollDepths)
+func (p *UpdatePool) ScrollDepths(ctx context.Context, views []storage.PageViewRow) error {
+	return p.agg.ScrollDepths(ctx, views)
+}
+
+// Close applies the queued updates and stops the workers
+func (p *UpdatePool) Close() {
+	p.mu.Lock()
+	if p.closed {
+		p.mu.Unlock()
+		return
+	}
+	p.closed = true
+	for _, queue := range p.queues {
+		close(queue)
+	}
+	p.mu.Unlock()
+
+	p.wg.Wait()
+}
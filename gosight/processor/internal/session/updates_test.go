@@ -0,0 +1,81 @@
+package session
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gosight/gosight/processor/internal/config"
+	"github.com/gosight/gosight/processor/internal/storage"
+)
+
+func TestUpdatePoolBoundsConcurrentUpdates(t *testing.T) {
+	const workers, sessions, events = 4, 50, 2000
+
+	var inFlight, maxInFlight atomic.Int64
+	var mu sync.Mutex
+	applied := make(map[string][]int)
+
+	p := NewUpdatePool(nil, config.SessionUpdatesConfig{Workers: workers, QueueSize: 64})
+	p.update = func(ctx context.Context, event storage.EventRow) error {
+		n := inFlight.Add(1)
+		defer inFlight.Add(-1)
+		for {
+			m := maxInFlight.Load()
+			if n <= m || maxInFlight.CompareAndSwap(m, n) {
+				break
+			}
+		}
+		time.Sleep(10 * time.Microsecond)
+
+		mu.Lock()
+		defer mu.Unlock()
+		seq, _ := strconv.Atoi(event.EventID)
+		applied[event.SessionID] = append(applied[event.SessionID], seq)
+		return nil
+	}
+
+	// A burst from many goroutines, as from the consumer's workers; each
+	// session's events come from one goroutine, in order
+	var wg sync.WaitGroup
+	for g := 0; g < 8; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			for i := 0; i < events; i++ {
+				if session := i % sessions; session%8 == g {
+					p.Enqueue(storage.EventRow{EventID: strconv.Itoa(i), SessionID: fmt.Sprintf("sess-%d", session)})
+				}
+			}
+		}(g)
+	}
+	wg.Wait()
+	p.Close()
+
+	if m := maxInFlight.Load(); m > workers {
+		t.Errorf("%d concurrent updates, want at most %d", m, workers)
+	}
+
+	total := 0
+	for sessionID, seqs := range applied {
+		total += len(seqs)
+		for i := 1; i < len(seqs); i++ {
+			if seqs[i] < seqs[i-1] {
+				t.Fatalf("%s updated out of order: %v", sessionID, seqs)
+			}
+		}
+	}
+	if total != events {
+		t.Errorf("applied %d updates, want %d", total, events)
+	}
+
+	// Updates after Close are dropped rather than blocking or panicking
+	p.Enqueue(storage.EventRow{SessionID: "late"})
+	if _, ok := applied["late"]; ok {
+		t.Error("update applied after Close")
+	}
+}
@@ -0,0 +1,64 @@
+package settings
+
+import (
+	"regexp"
+	"strings"
+
+	"github.com/rs/zerolog/log"
+)
+
+// regexPrefix marks a path pattern as a regular expression rather than a glob
+const regexPrefix = "re:"
+
+// compilePathPatterns compiles path_denylist / path_allowlist patterns. Globs
+// match the whole path: * matches within a segment, ** across segments and ?
+// a single character; "re:" patterns are regexes matched anywhere in the path
+// (anchor them as needed). Invalid patterns are skipped.
+func compilePathPatterns(projectID string, patterns []string) []*regexp.Regexp {
+	compiled := make([]*regexp.Regexp, 0, len(patterns))
+	for _, pattern := range patterns {
+		expr := globToRegexp(pattern)
+		if strings.HasPrefix(pattern, regexPrefix) {
+			expr = strings.TrimPrefix(pattern, regexPrefix)
+		}
+
+		re, err := regexp.Compile(expr)
+		if err != nil {
+			log.Warn().Err(err).Str("project_id", projectID).Str("pattern", pattern).Msg("Invalid path pattern in project settings, ignoring it")
+			continue
+		}
+		compiled = append(compiled, re)
+	}
+	return compiled
+}
+
+func globToRegexp(glob string) string {
+	var b strings.Builder
+	b.WriteString("^")
+	for i := 0; i < len(glob); i++ {
+		switch c := glob[i]; c {
+		case '*':
+			if i+1 < len(glob) && glob[i+1] == '*' {
+				b.WriteString(".*")
+				i++
+			} else {
+				b.WriteString("[^/]*")
+			}
+		case '?':
+			b.WriteString("[^/]")
+		default:
+			b.WriteString(regexp.QuoteMeta(string(c)))
+		}
+	}
+	b.WriteString("$")
+	return b.String()
+}
+
+func matchesAny(patterns []*regexp.Regexp, path string) bool {
+	for _, re := range patterns {
+		if re.MatchString(path) {
+			return true
+		}
+	}
+	return false
+}
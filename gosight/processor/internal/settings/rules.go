@@ -0,0 +1,100 @@
+package settings
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/rs/zerolog/log"
+
+	"github.com/gosight/gosight/processor/internal/eventtype"
+)
+
+// maxRuleWindowMs bounds the window of an insight rule, and so the events
+// tracked per session for it
+const maxRuleWindowMs = 60 * 60 * 1000
+
+// ruleTypePattern restricts insight types of rules to what built-in types look like
+var ruleTypePattern = regexp.MustCompile(`^[a-z][a-z0-9_]{0,63}$`)
+
+// InsightRule emits an insight of Type when a session has Count events of
+// EventType (custom events named EventName, when set) on a page matching Path
+// within WindowMs. Examples, as stored in project_settings.insight_rules:
+//
+//	// Three failed checkouts within a minute
+//	{"type": "checkout_failures", "event_type": "form_error", "path": "/checkout/**", "count": 3, "window_ms": 60000}
+//	// Any visit to the legacy dashboard
+//	{"type": "legacy_dashboard_visit", "event_type": "page_view", "path": "re:^/dashboard/v1(/|$)", "count": 1}
+//	// Five searches without results within two minutes
+//	{"type": "empty_searches", "event_type": "custom", "event_name": "search_no_results", "count": 5, "window_ms": 120000}
+type InsightRule struct {
+	Type      string `json:"type"`
+	EventType string `json:"event_type"`
+	EventName string `json:"event_name,omitempty"`
+	Path      string `json:"path,omitempty"` // glob or "re:" regex, as path_denylist; empty matches all pages
+	Count     int    `json:"count"`
+	WindowMs  int64  `json:"window_ms,omitempty"` // required when Count > 1
+
+	path *regexp.Regexp
+}
+
+// Validate checks a rule definition
+func (r InsightRule) Validate() error {
+	if !ruleTypePattern.MatchString(r.Type) {
+		return fmt.Errorf("invalid type %q (want lowercase letters, digits and underscores)", r.Type)
+	}
+	if eventtype.Normalize(r.EventType) == eventtype.Unknown {
+		return fmt.Errorf("unknown event_type %q", r.EventType)
+	}
+	if r.EventName != "" && eventtype.Normalize(r.EventType) != eventtype.Custom {
+		return fmt.Errorf("event_name requires event_type %q", eventtype.Custom)
+	}
+	if r.Count < 1 {
+		return fmt.Errorf("count must be at least 1")
+	}
+	if r.Count > 1 && (r.WindowMs <= 0 || r.WindowMs > maxRuleWindowMs) {
+		return fmt.Errorf("window_ms must be between 1 and %d", maxRuleWindowMs)
+	}
+	if expr, ok := strings.CutPrefix(r.Path, regexPrefix); ok {
+		if _, err := regexp.Compile(expr); err != nil {
+			return fmt.Errorf("invalid path: %w", err)
+		}
+	}
+	return nil
+}
+
+// Matches reports whether an event of the resolved type, custom event name and
+// page path counts toward the rule
+func (r InsightRule) Matches(eventType eventtype.Type, eventName, path string) bool {
+	if eventType != eventtype.Normalize(r.EventType) {
+		return false
+	}
+	if r.EventName != "" && r.EventName != eventName {
+		return false
+	}
+	return r.path == nil || r.path.MatchString(path)
+}
+
+// compileRules validates a project's insight rules and compiles their paths.
+// Invalid rules and rules repeating an earlier rule's type are skipped.
+func compileRules(projectID string, rules []InsightRule) []InsightRule {
+	compiled := make([]InsightRule, 0, len(rules))
+	seen := make(map[string]bool, len(rules))
+	for _, rule := range rules {
+		err := rule.Validate()
+		if err == nil && seen[rule.Type] {
+			err = fmt.Errorf("duplicate type %q", rule.Type)
+		}
+		if err != nil {
+			log.Warn().Err(err).Str("project_id", projectID).Str("rule", rule.Type).Msg("Invalid insight rule in project settings, ignoring it")
+			continue
+		}
+		seen[rule.Type] = true
+
+		if rule.Path != "" {
+			rule.path = compilePathPatterns(projectID, []string{rule.Path})[0]
+		}
+		compiled = append(compiled, rule)
+	}
+	return compiled
+}
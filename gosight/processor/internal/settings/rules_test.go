@@ -0,0 +1,67 @@
+package settings
+
+import (
+	"testing"
+
+	"github.com/gosight/gosight/processor/internal/eventtype"
+)
+
+func TestInsightRuleValidate(t *testing.T) {
+	valid := []InsightRule{
+		{Type: "checkout_failures", EventType: "form_error", Path: "/checkout/**", Count: 3, WindowMs: 60000},
+		{Type: "legacy_dashboard_visit", EventType: "page_view", Path: "re:^/dashboard/v1(/|$)", Count: 1},
+		{Type: "empty_searches", EventType: "custom", EventName: "search_no_results", Count: 5, WindowMs: 120000},
+	}
+	for _, rule := range valid {
+		if err := rule.Validate(); err != nil {
+			t.Errorf("%s: %v", rule.Type, err)
+		}
+	}
+
+	invalid := map[string]InsightRule{
+		"uppercase type":       {Type: "Checkout", EventType: "click", Count: 1},
+		"empty type":           {EventType: "click", Count: 1},
+		"unknown event type":   {Type: "x", EventType: "hover", Count: 1},
+		"event name on click":  {Type: "x", EventType: "click", EventName: "buy", Count: 1},
+		"zero count":           {Type: "x", EventType: "click"},
+		"count without window": {Type: "x", EventType: "click", Count: 2},
+		"window over an hour":  {Type: "x", EventType: "click", Count: 2, WindowMs: 2 * maxRuleWindowMs},
+		"invalid path regex":   {Type: "x", EventType: "click", Path: "re:(", Count: 1},
+	}
+	for name, rule := range invalid {
+		if err := rule.Validate(); err == nil {
+			t.Errorf("%s accepted", name)
+		}
+	}
+}
+
+func TestCompileRules(t *testing.T) {
+	rules := compileRules("proj", []InsightRule{
+		{Type: "checkout_clicks", EventType: "click", Path: "/checkout/*", Count: 2, WindowMs: 1000},
+		{Type: "checkout_clicks", EventType: "page_view", Count: 1}, // duplicate type
+		{Type: "bad", EventType: "hover", Count: 1},
+		{Type: "any_error", EventType: "js_error", Count: 1},
+	})
+	if len(rules) != 2 || rules[0].Type != "checkout_clicks" || rules[1].Type != "any_error" {
+		t.Fatalf("compiled %+v, want the first checkout_clicks and any_error", rules)
+	}
+
+	tests := []struct {
+		eventType eventtype.Type
+		path      string
+		want      bool
+	}{
+		{eventtype.Click, "/checkout/payment", true},
+		{eventtype.Click, "/checkout/payment/card", false},
+		{eventtype.Click, "/cart", false},
+		{eventtype.PageView, "/checkout/payment", false},
+	}
+	for _, tt := range tests {
+		if got := rules[0].Matches(tt.eventType, "", tt.path); got != tt.want {
+			t.Errorf("Matches(%s, %s) = %v, want %v", tt.eventType, tt.path, got, tt.want)
+		}
+	}
+	if !rules[1].Matches(eventtype.JSError, "", "/anything") {
+		t.Error("rule without a path doesn't match every page")
+	}
+}
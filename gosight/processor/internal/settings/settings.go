@@ -0,0 +1,238 @@
+package settings
+
+import (
+	"context"
+	"errors"
+	"maps"
+	"regexp"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/rs/zerolog/log"
+
+	"github.com/gosight/gosight/processor/internal/config"
+)
+
+// ProjectSettings are a project's overrides of the service config, from the
+// project_settings Postgres table. Zero values leave the config in effect, so
+// projects without a row behave as configured.
+type ProjectSettings struct {
+	// Fraction of sessions whose replay is kept (0-1); nil for replay_sampling.sample_rate
+	ReplaySampleRate *float64
+	// Insight types not stored or alerted for the project
+	DisabledInsights []string
+	// Page path patterns excluded from storage and insights (see PathExcluded)
+	PathDenylist  []string
+	PathAllowlist []string
+	// Days of data kept in ClickHouse; nil for the table TTLs
+	RetentionDays *int
+	// Custom insights emitted by the insight processor
+	InsightRules []InsightRule
+
+	denyPaths  []*regexp.Regexp
+	allowPaths []*regexp.Regexp
+}
+
+// InsightEnabled reports whether insights of the type are kept for the project
+func (s ProjectSettings) InsightEnabled(insightType string) bool {
+	return !slices.Contains(s.DisabledInsights, insightType)
+}
+
+// PathExcluded reports whether events of a page path are dropped for the
+// project: the path matches a denylist pattern or, with an allowlist, none of
+// the allowlist patterns
+func (s ProjectSettings) PathExcluded(path string) bool {
+	if matchesAny(s.denyPaths, path) {
+		return true
+	}
+	return len(s.allowPaths) > 0 && !matchesAny(s.allowPaths, path)
+}
+
+// compile compiles the path patterns and insight rules of a project's settings
+func (s *ProjectSettings) compile(projectID string) {
+	s.denyPaths = compilePathPatterns(projectID, s.PathDenylist)
+	s.allowPaths = compilePathPatterns(projectID, s.PathAllowlist)
+	s.InsightRules = compileRules(projectID, s.InsightRules)
+}
+
+// notifyChannel is notified with the project ID on every change to
+// project_settings (see the trigger in init-postgres.sql)
+const notifyChannel = "project_settings"
+
+// Loader caches project_settings in memory. The whole table is reloaded every
+// refresh interval; in between, a project's row is reloaded as soon as its
+// change is notified, so edits apply within seconds and a missed notification
+// is caught up by the next refresh.
+type Loader struct {
+	db       *pgxpool.Pool
+	interval time.Duration
+
+	settings map[string]ProjectSettings // project ID -> settings
+	mu       sync.RWMutex
+
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+// NewLoader loads the project settings and keeps them up to date until Close.
+// When Postgres is unreachable the config applies until a refresh succeeds.
+func NewLoader(db *pgxpool.Pool, cfg config.ProjectSettingsConfig) *Loader {
+	ctx, cancel := context.WithCancel(context.Background())
+	l := &Loader{
+		db:       db,
+		interval: cfg.RefreshInterval,
+		settings: make(map[string]ProjectSettings),
+		cancel:   cancel,
+	}
+
+	if err := l.loadAll(ctx); err != nil {
+		log.Warn().Err(err).Msg("Failed to load project settings, using config until the next refresh")
+	}
+
+	l.wg.Add(2)
+	go l.refreshLoop(ctx)
+	go l.listenLoop(ctx)
+
+	return l
+}
+
+// Get returns a project's settings
+func (l *Loader) Get(projectID string) ProjectSettings {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	return l.settings[projectID]
+}
+
+// All returns the settings of every project with a project_settings row
+func (l *Loader) All() map[string]ProjectSettings {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	return maps.Clone(l.settings)
+}
+
+// Close stops refreshing the settings; the last loaded ones stay available
+func (l *Loader) Close() {
+	l.cancel()
+	l.wg.Wait()
+}
+
+func (l *Loader) refreshLoop(ctx context.Context) {
+	defer l.wg.Done()
+
+	ticker := time.NewTicker(l.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := l.loadAll(ctx); err != nil && ctx.Err() == nil {
+				log.Warn().Err(err).Msg("Failed to refresh project settings")
+			}
+		}
+	}
+}
+
+func (l *Loader) listenLoop(ctx context.Context) {
+	defer l.wg.Done()
+
+	for {
+		err := l.listen(ctx)
+		if ctx.Err() != nil {
+			return
+		}
+		log.Warn().Err(err).Msg("Project settings notifications interrupted, retrying")
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(5 * time.Second):
+		}
+	}
+}
+
+// listen reloads a project's settings on each change notification until the
+// connection fails or ctx is done
+func (l *Loader) listen(ctx context.Context) error {
+	conn, err := l.db.Acquire(ctx)
+	if err != nil {
+		return err
+	}
+	// The connection is subscribed to the channel; don't return it to the pool
+	defer conn.Hijack().Close(context.Background())
+
+	if _, err := conn.Exec(ctx, "LISTEN "+notifyChannel); err != nil {
+		return err
+	}
+	// Changes made while no connection was listening
+	if err := l.loadAll(ctx); err != nil {
+		return err
+	}
+
+	for {
+		n, err := conn.Conn().WaitForNotification(ctx)
+		if err != nil {
+			return err
+		}
+		if err := l.loadProject(ctx, n.Payload); err != nil {
+			log.Warn().Err(err).Str("project_id", n.Payload).Msg("Failed to reload project settings")
+		}
+	}
+}
+
+const selectSettings = `
+	SELECT project_id::text, replay_sample_rate, disabled_insights, path_denylist, path_allowlist, retention_days, insight_rules
+	FROM project_settings
+`
+
+// loadAll replaces the cached settings with the whole table
+func (l *Loader) loadAll(ctx context.Context) error {
+	rows, err := l.db.Query(ctx, selectSettings)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	settings := make(map[string]ProjectSettings)
+	for rows.Next() {
+		var projectID string
+		var s ProjectSettings
+		if err := rows.Scan(&projectID, &s.ReplaySampleRate, &s.DisabledInsights, &s.PathDenylist, &s.PathAllowlist, &s.RetentionDays, &s.InsightRules); err != nil {
+			return err
+		}
+		s.compile(projectID)
+		settings[projectID] = s
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	l.mu.Lock()
+	l.settings = settings
+	l.mu.Unlock()
+	return nil
+}
+
+// loadProject reloads a project's settings, dropping them when its row was deleted
+func (l *Loader) loadProject(ctx context.Context, projectID string) error {
+	var s ProjectSettings
+	err := l.db.QueryRow(ctx, selectSettings+" WHERE project_id::text = $1", projectID).
+		Scan(&projectID, &s.ReplaySampleRate, &s.DisabledInsights, &s.PathDenylist, &s.PathAllowlist, &s.RetentionDays, &s.InsightRules)
+	if err != nil && !errors.Is(err, pgx.ErrNoRows) {
+		return err
+	}
+	s.compile(projectID)
+
+	l.mu.Lock()
+	if err == nil {
+		l.settings[projectID] = s
+	} else {
+		delete(l.settings, projectID)
+	}
+	l.mu.Unlock()
+	return nil
+}
@@ -1,8 +1,19 @@
 package storage
 
 import (
+	"bytes"
+	"compress/gzip"
 	"context"
+	"encoding/base64"
 	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"math"
+	"slices"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/ClickHouse/clickhouse-go/v2"
@@ -10,10 +21,41 @@ import (
 	"github.com/google/uuid"
 
 	"github.com/gosight/gosight/processor/internal/config"
+	"github.com/gosight/gosight/processor/internal/eventtype"
 )
 
 type ClickHouse struct {
 	conn driver.Conn
+
+	compressPayload    bool
+	frustrationWeights map[string]float64
+	timestampSource    string // see SetTimestampSource
+
+	// Event type -> category of events stored in events_<category> (see
+	// SetEventCategories); empty keeps all events in events
+	eventCategories map[eventtype.Type]string
+	categoryTables  []string
+
+	// Shadow deployments (see SetShadow)
+	tablePrefix string
+	discard     bool
+}
+
+// DefaultFrustrationWeights are the points each insight adds to a session's
+// frustration score at full confidence
+var DefaultFrustrationWeights = map[string]float64{
+	"rage_click":                   15,
+	"dead_click":                   5,
+	"error_click":                  20,
+	"u_turn":                       8,
+	"slow_page":                    10,
+	"slow_page_caused_abandonment": 20,
+	"thrashed_cursor":              5,
+	"rage_scroll":                  5,
+	"form_retry":                   15,
+	"reload_loop":                  10,
+	"error_page":                   10,
+	"long_task":                    8,
 }
 
 // EventRow represents a row in the events table
@@ -40,6 +82,39 @@ type EventRow struct {
 	Country        string
 	City           string
 	Payload        string
+
+	// Name of a custom event, also kept outside the payload so metrics can
+	// count custom events whose payload is compressed
+	CustomEventName string
+
+	// Network context sent by the SDK in the page object (0 / "" if not sent)
+	DevicePixelRatio float32
+	ConnectionType   string // e.g. 4g, slow-2g, wifi
+
+	// Raw enrichment inputs, kept so enrichment can be backfilled
+	ClientIP  string
+	UserAgent string
+
+	// The timestamp not chosen by storage.timestamp_source (server or client)
+	SecondaryTimestamp time.Time
+
+	// Ingestor receive time (zero if unknown); InsertEvents sets ProcessingLagMs
+	// to the time from it to the insert
+	ServerTimestamp time.Time
+	ProcessingLagMs int64
+
+	// 1 for QA / synthetic monitoring traffic, excluded from reads by default
+	IsSynthetic uint8
+
+	// Anonymous visitor ID, stable across a visitor's sessions ("" if unknown)
+	AnonymousID string
+
+	// 1 when the user didn't consent to analytics (not stored; see
+	// consent.essential_types)
+	AnalyticsDenied uint8
+
+	// Payload decoded from JSON, only set on rows read back from ClickHouse
+	PayloadData map[string]interface{}
 }
 
 // SessionRow represents a row in the sessions table
@@ -62,6 +137,13 @@ type SessionRow struct {
 	ExitPage     string
 	HasReplay    uint8
 	IsBounced    uint8
+	IsSynthetic  uint8 // any of its events was synthetic
+	// Any of its events lacked analytics consent: only essential events were
+	// kept, without user identifiers
+	AnalyticsDenied uint8
+
+	// Raw User-Agent of its first event, with enrichment.store_raw_user_agent
+	UserAgent string
 }
 
 // WebVitalsRow represents a row in the web_vitals table
@@ -79,6 +161,24 @@ type WebVitalsRow struct {
 	INP        *float64
 	DeviceType string
 	Country    string
+
+	DevicePixelRatio float32
+	ConnectionType   string
+}
+
+// Quantiles holds p50/p75/p95 of a single metric
+type Quantiles struct {
+	P50 float64
+	P75 float64
+	P95 float64
+}
+
+// Percentiles holds web vitals quantiles for a page over a time range
+type Percentiles struct {
+	Samples uint64
+	LCP     Quantiles
+	INP     Quantiles
+	CLS     Quantiles
 }
 
 // ErrorRow represents a row in the errors table
@@ -96,6 +196,11 @@ type ErrorRow struct {
 	PagePath  string
 	Browser   string
 	OS        string
+
+	// Identifies occurrences of the same error (see transformer.ErrorFingerprint)
+	Fingerprint string
+	// Occurrences the row stands for when errors are grouped or sampled
+	OccurrenceCount uint32
 }
 
 // PageViewRow represents a row in the page_views table
@@ -108,10 +213,114 @@ type PageViewRow struct {
 	PageTitle      string
 	Referrer       string
 	Timestamp      time.Time
-	TimeOnPageMs   uint64
+	TimeOnPageMs   uint64 // Active time, excluding time the tab was hidden
 	MaxScrollDepth uint8
 	DeviceType     string
 	Country        string
+
+	RawTimeOnPageMs uint64 // Wall clock time, including time the tab was hidden
+}
+
+// LongTaskRow represents a row in the long_tasks table: a task that blocked
+// the page's main thread for over 50ms
+type LongTaskRow struct {
+	ProjectID   string
+	SessionID   string
+	PageURL     string
+	PagePath    string
+	Timestamp   time.Time
+	DurationMs  float64
+	Attribution string // culprit container, e.g. "iframe https://ads.example.com"; empty for the page itself
+	DeviceType  string
+}
+
+// AggregateMetricRow represents a row in the aggregate_metrics table: a value
+// pushed pre-aggregated by a server-side integration (e.g. 120 checkout_completed
+// in the minute starting at BucketStart) instead of as individual events
+type AggregateMetricRow struct {
+	ProjectID     string
+	MetricName    string
+	BucketStart   time.Time
+	BucketSeconds uint32
+	Dimensions    map[string]string
+	Value         float64
+	IsSynthetic   uint8
+}
+
+// MetricPoint is the value of a metric in one time bucket
+type MetricPoint struct {
+	BucketStart time.Time
+	Value       float64
+}
+
+// ReplayChunkRow represents a row in the replay_chunks table
+type ReplayChunkRow struct {
+	ProjectID       string
+	SessionID       string
+	ChunkIndex      uint32
+	TimestampStart  time.Time
+	TimestampEnd    time.Time
+	Data            []byte // raw rrweb events, gzipped and base64 encoded on insert
+	HasFullSnapshot uint8
+}
+
+// maxMissingChunks bounds ReplayManifest.MissingChunks, so a bogus chunk index
+// can't blow up a manifest
+const maxMissingChunks = 1000
+
+// ReplayManifest describes the stored replay of a session so a player can fetch
+// its chunks in order. Chunks are listed by index whatever order they arrived
+// in; MissingChunks are the indices below the highest one received that never
+// arrived (at most maxMissingChunks), which a player has to skip.
+type ReplayManifest struct {
+	ProjectID      string
+	SessionID      string
+	ChunkIndices   []uint32 // ascending, without duplicates
+	MissingChunks  []uint32 // ascending
+	SnapshotChunks []uint32 // chunks with a full snapshot, ascending
+	StartTime      time.Time
+	EndTime        time.Time
+	Finalized      bool // the session ended, no more chunks are expected
+	UpdatedAt      time.Time
+}
+
+// HasFullSnapshot reports whether any chunk has a full snapshot; playback can
+// only start from one
+func (m *ReplayManifest) HasFullSnapshot() bool {
+	return len(m.SnapshotChunks) > 0
+}
+
+// Duration is the time covered by the chunks received
+func (m *ReplayManifest) Duration() time.Duration {
+	return m.EndTime.Sub(m.StartTime)
+}
+
+// AddChunk records a received chunk. A chunk index received again (a client
+// retry) is listed once.
+func (m *ReplayManifest) AddChunk(chunk ReplayChunkRow) {
+	if i, found := slices.BinarySearch(m.ChunkIndices, chunk.ChunkIndex); !found {
+		m.ChunkIndices = slices.Insert(m.ChunkIndices, i, chunk.ChunkIndex)
+	}
+	if chunk.HasFullSnapshot == 1 {
+		if i, found := slices.BinarySearch(m.SnapshotChunks, chunk.ChunkIndex); !found {
+			m.SnapshotChunks = slices.Insert(m.SnapshotChunks, i, chunk.ChunkIndex)
+		}
+	}
+	if m.StartTime.IsZero() || chunk.TimestampStart.Before(m.StartTime) {
+		m.StartTime = chunk.TimestampStart
+	}
+	if chunk.TimestampEnd.After(m.EndTime) {
+		m.EndTime = chunk.TimestampEnd
+	}
+
+	m.MissingChunks = m.MissingChunks[:0]
+	next := uint32(0)
+	for _, index := range m.ChunkIndices {
+		for ; next < index && len(m.MissingChunks) < maxMissingChunks; next++ {
+			m.MissingChunks = append(m.MissingChunks, next)
+		}
+		next = index + 1
+	}
 }
 
 // InsightRow represents a row in the insights table
@@ -128,6 +337,73 @@ type InsightRow struct {
 	TargetSelector  string
 	Details         map[string]interface{}
 	RelatedEventIDs []string
+
+	// TargetSelector with generated class names and positions stripped, for grouping
+	NormalizedSelector string
+
+	// Common Details fields in typed columns (see insightDetailColumns), nil when
+	// the insight type does not have them
+	ClickCount       *uint32
+	LoadTimeMs       *float64
+	DirectionChanges *uint32
+	TimeAwayMs       *int64
+
+	// How strongly the detector's signal matched, from 0 to 1
+	Confidence float32
+}
+
+// insightDetailColumns lists, per insight type, the Details fields copied into
+// typed columns so they can be queried without JSON extraction. Details keeps
+// every field, including these.
+var insightDetailColumns = map[string][]string{
+	"rage_click":      {"click_count"},
+	"slow_page":       {"load_time_ms"},
+	"thrashed_cursor": {"direction_changes"},
+	"u_turn":          {"time_away_ms"},
+}
+
+// ProjectDetails fills the typed detail columns from Details according to the insight type
+func (r *InsightRow) ProjectDetails() {
+	for _, field := range insightDetailColumns[r.InsightType] {
+		v, ok := toFloat64(r.Details[field])
+		if !ok {
+			continue
+		}
+		switch field {
+		case "click_count":
+			n := uint32(v)
+			r.ClickCount = &n
+		case "load_time_ms":
+			r.LoadTimeMs = &v
+		case "direction_changes":
+			n := uint32(v)
+			r.DirectionChanges = &n
+		case "time_away_ms":
+			n := int64(v)
+			r.TimeAwayMs = &n
+		}
+	}
+}
+
+// toFloat64 converts a numeric detail value, as set by detectors or decoded from JSON
+func toFloat64(v interface{}) (float64, bool) {
+	switch n := v.(type) {
+	case int:
+		return float64(n), true
+	case int32:
+		return float64(n), true
+	case int64:
+		return float64(n), true
+	case uint32:
+		return float64(n), true
+	case uint64:
+		return float64(n), true
+	case float32:
+		return float64(n), true
+	case float64:
+		return n, true
+	}
+	return 0, false
 }
 
 func NewClickHouse(cfg config.ClickHouseConfig) (*ClickHouse, error) {
@@ -150,34 +426,106 @@ func NewClickHouse(cfg config.ClickHouseConfig) (*ClickHouse, error) {
 		return nil, err
 	}
 
-	return &ClickHouse{conn: conn}, nil
+	return &ClickHouse{
+		conn:               conn,
+		frustrationWeights: DefaultFrustrationWeights,
+	}, nil
 }
 
+// InsertEvents inserts the events into their event tables, one insert per
+// table. The inserts are not atomic: when some fail, the others are kept and
+// an *EventsInsertError tells which events to insert again.
 func (c *ClickHouse) InsertEvents(ctx context.Context, events []EventRow) error {
-	if len(events) == 0 {
+	if len(events) == 0 || c.discard {
+		return nil
+	}
+	if len(c.eventCategories) == 0 {
+		return c.insertEvents(ctx, "events", events)
+	}
+
+	byTable := make(map[string][]EventRow)
+	for _, e := range events {
+		table := c.eventTable(e.EventType)
+		byTable[table] = append(byTable[table], e)
+	}
+
+	var insertErr EventsInsertError
+	var errs []error
+	for _, table := range slices.Sorted(maps.Keys(byTable)) {
+		rows := byTable[table]
+		if err := c.insertEvents(ctx, table, rows); err != nil {
+			errs = append(errs, fmt.Errorf("insert into %s: %w", c.table(table), err))
+			insertErr.Unsaved = append(insertErr.Unsaved, rows...)
+			continue
+		}
+		insertErr.Persisted = append(insertErr.Persisted, table)
+	}
+	if len(errs) == 0 {
 		return nil
 	}
+	insertErr.Err = errors.Join(errs...)
+	return &insertErr
+}
+
+// EventsInsertError is returned by InsertEvents when the events of some tables
+// were not inserted. The events of the Persisted tables were, so a retry must
+// insert only the Unsaved events or it stores the others twice.
+type EventsInsertError struct {
+	Persisted []string
+	Unsaved   []EventRow
+	Err       error
+}
+
+func (e *EventsInsertError) Error() string {
+	if len(e.Persisted) == 0 {
+		return e.Err.Error()
+	}
+	return fmt.Sprintf("%v (inserted into %s)", e.Err, strings.Join(e.Persisted, ", "))
+}
 
+func (e *EventsInsertError) Unwrap() error {
+	return e.Err
+}
+
+func (c *ClickHouse) insertEvents(ctx context.Context, table string, events []EventRow) error {
 	batch, err := c.conn.PrepareBatch(ctx, `
-		INSERT INTO events (
+		INSERT INTO `+c.table(table)+` (
 			event_id, project_id, session_id, user_id, event_type, timestamp,
 			page_url, page_path, page_title, referrer,
 			browser, browser_version, os, os_version, device_type,
 			screen_width, screen_height, viewport_width, viewport_height,
-			country, city, payload
+			country, city, payload, client_ip, user_agent, secondary_timestamp,
+			payload_compressed, processing_lag_ms, is_synthetic,
+			device_pixel_ratio, connection_type, anonymous_id, custom_event_name
 		)
 	`)
 	if err != nil {
 		return err
 	}
 
-	for _, e := range events {
+	insertTime := time.Now()
+	for i := range events {
+		e := &events[i]
+		if !e.ServerTimestamp.IsZero() {
+			e.ProcessingLagMs = insertTime.Sub(e.ServerTimestamp).Milliseconds()
+		}
+
+		payload, compressed := e.Payload, ""
+		if c.compressPayload && payload != "" {
+			if compressed, err = gzipPayload(payload); err != nil {
+				return err
+			}
+			payload = ""
+		}
+
 		err := batch.Append(
 			e.EventID, e.ProjectID, e.SessionID, e.UserID, e.EventType, e.Timestamp,
 			e.PageURL, e.PagePath, e.PageTitle, e.Referrer,
 			e.Browser, e.BrowserVersion, e.OS, e.OSVersion, e.DeviceType,
 			e.ScreenWidth, e.ScreenHeight, e.ViewportWidth, e.ViewportHeight,
-			e.Country, e.City, e.Payload,
+			e.Country, e.City, payload, e.ClientIP, e.UserAgent, e.SecondaryTimestamp,
+			compressed, e.ProcessingLagMs, e.IsSynthetic,
+			e.DevicePixelRatio, e.ConnectionType, e.AnonymousID, e.CustomEventName,
 		)
 		if err != nil {
 			return err
@@ -188,15 +536,15 @@ func (c *ClickHouse) InsertEvents(ctx context.Context, events []EventRow) error
 }
 
 func (c *ClickHouse) InsertWebVitals(ctx context.Context, vitals []WebVitalsRow) error {
-	if len(vitals) == 0 {
+	if len(vitals) == 0 || c.discard {
 		return nil
 	}
 
 	batch, err := c.conn.PrepareBatch(ctx, `
-		INSERT INTO web_vitals (
+		INSERT INTO `+c.table("web_vitals")+` (
 			project_id, session_id, page_url, page_path, timestamp,
 			lcp, fid, cls, ttfb, fcp, inp,
-			device_type, country
+			device_type, country, device_pixel_ratio, connection_type
 		)
 	`)
 	if err != nil {
@@ -207,7 +555,35 @@ func (c *ClickHouse) InsertWebVitals(ctx context.Context, vitals []WebVitalsRow)
 		err := batch.Append(
 			v.ProjectID, v.SessionID, v.PageURL, v.PagePath, v.Timestamp,
 			v.LCP, v.FID, v.CLS, v.TTFB, v.FCP, v.INP,
-			v.DeviceType, v.Country,
+			v.DeviceType, v.Country, v.DevicePixelRatio, v.ConnectionType,
+		)
+		if err != nil {
+			return err
+		}
+	}
+
+	return batch.Send()
+}
+
+func (c *ClickHouse) InsertLongTasks(ctx context.Context, tasks []LongTaskRow) error {
+	if len(tasks) == 0 || c.discard {
+		return nil
+	}
+
+	batch, err := c.conn.PrepareBatch(ctx, `
+		INSERT INTO `+c.table("long_tasks")+` (
+			project_id, session_id, page_url, page_path, timestamp,
+			duration_ms, attribution, device_type
+		)
+	`)
+	if err != nil {
+		return err
+	}
+
+	for _, t := range tasks {
+		err := batch.Append(
+			t.ProjectID, t.SessionID, t.PageURL, t.PagePath, t.Timestamp,
+			t.DurationMs, t.Attribution, t.DeviceType,
 		)
 		if err != nil {
 			return err
@@ -218,15 +594,16 @@ func (c *ClickHouse) InsertWebVitals(ctx context.Context, vitals []WebVitalsRow)
 }
 
 func (c *ClickHouse) InsertErrors(ctx context.Context, errors []ErrorRow) error {
-	if len(errors) == 0 {
+	if len(errors) == 0 || c.discard {
 		return nil
 	}
 
 	batch, err := c.conn.PrepareBatch(ctx, `
-		INSERT INTO errors (
+		INSERT INTO `+c.table("errors")+` (
 			project_id, session_id, timestamp,
 			error_type, message, stack, source, line, col,
-			page_url, page_path, browser, os
+			page_url, page_path, browser, os,
+			fingerprint, occurrence_count
 		)
 	`)
 	if err != nil {
@@ -238,6 +615,7 @@ func (c *ClickHouse) InsertErrors(ctx context.Context, errors []ErrorRow) error
 			e.ProjectID, e.SessionID, e.Timestamp,
 			e.ErrorType, e.Message, e.Stack, e.Source, e.Line, e.Col,
 			e.PageURL, e.PagePath, e.Browser, e.OS,
+			e.Fingerprint, max(e.OccurrenceCount, 1),
 		)
 		if err != nil {
 			return err
@@ -248,16 +626,16 @@ func (c *ClickHouse) InsertErrors(ctx context.Context, errors []ErrorRow) error
 }
 
 func (c *ClickHouse) InsertPageViews(ctx context.Context, pageViews []PageViewRow) error {
-	if len(pageViews) == 0 {
+	if len(pageViews) == 0 || c.discard {
 		return nil
 	}
 
 	batch, err := c.conn.PrepareBatch(ctx, `
-		INSERT INTO page_views (
+		INSERT INTO `+c.table("page_views")+` (
 			project_id, session_id, user_id,
 			page_url, page_path, page_title, referrer,
 			timestamp, time_on_page_ms, max_scroll_depth,
-			device_type, country
+			device_type, country, raw_time_on_page_ms
 		)
 	`)
 	if err != nil {
@@ -269,7 +647,107 @@ func (c *ClickHouse) InsertPageViews(ctx context.Context, pageViews []PageViewRo
 			pv.ProjectID, pv.SessionID, pv.UserID,
 			pv.PageURL, pv.PagePath, pv.PageTitle, pv.Referrer,
 			pv.Timestamp, pv.TimeOnPageMs, pv.MaxScrollDepth,
-			pv.DeviceType, pv.Country,
+			pv.DeviceType, pv.Country, pv.RawTimeOnPageMs,
+		)
+		if err != nil {
+			return err
+		}
+	}
+
+	return batch.Send()
+}
+
+func (c *ClickHouse) InsertAggregateMetrics(ctx context.Context, metrics []AggregateMetricRow) error {
+	if len(metrics) == 0 || c.discard {
+		return nil
+	}
+
+	batch, err := c.conn.PrepareBatch(ctx, `
+		INSERT INTO `+c.table("aggregate_metrics")+` (
+			project_id, metric_name, bucket_start, bucket_seconds,
+			dimensions, value, is_synthetic
+		)
+	`)
+	if err != nil {
+		return err
+	}
+
+	for _, m := range metrics {
+		dimensions := m.Dimensions
+		if dimensions == nil {
+			dimensions = map[string]string{}
+		}
+		err := batch.Append(
+			m.ProjectID, m.MetricName, m.BucketStart, m.BucketSeconds,
+			dimensions, m.Value, m.IsSynthetic,
+		)
+		if err != nil {
+			return err
+		}
+	}
+
+	return batch.Send()
+}
+
+func (c *ClickHouse) InsertReplayChunks(ctx context.Context, chunks []ReplayChunkRow) error {
+	if len(chunks) == 0 {
+		return nil
+	}
+
+	batch, err := c.conn.PrepareBatch(ctx, `
+		INSERT INTO replay_chunks (
+			project_id, session_id, chunk_index,
+			timestamp_start, timestamp_end,
+			data, has_full_snapshot
+		)
+	`)
+	if err != nil {
+		return err
+	}
+
+	for _, chunk := range chunks {
+		compressed, err := gzipPayload(string(chunk.Data))
+		if err != nil {
+			return err
+		}
+		err = batch.Append(
+			chunk.ProjectID, chunk.SessionID, chunk.ChunkIndex,
+			chunk.TimestampStart, chunk.TimestampEnd,
+			base64.StdEncoding.EncodeToString([]byte(compressed)), chunk.HasFullSnapshot,
+		)
+		if err != nil {
+			return err
+		}
+	}
+
+	return batch.Send()
+}
+
+// InsertReplayManifests writes session manifests; the latest UpdatedAt of a
+// session wins
+func (c *ClickHouse) InsertReplayManifests(ctx context.Context, manifests []ReplayManifest) error {
+	if len(manifests) == 0 {
+		return nil
+	}
+
+	batch, err := c.conn.PrepareBatch(ctx, `
+		INSERT INTO replay_manifests (
+			project_id, session_id,
+			chunk_indices, missing_chunks, snapshot_chunks,
+			timestamp_start, timestamp_end, duration_ms,
+			has_full_snapshot, finalized, updated_at
+		)
+	`)
+	if err != nil {
+		return err
+	}
+
+	for _, m := range manifests {
+		err := batch.Append(
+			m.ProjectID, m.SessionID,
+			nonNil(m.ChunkIndices), nonNil(m.MissingChunks), nonNil(m.SnapshotChunks),
+			m.StartTime, m.EndTime, uint64(max(m.Duration().Milliseconds(), 0)),
+			boolToUInt8(m.HasFullSnapshot()), boolToUInt8(m.Finalized), m.UpdatedAt,
 		)
 		if err != nil {
 			return err
@@ -279,17 +757,95 @@ func (c *ClickHouse) InsertPageViews(ctx context.Context, pageViews []PageViewRo
 	return batch.Send()
 }
 
+func nonNil(indices []uint32) []uint32 {
+	if indices == nil {
+		return []uint32{}
+	}
+	return indices
+}
+
+func boolToUInt8(b bool) uint8 {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+// GetReplayManifest returns the replay manifest of a session, or nil if it has
+// no replay. Sessions whose chunks were stored without a manifest get one
+// built from their chunks, not finalized.
+func (c *ClickHouse) GetReplayManifest(ctx context.Context, projectID, sessionID string) (*ReplayManifest, error) {
+	rows, err := c.conn.Query(ctx, `
+		SELECT chunk_indices, missing_chunks, snapshot_chunks,
+			timestamp_start, timestamp_end, finalized, updated_at
+		FROM replay_manifests FINAL
+		WHERE project_id = ? AND session_id = ?
+	`, projectID, sessionID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	if rows.Next() {
+		m := &ReplayManifest{ProjectID: projectID, SessionID: sessionID}
+		var finalized uint8
+		if err := rows.Scan(
+			&m.ChunkIndices, &m.MissingChunks, &m.SnapshotChunks,
+			&m.StartTime, &m.EndTime, &finalized, &m.UpdatedAt,
+		); err != nil {
+			return nil, err
+		}
+		m.Finalized = finalized == 1
+		return m, nil
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return c.replayManifestFromChunks(ctx, projectID, sessionID)
+}
+
+func (c *ClickHouse) replayManifestFromChunks(ctx context.Context, projectID, sessionID string) (*ReplayManifest, error) {
+	rows, err := c.conn.Query(ctx, `
+		SELECT chunk_index, timestamp_start, timestamp_end, has_full_snapshot
+		FROM replay_chunks
+		WHERE project_id = ? AND session_id = ?
+		ORDER BY chunk_index
+	`, projectID, sessionID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var m *ReplayManifest
+	for rows.Next() {
+		chunk := ReplayChunkRow{ProjectID: projectID, SessionID: sessionID}
+		if err := rows.Scan(&chunk.ChunkIndex, &chunk.TimestampStart, &chunk.TimestampEnd, &chunk.HasFullSnapshot); err != nil {
+			return nil, err
+		}
+		if m == nil {
+			m = &ReplayManifest{ProjectID: projectID, SessionID: sessionID}
+		}
+		m.AddChunk(chunk)
+	}
+	return m, rows.Err()
+}
+
 func (c *ClickHouse) UpsertSession(ctx context.Context, session SessionRow) error {
+	if c.discard {
+		return nil
+	}
 	return c.conn.Exec(ctx, `
-		INSERT INTO sessions (
+		INSERT INTO `+c.table("sessions")+` (
 			session_id, project_id, user_id,
 			started_at, ended_at, duration_ms,
 			browser, os, device_type,
 			country, city,
 			page_views, events_count, errors_count,
 			entry_page, exit_page,
-			has_replay, is_bounced
-		) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+			has_replay, is_bounced, is_synthetic, analytics_denied,
+			user_agent
+		) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
 	`,
 		session.SessionID, session.ProjectID, session.UserID,
 		session.StartedAt, session.EndedAt, session.DurationMs,
@@ -297,12 +853,17 @@ func (c *ClickHouse) UpsertSession(ctx context.Context, session SessionRow) erro
 		session.Country, session.City,
 		session.PageViews, session.EventsCount, session.ErrorsCount,
 		session.EntryPage, session.ExitPage,
-		session.HasReplay, session.IsBounced,
+		session.HasReplay, session.IsBounced, session.IsSynthetic, session.AnalyticsDenied,
+		session.UserAgent,
 	)
 }
 
 func (c *ClickHouse) InsertInsight(ctx context.Context, insight InsightRow) error {
+	if c.discard {
+		return nil
+	}
 	detailsJSON, _ := json.Marshal(insight.Details)
+	insight.ProjectDetails()
 
 	// Convert X and Y pointers to int32 with default 0
 	var x, y int32
@@ -314,25 +875,31 @@ func (c *ClickHouse) InsertInsight(ctx context.Context, insight InsightRow) erro
 	}
 
 	return c.conn.Exec(ctx, `
-		INSERT INTO insights (
+		INSERT INTO `+c.table("insights")+` (
 			insight_id, project_id, session_id, insight_type, timestamp,
-			url, path, x, y, target_selector, details, related_event_ids
-		) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+			url, path, x, y, target_selector, details, related_event_ids,
+			normalized_selector, click_count, load_time_ms, direction_changes, time_away_ms,
+			confidence
+		) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
 	`,
 		insight.InsightID, insight.ProjectID, insight.SessionID, insight.InsightType, insight.Timestamp,
 		insight.URL, insight.Path, x, y, insight.TargetSelector, string(detailsJSON), insight.RelatedEventIDs,
+		insight.NormalizedSelector, insight.ClickCount, insight.LoadTimeMs, insight.DirectionChanges, insight.TimeAwayMs,
+		insight.Confidence,
 	)
 }
 
 func (c *ClickHouse) InsertInsights(ctx context.Context, insights []InsightRow) error {
-	if len(insights) == 0 {
+	if len(insights) == 0 || c.discard {
 		return nil
 	}
 
 	batch, err := c.conn.PrepareBatch(ctx, `
-		INSERT INTO insights (
+		INSERT INTO `+c.table("insights")+` (
 			insight_id, project_id, session_id, insight_type, timestamp,
-			url, path, x, y, target_selector, details, related_event_ids
+			url, path, x, y, target_selector, details, related_event_ids,
+			normalized_selector, click_count, load_time_ms, direction_changes, time_away_ms,
+			confidence
 		)
 	`)
 	if err != nil {
@@ -340,7 +907,11 @@ func (c *ClickHouse) InsertInsights(ctx context.Context, insights []InsightRow)
 	}
 
 	for _, insight := range insights {
-		detailsJSON, _ := json.Marshal(insight.Details)
+		detailsJSON, err := json.Marshal(insight.Details)
+		if err != nil {
+			return fmt.Errorf("insight %s details: %w", insight.InsightID, err)
+		}
+		insight.ProjectDetails()
 
 		var x, y int32
 		if insight.X != nil {
@@ -350,9 +921,11 @@ func (c *ClickHouse) InsertInsights(ctx context.Context, insights []InsightRow)
 			y = int32(*insight.Y)
 		}
 
-		err := batch.Append(
+		err = batch.Append(
 			insight.InsightID, insight.ProjectID, insight.SessionID, insight.InsightType, insight.Timestamp,
 			insight.URL, insight.Path, x, y, insight.TargetSelector, string(detailsJSON), insight.RelatedEventIDs,
+			insight.NormalizedSelector, insight.ClickCount, insight.LoadTimeMs, insight.DirectionChanges, insight.TimeAwayMs,
+			insight.Confidence,
 		)
 		if err != nil {
 			return err
@@ -362,6 +935,677 @@ func (c *ClickHouse) InsertInsights(ctx context.Context, insights []InsightRow)
 	return batch.Send()
 }
 
+// WebVitalsRollupStale reports whether the period [from, to) needs rolling up:
+// raw web vitals of the period were inserted since it was last rolled up, or
+// it never was. Insert times have second precision, so vitals inserted in the
+// second of the rollup count as inserted since.
+func (c *ClickHouse) WebVitalsRollupStale(ctx context.Context, from, to time.Time) (bool, error) {
+	var count uint64
+	err := c.conn.QueryRow(ctx, `
+		SELECT count() FROM web_vitals
+		WHERE timestamp >= ? AND timestamp < ?
+		AND created_at >= (SELECT max(rolled_at) FROM web_vitals_rollup WHERE period_start = ?)
+	`, from, to, from).Scan(&count)
+	if err != nil {
+		return false, err
+	}
+	return count > 0, nil
+}
+
+// RollupWebVitals aggregates raw web vitals in [from, to) into quantile states
+// per (project, page), replacing an earlier rollup of the period: the table is
+// a ReplacingMergeTree versioned by rolled_at, read with FINAL, so the states of
+// the two rollups never merge.
+func (c *ClickHouse) RollupWebVitals(ctx context.Context, from, to time.Time) error {
+	return c.conn.Exec(ctx, `
+		INSERT INTO web_vitals_rollup (project_id, page_path, period_start, samples, rolled_at, lcp, inp, cls)
+		SELECT
+			project_id,
+			page_path,
+			? AS period_start,
+			count() AS samples,
+			now() AS rolled_at,
+			quantilesStateIf(0.5, 0.75, 0.95)(assumeNotNull(lcp), lcp IS NOT NULL) AS lcp,
+			quantilesStateIf(0.5, 0.75, 0.95)(assumeNotNull(inp), inp IS NOT NULL) AS inp,
+			quantilesStateIf(0.5, 0.75, 0.95)(assumeNotNull(cls), cls IS NOT NULL) AS cls
+		FROM web_vitals
+		WHERE timestamp >= ? AND timestamp < ?
+		GROUP BY project_id, page_path
+	`, from, from, to)
+}
+
+// GetWebVitalsPercentiles merges rolled up quantile states for a page over [from, to)
+func (c *ClickHouse) GetWebVitalsPercentiles(ctx context.Context, projectID, page string, from, to time.Time) (Percentiles, error) {
+	var (
+		p             Percentiles
+		lcp, inp, cls []float64
+	)
+
+	err := c.conn.QueryRow(ctx, `
+		SELECT
+			sum(samples),
+			quantilesIfMerge(0.5, 0.75, 0.95)(lcp),
+			quantilesIfMerge(0.5, 0.75, 0.95)(inp),
+			quantilesIfMerge(0.5, 0.75, 0.95)(cls)
+		FROM web_vitals_rollup FINAL
+		WHERE project_id = ? AND page_path = ?
+		AND period_start >= ? AND period_start < ?
+	`, projectID, page, from, to).Scan(&p.Samples, &lcp, &inp, &cls)
+	if err != nil {
+		return Percentiles{}, err
+	}
+
+	p.LCP = toQuantiles(lcp)
+	p.INP = toQuantiles(inp)
+	p.CLS = toQuantiles(cls)
+
+	return p, nil
+}
+
+func toQuantiles(values []float64) Quantiles {
+	var q Quantiles
+	if len(values) == 3 {
+		q.P50, q.P75, q.P95 = values[0], values[1], values[2]
+	}
+	// quantiles over an empty set are NaN
+	if math.IsNaN(q.P50) {
+		return Quantiles{}
+	}
+	return q
+}
+
+// SetCompressPayload makes InsertEvents gzip event payloads into payload_compressed
+// instead of storing the JSON in payload. Reads handle both forms.
+func (c *ClickHouse) SetCompressPayload(enabled bool) {
+	c.compressPayload = enabled
+}
+
+// shadowTables are the tables written by the event and insight processors
+var shadowTables = []string{"events", "sessions", "page_views", "web_vitals", "errors", "long_tasks", "aggregate_metrics", "insights"}
+
+// SetEventCategories stores events of the types of each category in their
+// own table, events_<category>, so categories can have their own TTL, ORDER
+// BY or replication. Other event types stay in events. Reads union all event
+// tables.
+func (c *ClickHouse) SetEventCategories(categories map[string][]string) {
+	c.eventCategories = make(map[eventtype.Type]string)
+	c.categoryTables = nil
+	for category, types := range categories {
+		for _, t := range types {
+			c.eventCategories[eventtype.Normalize(t)] = category
+		}
+		c.categoryTables = append(c.categoryTables, "events_"+category)
+	}
+	slices.Sort(c.categoryTables)
+}
+
+// CreateEventTables creates the missing category tables as copies of events.
+// Create them beforehand for a TTL, ORDER BY or engine of their own.
+func (c *ClickHouse) CreateEventTables(ctx context.Context) error {
+	for _, table := range c.categoryTables {
+		if err := c.conn.Exec(ctx, fmt.Sprintf("CREATE TABLE IF NOT EXISTS %s AS events", table)); err != nil {
+			return fmt.Errorf("create event table %s: %w", table, err)
+		}
+	}
+	return nil
+}
+
+// eventTable returns the table events of a type are stored in
+func (c *ClickHouse) eventTable(eventType string) string {
+	if category, ok := c.eventCategories[eventtype.Normalize(eventType)]; ok {
+		return "events_" + category
+	}
+	return "events"
+}
+
+// eventTables are all the tables events are stored in
+func (c *ClickHouse) eventTables() []string {
+	return append([]string{"events"}, c.categoryTables...)
+}
+
+// eventsSource is the table expression reading events from all event tables
+func (c *ClickHouse) eventsSource() string {
+	if len(c.categoryTables) == 0 {
+		return "events"
+	}
+	return fmt.Sprintf("merge(currentDatabase(), '^(%s)$')", strings.Join(c.eventTables(), "|"))
+}
+
+// SetShadow sends inserts of the event and insight processors to tables named
+// prefix+table, or drops them when discard is set, so a shadow deployment
+// leaves production data alone. Queries still read the production tables.
+func (c *ClickHouse) SetShadow(prefix string, discard bool) {
+	c.tablePrefix = prefix
+	c.discard = discard
+}
+
+// CreateShadowTables creates the prefixed tables SetShadow writes to, with the
+// structure of the production tables at the time they are created
+func (c *ClickHouse) CreateShadowTables(ctx context.Context) error {
+	if c.discard || c.tablePrefix == "" {
+		return nil
+	}
+	for _, table := range shadowTables {
+		if err := c.conn.Exec(ctx, fmt.Sprintf("CREATE TABLE IF NOT EXISTS %s AS %s", c.table(table), table)); err != nil {
+			return fmt.Errorf("create shadow table %s: %w", c.table(table), err)
+		}
+	}
+	for _, table := range c.categoryTables {
+		if err := c.conn.Exec(ctx, fmt.Sprintf("CREATE TABLE IF NOT EXISTS %s AS events", c.table(table))); err != nil {
+			return fmt.Errorf("create shadow table %s: %w", c.table(table), err)
+		}
+	}
+	return nil
+}
+
+// table returns the table inserts into the named table go to
+func (c *ClickHouse) table(name string) string {
+	return c.tablePrefix + name
+}
+
+// RetentionTable is a table rows are deleted from by per-project retention,
+// with the column their age is taken from
+type RetentionTable struct {
+	Name       string
+	TimeColumn string
+}
+
+// RetentionTables are the tables holding a project's analytics data
+var RetentionTables = [...]RetentionTable{
+	{"events", "timestamp"},
+	{"sessions", "started_at"},
+	{"page_views", "timestamp"},
+	{"web_vitals", "timestamp"},
+	{"web_vitals_rollup", "period_start"},
+	{"errors", "timestamp"},
+	{"long_tasks", "timestamp"},
+	{"aggregate_metrics", "bucket_start"},
+	{"insights", "timestamp"},
+	{"replay_chunks", "timestamp_start"},
+	{"replay_manifests", "timestamp_start"},
+}
+
+// CountExpired counts a project's rows of table older than cutoff
+func (c *ClickHouse) CountExpired(ctx context.Context, table RetentionTable, projectID string, cutoff time.Time) (uint64, error) {
+	source := c.table(table.Name)
+	if table.Name == "events" {
+		source = c.eventsSource()
+	}
+	var count uint64
+	err := c.conn.QueryRow(ctx, fmt.Sprintf(
+		"SELECT count() FROM %s WHERE project_id = ? AND %s < ?", source, table.TimeColumn,
+	), projectID, cutoff).Scan(&count)
+	return count, err
+}
+
+// DeleteExpired deletes a project's rows of table older than cutoff. The
+// delete is an asynchronous mutation; see PendingMutations.
+func (c *ClickHouse) DeleteExpired(ctx context.Context, table RetentionTable, projectID string, cutoff time.Time) error {
+	if c.discard {
+		return nil
+	}
+	tables := []string{table.Name}
+	if table.Name == "events" {
+		tables = c.eventTables()
+	}
+	for _, t := range tables {
+		if err := c.conn.Exec(ctx, fmt.Sprintf(
+			"ALTER TABLE %s DELETE WHERE project_id = ? AND %s < ?", c.table(t), table.TimeColumn,
+		), projectID, cutoff); err != nil {
+			return fmt.Errorf("delete from %s: %w", c.table(t), err)
+		}
+	}
+	return nil
+}
+
+// PendingMutations counts the unfinished mutations of the current database
+func (c *ClickHouse) PendingMutations(ctx context.Context) (uint64, error) {
+	var count uint64
+	err := c.conn.QueryRow(ctx, `
+		SELECT count() FROM system.mutations WHERE database = currentDatabase() AND NOT is_done
+	`).Scan(&count)
+	return count, err
+}
+
+// SetFrustrationWeights sets the points per insight type used by
+// SessionFrustrationScores; an empty map keeps the defaults
+func (c *ClickHouse) SetFrustrationWeights(weights map[string]float64) {
+	if len(weights) > 0 {
+		c.frustrationWeights = weights
+	}
+}
+
+// SessionFrustrationScores returns a 0-99 frustration score for every session of
+// a project with at least one weighted insight between from and to.
+//
+// Each insight adds the weight of its type in points, scaled by its confidence:
+// detectors grade confidence by how far past their threshold the signal went
+// (more rage clicks, a slower page), so severe insights count more than
+// borderline ones. Types without a weight are ignored. FrustrationScore turns
+// the total into the score.
+func (c *ClickHouse) SessionFrustrationScores(ctx context.Context, projectID string, from, to time.Time) (map[string]int, error) {
+	types := make([]string, 0, len(c.frustrationWeights))
+	for insightType := range c.frustrationWeights {
+		types = append(types, insightType)
+	}
+
+	rows, err := c.conn.Query(ctx, `
+		SELECT session_id, insight_type, sum(toFloat64(confidence))
+		FROM insights
+		WHERE project_id = ? AND timestamp >= ? AND timestamp < ? AND insight_type IN ?
+		GROUP BY session_id, insight_type
+	`, projectID, from, to, types)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	severities := make(map[string]map[string]float64)
+	for rows.Next() {
+		var sessionID, insightType string
+		var severity float64
+		if err := rows.Scan(&sessionID, &insightType, &severity); err != nil {
+			return nil, err
+		}
+		if severities[sessionID] == nil {
+			severities[sessionID] = make(map[string]float64)
+		}
+		severities[sessionID][insightType] = severity
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	scores := make(map[string]int, len(severities))
+	for sessionID, sessionSeverities := range severities {
+		scores[sessionID] = FrustrationScore(sessionSeverities, c.frustrationWeights)
+	}
+	return scores, nil
+}
+
+// SegmentMetrics holds headline session metrics for one segment
+type SegmentMetrics struct {
+	Segment          string
+	Sessions         uint64
+	BounceRate       float64 // fraction of bounced sessions (0-1)
+	AvgDurationMs    float64
+	MedianDurationMs float64
+}
+
+// sessionSegmentColumns lists the sessions columns SessionMetrics can group by.
+// groupBy is interpolated into the query, so only these are accepted.
+var sessionSegmentColumns = map[string]bool{
+	"device_type": true,
+	"country":     true,
+	"entry_page":  true,
+	"browser":     true,
+	"os":          true,
+}
+
+// SessionMetrics returns session count, bounce rate and average/median duration
+// per value of groupBy (e.g. device_type) for sessions started in [from, to),
+// largest segments first. Synthetic sessions are left out unless includeSynthetic.
+func (c *ClickHouse) SessionMetrics(ctx context.Context, projectID string, from, to time.Time, groupBy string, includeSynthetic bool) ([]SegmentMetrics, error) {
+	if !sessionSegmentColumns[groupBy] {
+		return nil, fmt.Errorf("unsupported session segment %q", groupBy)
+	}
+
+	// FINAL: sessions is a ReplacingMergeTree and each update inserts a new row
+	rows, err := c.conn.Query(ctx, fmt.Sprintf(`
+		SELECT
+			%s AS segment,
+			count() AS sessions,
+			avg(is_bounced),
+			avg(duration_ms),
+			quantile(0.5)(duration_ms)
+		FROM sessions FINAL
+		WHERE project_id = ? AND started_at >= ? AND started_at < ?%s
+		GROUP BY segment
+		ORDER BY sessions DESC
+	`, groupBy, syntheticFilter(includeSynthetic)), projectID, from, to)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var metrics []SegmentMetrics
+	for rows.Next() {
+		var m SegmentMetrics
+		if err := rows.Scan(&m.Segment, &m.Sessions, &m.BounceRate, &m.AvgDurationMs, &m.MedianDurationMs); err != nil {
+			return nil, err
+		}
+		metrics = append(metrics, m)
+	}
+	return metrics, rows.Err()
+}
+
+// SessionCursor is a position in the (started_at, session_id) order of a
+// project's sessions. The zero cursor is before the first session.
+type SessionCursor struct {
+	StartedAt time.Time
+	SessionID string
+}
+
+// String encodes the cursor for clients; ParseSessionCursor decodes it
+func (c SessionCursor) String() string {
+	if c.StartedAt.IsZero() {
+		return ""
+	}
+	raw := fmt.Sprintf("%d:%s", c.StartedAt.UnixMilli(), c.SessionID)
+	return base64.RawURLEncoding.EncodeToString([]byte(raw))
+}
+
+// ParseSessionCursor decodes a cursor returned by SessionCursor.String; ""
+// is the zero cursor
+func ParseSessionCursor(s string) (SessionCursor, error) {
+	if s == "" {
+		return SessionCursor{}, nil
+	}
+	raw, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return SessionCursor{}, fmt.Errorf("invalid session cursor: %w", err)
+	}
+	ms, sessionID, ok := strings.Cut(string(raw), ":")
+	if !ok {
+		return SessionCursor{}, fmt.Errorf("invalid session cursor %q", s)
+	}
+	startedAt, err := strconv.ParseInt(ms, 10, 64)
+	if err != nil {
+		return SessionCursor{}, fmt.Errorf("invalid session cursor %q: %w", s, err)
+	}
+	return SessionCursor{StartedAt: time.UnixMilli(startedAt), SessionID: sessionID}, nil
+}
+
+// ListSessionsSince returns up to limit sessions of a project (0 = no limit)
+// ordered by (started_at, session_id), starting after cursor, and the cursor to
+// pass to the next call. When no session is newer the cursor is returned
+// unchanged, so a client polling with the last cursor it got fetches every
+// session once. A session is placed by its started_at: one that is only
+// written after the cursor passed its start time is not returned. Synthetic
+// sessions are left out unless includeSynthetic.
+func (c *ClickHouse) ListSessionsSince(ctx context.Context, projectID string, cursor SessionCursor, limit int, includeSynthetic bool) ([]SessionRow, SessionCursor, error) {
+	// FINAL: sessions is a ReplacingMergeTree and each update inserts a new row
+	query := `
+		SELECT
+			session_id, project_id, user_id,
+			started_at, ended_at, duration_ms,
+			browser, os, device_type,
+			country, city,
+			page_views, events_count, errors_count,
+			entry_page, exit_page,
+			has_replay, is_bounced, is_synthetic, analytics_denied,
+			user_agent
+		FROM sessions FINAL
+		WHERE project_id = ?` + syntheticFilter(includeSynthetic)
+	args := []interface{}{projectID}
+
+	if !cursor.StartedAt.IsZero() {
+		query += ` AND (started_at > ? OR (started_at = ? AND session_id > ?))`
+		args = append(args, cursor.StartedAt, cursor.StartedAt, cursor.SessionID)
+	}
+
+	query += ` ORDER BY started_at, session_id`
+
+	if limit > 0 {
+		query += ` LIMIT ?`
+		args = append(args, limit)
+	}
+
+	rows, err := c.conn.Query(ctx, query, args...)
+	if err != nil {
+		return nil, cursor, err
+	}
+	defer rows.Close()
+
+	var sessions []SessionRow
+	for rows.Next() {
+		var s SessionRow
+		if err := rows.Scan(
+			&s.SessionID, &s.ProjectID, &s.UserID,
+			&s.StartedAt, &s.EndedAt, &s.DurationMs,
+			&s.Browser, &s.OS, &s.DeviceType,
+			&s.Country, &s.City,
+			&s.PageViews, &s.EventsCount, &s.ErrorsCount,
+			&s.EntryPage, &s.ExitPage,
+			&s.HasReplay, &s.IsBounced, &s.IsSynthetic, &s.AnalyticsDenied,
+			&s.UserAgent,
+		); err != nil {
+			return nil, cursor, err
+		}
+		sessions = append(sessions, s)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, cursor, err
+	}
+
+	if len(sessions) > 0 {
+		last := sessions[len(sessions)-1]
+		cursor = SessionCursor{StartedAt: last.StartedAt, SessionID: last.SessionID}
+	}
+	return sessions, cursor, nil
+}
+
+// MetricSeries returns a metric per bucket over [from, to): the pushed
+// aggregate_metrics values plus a count of the custom events of the same name,
+// so a metric reads the same whether it is sent as events or pre-aggregated.
+// Aggregates count in the bucket containing their bucket_start. Custom events
+// match on custom_event_name, or on the payload name for rows stored before it;
+// those with a compressed payload are not counted. Synthetic rows are left out
+// unless includeSynthetic.
+func (c *ClickHouse) MetricSeries(ctx context.Context, projectID, metric string, from, to time.Time, bucket time.Duration, includeSynthetic bool) ([]MetricPoint, error) {
+	seconds := int64(bucket / time.Second)
+	if seconds <= 0 {
+		return nil, fmt.Errorf("metric bucket must be at least 1s, got %s", bucket)
+	}
+
+	rows, err := c.conn.Query(ctx, fmt.Sprintf(`
+		SELECT bucket, sum(value)
+		FROM (
+			SELECT toStartOfInterval(bucket_start, INTERVAL %[1]d SECOND) AS bucket, value
+			FROM aggregate_metrics
+			WHERE project_id = ? AND metric_name = ?
+			AND bucket_start >= ? AND bucket_start < ?%[2]s
+			UNION ALL
+			SELECT toStartOfInterval(toDateTime(timestamp), INTERVAL %[1]d SECOND) AS bucket, toFloat64(1) AS value
+			FROM %[3]s
+			WHERE project_id = ? AND event_type IN ('custom', 'EVENT_TYPE_CUSTOM')
+			AND if(custom_event_name != '', custom_event_name, JSONExtractString(payload, 'name')) = ?
+			AND timestamp >= ? AND timestamp < ?%[2]s
+		)
+		GROUP BY bucket
+		ORDER BY bucket
+	`, seconds, syntheticFilter(includeSynthetic), c.eventsSource()), projectID, metric, from, to, projectID, metric, from, to)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var points []MetricPoint
+	for rows.Next() {
+		var p MetricPoint
+		if err := rows.Scan(&p.BucketStart, &p.Value); err != nil {
+			return nil, err
+		}
+		points = append(points, p)
+	}
+	return points, rows.Err()
+}
+
+// frustrationScoreMidpoint is the number of points scoring 50
+const frustrationScoreMidpoint = 100
+
+// FrustrationScore turns the summed confidence of a session's insights per type
+// into a score from 0 to 99. The weighted points map to
+// 100 * points / (points + frustrationScoreMidpoint), rounded down: one rage
+// click (15 points) scores 13, 100 points 50 and 900 points 90. The score keeps
+// rising without ever reaching 100, so the worst sessions still sort apart.
+func FrustrationScore(severities map[string]float64, weights map[string]float64) int {
+	var points float64
+	for insightType, severity := range severities {
+		points += weights[insightType] * severity
+	}
+	if points <= 0 {
+		return 0
+	}
+
+	return int(100 * points / (points + frustrationScoreMidpoint))
+}
+
+// GetSessionEvents returns all events of a session in chronological order.
+// Use GetSessionEventsPage for very long sessions.
+func (c *ClickHouse) GetSessionEvents(ctx context.Context, projectID, sessionID string) ([]EventRow, error) {
+	return c.GetSessionEventsPage(ctx, projectID, sessionID, time.Time{}, "", 0)
+}
+
+// GetSessionEventsPage returns up to limit events of a session (0 = no limit) ordered by
+// (timestamp, event_id), starting after the given event. Pass the Timestamp and EventID of
+// the last row of the previous page to continue; a zero time starts from the beginning.
+// The events table is ordered by (project_id, session_id, timestamp), so these reads
+// only touch the granules of a single session.
+func (c *ClickHouse) GetSessionEventsPage(ctx context.Context, projectID, sessionID string, afterTimestamp time.Time, afterEventID string, limit int) ([]EventRow, error) {
+	query := c.eventColumnsQuery() + `
+		WHERE project_id = ? AND session_id = ?
+	`
+	args := []interface{}{projectID, sessionID}
+
+	if !afterTimestamp.IsZero() {
+		query += ` AND (timestamp > ? OR (timestamp = ? AND toString(event_id) > ?))`
+		args = append(args, afterTimestamp, afterTimestamp, afterEventID)
+	}
+
+	query += ` ORDER BY timestamp, toString(event_id)`
+
+	if limit > 0 {
+		query += ` LIMIT ?`
+		args = append(args, limit)
+	}
+
+	rows, err := c.conn.Query(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var events []EventRow
+	for rows.Next() {
+		e, err := scanEventRow(rows)
+		if err != nil {
+			return nil, err
+		}
+		events = append(events, e)
+	}
+
+	return events, rows.Err()
+}
+
+// StreamEvents calls fn for each event of a project in [from, to), ordered by
+// session and then (timestamp, event_id), so each session's events arrive
+// together and in order. Rows are streamed, not loaded into memory. Synthetic
+// events are left out unless includeSynthetic.
+func (c *ClickHouse) StreamEvents(ctx context.Context, projectID string, from, to time.Time, includeSynthetic bool, fn func(EventRow) error) error {
+	rows, err := c.conn.Query(ctx, c.eventColumnsQuery()+`
+		WHERE project_id = ? AND timestamp >= ? AND timestamp < ?`+syntheticFilter(includeSynthetic)+`
+		ORDER BY session_id, timestamp, toString(event_id)
+	`, projectID, from, to)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		e, err := scanEventRow(rows)
+		if err != nil {
+			return err
+		}
+		if err := fn(e); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
+
+// syntheticFilter returns the condition leaving out synthetic rows (events or
+// sessions), or nothing when they are included
+func syntheticFilter(includeSynthetic bool) string {
+	if includeSynthetic {
+		return ""
+	}
+	return " AND is_synthetic = 0"
+}
+
+// eventColumnsQuery selects the columns read by scanEventRow
+func (c *ClickHouse) eventColumnsQuery() string {
+	return `
+		SELECT
+			toString(event_id), project_id, session_id, user_id, event_type, timestamp,
+			page_url, page_path, page_title, referrer,
+			browser, browser_version, os, os_version, device_type,
+			screen_width, screen_height, viewport_width, viewport_height,
+			country, city, payload, secondary_timestamp, payload_compressed,
+			is_synthetic
+		FROM ` + c.eventsSource()
+}
+
+func scanEventRow(rows driver.Rows) (EventRow, error) {
+	var e EventRow
+	var compressed string
+	err := rows.Scan(
+		&e.EventID, &e.ProjectID, &e.SessionID, &e.UserID, &e.EventType, &e.Timestamp,
+		&e.PageURL, &e.PagePath, &e.PageTitle, &e.Referrer,
+		&e.Browser, &e.BrowserVersion, &e.OS, &e.OSVersion, &e.DeviceType,
+		&e.ScreenWidth, &e.ScreenHeight, &e.ViewportWidth, &e.ViewportHeight,
+		&e.Country, &e.City, &e.Payload, &e.SecondaryTimestamp, &compressed,
+		&e.IsSynthetic,
+	)
+	if err != nil {
+		return EventRow{}, err
+	}
+
+	if compressed != "" {
+		if e.Payload, err = gunzipPayload(compressed); err != nil {
+			return EventRow{}, err
+		}
+	}
+
+	if e.Payload != "" {
+		// Keep the row even if the stored payload is not valid JSON
+		json.Unmarshal([]byte(e.Payload), &e.PayloadData)
+	}
+
+	return e, nil
+}
+
+// gzipPayload compresses a JSON payload for the payload_compressed column
+func gzipPayload(payload string) (string, error) {
+	var buf bytes.Buffer
+	zw, err := gzip.NewWriterLevel(&buf, gzip.BestSpeed)
+	if err != nil {
+		return "", err
+	}
+	if _, err := zw.Write([]byte(payload)); err != nil {
+		return "", err
+	}
+	if err := zw.Close(); err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
+
+// gunzipPayload reverses gzipPayload
+func gunzipPayload(compressed string) (string, error) {
+	zr, err := gzip.NewReader(strings.NewReader(compressed))
+	if err != nil {
+		return "", err
+	}
+	defer zr.Close()
+
+	data, err := io.ReadAll(zr)
+	if err != nil {
+		return "", err
+	}
+	return string(data), nil
+}
+
 func (c *ClickHouse) Close() error {
 	return c.conn.Close()
 }
@@ -0,0 +1,100 @@
+package storage
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestProjectDetailsPerInsightType(t *testing.T) {
+	tests := []struct {
+		insightType string
+		details     map[string]interface{}
+		check       func(t *testing.T, r InsightRow)
+	}{
+		{
+			insightType: "rage_click",
+			details:     map[string]interface{}{"click_count": 12},
+			check: func(t *testing.T, r InsightRow) {
+				if r.ClickCount == nil || *r.ClickCount != 12 {
+					t.Errorf("ClickCount = %v, want 12", r.ClickCount)
+				}
+				if r.LoadTimeMs != nil || r.DirectionChanges != nil || r.TimeAwayMs != nil {
+					t.Error("unexpected typed columns set for rage_click")
+				}
+			},
+		},
+		{
+			insightType: "slow_page",
+			details:     map[string]interface{}{"load_time_ms": 4250.5},
+			check: func(t *testing.T, r InsightRow) {
+				if r.LoadTimeMs == nil || *r.LoadTimeMs != 4250.5 {
+					t.Errorf("LoadTimeMs = %v, want 4250.5", r.LoadTimeMs)
+				}
+				if r.ClickCount != nil {
+					t.Error("unexpected ClickCount for slow_page")
+				}
+			},
+		},
+		{
+			insightType: "thrashed_cursor",
+			details:     map[string]interface{}{"direction_changes": int64(9)},
+			check: func(t *testing.T, r InsightRow) {
+				if r.DirectionChanges == nil || *r.DirectionChanges != 9 {
+					t.Errorf("DirectionChanges = %v, want 9", r.DirectionChanges)
+				}
+			},
+		},
+		{
+			insightType: "u_turn",
+			details:     map[string]interface{}{"time_away_ms": int64(3000)},
+			check: func(t *testing.T, r InsightRow) {
+				if r.TimeAwayMs == nil || *r.TimeAwayMs != 3000 {
+					t.Errorf("TimeAwayMs = %v, want 3000", r.TimeAwayMs)
+				}
+			},
+		},
+		{
+			// Fields of other types are not projected
+			insightType: "dead_click",
+			details:     map[string]interface{}{"click_count": 3, "load_time_ms": 100.0},
+			check: func(t *testing.T, r InsightRow) {
+				if r.ClickCount != nil || r.LoadTimeMs != nil || r.DirectionChanges != nil || r.TimeAwayMs != nil {
+					t.Error("unexpected typed columns set for dead_click")
+				}
+			},
+		},
+		{
+			// Non-numeric values are left to Details
+			insightType: "rage_click",
+			details:     map[string]interface{}{"click_count": "many"},
+			check: func(t *testing.T, r InsightRow) {
+				if r.ClickCount != nil {
+					t.Errorf("ClickCount = %v, want nil", *r.ClickCount)
+				}
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.insightType, func(t *testing.T) {
+			r := InsightRow{InsightType: tt.insightType, Details: tt.details}
+			r.ProjectDetails()
+			tt.check(t, r)
+		})
+	}
+}
+
+func TestProjectDetailsFromDecodedJSON(t *testing.T) {
+	// Details read back from JSON hold float64 numbers
+	var details map[string]interface{}
+	if err := json.Unmarshal([]byte(`{"click_count": 7, "selector": "#buy"}`), &details); err != nil {
+		t.Fatal(err)
+	}
+
+	r := InsightRow{InsightType: "rage_click", Details: details}
+	r.ProjectDetails()
+
+	if r.ClickCount == nil || *r.ClickCount != 7 {
+		t.Errorf("ClickCount = %v, want 7", r.ClickCount)
+	}
+}
@@ -0,0 +1,61 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+func TestInsertEventsReportsPartiallyInsertedCategories(t *testing.T) {
+	c := testClickHouse(t)
+	ctx := context.Background()
+	c.SetEventCategories(map[string][]string{
+		"core":        {"error"},
+		"interaction": {"mouse_move"},
+	})
+	if err := c.CreateEventTables(ctx); err != nil {
+		t.Fatal(err)
+	}
+	// Inserting into events_interaction fails
+	if err := c.conn.Exec(ctx, "DROP TABLE events_interaction"); err != nil {
+		t.Fatal(err)
+	}
+
+	now := time.Now().UTC()
+	var rows []EventRow
+	for _, eventType := range []string{"click", "error", "mouse_move", "mouse_move"} {
+		rows = append(rows, EventRow{EventID: uuid.New().String(), ProjectID: "proj", SessionID: "sess", EventType: eventType, Timestamp: now})
+	}
+
+	err := c.InsertEvents(ctx, rows)
+	var insertErr *EventsInsertError
+	if !errors.As(err, &insertErr) {
+		t.Fatalf("InsertEvents = %v, want an *EventsInsertError", err)
+	}
+	if !slices.Equal(insertErr.Persisted, []string{"events", "events_core"}) {
+		t.Errorf("persisted %v, want events and events_core", insertErr.Persisted)
+	}
+	if len(insertErr.Unsaved) != 2 || insertErr.Unsaved[0].EventType != "mouse_move" {
+		t.Errorf("unsaved %d events, want the 2 mouse moves", len(insertErr.Unsaved))
+	}
+
+	// Retrying the unsaved events once their table is back stores each
+	// event once
+	if err := c.CreateEventTables(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.InsertEvents(ctx, insertErr.Unsaved); err != nil {
+		t.Fatal(err)
+	}
+	events, err := c.GetSessionEvents(ctx, "proj", "sess")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != len(rows) {
+		t.Errorf("stored %d events, want %d", len(events), len(rows))
+	}
+}
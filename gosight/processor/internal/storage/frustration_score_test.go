@@ -0,0 +1,80 @@
+package storage
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+func TestFrustrationScore(t *testing.T) {
+	weights := map[string]float64{"rage_click": 15, "dead_click": 5, "slow_page": 10}
+
+	tests := []struct {
+		name       string
+		severities map[string]float64
+		want       int
+	}{
+		{"no insights", nil, 0},
+		{"unweighted type", map[string]float64{"pogostick": 3}, 0},
+		{"one rage click", map[string]float64{"rage_click": 1}, 13},
+		{"borderline rage click", map[string]float64{"rage_click": 0.5}, 6},
+		{"midpoint", map[string]float64{"rage_click": 4, "dead_click": 4, "slow_page": 2}, 50},
+		{"many insights", map[string]float64{"rage_click": 60}, 90},
+		{"far past saturation", map[string]float64{"rage_click": 1e6}, 99},
+	}
+	for _, tt := range tests {
+		if got := FrustrationScore(tt.severities, weights); got != tt.want {
+			t.Errorf("%s: FrustrationScore = %d, want %d", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestFrustrationScoreKeepsRankingBadSessions(t *testing.T) {
+	weights := DefaultFrustrationWeights
+	bad := FrustrationScore(map[string]float64{"rage_click": 40, "error_click": 10}, weights)
+	worse := FrustrationScore(map[string]float64{"rage_click": 80, "error_click": 20}, weights)
+	if bad >= worse {
+		t.Errorf("scores %d and %d, want the worse session to score higher", bad, worse)
+	}
+	if worse >= 100 {
+		t.Errorf("score %d, want below 100", worse)
+	}
+}
+
+func TestSessionFrustrationScores(t *testing.T) {
+	c := testClickHouse(t)
+	c.SetFrustrationWeights(map[string]float64{"rage_click": 15, "dead_click": 5})
+	ctx := context.Background()
+	now := time.Now().UTC()
+
+	insert := func(sessionID, insightType string, confidence float32) {
+		t.Helper()
+		if err := c.InsertInsight(ctx, InsightRow{
+			InsightID:   uuid.New(),
+			ProjectID:   "proj",
+			SessionID:   sessionID,
+			InsightType: insightType,
+			Timestamp:   now,
+			Confidence:  confidence,
+		}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	insert("severe", "rage_click", 1)
+	insert("severe", "dead_click", 1)
+	insert("mild", "rage_click", 0.5)
+	insert("unweighted", "pogostick", 1)
+
+	scores, err := c.SessionFrustrationScores(ctx, "proj", now.Add(-time.Minute), now.Add(time.Minute))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(scores) != 2 {
+		t.Errorf("scores = %v, want severe and mild only", scores)
+	}
+	if scores["severe"] != 16 || scores["mild"] != 6 {
+		t.Errorf("scores = %v, want severe 16 and mild 6", scores)
+	}
+}
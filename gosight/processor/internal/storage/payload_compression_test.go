@@ -0,0 +1,109 @@
+package storage
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// representativePayloads are event payloads of the shapes that dominate the
+// events table: small interactions, custom events with large properties and
+// DOM snapshot-like data
+func representativePayloads() map[string]string {
+	click := `{"x":412,"y":988,"target_tag":"button","target_selector":"main > form.checkout > button.btn.btn-primary","target_text":"Place order"}`
+
+	properties := make(map[string]interface{})
+	for i := 0; i < 40; i++ {
+		properties[fmt.Sprintf("item_%d", i)] = map[string]interface{}{
+			"sku": fmt.Sprintf("SKU-%05d", i), "category": "apparel", "price": 19.99, "currency": "USD",
+		}
+	}
+	custom, _ := json.Marshal(map[string]interface{}{"name": "cart_viewed", "properties": properties})
+
+	var nodes []string
+	for i := 0; i < 200; i++ {
+		nodes = append(nodes, fmt.Sprintf(`{"id":%d,"type":2,"tagName":"div","attributes":{"class":"product-card grid-item"},"childNodes":[{"id":%d,"type":3,"textContent":"Product %d"}]}`, i*2, i*2+1, i))
+	}
+	snapshot := `{"type":2,"data":{"node":{"type":0,"childNodes":[` + strings.Join(nodes, ",") + `]}}}`
+
+	return map[string]string{"click": click, "custom": string(custom), "snapshot": snapshot}
+}
+
+func TestGzipPayloadRoundTrip(t *testing.T) {
+	for name, payload := range representativePayloads() {
+		compressed, err := gzipPayload(payload)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := gunzipPayload(compressed)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != payload {
+			t.Errorf("%s: round trip changed the payload", name)
+		}
+	}
+}
+
+// BenchmarkPayloadCompression reports the CPU cost of compressing and
+// decompressing each payload, and the stored size as a fraction of the JSON
+func BenchmarkPayloadCompression(b *testing.B) {
+	for name, payload := range representativePayloads() {
+		compressed, err := gzipPayload(payload)
+		if err != nil {
+			b.Fatal(err)
+		}
+		ratio := float64(len(compressed)) / float64(len(payload))
+
+		b.Run("gzip/"+name, func(b *testing.B) {
+			b.SetBytes(int64(len(payload)))
+			for i := 0; i < b.N; i++ {
+				gzipPayload(payload)
+			}
+			b.ReportMetric(ratio, "size_ratio")
+		})
+		b.Run("gunzip/"+name, func(b *testing.B) {
+			b.SetBytes(int64(len(payload)))
+			for i := 0; i < b.N; i++ {
+				gunzipPayload(compressed)
+			}
+		})
+	}
+}
+
+func TestMetricSeriesCountsCompressedCustomEvents(t *testing.T) {
+	c := testClickHouse(t)
+	ctx := context.Background()
+	bucket := time.Now().UTC().Truncate(time.Hour)
+
+	insert := func(compress bool) {
+		t.Helper()
+		c.SetCompressPayload(compress)
+		if err := c.InsertEvents(ctx, []EventRow{{
+			EventID:         uuid.New().String(),
+			ProjectID:       "proj",
+			SessionID:       "sess",
+			EventType:       "custom",
+			Timestamp:       bucket.Add(time.Minute),
+			Payload:         `{"name":"signup","properties":{"plan":"pro"}}`,
+			CustomEventName: "signup",
+		}}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	insert(false)
+	insert(true)
+
+	points, err := c.MetricSeries(ctx, "proj", "signup", bucket, bucket.Add(time.Hour), time.Hour, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(points) != 1 || points[0].Value != 2 {
+		t.Errorf("points = %+v, want both custom events counted", points)
+	}
+}
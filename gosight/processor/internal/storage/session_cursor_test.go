@@ -0,0 +1,88 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestSessionCursorRoundTrip(t *testing.T) {
+	cursor := SessionCursor{StartedAt: time.UnixMilli(1760000000123), SessionID: "sess:with:colons"}
+	parsed, err := ParseSessionCursor(cursor.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !parsed.StartedAt.Equal(cursor.StartedAt) || parsed.SessionID != cursor.SessionID {
+		t.Errorf("parsed %+v, want %+v", parsed, cursor)
+	}
+
+	if zero, err := ParseSessionCursor(""); err != nil || !zero.StartedAt.IsZero() || (SessionCursor{}).String() != "" {
+		t.Errorf("zero cursor = %+v, %v", zero, err)
+	}
+	for _, bad := range []string{"!!!", "bm8tY29sb24", "eDpzZXNz"} {
+		if _, err := ParseSessionCursor(bad); err == nil {
+			t.Errorf("cursor %q accepted", bad)
+		}
+	}
+}
+
+func TestListSessionsSinceSyncsIncrementally(t *testing.T) {
+	c := testClickHouse(t)
+	ctx := context.Background()
+	start := time.Now().UTC().Truncate(time.Second).Add(-time.Hour)
+
+	// Pairs of sessions share a start time, so pages split ties by session_id
+	insert := func(from, to int) {
+		for i := from; i < to; i++ {
+			err := c.UpsertSession(ctx, SessionRow{
+				SessionID: fmt.Sprintf("sess-%03d", i),
+				ProjectID: "proj",
+				StartedAt: start.Add(time.Duration(i/2) * time.Second),
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	seen := make(map[string]int)
+	var cursor SessionCursor
+	syncAll := func() {
+		for pages := 0; ; pages++ {
+			if pages > 20 {
+				t.Fatal("sync did not end")
+			}
+			sessions, next, err := c.ListSessionsSince(ctx, "proj", cursor, 3, false)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(sessions) == 0 {
+				if next != cursor {
+					t.Errorf("cursor moved to %+v without new sessions", next)
+				}
+				return
+			}
+			for _, s := range sessions {
+				seen[s.SessionID]++
+			}
+			cursor = next
+		}
+	}
+
+	insert(0, 10)
+	syncAll()
+	// Updating a synced session doesn't return it again
+	insert(9, 10)
+	insert(10, 17)
+	syncAll()
+
+	if len(seen) != 17 {
+		t.Errorf("synced %d sessions, want 17", len(seen))
+	}
+	for sessionID, n := range seen {
+		if n != 1 {
+			t.Errorf("%s synced %d times", sessionID, n)
+		}
+	}
+}
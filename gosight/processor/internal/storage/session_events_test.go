@@ -0,0 +1,79 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+func TestGetSessionEventsPagePagesInOrder(t *testing.T) {
+	c := testClickHouse(t)
+	ctx := context.Background()
+	start := time.Now().UTC().Truncate(time.Second)
+
+	// Pairs of events share a timestamp, so pages split ties by event_id
+	var rows []EventRow
+	for i := 0; i < 25; i++ {
+		rows = append(rows, EventRow{
+			EventID:   uuid.New().String(),
+			ProjectID: "proj",
+			SessionID: "sess",
+			EventType: "click",
+			Timestamp: start.Add(time.Duration(i/2) * time.Second),
+			Payload:   fmt.Sprintf(`{"n":%d}`, i),
+		})
+	}
+	// Another session's events are not returned
+	rows = append(rows, EventRow{EventID: uuid.New().String(), ProjectID: "proj", SessionID: "other", EventType: "click", Timestamp: start})
+	if err := c.InsertEvents(ctx, rows); err != nil {
+		t.Fatal(err)
+	}
+
+	all, err := c.GetSessionEvents(ctx, "proj", "sess")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(all) != 25 {
+		t.Fatalf("got %d events, want 25", len(all))
+	}
+
+	var paged []EventRow
+	var afterTimestamp time.Time
+	var afterEventID string
+	for pages := 0; ; pages++ {
+		if pages > 10 {
+			t.Fatal("paging did not end")
+		}
+		page, err := c.GetSessionEventsPage(ctx, "proj", "sess", afterTimestamp, afterEventID, 4)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(page) == 0 {
+			break
+		}
+		if len(page) > 4 {
+			t.Fatalf("page of %d events, want at most 4", len(page))
+		}
+		paged = append(paged, page...)
+		last := page[len(page)-1]
+		afterTimestamp, afterEventID = last.Timestamp, last.EventID
+	}
+
+	if len(paged) != len(all) {
+		t.Fatalf("paged %d events, want %d", len(paged), len(all))
+	}
+	for i := range all {
+		if paged[i].EventID != all[i].EventID {
+			t.Fatalf("event %d = %s, want %s", i, paged[i].EventID, all[i].EventID)
+		}
+		if i > 0 && paged[i].Timestamp.Before(paged[i-1].Timestamp) {
+			t.Fatalf("event %d out of timestamp order", i)
+		}
+	}
+	if paged[0].PayloadData == nil {
+		t.Error("payload not decoded")
+	}
+}
@@ -0,0 +1,69 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestSessionMetricsRejectsUnknownSegments(t *testing.T) {
+	c := &ClickHouse{}
+	for _, groupBy := range []string{"", "user_id", "country; DROP TABLE sessions"} {
+		if _, err := c.SessionMetrics(context.Background(), "proj", time.Time{}, time.Now(), groupBy, false); err == nil {
+			t.Errorf("groupBy %q accepted", groupBy)
+		}
+	}
+}
+
+func TestSessionMetricsPerSegment(t *testing.T) {
+	c := testClickHouse(t)
+	ctx := context.Background()
+	from := time.Now().UTC().Truncate(time.Hour).Add(-24 * time.Hour)
+
+	sessions := []SessionRow{
+		{DeviceType: "desktop", DurationMs: 10000},
+		{DeviceType: "desktop", DurationMs: 20000},
+		{DeviceType: "desktop", DurationMs: 0, IsBounced: 1},
+		{DeviceType: "desktop", DurationMs: 30000},
+		{DeviceType: "mobile", DurationMs: 0, IsBounced: 1},
+		{DeviceType: "mobile", DurationMs: 4000},
+		// Left out: synthetic, and started outside the range
+		{DeviceType: "desktop", DurationMs: 99000, IsSynthetic: 1},
+		{DeviceType: "mobile", DurationMs: 99000, StartedAt: from.Add(-time.Hour)},
+	}
+	for i, s := range sessions {
+		s.SessionID = fmt.Sprintf("sess-%d", i)
+		s.ProjectID = "proj"
+		if s.StartedAt.IsZero() {
+			s.StartedAt = from.Add(time.Duration(i) * time.Minute)
+		}
+		if err := c.UpsertSession(ctx, s); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	metrics, err := c.SessionMetrics(ctx, "proj", from, from.Add(24*time.Hour), "device_type", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(metrics) != 2 {
+		t.Fatalf("got %d segments, want 2: %+v", len(metrics), metrics)
+	}
+
+	desktop, mobile := metrics[0], metrics[1]
+	if desktop.Segment != "desktop" || desktop.Sessions != 4 || desktop.BounceRate != 0.25 || desktop.AvgDurationMs != 15000 {
+		t.Errorf("desktop = %+v, want 4 sessions, 0.25 bounce rate, 15000ms average", desktop)
+	}
+	if mobile.Segment != "mobile" || mobile.Sessions != 2 || mobile.BounceRate != 0.5 || mobile.AvgDurationMs != 2000 {
+		t.Errorf("mobile = %+v, want 2 sessions, 0.5 bounce rate, 2000ms average", mobile)
+	}
+
+	withSynthetic, err := c.SessionMetrics(ctx, "proj", from, from.Add(24*time.Hour), "device_type", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if withSynthetic[0].Sessions != 5 {
+		t.Errorf("desktop sessions with synthetic = %d, want 5", withSynthetic[0].Sessions)
+	}
+}
@@ -0,0 +1,242 @@
+package storage
+
+import (
+	"context"
+	"encoding/json"
+	"slices"
+	"sort"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/gosight/gosight/processor/internal/config"
+)
+
+// Kinds of timeline entries, in the order of entries at the same timestamp
+const (
+	TimelineReplayChunk = "replay_chunk"
+	TimelineEvent       = "event"
+	TimelineInsight     = "insight"
+)
+
+var timelineKindOrder = map[string]int{TimelineReplayChunk: 0, TimelineEvent: 1, TimelineInsight: 2}
+
+// TimelineEntry is a replay chunk boundary, event or insight of a session
+// timeline. Timestamp is on the client clock, as replay chunks are.
+type TimelineEntry struct {
+	Kind      string
+	Timestamp time.Time
+	Offset    time.Duration // since Timeline.Start: the position on the replay player's timeline
+
+	Chunk   *ReplayChunkRow // replay_chunk entries; Data is not loaded
+	Event   *EventRow       // event entries
+	Insight *InsightRow     // insight entries
+}
+
+// Timeline is a session's replay chunks, events and insights merged in
+// timestamp order, so a replay player can annotate its timeline (e.g. with
+// rage click markers). Everything is placed on the client clock, the clock of
+// rrweb replay data: events by their client timestamp, insights at their last
+// related event, or at their detection time shifted by ClockSkew.
+type Timeline struct {
+	ProjectID string
+	SessionID string
+	Start     time.Time
+	End       time.Time
+
+	Replay *ReplayManifest // nil when the session has no replay
+
+	// Median client minus server clock difference of the session's events
+	ClockSkew time.Duration
+
+	Entries []TimelineEntry
+}
+
+// SetTimestampSource tells reads which event timestamp column holds the client
+// time: timestamp with config.TimestampSourceClient (the default), else
+// secondary_timestamp. It must match the event processor's
+// storage.timestamp_source.
+func (c *ClickHouse) SetTimestampSource(source string) {
+	c.timestampSource = source
+}
+
+// GetSessionTimeline merges a session's replay chunk boundaries, events and
+// insights into a single timeline
+func (c *ClickHouse) GetSessionTimeline(ctx context.Context, projectID, sessionID string) (Timeline, error) {
+	timeline := Timeline{ProjectID: projectID, SessionID: sessionID}
+
+	manifest, err := c.GetReplayManifest(ctx, projectID, sessionID)
+	if err != nil {
+		return Timeline{}, err
+	}
+	timeline.Replay = manifest
+
+	chunks, err := c.getReplayChunkBounds(ctx, projectID, sessionID)
+	if err != nil {
+		return Timeline{}, err
+	}
+	events, err := c.GetSessionEvents(ctx, projectID, sessionID)
+	if err != nil {
+		return Timeline{}, err
+	}
+	insights, err := c.getSessionInsights(ctx, projectID, sessionID)
+	if err != nil {
+		return Timeline{}, err
+	}
+
+	for i := range chunks {
+		timeline.Entries = append(timeline.Entries, TimelineEntry{
+			Kind:      TimelineReplayChunk,
+			Timestamp: chunks[i].TimestampStart,
+			Chunk:     &chunks[i],
+		})
+	}
+
+	var skews []time.Duration
+	eventTimes := make(map[string]time.Time, len(events))
+	for i := range events {
+		client, server := c.eventClientTime(events[i])
+		if !server.IsZero() {
+			skews = append(skews, client.Sub(server))
+		}
+		eventTimes[events[i].EventID] = client
+		timeline.Entries = append(timeline.Entries, TimelineEntry{
+			Kind:      TimelineEvent,
+			Timestamp: client,
+			Event:     &events[i],
+		})
+	}
+	if len(skews) > 0 {
+		slices.Sort(skews)
+		timeline.ClockSkew = skews[len(skews)/2]
+	}
+
+	for i := range insights {
+		timeline.Entries = append(timeline.Entries, TimelineEntry{
+			Kind:      TimelineInsight,
+			Timestamp: insightClientTime(insights[i], eventTimes, timeline.ClockSkew),
+			Insight:   &insights[i],
+		})
+	}
+
+	sort.SliceStable(timeline.Entries, func(i, j int) bool {
+		a, b := timeline.Entries[i], timeline.Entries[j]
+		if !a.Timestamp.Equal(b.Timestamp) {
+			return a.Timestamp.Before(b.Timestamp)
+		}
+		return timelineKindOrder[a.Kind] < timelineKindOrder[b.Kind]
+	})
+
+	if len(timeline.Entries) > 0 {
+		timeline.Start = timeline.Entries[0].Timestamp
+		for i := range timeline.Entries {
+			e := &timeline.Entries[i]
+			e.Offset = e.Timestamp.Sub(timeline.Start)
+			timeline.End = maxTime(timeline.End, e.Timestamp)
+			if e.Chunk != nil {
+				timeline.End = maxTime(timeline.End, e.Chunk.TimestampEnd)
+			}
+		}
+	}
+
+	return timeline, nil
+}
+
+// eventClientTime returns the client and server time of an event; the server
+// time is zero for rows stored without secondary_timestamp
+func (c *ClickHouse) eventClientTime(e EventRow) (client, server time.Time) {
+	if c.timestampSource == config.TimestampSourceServer {
+		if e.SecondaryTimestamp.IsZero() {
+			return e.Timestamp, time.Time{}
+		}
+		return e.SecondaryTimestamp, e.Timestamp
+	}
+	return e.Timestamp, e.SecondaryTimestamp
+}
+
+// insightClientTime places an insight at its last related event of the
+// session, or else at its detection time (server clock) shifted by skew
+func insightClientTime(insight InsightRow, eventTimes map[string]time.Time, skew time.Duration) time.Time {
+	var last time.Time
+	for _, id := range insight.RelatedEventIDs {
+		if t, ok := eventTimes[id]; ok {
+			last = maxTime(last, t)
+		}
+	}
+	if !last.IsZero() {
+		return last
+	}
+	return insight.Timestamp.Add(skew)
+}
+
+func maxTime(a, b time.Time) time.Time {
+	if b.After(a) {
+		return b
+	}
+	return a
+}
+
+// getReplayChunkBounds returns a session's replay chunks without their data,
+// by index; a chunk index received again (a client retry) is listed once
+func (c *ClickHouse) getReplayChunkBounds(ctx context.Context, projectID, sessionID string) ([]ReplayChunkRow, error) {
+	rows, err := c.conn.Query(ctx, `
+		SELECT chunk_index, any(timestamp_start), any(timestamp_end), max(has_full_snapshot)
+		FROM replay_chunks
+		WHERE project_id = ? AND session_id = ?
+		GROUP BY chunk_index
+		ORDER BY chunk_index
+	`, projectID, sessionID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var chunks []ReplayChunkRow
+	for rows.Next() {
+		chunk := ReplayChunkRow{ProjectID: projectID, SessionID: sessionID}
+		if err := rows.Scan(&chunk.ChunkIndex, &chunk.TimestampStart, &chunk.TimestampEnd, &chunk.HasFullSnapshot); err != nil {
+			return nil, err
+		}
+		chunks = append(chunks, chunk)
+	}
+	return chunks, rows.Err()
+}
+
+// getSessionInsights returns a session's insights by detection time
+func (c *ClickHouse) getSessionInsights(ctx context.Context, projectID, sessionID string) ([]InsightRow, error) {
+	rows, err := c.conn.Query(ctx, `
+		SELECT toString(insight_id), insight_type, timestamp, url, path, x, y,
+			target_selector, normalized_selector, details, related_event_ids, confidence
+		FROM insights
+		WHERE project_id = ? AND session_id = ?
+		ORDER BY timestamp
+	`, projectID, sessionID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var insights []InsightRow
+	for rows.Next() {
+		insight := InsightRow{ProjectID: projectID, SessionID: sessionID}
+		var insightID, details string
+		var x, y int32
+		if err := rows.Scan(
+			&insightID, &insight.InsightType, &insight.Timestamp, &insight.URL, &insight.Path, &x, &y,
+			&insight.TargetSelector, &insight.NormalizedSelector, &details, &insight.RelatedEventIDs,
+			&insight.Confidence,
+		); err != nil {
+			return nil, err
+		}
+		insight.InsightID, _ = uuid.Parse(insightID)
+		// Insights without a position are stored at (0, 0)
+		if x != 0 || y != 0 {
+			xi, yi := int(x), int(y)
+			insight.X, insight.Y = &xi, &yi
+		}
+		// Keep the insight even if its details are not valid JSON
+		json.Unmarshal([]byte(details), &insight.Details)
+		insights = append(insights, insight)
+	}
+	return insights, rows.Err()
+}
@@ -0,0 +1,156 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gosight/gosight/processor/internal/config"
+)
+
+// schemaFile is the ClickHouse schema deployments are initialized with
+const schemaFile = "../../../scripts/init-clickhouse.sql"
+
+// testClickHouse creates a scratch database from schemaFile on the ClickHouse
+// at CLICKHOUSE_ADDR (default localhost:9000), skipping the test when none is
+// reachable
+func testClickHouse(t *testing.T) *ClickHouse {
+	t.Helper()
+	addr := os.Getenv("CLICKHOUSE_ADDR")
+	if addr == "" {
+		addr = "localhost:9000"
+	}
+	admin, err := NewClickHouse(config.ClickHouseConfig{Addr: addr, Database: "default"})
+	if err != nil {
+		t.Skipf("ClickHouse unavailable at %s: %v", addr, err)
+	}
+	t.Cleanup(func() { admin.conn.Close() })
+
+	ctx := context.Background()
+	database := fmt.Sprintf("gosight_test_%d", time.Now().UnixNano())
+	if err := admin.conn.Exec(ctx, "CREATE DATABASE "+database); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { admin.conn.Exec(ctx, "DROP DATABASE "+database) })
+
+	for _, statement := range schemaStatements(t, database) {
+		if err := admin.conn.Exec(ctx, statement); err != nil {
+			t.Fatalf("%v\n%s", err, statement)
+		}
+	}
+
+	c, err := NewClickHouse(config.ClickHouseConfig{Addr: addr, Database: database})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { c.conn.Close() })
+	return c
+}
+
+// schemaStatements returns the statements of schemaFile, without comments,
+// creating the tables of the gosight database in database instead
+func schemaStatements(t *testing.T, database string) []string {
+	t.Helper()
+	data, err := os.ReadFile(schemaFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var schema strings.Builder
+	for _, line := range strings.Split(string(data), "\n") {
+		if i := strings.Index(line, "--"); i >= 0 {
+			line = line[:i]
+		}
+		schema.WriteString(line + "\n")
+	}
+
+	var statements []string
+	for _, statement := range strings.Split(schema.String(), ";") {
+		statement = strings.TrimSpace(statement)
+		if statement == "" || strings.HasPrefix(statement, "CREATE DATABASE") {
+			continue
+		}
+		statements = append(statements, strings.ReplaceAll(statement, "gosight.", database+"."))
+	}
+	return statements
+}
+
+// seedVitals inserts one web vitals row per LCP value, spread over the hour
+// starting at period
+func seedVitals(t *testing.T, c *ClickHouse, period time.Time, lcps ...float64) {
+	t.Helper()
+	rows := make([]WebVitalsRow, len(lcps))
+	for i, lcp := range lcps {
+		rows[i] = WebVitalsRow{
+			ProjectID: "proj",
+			SessionID: fmt.Sprintf("sess-%d", i),
+			PageURL:   "https://example.com/checkout",
+			PagePath:  "/checkout",
+			Timestamp: period.Add(time.Duration(i) * time.Second),
+			LCP:       &lcp,
+		}
+	}
+	if err := c.InsertWebVitals(context.Background(), rows); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestWebVitalsRollupIncludesLateVitals(t *testing.T) {
+	c := testClickHouse(t)
+	ctx := context.Background()
+	period := time.Now().UTC().Truncate(time.Hour).Add(-2 * time.Hour)
+	next := period.Add(time.Hour)
+
+	lcps := make([]float64, 100)
+	for i := range lcps {
+		lcps[i] = float64(i + 1)
+	}
+	seedVitals(t, c, period, lcps...)
+
+	if stale, err := c.WebVitalsRollupStale(ctx, period, next); err != nil || !stale {
+		t.Fatalf("stale = %v, %v before the first rollup, want true", stale, err)
+	}
+	if err := c.RollupWebVitals(ctx, period, next); err != nil {
+		t.Fatal(err)
+	}
+
+	p, err := c.GetWebVitalsPercentiles(ctx, "proj", "/checkout", period, next)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.Samples != 100 {
+		t.Errorf("samples = %d, want 100", p.Samples)
+	}
+	if math.Abs(p.LCP.P50-50) > 2 || math.Abs(p.LCP.P95-95) > 2 {
+		t.Errorf("LCP = %+v, want p50 ~50 and p95 ~95", p.LCP)
+	}
+
+	// Vitals of the period arriving after its rollup make it stale again
+	late := make([]float64, 100)
+	for i := range late {
+		late[i] = 1000
+	}
+	seedVitals(t, c, period, late...)
+
+	if stale, err := c.WebVitalsRollupStale(ctx, period, next); err != nil || !stale {
+		t.Fatalf("stale = %v, %v after late vitals, want true", stale, err)
+	}
+	if err := c.RollupWebVitals(ctx, period, next); err != nil {
+		t.Fatal(err)
+	}
+
+	p, err = c.GetWebVitalsPercentiles(ctx, "proj", "/checkout", period, next)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.Samples != 200 {
+		t.Errorf("samples = %d, want 200 (rolled up again, not added twice)", p.Samples)
+	}
+	if p.LCP.P95 < 900 {
+		t.Errorf("LCP p95 = %v, want the late vitals included", p.LCP.P95)
+	}
+}
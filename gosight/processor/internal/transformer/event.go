@@ -1,11 +1,21 @@
 package transformer
 
 import (
+	"crypto/sha1"
+	"encoding/hex"
 	"encoding/json"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"sync/atomic"
 	"time"
 
 	"github.com/google/uuid"
 
+	"github.com/gosight/gosight/processor/internal/config"
+	"github.com/gosight/gosight/processor/internal/eventtype"
+	"github.com/gosight/gosight/processor/internal/selector"
 	"github.com/gosight/gosight/processor/internal/storage"
 )
 
@@ -17,6 +27,7 @@ type EnrichedEvent struct {
 	ProjectID       string                 `json:"project_id"`
 	SessionID       string                 `json:"session_id"`
 	UserID          string                 `json:"user_id"`
+	AnonymousID     string                 `json:"anonymous_id"`
 	Page            map[string]interface{} `json:"page"`
 	Payload         map[string]interface{} `json:"payload"`
 	ServerTimestamp int64                  `json:"server_timestamp"`
@@ -28,6 +39,9 @@ type EnrichedEvent struct {
 	Country         string                 `json:"country"`
 	City            string                 `json:"city"`
 	ClientIP        string                 `json:"client_ip"`
+	UserAgent       string                 `json:"user_agent"`
+	Synthetic       bool                   `json:"synthetic"`
+	AnalyticsDenied bool                   `json:"-"` // consent.analytics was false
 }
 
 // TransformResult contains the transformed data for different tables
@@ -36,23 +50,48 @@ type TransformResult struct {
 	PageView  *storage.PageViewRow
 	WebVitals *storage.WebVitalsRow
 	Error     *storage.ErrorRow
+	LongTask  *storage.LongTaskRow
+
+	// Pre-aggregated metric of an aggregate event, which has no Event row
+	Aggregate *storage.AggregateMetricRow
+
+	// Page timing signals
+	Visibility string // "hidden" or "visible" for visibility events
+	PageExit   bool
 }
 
-// TransformEvent transforms a raw event from Kafka to ClickHouse row structures
-func TransformEvent(raw map[string]interface{}) (*TransformResult, error) {
+// TransformEvent transforms a raw event from Kafka to ClickHouse row structures.
+// Target selectors are normalized with normalizer, which may be nil.
+// timestampSource selects the primary timestamp (config.TimestampSourceClient or
+// config.TimestampSourceServer); the other one goes to SecondaryTimestamp.
+func TransformEvent(raw map[string]interface{}, normalizer *selector.Normalizer, timestampSource string) (*TransformResult, error) {
 	result := &TransformResult{}
 
 	// Parse the enriched event
 	event := parseEnrichedEvent(raw)
 
+	// Aggregate events carry a metric rather than an interaction; they are
+	// stored in aggregate_metrics only, outside of any session
+	if eventtype.Normalize(event.Type) == eventtype.Aggregate {
+		aggregate, err := parseAggregate(event)
+		if err != nil {
+			return nil, err
+		}
+		result.Aggregate = aggregate
+		return result, nil
+	}
+
+	timestamp, secondaryTimestamp := eventTimestamps(event, timestampSource)
+
 	// Create base event row
 	eventRow := &storage.EventRow{
 		EventID:        event.EventID,
 		ProjectID:      event.ProjectID,
 		SessionID:      event.SessionID,
 		UserID:         event.UserID,
+		AnonymousID:    event.AnonymousID,
 		EventType:      event.Type,
-		Timestamp:      time.UnixMilli(event.Timestamp),
+		Timestamp:      timestamp,
 		Browser:        event.Browser,
 		BrowserVersion: event.BrowserVersion,
 		OS:             event.OS,
@@ -60,6 +99,19 @@ func TransformEvent(raw map[string]interface{}) (*TransformResult, error) {
 		DeviceType:     event.DeviceType,
 		Country:        event.Country,
 		City:           event.City,
+		ClientIP:       event.ClientIP,
+		UserAgent:      event.UserAgent,
+
+		SecondaryTimestamp: secondaryTimestamp,
+	}
+	if event.ServerTimestamp > 0 {
+		eventRow.ServerTimestamp = time.UnixMilli(event.ServerTimestamp)
+	}
+	if event.Synthetic {
+		eventRow.IsSynthetic = 1
+	}
+	if event.AnalyticsDenied {
+		eventRow.AnalyticsDenied = 1
 	}
 
 	// Parse page info
@@ -70,10 +122,34 @@ func TransformEvent(raw map[string]interface{}) (*TransformResult, error) {
 		eventRow.Referrer = getString(event.Page, "referrer")
 
 		// Get viewport dimensions
-		eventRow.ViewportWidth = getUint16(event.Page, "viewport_width")
-		eventRow.ViewportHeight = getUint16(event.Page, "viewport_height")
-		eventRow.ScreenWidth = getUint16(event.Page, "screen_width")
-		eventRow.ScreenHeight = getUint16(event.Page, "screen_height")
+		eventRow.ViewportWidth = getDimension(event.Page, "viewport_width")
+		eventRow.ViewportHeight = getDimension(event.Page, "viewport_height")
+		eventRow.ScreenWidth = getDimension(event.Page, "screen_width")
+		eventRow.ScreenHeight = getDimension(event.Page, "screen_height")
+
+		eventRow.DevicePixelRatio = parseDevicePixelRatio(event.Page)
+		eventRow.ConnectionType = parseConnectionType(event.Page)
+	}
+
+	if eventtype.Normalize(event.Type) == eventtype.Custom && event.Payload != nil {
+		eventRow.CustomEventName = getString(event.Payload, "name")
+	}
+
+	// Keep the raw selector and add a stable one for grouping
+	if event.Payload != nil && normalizer != nil {
+		if sel := getString(event.Payload, "target_selector"); sel != "" {
+			event.Payload["normalized_selector"] = normalizer.Normalize(sel)
+		}
+	}
+
+	// Bound target_classes before storing (a nil normalizer uses the default limits)
+	if classes, ok := event.Payload["target_classes"].([]interface{}); ok {
+		event.Payload["target_classes"] = normalizer.LimitClasses(classes)
+	}
+
+	// Store click interactivity hints as booleans
+	if event.Payload != nil {
+		normalizeClickHints(event.Payload)
 	}
 
 	// Store payload as JSON
@@ -84,9 +160,9 @@ func TransformEvent(raw map[string]interface{}) (*TransformResult, error) {
 
 	result.Event = eventRow
 
-	// Handle specific event types (support both proto enum names and simple names)
-	switch event.Type {
-	case "page_view", "EVENT_TYPE_PAGE_VIEW":
+	// Handle specific event types
+	switch eventtype.Normalize(event.Type) {
+	case eventtype.PageView:
 		result.PageView = &storage.PageViewRow{
 			ProjectID:      event.ProjectID,
 			SessionID:      event.SessionID,
@@ -96,13 +172,13 @@ func TransformEvent(raw map[string]interface{}) (*TransformResult, error) {
 			PageTitle:      eventRow.PageTitle,
 			Referrer:       eventRow.Referrer,
 			Timestamp:      eventRow.Timestamp,
-			TimeOnPageMs:   0, // Will be calculated later or from payload
-			MaxScrollDepth: 0, // Will be updated from scroll events
+			TimeOnPageMs:   0, // Set by the processor's PageTimer when the page ends
+			MaxScrollDepth: 0, // Set from scroll events by the session aggregator
 			DeviceType:     event.DeviceType,
 			Country:        event.Country,
 		}
 
-	case "web_vitals", "EVENT_TYPE_WEB_VITALS":
+	case eventtype.WebVitals:
 		if event.Payload != nil {
 			webVitals := &storage.WebVitalsRow{
 				ProjectID:  event.ProjectID,
@@ -112,6 +188,9 @@ func TransformEvent(raw map[string]interface{}) (*TransformResult, error) {
 				Timestamp:  eventRow.Timestamp,
 				DeviceType: event.DeviceType,
 				Country:    event.Country,
+
+				DevicePixelRatio: eventRow.DevicePixelRatio,
+				ConnectionType:   eventRow.ConnectionType,
 			}
 
 			// Handle individual metric format: {"metric":"LCP","value":732}
@@ -144,7 +223,36 @@ func TransformEvent(raw map[string]interface{}) (*TransformResult, error) {
 			result.WebVitals = webVitals
 		}
 
-	case "js_error", "EVENT_TYPE_JS_ERROR":
+	case eventtype.VisibilityChange:
+		if event.Payload != nil {
+			result.Visibility = parseVisibility(event.Payload)
+		}
+
+	case eventtype.PageHidden:
+		result.Visibility = "hidden"
+
+	case eventtype.PageVisible:
+		result.Visibility = "visible"
+
+	case eventtype.PageExit:
+		result.PageExit = true
+
+	case eventtype.LongTask:
+		// Payload: {"duration":120,"attribution":[{"container_type":"iframe",...}]}
+		if duration := getFloat64Ptr(event.Payload, "duration"); duration != nil && *duration > 0 {
+			result.LongTask = &storage.LongTaskRow{
+				ProjectID:   event.ProjectID,
+				SessionID:   event.SessionID,
+				PageURL:     eventRow.PageURL,
+				PagePath:    eventRow.PagePath,
+				Timestamp:   eventRow.Timestamp,
+				DurationMs:  *duration,
+				Attribution: parseLongTaskAttribution(event.Payload["attribution"]),
+				DeviceType:  event.DeviceType,
+			}
+		}
+
+	case eventtype.JSError:
 		if event.Payload != nil {
 			result.Error = &storage.ErrorRow{
 				ProjectID: event.ProjectID,
@@ -163,15 +271,15 @@ func TransformEvent(raw map[string]interface{}) (*TransformResult, error) {
 			}
 		}
 
-	case "EVENT_TYPE_CUSTOM":
+	case eventtype.Custom:
 		if event.Payload != nil {
 			// Check the "name" field to determine the actual event type
 			// SDK sends: {"name":"web_vitals","properties":{"lcp":...}}
 			name := getString(event.Payload, "name")
 			properties, hasProperties := event.Payload["properties"].(map[string]interface{})
 
-			switch name {
-			case "web_vitals":
+			switch eventtype.Normalize(name) {
+			case eventtype.WebVitals:
 				// Custom tracked web_vitals
 				if hasProperties {
 					result.WebVitals = &storage.WebVitalsRow{
@@ -188,10 +296,13 @@ func TransformEvent(raw map[string]interface{}) (*TransformResult, error) {
 						INP:        getFloat64Ptr(properties, "inp"),
 						DeviceType: event.DeviceType,
 						Country:    event.Country,
+
+						DevicePixelRatio: eventRow.DevicePixelRatio,
+						ConnectionType:   eventRow.ConnectionType,
 					}
 				}
 
-			case "js_error":
+			case eventtype.JSError:
 				// Custom tracked error
 				if hasProperties {
 					result.Error = &storage.ErrorRow{
@@ -234,9 +345,82 @@ func TransformEvent(raw map[string]interface{}) (*TransformResult, error) {
 		}
 	}
 
+	if result.Error != nil {
+		result.Error.Fingerprint = ErrorFingerprint(result.Error)
+		result.Error.OccurrenceCount = 1
+	}
+
 	return result, nil
 }
 
+// parseAggregate reads the metric of an aggregate event from its payload:
+// {"metric":"checkout_completed","value":120,"dimensions":{"plan":"pro"},
+// "bucket_start":1700000040000,"bucket_seconds":60}. bucket_start (unix ms)
+// defaults to the event timestamp. Dimension values are stored as strings.
+func parseAggregate(event *EnrichedEvent) (*storage.AggregateMetricRow, error) {
+	if event.Payload == nil {
+		return nil, fmt.Errorf("aggregate event %s has no payload", event.EventID)
+	}
+
+	metric := getString(event.Payload, "metric")
+	if metric == "" {
+		return nil, fmt.Errorf("aggregate event %s has no metric", event.EventID)
+	}
+	value, ok := event.Payload["value"].(float64)
+	if !ok || math.IsNaN(value) || math.IsInf(value, 0) {
+		return nil, fmt.Errorf("aggregate event %s has no numeric value", event.EventID)
+	}
+
+	bucketStart := event.Timestamp
+	if v, ok := event.Payload["bucket_start"].(float64); ok {
+		bucketStart = int64(v)
+	}
+
+	row := &storage.AggregateMetricRow{
+		ProjectID:     event.ProjectID,
+		MetricName:    metric,
+		BucketStart:   time.UnixMilli(bucketStart).UTC(),
+		BucketSeconds: getUint32(event.Payload, "bucket_seconds"),
+		Value:         value,
+	}
+	if event.Synthetic {
+		row.IsSynthetic = 1
+	}
+
+	if dims, ok := event.Payload["dimensions"].(map[string]interface{}); ok {
+		row.Dimensions = make(map[string]string, len(dims))
+		for k, v := range dims {
+			switch x := v.(type) {
+			case string:
+				row.Dimensions[k] = x
+			case float64:
+				row.Dimensions[k] = strconv.FormatFloat(x, 'f', -1, 64)
+			case bool:
+				row.Dimensions[k] = strconv.FormatBool(x)
+			default:
+				return nil, fmt.Errorf("aggregate event %s: dimension %q is not a string, number or bool", event.EventID, k)
+			}
+		}
+	}
+
+	return row, nil
+}
+
+// eventTimestamps returns the primary and secondary timestamps of an event.
+// Events without a server timestamp fall back to the client one.
+func eventTimestamps(event *EnrichedEvent, source string) (time.Time, time.Time) {
+	client := time.UnixMilli(event.Timestamp)
+	server := client
+	if event.ServerTimestamp > 0 {
+		server = time.UnixMilli(event.ServerTimestamp)
+	}
+
+	if source == config.TimestampSourceServer {
+		return server, client
+	}
+	return client, server
+}
+
 func parseEnrichedEvent(raw map[string]interface{}) *EnrichedEvent {
 	event := &EnrichedEvent{}
 
@@ -266,6 +450,9 @@ func parseEnrichedEvent(raw map[string]interface{}) *EnrichedEvent {
 	if v, ok := raw["user_id"].(string); ok {
 		event.UserID = v
 	}
+	if v, ok := raw["anonymous_id"].(string); ok {
+		event.AnonymousID = v
+	}
 	if v, ok := raw["page"].(map[string]interface{}); ok {
 		event.Page = v
 	}
@@ -299,10 +486,90 @@ func parseEnrichedEvent(raw map[string]interface{}) *EnrichedEvent {
 	if v, ok := raw["client_ip"].(string); ok {
 		event.ClientIP = v
 	}
+	if v, ok := raw["user_agent"].(string); ok {
+		event.UserAgent = v
+	}
+	if v, ok := raw["synthetic"].(bool); ok {
+		event.Synthetic = v
+	}
+	// Consent is resolved by the ingestor; events without it predate consent flags
+	if consent, ok := raw["consent"].(map[string]interface{}); ok {
+		if analytics, ok := consent["analytics"].(bool); ok {
+			event.AnalyticsDenied = !analytics
+		}
+	}
 
 	return event
 }
 
+// parseVisibility accepts {"state":"hidden"}, {"visibility_state":"hidden"} or {"hidden":true}
+func parseVisibility(payload map[string]interface{}) string {
+	state := getString(payload, "state")
+	if state == "" {
+		state = getString(payload, "visibility_state")
+	}
+	if state == "" {
+		if hidden, ok := payload["hidden"].(bool); ok {
+			if hidden {
+				return "hidden"
+			}
+			return "visible"
+		}
+	}
+
+	switch state {
+	case "hidden", "visible":
+		return state
+	}
+	return ""
+}
+
+// ErrorFingerprint identifies occurrences of the same error: the type, message,
+// source location and the top stack frame, which tells apart errors with a
+// generic message thrown from different places
+func ErrorFingerprint(e *storage.ErrorRow) string {
+	h := sha1.New()
+	for _, part := range []string{e.ProjectID, e.ErrorType, e.Message, e.Source,
+		strconv.FormatUint(uint64(e.Line), 10), strconv.FormatUint(uint64(e.Col), 10), topStackFrame(e.Stack)} {
+		h.Write([]byte(part))
+		h.Write([]byte{0})
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// topStackFrame returns the first frame of a stack trace: the first "at ..."
+// line of a V8 stack (which starts with the message) or the first line of a
+// Firefox/Safari one ("fn@url:line:col")
+func topStackFrame(stack string) string {
+	lines := strings.Split(stack, "\n")
+	for _, line := range lines {
+		line = strings.TrimSpace(line)
+		if strings.HasPrefix(line, "at ") || strings.Contains(line, "@") {
+			return line
+		}
+	}
+	return strings.TrimSpace(lines[0])
+}
+
+// normalizeClickHints stores the SDK's optional interactivity hints as
+// had_click_handler and cursor_pointer booleans. cursor_pointer may also be sent
+// as the computed cursor style.
+func normalizeClickHints(payload map[string]interface{}) {
+	if v, ok := payload["cursor"].(string); ok {
+		if _, set := payload["cursor_pointer"]; !set {
+			payload["cursor_pointer"] = v == "pointer"
+		}
+	}
+	for _, key := range []string{"had_click_handler", "cursor_pointer"} {
+		switch v := payload[key].(type) {
+		case string:
+			payload[key] = v == "true" || v == "1"
+		case float64:
+			payload[key] = v != 0
+		}
+	}
+}
+
 func getString(m map[string]interface{}, key string) string {
 	if v, ok := m[key].(string); ok {
 		return v
@@ -310,23 +577,120 @@ func getString(m map[string]interface{}, key string) string {
 	return ""
 }
 
-func getUint16(m map[string]interface{}, key string) uint16 {
-	if v, ok := m[key].(float64); ok {
-		return uint16(v)
+// maxDevicePixelRatio bounds device_pixel_ratio; larger values are bogus
+const maxDevicePixelRatio = 10
+
+// parseDevicePixelRatio reads window.devicePixelRatio sent in the page object,
+// 0 when missing or out of range
+func parseDevicePixelRatio(page map[string]interface{}) float32 {
+	dpr := getFloat64Ptr(page, "device_pixel_ratio")
+	if dpr == nil || *dpr <= 0 || *dpr > maxDevicePixelRatio {
+		return 0
+	}
+	return float32(*dpr)
+}
+
+// maxConnectionTypeLength bounds connection_type, a LowCardinality column
+const maxConnectionTypeLength = 16
+
+// parseConnectionType reads the Network Information API connection type sent
+// in the page object: the effective type (slow-2g, 2g, 3g, 4g) or the physical
+// type (wifi, cellular, ethernet...), lowercased; "" when missing or invalid
+func parseConnectionType(page map[string]interface{}) string {
+	connection := strings.ToLower(strings.TrimSpace(getString(page, "connection_type")))
+	if len(connection) > maxConnectionTypeLength {
+		return ""
+	}
+	for _, c := range connection {
+		if (c < 'a' || c > 'z') && (c < '0' || c > '9') && c != '-' && c != '_' {
+			return ""
+		}
 	}
-	return 0
+	return connection
+}
+
+// maxAttributionLength bounds the attribution of a long task
+const maxAttributionLength = 256
+
+// parseLongTaskAttribution describes the container a long task is attributed
+// to: a string as sent, or the container type, name and src of the first
+// TaskAttributionTiming entry. "" when the task is the page's own (window).
+func parseLongTaskAttribution(v interface{}) string {
+	var attribution string
+	switch v := v.(type) {
+	case string:
+		attribution = v
+	case []interface{}:
+		if len(v) == 0 {
+			return ""
+		}
+		entry, _ := v[0].(map[string]interface{})
+		var parts []string
+		for _, key := range []string{"container_type", "container_name", "container_src"} {
+			if s := getString(entry, key); s != "" {
+				parts = append(parts, s)
+			}
+		}
+		attribution = strings.Join(parts, " ")
+	}
+
+	if attribution == "window" {
+		return ""
+	}
+	if len(attribution) > maxAttributionLength {
+		attribution = attribution[:maxAttributionLength]
+	}
+	return attribution
+}
+
+// maxDimension bounds viewport and screen dimensions (px); larger values are bogus
+const maxDimension = 16384
+
+// outOfRange counts payload numbers read as 0 for being out of range
+var outOfRange atomic.Uint64
+
+// OutOfRangeValues is the number of payload numbers read as 0 (unknown) for
+// being negative or too large for their column
+func OutOfRangeValues() uint64 {
+	return outOfRange.Load()
+}
+
+// getDimension reads a viewport or screen dimension, 0 when missing or out of range
+func getDimension(m map[string]interface{}, key string) uint16 {
+	return uint16(getBounded(m, key, maxDimension))
 }
 
 func getUint32(m map[string]interface{}, key string) uint32 {
-	if v, ok := m[key].(float64); ok {
-		return uint32(v)
+	return uint32(getBounded(m, key, math.MaxUint32))
+}
+
+// getBounded reads a number in [0, max], rather than letting the cast to an
+// unsigned column wrap it around. Missing values are 0; values out of range
+// are counted and also read as 0.
+func getBounded(m map[string]interface{}, key string, max float64) float64 {
+	v, ok := m[key].(float64)
+	if !ok {
+		return 0
+	}
+	if math.IsNaN(v) || v < 0 || v > max {
+		outOfRange.Add(1)
+		return 0
 	}
-	return 0
+	return v
 }
 
+// getFloat64Ptr reads a number, or a numeric string: custom event properties
+// sent over gRPC are a map[string]string
 func getFloat64Ptr(m map[string]interface{}, key string) *float64 {
-	if v, ok := m[key].(float64); ok {
+	switch v := m[key].(type) {
+	case float64:
 		return &v
+	case string:
+		f, err := strconv.ParseFloat(strings.TrimSpace(v), 64)
+		if err != nil || math.IsNaN(f) || math.IsInf(f, 0) {
+			return nil
+		}
+		return &f
 	}
 	return nil
 }
@@ -0,0 +1,71 @@
+package transformer
+
+import (
+	"math"
+	"testing"
+
+	"github.com/gosight/gosight/processor/internal/config"
+)
+
+func TestGetDimension(t *testing.T) {
+	tests := []struct {
+		name     string
+		value    interface{}
+		want     uint16
+		outRange bool
+	}{
+		{"valid", 1920.0, 1920, false},
+		{"zero", 0.0, 0, false},
+		{"at the bound", float64(maxDimension), maxDimension, false},
+		{"overflow", 70000.0, 0, true},
+		{"over the bound", float64(maxDimension + 1), 0, true},
+		{"negative", -5.0, 0, true},
+		{"NaN", math.NaN(), 0, true},
+		{"infinite", math.Inf(1), 0, true},
+		{"missing", nil, 0, false},
+		{"not a number", "1920", 0, false},
+	}
+	for _, tt := range tests {
+		before := OutOfRangeValues()
+		page := map[string]interface{}{}
+		if tt.value != nil {
+			page["viewport_width"] = tt.value
+		}
+		if got := getDimension(page, "viewport_width"); got != tt.want {
+			t.Errorf("%s: got %d, want %d", tt.name, got, tt.want)
+		}
+		if counted := OutOfRangeValues() > before; counted != tt.outRange {
+			t.Errorf("%s: counted as out of range = %v, want %v", tt.name, counted, tt.outRange)
+		}
+	}
+}
+
+func TestTransformEventBoundsDimensions(t *testing.T) {
+	result, err := TransformEvent(map[string]interface{}{
+		"event_id":   "0b6f2c4e-4a8b-4d7e-9c1a-2f3e4d5c6b7a",
+		"type":       "js_error",
+		"project_id": "proj",
+		"session_id": "sess",
+		"timestamp":  1700000000000.0,
+		"page": map[string]interface{}{
+			"url":             "https://example.com/",
+			"viewport_width":  70000.0,
+			"viewport_height": -1.0,
+			"screen_width":    2560.0,
+			"screen_height":   1440.0,
+		},
+		"payload": map[string]interface{}{"message": "boom", "line": -3.0, "column": 5e12},
+	}, nil, config.TimestampSourceClient)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	e := result.Event
+	if e.ViewportWidth != 0 || e.ViewportHeight != 0 || e.ScreenWidth != 2560 || e.ScreenHeight != 1440 {
+		t.Errorf("dimensions = %dx%d viewport, %dx%d screen, want 0x0 and 2560x1440",
+			e.ViewportWidth, e.ViewportHeight, e.ScreenWidth, e.ScreenHeight)
+	}
+	if result.Error == nil || result.Error.Line != 0 || result.Error.Col != 0 {
+		t.Errorf("error = %+v, want line and column 0", result.Error)
+	}
+}
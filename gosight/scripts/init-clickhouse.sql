@@ -16,10 +16,12 @@ CREATE TABLE IF NOT EXISTS gosight.events
     project_id      String,
     session_id      String,
     user_id         String,
+    anonymous_id    String,  -- visitor cookie or client ID; uniq() counts unique visitors
 
     -- Event info
     event_type      LowCardinality(String),  -- click, scroll, error, etc.
     timestamp       DateTime64(3),            -- millisecond precision
+    secondary_timestamp DateTime64(3),        -- server time if timestamp is client time, and vice versa (storage.timestamp_source)
 
     -- Page info
     page_url        String,
@@ -37,6 +39,8 @@ CREATE TABLE IF NOT EXISTS gosight.events
     screen_height   UInt16,
     viewport_width  UInt16,
     viewport_height UInt16,
+    device_pixel_ratio Float32,                 -- 0 if not sent
+    connection_type LowCardinality(String),  -- Network Information API: slow-2g, 2g, 3g, 4g, wifi...
 
     -- Geo info (enriched by ingestor)
     country         LowCardinality(String),
@@ -44,16 +48,50 @@ CREATE TABLE IF NOT EXISTS gosight.events
 
     -- Event payload (JSON)
     payload         String,
+    payload_compressed String CODEC(NONE),  -- gzipped payload when storage.compress_payload is on (payload is then empty)
+    custom_event_name LowCardinality(String),  -- payload name of custom events, readable when the payload is compressed
+
+    -- Raw enrichment inputs (for backfilling geo/UA enrichment). user_agent
+    -- is only filled with the processor's enrichment.store_raw_user_agent; it
+    -- has too many distinct values for LowCardinality, so if storage is a
+    -- concern keep it on a separate table keyed by event_id instead
+    client_ip       String,
+    user_agent      String,
+
+    -- Pipeline latency: ms from ingestor receipt (server_timestamp) to insert
+    processing_lag_ms Int64,
+
+    -- QA / synthetic monitoring traffic (synthetic flag or test API key);
+    -- exclude with is_synthetic = 0
+    is_synthetic    UInt8 DEFAULT 0,
 
     -- Metadata
     created_at      DateTime DEFAULT now()
 )
 ENGINE = MergeTree()
 PARTITION BY toYYYYMM(timestamp)
+-- Session-scoped primary key: session timelines (GetSessionEvents) read
+-- a contiguous, already time-sorted range; keep session_id before timestamp
 ORDER BY (project_id, session_id, timestamp)
 TTL toDateTime(timestamp) + INTERVAL 90 DAY
 SETTINGS index_granularity = 8192;
 
+-- Event category tables (processor storage.event_categories) hold the events
+-- of a category's types in events_<category>, with the columns of events. The
+-- processor creates missing ones as plain copies of events; create them first
+-- to give a category its own TTL, ORDER BY or engine, e.g.:
+--
+-- CREATE TABLE IF NOT EXISTS gosight.events_interaction AS gosight.events
+-- ENGINE = MergeTree()
+-- PARTITION BY toYYYYMM(timestamp)
+-- ORDER BY (project_id, session_id, timestamp)
+-- TTL toDateTime(timestamp) + INTERVAL 30 DAY;
+--
+-- Reads union all event tables through merge(), so column changes (the
+-- ALTERs below) must be applied to every events_<category> table as well.
+-- Each table is inserted into separately, so a failed flush may have stored
+-- the events of some categories; only the others are reported as failed.
+
 -- ===========================================
 -- Sessions Table
 -- Aggregated session data
@@ -90,6 +128,11 @@ CREATE TABLE IF NOT EXISTS gosight.sessions
     -- Flags
     has_replay      UInt8,
     is_bounced      UInt8,
+    is_synthetic    UInt8 DEFAULT 0,  -- any synthetic event
+    analytics_denied UInt8 DEFAULT 0, -- any event without analytics consent (essential events only, no user identifiers)
+
+    -- Raw User-Agent of the first event (enrichment.store_raw_user_agent)
+    user_agent      String,
 
     created_at      DateTime DEFAULT now()
 )
@@ -116,7 +159,8 @@ CREATE TABLE IF NOT EXISTS gosight.page_views
 
     -- Timing
     timestamp       DateTime64(3),
-    time_on_page_ms UInt64,
+    time_on_page_ms UInt64,            -- active time, excluding backgrounded tab
+    raw_time_on_page_ms UInt64,        -- wall clock time, including backgrounded tab
 
     -- Scroll depth
     max_scroll_depth UInt8,  -- 0-100%
@@ -156,6 +200,8 @@ CREATE TABLE IF NOT EXISTS gosight.web_vitals
     -- Device context
     device_type     LowCardinality(String),
     country         LowCardinality(String),
+    device_pixel_ratio Float32,
+    connection_type LowCardinality(String),  -- segments vitals by network quality
 
     created_at      DateTime DEFAULT now()
 )
@@ -164,6 +210,63 @@ PARTITION BY toYYYYMM(timestamp)
 ORDER BY (project_id, page_path, timestamp)
 TTL toDateTime(timestamp) + INTERVAL 90 DAY;
 
+-- ===========================================
+-- Web Vitals Rollup Table
+-- Quantile states per page, written periodically by the event processor.
+-- Rolling a period up again inserts new rows replacing the earlier ones
+-- (latest rolled_at); read with FINAL.
+-- ===========================================
+CREATE TABLE IF NOT EXISTS gosight.web_vitals_rollup
+(
+    project_id      String,
+    page_path       String,
+
+    period_start    DateTime64(3),
+    samples         UInt64,
+    rolled_at       DateTime,  -- when the period was last rolled up
+
+    -- Mergeable p50/p75/p95 states (query with quantilesIfMerge)
+    lcp             AggregateFunction(quantilesIf(0.5, 0.75, 0.95), Float64, UInt8),
+    inp             AggregateFunction(quantilesIf(0.5, 0.75, 0.95), Float64, UInt8),
+    cls             AggregateFunction(quantilesIf(0.5, 0.75, 0.95), Float64, UInt8)
+)
+ENGINE = ReplacingMergeTree(rolled_at)
+PARTITION BY toYYYYMM(period_start)
+ORDER BY (project_id, page_path, period_start)
+TTL toDateTime(period_start) + INTERVAL 365 DAY;
+
+-- Tables created as an AggregatingMergeTree would merge the states of both
+-- rollups of a period; recreate them and copy the rows over:
+--
+-- RENAME TABLE gosight.web_vitals_rollup TO gosight.web_vitals_rollup_old;
+-- (CREATE TABLE above)
+-- INSERT INTO gosight.web_vitals_rollup SELECT * FROM gosight.web_vitals_rollup_old;
+-- DROP TABLE gosight.web_vitals_rollup_old;
+
+-- ===========================================
+-- Aggregate Metrics Table
+-- Pre-aggregated values pushed by server-side integrations (aggregate events)
+-- ===========================================
+CREATE TABLE IF NOT EXISTS gosight.aggregate_metrics
+(
+    project_id      String,
+    metric_name     LowCardinality(String),
+
+    bucket_start    DateTime,   -- aligned to bucket_seconds
+    bucket_seconds  UInt32,
+
+    dimensions      Map(LowCardinality(String), String),  -- bounded by aggregate_metrics.max_dimensions
+    value           Float64,    -- pushes of the same bucket add up (sum on read)
+
+    is_synthetic    UInt8 DEFAULT 0,
+
+    created_at      DateTime DEFAULT now()
+)
+ENGINE = MergeTree()
+PARTITION BY toYYYYMM(bucket_start)
+ORDER BY (project_id, metric_name, bucket_start)
+TTL bucket_start + INTERVAL 365 DAY;
+
 -- ===========================================
 -- Replay Chunks Table
 -- Session replay data (compressed)
@@ -188,6 +291,32 @@ PARTITION BY toYYYYMM(timestamp_start)
 ORDER BY (project_id, session_id, chunk_index)
 TTL toDateTime(timestamp_start) + INTERVAL 30 DAY;  -- Replay data expires faster
 
+-- ===========================================
+-- Replay Manifests Table
+-- Per-session index of replay chunks for playback
+-- ===========================================
+CREATE TABLE IF NOT EXISTS gosight.replay_manifests
+(
+    project_id      String,
+    session_id      String,
+
+    chunk_indices   Array(UInt32),  -- received, ascending
+    missing_chunks  Array(UInt32),  -- gaps below the highest index received
+    snapshot_chunks Array(UInt32),  -- chunks with a full snapshot
+
+    timestamp_start DateTime64(3),
+    timestamp_end   DateTime64(3),
+    duration_ms     UInt64,
+
+    has_full_snapshot UInt8,
+    finalized       UInt8,  -- session ended, no more chunks expected
+
+    updated_at      DateTime64(3)
+)
+ENGINE = ReplacingMergeTree(updated_at)
+ORDER BY (project_id, session_id)
+TTL toDateTime(timestamp_start) + INTERVAL 30 DAY;  -- Same as replay_chunks
+
 -- ===========================================
 -- Errors Table
 -- JavaScript errors
@@ -215,6 +344,10 @@ CREATE TABLE IF NOT EXISTS gosight.errors
     browser         LowCardinality(String),
     os              LowCardinality(String),
 
+    -- Grouping / sampling (occurrences are sum(occurrence_count))
+    fingerprint     String,
+    occurrence_count UInt32 DEFAULT 1,
+
     created_at      DateTime DEFAULT now()
 )
 ENGINE = MergeTree()
@@ -222,6 +355,35 @@ PARTITION BY toYYYYMM(timestamp)
 ORDER BY (project_id, message, timestamp)
 TTL toDateTime(timestamp) + INTERVAL 90 DAY;
 
+-- ===========================================
+-- Long Tasks Table
+-- Tasks blocking the main thread for over 50ms
+-- ===========================================
+CREATE TABLE IF NOT EXISTS gosight.long_tasks
+(
+    project_id      String,
+    session_id      String,
+
+    timestamp       DateTime64(3),
+
+    -- Task
+    duration_ms     Float64,
+    attribution     String,  -- culprit container (iframe, embed, ...); empty for the page itself
+
+    -- Page context
+    page_url        String,
+    page_path       String,
+
+    -- Device
+    device_type     LowCardinality(String),
+
+    created_at      DateTime DEFAULT now()
+)
+ENGINE = MergeTree()
+PARTITION BY toYYYYMM(timestamp)
+ORDER BY (project_id, page_path, timestamp)
+TTL toDateTime(timestamp) + INTERVAL 90 DAY;
+
 -- ===========================================
 -- Insights Table
 -- Detected UX issues (rage clicks, dead clicks, etc.)
@@ -232,7 +394,7 @@ CREATE TABLE IF NOT EXISTS gosight.insights
     project_id      String,
     session_id      String,
 
-    insight_type    LowCardinality(String),  -- rage_click, dead_click, error_click, thrashed_cursor, u_turn, slow_page
+    insight_type    LowCardinality(String),  -- rage_click, dead_click, error_click, thrashed_cursor, rage_scroll, u_turn, slow_page, slow_page_caused_abandonment, form_retry, reload_loop, error_page, long_task, media_abandonment, pogostick, funnel_abandonment, funnel_drop, missing_vitals, insight_rate_capped, or a project's custom insight rule type
 
     timestamp       DateTime64(3),
 
@@ -246,10 +408,20 @@ CREATE TABLE IF NOT EXISTS gosight.insights
 
     -- Target element
     target_selector String,
+    normalized_selector String,  -- stable selector for grouping (hashed classes, :nth-child stripped)
 
     -- Details (JSON)
     details         String,
 
+    -- Common details in typed columns, set for the insight types that have them
+    click_count       Nullable(UInt32),   -- rage_click
+    load_time_ms      Nullable(Float64),  -- slow_page
+    direction_changes Nullable(UInt32),   -- thrashed_cursor
+    time_away_ms      Nullable(Int64),    -- u_turn
+
+    -- How strongly the detector's signal matched, 0 to 1
+    confidence      Float32 DEFAULT 1,
+
     -- Related event IDs
     related_event_ids Array(String),
 
@@ -259,3 +431,32 @@ ENGINE = MergeTree()
 PARTITION BY toYYYYMM(timestamp)
 ORDER BY (project_id, insight_type, timestamp)
 TTL toDateTime(timestamp) + INTERVAL 90 DAY;
+
+-- ===========================================
+-- Migrations for existing installs
+-- ===========================================
+ALTER TABLE gosight.page_views ADD COLUMN IF NOT EXISTS raw_time_on_page_ms UInt64 AFTER time_on_page_ms;
+ALTER TABLE gosight.events ADD COLUMN IF NOT EXISTS client_ip String AFTER payload;
+ALTER TABLE gosight.events ADD COLUMN IF NOT EXISTS user_agent String AFTER client_ip;
+ALTER TABLE gosight.insights ADD COLUMN IF NOT EXISTS normalized_selector String AFTER target_selector;
+ALTER TABLE gosight.events ADD COLUMN IF NOT EXISTS secondary_timestamp DateTime64(3) AFTER timestamp;
+ALTER TABLE gosight.events ADD COLUMN IF NOT EXISTS payload_compressed String CODEC(NONE) AFTER payload;
+ALTER TABLE gosight.insights ADD COLUMN IF NOT EXISTS click_count Nullable(UInt32) AFTER details;
+ALTER TABLE gosight.insights ADD COLUMN IF NOT EXISTS load_time_ms Nullable(Float64) AFTER click_count;
+ALTER TABLE gosight.insights ADD COLUMN IF NOT EXISTS direction_changes Nullable(UInt32) AFTER load_time_ms;
+ALTER TABLE gosight.insights ADD COLUMN IF NOT EXISTS time_away_ms Nullable(Int64) AFTER direction_changes;
+ALTER TABLE gosight.events ADD COLUMN IF NOT EXISTS processing_lag_ms Int64 AFTER user_agent;
+ALTER TABLE gosight.errors ADD COLUMN IF NOT EXISTS fingerprint String AFTER os;
+ALTER TABLE gosight.errors ADD COLUMN IF NOT EXISTS occurrence_count UInt32 DEFAULT 1 AFTER fingerprint;
+ALTER TABLE gosight.events ADD COLUMN IF NOT EXISTS is_synthetic UInt8 DEFAULT 0 AFTER processing_lag_ms;
+ALTER TABLE gosight.sessions ADD COLUMN IF NOT EXISTS is_synthetic UInt8 DEFAULT 0 AFTER is_bounced;
+ALTER TABLE gosight.events ADD COLUMN IF NOT EXISTS device_pixel_ratio Float32 AFTER viewport_height;
+ALTER TABLE gosight.events ADD COLUMN IF NOT EXISTS connection_type LowCardinality(String) AFTER device_pixel_ratio;
+ALTER TABLE gosight.web_vitals ADD COLUMN IF NOT EXISTS device_pixel_ratio Float32 AFTER country;
+ALTER TABLE gosight.web_vitals ADD COLUMN IF NOT EXISTS connection_type LowCardinality(String) AFTER device_pixel_ratio;
+ALTER TABLE gosight.sessions ADD COLUMN IF NOT EXISTS analytics_denied UInt8 DEFAULT 0 AFTER is_synthetic;
+ALTER TABLE gosight.events ADD COLUMN IF NOT EXISTS anonymous_id String AFTER user_id;
+ALTER TABLE gosight.sessions ADD COLUMN IF NOT EXISTS user_agent String AFTER analytics_denied;
+ALTER TABLE gosight.insights ADD COLUMN IF NOT EXISTS confidence Float32 DEFAULT 1 AFTER time_away_ms;
+ALTER TABLE gosight.web_vitals_rollup ADD COLUMN IF NOT EXISTS rolled_at DateTime AFTER samples;
+ALTER TABLE gosight.events ADD COLUMN IF NOT EXISTS custom_event_name LowCardinality(String) AFTER payload_compressed;
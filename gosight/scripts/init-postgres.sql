@@ -73,6 +73,9 @@ CREATE TABLE IF NOT EXISTS api_keys (
     -- Permissions
     permissions     JSONB DEFAULT '["ingest"]',  -- ingest, read, admin
 
+    -- Test keys (QA, synthetic monitoring) mark all their events synthetic
+    is_test         BOOLEAN DEFAULT false,
+
     -- Rate limiting
     rate_limit      INTEGER DEFAULT 1000,  -- requests per minute
     request_count   BIGINT DEFAULT 0,      -- total requests made
@@ -90,6 +93,9 @@ CREATE TABLE IF NOT EXISTS api_keys (
 CREATE INDEX IF NOT EXISTS idx_api_keys_project ON api_keys(project_id);
 CREATE INDEX IF NOT EXISTS idx_api_keys_prefix ON api_keys(key_prefix);
 
+-- Existing installs
+ALTER TABLE api_keys ADD COLUMN IF NOT EXISTS is_test BOOLEAN DEFAULT false;
+
 -- ===========================================
 -- Team Members Table
 -- Project collaborators
@@ -188,6 +194,44 @@ CREATE TABLE IF NOT EXISTS refresh_tokens (
 -- Indexes
 CREATE INDEX IF NOT EXISTS idx_refresh_tokens_user ON refresh_tokens(user_id);
 
+-- ===========================================
+-- Project Settings Table
+-- Per-project overrides of the ingestor/processor config,
+-- cached by both; NULL / empty values leave the config in effect
+-- ===========================================
+CREATE TABLE IF NOT EXISTS project_settings (
+    project_id          UUID PRIMARY KEY REFERENCES projects(id) ON DELETE CASCADE,
+
+    -- Fraction of sessions whose replay is kept (0-1)
+    replay_sample_rate  DOUBLE PRECISION CHECK (replay_sample_rate BETWEEN 0 AND 1),
+
+    -- Insight types not stored or alerted (rage_click, dead_click, ...)
+    disabled_insights   TEXT[] NOT NULL DEFAULT '{}',
+
+    -- Page paths excluded from storage and insights: paths matching a denylist
+    -- pattern and, when the allowlist is not empty, paths matching none of it.
+    -- Globs (* within a segment, ** across segments) or regexes prefixed "re:"
+    path_denylist       TEXT[] NOT NULL DEFAULT '{}',
+    path_allowlist      TEXT[] NOT NULL DEFAULT '{}',
+
+    -- Days of analytics data kept; NULL keeps the ClickHouse table TTLs
+    -- (which also cap it). Enforced by the event processor's retention job
+    retention_days      INTEGER CHECK (retention_days > 0),
+
+    -- Custom insights emitted when a session has count events of a type on
+    -- matching pages within window_ms (see settings.InsightRule):
+    -- [{"type", "event_type", "event_name", "path", "count", "window_ms"}]
+    insight_rules       JSONB NOT NULL DEFAULT '[]',
+
+    updated_at          TIMESTAMP WITH TIME ZONE DEFAULT NOW()
+);
+
+-- Existing installs
+ALTER TABLE project_settings ADD COLUMN IF NOT EXISTS path_denylist TEXT[] NOT NULL DEFAULT '{}';
+ALTER TABLE project_settings ADD COLUMN IF NOT EXISTS path_allowlist TEXT[] NOT NULL DEFAULT '{}';
+ALTER TABLE project_settings ADD COLUMN IF NOT EXISTS retention_days INTEGER CHECK (retention_days > 0);
+ALTER TABLE project_settings ADD COLUMN IF NOT EXISTS insight_rules JSONB NOT NULL DEFAULT '[]';
+
 -- ===========================================
 -- Updated_at trigger function
 -- ===========================================
@@ -212,6 +256,28 @@ CREATE TRIGGER update_alerts_updated_at
     BEFORE UPDATE ON alerts
     FOR EACH ROW EXECUTE FUNCTION update_updated_at_column();
 
+CREATE TRIGGER update_project_settings_updated_at
+    BEFORE UPDATE ON project_settings
+    FOR EACH ROW EXECUTE FUNCTION update_updated_at_column();
+
+-- Services caching project settings LISTEN on project_settings and reload the
+-- changed project
+CREATE OR REPLACE FUNCTION notify_project_settings()
+RETURNS TRIGGER AS $$
+BEGIN
+    IF TG_OP = 'DELETE' THEN
+        PERFORM pg_notify('project_settings', OLD.project_id::text);
+    ELSE
+        PERFORM pg_notify('project_settings', NEW.project_id::text);
+    END IF;
+    RETURN NULL;
+END;
+$$ language 'plpgsql';
+
+CREATE TRIGGER notify_project_settings_changed
+    AFTER INSERT OR UPDATE OR DELETE ON project_settings
+    FOR EACH ROW EXECUTE FUNCTION notify_project_settings();
+
 -- ===========================================
 -- SEED DATA FOR DEVELOPMENT/TESTING
 -- ===========================================